@@ -0,0 +1,237 @@
+package main
+
+import (
+    "crypto/rand"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ---------- Two-phase confirmation ----------
+
+// 破坏性操作分两步：不带 confirm 时只返回影响范围和一次性 token，
+// 带上 token（参数必须完全一致）再调用一次才真正执行
+const confirmTTL = 5 * time.Minute
+
+type Impact struct {
+    Action string   `json:"action"`
+    Rows   int64    `json:"rows"`
+    Sample []string `json:"sample,omitempty"`
+    // dry run 时算出来、和 token 一起存下的值（如 purge 的截止时间），确认时原样交给 Execute，
+    // 而不是按确认那一刻重新算，保证执行的就是 dry run 看到的范围
+    Bound map[string]string `json:"bound,omitempty"`
+}
+
+type destructiveOp struct {
+    Name    string
+    Impact  func(r *http.Request) (*Impact, error)
+    Execute func(r *http.Request, bound map[string]string) (int64, error)
+}
+
+type pendingConfirm struct {
+    op       string
+    params   string
+    bound    map[string]string
+    expireAt time.Time
+}
+
+type confirmStore struct {
+    mu      sync.Mutex
+    pending map[string]pendingConfirm
+}
+
+var confirmations = &confirmStore{pending: map[string]pendingConfirm{}}
+
+func (s *confirmStore) issue(op, params string, bound map[string]string) (string, time.Time, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", time.Time{}, err
+    }
+    token := hex.EncodeToString(b)
+    exp := time.Now().Add(confirmTTL)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for k, v := range s.pending {
+        if time.Now().After(v.expireAt) {
+            delete(s.pending, k)
+        }
+    }
+    s.pending[token] = pendingConfirm{op: op, params: params, bound: bound, expireAt: exp}
+    return token, exp, nil
+}
+
+// token 只能用一次，无论成功与否都作废；返回 issue 时绑定的值
+func (s *confirmStore) consume(token, op, params string) (map[string]string, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    p, ok := s.pending[token]
+    if !ok {
+        return nil, false
+    }
+    delete(s.pending, token)
+    if p.op != op || p.params != params || !time.Now().Before(p.expireAt) {
+        return nil, false
+    }
+    return p.bound, true
+}
+
+// 去掉 confirm 后的规范化查询串，用于绑定 token 与参数
+func confirmParams(r *http.Request) string {
+    q := url.Values{}
+    for k, v := range r.URL.Query() {
+        if k == "confirm" {
+            continue
+        }
+        sorted := append([]string(nil), v...)
+        sort.Strings(sorted)
+        q[k] = sorted
+    }
+    return r.URL.Path + "?" + q.Encode()
+}
+
+func confirmedHandler(op destructiveOp) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        params := confirmParams(r)
+        token := r.URL.Query().Get("confirm")
+        if token == "" {
+            token = r.Header.Get("X-Confirm-Token")
+        }
+        w.Header().Set("Content-Type", "application/json")
+        if token == "" {
+            impact, err := op.Impact(r)
+            if err != nil {
+                http.Error(w, err.Error(), 400)
+                return
+            }
+            impact.Action = op.Name
+            tok, exp, err := confirmations.issue(op.Name, params, impact.Bound)
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            json.NewEncoder(w).Encode(map[string]any{
+                "dryRun":       true,
+                "impact":       impact,
+                "confirmToken": tok,
+                "expiresAt":    exp.Format(time.RFC3339),
+            })
+            return
+        }
+        bound, ok := confirmations.consume(token, op.Name, params)
+        if !ok {
+            http.Error(w, "invalid or expired confirmation token", 412)
+            return
+        }
+        n, err := op.Execute(r, bound)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        json.NewEncoder(w).Encode(map[string]any{
+            "dryRun":  false,
+            "action":  op.Name,
+            "deleted": n,
+        })
+    }
+}
+
+// ---------- Admin: purge ----------
+
+// 清理长时间未刷新的行（例如进程停机期间被删除的 Pod）
+// POST /admin/purge?resource=pods&olderThan=24h[&ns=default]
+// 截止时间只在 dry run 时按 olderThan 算一次，绑定在 token 上（Impact.Bound），确认时不再往后挪
+func purgeCutoff(r *http.Request) (string, error) {
+    d, err := time.ParseDuration(r.URL.Query().Get("olderThan"))
+    if err != nil || d <= 0 {
+        return "", fmt.Errorf("olderThan must be a positive duration")
+    }
+    return time.Now().Add(-d).Format(time.RFC3339), nil
+}
+
+func purgeFilter(r *http.Request, before string) (table, key, where string, args []any, err error) {
+    q := r.URL.Query()
+    switch q.Get("resource") {
+    case "pods":
+        table, key = "pods", "namespace||'/'||name"
+    case "nodes":
+        table, key = "nodes", "name"
+    default:
+        return "", "", "", nil, fmt.Errorf("unsupported resource %q", q.Get("resource"))
+    }
+    if before == "" {
+        return "", "", "", nil, fmt.Errorf("missing purge cutoff")
+    }
+    conds := []string{"updated_at < ?"}
+    args = []any{before}
+    if ns := q.Get("ns"); ns != "" {
+        if table != "pods" {
+            return "", "", "", nil, fmt.Errorf("ns only applies to pods")
+        }
+        conds = append(conds, "namespace = ?")
+        args = append(args, ns)
+    }
+    return table, key, strings.Join(conds, " AND "), args, nil
+}
+
+func purgeOp(db *sql.DB, hot *hotReadModel) destructiveOp {
+    return destructiveOp{
+        Name: "purge",
+        Impact: func(r *http.Request) (*Impact, error) {
+            before, err := purgeCutoff(r)
+            if err != nil {
+                return nil, err
+            }
+            table, key, where, args, err := purgeFilter(r, before)
+            if err != nil {
+                return nil, err
+            }
+            imp := &Impact{Bound: map[string]string{"before": before}}
+            if err := db.QueryRowContext(r.Context(), `SELECT count(*) FROM `+table+` WHERE `+where, args...).Scan(&imp.Rows); err != nil {
+                return nil, err
+            }
+            rows, err := db.QueryContext(r.Context(), `SELECT `+key+` FROM `+table+` WHERE `+where+` ORDER BY 1 LIMIT 20`, args...)
+            if err != nil {
+                return nil, err
+            }
+            defer rows.Close()
+            for rows.Next() {
+                var s string
+                if err := rows.Scan(&s); err != nil {
+                    return nil, err
+                }
+                imp.Sample = append(imp.Sample, s)
+            }
+            return imp, rows.Err()
+        },
+        Execute: func(r *http.Request, bound map[string]string) (int64, error) {
+            table, _, where, args, err := purgeFilter(r, bound["before"])
+            if err != nil {
+                return 0, err
+            }
+            var n int64
+            err = withChangeSource(db, "admin", func(tx *sql.Tx) error {
+                res, err := tx.Exec(`DELETE FROM `+table+` WHERE `+where, args...)
+                if err != nil {
+                    return err
+                }
+                n, err = res.RowsAffected()
+                return err
+            })
+            if err == nil && (table == "pods" || table == "nodes") {
+                hot.reloadAfter("purge")
+            }
+            return n, err
+        },
+    }
+}
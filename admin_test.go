@@ -0,0 +1,55 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+// 确认时用 dry run 那一刻的截止时间，dry run 之后才"过期"的行不删
+func TestPurgeUsesDryRunCutoff(t *testing.T) {
+    db := newTestDB(t)
+    h := confirmedHandler(purgeOp(db, nil))
+    insert := func(name, updatedAt string) {
+        t.Helper()
+        if _, err := db.Exec(`INSERT INTO pods(uid,name,namespace,updated_at) VALUES(?,?,'shop',?)`, "uid-"+name, name, updatedAt); err != nil {
+            t.Fatal(err)
+        }
+    }
+    insert("old", time.Now().Add(-2*time.Hour).Format(time.RFC3339))
+
+    const url = "/admin/purge?resource=pods&olderThan=1h"
+    rec := httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodPost, url, nil))
+    var dry struct {
+        Impact       Impact `json:"impact"`
+        ConfirmToken string `json:"confirmToken"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &dry); err != nil {
+        t.Fatalf("%d %s: %v", rec.Code, rec.Body, err)
+    }
+    before := dry.Impact.Bound["before"]
+    if dry.Impact.Rows != 1 || before == "" {
+        t.Fatalf("dry run = %s", rec.Body)
+    }
+    // 正好在截止时间上，确认时若重新按 now-1h 算就会被删
+    insert("edge", before)
+    time.Sleep(1100 * time.Millisecond)
+
+    rec = httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodPost, url+"&confirm="+dry.ConfirmToken, nil))
+    var done struct {
+        Deleted int64 `json:"deleted"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &done); err != nil {
+        t.Fatalf("%d %s: %v", rec.Code, rec.Body, err)
+    }
+    if done.Deleted != 1 {
+        t.Fatalf("deleted %d rows, want 1", done.Deleted)
+    }
+    if n, err := countRows(db, `SELECT count(*) FROM pods WHERE name='edge'`)(); err != nil || n != 1 {
+        t.Fatalf("edge pod rows = %d, %v", n, err)
+    }
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+)
+
+// ---------- Alert rules ----------
+
+// 配置里的规则定时对库存求值（alerts.rules），例如 node 的 ready=false 持续 5m、prod 里 phase=Failed 的 Pod，
+// 命中的对象通知到 Slack（incoming webhook）或通用 webhook。去重按对象：同一条规则对同一个对象只在开始命中时发一次
+// firing，不再命中时发一次 resolved，中间对象怎么更新都不重复发；状态存在 alerts 表里，重启后不会重发。
+// 通知经 exporter "alerts" 发送，重试、暂停和状态与其它 exporter 一致；发送失败的下一轮再发。
+const (
+    alertFiring   = "firing"
+    alertResolved = "resolved"
+)
+
+func initAlerts(db *sql.DB) error {
+    _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS alerts(
+    rule TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    namespace TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL DEFAULT '',
+    since TEXT NOT NULL,
+    fired_at TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY(rule, ref)
+);`)
+    return err
+}
+
+// where 里的一项：col=value 或 col!=value，按文本比较
+type alertCond struct {
+    col, value string
+    neg        bool
+}
+
+// where 写成 phase=Failed,namespace=prod，各项之间是 AND；字段是该 kind 的 history 列或主键 ref
+func parseAlertWhere(h historySource, where string) ([]alertCond, error) {
+    var out []alertCond
+    for _, part := range strings.Split(where, ",") {
+        part = strings.TrimSpace(part)
+        if part == "" {
+            continue
+        }
+        var c alertCond
+        col, value, ok := strings.Cut(part, "!=")
+        if ok {
+            c.neg = true
+        } else if col, value, ok = strings.Cut(part, "="); !ok {
+            return nil, fmt.Errorf("invalid condition %q, expected field=value or field!=value", part)
+        }
+        c.col, c.value = strings.TrimSpace(col), strings.TrimSpace(value)
+        if c.col == "ref" {
+            c.col = h.Key
+        } else if !slices.Contains(h.Columns, c.col) {
+            return nil, fmt.Errorf("%s has no field %q (one of ref, %s)", h.Kind, c.col, strings.Join(h.Columns, ", "))
+        }
+        out = append(out, c)
+    }
+    if len(out) == 0 {
+        return nil, errors.New("where is empty")
+    }
+    return out, nil
+}
+
+type alertRule struct {
+    AlertRule
+    src   historySource
+    conds []alertCond
+}
+
+func compileAlertRule(r AlertRule) (alertRule, error) {
+    h, ok := historySourceOf(r.Kind)
+    if !ok {
+        return alertRule{}, fmt.Errorf("unknown kind %q", r.Kind)
+    }
+    conds, err := parseAlertWhere(h, r.Where)
+    if err != nil {
+        return alertRule{}, err
+    }
+    return alertRule{AlertRule: r, src: h, conds: conds}, nil
+}
+
+type alertObject struct {
+    ref, namespace, name string
+}
+
+// 当前命中规则的对象
+func (r alertRule) matches(db *sql.DB) (map[string]alertObject, error) {
+    h := r.src
+    var conds []string
+    var args []any
+    for _, c := range r.conds {
+        op := "="
+        if c.neg {
+            op = "!="
+        }
+        conds = append(conds, fmt.Sprintf("coalesce(CAST(t.%s AS TEXT),'') %s ?", c.col, op))
+        args = append(args, c.value)
+    }
+    rows, err := db.Query(fmt.Sprintf(`SELECT t.%s,coalesce(%s,''),coalesce(%s,'') FROM %s t WHERE %s`,
+        h.Key, h.col(h.Namespace, "t"), h.col(h.Name, "t"), h.Table, strings.Join(conds, " AND ")), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string]alertObject{}
+    for rows.Next() {
+        var o alertObject
+        if err := rows.Scan(&o.ref, &o.namespace, &o.name); err != nil {
+            return nil, err
+        }
+        out[o.ref] = o
+    }
+    return out, rows.Err()
+}
+
+// webhook 目标收到的 JSON
+type AlertEvent struct {
+    Status    string `json:"status"`
+    Rule      string `json:"rule"`
+    Kind      string `json:"kind"`
+    Ref       string `json:"ref"`
+    Namespace string `json:"namespace,omitempty"`
+    Name      string `json:"name"`
+    Where     string `json:"where"`
+    Site      string `json:"site,omitempty"`
+    // 开始命中的时间
+    Since string `json:"since"`
+    Time  string `json:"time"`
+}
+
+func (e AlertEvent) text() string {
+    obj := e.Kind + " " + e.Name
+    if e.Namespace != "" {
+        obj = e.Kind + " " + e.Namespace + "/" + e.Name
+    }
+    if e.Site != "" {
+        obj += " @ " + e.Site
+    }
+    return fmt.Sprintf("[%s] %s: %s (%s, since %s)", strings.ToUpper(e.Status), e.Rule, obj, e.Where, e.Since)
+}
+
+type alertEngine struct {
+    db      *sql.DB
+    cfg     AlertsConfig
+    site    string
+    client  *http.Client
+    rules   []alertRule
+    targets map[string]AlertTarget
+}
+
+// cfg 已经过 validate，规则都能编译
+func newAlertEngine(db *sql.DB, cfg AlertsConfig, site string) *alertEngine {
+    e := &alertEngine{db: db, cfg: cfg, site: site, client: &http.Client{Timeout: 10 * time.Second}, targets: map[string]AlertTarget{}}
+    for _, r := range cfg.Rules {
+        rule, _ := compileAlertRule(r)
+        e.rules = append(e.rules, rule)
+    }
+    for _, t := range cfg.Targets {
+        e.targets[t.Name] = t
+    }
+    return e
+}
+
+func (e *alertEngine) send(t AlertTarget, ev AlertEvent) error {
+    var body []byte
+    if t.Type == "slack" {
+        body, _ = json.Marshal(map[string]string{"text": ev.text()})
+    } else {
+        body, _ = json.Marshal(ev)
+    }
+    return postWebhook(e.client, t.URL, t.Secret, body)
+}
+
+// 发给规则的全部目标；有一个失败就算失败，下一轮整体重发
+func (e *alertEngine) notify(h *exportHandle, r alertRule, ev AlertEvent) error {
+    var errs []error
+    for _, name := range r.Targets {
+        t := e.targets[name]
+        if err := h.do(func() error { return e.send(t, ev) }); err != nil {
+            errs = append(errs, fmt.Errorf("%s: %w", name, err))
+        }
+    }
+    return errors.Join(errs...)
+}
+
+type alertState struct {
+    obj     alertObject
+    since   time.Time
+    firedAt string
+}
+
+func (e *alertEngine) loadStates(rule string) (map[string]*alertState, error) {
+    rows, err := e.db.Query(`SELECT ref,namespace,name,since,fired_at FROM alerts WHERE rule=?`, rule)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string]*alertState{}
+    for rows.Next() {
+        var s alertState
+        var since string
+        if err := rows.Scan(&s.obj.ref, &s.obj.namespace, &s.obj.name, &since, &s.firedAt); err != nil {
+            return nil, err
+        }
+        s.since, _ = time.Parse(time.RFC3339, since)
+        out[s.obj.ref] = &s
+    }
+    return out, rows.Err()
+}
+
+// 一条规则求值一次：新命中的先记下 since，持续满 for 后发 firing；不再命中的发 resolved（没发过 firing 的直接删）
+func (e *alertEngine) evaluateRule(h *exportHandle, r alertRule, now time.Time) (sent int, err error) {
+    objs, err := r.matches(e.db)
+    if err != nil {
+        return 0, err
+    }
+    states, err := e.loadStates(r.Name)
+    if err != nil {
+        return 0, err
+    }
+    ts := now.UTC().Format(time.RFC3339)
+    for ref, o := range objs {
+        if _, ok := states[ref]; ok {
+            continue
+        }
+        if _, err := e.db.Exec(`INSERT INTO alerts(rule,ref,kind,namespace,name,since) VALUES(?,?,?,?,?,?)`,
+            r.Name, ref, r.Kind, o.namespace, o.name, ts); err != nil {
+            return sent, err
+        }
+        states[ref] = &alertState{obj: o, since: now.Truncate(time.Second)}
+    }
+    var errs []error
+    for ref, s := range states {
+        ev := AlertEvent{Rule: r.Name, Kind: r.Kind, Ref: ref, Namespace: s.obj.namespace, Name: s.obj.name,
+            Where: r.Where, Site: e.site, Since: s.since.UTC().Format(time.RFC3339), Time: ts}
+        if _, ok := objs[ref]; ok {
+            if s.firedAt != "" || now.Sub(s.since) < r.For.Duration {
+                continue
+            }
+            ev.Status = alertFiring
+            if err := e.notify(h, r, ev); err != nil {
+                errs = append(errs, err)
+                continue
+            }
+            sent++
+            if _, err := e.db.Exec(`UPDATE alerts SET fired_at=? WHERE rule=? AND ref=?`, ts, r.Name, ref); err != nil {
+                return sent, err
+            }
+            continue
+        }
+        if s.firedAt != "" {
+            ev.Status = alertResolved
+            if err := e.notify(h, r, ev); err != nil {
+                errs = append(errs, err)
+                continue
+            }
+            sent++
+        }
+        if _, err := e.db.Exec(`DELETE FROM alerts WHERE rule=? AND ref=?`, r.Name, ref); err != nil {
+            return sent, err
+        }
+    }
+    return sent, errors.Join(errs...)
+}
+
+func (e *alertEngine) evaluate(h *exportHandle) {
+    now := time.Now()
+    for _, r := range e.rules {
+        sent, err := e.evaluateRule(h, r, now)
+        if err != nil && !errors.Is(err, errExporterDisabled) {
+            log.Printf("[alerts] %s: %v", r.Name, err)
+        }
+        if sent > 0 {
+            log.Printf("[alerts] %s: sent %d notifications", r.Name, sent)
+        }
+    }
+}
+
+func (e *alertEngine) Name() string { return "alerts" }
+
+func (e *alertEngine) Run(h *exportHandle, stop <-chan struct{}) {
+    // 配置里已删掉的规则留下的状态
+    names := make([]string, 0, len(e.rules))
+    for _, r := range e.rules {
+        names = append(names, r.Name)
+    }
+    b, _ := json.Marshal(names)
+    if _, err := e.db.Exec(`DELETE FROM alerts WHERE rule NOT IN (SELECT value FROM json_each(?))`, string(b)); err != nil {
+        log.Printf("[alerts] %v", err)
+    }
+    t := time.NewTicker(e.cfg.Interval.Duration)
+    defer t.Stop()
+    for {
+        e.evaluate(h)
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+        }
+    }
+}
+
+type AlertRow struct {
+    Rule      string `json:"rule"`
+    Kind      string `json:"kind"`
+    Ref       string `json:"ref"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    Since     string `json:"since"`
+    // 还没满 for 的为 false
+    Firing  bool   `json:"firing"`
+    FiredAt string `json:"firedAt"`
+}
+
+// GET /cmdb/alerts?rule=&firing=true   当前命中的对象；集群级对象（node、host ...）对限定 namespace 的 key 不可见
+func alertsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        conds, args := []string{"1"}, []any{}
+        if v := q.Get("rule"); v != "" {
+            conds = append(conds, "rule=?")
+            args = append(args, v)
+        }
+        if q.Get("firing") == "true" {
+            conds = append(conds, "fired_at!=''")
+        }
+        where, args := scopeOf(r.Context()).where("namespace", strings.Join(conds, " AND "), args...)
+        rows, err := db.QueryContext(r.Context(), `SELECT rule,kind,ref,namespace,name,since,fired_at FROM alerts`+where+` ORDER BY since,rule,ref`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "alerts", AlertRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var a AlertRow
+            if err := rows.Scan(&a.Rule, &a.Kind, &a.Ref, &a.Namespace, &a.Name, &a.Since, &a.FiredAt); err != nil {
+                lw.Fail(err)
+                return
+            }
+            a.Firing = a.FiredAt != ""
+            if err := lw.Write(a); err != nil {
+                log.Printf("[http] write alerts: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Architectures ----------
+
+// amd64 → arm64 迁移要先知道三件事：每种架构有多少节点、跑的是什么容器运行时；哪些 workload 用 nodeSelector /
+// required nodeAffinity 钉在了某些架构上；镜像在哪些平台上实际跑过。节点的架构取 status.nodeInfo.architecture
+// （没有时用 kubernetes.io/arch 标签），和 operatingSystem、containerRuntimeVersion 一起存到 nodes 上。
+// Pod 上的 arch_pin 是 kubernetes.io/arch（及旧的 beta 标签）约束允许的架构，逗号分隔；没有约束时为空。
+// NotIn 按 Kubernetes 发布的架构（加上约束里提到的）取补集。
+// 镜像的平台按运行它的节点算：kubelet 从多架构镜像里拉的是节点平台的那份，跑起来的 Pod 就说明这个平台有镜像。
+// 还没调度或节点不在库里的 Pod 没有平台；不查 registry 的 manifest list，所以没跑过的平台不知道有没有。
+var archLabels = []string{corev1.LabelArchStable, "beta.kubernetes.io/arch"}
+
+var knownArchitectures = []string{"amd64", "arm", "arm64", "ppc64le", "s390x"}
+
+func initArchitectures(db *sql.DB) error {
+    for _, c := range []string{"architecture", "os", "container_runtime"} {
+        if err := addColumnIfMissing(db, "nodes", c, "TEXT"); err != nil {
+            return err
+        }
+    }
+    return addColumnIfMissing(db, "pods", "arch_pin", "TEXT")
+}
+
+func nodePlatformOf(n *corev1.Node) (arch, nodeOS, containerRuntime string) {
+    info := n.Status.NodeInfo
+    arch, nodeOS = info.Architecture, info.OperatingSystem
+    if arch == "" {
+        arch = n.Labels[corev1.LabelArchStable]
+    }
+    if nodeOS == "" {
+        nodeOS = n.Labels[corev1.LabelOSStable]
+    }
+    return arch, nodeOS, info.ContainerRuntimeVersion
+}
+
+// nodeSelector 和 required nodeAffinity 都要满足；nodeSelectorTerms 之间是 OR，有一个 term 不限架构就等于不限
+func podArchPin(spec corev1.PodSpec) string {
+    universe := slices.Clone(knownArchitectures)
+    var selector []string
+    for _, l := range archLabels {
+        if v := spec.NodeSelector[l]; v != "" {
+            selector = append(selector, v)
+            universe = append(universe, v)
+        }
+    }
+    var terms [][]corev1.NodeSelectorRequirement
+    if a := spec.Affinity; a != nil && a.NodeAffinity != nil && a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+        for _, t := range a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+            var reqs []corev1.NodeSelectorRequirement
+            for _, e := range t.MatchExpressions {
+                if slices.Contains(archLabels, e.Key) && (e.Operator == corev1.NodeSelectorOpIn || e.Operator == corev1.NodeSelectorOpNotIn) {
+                    reqs = append(reqs, e)
+                    universe = append(universe, e.Values...)
+                }
+            }
+            terms = append(terms, reqs)
+        }
+    }
+    pinned := len(selector) > 0
+    if len(terms) > 0 && !slices.ContainsFunc(terms, func(r []corev1.NodeSelectorRequirement) bool { return len(r) == 0 }) {
+        pinned = true
+    } else {
+        terms = nil
+    }
+    if !pinned {
+        return ""
+    }
+    sort.Strings(universe)
+    var allowed []string
+    for _, arch := range slices.Compact(universe) {
+        ok := !slices.ContainsFunc(selector, func(v string) bool { return v != arch })
+        if ok && terms != nil {
+            ok = slices.ContainsFunc(terms, func(reqs []corev1.NodeSelectorRequirement) bool {
+                for _, e := range reqs {
+                    if slices.Contains(e.Values, arch) != (e.Operator == corev1.NodeSelectorOpIn) {
+                        return false
+                    }
+                }
+                return true
+            })
+        }
+        if ok {
+            allowed = append(allowed, arch)
+        }
+    }
+    if len(allowed) == 0 {
+        // 互相矛盾的约束，调度不上
+        return "none"
+    }
+    return strings.Join(allowed, ",")
+}
+
+type ArchitectureStat struct {
+    Architecture string `json:"architecture"`
+    OS           string `json:"os"`
+    // containerRuntimeVersion，如 containerd://1.7.13
+    ContainerRuntime string `json:"containerRuntime"`
+    Nodes            int    `json:"nodes"`
+}
+
+type PinnedWorkload struct {
+    Namespace string `json:"namespace"`
+    Kind      string `json:"kind"`
+    Name      string `json:"name"`
+    Team      string `json:"team"`
+    Pods      int64  `json:"pods"`
+    // 允许的架构，Pod 之间不一致时用 | 隔开
+    ArchPin string `json:"archPin"`
+    // 现在运行所在节点的 os/arch
+    Platforms string `json:"platforms"`
+}
+
+type ArchitectureReport struct {
+    // 限定 namespace 的 key 为空
+    Nodes  []ArchitectureStat `json:"nodes"`
+    Pinned []PinnedWorkload   `json:"pinned"`
+}
+
+func computeArchitectures(q querier, scope nsScope, arch string) (*ArchitectureReport, error) {
+    rep := &ArchitectureReport{Nodes: []ArchitectureStat{}, Pinned: []PinnedWorkload{}}
+    if scope == nil {
+        rows, err := q.Query(`SELECT coalesce(architecture,''),coalesce(os,''),coalesce(container_runtime,''),count(*) FROM nodes GROUP BY 1,2,3 ORDER BY 1,2,3`)
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            var s ArchitectureStat
+            if err := rows.Scan(&s.Architecture, &s.OS, &s.ContainerRuntime, &s.Nodes); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            rep.Nodes = append(rep.Nodes, s)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+    workloads, err := computeWorkloads(q, "", scope)
+    if err != nil {
+        return nil, err
+    }
+    for _, w := range workloads {
+        if w.ArchPin == "" || arch != "" && pinAllows(w.ArchPin, arch) {
+            continue
+        }
+        rep.Pinned = append(rep.Pinned, PinnedWorkload{Namespace: w.Namespace, Kind: w.Kind, Name: w.Name, Team: w.Team, Pods: w.Pods,
+            ArchPin: w.ArchPin, Platforms: w.Platforms})
+    }
+    return rep, nil
+}
+
+// 有一个 Pod 的约束允许就算允许：滚动更新时新旧 Pod 的约束可以不同
+func pinAllows(pin, arch string) bool {
+    return slices.ContainsFunc(strings.Split(pin, "|"), func(p string) bool { return slices.Contains(strings.Split(p, ","), arch) })
+}
+
+// GET /cmdb/architectures?arch=arm64
+// arch= 时 pinned 只列不能调度到这个架构上的 workload，即迁移前要改约束的
+func architecturesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        var rep *ArchitectureReport
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            rep, err = computeArchitectures(dbFrom(ctx, db), scopeOf(r.Context()), r.URL.Query().Get("arch"))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, rep)
+    }
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+    "archive/tar"
+    "bufio"
+    "compress/gzip"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+)
+
+// ---------- Archive export / import ----------
+
+// lightcmdb export / lightcmdb import：整个库导成一个 tar.gz 再导回去，用于换机器、克隆环境，
+// 以及以后在不同存储后端之间迁移。格式和后端无关：先是 manifest.json（格式版本、各表的列和行数），
+// 然后每张表一个 tables/<表名>.ndjson，每行是按 columns 顺序排列的值数组。
+// 搜索索引（search_docs、search_fts*）是派生数据，不导出，导入后重建；change_context 是写入时的临时状态，也不导出。
+const (
+    archiveFormat  = "lightcmdb-archive"
+    archiveVersion = 1
+)
+
+type archiveManifest struct {
+    Format    string         `json:"format"`
+    Version   int            `json:"version"`
+    CreatedAt string         `json:"createdAt"`
+    Tables    []archiveTable `json:"tables"`
+}
+
+type archiveTable struct {
+    Name    string   `json:"name"`
+    Columns []string `json:"columns"`
+    Rows    int      `json:"rows"`
+}
+
+func archivedTables(q querier) ([]string, error) {
+    rows, err := q.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite%'
+ AND name NOT IN ('search_docs','change_context','db_encryption') AND name NOT LIKE 'search_fts%' ORDER BY name`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []string
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return nil, err
+        }
+        out = append(out, name)
+    }
+    return out, rows.Err()
+}
+
+func tableColumns(q querier, table string) ([]string, error) {
+    rows, err := q.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var cols []string
+    for rows.Next() {
+        var (
+            cid, notNull, pk int
+            name, typ        string
+            dflt             sql.NullString
+        )
+        if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+            return nil, err
+        }
+        cols = append(cols, name)
+    }
+    return cols, rows.Err()
+}
+
+// 一张表写到临时文件：tar 头里要先写大小
+func dumpTable(q querier, t *archiveTable) (*os.File, error) {
+    f, err := os.CreateTemp("", "lightcmdb-export-*.ndjson")
+    if err != nil {
+        return nil, err
+    }
+    os.Remove(f.Name())
+    rows, err := q.Query(`SELECT "` + strings.Join(t.Columns, `","`) + `" FROM ` + t.Name + ` ORDER BY rowid`)
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    defer rows.Close()
+    bw := bufio.NewWriter(f)
+    enc := json.NewEncoder(bw)
+    vals := make([]any, len(t.Columns))
+    ptrs := make([]any, len(vals))
+    for i := range vals {
+        ptrs[i] = &vals[i]
+    }
+    for rows.Next() {
+        if err := rows.Scan(ptrs...); err != nil {
+            f.Close()
+            return nil, err
+        }
+        for i, v := range vals {
+            if b, ok := v.([]byte); ok {
+                vals[i] = string(b)
+            }
+        }
+        if err := enc.Encode(vals); err != nil {
+            f.Close()
+            return nil, err
+        }
+        t.Rows++
+    }
+    if err := rows.Err(); err != nil {
+        f.Close()
+        return nil, err
+    }
+    if err := bw.Flush(); err != nil {
+        f.Close()
+        return nil, err
+    }
+    _, err = f.Seek(0, io.SeekStart)
+    return f, err
+}
+
+// 在只读事务里导出，所有表来自同一个快照
+func exportArchive(db *sql.DB, w io.Writer) (*archiveManifest, error) {
+    tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+    names, err := archivedTables(tx)
+    if err != nil {
+        return nil, err
+    }
+    m := &archiveManifest{Format: archiveFormat, Version: archiveVersion, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+    var files []*os.File
+    defer func() {
+        for _, f := range files {
+            f.Close()
+        }
+    }()
+    for _, name := range names {
+        t := archiveTable{Name: name}
+        if t.Columns, err = tableColumns(tx, name); err != nil {
+            return nil, err
+        }
+        f, err := dumpTable(tx, &t)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", name, err)
+        }
+        files = append(files, f)
+        m.Tables = append(m.Tables, t)
+    }
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+    mb, _ := json.MarshalIndent(m, "", "  ")
+    now := time.Now()
+    if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0o600, Size: int64(len(mb)), ModTime: now}); err != nil {
+        return nil, err
+    }
+    if _, err := tw.Write(mb); err != nil {
+        return nil, err
+    }
+    for i, f := range files {
+        st, err := f.Stat()
+        if err != nil {
+            return nil, err
+        }
+        if err := tw.WriteHeader(&tar.Header{Name: "tables/" + m.Tables[i].Name + ".ndjson", Mode: 0o600, Size: st.Size(), ModTime: now}); err != nil {
+            return nil, err
+        }
+        if _, err := io.Copy(tw, f); err != nil {
+            return nil, err
+        }
+    }
+    if err := tw.Close(); err != nil {
+        return nil, err
+    }
+    return m, gz.Close()
+}
+
+// JSON 数字按 SQLite 的整数 / 浮点区分，避免大整数经 float64 丢精度
+func archiveValue(v any) any {
+    n, ok := v.(json.Number)
+    if !ok {
+        return v
+    }
+    if i, err := n.Int64(); err == nil {
+        return i
+    }
+    f, _ := n.Float64()
+    return f
+}
+
+func loadTable(tx *sql.Tx, t archiveTable, r io.Reader) (int, error) {
+    stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s("%s") VALUES(%s)`, t.Name, strings.Join(t.Columns, `","`),
+        strings.TrimSuffix(strings.Repeat("?,", len(t.Columns)), ",")))
+    if err != nil {
+        return 0, err
+    }
+    defer stmt.Close()
+    dec := json.NewDecoder(r)
+    dec.UseNumber()
+    n := 0
+    for {
+        var vals []any
+        if err := dec.Decode(&vals); err == io.EOF {
+            return n, nil
+        } else if err != nil {
+            return n, fmt.Errorf("row %d: %w", n+1, err)
+        }
+        if len(vals) != len(t.Columns) {
+            return n, fmt.Errorf("row %d has %d values, want %d", n+1, len(vals), len(t.Columns))
+        }
+        for i := range vals {
+            vals[i] = archiveValue(vals[i])
+        }
+        if _, err := stmt.Exec(vals...); err != nil {
+            return n, fmt.Errorf("row %d: %w", n+1, err)
+        }
+        n++
+    }
+}
+
+// 目标库先按当前版本建好表；归档里缺的列（旧版本导出）用默认值，多出的列（新版本导出）报错。
+// 导入期间删掉所有触发器，否则每一行都会写一条 history、搜索文档等；导完原样重建。
+// replace 为 false 时要求归档里的表在目标库中都是空的，为 true 时先清空这些表。
+func importArchive(db *sql.DB, r io.Reader, replace bool) (*archiveManifest, error) {
+    gz, err := gzip.NewReader(r)
+    if err != nil {
+        return nil, err
+    }
+    tr := tar.NewReader(gz)
+    hdr, err := tr.Next()
+    if err != nil {
+        return nil, err
+    }
+    if hdr.Name != "manifest.json" {
+        return nil, fmt.Errorf("not a %s: first entry is %s", archiveFormat, hdr.Name)
+    }
+    m := &archiveManifest{}
+    if err := json.NewDecoder(tr).Decode(m); err != nil {
+        return nil, fmt.Errorf("manifest.json: %w", err)
+    }
+    if m.Format != archiveFormat {
+        return nil, fmt.Errorf("not a %s: format %q", archiveFormat, m.Format)
+    }
+    if m.Version > archiveVersion {
+        return nil, fmt.Errorf("archive version %d is newer than this binary supports (%d)", m.Version, archiveVersion)
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+    existing, err := archivedTables(tx)
+    if err != nil {
+        return nil, err
+    }
+    tables := map[string]archiveTable{}
+    for _, t := range m.Tables {
+        if !slices.Contains(existing, t.Name) {
+            return nil, fmt.Errorf("table %s does not exist here (federation.mode?)", t.Name)
+        }
+        cols, err := tableColumns(tx, t.Name)
+        if err != nil {
+            return nil, err
+        }
+        for _, c := range t.Columns {
+            if !slices.Contains(cols, c) {
+                return nil, fmt.Errorf("table %s has no column %s, upgrade this binary first", t.Name, c)
+            }
+        }
+        if !replace {
+            var n int
+            if err := tx.QueryRow(`SELECT count(*) FROM ` + t.Name).Scan(&n); err != nil {
+                return nil, err
+            }
+            if n > 0 {
+                return nil, fmt.Errorf("table %s already has %d rows, use -replace to overwrite", t.Name, n)
+            }
+        }
+        tables[t.Name] = t
+    }
+
+    triggers := map[string]string{}
+    rows, err := tx.Query(`SELECT name,sql FROM sqlite_master WHERE type='trigger'`)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var name, stmt string
+        if err := rows.Scan(&name, &stmt); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        triggers[name] = stmt
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    for name := range triggers {
+        if _, err := tx.Exec(`DROP TRIGGER "` + name + `"`); err != nil {
+            return nil, err
+        }
+    }
+    if replace {
+        for name := range tables {
+            if _, err := tx.Exec(`DELETE FROM ` + name); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    loaded := map[string]int{}
+    for {
+        hdr, err := tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+        name, ok := strings.CutPrefix(hdr.Name, "tables/")
+        name, ok2 := strings.CutSuffix(name, ".ndjson")
+        t, known := tables[name]
+        if !ok || !ok2 || !known {
+            return nil, fmt.Errorf("unexpected entry %s", hdr.Name)
+        }
+        n, err := loadTable(tx, t, tr)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", name, err)
+        }
+        loaded[name] = n
+    }
+    for _, t := range m.Tables {
+        if loaded[t.Name] != t.Rows {
+            return nil, fmt.Errorf("%s: loaded %d rows, manifest says %d", t.Name, loaded[t.Name], t.Rows)
+        }
+    }
+    for _, stmt := range triggers {
+        if _, err := tx.Exec(stmt); err != nil {
+            return nil, err
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    return m, rebuildSearchIndex(db)
+}
+
+// lightcmdb export [-o cmdb.tar.gz]，默认写到标准输出；库所在目录取配置的 storage.dataDir
+func runArchiveExport(args []string, stdout io.Writer) error {
+    fs := flag.NewFlagSet("export", flag.ContinueOnError)
+    out := fs.String("o", "-", "archive file, - for stdout")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    cfg, err := loadConfig()
+    if err != nil {
+        return err
+    }
+    // openDB 会新建空库，先确认库存在
+    if _, err := os.Stat(filepath.Join(cfg.Storage.DataDir, dbFile)); err != nil {
+        return err
+    }
+    restrictUmask()
+    db, err := openDB(cfg.Storage.DataDir)
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    w := stdout
+    var f *os.File
+    if *out != "-" {
+        if f, err = os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+            return err
+        }
+        w = f
+    }
+    m, err := exportArchive(db, w)
+    if f != nil {
+        if cerr := f.Close(); err == nil {
+            err = cerr
+        }
+    }
+    if err != nil {
+        return err
+    }
+    total := 0
+    for _, t := range m.Tables {
+        total += t.Rows
+    }
+    fmt.Fprintf(os.Stderr, "exported %d tables, %d rows\n", len(m.Tables), total)
+    return nil
+}
+
+// lightcmdb import [-replace] cmdb.tar.gz（- 为标准输入）；不要在服务运行时导入
+func runArchiveImport(args []string, stdin io.Reader) error {
+    fs := flag.NewFlagSet("import", flag.ContinueOnError)
+    replace := fs.Bool("replace", false, "clear the tables in the archive before loading")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if fs.NArg() != 1 {
+        return errors.New("usage: lightcmdb import [-replace] <archive.tar.gz | ->")
+    }
+    r := stdin
+    if name := fs.Arg(0); name != "-" {
+        f, err := os.Open(name)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        r = f
+    }
+    cfg, err := loadConfig()
+    if err != nil {
+        return err
+    }
+    restrictUmask()
+    if err := os.MkdirAll(cfg.Storage.DataDir, 0o700); err != nil {
+        return err
+    }
+    db, err := openMigratedDB(cfg)
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    m, err := importArchive(db, r, *replace)
+    if err != nil {
+        return err
+    }
+    total := 0
+    for _, t := range m.Tables {
+        total += t.Rows
+    }
+    fmt.Fprintf(os.Stderr, "imported %d tables, %d rows (archive from %s)\n", len(m.Tables), total, m.CreatedAt)
+    return nil
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/dynamic/dynamicinformer"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Argo CD applications ----------
+
+// 集群提供 argoproj.io/v1alpha1 的 Application 时也作为 CI 入库：Git 仓库、路径、目标 revision，以及 sync / health 状态。
+// 和 KubeVirt 一样用 dynamic informer，写库走 syncs 的队列；CRD 只在启动时探测一次。
+// Application 管的 workload 有两个来源：
+//   - tracking label：Argo CD 给它部署的对象打 instanceLabel（默认 app.kubernetes.io/instance）=<app>，
+//     Application 不在 Argo CD 自己的 namespace 时值为 <namespace>_<app>；
+//   - status.resources：Argo CD 自己列出的受管对象，按注解跟踪（argocd.argoproj.io/tracking-id）时只有这个。
+//
+// 两者合并后取库里有的 Deployment / Service，用在 /cmdb/apps 和拓扑（argoapp/<ns>/<name> owns ...）上。
+var (
+    argoGroupVersion = "argoproj.io/v1alpha1"
+    argoAppResource  = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+)
+
+func initArgoCD(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS argo_applications(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    project TEXT,
+    repo_url TEXT,
+    path TEXT,
+    chart TEXT,
+    target_revision TEXT,
+    sync_status TEXT,
+    synced_revision TEXT,
+    health_status TEXT,
+    dest_server TEXT,
+    dest_namespace TEXT,
+    -- status.resources 的摘要，只用来判断 update 是否需要写库
+    resources TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS argo_app_resources(
+    app_uid TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    status TEXT,
+    health TEXT,
+    PRIMARY KEY(app_uid, kind, namespace, name)
+);`,
+        `CREATE INDEX IF NOT EXISTS argo_app_resources_obj ON argo_app_resources(kind, namespace, name)`,
+        `DROP TRIGGER IF EXISTS argo_applications_ad`,
+        `CREATE TRIGGER argo_applications_ad AFTER DELETE ON argo_applications BEGIN
+ DELETE FROM argo_app_resources WHERE app_uid=old.uid; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type argoAppFields struct {
+    project, repoURL, path, chart, targetRevision string
+    syncStatus, syncedRevision, health            string
+    destServer, destNamespace                     string
+}
+
+// 多 source 的 Application（spec.sources）取第一个；revision 同理
+func argoAppFieldsOf(u *unstructured.Unstructured) argoAppFields {
+    var f argoAppFields
+    o := u.Object
+    f.project, _, _ = unstructured.NestedString(o, "spec", "project")
+    src, _, _ := unstructured.NestedMap(o, "spec", "source")
+    if src == nil {
+        if srcs, _, _ := unstructured.NestedSlice(o, "spec", "sources"); len(srcs) > 0 {
+            src, _ = srcs[0].(map[string]interface{})
+        }
+    }
+    f.repoURL, _, _ = unstructured.NestedString(src, "repoURL")
+    f.path, _, _ = unstructured.NestedString(src, "path")
+    f.chart, _, _ = unstructured.NestedString(src, "chart")
+    f.targetRevision, _, _ = unstructured.NestedString(src, "targetRevision")
+    if f.targetRevision == "" {
+        f.targetRevision = "HEAD"
+    }
+    f.syncStatus, _, _ = unstructured.NestedString(o, "status", "sync", "status")
+    f.syncedRevision, _, _ = unstructured.NestedString(o, "status", "sync", "revision")
+    if f.syncedRevision == "" {
+        if revs, _, _ := unstructured.NestedStringSlice(o, "status", "sync", "revisions"); len(revs) > 0 {
+            f.syncedRevision = revs[0]
+        }
+    }
+    f.health, _, _ = unstructured.NestedString(o, "status", "health", "status")
+    f.destServer, _, _ = unstructured.NestedString(o, "spec", "destination", "server")
+    if f.destServer == "" {
+        f.destServer, _, _ = unstructured.NestedString(o, "spec", "destination", "name")
+    }
+    f.destNamespace, _, _ = unstructured.NestedString(o, "spec", "destination", "namespace")
+    return f
+}
+
+type argoManagedRes struct {
+    kind, namespace, name, status, health string
+}
+
+// status.resources 里只记 core / apps 组的对象
+func argoAppResources(u *unstructured.Unstructured) []argoManagedRes {
+    items, _, _ := unstructured.NestedSlice(u.Object, "status", "resources")
+    var out []argoManagedRes
+    for _, it := range items {
+        m, ok := it.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        group, _ := m["group"].(string)
+        if group != "" && group != "apps" {
+            continue
+        }
+        r := argoManagedRes{}
+        r.kind, _ = m["kind"].(string)
+        r.namespace, _ = m["namespace"].(string)
+        r.name, _ = m["name"].(string)
+        r.status, _ = m["status"].(string)
+        r.health, _, _ = unstructured.NestedString(m, "health", "status")
+        if r.kind != "" && r.name != "" {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// kind/ns/name=status/health，逗号分隔
+func argoResourcesSummary(list []argoManagedRes) string {
+    parts := make([]string, len(list))
+    for i, r := range list {
+        parts[i] = r.kind + "/" + r.namespace + "/" + r.name + "=" + r.status + "/" + r.health
+    }
+    return strings.Join(parts, ",")
+}
+
+func upsertArgoApp(db querier, u *unstructured.Unstructured) error {
+    if u == nil {
+        return errors.New("nil application")
+    }
+    f := argoAppFieldsOf(u)
+    managed := argoAppResources(u)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO argo_applications(uid,name,namespace,project,repo_url,path,chart,target_revision,sync_status,synced_revision,health_status,
+ dest_server,dest_namespace,resources,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ project=excluded.project,
+ repo_url=excluded.repo_url,
+ path=excluded.path,
+ chart=excluded.chart,
+ target_revision=excluded.target_revision,
+ sync_status=excluded.sync_status,
+ synced_revision=excluded.synced_revision,
+ health_status=excluded.health_status,
+ dest_server=excluded.dest_server,
+ dest_namespace=excluded.dest_namespace,
+ resources=excluded.resources,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("argo_applications"), string(u.GetUID()), u.GetName(), u.GetNamespace(), f.project, f.repoURL, f.path, f.chart,
+        f.targetRevision, f.syncStatus, f.syncedRevision, f.health, f.destServer, f.destNamespace, argoResourcesSummary(managed), u.GetResourceVersion(), now, now)
+    ok, err := upsertApplied(res, err, "argo_applications", u.GetNamespace()+"/"+u.GetName(), u.GetResourceVersion())
+    if !ok {
+        return err
+    }
+    uid := string(u.GetUID())
+    if _, err := db.Exec(`DELETE FROM argo_app_resources WHERE app_uid=?`, uid); err != nil {
+        return err
+    }
+    for _, r := range managed {
+        ns := r.namespace
+        if ns == "" {
+            ns = f.destNamespace
+        }
+        if _, err := db.Exec(`INSERT OR IGNORE INTO argo_app_resources(app_uid,kind,namespace,name,status,health) VALUES(?,?,?,?,?,?)`,
+            uid, r.kind, ns, r.name, r.status, r.health); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func deleteArgoApp(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM argo_applications WHERE uid=?`, uid)
+    return err
+}
+
+// 只给队列用（project 判断 update 是否需要写库）；/admin/diff 和对账不覆盖
+var argoKinds = []syncDiffKind{
+    {Name: "applications", Table: "argo_applications", Key: "uid", NS: "namespace",
+        Cols: []string{"project", "repo_url", "path", "chart", "target_revision", "sync_status", "synced_revision", "health_status",
+            "dest_server", "dest_namespace", "resources"},
+        project: func(o runtime.Object) (string, []string) {
+            u := o.(*unstructured.Unstructured)
+            f := argoAppFieldsOf(u)
+            return string(u.GetUID()), []string{f.project, f.repoURL, f.path, f.chart, f.targetRevision, f.syncStatus, f.syncedRevision,
+                f.health, f.destServer, f.destNamespace, argoResourcesSummary(argoAppResources(u))}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertArgoApp(q, o.(*unstructured.Unstructured)) },
+        remove: deleteArgoApp},
+}
+
+func argoCDServed(client kubernetes.Interface) (bool, error) {
+    list, err := client.Discovery().ServerResourcesForGroupVersion(argoGroupVersion)
+    if apierrors.IsNotFound(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    for _, r := range list.APIResources {
+        if r.Name == argoAppResource.Resource {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// 必须在 syncs.run 之前调用。返回的 factory 由调用方 Start；不等它同步，缺 argoproj.io 的权限时只会打日志
+func watchArgoCD(client kubernetes.Interface, dyn dynamic.Interface, transform cache.TransformFunc, syncs *syncQueue, caches *cacheMeter) dynamicinformer.DynamicSharedInformerFactory {
+    ok, err := argoCDServed(client)
+    if err != nil {
+        log.Printf("[argocd] discovery: %v, Applications are not tracked", err)
+        return nil
+    }
+    if !ok {
+        return nil
+    }
+    factory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, 0)
+    inf := factory.ForResource(argoAppResource).Informer()
+    inf.SetTransform(transform)
+    syncs.addKind(argoKinds[0], inf)
+    caches.add(argoKinds[0].Name, inf)
+    log.Printf("[argocd] %s found, tracking Applications", argoGroupVersion)
+    return factory
+}
+
+// ---------- HTTP ----------
+
+type AppRow struct {
+    UID            string `json:"uid"`
+    Namespace      string `json:"namespace"`
+    Name           string `json:"name"`
+    Project        string `json:"project"`
+    RepoURL        string `json:"repoURL"`
+    Path           string `json:"path"`
+    Chart          string `json:"chart"`
+    TargetRevision string `json:"targetRevision"`
+    // Synced / OutOfSync / Unknown
+    SyncStatus     string `json:"syncStatus"`
+    SyncedRevision string `json:"syncedRevision"`
+    // Healthy / Progressing / Degraded / Suspended / Missing / Unknown
+    HealthStatus  string `json:"healthStatus"`
+    DestServer    string `json:"destServer"`
+    DestNamespace string `json:"destNamespace"`
+    // 库里能对上的 Deployment / Service 数
+    Workloads int    `json:"workloads"`
+    UpdatedAt string `json:"updatedAt"`
+}
+
+type AppWorkload struct {
+    Kind      string `json:"kind"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    CI        string `json:"ci"`
+    // label（tracking label）和 / 或 status（status.resources）
+    Via string `json:"via"`
+    // status.resources 里的 sync / health 状态，只按 label 对上时为空
+    SyncStatus   string `json:"syncStatus,omitempty"`
+    HealthStatus string `json:"healthStatus,omitempty"`
+}
+
+type App struct {
+    AppRow
+    WorkloadList []AppWorkload `json:"workloadList"`
+}
+
+// tracking label 的值 -> 带这个 label 的 Deployment / Service
+type argoLabelIndex map[string][]AppWorkload
+
+func loadArgoLabelIndex(ctx context.Context, db *sql.DB, label string) (argoLabelIndex, error) {
+    // LIKE 只粗筛，再按解析出的 label 精确比较
+    rows, err := dbFrom(ctx, db).Query(`SELECT 'Deployment',namespace,name,labels FROM deployments WHERE labels LIKE ?
+UNION ALL SELECT 'Service',namespace,name,labels FROM services WHERE labels LIKE ?`, "%"+label+"=%", "%"+label+"=%")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    idx := argoLabelIndex{}
+    for rows.Next() {
+        var w AppWorkload
+        var flat string
+        if err := rows.Scan(&w.Kind, &w.Namespace, &w.Name, &flat); err != nil {
+            return nil, err
+        }
+        if v, ok := parseLabels(flat)[label]; ok {
+            w.Via = "label"
+            idx[v] = append(idx[v], w)
+        }
+    }
+    return idx, rows.Err()
+}
+
+// 一个 Application 管的 Deployment / Service：tracking label 和 status.resources 的并集，只取库里有的
+func argoAppWorkloads(ctx context.Context, db *sql.DB, idx argoLabelIndex, app AppRow) ([]AppWorkload, error) {
+    rows, err := dbFrom(ctx, db).Query(`SELECT x.kind,x.namespace,x.name,coalesce(x.status,''),coalesce(x.health,'') FROM argo_app_resources x
+WHERE x.app_uid=? AND (x.kind='Deployment' AND EXISTS(SELECT 1 FROM deployments d WHERE d.namespace=x.namespace AND d.name=x.name)
+ OR x.kind='Service' AND EXISTS(SELECT 1 FROM services s WHERE s.namespace=x.namespace AND s.name=x.name))`, app.UID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := append(append([]AppWorkload{}, idx[app.Name]...), idx[app.Namespace+"_"+app.Name]...)
+    for rows.Next() {
+        w := AppWorkload{Via: "status"}
+        if err := rows.Scan(&w.Kind, &w.Namespace, &w.Name, &w.SyncStatus, &w.HealthStatus); err != nil {
+            return nil, err
+        }
+        out = append(out, w)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    merged := []AppWorkload{}
+    byKey := map[[3]string]int{}
+    for _, w := range out {
+        key := [3]string{w.Kind, w.Namespace, w.Name}
+        if i, ok := byKey[key]; ok {
+            m := &merged[i]
+            if !strings.Contains(m.Via, w.Via) {
+                m.Via += "," + w.Via
+            }
+            m.SyncStatus, m.HealthStatus = w.SyncStatus, w.HealthStatus
+            continue
+        }
+        w.CI = topoID(strings.ToLower(w.Kind), w.Namespace, w.Name)
+        byKey[key] = len(merged)
+        merged = append(merged, w)
+    }
+    sort.Slice(merged, func(i, j int) bool { return merged[i].CI < merged[j].CI })
+    return merged, nil
+}
+
+const appSelect = `SELECT uid,namespace,name,coalesce(project,''),coalesce(repo_url,''),coalesce(path,''),coalesce(chart,''),
+ coalesce(target_revision,''),coalesce(sync_status,''),coalesce(synced_revision,''),coalesce(health_status,''),coalesce(dest_server,''),
+ coalesce(dest_namespace,''),updated_at FROM argo_applications`
+
+func scanApps(rows *sql.Rows) ([]AppRow, error) {
+    defer rows.Close()
+    var out []AppRow
+    for rows.Next() {
+        var a AppRow
+        if err := rows.Scan(&a.UID, &a.Namespace, &a.Name, &a.Project, &a.RepoURL, &a.Path, &a.Chart, &a.TargetRevision, &a.SyncStatus,
+            &a.SyncedRevision, &a.HealthStatus, &a.DestServer, &a.DestNamespace, &a.UpdatedAt); err != nil {
+            return nil, err
+        }
+        out = append(out, a)
+    }
+    return out, rows.Err()
+}
+
+// GET /cmdb/apps?ns=&project=&sync=OutOfSync&health=Degraded
+// GET /cmdb/apps/{namespace}/{name}            带管理的 workload
+func appsAPI(db *sql.DB, label string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        ctx := r.Context()
+        if rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/apps"), "/"); rest != "" {
+            ns, name, ok := strings.Cut(rest, "/")
+            if !ok || ns == "" || name == "" {
+                http.Error(w, "path must be /cmdb/apps/{namespace}/{name}", 400)
+                return
+            }
+            getApp(db, label, w, r, ns, name)
+            return
+        }
+        q := r.URL.Query()
+        var conds []string
+        var condArgs []any
+        for _, f := range []struct{ param, col string }{{"ns", "namespace"}, {"project", "project"}, {"sync", "sync_status"}, {"health", "health_status"}} {
+            if v := q.Get(f.param); v != "" {
+                conds = append(conds, f.col+"=?")
+                condArgs = append(condArgs, v)
+            }
+        }
+        where, args := scopeOf(ctx).where("namespace", strings.Join(conds, " AND "), condArgs...)
+        rows, err := db.QueryContext(ctx, appSelect+where+` ORDER BY namespace,name`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        // 单连接：先读完再查 workload
+        list, err := scanApps(rows)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        idx, err := loadArgoLabelIndex(ctx, db, label)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        for i := range list {
+            wl, err := argoAppWorkloads(ctx, db, idx, list[i])
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            list[i].Workloads = len(wl)
+        }
+        lw, err := newListWriter(w, r, "apps", AppRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, a := range list {
+            if err := lw.Write(a); err != nil {
+                log.Printf("[http] write apps: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
+
+func loadApp(ctx context.Context, db *sql.DB, ns, name string) (*AppRow, error) {
+    where, args := scopeOf(ctx).where("namespace", "namespace=? AND name=?", ns, name)
+    rows, err := dbFrom(ctx, db).Query(appSelect+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    list, err := scanApps(rows)
+    if err != nil || len(list) == 0 {
+        return nil, err
+    }
+    return &list[0], nil
+}
+
+func getApp(db *sql.DB, label string, w http.ResponseWriter, r *http.Request, ns, name string) {
+    a, err := loadApp(r.Context(), db, ns, name)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if a == nil {
+        http.Error(w, "not found", 404)
+        return
+    }
+    app := App{AppRow: *a}
+    idx, err := loadArgoLabelIndex(r.Context(), db, label)
+    if err == nil {
+        app.WorkloadList, err = argoAppWorkloads(r.Context(), db, idx, *a)
+    }
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    app.Workloads = len(app.WorkloadList)
+    writeJSON(w, r, app)
+}
+
+// 拓扑里的 instanceLabel；启动时由 main 按配置设置
+var argoInstanceLabel = "app.kubernetes.io/instance"
+
+// 拓扑：argoapp -> 它管的 Deployment / Service（owns）；反过来从这些对象找到 Application
+func argoAppLinks(ctx context.Context, db *sql.DB, o topoObj) ([]topoLink, error) {
+    if o.node.Kind != "argoapp" {
+        return argoAppsOf(ctx, db, o)
+    }
+    a, err := loadApp(ctx, db, o.node.Namespace, o.node.Name)
+    if err != nil || a == nil {
+        return nil, err
+    }
+    idx, err := loadArgoLabelIndex(ctx, db, argoInstanceLabel)
+    if err != nil {
+        return nil, err
+    }
+    wl, err := argoAppWorkloads(ctx, db, idx, *a)
+    if err != nil {
+        return nil, err
+    }
+    var out []topoLink
+    for _, x := range wl {
+        obj, err := loadTopoRoot(ctx, db, x.CI)
+        if errors.Is(err, errTopoRootNotFound) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, topoLink{obj: *obj, edge: TopoEdge{Source: o.node.ID, Target: obj.node.ID, Type: "owns"}})
+    }
+    return out, nil
+}
+
+// 从 Deployment / Service 反查：label 的值给出 app 名（可能带 <namespace>_ 前缀），status.resources 给出 uid
+func argoAppsOf(ctx context.Context, db *sql.DB, o topoObj) ([]topoLink, error) {
+    kind := map[string]string{"deployment": "Deployment", "service": "Service"}[o.node.Kind]
+    var apps []AppRow
+    if v := parseLabels(gqlStr(o.row["labels"]))[argoInstanceLabel]; v != "" {
+        ns, name, ok := strings.Cut(v, "_")
+        cond, args := "name=?", []any{v}
+        if ok {
+            cond, args = "name=? OR (namespace=? AND name=?)", []any{v, ns, name}
+        }
+        rows, err := dbFrom(ctx, db).Query(appSelect+` WHERE `+cond, args...)
+        if err != nil {
+            return nil, err
+        }
+        if apps, err = scanApps(rows); err != nil {
+            return nil, err
+        }
+    }
+    rows, err := dbFrom(ctx, db).Query(appSelect+` WHERE uid IN (SELECT app_uid FROM argo_app_resources WHERE kind=? AND namespace=? AND name=?)`,
+        kind, o.node.Namespace, o.node.Name)
+    if err != nil {
+        return nil, err
+    }
+    more, err := scanApps(rows)
+    if err != nil {
+        return nil, err
+    }
+    var out []topoLink
+    seen := map[string]bool{}
+    for _, a := range append(apps, more...) {
+        if seen[a.UID] || !scopeOf(ctx).allows(a.Namespace) {
+            continue
+        }
+        seen[a.UID] = true
+        app := topoObj{node: TopoNode{ID: topoID("argoapp", a.Namespace, a.Name), Kind: "argoapp", Name: a.Name, Namespace: a.Namespace}}
+        out = append(out, topoLink{obj: app, edge: TopoEdge{Source: app.node.ID, Target: o.node.ID, Type: "owns"}})
+    }
+    return out, nil
+}
+
+func loadTopoArgoApp(ctx context.Context, db *sql.DB, ns, name string) (*topoObj, error) {
+    a, err := loadApp(ctx, db, ns, name)
+    if err != nil {
+        return nil, err
+    }
+    if a == nil {
+        return nil, errTopoRootNotFound
+    }
+    return &topoObj{node: TopoNode{ID: topoID("argoapp", ns, name), Kind: "argoapp", Name: name, Namespace: ns}}, nil
+}
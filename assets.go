@@ -0,0 +1,399 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    cmdbclient "lightcmdb-week3/client"
+)
+
+// ---------- Manual assets ----------
+
+// 无法自动发现的 CI（交换机、设备、license 等），由人维护。(type,name) 唯一；
+// 不属于任何 namespace，和 node 一样对有 namespace 范围的凭据不可见，写操作只允许不限范围的 key。
+func initAssets(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS assets(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL,
+    name TEXT NOT NULL,
+    site TEXT NOT NULL DEFAULT '',
+    owner TEXT NOT NULL DEFAULT '',
+    labels TEXT NOT NULL DEFAULT '',
+    created_at TEXT,
+    updated_at TEXT,
+    UNIQUE(type, name)
+);`,
+        `CREATE INDEX IF NOT EXISTS assets_site ON assets(site)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    // 按类型各不相同的属性（型号、序列号、到期日……），JSON 对象
+    if err := addColumnIfMissing(db, "assets", "attributes", "TEXT NOT NULL DEFAULT ''"); err != nil {
+        return err
+    }
+    // 外部系统推送的 CI 记下系统名和最后一次上报时间，见 external.go
+    if err := addColumnIfMissing(db, "assets", "source", "TEXT NOT NULL DEFAULT 'manual'"); err != nil {
+        return err
+    }
+    return addColumnIfMissing(db, "assets", "reported_at", "TEXT")
+}
+
+type Asset struct {
+    ID         int64             `json:"id"`
+    Type       string            `json:"type"`
+    Name       string            `json:"name"`
+    Site       string            `json:"site"`
+    Owner      string            `json:"owner"`
+    Labels     map[string]string `json:"labels"`
+    Attributes map[string]string `json:"attributes,omitempty"`
+    UpdatedAt  string            `json:"updatedAt,omitempty"`
+    // 只读：manual 或推送它的外部系统，写入时忽略
+    Source     string `json:"source,omitempty"`
+    ReportedAt string `json:"reportedAt,omitempty"`
+}
+
+const assetSelect = `SELECT id,type,name,site,owner,labels,attributes,coalesce(updated_at,''),source,coalesce(reported_at,'') FROM assets`
+
+func scanAssets(rows *sql.Rows) ([]Asset, error) {
+    defer rows.Close()
+    var out []Asset
+    for rows.Next() {
+        var a Asset
+        var labels, attrs string
+        if err := rows.Scan(&a.ID, &a.Type, &a.Name, &a.Site, &a.Owner, &labels, &attrs, &a.UpdatedAt, &a.Source, &a.ReportedAt); err != nil {
+            return nil, err
+        }
+        a.Labels = parseLabels(labels)
+        a.Attributes = decodeAttributes(attrs)
+        out = append(out, a)
+    }
+    return out, rows.Err()
+}
+
+// 写操作要求不限范围的凭据；未启用认证时 principal 为空，放行
+func canWriteAssets(r *http.Request) bool {
+    p := principalFrom(r.Context())
+    return p == nil || p.Admin
+}
+
+// GET /cmdb/assets?type=&site=&owner=&source=&labelSelector=    列表
+// POST /cmdb/assets                       新建/覆盖一个或一组（按 type+name）
+// PATCH /cmdb/assets[?dryRun=true]        按条件批量改 owner/site/labels
+func assetsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            listAssets(db, w, r)
+        case http.MethodPost:
+            if !canWriteAssets(r) {
+                http.Error(w, "credentials not allowed to modify assets", 403)
+                return
+            }
+            upsertAssets(db, w, r)
+        case http.MethodPatch:
+            if !canWriteAssets(r) {
+                http.Error(w, "credentials not allowed to modify assets", 403)
+                return
+            }
+            bulkPatchAssets(db, w, r)
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
+
+func listAssets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    sel, err := parseLabelSelector(r)
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    sc, args := scopeOf(r.Context()).cond("''")
+    conds := []string{sc}
+    for _, col := range []string{"type", "site", "owner", "source"} {
+        if v := q.Get(col); v != "" {
+            conds = append(conds, col+"=?")
+            args = append(args, v)
+        }
+    }
+    rows, err := db.QueryContext(r.Context(), assetSelect+` WHERE `+strings.Join(conds, " AND ")+` ORDER BY type,name`, args...)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    assets, err := scanAssets(rows)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    lw, err := newListWriter(w, r, "assets", AssetRow{})
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    for _, a := range assets {
+        if !selectorMatchesMap(sel, a.Labels) {
+            continue
+        }
+        if err := lw.Write(a.row()); err != nil {
+            log.Printf("[http] write assets: %v", err)
+            return
+        }
+    }
+    lw.Close()
+}
+
+// 列表输出用扁平的 labels，CSV 才有意义；定义在 client 包里
+type AssetRow = cmdbclient.AssetRow
+
+func (a Asset) row() AssetRow {
+    return AssetRow{ID: a.ID, Type: a.Type, Name: a.Name, Site: a.Site, Owner: a.Owner,
+        Labels: flattenLabels(a.Labels), Attributes: flattenLabels(a.Attributes), UpdatedAt: a.UpdatedAt, Source: a.Source, ReportedAt: a.ReportedAt}
+}
+
+func upsertAssets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    var assets []Asset
+    if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+        err = json.Unmarshal(body, &assets)
+    } else {
+        var a Asset
+        err = json.Unmarshal(body, &a)
+        assets = []Asset{a}
+    }
+    if err != nil {
+        http.Error(w, "invalid JSON: "+err.Error(), 400)
+        return
+    }
+    for _, a := range assets {
+        if err := validateAsset(a); err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+    }
+    now := time.Now().Format(time.RFC3339)
+    err = withChangeSource(db, changeSourceFor(r, "manual"), func(tx *sql.Tx) error {
+        for _, a := range assets {
+            if err := writeAsset(tx, a, now); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    writeJSON(w, r, map[string]any{"upserted": len(assets)})
+}
+
+func writeAsset(tx *sql.Tx, a Asset, now string) error {
+    _, err := tx.Exec(`
+INSERT INTO assets(type,name,site,owner,labels,attributes,created_at,updated_at) VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(type,name) DO UPDATE SET
+ site=excluded.site,
+ owner=excluded.owner,
+ labels=excluded.labels,
+ attributes=excluded.attributes,
+ updated_at=excluded.updated_at
+`, a.Type, a.Name, a.Site, a.Owner, flattenLabels(a.Labels), encodeAttributes(a.Attributes), now, now)
+    return err
+}
+
+// 在变更记录里留下是谁做的，例如 manual:alice
+func changeSourceFor(r *http.Request, kind string) string {
+    if p := principalFrom(r.Context()); p != nil {
+        return kind + ":" + p.Name
+    }
+    return kind
+}
+
+// ---------- Bulk reassignment ----------
+
+// 条件之间是 AND；labels 要求全部匹配。必须至少给一个条件，或显式 all=true
+type AssetFilter struct {
+    All    bool              `json:"all,omitempty"`
+    Type   string            `json:"type,omitempty"`
+    Site   string            `json:"site,omitempty"`
+    Owner  string            `json:"owner,omitempty"`
+    Labels map[string]string `json:"labels,omitempty"`
+}
+
+// nil 字段不改；labels 合并进现有 labels，removeLabels 删除指定 key
+type AssetChanges struct {
+    Site         *string           `json:"site,omitempty"`
+    Owner        *string           `json:"owner,omitempty"`
+    Labels       map[string]string `json:"labels,omitempty"`
+    RemoveLabels []string          `json:"removeLabels,omitempty"`
+}
+
+type BulkPatchRequest struct {
+    Filter AssetFilter  `json:"filter"`
+    Set    AssetChanges `json:"set"`
+}
+
+type BulkPatchItem struct {
+    ID     int64  `json:"id"`
+    Type   string `json:"type"`
+    Name   string `json:"name"`
+    Before Asset  `json:"before"`
+    After  Asset  `json:"after"`
+}
+
+type BulkPatchResult struct {
+    DryRun  bool            `json:"dryRun"`
+    Matched int             `json:"matched"`
+    Changed int             `json:"changed"`
+    Items   []BulkPatchItem `json:"items"`
+}
+
+func (f AssetFilter) validate() error {
+    if !f.All && f.Type == "" && f.Site == "" && f.Owner == "" && len(f.Labels) == 0 {
+        return errors.New("filter is empty; set at least one condition or all=true")
+    }
+    return nil
+}
+
+func (f AssetFilter) matches(a Asset) bool {
+    if (f.Type != "" && a.Type != f.Type) || (f.Site != "" && a.Site != f.Site) || (f.Owner != "" && a.Owner != f.Owner) {
+        return false
+    }
+    for k, v := range f.Labels {
+        if a.Labels[k] != v {
+            return false
+        }
+    }
+    return true
+}
+
+func (c AssetChanges) empty() bool {
+    return c.Site == nil && c.Owner == nil && len(c.Labels) == 0 && len(c.RemoveLabels) == 0
+}
+
+func (c AssetChanges) apply(a Asset) Asset {
+    labels := make(map[string]string, len(a.Labels))
+    for k, v := range a.Labels {
+        labels[k] = v
+    }
+    for k, v := range c.Labels {
+        labels[k] = v
+    }
+    for _, k := range c.RemoveLabels {
+        delete(labels, k)
+    }
+    a.Labels = labels
+    if c.Site != nil {
+        a.Site = *c.Site
+    }
+    if c.Owner != nil {
+        a.Owner = *c.Owner
+    }
+    return a
+}
+
+func sameAsset(a, b Asset) bool {
+    return a.Site == b.Site && a.Owner == b.Owner && flattenLabels(a.Labels) == flattenLabels(b.Labels)
+}
+
+// 在事务里读出匹配的资产并计算修改后的样子，执行时在同一事务里写回，中间不会混入其他写入
+func planBulkPatch(tx *sql.Tx, req BulkPatchRequest) (*BulkPatchResult, error) {
+    conds, args := []string{"1"}, []any{}
+    for _, f := range []struct{ col, v string }{{"type", req.Filter.Type}, {"site", req.Filter.Site}, {"owner", req.Filter.Owner}} {
+        if f.v != "" {
+            conds = append(conds, f.col+"=?")
+            args = append(args, f.v)
+        }
+    }
+    rows, err := tx.Query(assetSelect+` WHERE `+strings.Join(conds, " AND ")+` ORDER BY id`, args...)
+    if err != nil {
+        return nil, err
+    }
+    assets, err := scanAssets(rows)
+    if err != nil {
+        return nil, err
+    }
+    res := &BulkPatchResult{Items: []BulkPatchItem{}}
+    for _, a := range assets {
+        if !req.Filter.matches(a) {
+            continue
+        }
+        res.Matched++
+        after := req.Set.apply(a)
+        if sameAsset(a, after) {
+            continue
+        }
+        res.Changed++
+        res.Items = append(res.Items, BulkPatchItem{ID: a.ID, Type: a.Type, Name: a.Name, Before: a, After: after})
+    }
+    return res, nil
+}
+
+// 每个被修改的资产都会经触发器写一条 changes（source=bulk:<key>），即审计记录
+func bulkPatchAssets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+    var req BulkPatchRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid JSON: "+err.Error(), 400)
+        return
+    }
+    if err := req.Filter.validate(); err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    if req.Set.empty() {
+        http.Error(w, "set is empty", 400)
+        return
+    }
+    dryRun := r.URL.Query().Get("dryRun") == "true"
+    var res *BulkPatchResult
+    if dryRun {
+        tx, err := db.Begin()
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        res, err = planBulkPatch(tx, req)
+        tx.Rollback()
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        res.DryRun = true
+        writeJSON(w, r, res)
+        return
+    }
+    err := withChangeSource(db, changeSourceFor(r, "bulk"), func(tx *sql.Tx) error {
+        var err error
+        if res, err = planBulkPatch(tx, req); err != nil {
+            return err
+        }
+        now := time.Now().Format(time.RFC3339)
+        for _, it := range res.Items {
+            if _, err := tx.Exec(`UPDATE assets SET site=?, owner=?, labels=?, updated_at=? WHERE id=?`,
+                it.After.Site, it.After.Owner, flattenLabels(it.After.Labels), now, it.ID); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    log.Printf("[assets] bulk patch by %s: matched=%d changed=%d", changeSourceFor(r, "bulk"), res.Matched, res.Changed)
+    writeJSON(w, r, res)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ---------- Custom attributes ----------
+
+// 用户给自动发现的 CI 打的 key/value（负责团队、成本中心、重要程度……）。存在单独的 ci_attributes 表里，
+// informer / SSH 采集覆盖 pods、nodes、hosts 的行时不会动到它；对象删除时一起删。
+// 存扁平的 k=v,k=v（和 labels 一样），列表接口直接用子查询带出，按 key 排好序。
+type attributeKind struct {
+    table, key, namespace string
+}
+
+var attributeKinds = map[string]attributeKind{
+    "pods":  {table: "pods", key: "uid", namespace: "namespace"},
+    "nodes": {table: "nodes", key: "name", namespace: "''"},
+    "hosts": {table: "hosts", key: "address", namespace: "''"},
+}
+
+func initAttributes(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS ci_attributes(
+    kind TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    attributes TEXT NOT NULL,
+    updated_at TEXT,
+    PRIMARY KEY(kind, ref)
+);`}
+    for kind, k := range attributeKinds {
+        stmts = append(stmts, `DROP TRIGGER IF EXISTS `+k.table+`_attrs_ad`,
+            fmt.Sprintf(`CREATE TRIGGER %s_attrs_ad AFTER DELETE ON %s BEGIN
+ DELETE FROM ci_attributes WHERE kind='%s' AND ref=old.%s; END`, k.table, k.table, kind, k.key))
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 列表查询里用的列，table 是外层查询的表名
+func attributesColumn(kind string) string {
+    k := attributeKinds[kind]
+    return fmt.Sprintf(`coalesce((SELECT attributes FROM ci_attributes a WHERE a.kind='%s' AND a.ref=%s.%s),'')`, kind, k.table, k.key)
+}
+
+// 值为 null 删除这个 key，其它 key 不变
+type AttributePatch map[string]*string
+
+func (p AttributePatch) validate() error {
+    if len(p) == 0 {
+        return errors.New("no attributes given")
+    }
+    for k, v := range p {
+        if k == "" || strings.ContainsAny(k, "=,") {
+            return fmt.Errorf("invalid attribute name %q", k)
+        }
+        if v != nil && strings.Contains(*v, ",") {
+            return fmt.Errorf("attribute %s: value must not contain ','", k)
+        }
+    }
+    return nil
+}
+
+func patchAttributes(tx *sql.Tx, kind, ref string, patch AttributePatch) (map[string]string, error) {
+    var flat string
+    err := tx.QueryRow(`SELECT attributes FROM ci_attributes WHERE kind=? AND ref=?`, kind, ref).Scan(&flat)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        return nil, err
+    }
+    attrs := parseLabels(flat)
+    for k, v := range patch {
+        if v == nil {
+            delete(attrs, k)
+        } else {
+            attrs[k] = *v
+        }
+    }
+    if len(attrs) == 0 {
+        _, err = tx.Exec(`DELETE FROM ci_attributes WHERE kind=? AND ref=?`, kind, ref)
+    } else {
+        _, err = tx.Exec(`INSERT INTO ci_attributes(kind,ref,attributes,updated_at) VALUES(?,?,?,?)
+ON CONFLICT(kind,ref) DO UPDATE SET attributes=excluded.attributes, updated_at=excluded.updated_at`,
+            kind, ref, flattenLabels(attrs), time.Now().Format(time.RFC3339))
+    }
+    return attrs, err
+}
+
+// PATCH /cmdb/pods/{uid}、/cmdb/nodes/{name}、/cmdb/hosts/{address}，body 为 {"team":"payments","legacy":null}
+// 和资产一样只允许不限范围的凭据修改
+func attributesAPI(db *sql.DB, hot *hotReadModel, kind string) http.HandlerFunc {
+    k := attributeKinds[kind]
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPatch {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if !canWriteAssets(r) {
+            http.Error(w, "credentials not allowed to modify attributes", 403)
+            return
+        }
+        ref := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/"+kind), "/")
+        if ref == "" {
+            http.Error(w, "missing "+k.key, 400)
+            return
+        }
+        var patch AttributePatch
+        if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+            http.Error(w, "invalid JSON: "+err.Error(), 400)
+            return
+        }
+        if err := patch.validate(); err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        var attrs map[string]string
+        found := true
+        err := withChangeSource(db, changeSourceFor(r, "manual"), func(tx *sql.Tx) error {
+            var n int
+            if err := tx.QueryRow(`SELECT count(*) FROM `+k.table+` WHERE `+k.key+`=?`, ref).Scan(&n); err != nil {
+                return err
+            }
+            if found = n > 0; !found {
+                return nil
+            }
+            var err error
+            attrs, err = patchAttributes(tx, kind, ref, patch)
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if !found {
+            http.Error(w, kind+" not found", 404)
+            return
+        }
+        // 内存里的列表也带 attributes，写完重读这一行
+        hot.refresh(k.table, ref)
+        log.Printf("[attributes] %s %s set by %s", kind, ref, changeSourceFor(r, "manual"))
+        writeJSON(w, r, map[string]any{"kind": kind, k.key: ref, "attributes": attrs})
+    }
+}
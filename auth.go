@@ -0,0 +1,301 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "sigs.k8s.io/yaml"
+)
+
+// ---------- API auth ----------
+
+// 默认都要认证，只有这些公开；新加的路由不用记得登记，漏了也是被拦住而不是敞开。
+// /auth/ 是 OIDC 登录流程，/api/v1/ 去掉前缀后照样按这里的规则走
+var publicPaths = []string{"/healthz", "/version", "/metrics", "/openapi.json", "/docs"}
+
+var publicPrefixes = []string{"/auth/", apiV1Prefix + "/"}
+
+func protectedPath(path string) bool {
+    if slices.Contains(publicPaths, path) {
+        return false
+    }
+    for _, p := range publicPrefixes {
+        if strings.HasPrefix(path, p) {
+            return false
+        }
+    }
+    return true
+}
+
+// keys 文件（YAML/JSON）内容是 [{name, key, namespaces, clusters, sites, unmask}] 列表；
+// namespaces 为空表示不限，clusters 为空表示任意实例（按 federation.site 匹配），unmask 见 masking.go。
+// sites 非空的是 hub 发给 edge 的 key：只能拉这些站点的有效配置、替它们上报，别的接口都不能调
+type APIKey struct {
+    Name       string   `json:"name"`
+    Key        string   `json:"key"`
+    Namespaces []string `json:"namespaces"`
+    Clusters   []string `json:"clusters"`
+    Sites      []string `json:"sites"`
+    Unmask     bool     `json:"unmask"`
+}
+
+// 只保存 key 的 sha256，比较时长度固定，且不会因为提前返回泄露是第几个 key 匹配
+type apiKeyEntry struct {
+    principal *principal
+    clusters  []string
+    hash      [sha256.Size]byte
+}
+
+type authenticator struct {
+    keys    []apiKeyEntry
+    cluster string
+    oidc    *oidcAuth
+}
+
+// 已认证的调用方；scope 为 nil 表示可见全部 namespace，admin 才能调用 /admin/* 和改 fleet 配置，
+// unmask 看到 masking 遮盖的原值；sites 非空表示 edge key，见 APIKey
+type principal struct {
+    Name   string
+    Scope  nsScope
+    Admin  bool
+    Unmask bool
+    Sites  []string
+}
+
+// 认证关闭（p 为 nil）或不是 edge key 时不限站点
+func (p *principal) allowsSite(site string) bool {
+    return p == nil || p.Sites == nil || slices.Contains(p.Sites, site)
+}
+
+type principalKey struct{}
+
+// 认证关闭时为 nil
+func principalFrom(ctx context.Context) *principal {
+    p, _ := ctx.Value(principalKey{}).(*principal)
+    return p
+}
+
+// key 来源：auth.keysFile 和环境变量 LIGHTCMDB_API_KEYS（逗号分隔），两者合并
+func loadAuthenticator(cfg AuthConfig, cluster string) (*authenticator, error) {
+    var keys []APIKey
+    if cfg.KeysFile != "" {
+        b, err := os.ReadFile(cfg.KeysFile)
+        if err != nil {
+            return nil, err
+        }
+        if err := yaml.UnmarshalStrict(b, &keys); err != nil {
+            return nil, fmt.Errorf("%s: %w", cfg.KeysFile, err)
+        }
+    }
+    if env := os.Getenv("LIGHTCMDB_API_KEYS"); env != "" {
+        for i, k := range strings.Split(env, ",") {
+            if k = strings.TrimSpace(k); k != "" {
+                keys = append(keys, APIKey{Name: "env-" + strconv.Itoa(i+1), Key: k})
+            }
+        }
+    }
+    a := &authenticator{cluster: cluster}
+    seen := map[string]bool{}
+    for _, k := range keys {
+        if k.Name == "" || k.Key == "" {
+            return nil, fmt.Errorf("api key entry needs name and key (name=%q)", k.Name)
+        }
+        if seen[k.Name] {
+            return nil, fmt.Errorf("duplicate api key name %q", k.Name)
+        }
+        seen[k.Name] = true
+        // 不限 namespace 的 key 才有 admin 权限
+        p := &principal{Name: k.Name, Admin: true, Unmask: k.Unmask}
+        if len(k.Namespaces) > 0 {
+            p.Scope, p.Admin = nsScope(k.Namespaces), false
+        }
+        if len(k.Sites) > 0 {
+            if len(k.Namespaces) > 0 {
+                return nil, fmt.Errorf("api key %q: sites and namespaces cannot be combined", k.Name)
+            }
+            p.Sites, p.Admin = k.Sites, false
+        }
+        a.keys = append(a.keys, apiKeyEntry{principal: p, clusters: k.Clusters, hash: sha256.Sum256([]byte(k.Key))})
+    }
+    if cfg.OIDC.IssuerURL != "" {
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        o, err := newOIDCAuth(ctx, cfg.OIDC)
+        if err != nil {
+            return nil, err
+        }
+        a.oidc = o
+    }
+    return a, nil
+}
+
+func (a *authenticator) enabled() bool {
+    return len(a.keys) > 0 || a.oidc != nil
+}
+
+// 遍历全部 key，不提前退出
+func (a *authenticator) lookup(token string) *apiKeyEntry {
+    h := sha256.Sum256([]byte(token))
+    var found *apiKeyEntry
+    for i := range a.keys {
+        if subtle.ConstantTimeCompare(h[:], a.keys[i].hash[:]) == 1 {
+            found = &a.keys[i]
+        }
+    }
+    return found
+}
+
+func (e *apiKeyEntry) allowsCluster(cluster string) bool {
+    if len(e.clusters) == 0 {
+        return true
+    }
+    for _, c := range e.clusters {
+        if c == cluster {
+            return true
+        }
+    }
+    return false
+}
+
+// Authorization: Bearer <key|id_token>、X-API-Key: <key>，或 OIDC 登录后的 session cookie
+func requestToken(r *http.Request) string {
+    if v := r.Header.Get("Authorization"); len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+        return strings.TrimSpace(v[7:])
+    }
+    if v := r.Header.Get("X-API-Key"); v != "" {
+        return v
+    }
+    if c, err := r.Cookie(sessionCookie); err == nil {
+        return c.Value
+    }
+    return ""
+}
+
+// API key 优先；不是已知 key 且启用了 OIDC 时按 ID token 校验
+func (a *authenticator) authenticate(r *http.Request, token string) (*principal, int, string) {
+    if key := a.lookup(token); key != nil {
+        if !key.allowsCluster(a.cluster) {
+            return nil, 403, "API key not valid for this cluster"
+        }
+        return key.principal, 0, ""
+    }
+    if a.oidc != nil {
+        p, err := a.oidc.principal(r.Context(), token)
+        if errors.Is(err, errNoGroupScope) {
+            return nil, 403, err.Error()
+        }
+        if err == nil {
+            return p, 0, ""
+        }
+    }
+    return nil, 401, "invalid API key or token"
+}
+
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+    if !a.enabled() {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token := requestToken(r)
+        if token == "" {
+            // 浏览器直接打开时跳去登录
+            if a.oidc != nil && r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+                http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+                return
+            }
+            w.Header().Set("WWW-Authenticate", `Bearer realm="lightcmdb"`)
+            http.Error(w, "missing API key", 401)
+            return
+        }
+        p, code, msg := a.authenticate(r, token)
+        if p == nil {
+            if code == 401 {
+                log.Printf("[auth] rejected credentials for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+                w.Header().Set("WWW-Authenticate", `Bearer realm="lightcmdb", error="invalid_token"`)
+            }
+            http.Error(w, msg, code)
+            return
+        }
+        // admin 接口影响全局数据，只有不限 namespace 的 API key 能调用
+        if !p.Admin && strings.HasPrefix(r.URL.Path, "/admin/") {
+            http.Error(w, "credentials not allowed to call admin APIs", 403)
+            return
+        }
+        // edge key 只能调 edge 用的那几个接口，站点由 handler 检查；fleet 配置的读写和 rollout 状态要 admin
+        if p.Sites != nil && !edgeRoute(r) {
+            http.Error(w, "edge keys can only fetch their config and report to /federation/", 403)
+            return
+        }
+        if !p.Admin && p.Sites == nil && strings.HasPrefix(r.URL.Path, "/federation/") {
+            http.Error(w, "credentials not allowed to call federation APIs", 403)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+    })
+}
+
+// edge 拉配置和上报用到的请求
+func edgeRoute(r *http.Request) bool {
+    switch r.URL.Path {
+    case "/federation/config/effective":
+        return r.Method == http.MethodGet
+    case "/federation/status", "/federation/clusters":
+        return r.Method == http.MethodPost
+    }
+    return false
+}
+
+// ---------- Namespace scope ----------
+
+// 可见 namespace 集合。过滤条件拼进 SQL，库里查出来的就已经是可见行，
+// 不依赖 handler 事后检查；集群级对象（node，namespace 为空）对受限 key 不可见。
+type nsScope []string
+
+func scopeOf(ctx context.Context) nsScope {
+    if p := principalFrom(ctx); p != nil {
+        return p.Scope
+    }
+    return nil
+}
+
+// 返回 "<col> IN (...)" 条件；不受限时为 "1"
+func (s nsScope) cond(col string) (string, []any) {
+    if s == nil {
+        return "1", nil
+    }
+    b, _ := json.Marshal([]string(s))
+    return col + " IN (SELECT value FROM json_each(?))", []any{string(b)}
+}
+
+// 在已有条件前加上 scope 条件，返回完整的 WHERE 子句
+func (s nsScope) where(col, cond string, args ...any) (string, []any) {
+    sc, sargs := s.cond(col)
+    if cond == "" {
+        return " WHERE " + sc, sargs
+    }
+    return " WHERE " + sc + " AND (" + cond + ")", append(sargs, args...)
+}
+
+func (s nsScope) allows(ns string) bool {
+    if s == nil {
+        return true
+    }
+    for _, n := range s {
+        if n == ns {
+            return true
+        }
+    }
+    return false
+}
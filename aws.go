@@ -0,0 +1,437 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ---------- AWS collector ----------
+
+// EC2 的 Query API（DescribeInstances / DescribeVolumes / DescribeSecurityGroups），自己做 SigV4 签名，
+// 只为这三个只读调用不引入 aws-sdk-go。凭据依次取：环境变量 AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY（/ AWS_SESSION_TOKEN），
+// IRSA（AWS_ROLE_ARN + AWS_WEB_IDENTITY_TOKEN_FILE，调 STS AssumeRoleWithWebIdentity），最后是 EC2 实例角色（IMDSv2）。
+// 需要 ec2:DescribeInstances、ec2:DescribeVolumes、ec2:DescribeSecurityGroups。
+const (
+    ec2APIVersion = "2016-11-15"
+    imdsBase      = "http://169.254.169.254"
+)
+
+type awsCredentials struct {
+    AccessKeyID     string
+    SecretAccessKey string
+    SessionToken    string
+    // 零值表示不过期（环境变量）
+    Expires time.Time
+}
+
+type awsCollector struct {
+    cfg    CloudProviderConfig
+    client *http.Client
+
+    mu    sync.Mutex
+    creds awsCredentials
+}
+
+func newAWSCollector(cfg CloudProviderConfig) (cloudCollector, error) {
+    if len(cfg.Regions) == 0 {
+        return nil, errors.New("regions is empty")
+    }
+    return &awsCollector{cfg: cfg, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+func (a *awsCollector) Provider() string  { return "aws" }
+func (a *awsCollector) Regions() []string { return a.cfg.Regions }
+
+// 临时凭据提前 5 分钟刷新
+func (a *awsCollector) credentials(ctx context.Context) (awsCredentials, error) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.creds.AccessKeyID != "" && (a.creds.Expires.IsZero() || time.Until(a.creds.Expires) > 5*time.Minute) {
+        return a.creds, nil
+    }
+    var c awsCredentials
+    var err error
+    switch {
+    case os.Getenv("AWS_ACCESS_KEY_ID") != "":
+        c = awsCredentials{AccessKeyID: os.Getenv("AWS_ACCESS_KEY_ID"), SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+            SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+    case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "":
+        c, err = a.webIdentityCredentials(ctx)
+    default:
+        c, err = a.imdsCredentials(ctx)
+    }
+    if err != nil {
+        return c, fmt.Errorf("credentials: %w", err)
+    }
+    a.creds = c
+    return c, nil
+}
+
+func (a *awsCollector) webIdentityCredentials(ctx context.Context) (awsCredentials, error) {
+    token, err := os.ReadFile(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+    if err != nil {
+        return awsCredentials{}, err
+    }
+    region := os.Getenv("AWS_REGION")
+    if region == "" {
+        region = a.cfg.Regions[0]
+    }
+    q := url.Values{"Action": {"AssumeRoleWithWebIdentity"}, "Version": {"2011-06-15"}, "RoleArn": {os.Getenv("AWS_ROLE_ARN")},
+        "RoleSessionName": {"lightcmdb"}, "WebIdentityToken": {strings.TrimSpace(string(token))}}
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://sts."+region+".amazonaws.com/", strings.NewReader(q.Encode()))
+    if err != nil {
+        return awsCredentials{}, err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    var out struct {
+        Credentials struct {
+            AccessKeyID     string    `xml:"AccessKeyId"`
+            SecretAccessKey string    `xml:"SecretAccessKey"`
+            SessionToken    string    `xml:"SessionToken"`
+            Expiration      time.Time `xml:"Expiration"`
+        } `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+    }
+    if err := a.doXML(req, &out); err != nil {
+        return awsCredentials{}, err
+    }
+    c := out.Credentials
+    return awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expires: c.Expiration}, nil
+}
+
+func (a *awsCollector) imdsCredentials(ctx context.Context) (awsCredentials, error) {
+    get := func(method, path string, hdr map[string]string) (string, error) {
+        req, err := http.NewRequestWithContext(ctx, method, imdsBase+path, nil)
+        if err != nil {
+            return "", err
+        }
+        for k, v := range hdr {
+            req.Header.Set(k, v)
+        }
+        resp, err := a.client.Do(req)
+        if err != nil {
+            return "", err
+        }
+        defer resp.Body.Close()
+        b, err := io.ReadAll(resp.Body)
+        if resp.StatusCode != 200 {
+            return "", fmt.Errorf("imds %s: %s", path, resp.Status)
+        }
+        return string(b), err
+    }
+    token, err := get(http.MethodPut, "/latest/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "21600"})
+    if err != nil {
+        return awsCredentials{}, err
+    }
+    hdr := map[string]string{"X-aws-ec2-metadata-token": token}
+    role, err := get(http.MethodGet, "/latest/meta-data/iam/security-credentials/", hdr)
+    if err != nil {
+        return awsCredentials{}, err
+    }
+    body, err := get(http.MethodGet, "/latest/meta-data/iam/security-credentials/"+strings.TrimSpace(strings.Split(role, "\n")[0]), hdr)
+    if err != nil {
+        return awsCredentials{}, err
+    }
+    var c struct {
+        AccessKeyID     string    `json:"AccessKeyId"`
+        SecretAccessKey string    `json:"SecretAccessKey"`
+        Token           string    `json:"Token"`
+        Expiration      time.Time `json:"Expiration"`
+    }
+    if err := json.Unmarshal([]byte(body), &c); err != nil {
+        return awsCredentials{}, err
+    }
+    return awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expires: c.Expiration}, nil
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(s))
+    return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+    h := sha256.Sum256(b)
+    return hex.EncodeToString(h[:])
+}
+
+// SigV4：签名 host、x-amz-date（和 x-amz-security-token）
+func signAWSRequest(req *http.Request, body []byte, c awsCredentials, region, service string, now time.Time) {
+    amzDate := now.UTC().Format("20060102T150405Z")
+    day := amzDate[:8]
+    req.Header.Set("X-Amz-Date", amzDate)
+    if c.SessionToken != "" {
+        req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+    }
+    headers := map[string]string{"host": req.URL.Host, "x-amz-date": amzDate}
+    if c.SessionToken != "" {
+        headers["x-amz-security-token"] = c.SessionToken
+    }
+    names := make([]string, 0, len(headers))
+    for k := range headers {
+        names = append(names, k)
+    }
+    sort.Strings(names)
+    var canonHeaders strings.Builder
+    for _, k := range names {
+        canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+    }
+    signed := strings.Join(names, ";")
+    path := req.URL.EscapedPath()
+    if path == "" {
+        path = "/"
+    }
+    canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonHeaders.String(), signed, sha256Hex(body)}, "\n")
+    scope := day + "/" + region + "/" + service + "/aws4_request"
+    toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+    key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), day)
+    key = hmacSHA256(key, region)
+    key = hmacSHA256(key, service)
+    key = hmacSHA256(key, "aws4_request")
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        c.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// 出错时 EC2 / STS 返回 <Errors><Error><Code/><Message/></Error></Errors>
+func (a *awsCollector) doXML(req *http.Request, out any) error {
+    resp, err := a.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    b, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode != 200 {
+        var e struct {
+            Code    string `xml:"Errors>Error>Code"`
+            Message string `xml:"Errors>Error>Message"`
+        }
+        if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+            return fmt.Errorf("%s: %s", e.Code, e.Message)
+        }
+        var stsErr struct {
+            Code    string `xml:"Error>Code"`
+            Message string `xml:"Error>Message"`
+        }
+        if xml.Unmarshal(b, &stsErr) == nil && stsErr.Code != "" {
+            return fmt.Errorf("%s: %s", stsErr.Code, stsErr.Message)
+        }
+        return fmt.Errorf("%s", resp.Status)
+    }
+    return xml.Unmarshal(b, out)
+}
+
+func (a *awsCollector) endpoint(region string) string {
+    if a.cfg.Endpoint != "" {
+        return strings.ReplaceAll(a.cfg.Endpoint, "{region}", region)
+    }
+    return "https://ec2." + region + ".amazonaws.com/"
+}
+
+func (a *awsCollector) ec2(ctx context.Context, region, action string, params url.Values, out any) error {
+    creds, err := a.credentials(ctx)
+    if err != nil {
+        return err
+    }
+    params.Set("Action", action)
+    params.Set("Version", ec2APIVersion)
+    body := []byte(params.Encode())
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint(region), strings.NewReader(string(body)))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+    signAWSRequest(req, body, creds, region, "ec2", time.Now())
+    if err := a.doXML(req, out); err != nil {
+        return fmt.Errorf("%s: %w", action, err)
+    }
+    return nil
+}
+
+// Describe* 按 NextToken 翻页；各接口 MaxResults 的上限不同
+func ec2Pages[P any](ctx context.Context, a *awsCollector, region, action string, maxResults int, next func(*P) string, each func(*P)) error {
+    var token string
+    for {
+        params := url.Values{"MaxResults": {fmt.Sprint(maxResults)}}
+        if token != "" {
+            params.Set("NextToken", token)
+        }
+        var page P
+        if err := a.ec2(ctx, region, action, params, &page); err != nil {
+            return err
+        }
+        each(&page)
+        if token = next(&page); token == "" {
+            return nil
+        }
+    }
+}
+
+type ec2Tags []struct {
+    Key   string `xml:"key"`
+    Value string `xml:"value"`
+}
+
+func (t ec2Tags) toMap() map[string]string {
+    m := map[string]string{}
+    for _, kv := range t {
+        m[kv.Key] = kv.Value
+    }
+    return m
+}
+
+type ec2InstancesPage struct {
+    Reservations []struct {
+        Instances []struct {
+            ID        string  `xml:"instanceId"`
+            Type      string  `xml:"instanceType"`
+            State     string  `xml:"instanceState>name"`
+            Zone      string  `xml:"placement>availabilityZone"`
+            PrivateIP string  `xml:"privateIpAddress"`
+            PublicIP  string  `xml:"ipAddress"`
+            VPC       string  `xml:"vpcId"`
+            Launched  string  `xml:"launchTime"`
+            Tags      ec2Tags `xml:"tagSet>item"`
+            Groups    []struct {
+                ID string `xml:"groupId"`
+            } `xml:"groupSet>item"`
+        } `xml:"instancesSet>item"`
+    } `xml:"reservationSet>item"`
+    NextToken string `xml:"nextToken"`
+}
+
+type ec2VolumesPage struct {
+    Volumes []struct {
+        ID          string  `xml:"volumeId"`
+        Type        string  `xml:"volumeType"`
+        Size        int64   `xml:"size"`
+        State       string  `xml:"status"`
+        Zone        string  `xml:"availabilityZone"`
+        Tags        ec2Tags `xml:"tagSet>item"`
+        Attachments []struct {
+            InstanceID string `xml:"instanceId"`
+        } `xml:"attachmentSet>item"`
+    } `xml:"volumeSet>item"`
+    NextToken string `xml:"nextToken"`
+}
+
+type ec2SecurityGroupsPage struct {
+    Groups []struct {
+        ID          string          `xml:"groupId"`
+        Name        string          `xml:"groupName"`
+        Description string          `xml:"groupDescription"`
+        VPC         string          `xml:"vpcId"`
+        Tags        ec2Tags         `xml:"tagSet>item"`
+        Ingress     []ec2Permission `xml:"ipPermissions>item"`
+    } `xml:"securityGroupInfo>item"`
+    NextToken string `xml:"nextToken"`
+}
+
+type ec2Permission struct {
+    Protocol string `xml:"ipProtocol"`
+    FromPort string `xml:"fromPort"`
+    ToPort   string `xml:"toPort"`
+    IPv4     []struct {
+        CIDR string `xml:"cidrIp"`
+    } `xml:"ipRanges>item"`
+    IPv6 []struct {
+        CIDR string `xml:"cidrIpv6"`
+    } `xml:"ipv6Ranges>item"`
+    Groups []struct {
+        ID string `xml:"groupId"`
+    } `xml:"groups>item"`
+}
+
+// tcp:443 from 0.0.0.0/0、tcp:8000-8080 from sg-0abc；协议 -1 写成 all，不带端口
+func ingressRules(perms []ec2Permission) string {
+    var out []string
+    for _, p := range perms {
+        rule := p.Protocol
+        switch {
+        case rule == "-1":
+            rule = "all"
+        case p.FromPort == "" || p.FromPort == "-1":
+        case p.FromPort == p.ToPort:
+            rule += ":" + p.FromPort
+        default:
+            rule += ":" + p.FromPort + "-" + p.ToPort
+        }
+        var srcs []string
+        for _, r := range p.IPv4 {
+            srcs = append(srcs, r.CIDR)
+        }
+        for _, r := range p.IPv6 {
+            srcs = append(srcs, r.CIDR)
+        }
+        for _, g := range p.Groups {
+            srcs = append(srcs, g.ID)
+        }
+        for _, src := range srcs {
+            out = append(out, rule+" from "+src)
+        }
+    }
+    sort.Strings(out)
+    return strings.Join(out, ",")
+}
+
+func (a *awsCollector) Collect(ctx context.Context, region string) (*cloudInventory, error) {
+    inv := &cloudInventory{}
+    err := ec2Pages(ctx, a, region, "DescribeInstances", 1000, func(p *ec2InstancesPage) string { return p.NextToken },
+        func(p *ec2InstancesPage) {
+            for _, r := range p.Reservations {
+                for _, i := range r.Instances {
+                    var groups []string
+                    for _, g := range i.Groups {
+                        groups = append(groups, g.ID)
+                    }
+                    tags := i.Tags.toMap()
+                    inv.Instances = append(inv.Instances, cloudInstance{ID: i.ID, Name: tags["Name"], Type: i.Type, State: i.State,
+                        Zone: i.Zone, PrivateIP: i.PrivateIP, PublicIP: i.PublicIP, VPC: i.VPC, Groups: strings.Join(groups, ","),
+                        Tags: tags, LaunchedAt: i.Launched, ProviderID: "aws:///" + i.Zone + "/" + i.ID})
+                }
+            }
+        })
+    if err != nil {
+        return nil, err
+    }
+    err = ec2Pages(ctx, a, region, "DescribeVolumes", 500, func(p *ec2VolumesPage) string { return p.NextToken },
+        func(p *ec2VolumesPage) {
+            for _, v := range p.Volumes {
+                var attached string
+                if len(v.Attachments) > 0 {
+                    attached = v.Attachments[0].InstanceID
+                }
+                inv.Volumes = append(inv.Volumes, cloudVolume{ID: v.ID, Type: v.Type, SizeGB: v.Size, State: v.State, Zone: v.Zone,
+                    AttachedTo: attached, Tags: v.Tags.toMap()})
+            }
+        })
+    if err != nil {
+        return nil, err
+    }
+    err = ec2Pages(ctx, a, region, "DescribeSecurityGroups", 1000, func(p *ec2SecurityGroupsPage) string { return p.NextToken },
+        func(p *ec2SecurityGroupsPage) {
+            for _, g := range p.Groups {
+                inv.SecurityGroups = append(inv.SecurityGroups, cloudSecurityGroup{ID: g.ID, Name: g.Name, VPC: g.VPC,
+                    Description: g.Description, Ingress: ingressRules(g.Ingress), Tags: g.Tags.toMap()})
+            }
+        })
+    if err != nil {
+        return nil, err
+    }
+    return inv, nil
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ---------- Named baselines ----------
+
+// 升级前冻结一份库存（POST /admin/baselines {"name":"pre-upgrade-2024-06"}），升级后用 /admin/baselines/<name>/diff
+// 和当前库存比较，确认什么都没丢。存的是所有 history 跟踪类型的投影（同 /cmdb/export 和 git 快照），
+// 每个对象一行 baseline_objects；diff 的格式同 /cmdb/compare：added / removed / changed 按 kind 分组。
+// 和 /cmdb/compare 不同，这里不依赖 history 的保留期，多久以前的 baseline 都能比。
+// baselines.interval 配置后定时建 auto-<时间> 的 baseline，只保留最新的 baselines.keep 个；手工建的一直保留，直到 DELETE。
+const autoBaselinePrefix = "auto-"
+
+var baselineNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+var errBaselineExists = errors.New("baseline already exists")
+
+func initBaselines(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS baselines(
+    name TEXT PRIMARY KEY,
+    description TEXT,
+    auto INTEGER NOT NULL DEFAULT 0,
+    objects INTEGER NOT NULL DEFAULT 0,
+    created_by TEXT,
+    created_at TEXT NOT NULL
+);`, `
+CREATE TABLE IF NOT EXISTS baseline_objects(
+    baseline TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    namespace TEXT,
+    name TEXT,
+    object TEXT,
+    PRIMARY KEY(baseline, kind, ref)
+);`,
+        `DROP TRIGGER IF EXISTS baselines_objects_ad`,
+        `CREATE TRIGGER baselines_objects_ad AFTER DELETE ON baselines BEGIN
+ DELETE FROM baseline_objects WHERE baseline=old.name; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type BaselineRow struct {
+    Name        string `json:"name"`
+    Description string `json:"description"`
+    // 定时建的
+    Auto      bool   `json:"auto"`
+    Objects   int64  `json:"objects"`
+    CreatedBy string `json:"createdBy"`
+    CreatedAt string `json:"createdAt"`
+}
+
+type BaselineRequest struct {
+    Name        string `json:"name"`
+    Description string `json:"description"`
+}
+
+// 一个事务里复制所有类型，期间没有别的写入，各类型之间是一致的
+func createBaseline(db *sql.DB, b *BaselineRow) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    res, err := tx.Exec(`INSERT INTO baselines(name,description,auto,created_by,created_at) VALUES(?,?,?,?,?) ON CONFLICT(name) DO NOTHING`,
+        b.Name, b.Description, b.Auto, b.CreatedBy, b.CreatedAt)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return errBaselineExists
+    }
+    b.Objects = 0
+    for _, h := range historySources {
+        res, err := tx.Exec(fmt.Sprintf(`INSERT INTO baseline_objects(baseline,kind,ref,namespace,name,object)
+ SELECT ?,'%s',CAST(t.%s AS TEXT),coalesce(%s,''),coalesce(%s,''),%s FROM %s t`,
+            h.Kind, h.Key, h.col(h.Namespace, "t"), h.col(h.Name, "t"), h.jsonObject("t"), h.Table), b.Name)
+        if err != nil {
+            return fmt.Errorf("%s: %w", h.Kind, err)
+        }
+        n, _ := res.RowsAffected()
+        b.Objects += n
+    }
+    if _, err := tx.Exec(`UPDATE baselines SET objects=? WHERE name=?`, b.Objects, b.Name); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func scanBaselines(rows *sql.Rows) ([]BaselineRow, error) {
+    defer rows.Close()
+    out := []BaselineRow{}
+    for rows.Next() {
+        var b BaselineRow
+        if err := rows.Scan(&b.Name, &b.Description, &b.Auto, &b.Objects, &b.CreatedBy, &b.CreatedAt); err != nil {
+            return nil, err
+        }
+        out = append(out, b)
+    }
+    return out, rows.Err()
+}
+
+const baselineSelect = `SELECT name,coalesce(description,''),auto,objects,coalesce(created_by,''),created_at FROM baselines`
+
+// 定时建 baseline，然后删掉超出 keep 的旧的自动 baseline
+func runBaselineSchedule(db *sql.DB, every time.Duration, keep int, stop <-chan struct{}) {
+    t := time.NewTicker(every)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            now := time.Now().UTC()
+            b := BaselineRow{Name: autoBaselinePrefix + now.Format("20060102-150405"), Auto: true, CreatedBy: "schedule",
+                CreatedAt: now.Format(time.RFC3339)}
+            if err := createBaseline(db, &b); err != nil {
+                log.Printf("[baselines] create %s: %v", b.Name, err)
+                continue
+            }
+            res, err := db.Exec(`DELETE FROM baselines WHERE auto=1 AND name NOT IN (SELECT name FROM baselines WHERE auto=1 ORDER BY created_at DESC,name DESC LIMIT ?)`, keep)
+            if err != nil {
+                log.Printf("[baselines] prune: %v", err)
+                continue
+            }
+            n, _ := res.RowsAffected()
+            log.Printf("[baselines] created %s objects=%d pruned=%d", b.Name, b.Objects, n)
+        }
+    }
+}
+
+type BaselineDiff struct {
+    Baseline  string `json:"baseline"`
+    CreatedAt string `json:"createdAt"`
+    // 当前库存的读取时间
+    ComparedAt string `json:"comparedAt"`
+    // 按 history 的 kind 分组；changes 和 sources 不适用，为 0 和空
+    Kinds     map[string]*CompareGroup `json:"kinds"`
+    Truncated bool                     `json:"truncated,omitempty"`
+}
+
+type baselineObject struct {
+    namespace, name, object string
+}
+
+// 每个类型先读完 baseline 再扫当前的表（单连接，不能边读边查）
+func diffBaseline(q querier, b BaselineRow, sources []historySource, ns string) (*BaselineDiff, error) {
+    rep := &BaselineDiff{Baseline: b.Name, CreatedAt: b.CreatedAt, ComparedAt: time.Now().UTC().Format(time.RFC3339), Kinds: map[string]*CompareGroup{}}
+    n := 0
+    add := func(kind string, o CompareObject) {
+        if n == compareLimit {
+            rep.Truncated = true
+            return
+        }
+        n++
+        o.Sources = []string{}
+        g := rep.Kinds[kind]
+        if g == nil {
+            g = &CompareGroup{Added: []CompareObject{}, Removed: []CompareObject{}, Changed: []CompareObject{}}
+            rep.Kinds[kind] = g
+        }
+        switch {
+        case o.Before == "":
+            g.Added = append(g.Added, o)
+        case o.After == "":
+            g.Removed = append(g.Removed, o)
+        default:
+            g.Changed = append(g.Changed, o)
+        }
+    }
+    for _, h := range sources {
+        cond, args := "baseline=? AND kind=?", []any{b.Name, h.Kind}
+        if ns != "" {
+            cond += " AND namespace=?"
+            args = append(args, ns)
+        }
+        rows, err := q.Query(`SELECT ref,namespace,name,cmdb_unseal(object) FROM baseline_objects WHERE `+cond, args...)
+        if err != nil {
+            return nil, err
+        }
+        old := map[string]baselineObject{}
+        for rows.Next() {
+            var ref string
+            var o baselineObject
+            if err := rows.Scan(&ref, &o.namespace, &o.name, &o.object); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            old[ref] = o
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+        lq, largs := exportQuery(h, nil)
+        rows, err = q.Query(lq, largs...)
+        if err != nil {
+            return nil, err
+        }
+        var objs []CompareObject
+        for rows.Next() {
+            var o CompareObject
+            var obj string
+            if err := rows.Scan(&o.Ref, &o.Namespace, &o.Name, &obj); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            if ns != "" && o.Namespace != ns {
+                continue
+            }
+            o.After = RawJSON(obj)
+            if prev, ok := old[o.Ref]; ok {
+                delete(old, o.Ref)
+                if prev.object == obj {
+                    continue
+                }
+                o.Before = RawJSON(prev.object)
+            }
+            objs = append(objs, o)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+        for ref, prev := range old {
+            objs = append(objs, CompareObject{Ref: ref, Namespace: prev.namespace, Name: prev.name, Before: RawJSON(prev.object)})
+        }
+        sort.Slice(objs, func(i, j int) bool {
+            if objs[i].Namespace != objs[j].Namespace {
+                return objs[i].Namespace < objs[j].Namespace
+            }
+            if objs[i].Name != objs[j].Name {
+                return objs[i].Name < objs[j].Name
+            }
+            return objs[i].Ref < objs[j].Ref
+        })
+        for _, o := range objs {
+            if o.Before != "" && o.After != "" {
+                var err error
+                if o.Fields, err = compareFields(string(o.Before), string(o.After)); err != nil {
+                    return nil, err
+                }
+            }
+            add(h.Kind, o)
+        }
+    }
+    return rep, nil
+}
+
+// GET    /admin/baselines                       列表，新的在前
+// POST   /admin/baselines {"name":"pre-upgrade-2024-06","description":"..."}
+// GET    /admin/baselines/{name}
+// DELETE /admin/baselines/{name}
+// GET    /admin/baselines/{name}/diff?kind=pod,node&ns=   baseline 到现在的变化
+func baselinesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/baselines"), "/")
+        if rest == "" {
+            switch r.Method {
+            case http.MethodGet:
+                rows, err := db.QueryContext(r.Context(), baselineSelect+` ORDER BY created_at DESC,name DESC`)
+                if err != nil {
+                    http.Error(w, err.Error(), 500)
+                    return
+                }
+                list, err := scanBaselines(rows)
+                if err != nil {
+                    http.Error(w, err.Error(), 500)
+                    return
+                }
+                writeJSON(w, r, list)
+            case http.MethodPost:
+                postBaseline(db, w, r)
+            default:
+                http.Error(w, "method not allowed", 405)
+            }
+            return
+        }
+        name, sub, _ := strings.Cut(rest, "/")
+        if sub != "" && sub != "diff" {
+            http.NotFound(w, r)
+            return
+        }
+        rows, err := db.QueryContext(r.Context(), baselineSelect+` WHERE name=?`, name)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        list, err := scanBaselines(rows)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if len(list) == 0 {
+            http.Error(w, "baseline not found", 404)
+            return
+        }
+        b := list[0]
+        switch {
+        case sub == "diff" && r.Method == http.MethodGet:
+            getBaselineDiff(db, w, r, b)
+        case sub == "" && r.Method == http.MethodGet:
+            writeJSON(w, r, b)
+        case sub == "" && r.Method == http.MethodDelete:
+            confirmedHandler(baselineDeleteOp(db, b))(w, r)
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
+
+// 删掉就没法再和升级前比较了，两步确认；影响范围按 kind 列出 baseline 里的对象数
+func baselineDeleteOp(db *sql.DB, b BaselineRow) destructiveOp {
+    return destructiveOp{
+        Name: "baseline-delete",
+        Impact: func(r *http.Request) (*Impact, error) {
+            imp := &Impact{Rows: b.Objects}
+            rows, err := db.QueryContext(r.Context(), `SELECT kind||' '||count(*) FROM baseline_objects WHERE baseline=? GROUP BY kind ORDER BY kind`, b.Name)
+            if err != nil {
+                return nil, err
+            }
+            defer rows.Close()
+            for rows.Next() {
+                var s string
+                if err := rows.Scan(&s); err != nil {
+                    return nil, err
+                }
+                imp.Sample = append(imp.Sample, s)
+            }
+            return imp, rows.Err()
+        },
+        Execute: func(r *http.Request, _ map[string]string) (int64, error) {
+            res, err := db.ExecContext(r.Context(), `DELETE FROM baselines WHERE name=?`, b.Name)
+            if err != nil {
+                return 0, err
+            }
+            if n, _ := res.RowsAffected(); n == 0 {
+                return 0, nil
+            }
+            log.Printf("[baselines] %s deleted by %s", b.Name, changeSourceFor(r, "manual"))
+            return b.Objects, nil
+        },
+    }
+}
+
+func postBaseline(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+    var req BaselineRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid JSON: "+err.Error(), 400)
+        return
+    }
+    if !baselineNamePattern.MatchString(req.Name) {
+        http.Error(w, "name must be letters, digits, '.', '_' or '-', e.g. pre-upgrade-2024-06", 400)
+        return
+    }
+    if strings.HasPrefix(req.Name, autoBaselinePrefix) {
+        http.Error(w, "names starting with "+autoBaselinePrefix+" are reserved for scheduled baselines", 400)
+        return
+    }
+    b := BaselineRow{Name: req.Name, Description: req.Description, CreatedBy: changeSourceFor(r, "manual"),
+        CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+    err := createBaseline(db, &b)
+    if errors.Is(err, errBaselineExists) {
+        http.Error(w, "baseline "+b.Name+" already exists", 409)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    log.Printf("[baselines] %s created by %s objects=%d", b.Name, b.CreatedBy, b.Objects)
+    writeJSON(w, r, b)
+}
+
+func getBaselineDiff(db *sql.DB, w http.ResponseWriter, r *http.Request, b BaselineRow) {
+    sources := historySources
+    if v := r.URL.Query().Get("kind"); v != "" {
+        sources = nil
+        for _, k := range strings.Split(v, ",") {
+            h, ok := historySourceOf(k)
+            if !ok {
+                http.Error(w, "unknown kind "+k, 400)
+                return
+            }
+            sources = append(sources, h)
+        }
+    }
+    var rep *BaselineDiff
+    err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+        var err error
+        rep, err = diffBaseline(dbFrom(ctx, db), b, sources, r.URL.Query().Get("ns"))
+        return err
+    })
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    writeJSON(w, r, rep)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// DELETE /admin/baselines/<name> 不带 token 只是 dry run
+func TestBaselineDeleteIsTwoPhase(t *testing.T) {
+    db := newTestDB(t)
+    if _, err := db.Exec(`INSERT INTO baselines(name,objects,created_at) VALUES('pre-upgrade',1,'2026-01-01T00:00:00Z')`); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := db.Exec(`INSERT INTO baseline_objects(baseline,kind,ref) VALUES('pre-upgrade','node','n1')`); err != nil {
+        t.Fatal(err)
+    }
+    h := baselinesAPI(db)
+    baselines := countRows(db, `SELECT count(*) FROM baselines`)
+
+    rec := httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodDelete, "/admin/baselines/pre-upgrade", nil))
+    var dry struct {
+        DryRun       bool   `json:"dryRun"`
+        Impact       Impact `json:"impact"`
+        ConfirmToken string `json:"confirmToken"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &dry); err != nil {
+        t.Fatalf("%d %s: %v", rec.Code, rec.Body, err)
+    }
+    if !dry.DryRun || dry.Impact.Rows != 1 || len(dry.Impact.Sample) != 1 || dry.Impact.Sample[0] != "node 1" {
+        t.Fatalf("dry run = %s", rec.Body)
+    }
+    if n, err := baselines(); err != nil || n != 1 {
+        t.Fatalf("baselines after dry run = %d, %v", n, err)
+    }
+
+    rec = httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodDelete, "/admin/baselines/pre-upgrade?confirm="+dry.ConfirmToken, nil))
+    if rec.Code != 200 {
+        t.Fatalf("confirm: %d %s", rec.Code, rec.Body)
+    }
+    if n, err := baselines(); err != nil || n != 0 {
+        t.Fatalf("baselines after delete = %d, %v", n, err)
+    }
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// ---------- Batch get ----------
+
+// 给告警补 CMDB 信息的工具一条告警要查好几个对象，一次几百条告警就是几百个 GET：
+// POST /cmdb/batch 一次取回一组 CI，按请求的顺序返回，所有条目在同一个读快照里查，互相一致。
+// CI 用 type + id 表示，和拓扑 / 手工关系里的 CI id 一致（id 是去掉 type/ 之后的部分）：
+// pod、service、deployment、serviceaccount、helmrelease、argoapp 为 <ns>/<name>，node 为 <name>，
+// host、netdevice 为 <address>，asset 为 <type>/<name>。返回的对象和对应列表接口的一行相同；
+// service、deployment 没有列表接口，用 GraphQL 里的字段。
+// 找不到（包括不在 key 的 namespace 范围内）、id 格式不对和不支持的 type 都只影响那一条，整个请求仍返回 200。
+const batchLimit = 1000
+
+type BatchRef struct {
+    Type string `json:"type"`
+    ID   string `json:"id"`
+}
+
+type BatchRequest struct {
+    Items []BatchRef `json:"items"`
+}
+
+type BatchItem struct {
+    Type   string `json:"type"`
+    ID     string `json:"id"`
+    Found  bool   `json:"found"`
+    Object any    `json:"object,omitempty"`
+    // id 格式不对、type 不支持
+    Error string `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+    Found   int         `json:"found"`
+    Missing int         `json:"missing"`
+    Items   []BatchItem `json:"items"`
+}
+
+// 找不到时返回 nil, nil
+type batchLoader func(ctx context.Context, db *sql.DB, id string) (any, error)
+
+var batchLoaders = map[string]batchLoader{
+    "pod": namespacedLoader(func(ctx context.Context, db *sql.DB, ns, name string) (any, error) {
+        where, args := scopeOf(ctx).where("namespace", "namespace=? AND name=?", ns, name)
+        return batchRow(ctx, db, `SELECT `+podRowColumns+` FROM pods`+where, scanPodRow, args...)
+    }),
+    "node": func(ctx context.Context, db *sql.DB, name string) (any, error) {
+        where, args := scopeOf(ctx).where("''", "name=?", name)
+        return batchRow(ctx, db, `SELECT `+nodeRowColumns+` FROM nodes`+where, scanNodeRow, args...)
+    },
+    "service": namespacedLoader(func(ctx context.Context, db *sql.DB, ns, name string) (any, error) {
+        return firstRow(gqlServices(ctx, db, "namespace=? AND name=?", ns, name))
+    }),
+    "deployment": namespacedLoader(func(ctx context.Context, db *sql.DB, ns, name string) (any, error) {
+        return firstRow(gqlDeployments(ctx, db, "namespace=? AND name=?", ns, name))
+    }),
+    "serviceaccount": loadBatchServiceAccount,
+    "sa":             loadBatchServiceAccount,
+    "helmrelease": namespacedLoader(func(ctx context.Context, db *sql.DB, ns, name string) (any, error) {
+        where, args := scopeOf(ctx).where("r.namespace", "r.ref=?", ns+"/"+name)
+        return batchRow(ctx, db, helmReleaseSelect+where, scanHelmRelease, args...)
+    }),
+    "argoapp": namespacedLoader(func(ctx context.Context, db *sql.DB, ns, name string) (any, error) {
+        app, err := loadApp(ctx, db, ns, name)
+        if err != nil || app == nil {
+            return nil, err
+        }
+        return *app, nil
+    }),
+    "host": func(ctx context.Context, db *sql.DB, addr string) (any, error) {
+        where, args := scopeOf(ctx).where("''", "address=?", addr)
+        return batchRow(ctx, db, hostSelect+where, scanHostRow, args...)
+    },
+    "netdevice": func(ctx context.Context, db *sql.DB, addr string) (any, error) {
+        where, args := scopeOf(ctx).where("''", "address=?", addr)
+        return batchRow(ctx, db, netDeviceSelect+where, scanNetDevice, args...)
+    },
+    "asset": func(ctx context.Context, db *sql.DB, id string) (any, error) {
+        // 资产名里可以有 /，type 里不行
+        typ, name, ok := strings.Cut(id, "/")
+        if !ok || typ == "" || name == "" {
+            return nil, errBatchInvalidID("<type>/<name>")
+        }
+        where, args := scopeOf(ctx).where("''", "type=? AND name=?", typ, name)
+        rows, err := dbFrom(ctx, db).Query(assetSelect+where, args...)
+        if err != nil {
+            return nil, err
+        }
+        list, err := scanAssets(rows)
+        if err != nil || len(list) == 0 {
+            return nil, err
+        }
+        return list[0], nil
+    },
+}
+
+var loadBatchServiceAccount = namespacedLoader(func(ctx context.Context, db *sql.DB, ns, name string) (any, error) {
+    where, args := scopeOf(ctx).where("namespace", "namespace=? AND name=?", ns, name)
+    rows, err := dbFrom(ctx, db).Query(serviceAccountSelect+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    list, err := scanServiceAccounts(rows)
+    if err != nil || len(list) == 0 {
+        return nil, err
+    }
+    return list[0], nil
+})
+
+type errBatchInvalidID string
+
+func (e errBatchInvalidID) Error() string { return "id must be " + string(e) }
+
+func namespacedLoader(load func(ctx context.Context, db *sql.DB, ns, name string) (any, error)) batchLoader {
+    return func(ctx context.Context, db *sql.DB, id string) (any, error) {
+        ns, name, ok := strings.Cut(id, "/")
+        if !ok || ns == "" || name == "" || strings.Contains(name, "/") {
+            return nil, errBatchInvalidID("<namespace>/<name>")
+        }
+        return load(ctx, db, ns, name)
+    }
+}
+
+// 只取第一行；没有时返回 nil 而不是 T 的零值
+func batchRow[T any](ctx context.Context, db *sql.DB, query string, scan func(*sql.Rows) (T, error), args ...any) (any, error) {
+    rows, err := dbFrom(ctx, db).Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    if !rows.Next() {
+        return nil, rows.Err()
+    }
+    v, err := scan(rows)
+    if err != nil {
+        return nil, err
+    }
+    return v, nil
+}
+
+func batchGet(ctx context.Context, db *sql.DB, refs []BatchRef) (*BatchResult, error) {
+    res := &BatchResult{Items: make([]BatchItem, 0, len(refs))}
+    for _, ref := range refs {
+        it := BatchItem{Type: ref.Type, ID: ref.ID}
+        load, ok := batchLoaders[strings.ToLower(ref.Type)]
+        if !ok {
+            it.Error = fmt.Sprintf("unsupported type %q", ref.Type)
+        } else {
+            obj, err := load(ctx, db, ref.ID)
+            var invalid errBatchInvalidID
+            switch {
+            case errors.As(err, &invalid):
+                it.Error = invalid.Error()
+            case err != nil:
+                return nil, err
+            default:
+                it.Object, it.Found = obj, obj != nil
+            }
+        }
+        if it.Found {
+            res.Found++
+        } else {
+            res.Missing++
+        }
+        res.Items = append(res.Items, it)
+    }
+    return res, nil
+}
+
+// POST /cmdb/batch  {"items":[{"type":"pod","id":"shop/cart-7d9f"},{"type":"node","id":"worker-3"}]}
+func batchAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        var req BatchRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, "invalid JSON: "+err.Error(), 400)
+            return
+        }
+        if len(req.Items) > batchLimit {
+            http.Error(w, fmt.Sprintf("at most %d items per request", batchLimit), 400)
+            return
+        }
+        var res *BatchResult
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            res, err = batchGet(ctx, db, req.Items)
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, res)
+    }
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Informer cache usage ----------
+
+// 每种 informer 缓存的近似内存：对象数 × 抽样对象的 JSON 平均大小。
+// 只是量级参考（Go 里的实际占用一般是 JSON 的 2~3 倍），用来判断受限设备上哪些 kind 该改成 metadata-only。
+const cacheSampleSize = 32
+
+type CacheUsage struct {
+    Kind           string `json:"kind"`
+    Objects        int    `json:"objects"`
+    SampledObjects int    `json:"sampledObjects"`
+    AvgObjectBytes int64  `json:"avgObjectBytes"`
+    EstimatedBytes int64  `json:"estimatedBytes"`
+}
+
+type cacheMeter struct {
+    informers map[string]cache.SharedIndexInformer
+    mu        sync.Mutex
+    at        time.Time
+    last      []CacheUsage
+}
+
+func newCacheMeter() *cacheMeter {
+    return &cacheMeter{informers: map[string]cache.SharedIndexInformer{}}
+}
+
+func (c *cacheMeter) add(kind string, inf cache.SharedIndexInformer) {
+    c.informers[kind] = inf
+}
+
+func measureStore(kind string, store cache.Store) CacheUsage {
+    objs := store.List()
+    u := CacheUsage{Kind: kind, Objects: len(objs)}
+    if len(objs) == 0 {
+        return u
+    }
+    // 均匀抽样，避免只看到最早建的那批对象
+    step := max(1, len(objs)/cacheSampleSize)
+    var total int64
+    for i := 0; i < len(objs) && u.SampledObjects < cacheSampleSize; i += step {
+        b, err := json.Marshal(objs[i])
+        if err != nil {
+            continue
+        }
+        total += int64(len(b))
+        u.SampledObjects++
+    }
+    if u.SampledObjects > 0 {
+        u.AvgObjectBytes = total / int64(u.SampledObjects)
+        u.EstimatedBytes = u.AvgObjectBytes * int64(u.Objects)
+    }
+    return u
+}
+
+// 结果缓存 30 秒，metrics 抓取和 status 接口共用
+func (c *cacheMeter) usage() []CacheUsage {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.last != nil && time.Since(c.at) < 30*time.Second {
+        return c.last
+    }
+    out := make([]CacheUsage, 0, len(c.informers))
+    for kind, inf := range c.informers {
+        out = append(out, measureStore(kind, inf.GetStore()))
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+    c.last, c.at = out, time.Now()
+    return out
+}
+
+func (c *cacheMeter) registerMetrics(m *metricsRegistry) {
+    m.register(metricFamily{Name: "lightcmdb_informer_cache_objects", Type: "gauge",
+        Help: "Objects held in the informer cache, by kind",
+        Collect: func() []metricSample {
+            var out []metricSample
+            for _, u := range c.usage() {
+                out = append(out, metricSample{Labels: []metricLabel{{"kind", u.Kind}}, Value: float64(u.Objects)})
+            }
+            return out
+        }})
+    m.register(metricFamily{Name: "lightcmdb_informer_cache_bytes", Type: "gauge",
+        Help: "Approximate informer cache size (objects x sampled JSON size), by kind",
+        Collect: func() []metricSample {
+            var out []metricSample
+            for _, u := range c.usage() {
+                out = append(out, metricSample{Labels: []metricLabel{{"kind", u.Kind}}, Value: float64(u.EstimatedBytes)})
+            }
+            return out
+        }})
+}
+
+// ---------- Admin status ----------
+
+type AdminStatus struct {
+    StartedAt string       `json:"startedAt"`
+    Uptime    string       `json:"uptime"`
+    Caches    []CacheUsage `json:"informerCaches"`
+}
+
+// GET /admin/status
+func adminStatusAPI(started time.Time, caches *cacheMeter) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        writeJSON(w, r, AdminStatus{
+            StartedAt: started.UTC().Format(time.RFC3339),
+            Uptime:    time.Since(started).Round(time.Second).String(),
+            Caches:    caches.usage(),
+        })
+    }
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+    "crypto/x509"
+    "database/sql"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/dynamic/dynamicinformer"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- TLS certificate expiry ----------
+
+// 证书过期是最常见、也最容易提前发现的故障。两个来源：
+//   - certificates.enabled 时单独的 informer 只 List/Watch type=kubernetes.io/tls 的 Secret（需要 secrets 的 list/watch 权限），
+//     进缓存前就解析 tls.crt，Secret 的内容（包括私钥）不进缓存也不入库，只留证书的摘要，存在 tls_secrets；
+//   - 集群提供 cert-manager.io/v1 时 Certificate 也入库（cert_manager_certificates，和 Argo CD 一样走 syncs 的队列），
+//     notAfter、renewalTime 和 Ready 取自 status。
+//
+// tls.crt 里有多张证书（中间证书）时 not_after 取最早过期的那张：链上任何一张过期握手都会失败。
+// /cmdb/certificates?expiringWithin=30d 合并两个来源，最早过期的在前；lightcmdb_certificate_expiry_timestamp_seconds 给告警用。
+const (
+    tlsSecretType = string(corev1.SecretTypeTLS)
+    // transform 后 Secret.Data 里只剩这一项：tlsCertInfo 的 JSON
+    tlsSummaryKey = "lightcmdb.summary"
+)
+
+var (
+    certManagerGroupVersion = "cert-manager.io/v1"
+    certManagerResource     = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+)
+
+func initCertificates(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS tls_secrets(
+    ref TEXT PRIMARY KEY,
+    uid TEXT,
+    namespace TEXT,
+    name TEXT,
+    common_name TEXT,
+    dns_names TEXT,
+    issuer TEXT,
+    serial TEXT,
+    not_before TEXT,
+    not_after TEXT,
+    -- tls.crt 里的证书张数
+    chain_length INTEGER,
+    -- 解析失败的原因，此时证书字段为空
+    error TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS cert_manager_certificates(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    secret_name TEXT,
+    common_name TEXT,
+    dns_names TEXT,
+    issuer TEXT,
+    not_after TEXT,
+    renewal_time TEXT,
+    ready TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`,
+        `CREATE INDEX IF NOT EXISTS tls_secrets_not_after ON tls_secrets(not_after)`,
+        `CREATE INDEX IF NOT EXISTS cert_manager_certificates_not_after ON cert_manager_certificates(not_after)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type tlsCertInfo struct {
+    CommonName  string `json:"commonName"`
+    DNSNames    string `json:"dnsNames"`
+    Issuer      string `json:"issuer"`
+    Serial      string `json:"serial"`
+    NotBefore   string `json:"notBefore"`
+    NotAfter    string `json:"notAfter"`
+    ChainLength int    `json:"chainLength"`
+    Error       string `json:"error,omitempty"`
+}
+
+// 第一张是叶子证书，名字、签发者都取它的
+func parseTLSChain(data []byte) (tlsCertInfo, error) {
+    var info tlsCertInfo
+    var notAfter time.Time
+    for {
+        var block *pem.Block
+        block, data = pem.Decode(data)
+        if block == nil {
+            break
+        }
+        if block.Type != "CERTIFICATE" {
+            continue
+        }
+        c, err := x509.ParseCertificate(block.Bytes)
+        if err != nil {
+            return tlsCertInfo{}, err
+        }
+        if info.ChainLength == 0 {
+            names := append([]string{}, c.DNSNames...)
+            for _, ip := range c.IPAddresses {
+                names = append(names, ip.String())
+            }
+            info.CommonName = c.Subject.CommonName
+            info.DNSNames = strings.Join(names, ",")
+            info.Issuer = c.Issuer.CommonName
+            if info.Issuer == "" {
+                info.Issuer = c.Issuer.String()
+            }
+            info.Serial = c.SerialNumber.Text(16)
+            info.NotBefore = c.NotBefore.UTC().Format(time.RFC3339)
+        }
+        if info.ChainLength == 0 || c.NotAfter.Before(notAfter) {
+            notAfter = c.NotAfter
+        }
+        info.ChainLength++
+    }
+    if info.ChainLength == 0 {
+        return tlsCertInfo{}, errors.New("tls.crt has no PEM certificate")
+    }
+    info.NotAfter = notAfter.UTC().Format(time.RFC3339)
+    return info, nil
+}
+
+// 给 SetTransform 用：解析一次，缓存里只留摘要；对已经转换过的对象原样返回
+func tlsSecretTransform(obj interface{}) (interface{}, error) {
+    s, ok := obj.(*corev1.Secret)
+    if !ok || s.Data[tlsSummaryKey] != nil {
+        return obj, nil
+    }
+    info, err := parseTLSChain(s.Data[corev1.TLSCertKey])
+    if err != nil {
+        info = tlsCertInfo{Error: err.Error()}
+    }
+    summary, err := json.Marshal(info)
+    if err != nil {
+        return nil, err
+    }
+    s.ManagedFields = nil
+    // kubectl apply 的 last-applied-configuration 里也会有私钥
+    s.Annotations = nil
+    s.StringData = nil
+    s.Data = map[string][]byte{tlsSummaryKey: summary}
+    return s, nil
+}
+
+func storeTLSSecret(db *sql.DB, s *corev1.Secret) error {
+    var info tlsCertInfo
+    if err := json.Unmarshal(s.Data[tlsSummaryKey], &info); err != nil {
+        return err
+    }
+    now := time.Now().UTC().Format(time.RFC3339)
+    _, err := db.Exec(`
+INSERT INTO tls_secrets(ref,uid,namespace,name,common_name,dns_names,issuer,serial,not_before,not_after,chain_length,error,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(ref) DO UPDATE SET
+ uid=excluded.uid,
+ common_name=excluded.common_name,
+ dns_names=excluded.dns_names,
+ issuer=excluded.issuer,
+ serial=excluded.serial,
+ not_before=excluded.not_before,
+ not_after=excluded.not_after,
+ chain_length=excluded.chain_length,
+ error=excluded.error,
+ updated_at=excluded.updated_at
+WHERE tls_secrets.uid IS NOT excluded.uid OR tls_secrets.serial IS NOT excluded.serial OR tls_secrets.error IS NOT excluded.error
+`, s.Namespace+"/"+s.Name, string(s.UID), s.Namespace, s.Name, info.CommonName, info.DNSNames, info.Issuer, info.Serial,
+        info.NotBefore, info.NotAfter, info.ChainLength, info.Error, now, now)
+    return err
+}
+
+// 单独的 informer，只 List/Watch type=kubernetes.io/tls 的 Secret；需要 secrets 的 list/watch 权限
+func watchTLSSecrets(db *sql.DB, client kubernetes.Interface, stop <-chan struct{}) {
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+        o.FieldSelector = "type=" + tlsSecretType
+    }))
+    inf := factory.Core().V1().Secrets().Informer()
+    if err := inf.SetTransform(tlsSecretTransform); err != nil {
+        log.Printf("[certs] transform: %v", err)
+    }
+    upsert := func(obj interface{}) {
+        s, ok := obj.(*corev1.Secret)
+        if !ok {
+            return
+        }
+        if err := storeTLSSecret(db, s); err != nil {
+            log.Printf("[certs] %s/%s err=%v", s.Namespace, s.Name, err)
+        }
+    }
+    inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+        AddFunc:    upsert,
+        UpdateFunc: func(_, obj interface{}) { upsert(obj) },
+        DeleteFunc: func(obj interface{}) {
+            key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+            if err != nil {
+                return
+            }
+            if _, err := db.Exec(`DELETE FROM tls_secrets WHERE ref=?`, key); err != nil {
+                log.Printf("[certs] delete %s err=%v", key, err)
+            }
+        },
+    })
+    factory.Start(stop)
+    go func() {
+        // 不等同步：缺权限时只会打日志，不影响启动
+        if !cache.WaitForCacheSync(stop, inf.HasSynced) {
+            return
+        }
+        if err := pruneTLSSecrets(db, inf.GetStore()); err != nil {
+            log.Printf("[certs] prune: %v", err)
+        }
+    }()
+}
+
+// 启动时库里有、集群里已经没有的（停机期间删除的）
+func pruneTLSSecrets(db *sql.DB, store cache.Store) error {
+    live := map[string]bool{}
+    for _, k := range store.ListKeys() {
+        live[k] = true
+    }
+    rows, err := db.Query(`SELECT ref FROM tls_secrets`)
+    if err != nil {
+        return err
+    }
+    var gone []string
+    for rows.Next() {
+        var ref string
+        if err := rows.Scan(&ref); err != nil {
+            rows.Close()
+            return err
+        }
+        if !live[ref] {
+            gone = append(gone, ref)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    for _, ref := range gone {
+        if _, err := db.Exec(`DELETE FROM tls_secrets WHERE ref=?`, ref); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ---------- cert-manager ----------
+
+type certManagerFields struct {
+    secretName, commonName, dnsNames, issuer, notAfter, renewalTime, ready string
+}
+
+func certManagerFieldsOf(u *unstructured.Unstructured) certManagerFields {
+    var f certManagerFields
+    f.secretName, _, _ = unstructured.NestedString(u.Object, "spec", "secretName")
+    f.commonName, _, _ = unstructured.NestedString(u.Object, "spec", "commonName")
+    names, _, _ := unstructured.NestedStringSlice(u.Object, "spec", "dnsNames")
+    f.dnsNames = strings.Join(names, ",")
+    kind, _, _ := unstructured.NestedString(u.Object, "spec", "issuerRef", "kind")
+    name, _, _ := unstructured.NestedString(u.Object, "spec", "issuerRef", "name")
+    if kind == "" {
+        kind = "Issuer"
+    }
+    f.issuer = kind + "/" + name
+    f.notAfter, _, _ = unstructured.NestedString(u.Object, "status", "notAfter")
+    f.renewalTime, _, _ = unstructured.NestedString(u.Object, "status", "renewalTime")
+    conds, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+    for _, c := range conds {
+        if m, ok := c.(map[string]interface{}); ok && m["type"] == "Ready" {
+            f.ready, _ = m["status"].(string)
+        }
+    }
+    return f
+}
+
+func upsertCertManagerCertificate(db querier, u *unstructured.Unstructured) error {
+    if u == nil {
+        return errors.New("nil certificate")
+    }
+    f := certManagerFieldsOf(u)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO cert_manager_certificates(uid,name,namespace,secret_name,common_name,dns_names,issuer,not_after,renewal_time,ready,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ secret_name=excluded.secret_name,
+ common_name=excluded.common_name,
+ dns_names=excluded.dns_names,
+ issuer=excluded.issuer,
+ not_after=excluded.not_after,
+ renewal_time=excluded.renewal_time,
+ ready=excluded.ready,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("cert_manager_certificates"), string(u.GetUID()), u.GetName(), u.GetNamespace(), f.secretName, f.commonName,
+        f.dnsNames, f.issuer, f.notAfter, f.renewalTime, f.ready, flattenLabels(u.GetLabels()), u.GetResourceVersion(), now, now)
+    _, err = upsertApplied(res, err, "cert_manager_certificates", u.GetNamespace()+"/"+u.GetName(), u.GetResourceVersion())
+    return err
+}
+
+func deleteCertManagerCertificate(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM cert_manager_certificates WHERE uid=?`, uid)
+    return err
+}
+
+var certManagerKinds = []syncDiffKind{
+    {Name: "certificates", Table: "cert_manager_certificates", Key: "uid", NS: "namespace",
+        Cols: []string{"secret_name", "common_name", "dns_names", "issuer", "not_after", "renewal_time", "ready", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            u := o.(*unstructured.Unstructured)
+            f := certManagerFieldsOf(u)
+            return string(u.GetUID()), []string{f.secretName, f.commonName, f.dnsNames, f.issuer, f.notAfter, f.renewalTime, f.ready,
+                flattenLabels(u.GetLabels())}
+        },
+        upsert: func(q querier, o runtime.Object) error {
+            return upsertCertManagerCertificate(q, o.(*unstructured.Unstructured))
+        },
+        remove: deleteCertManagerCertificate},
+}
+
+func certManagerServed(client kubernetes.Interface) (bool, error) {
+    list, err := client.Discovery().ServerResourcesForGroupVersion(certManagerGroupVersion)
+    if apierrors.IsNotFound(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    for _, r := range list.APIResources {
+        if r.Name == certManagerResource.Resource {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// 同 watchArgoCD：必须在 syncs.run 之前调用，返回的 factory 由调用方 Start
+func watchCertManager(client kubernetes.Interface, dyn dynamic.Interface, transform cache.TransformFunc, syncs *syncQueue, caches *cacheMeter) dynamicinformer.DynamicSharedInformerFactory {
+    ok, err := certManagerServed(client)
+    if err != nil {
+        log.Printf("[certs] discovery: %v, cert-manager Certificates are not tracked", err)
+        return nil
+    }
+    if !ok {
+        return nil
+    }
+    factory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, 0)
+    inf := factory.ForResource(certManagerResource).Informer()
+    inf.SetTransform(transform)
+    syncs.addKind(certManagerKinds[0], inf)
+    caches.add(certManagerKinds[0].Name, inf)
+    log.Printf("[certs] %s found, tracking Certificates", certManagerGroupVersion)
+    return factory
+}
+
+// ---------- HTTP ----------
+
+type CertificateRow struct {
+    // secret（tls Secret 里的证书）或 cert-manager（Certificate 的 status）
+    Source    string `json:"source"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // cert-manager 写证书的 Secret；source=secret 时就是 name
+    SecretName string `json:"secretName"`
+    CommonName string `json:"commonName"`
+    DNSNames   string `json:"dnsNames"`
+    Issuer     string `json:"issuer"`
+    // 还没签发或解析失败时为空
+    NotAfter string `json:"notAfter"`
+    // 负数表示已过期
+    DaysLeft float64 `json:"daysLeft"`
+    Expired  bool    `json:"expired"`
+    // 以下只有 cert-manager 有
+    RenewalTime string `json:"renewalTime,omitempty"`
+    Ready       string `json:"ready,omitempty"`
+    // tls.crt 解析失败的原因
+    Error string `json:"error,omitempty"`
+}
+
+// 30d 或 Go 的 duration（72h）
+func parseExpiryWindow(v string) (time.Duration, error) {
+    if n, ok := strings.CutSuffix(v, "d"); ok {
+        days, err := strconv.Atoi(n)
+        if err != nil || days < 0 {
+            return 0, fmt.Errorf("invalid window %q", v)
+        }
+        return time.Duration(days) * 24 * time.Hour, nil
+    }
+    d, err := time.ParseDuration(v)
+    if err != nil || d < 0 {
+        return 0, fmt.Errorf("invalid window %q", v)
+    }
+    return d, nil
+}
+
+const certificateSelect = `SELECT source,namespace,name,secret_name,common_name,dns_names,issuer,not_after,renewal_time,ready,error FROM (
+SELECT 'secret' AS source,namespace,name,name AS secret_name,coalesce(common_name,'') AS common_name,coalesce(dns_names,'') AS dns_names,
+ coalesce(issuer,'') AS issuer,coalesce(not_after,'') AS not_after,'' AS renewal_time,'' AS ready,coalesce(error,'') AS error FROM tls_secrets
+UNION ALL
+SELECT 'cert-manager',namespace,name,coalesce(secret_name,''),coalesce(common_name,''),coalesce(dns_names,''),
+ coalesce(issuer,''),coalesce(not_after,''),coalesce(renewal_time,''),coalesce(ready,''),'' FROM cert_manager_certificates)`
+
+// GET /cmdb/certificates?expiringWithin=30d&ns=&source=secret|cert-manager[&format=csv]
+func certificatesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        qs := r.URL.Query()
+        now := time.Now().UTC()
+        var conds []string
+        var condArgs []any
+        if v := qs.Get("expiringWithin"); v != "" {
+            d, err := parseExpiryWindow(v)
+            if err != nil {
+                http.Error(w, "expiringWithin must be like 30d or 72h", 400)
+                return
+            }
+            // 已过期的也算
+            conds = append(conds, "not_after<>'' AND not_after<=?")
+            condArgs = append(condArgs, now.Add(d).Format(time.RFC3339))
+        }
+        if v := qs.Get("ns"); v != "" {
+            conds = append(conds, "namespace=?")
+            condArgs = append(condArgs, v)
+        }
+        switch v := qs.Get("source"); v {
+        case "":
+        case "secret", "cert-manager":
+            conds = append(conds, "source=?")
+            condArgs = append(condArgs, v)
+        default:
+            http.Error(w, "source must be secret or cert-manager", 400)
+            return
+        }
+        where, args := scopeOf(r.Context()).where("namespace", strings.Join(conds, " AND "), condArgs...)
+        // 未签发的排最后
+        rows, err := db.QueryContext(r.Context(), certificateSelect+where+` ORDER BY not_after='',not_after,namespace,name,source`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "certificates", CertificateRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var c CertificateRow
+            if err := rows.Scan(&c.Source, &c.Namespace, &c.Name, &c.SecretName, &c.CommonName, &c.DNSNames, &c.Issuer, &c.NotAfter,
+                &c.RenewalTime, &c.Ready, &c.Error); err != nil {
+                lw.Fail(err)
+                return
+            }
+            if t, err := time.Parse(time.RFC3339, c.NotAfter); err == nil {
+                c.DaysLeft = math.Round(t.Sub(now).Hours()/24*10) / 10
+                c.Expired = !t.After(now)
+            }
+            if err := lw.Write(c); err != nil {
+                log.Printf("[http] write certificates: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+            return
+        }
+        lw.Close()
+    }
+}
+
+func registerCertificateMetrics(m *metricsRegistry, db *sql.DB) {
+    m.register(metricFamily{Name: "lightcmdb_certificate_expiry_timestamp_seconds", Type: "gauge",
+        Help: "notAfter of TLS Secrets and cert-manager Certificates as a Unix timestamp, by source, namespace and name",
+        Collect: func() []metricSample {
+            rows, err := db.Query(`SELECT source,namespace,name,not_after FROM (` + certificateSelect + `) WHERE not_after<>'' ORDER BY 1,2,3`)
+            if err != nil {
+                log.Printf("[metrics] certificates: %v", err)
+                return nil
+            }
+            defer rows.Close()
+            var out []metricSample
+            for rows.Next() {
+                var source, ns, name, notAfter string
+                if err := rows.Scan(&source, &ns, &name, &notAfter); err != nil {
+                    log.Printf("[metrics] certificates: %v", err)
+                    return out
+                }
+                if t, err := time.Parse(time.RFC3339, notAfter); err == nil {
+                    out = append(out, metricSample{Labels: []metricLabel{{"source", source}, {"namespace", ns}, {"name", name}},
+                        Value: float64(t.Unix())})
+                }
+            }
+            if err := rows.Err(); err != nil {
+                log.Printf("[metrics] certificates: %v", err)
+            }
+            return out
+        }})
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "text/tabwriter"
+    "time"
+)
+
+// ---------- Consistency check ----------
+
+// lightcmdb check [-repair] 和 /admin/check 按规则检查库内的引用完整性，审计前确认库里的数据可信：
+//   - pod_node_missing：已调度的 Pod 指向 nodes 表里没有的节点（同 /cmdb/stats）
+//   - relation_endpoint_missing：手工关系的一端已不存在（关系在对象删除时故意保留，见 relations.go）
+//   - ref_source_missing：refs 的来源 Pod / Deployment 已不在库里
+//   - history_current_missing：history 最后一条不是 delete，但当前表里没有这一行
+//   - current_history_missing：当前表里有、history 里没有任何记录（history 之前写入的行、关掉触发器导入的行）
+//
+// 修复：关系和 refs 删掉（/admin/check 要两步确认）；history 两条补一条 source=check 的 delete / create 记录；
+// 孤儿 Pod 只能由 informer 判断，只有服务内（/admin/check）能修复：重新入队，缓存里没有的按正常流程删掉。
+const checkExamples = 10
+
+type CheckResult struct {
+    Rule        string `json:"rule"`
+    Description string `json:"description"`
+    Count       int    `json:"count"`
+    // 最多 checkExamples 个，格式因规则而异（namespace/name、relation id、kind/ref）
+    Examples   []string `json:"examples"`
+    Repairable bool     `json:"repairable"`
+    // 修复的行数；孤儿 Pod 为重新入队的数量
+    Repaired int64 `json:"repaired"`
+}
+
+type CheckReport struct {
+    CheckedAt  string `json:"checkedAt"`
+    DurationMs int64  `json:"durationMs"`
+    // 修复之后仍然存在的问题数
+    Problems int           `json:"problems"`
+    Results  []CheckResult `json:"results"`
+}
+
+type checkRule struct {
+    name, desc string
+    // 有问题的行的标识
+    find   func(ctx context.Context, db *sql.DB) ([]string, error)
+    repair func(ctx context.Context, db *sql.DB, found []string) (int64, error)
+    // repair 只是重新入队，结果要等 worker 处理完，这次仍算作问题
+    queued bool
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+    rows, err := db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []string
+    for rows.Next() {
+        var s string
+        if err := rows.Scan(&s); err != nil {
+            return nil, err
+        }
+        out = append(out, s)
+    }
+    return out, rows.Err()
+}
+
+// syncs 为 nil 时（命令行）孤儿 Pod 不能修复
+func checkRules(syncs *syncQueue) []checkRule {
+    rules := []checkRule{
+        {name: "pod_node_missing", desc: "scheduled pods whose node is not in the CMDB",
+            find: func(ctx context.Context, db *sql.DB) ([]string, error) {
+                return queryStrings(ctx, db, `SELECT namespace||'/'||name FROM pods WHERE `+orphanPodCond+` ORDER BY 1`)
+            }},
+        {name: "relation_endpoint_missing", desc: "manual relations with an end that no longer exists",
+            find: func(ctx context.Context, db *sql.DB) ([]string, error) {
+                rows, err := db.QueryContext(ctx, `SELECT id,from_id,to_id FROM relations ORDER BY id`)
+                if err != nil {
+                    return nil, err
+                }
+                type rel struct {
+                    id       int64
+                    from, to string
+                }
+                var rels []rel
+                for rows.Next() {
+                    var r rel
+                    if err := rows.Scan(&r.id, &r.from, &r.to); err != nil {
+                        rows.Close()
+                        return nil, err
+                    }
+                    rels = append(rels, r)
+                }
+                rows.Close()
+                if err := rows.Err(); err != nil {
+                    return nil, err
+                }
+                var out []string
+                for _, r := range rels {
+                    for _, end := range []string{r.from, r.to} {
+                        // 不带请求的 principal，不受 key 的 namespace scope 影响
+                        _, err := loadTopoRoot(context.Background(), db, end)
+                        if errors.Is(err, errTopoRootNotFound) || errors.Is(err, errTopoRootInvalid) {
+                            out = append(out, fmt.Sprint(r.id))
+                            break
+                        }
+                        if err != nil {
+                            return nil, err
+                        }
+                    }
+                }
+                return out, nil
+            },
+            repair: func(ctx context.Context, db *sql.DB, found []string) (int64, error) {
+                var n int64
+                err := withChangeSource(db, "check", func(tx *sql.Tx) error {
+                    for _, id := range found {
+                        res, err := tx.ExecContext(ctx, `DELETE FROM relations WHERE id=?`, id)
+                        if err != nil {
+                            return err
+                        }
+                        m, _ := res.RowsAffected()
+                        n += m
+                    }
+                    return nil
+                })
+                return n, err
+            }},
+        {name: "ref_source_missing", desc: "references whose source pod or deployment is not in the CMDB",
+            find: func(ctx context.Context, db *sql.DB) ([]string, error) {
+                return queryStrings(ctx, db, `SELECT DISTINCT src_kind||'/'||src_ref FROM refs WHERE `+refSourceMissingCond+` ORDER BY 1`)
+            },
+            repair: func(ctx context.Context, db *sql.DB, found []string) (int64, error) {
+                res, err := db.ExecContext(ctx, `DELETE FROM refs WHERE `+refSourceMissingCond)
+                if err != nil {
+                    return 0, err
+                }
+                return res.RowsAffected()
+            }},
+        {name: "history_current_missing", desc: "objects whose last history record is not a delete but that have no current row",
+            find: func(ctx context.Context, db *sql.DB) ([]string, error) {
+                var out []string
+                for _, h := range historySources {
+                    refs, err := queryStrings(ctx, db, `SELECT c.kind||'/'||c.ref FROM changes c`+historyOpenJoin(h)+` ORDER BY c.ref`, h.Kind)
+                    if err != nil {
+                        return nil, err
+                    }
+                    out = append(out, refs...)
+                }
+                return out, nil
+            },
+            repair: func(ctx context.Context, db *sql.DB, found []string) (int64, error) {
+                var n int64
+                now := time.Now().UTC().Format(time.RFC3339)
+                for _, h := range historySources {
+                    res, err := db.ExecContext(ctx, `INSERT INTO changes(kind,ref,namespace,name,op,before,after,source,ts)
+SELECT c.kind,c.ref,c.namespace,c.name,'delete',c.after,NULL,'check',? FROM changes c`+historyOpenJoin(h), now, h.Kind)
+                    if err != nil {
+                        return n, err
+                    }
+                    m, _ := res.RowsAffected()
+                    n += m
+                }
+                return n, nil
+            }},
+        {name: "current_history_missing", desc: "current rows without any history record",
+            find: func(ctx context.Context, db *sql.DB) ([]string, error) {
+                var out []string
+                for _, h := range historySources {
+                    refs, err := queryStrings(ctx, db, `SELECT '`+h.Kind+`/'||t.`+h.Key+` FROM `+h.Table+` t WHERE `+historyAbsentCond(h)+` ORDER BY 1`, h.Kind)
+                    if err != nil {
+                        return nil, err
+                    }
+                    out = append(out, refs...)
+                }
+                return out, nil
+            },
+            repair: func(ctx context.Context, db *sql.DB, found []string) (int64, error) {
+                var n int64
+                now := time.Now().UTC().Format(time.RFC3339)
+                for _, h := range historySources {
+                    res, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO changes(kind,ref,namespace,name,op,before,after,source,ts)
+SELECT '%s',t.%s,%s,%s,'create',NULL,%s,'check',? FROM %s t WHERE %s`,
+                        h.Kind, h.Key, h.col(h.Namespace, "t"), h.col(h.Name, "t"), h.jsonObject("t"), h.Table, historyAbsentCond(h)), now, h.Kind)
+                    if err != nil {
+                        return n, err
+                    }
+                    m, _ := res.RowsAffected()
+                    n += m
+                }
+                return n, nil
+            }},
+    }
+    if syncs != nil {
+        rules[0].queued = true
+        rules[0].repair = func(ctx context.Context, db *sql.DB, found []string) (int64, error) {
+            for _, key := range found {
+                syncs.push(syncItem{kind: "pods", key: key})
+            }
+            return int64(len(found)), nil
+        }
+    }
+    return rules
+}
+
+// refs 的来源由删除触发器清理，这里兜底触发器之外的删除
+const refSourceMissingCond = `(src_kind='Pod' AND src_ref NOT IN (SELECT uid FROM pods)) OR
+ (src_kind='Deployment' AND src_ref NOT IN (SELECT uid FROM deployments))`
+
+// 每个 ref 最后一条记录不是 delete、当前表里却没有；参数为 kind。key 可能是整数列，统一按文本比较
+func historyOpenJoin(h historySource) string {
+    return ` JOIN (SELECT max(id) id FROM changes WHERE kind=? GROUP BY ref) m ON m.id=c.id
+ WHERE c.op<>'delete' AND c.ref NOT IN (SELECT CAST(` + h.Key + ` AS TEXT) FROM ` + h.Table + `)`
+}
+
+// 参数为 kind
+func historyAbsentCond(h historySource) string {
+    return `CAST(t.` + h.Key + ` AS TEXT) NOT IN (SELECT ref FROM changes WHERE kind=?)`
+}
+
+func runCheck(ctx context.Context, db *sql.DB, rules []checkRule, repair bool) (*CheckReport, error) {
+    start := time.Now()
+    rep := &CheckReport{CheckedAt: start.UTC().Format(time.RFC3339), Results: []CheckResult{}}
+    for _, rule := range rules {
+        found, err := rule.find(ctx, db)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", rule.name, err)
+        }
+        res := CheckResult{Rule: rule.name, Description: rule.desc, Count: len(found),
+            Examples: found[:min(len(found), checkExamples)], Repairable: rule.repair != nil}
+        if res.Examples == nil {
+            res.Examples = []string{}
+        }
+        remaining := len(found)
+        if repair && rule.repair != nil && len(found) > 0 {
+            if res.Repaired, err = rule.repair(ctx, db, found); err != nil {
+                return nil, fmt.Errorf("repair %s: %w", rule.name, err)
+            }
+            log.Printf("[check] %s: %d found, %d repaired", rule.name, len(found), res.Repaired)
+            if !rule.queued {
+                remaining = 0
+            }
+        }
+        rep.Problems += remaining
+        rep.Results = append(rep.Results, res)
+    }
+    rep.DurationMs = time.Since(start).Milliseconds()
+    return rep, nil
+}
+
+// 修复会删掉手工关系和 refs，和 /admin/purge 一样两步确认：dry run 按规则列出要修的行
+func checkRepairOp(db *sql.DB, syncs *syncQueue) destructiveOp {
+    return destructiveOp{
+        Name: "check-repair",
+        Impact: func(r *http.Request) (*Impact, error) {
+            imp := &Impact{}
+            for _, rule := range checkRules(syncs) {
+                if rule.repair == nil {
+                    continue
+                }
+                found, err := rule.find(r.Context(), db)
+                if err != nil {
+                    return nil, fmt.Errorf("%s: %w", rule.name, err)
+                }
+                imp.Rows += int64(len(found))
+                for _, id := range found {
+                    imp.Sample = append(imp.Sample, rule.name+" "+id)
+                }
+            }
+            return imp, nil
+        },
+        Execute: func(r *http.Request, _ map[string]string) (int64, error) {
+            rep, err := runCheck(r.Context(), db, checkRules(syncs), true)
+            if err != nil {
+                return 0, err
+            }
+            var n int64
+            for _, res := range rep.Results {
+                n += res.Repaired
+            }
+            return n, nil
+        },
+    }
+}
+
+// GET /admin/check 只检查；POST 修复（两步确认，见 checkRepairOp）
+func checkAPI(db *sql.DB, syncs *syncQueue) http.HandlerFunc {
+    repair := confirmedHandler(checkRepairOp(db, syncs))
+    return func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+        case http.MethodPost:
+            if syncs.dryRun {
+                http.Error(w, "repair is disabled in --dry-run mode", 409)
+                return
+            }
+            repair(w, r)
+            return
+        default:
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        rep, err := runCheck(r.Context(), db, checkRules(syncs), false)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, rep)
+    }
+}
+
+// lightcmdb check [-repair] [-json]：服务停着也能跑；还有问题时退出码为 1
+func runCheckCommand(args []string, stdout io.Writer) error {
+    fs := flag.NewFlagSet("check", flag.ContinueOnError)
+    repair := fs.Bool("repair", false, "fix what can be fixed without the cluster")
+    asJSON := fs.Bool("json", false, "print the same JSON as GET /admin/check")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    cfg, err := loadConfig()
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat(filepath.Join(cfg.Storage.DataDir, dbFile)); err != nil {
+        return err
+    }
+    db, err := openMigratedDB(cfg)
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    rep, err := runCheck(context.Background(), db, checkRules(nil), *repair)
+    if err != nil {
+        return err
+    }
+    if *asJSON {
+        enc := json.NewEncoder(stdout)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(rep); err != nil {
+            return err
+        }
+    } else {
+        tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+        fmt.Fprintln(tw, "RULE\tCOUNT\tREPAIRED\tEXAMPLES")
+        for _, res := range rep.Results {
+            repaired := fmt.Sprint(res.Repaired)
+            if !res.Repairable {
+                repaired = "-"
+            }
+            fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", res.Rule, res.Count, repaired, strings.Join(res.Examples, ","))
+        }
+        tw.Flush()
+    }
+    if rep.Problems > 0 {
+        return fmt.Errorf("%d problems found", rep.Problems)
+    }
+    return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "slices"
+    "testing"
+)
+
+// POST /admin/check 先只返回要修的行，带上 token 才删
+func TestCheckRepairIsTwoPhase(t *testing.T) {
+    db := newTestDB(t)
+    if _, err := db.Exec(`INSERT INTO relations(from_id,to_id,type) VALUES('node/gone','host/10.0.0.9','runs-on')`); err != nil {
+        t.Fatal(err)
+    }
+    h := checkAPI(db, newSyncQueue(db, nil))
+    relations := countRows(db, `SELECT count(*) FROM relations`)
+
+    rec := httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodPost, "/admin/check", nil))
+    if rec.Code != 200 {
+        t.Fatalf("dry run: %d %s", rec.Code, rec.Body)
+    }
+    var dry struct {
+        DryRun       bool   `json:"dryRun"`
+        Impact       Impact `json:"impact"`
+        ConfirmToken string `json:"confirmToken"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &dry); err != nil {
+        t.Fatal(err)
+    }
+    if !dry.DryRun || dry.ConfirmToken == "" || !slices.Contains(dry.Impact.Sample, "relation_endpoint_missing 1") {
+        t.Fatalf("dry run = %s", rec.Body)
+    }
+    if n, err := relations(); err != nil || n != 1 {
+        t.Fatalf("relations after dry run = %d, %v", n, err)
+    }
+
+    rec = httptest.NewRecorder()
+    h(rec, httptest.NewRequest(http.MethodPost, "/admin/check?confirm="+dry.ConfirmToken, nil))
+    if rec.Code != 200 {
+        t.Fatalf("confirm: %d %s", rec.Code, rec.Body)
+    }
+    if n, err := relations(); err != nil || n != 0 {
+        t.Fatalf("relations after repair = %d, %v", n, err)
+    }
+}
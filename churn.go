@@ -0,0 +1,210 @@
+package main
+
+import (
+    "cmp"
+    "database/sql"
+    "log"
+    "math"
+    "net/http"
+    "slices"
+    "strconv"
+    "time"
+)
+
+// ---------- Pod churn ----------
+
+// 按 namespace 统计 history 里 Pod 的 create / delete：最近一个 window 的次数和之前 baseline 内每个 window 的均值、标准差比较，
+// score = (当前 - 均值) / max(标准差, 1)。score >= threshold 且当前次数 >= min 时标为异常，
+// 用来提前发现 crashloop 风暴、失控的 operator 反复建删 Pod。
+// history 没有覆盖完整 baseline 时（刚装上、导入的库）只用覆盖到的 window，一个都没有时不标异常。
+const (
+    maxChurnWindow   = 24 * time.Hour
+    maxChurnBaseline = 30 * 24 * time.Hour
+)
+
+type ChurnRow struct {
+    Namespace string `json:"namespace"`
+    // 最近一个 window 内
+    Created int64 `json:"created"`
+    Deleted int64 `json:"deleted"`
+    Churn   int64 `json:"churn"`
+    // baseline 内每个 window 的 churn
+    BaselineWindows int     `json:"baselineWindows"`
+    BaselineMean    float64 `json:"baselineMean"`
+    BaselineStddev  float64 `json:"baselineStddev"`
+    Score           float64 `json:"score"`
+    Anomalous       bool    `json:"anomalous"`
+}
+
+type churnParams struct {
+    window, baseline time.Duration
+    threshold        float64
+    min              int64
+}
+
+func computeChurn(r *http.Request, db *sql.DB, ns string, p churnParams, now time.Time) ([]ChurnRow, error) {
+    end := now.UTC()
+    cur := end.Add(-p.window)
+    start := cur.Add(-p.baseline)
+    windows := int(p.baseline / p.window)
+    // 最早的 history 之前的 window 不算进 baseline
+    var first sql.NullString
+    if err := db.QueryRowContext(r.Context(), `SELECT min(ts) FROM changes`).Scan(&first); err != nil {
+        return nil, err
+    }
+    if t, err := time.Parse(time.RFC3339, first.String); err == nil && t.After(start) {
+        windows = max(int(cur.Sub(t)/p.window), 0)
+        start = cur.Add(-time.Duration(windows) * p.window)
+    }
+    cond, condArgs := "kind='pod' AND op IN ('create','delete') AND ts>=? AND ts<?", []any{start.Format(time.RFC3339), end.Format(time.RFC3339)}
+    if ns != "" {
+        cond, condArgs = cond+" AND namespace=?", append(condArgs, ns)
+    }
+    where, args := scopeOf(r.Context()).where("namespace", cond, condArgs...)
+    rows, err := db.QueryContext(r.Context(), `SELECT coalesce(namespace,''),op,ts FROM changes`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    type nsChurn struct {
+        row     ChurnRow
+        buckets []int64
+    }
+    byNS := map[string]*nsChurn{}
+    for rows.Next() {
+        var ns, op, raw string
+        if err := rows.Scan(&ns, &op, &raw); err != nil {
+            return nil, err
+        }
+        ts, err := time.Parse(time.RFC3339, raw)
+        if err != nil {
+            continue
+        }
+        c := byNS[ns]
+        if c == nil {
+            c = &nsChurn{row: ChurnRow{Namespace: ns}, buckets: make([]int64, windows)}
+            byNS[ns] = c
+        }
+        if !ts.Before(cur) {
+            if op == "create" {
+                c.row.Created++
+            } else {
+                c.row.Deleted++
+            }
+            continue
+        }
+        if i := int(ts.Sub(start) / p.window); i >= 0 && i < windows {
+            c.buckets[i]++
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    out := make([]ChurnRow, 0, len(byNS))
+    for _, c := range byNS {
+        row := c.row
+        row.Churn = row.Created + row.Deleted
+        row.BaselineWindows = windows
+        if windows > 0 {
+            var sum float64
+            for _, n := range c.buckets {
+                sum += float64(n)
+            }
+            row.BaselineMean = sum / float64(windows)
+            var sq float64
+            for _, n := range c.buckets {
+                sq += (float64(n) - row.BaselineMean) * (float64(n) - row.BaselineMean)
+            }
+            row.BaselineStddev = math.Sqrt(sq / float64(windows))
+            row.Score = (float64(row.Churn) - row.BaselineMean) / max(row.BaselineStddev, 1)
+            row.Anomalous = row.Churn >= p.min && row.Score >= p.threshold
+        }
+        out = append(out, row)
+    }
+    // score 高的在前
+    slices.SortFunc(out, func(a, b ChurnRow) int {
+        if c := cmp.Compare(b.Score, a.Score); c != 0 {
+            return c
+        }
+        return cmp.Compare(a.Namespace, b.Namespace)
+    })
+    return out, nil
+}
+
+func parseChurnParams(r *http.Request) (churnParams, string) {
+    q := r.URL.Query()
+    p := churnParams{window: time.Hour, baseline: 24 * time.Hour, threshold: 3, min: 10}
+    for _, d := range []struct {
+        name  string
+        limit time.Duration
+        dst   *time.Duration
+    }{{"window", maxChurnWindow, &p.window}, {"baseline", maxChurnBaseline, &p.baseline}} {
+        v := q.Get(d.name)
+        if v == "" {
+            continue
+        }
+        n, err := time.ParseDuration(v)
+        if err != nil || n <= 0 {
+            return p, d.name + " must be a positive duration such as 30m or 1h"
+        }
+        if n > d.limit {
+            return p, d.name + " must not exceed " + d.limit.String()
+        }
+        *d.dst = n
+    }
+    if p.baseline < 2*p.window {
+        return p, "baseline must be at least twice the window"
+    }
+    if v := q.Get("threshold"); v != "" {
+        f, err := strconv.ParseFloat(v, 64)
+        if err != nil || f <= 0 || math.IsInf(f, 0) {
+            return p, "threshold must be a positive number"
+        }
+        p.threshold = f
+    }
+    if v := q.Get("min"); v != "" {
+        n, err := strconv.ParseInt(v, 10, 64)
+        if err != nil || n < 1 {
+            return p, "min must be a positive integer"
+        }
+        p.min = n
+    }
+    return p, ""
+}
+
+// GET /cmdb/churn?window=1h&baseline=24h&threshold=3&min=10&ns=&anomalous=true[&format=csv]
+func churnAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        p, msg := parseChurnParams(r)
+        if msg != "" {
+            http.Error(w, msg, 400)
+            return
+        }
+        q := r.URL.Query()
+        list, err := computeChurn(r, db, q.Get("ns"), p, time.Now())
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "churn", ChurnRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        onlyAnomalous := q.Get("anomalous") == "true"
+        for _, row := range list {
+            if onlyAnomalous && !row.Anomalous {
+                continue
+            }
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write churn: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
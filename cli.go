@@ -0,0 +1,131 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "text/tabwriter"
+)
+
+// ---------- Command line ----------
+
+// lightcmdb <command> [flags]：子命令共用 loadConfig（$LIGHTCMDB_CONFIG 或 ./lightcmdb.yaml）和建表逻辑。
+// 不带子命令、或第一个参数是 flag 时等同于 serve，和以前的启动方式兼容。
+// lightcmdbctl 可以是指向本二进制的链接，也可以写成 lightcmdb ctl。
+
+type cliCommand struct {
+    name, summary string
+    run           func(args []string) error
+}
+
+func cliCommands() []cliCommand {
+    return []cliCommand{
+        {"serve", "sync the cluster and serve the HTTP API (default)", runServe},
+        {"migrate", "create or upgrade the DB schema, then exit", runMigrate},
+        {"check", "check referential integrity of the DB, optionally repair", func(args []string) error { return runCheckCommand(args, os.Stdout) }},
+        {"export", "write the whole DB to a tar.gz archive", func(args []string) error { return runArchiveExport(args, os.Stdout) }},
+        {"import", "load an archive written by export", func(args []string) error { return runArchiveImport(args, os.Stdin) }},
+        {"ctl", "query a running instance over its HTTP API", func(args []string) error { return runCtl(args, os.Stdout) }},
+        {"tui", "terminal UI for a running instance", runTUI},
+        {"version", "print version and build information", func(args []string) error { return runVersion(args, os.Stdout) }},
+    }
+}
+
+func runCLI(argv0 string, args []string) int {
+    if filepath.Base(argv0) == "lightcmdbctl" {
+        return cliExit("lightcmdbctl", runCtl(args, os.Stdout))
+    }
+    name := "serve"
+    if len(args) > 0 && (len(args[0]) > 0 && args[0][0] != '-' || args[0] == "-h" || args[0] == "--help") {
+        name, args = args[0], args[1:]
+    }
+    if name == "help" || name == "-h" || name == "--help" {
+        cliUsage(os.Stdout)
+        return 0
+    }
+    for _, c := range cliCommands() {
+        if c.name == name {
+            return cliExit(name, c.run(args))
+        }
+    }
+    fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+    cliUsage(os.Stderr)
+    return 2
+}
+
+func cliExit(name string, err error) int {
+    switch {
+    case err == nil, errors.Is(err, flag.ErrHelp):
+        return 0
+    default:
+        fmt.Fprintln(os.Stderr, name+":", err)
+        return 1
+    }
+}
+
+func cliUsage(w io.Writer) {
+    fmt.Fprintln(w, "usage: lightcmdb <command> [flags]")
+    fmt.Fprintln(w)
+    tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+    for _, c := range cliCommands() {
+        fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+    }
+    tw.Flush()
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "The config file is $LIGHTCMDB_CONFIG, or ./lightcmdb.yaml if present.")
+}
+
+// serve、migrate、import 共用：打开 storage.dataDir 下的库并建表 / 补列
+func openMigratedDB(cfg *Config) (*sql.DB, error) {
+    if err := applyProjections(cfg.Projections); err != nil {
+        return nil, fmt.Errorf("projections: %w", err)
+    }
+    db, err := openDB(cfg.Storage.DataDir)
+    if err != nil {
+        return nil, err
+    }
+    if err := initSchema(db); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("init schema: %w", err)
+    }
+    if err := initFederationSchema(db, cfg.Federation.Mode); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("init federation schema: %w", err)
+    }
+    if err := setupFieldEncryption(db, cfg.Storage.Encryption); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return db, nil
+}
+
+// lightcmdb migrate：升级版本前单独跑一遍建表，不连集群、不起 HTTP
+func runMigrate(args []string) error {
+    fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    cfg, err := loadConfig()
+    if err != nil {
+        return err
+    }
+    restrictUmask()
+    if err := os.MkdirAll(cfg.Storage.DataDir, 0o700); err != nil {
+        return err
+    }
+    db, err := openMigratedDB(cfg)
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    var tables int
+    if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite%'`).Scan(&tables); err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stderr, "schema up to date: %s (%d tables)\n", filepath.Join(cfg.Storage.DataDir, dbFile), tables)
+    return nil
+}
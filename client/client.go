@@ -0,0 +1,82 @@
+// Package client 是 LightCMDB HTTP API 的 Go 客户端，给其他内部服务用，不用自己拼 URL、解 JSON：
+//
+//	c := client.New("https://cmdb.example.com", os.Getenv("LIGHTCMDB_TOKEN"))
+//	pods, err := c.Pods().List(ctx, client.PodListOptions{Namespace: "prod"})
+//
+// 返回的类型就是服务端输出的 DTO，和 /openapi.json 里同名的 schema 一致。
+// lightcmdb ctl / tui 也通过它访问 API。
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+type Client struct {
+    base  string
+    token string
+    // 默认 10s 超时的 http.Client；需要代理、自签证书时替换
+    HTTPClient *http.Client
+    // 服务端按 User-Agent 区分调用方（/admin/consumers），建议设成服务名
+    UserAgent string
+}
+
+// token 为 API key 或 OIDC ID token，为空时不带认证头（只能访问公开接口）
+func New(baseURL, token string) *Client {
+    return &Client{
+        base:       strings.TrimRight(baseURL, "/"),
+        token:      token,
+        HTTPClient: &http.Client{Timeout: 10 * time.Second},
+        UserAgent:  "lightcmdb-go-client",
+    }
+}
+
+func (c *Client) BaseURL() string { return c.base }
+
+// 非 200 响应
+type APIError struct {
+    Path       string
+    StatusCode int
+    Status     string
+    // 响应体的前 512 字节
+    Message string
+}
+
+func (e *APIError) Error() string {
+    return fmt.Sprintf("%s: %s %s", e.Path, e.Status, e.Message)
+}
+
+// GET path?q，把 JSON 响应解到 out；没有对应方法的接口直接用它
+func (c *Client) Get(ctx context.Context, path string, q url.Values, out any) error {
+    u := c.base + path
+    if len(q) > 0 {
+        u += "?" + q.Encode()
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Accept", "application/json")
+    if c.UserAgent != "" {
+        req.Header.Set("User-Agent", c.UserAgent)
+    }
+    if c.token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.token)
+    }
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return &APIError{Path: path, StatusCode: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(b))}
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+    "context"
+    "net/url"
+    "strconv"
+)
+
+// ---------- Resources ----------
+
+// 空字段不加到查询参数里，和服务端不带该参数时的行为一致
+
+type PodListOptions struct {
+    Namespace string
+    // 所属团队，见 ownership
+    Team string
+    // 例如 app=web,tier in (api,db)
+    LabelSelector string
+}
+
+type NodeListOptions struct {
+    // sriov、gpu、tpu、fpga，逗号分隔时要求全部具备
+    Capability    string
+    LabelSelector string
+}
+
+type AssetListOptions struct {
+    Type, Site, Owner string
+    // manual 或外部系统名
+    Source        string
+    LabelSelector string
+}
+
+type SearchOptions struct {
+    // pod、node、service 或 deployment
+    Type string
+    // 0 为服务端默认（50），最多 500
+    Limit int
+}
+
+type PodsClient struct{ c *Client }
+
+func (c *Client) Pods() *PodsClient { return &PodsClient{c} }
+
+// GET /cmdb/pods
+func (p *PodsClient) List(ctx context.Context, opts PodListOptions) ([]PodRow, error) {
+    var out []PodRow
+    err := p.c.Get(ctx, "/cmdb/pods", query("ns", opts.Namespace, "team", opts.Team, "labelSelector", opts.LabelSelector), &out)
+    return out, err
+}
+
+type NodesClient struct{ c *Client }
+
+func (c *Client) Nodes() *NodesClient { return &NodesClient{c} }
+
+// GET /cmdb/nodes
+func (n *NodesClient) List(ctx context.Context, opts NodeListOptions) ([]NodeRow, error) {
+    var out []NodeRow
+    err := n.c.Get(ctx, "/cmdb/nodes", query("capability", opts.Capability, "labelSelector", opts.LabelSelector), &out)
+    return out, err
+}
+
+type AssetsClient struct{ c *Client }
+
+func (c *Client) Assets() *AssetsClient { return &AssetsClient{c} }
+
+// GET /cmdb/assets
+func (a *AssetsClient) List(ctx context.Context, opts AssetListOptions) ([]AssetRow, error) {
+    var out []AssetRow
+    err := a.c.Get(ctx, "/cmdb/assets", query("type", opts.Type, "site", opts.Site, "owner", opts.Owner, "source", opts.Source, "labelSelector", opts.LabelSelector), &out)
+    return out, err
+}
+
+// GET /cmdb/search?q=，多个词之间为 AND、按前缀匹配
+func (c *Client) Search(ctx context.Context, q string, opts SearchOptions) ([]SearchHit, error) {
+    var limit string
+    if opts.Limit > 0 {
+        limit = strconv.Itoa(opts.Limit)
+    }
+    var out []SearchHit
+    err := c.Get(ctx, "/cmdb/search", query("q", q, "type", opts.Type, "limit", limit), &out)
+    return out, err
+}
+
+// 成对的 name, value，跳过空值
+func query(kv ...string) url.Values {
+    q := url.Values{}
+    for i := 0; i+1 < len(kv); i += 2 {
+        if kv[i+1] != "" {
+            q.Set(kv[i], kv[i+1])
+        }
+    }
+    return q
+}
@@ -0,0 +1,89 @@
+package client
+
+// ---------- HTTP DTO ----------
+
+// 服务端（package main）通过类型别名直接输出这些类型，字段改动两边同时生效
+
+type PodRow struct {
+    UID       string `json:"uid"`
+    Name      string `json:"name"`
+    Namespace string `json:"namespace"`
+    Phase     string `json:"phase"`
+    NodeName  string `json:"nodeName"`
+    PodIP     string `json:"podIP"`
+    Labels    string `json:"labels"`
+    // 毫核 / 字节
+    CPURequest    int64 `json:"cpuRequestMilli"`
+    MemoryRequest int64 `json:"memoryRequestBytes"`
+    // 扩展资源和 hugepages 的 requests，name=数量，例如 nvidia.com/gpu=1
+    ExtendedRequests string `json:"extendedRequests"`
+    // metrics-server 的最近一次采样（各容器之和），未开启或没有数据时 usageSampledAt 为空
+    CPUUsage       int64  `json:"cpuUsageMilli"`
+    MemoryUsage    int64  `json:"memoryUsageBytes"`
+    UsageSampledAt string `json:"usageSampledAt"`
+    // 用户设置的 k=v,k=v，见服务端 attributes.go
+    Attributes string `json:"attributes"`
+    // 所在 namespace 的团队，见服务端 ownership.go
+    Team      string `json:"team"`
+    UpdatedAt string `json:"updatedAt"`
+}
+
+type NodeRow struct {
+    Name   string `json:"name"`
+    Labels string `json:"labels"`
+    CPU    string `json:"cpu"`
+    Memory string `json:"memory"`
+    // 扩展资源和 hugepages 的 capacity / allocatable，name=数量
+    CapacityExtended    string `json:"capacityExtended"`
+    AllocatableExtended string `json:"allocatableExtended"`
+    InternalIP          string `json:"internalIP"`
+    // 硬件类别（sriov,gpu,tpu,fpga），见服务端 nodehardware.go
+    Capabilities string `json:"capabilities"`
+    // device plugin 的扩展资源 allocatable，name=数量
+    Devices string `json:"devices"`
+    // 各类别可分配的设备数（SR-IOV 为 VF 数）
+    SRIOVCount int64 `json:"sriovCount"`
+    GPUCount   int64 `json:"gpuCount"`
+    TPUCount   int64 `json:"tpuCount"`
+    FPGACount  int64 `json:"fpgaCount"`
+    // spec.unschedulable，cordon 之后为 true
+    Unschedulable bool `json:"unschedulable"`
+    // topology.kubernetes.io/zone 和 region，没有标签时为空
+    Zone   string `json:"zone"`
+    Region string `json:"region"`
+    // status.nodeInfo 的 architecture、operatingSystem 和 containerRuntimeVersion（如 containerd://1.7.13）
+    Architecture     string `json:"architecture"`
+    OS               string `json:"os"`
+    ContainerRuntime string `json:"containerRuntime"`
+    // metrics-server 的最近一次采样，未开启或没有数据时 usageSampledAt 为空，见服务端 nodeusage.go
+    CPUUsageMilli            int64   `json:"cpuUsageMilli"`
+    MemoryUsageBytes         int64   `json:"memoryUsageBytes"`
+    CPUUtilizationPercent    float64 `json:"cpuUtilizationPercent"`
+    MemoryUtilizationPercent float64 `json:"memoryUtilizationPercent"`
+    UsageSampledAt           string  `json:"usageSampledAt"`
+    Attributes               string  `json:"attributes"`
+    UpdatedAt                string  `json:"updatedAt"`
+}
+
+// 列表输出用扁平的 labels，CSV 才有意义
+type AssetRow struct {
+    ID         int64  `json:"id"`
+    Type       string `json:"type"`
+    Name       string `json:"name"`
+    Site       string `json:"site"`
+    Owner      string `json:"owner"`
+    Labels     string `json:"labels"`
+    Attributes string `json:"attributes"`
+    UpdatedAt  string `json:"updatedAt"`
+    // manual，或通过 /cmdb/external 推送它的系统
+    Source     string `json:"source"`
+    ReportedAt string `json:"reportedAt"`
+}
+
+type SearchHit struct {
+    Type      string `json:"type"`
+    ID        string `json:"id"`
+    Name      string `json:"name"`
+    Namespace string `json:"namespace,omitempty"`
+    Match     string `json:"match"`
+}
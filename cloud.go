@@ -0,0 +1,373 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ---------- Cloud assets ----------
+
+// 云上的实例、磁盘和安全组。每个 provider 实现 cloudCollector，按 region 返回完整清单；一个 region 成功后整体替换该 region 的行
+// （更新已有的、删掉这次没返回的），失败时保留上次的结果。实例按 provider ID（Node.spec.providerID 的写法）关联到 Kubernetes 节点。
+// 目前只有 aws（aws.go），新 provider 加到 cloudProviders。
+type cloudInstance struct {
+    ID         string
+    Name       string
+    Type       string
+    State      string
+    Zone       string
+    PrivateIP  string
+    PublicIP   string
+    VPC        string
+    Groups     string // 安全组 ID，逗号分隔
+    Tags       map[string]string
+    LaunchedAt string
+    // 与 Node.spec.providerID 相同的格式，例如 aws:///us-east-1a/i-0abc
+    ProviderID string
+}
+
+type cloudVolume struct {
+    ID         string
+    Type       string
+    SizeGB     int64
+    State      string
+    Zone       string
+    AttachedTo string // 实例 ID
+    Tags       map[string]string
+}
+
+type cloudSecurityGroup struct {
+    ID          string
+    Name        string
+    VPC         string
+    Description string
+    // 入站规则，逗号分隔的 <协议>:<端口范围> from <来源>
+    Ingress string
+    Tags    map[string]string
+}
+
+type cloudInventory struct {
+    Instances      []cloudInstance
+    Volumes        []cloudVolume
+    SecurityGroups []cloudSecurityGroup
+}
+
+type cloudCollector interface {
+    Provider() string
+    Regions() []string
+    Collect(ctx context.Context, region string) (*cloudInventory, error)
+}
+
+var cloudProviders = map[string]func(CloudProviderConfig) (cloudCollector, error){
+    "aws": newAWSCollector,
+}
+
+var cloudTables = []string{"cloud_instances", "cloud_volumes", "cloud_security_groups"}
+
+func initCloud(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS cloud_instances(
+    provider TEXT NOT NULL,
+    region TEXT NOT NULL,
+    id TEXT NOT NULL,
+    name TEXT,
+    type TEXT,
+    state TEXT,
+    zone TEXT,
+    private_ip TEXT,
+    public_ip TEXT,
+    vpc TEXT,
+    security_groups TEXT,
+    tags TEXT,
+    launched_at TEXT,
+    provider_id TEXT,
+    synced_at TEXT NOT NULL,
+    PRIMARY KEY(provider, id)
+);`, `
+CREATE TABLE IF NOT EXISTS cloud_volumes(
+    provider TEXT NOT NULL,
+    region TEXT NOT NULL,
+    id TEXT NOT NULL,
+    type TEXT,
+    size_gb INTEGER,
+    state TEXT,
+    zone TEXT,
+    attached_to TEXT,
+    tags TEXT,
+    synced_at TEXT NOT NULL,
+    PRIMARY KEY(provider, id)
+);`, `
+CREATE TABLE IF NOT EXISTS cloud_security_groups(
+    provider TEXT NOT NULL,
+    region TEXT NOT NULL,
+    id TEXT NOT NULL,
+    name TEXT,
+    vpc TEXT,
+    description TEXT,
+    ingress TEXT,
+    tags TEXT,
+    synced_at TEXT NOT NULL,
+    PRIMARY KEY(provider, id)
+);`,
+        `CREATE INDEX IF NOT EXISTS idx_cloud_instances_provider_id ON cloud_instances(provider_id)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    // 关联实例用；旧库补列
+    return addColumnIfMissing(db, "nodes", "provider_id", "TEXT")
+}
+
+func storeCloudInventory(db *sql.DB, provider, region string, inv *cloudInventory) error {
+    now := time.Now().UTC().Format(time.RFC3339Nano)
+    return withChangeSource(db, "cloud:"+provider, func(tx *sql.Tx) error {
+        for _, i := range inv.Instances {
+            if _, err := tx.Exec(`
+INSERT INTO cloud_instances(provider,region,id,name,type,state,zone,private_ip,public_ip,vpc,security_groups,tags,launched_at,provider_id,synced_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(provider,id) DO UPDATE SET
+ region=excluded.region,
+ name=excluded.name,
+ type=excluded.type,
+ state=excluded.state,
+ zone=excluded.zone,
+ private_ip=excluded.private_ip,
+ public_ip=excluded.public_ip,
+ vpc=excluded.vpc,
+ security_groups=excluded.security_groups,
+ tags=excluded.tags,
+ launched_at=excluded.launched_at,
+ provider_id=excluded.provider_id,
+ synced_at=excluded.synced_at
+`, provider, region, i.ID, i.Name, i.Type, i.State, i.Zone, i.PrivateIP, i.PublicIP, i.VPC, i.Groups, flattenLabels(i.Tags),
+                i.LaunchedAt, i.ProviderID, now); err != nil {
+                return err
+            }
+        }
+        for _, v := range inv.Volumes {
+            if _, err := tx.Exec(`INSERT OR REPLACE INTO cloud_volumes(provider,region,id,type,size_gb,state,zone,attached_to,tags,synced_at)
+VALUES(?,?,?,?,?,?,?,?,?,?)`, provider, region, v.ID, v.Type, v.SizeGB, v.State, v.Zone, v.AttachedTo, flattenLabels(v.Tags), now); err != nil {
+                return err
+            }
+        }
+        for _, g := range inv.SecurityGroups {
+            if _, err := tx.Exec(`INSERT OR REPLACE INTO cloud_security_groups(provider,region,id,name,vpc,description,ingress,tags,synced_at)
+VALUES(?,?,?,?,?,?,?,?,?)`, provider, region, g.ID, g.Name, g.VPC, g.Description, g.Ingress, flattenLabels(g.Tags), now); err != nil {
+                return err
+            }
+        }
+        // 这次没返回的已经不存在了
+        for _, t := range cloudTables {
+            if _, err := tx.Exec(`DELETE FROM `+t+` WHERE provider=? AND region=? AND synced_at<>?`, provider, region, now); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+type cloudSyncer struct {
+    db         *sql.DB
+    collectors []cloudCollector
+    // provider/region -> 上次的错误，同样的错误不重复打日志
+    lastErr map[string]string
+}
+
+func newCloudSyncer(db *sql.DB, cfg CloudConfig) (*cloudSyncer, error) {
+    s := &cloudSyncer{db: db, lastErr: map[string]string{}}
+    for _, p := range cfg.Providers {
+        c, err := cloudProviders[p.Provider](p)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", p.Provider, err)
+        }
+        s.collectors = append(s.collectors, c)
+    }
+    return s, nil
+}
+
+// 各 provider/region 的错误合在一起返回，一个失败不影响其它的
+func (s *cloudSyncer) syncOnce(ctx context.Context) error {
+    var errs []error
+    for _, c := range s.collectors {
+        for _, region := range c.Regions() {
+            key := c.Provider() + "/" + region
+            inv, err := c.Collect(ctx, region)
+            if err == nil {
+                err = storeCloudInventory(s.db, c.Provider(), region, inv)
+            }
+            if err != nil {
+                errs = append(errs, fmt.Errorf("%s: %w", key, err))
+            }
+            switch {
+            case err != nil && err.Error() != s.lastErr[key]:
+                log.Printf("[cloud] %s: %v", key, err)
+                s.lastErr[key] = err.Error()
+            case err == nil:
+                if s.lastErr[key] != "" {
+                    log.Printf("[cloud] %s: available again", key)
+                }
+                delete(s.lastErr, key)
+            }
+        }
+    }
+    return errors.Join(errs...)
+}
+
+type CloudInstanceRow struct {
+    Provider       string `json:"provider"`
+    Region         string `json:"region"`
+    ID             string `json:"id"`
+    Name           string `json:"name"`
+    Type           string `json:"type"`
+    State          string `json:"state"`
+    Zone           string `json:"zone"`
+    PrivateIP      string `json:"privateIP"`
+    PublicIP       string `json:"publicIP"`
+    VPC            string `json:"vpc"`
+    SecurityGroups string `json:"securityGroups"`
+    Tags           string `json:"tags"`
+    LaunchedAt     string `json:"launchedAt"`
+    ProviderID     string `json:"providerID"`
+    // providerID 相同的 Kubernetes 节点，不是节点时为空
+    NodeName string `json:"nodeName"`
+    SyncedAt string `json:"syncedAt"`
+}
+
+type CloudVolumeRow struct {
+    Provider   string `json:"provider"`
+    Region     string `json:"region"`
+    ID         string `json:"id"`
+    Type       string `json:"type"`
+    SizeGB     int64  `json:"sizeGB"`
+    State      string `json:"state"`
+    Zone       string `json:"zone"`
+    AttachedTo string `json:"attachedTo"`
+    NodeName   string `json:"nodeName"`
+    Tags       string `json:"tags"`
+    SyncedAt   string `json:"syncedAt"`
+}
+
+type CloudSecurityGroupRow struct {
+    Provider    string `json:"provider"`
+    Region      string `json:"region"`
+    ID          string `json:"id"`
+    Name        string `json:"name"`
+    VPC         string `json:"vpc"`
+    Description string `json:"description"`
+    Ingress     string `json:"ingress"`
+    Tags        string `json:"tags"`
+    SyncedAt    string `json:"syncedAt"`
+}
+
+// provider、region 精确匹配，tag=k=v 要求带上该标签。云资产不属于任何 namespace，限定 namespace 的 key 看不到
+func cloudFilter(r *http.Request, alias string) (string, []any) {
+    q := r.URL.Query()
+    conds := []string{"1"}
+    var args []any
+    for _, f := range []string{"provider", "region"} {
+        if v := q.Get(f); v != "" {
+            conds = append(conds, alias+"."+f+"=?")
+            args = append(args, v)
+        }
+    }
+    if v := q.Get("tag"); v != "" {
+        conds = append(conds, `(','||`+alias+`.tags||',') LIKE ?`)
+        args = append(args, "%,"+v+",%")
+    }
+    return scopeOf(r.Context()).where("''", strings.Join(conds, " AND "), args...)
+}
+
+func cloudList[T any](db *sql.DB, w http.ResponseWriter, r *http.Request, name, query string, args []any, scan func(*sql.Rows, *T) error) {
+    rows, err := db.QueryContext(r.Context(), query, args...)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    defer rows.Close()
+    var zero T
+    lw, err := newListWriter(w, r, name, zero)
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    for rows.Next() {
+        var row T
+        if err := scan(rows, &row); err != nil {
+            lw.Fail(err)
+            return
+        }
+        if err := lw.Write(row); err != nil {
+            log.Printf("[http] write %s: %v", name, err)
+            return
+        }
+    }
+    if err := rows.Err(); err != nil {
+        lw.Fail(err)
+    }
+    lw.Close()
+}
+
+// GET /cmdb/cloud/instances?provider=&region=&tag=k=v
+func cloudInstancesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        where, args := cloudFilter(r, "i")
+        cloudList(db, w, r, "cloud-instances", `
+SELECT i.provider,i.region,i.id,coalesce(i.name,''),coalesce(i.type,''),coalesce(i.state,''),coalesce(i.zone,''),coalesce(i.private_ip,''),
+ coalesce(i.public_ip,''),coalesce(i.vpc,''),coalesce(i.security_groups,''),coalesce(i.tags,''),coalesce(i.launched_at,''),
+ coalesce(i.provider_id,''),coalesce(n.name,''),i.synced_at
+FROM cloud_instances i LEFT JOIN nodes n ON n.provider_id=i.provider_id AND i.provider_id<>''`+where+` ORDER BY i.provider,i.region,i.id`, args,
+            func(rows *sql.Rows, c *CloudInstanceRow) error {
+                return rows.Scan(&c.Provider, &c.Region, &c.ID, &c.Name, &c.Type, &c.State, &c.Zone, &c.PrivateIP, &c.PublicIP, &c.VPC,
+                    &c.SecurityGroups, &c.Tags, &c.LaunchedAt, &c.ProviderID, &c.NodeName, &c.SyncedAt)
+            })
+    }
+}
+
+// GET /cmdb/cloud/volumes?provider=&region=&tag=k=v
+func cloudVolumesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        where, args := cloudFilter(r, "v")
+        cloudList(db, w, r, "cloud-volumes", `
+SELECT v.provider,v.region,v.id,coalesce(v.type,''),coalesce(v.size_gb,0),coalesce(v.state,''),coalesce(v.zone,''),coalesce(v.attached_to,''),
+ coalesce(n.name,''),coalesce(v.tags,''),v.synced_at
+FROM cloud_volumes v
+ LEFT JOIN cloud_instances i ON i.provider=v.provider AND i.id=v.attached_to
+ LEFT JOIN nodes n ON n.provider_id=i.provider_id AND i.provider_id<>''`+where+` ORDER BY v.provider,v.region,v.id`, args,
+            func(rows *sql.Rows, c *CloudVolumeRow) error {
+                return rows.Scan(&c.Provider, &c.Region, &c.ID, &c.Type, &c.SizeGB, &c.State, &c.Zone, &c.AttachedTo, &c.NodeName, &c.Tags, &c.SyncedAt)
+            })
+    }
+}
+
+// GET /cmdb/cloud/securitygroups?provider=&region=&tag=k=v
+func cloudSecurityGroupsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        where, args := cloudFilter(r, "g")
+        cloudList(db, w, r, "cloud-security-groups", `
+SELECT g.provider,g.region,g.id,coalesce(g.name,''),coalesce(g.vpc,''),coalesce(g.description,''),coalesce(g.ingress,''),coalesce(g.tags,''),g.synced_at
+FROM cloud_security_groups g`+where+` ORDER BY g.provider,g.region,g.id`, args,
+            func(rows *sql.Rows, c *CloudSecurityGroupRow) error {
+                return rows.Scan(&c.Provider, &c.Region, &c.ID, &c.Name, &c.VPC, &c.Description, &c.Ingress, &c.Tags, &c.SyncedAt)
+            })
+    }
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/client-go/kubernetes"
+    corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// ---------- Federation: clusters ----------
+
+// hub 上把每个 edge 集群当作一个 CI：edge 每次拉配置时顺带上报版本、节点（含 Ready）和 Pod 数量，
+// hub 只保存最新一份，/cmdb/clusters 据此给出 health 和最后同步时间，/cmdb/clusters/{site} 带上节点列表。
+type ClusterNode struct {
+    Name           string `json:"name"`
+    Ready          bool   `json:"ready"`
+    InternalIP     string `json:"internalIP,omitempty"`
+    KubeletVersion string `json:"kubeletVersion,omitempty"`
+}
+
+type ClusterReport struct {
+    Site              string        `json:"site"`
+    KubernetesVersion string        `json:"kubernetesVersion"`
+    Nodes             []ClusterNode `json:"nodes"`
+    Pods              int           `json:"pods"`
+    RunningPods       int           `json:"runningPods"`
+    // edge 的 pollInterval，hub 用来判断上报是否过期
+    IntervalSeconds int `json:"intervalSeconds"`
+    // edge 上 LightCMDB 自己和各 collector 的状态，见 instances.go；旧版本的 edge 没有
+    Instance *InstanceReport `json:"instance,omitempty"`
+}
+
+type ClusterRow struct {
+    Site string `json:"site"`
+    // healthy / degraded（有节点 NotReady）/ stale（超过 3 个上报周期没有消息）
+    Health            string `json:"health"`
+    KubernetesVersion string `json:"kubernetesVersion"`
+    Nodes             int    `json:"nodes"`
+    ReadyNodes        int    `json:"readyNodes"`
+    Pods              int    `json:"pods"`
+    RunningPods       int    `json:"runningPods"`
+    LastSync          string `json:"lastSync"`
+    ConfigVersion     string `json:"configVersion,omitempty"`
+    // 详情接口才返回
+    NodeList []ClusterNode `json:"nodeList,omitempty"`
+}
+
+// 没上报周期时按 1 分钟算，至少容忍 5 分钟
+func clusterHealth(reportedAt time.Time, interval time.Duration, nodes, ready int, now time.Time) string {
+    if interval <= 0 {
+        interval = time.Minute
+    }
+    switch {
+    case now.Sub(reportedAt) > max(3*interval, 5*time.Minute):
+        return "stale"
+    case ready < nodes:
+        return "degraded"
+    default:
+        return "healthy"
+    }
+}
+
+// ---------- edge side ----------
+
+type clusterInventory struct {
+    db     *sql.DB
+    client kubernetes.Interface
+    nodes  corelisters.NodeLister
+    self   *selfReporter
+}
+
+func (inv *clusterInventory) collect(cfg FederationConfig) (ClusterReport, error) {
+    rep := ClusterReport{Site: cfg.Site, Nodes: []ClusterNode{}, IntervalSeconds: int(cfg.PollInterval.Seconds())}
+    if inv.self != nil {
+        self := inv.self.current()
+        // hub 上按上报周期判断是否过期
+        self.IntervalSeconds = rep.IntervalSeconds
+        rep.Instance = &self
+    }
+    v, err := inv.client.Discovery().ServerVersion()
+    if err != nil {
+        return rep, err
+    }
+    rep.KubernetesVersion = v.GitVersion
+    nodes, err := inv.nodes.List(labels.Everything())
+    if err != nil {
+        return rep, err
+    }
+    for _, n := range nodes {
+        rep.Nodes = append(rep.Nodes, ClusterNode{Name: n.Name, Ready: nodeStateOf(n).ready, InternalIP: nodeInternalIP(n),
+            KubeletVersion: n.Status.NodeInfo.KubeletVersion})
+    }
+    err = inv.db.QueryRow(`SELECT count(*), coalesce(sum(phase='Running'),0) FROM pods`).Scan(&rep.Pods, &rep.RunningPods)
+    return rep, err
+}
+
+func (s *edgeSyncer) reportCluster() error {
+    rep, err := s.inventory.collect(s.cfg)
+    if err != nil {
+        return err
+    }
+    b, _ := json.Marshal(rep)
+    resp, err := s.do(http.MethodPost, "/federation/clusters", b)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("hub returned %s", resp.Status)
+    }
+    return nil
+}
+
+// ---------- hub side ----------
+
+// POST /federation/clusters 由 edge 上报，整体替换该站点的节点列表
+func fleetClustersAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        var rep ClusterReport
+        if err := json.NewDecoder(io.LimitReader(r.Body, 4<<20)).Decode(&rep); err != nil || rep.Site == "" {
+            http.Error(w, "invalid report", 400)
+            return
+        }
+        if !principalFrom(r.Context()).allowsSite(rep.Site) {
+            http.Error(w, "key not valid for site "+rep.Site, 403)
+            return
+        }
+        if err := storeClusterReport(db, rep); err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+func storeClusterReport(db *sql.DB, rep ClusterReport) error {
+    ready := 0
+    for _, n := range rep.Nodes {
+        if n.Ready {
+            ready++
+        }
+    }
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    _, err = tx.Exec(`
+INSERT INTO fleet_clusters(site,kubernetes_version,node_count,ready_nodes,pod_count,running_pods,interval_s,reported_at)
+VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(site) DO UPDATE SET
+ kubernetes_version=excluded.kubernetes_version,
+ node_count=excluded.node_count,
+ ready_nodes=excluded.ready_nodes,
+ pod_count=excluded.pod_count,
+ running_pods=excluded.running_pods,
+ interval_s=excluded.interval_s,
+ reported_at=excluded.reported_at
+`, rep.Site, rep.KubernetesVersion, len(rep.Nodes), ready, rep.Pods, rep.RunningPods, rep.IntervalSeconds,
+        time.Now().UTC().Format(time.RFC3339))
+    if err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM fleet_cluster_nodes WHERE site=?`, rep.Site); err != nil {
+        return err
+    }
+    for _, n := range rep.Nodes {
+        if _, err := tx.Exec(`INSERT OR REPLACE INTO fleet_cluster_nodes(site,name,ready,internal_ip,kubelet_version) VALUES(?,?,?,?,?)`,
+            rep.Site, n.Name, n.Ready, sealField(n.InternalIP), n.KubeletVersion); err != nil {
+            return err
+        }
+    }
+    if rep.Instance != nil {
+        self := *rep.Instance
+        self.Site = rep.Site
+        if err := storeInstanceReport(tx, self, time.Now()); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func loadClusters(db querier, site string) ([]ClusterRow, error) {
+    where, args := "", []any{}
+    if site != "" {
+        where, args = " WHERE c.site=?", []any{site}
+    }
+    rows, err := db.Query(`
+SELECT c.site,c.kubernetes_version,c.node_count,c.ready_nodes,c.pod_count,c.running_pods,c.interval_s,c.reported_at,coalesce(r.version,'')
+FROM fleet_clusters c LEFT JOIN fleet_rollout r ON r.site=c.site`+where+` ORDER BY c.site`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    now := time.Now()
+    out := []ClusterRow{}
+    for rows.Next() {
+        var c ClusterRow
+        var interval int
+        if err := rows.Scan(&c.Site, &c.KubernetesVersion, &c.Nodes, &c.ReadyNodes, &c.Pods, &c.RunningPods, &interval,
+            &c.LastSync, &c.ConfigVersion); err != nil {
+            return nil, err
+        }
+        reported, _ := time.Parse(time.RFC3339, c.LastSync)
+        c.Health = clusterHealth(reported, time.Duration(interval)*time.Second, c.Nodes, c.ReadyNodes, now)
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+func loadClusterNodes(db querier, site string) ([]ClusterNode, error) {
+    rows, err := db.Query(`SELECT name,ready,cmdb_unseal(internal_ip),kubelet_version FROM fleet_cluster_nodes WHERE site=? ORDER BY name`, site)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := []ClusterNode{}
+    for rows.Next() {
+        var n ClusterNode
+        if err := rows.Scan(&n.Name, &n.Ready, &n.InternalIP, &n.KubeletVersion); err != nil {
+            return nil, err
+        }
+        out = append(out, n)
+    }
+    return out, rows.Err()
+}
+
+// GET /cmdb/clusters 列表；GET /cmdb/clusters/{site} 单个集群及其节点
+// 集群和节点一样不属于任何 namespace，限定 namespace 的 key 看到空列表 / 404
+func clustersAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        scoped := scopeOf(r.Context()) != nil
+        site := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/clusters"), "/")
+        if site == "" {
+            if scoped {
+                writeJSON(w, r, []ClusterRow{})
+                return
+            }
+            out, err := loadClusters(db, "")
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            writeJSON(w, r, out)
+            return
+        }
+        if scoped {
+            http.Error(w, "cluster not found", 404)
+            return
+        }
+        // 汇总行和节点列表在同一快照里读，节点数和 nodeList 一致
+        var out []ClusterRow
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            q := dbFrom(ctx, db)
+            var err error
+            if out, err = loadClusters(q, site); err != nil || len(out) == 0 {
+                return err
+            }
+            out[0].NodeList, err = loadClusterNodes(q, site)
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if len(out) == 0 {
+            http.Error(w, "cluster not found", 404)
+            return
+        }
+        writeJSON(w, r, out[0])
+    }
+}
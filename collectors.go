@@ -0,0 +1,492 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// ---------- Collectors ----------
+
+// 所有往库里采数据的来源（informer、定时轮询的 metrics-server / 云厂商 / SSH / SNMP、单独 watch 的 Secret / Event ...）都实现 collector，
+// 由 registry 统一启动，/admin/collectors 可以查看状态、运行时停止 / 启动、立即重新采集一次。
+// 新的来源实现这个接口（或者用下面三种通用实现包一下）并在 main 里 add 即可，不用再往 main 里加 goroutine 和开关。
+// 停止只在内存里，重启后全部按配置启动。
+type collector interface {
+    Name() string
+    // 开始采集，不阻塞；stop 关闭后结束。Stop 之后可以再次 Start
+    Start(stop <-chan struct{}) error
+    // 停止采集，库里停在停止时的状态
+    Stop()
+    // 立即完整采集一次，不等下一个周期
+    Resync(ctx context.Context) error
+    Status() CollectorStatus
+}
+
+type CollectorStatus struct {
+    Name string `json:"name"`
+    // informer / periodic / watch
+    Type    string `json:"type"`
+    Running bool   `json:"running"`
+    // informer：写入的 kind，和 /admin/watchers 的 resource 相同
+    Kinds []string `json:"kinds,omitempty"`
+    // informer：全部 kind 的缓存都已同步
+    Synced bool `json:"synced,omitempty"`
+    // periodic：采集间隔
+    Interval    string `json:"interval,omitempty"`
+    LastRun     string `json:"lastRun,omitempty"`
+    LastSuccess string `json:"lastSuccess,omitempty"`
+    LastError   string `json:"lastError,omitempty"`
+    Runs        int64  `json:"runs"`
+    Failures    int64  `json:"failures"`
+    lastSuccess time.Time
+}
+
+var errCollectorStopped = errors.New("collector is stopped")
+
+type collectorRegistry struct {
+    mu      sync.Mutex
+    entries []collector
+    // start 之后才有；之后 add 的立即启动
+    stop <-chan struct{}
+}
+
+func newCollectorRegistry() *collectorRegistry {
+    return &collectorRegistry{}
+}
+
+// nil 表示未启用，直接忽略
+func (r *collectorRegistry) add(c collector) {
+    if c == nil {
+        return
+    }
+    r.mu.Lock()
+    r.entries = append(r.entries, c)
+    stop := r.stop
+    r.mu.Unlock()
+    if stop != nil {
+        r.startOne(c, stop)
+    }
+}
+
+func (r *collectorRegistry) start(stop <-chan struct{}) {
+    r.mu.Lock()
+    r.stop = stop
+    entries := append([]collector(nil), r.entries...)
+    r.mu.Unlock()
+    for _, c := range entries {
+        r.startOne(c, stop)
+    }
+}
+
+// 用 start 时的 stop 重新启动一个停止了的 collector
+func (r *collectorRegistry) restart(c collector) error {
+    r.mu.Lock()
+    stop := r.stop
+    r.mu.Unlock()
+    if stop == nil {
+        return errors.New("collectors are not started yet")
+    }
+    return c.Start(stop)
+}
+
+func (r *collectorRegistry) startOne(c collector, stop <-chan struct{}) {
+    if err := c.Start(stop); err != nil {
+        log.Printf("[collectors] start %s: %v", c.Name(), err)
+    }
+}
+
+func (r *collectorRegistry) find(name string) collector {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, c := range r.entries {
+        if c.Name() == name {
+            return c
+        }
+    }
+    return nil
+}
+
+func (r *collectorRegistry) statuses() []CollectorStatus {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    out := make([]CollectorStatus, 0, len(r.entries))
+    for _, c := range r.entries {
+        out = append(out, c.Status())
+    }
+    return out
+}
+
+func (r *collectorRegistry) registerMetrics(m *metricsRegistry) {
+    perCollector := func(v func(st CollectorStatus) float64) func() []metricSample {
+        return func() []metricSample {
+            var out []metricSample
+            for _, st := range r.statuses() {
+                out = append(out, metricSample{Labels: []metricLabel{{"collector", st.Name}}, Value: v(st)})
+            }
+            return out
+        }
+    }
+    m.register(metricFamily{Name: "lightcmdb_collector_running", Type: "gauge",
+        Help: "1 if the collector is running",
+        Collect: perCollector(func(st CollectorStatus) float64 {
+            if st.Running {
+                return 1
+            }
+            return 0
+        })})
+    m.register(metricFamily{Name: "lightcmdb_collector_last_success_timestamp_seconds", Type: "gauge",
+        Help: "Unix time of the last successful collection, 0 if none yet",
+        Collect: perCollector(func(st CollectorStatus) float64 {
+            if st.lastSuccess.IsZero() {
+                return 0
+            }
+            return float64(st.lastSuccess.Unix())
+        })})
+    m.register(metricFamily{Name: "lightcmdb_collector_failures", Type: "counter",
+        Help:    "Collection runs that failed",
+        Collect: perCollector(func(st CollectorStatus) float64 { return float64(st.Failures) })})
+}
+
+// ---------- Informer collectors ----------
+
+// SharedInformerFactory 和 DynamicSharedInformerFactory 都满足
+type informerFactory interface {
+    Start(stop <-chan struct{})
+}
+
+// 一个 factory 里的 informer，事件经 syncQueue 写库。factory 一旦 Start 就不能重来，
+// 所以 Stop 是暂停这些 kind 的同步（同 /admin/watchers，缓存照常更新），再 Start 时恢复并补齐；
+// 运行状态以暂停状态为准，/admin/watchers 暂停了其中任一 kind 就算停止。
+// Resync 把缓存里的全部 key 和库里已有的行重新入队，和恢复暂停时一样
+type informerCollector struct {
+    name    string
+    factory informerFactory
+    syncs   *syncQueue
+    kinds   []string
+
+    mu      sync.Mutex
+    started bool
+    st      CollectorStatus
+}
+
+// watch 注册到 syncs 的 kind 都归这个 collector，要在 syncs.run 之前调用；watch 返回 nil 表示未启用
+func newInformerCollector(name string, syncs *syncQueue, watch func() informerFactory) collector {
+    n := len(syncs.order)
+    factory := watch()
+    if factory == nil {
+        return nil
+    }
+    kinds := append([]string(nil), syncs.order[n:]...)
+    return &informerCollector{name: name, factory: factory, syncs: syncs, kinds: kinds}
+}
+
+func (c *informerCollector) Name() string { return c.name }
+
+func (c *informerCollector) Start(stop <-chan struct{}) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if !c.started {
+        c.factory.Start(stop)
+        c.started = true
+        return nil
+    }
+    for _, kind := range c.kinds {
+        if err := c.syncs.setPaused(kind, false); err != nil {
+            return fmt.Errorf("%s: %w", kind, err)
+        }
+    }
+    return nil
+}
+
+func (c *informerCollector) Stop() {
+    for _, kind := range c.kinds {
+        c.syncs.setPaused(kind, true)
+    }
+}
+
+// 调用方持有 c.mu
+func (c *informerCollector) running() bool {
+    if !c.started {
+        return false
+    }
+    c.syncs.mu.Lock()
+    defer c.syncs.mu.Unlock()
+    for _, kind := range c.kinds {
+        if _, ok := c.syncs.paused[kind]; ok {
+            return false
+        }
+    }
+    return true
+}
+
+func (c *informerCollector) Resync(ctx context.Context) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if !c.running() {
+        return errCollectorStopped
+    }
+    now := time.Now()
+    c.st.Runs++
+    c.st.LastRun = now.UTC().Format(time.RFC3339)
+    for _, kind := range c.kinds {
+        if err := c.syncs.requeueAll(kind); err != nil {
+            c.st.Failures++
+            c.st.LastError = fmt.Sprintf("%s: %v", kind, err)
+            return errors.New(c.st.LastError)
+        }
+    }
+    c.st.LastError = ""
+    c.st.LastSuccess, c.st.lastSuccess = c.st.LastRun, now
+    return nil
+}
+
+func (c *informerCollector) Status() CollectorStatus {
+    c.mu.Lock()
+    st := c.st
+    st.Name, st.Type, st.Kinds = c.name, "informer", c.kinds
+    st.Running, st.Synced = c.running(), c.started
+    c.mu.Unlock()
+    c.syncs.mu.Lock()
+    defer c.syncs.mu.Unlock()
+    for _, kind := range c.kinds {
+        if !c.syncs.kinds[kind].synced() {
+            st.Synced = false
+        }
+        // 没有手工 Resync 时取最后一次写库成功的时间
+        if t := c.syncs.lastSuccess[kind]; t.After(st.lastSuccess) {
+            st.lastSuccess = t
+            st.LastSuccess = t.UTC().Format(time.RFC3339)
+        }
+    }
+    return st
+}
+
+// ---------- Periodic collectors ----------
+
+// 每 every 调一次 run，启动时先跑一次。timeout 为 0 时只在停止时取消 ctx，否则每次最多跑 timeout。
+// 上一次还没跑完时 Resync 等它结束再跑
+type periodicCollector struct {
+    name    string
+    every   time.Duration
+    timeout time.Duration
+    run     func(ctx context.Context) error
+
+    // 同一时间只跑一次
+    runMu sync.Mutex
+    mu    sync.Mutex
+    // 运行中时非 nil，Stop 时调用
+    cancel context.CancelFunc
+    st     CollectorStatus
+}
+
+func newPeriodicCollector(name string, every, timeout time.Duration, run func(ctx context.Context) error) *periodicCollector {
+    return &periodicCollector{name: name, every: every, timeout: timeout, run: run}
+}
+
+func (c *periodicCollector) Name() string { return c.name }
+
+func (c *periodicCollector) Start(stop <-chan struct{}) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.cancel != nil {
+        return nil
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    c.cancel = cancel
+    go func() {
+        select {
+        case <-stop:
+            c.Stop()
+        case <-ctx.Done():
+        }
+    }()
+    go c.loop(ctx)
+    return nil
+}
+
+func (c *periodicCollector) loop(ctx context.Context) {
+    t := time.NewTicker(c.every)
+    defer t.Stop()
+    for {
+        c.once(ctx)
+        select {
+        case <-ctx.Done():
+            return
+        case <-t.C:
+        }
+    }
+}
+
+func (c *periodicCollector) once(ctx context.Context) error {
+    c.runMu.Lock()
+    defer c.runMu.Unlock()
+    if c.timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, c.timeout)
+        defer cancel()
+    }
+    start := time.Now()
+    err := c.run(ctx)
+    // 停止时被取消的不算失败
+    if err != nil && errors.Is(err, context.Canceled) {
+        return err
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.st.Runs++
+    c.st.LastRun = start.UTC().Format(time.RFC3339)
+    if err != nil {
+        c.st.Failures++
+        c.st.LastError = err.Error()
+        return err
+    }
+    c.st.LastError = ""
+    c.st.LastSuccess, c.st.lastSuccess = c.st.LastRun, start
+    return nil
+}
+
+func (c *periodicCollector) Stop() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.cancel != nil {
+        c.cancel()
+        c.cancel = nil
+    }
+}
+
+// 停止时也可以手工跑一次
+func (c *periodicCollector) Resync(ctx context.Context) error {
+    return c.once(ctx)
+}
+
+func (c *periodicCollector) Status() CollectorStatus {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    st := c.st
+    st.Name, st.Type, st.Interval = c.name, "periodic", c.every.String()
+    st.Running = c.cancel != nil
+    return st
+}
+
+// ---------- Watch collectors ----------
+
+// 自己建 informer、随 stop 结束的 watchXxx（Helm release、TLS Secret、Event ...）。
+// 每次 Start 都是新的 informer，Resync 即停止后重新 List/Watch
+type watchCollector struct {
+    name  string
+    watch func(stop <-chan struct{})
+
+    mu sync.Mutex
+    // 运行中时非 nil，关闭即停止这一轮的 informer
+    quit chan struct{}
+    // 最近一次 Start 传入的 stop，Resync 重启时用
+    stop <-chan struct{}
+    st   CollectorStatus
+}
+
+func newWatchCollector(name string, watch func(stop <-chan struct{})) *watchCollector {
+    return &watchCollector{name: name, watch: watch}
+}
+
+func (c *watchCollector) Name() string { return c.name }
+
+func (c *watchCollector) Start(stop <-chan struct{}) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.quit != nil {
+        return nil
+    }
+    quit, merged := make(chan struct{}), make(chan struct{})
+    go func() {
+        select {
+        case <-stop:
+        case <-quit:
+        }
+        close(merged)
+    }()
+    c.quit, c.stop = quit, stop
+    now := time.Now()
+    c.st.Runs++
+    c.st.LastRun = now.UTC().Format(time.RFC3339)
+    c.st.LastSuccess, c.st.lastSuccess = c.st.LastRun, now
+    c.watch(merged)
+    return nil
+}
+
+func (c *watchCollector) Stop() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.quit != nil {
+        close(c.quit)
+        c.quit = nil
+    }
+}
+
+func (c *watchCollector) Resync(context.Context) error {
+    c.mu.Lock()
+    stop := c.stop
+    running := c.quit != nil
+    c.mu.Unlock()
+    if !running {
+        return errCollectorStopped
+    }
+    c.Stop()
+    return c.Start(stop)
+}
+
+func (c *watchCollector) Status() CollectorStatus {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    st := c.st
+    st.Name, st.Type = c.name, "watch"
+    st.Running = c.quit != nil
+    return st
+}
+
+// ---------- HTTP ----------
+
+// GET /admin/collectors 查看状态；POST /admin/collectors?name=cloud&action=resync|stop|start（重启后以配置为准）
+func collectorsAPI(reg *collectorRegistry) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            writeJSON(w, r, reg.statuses())
+        case http.MethodPost:
+            q := r.URL.Query()
+            c := reg.find(q.Get("name"))
+            if c == nil {
+                http.Error(w, "unknown collector", 404)
+                return
+            }
+            var err error
+            switch q.Get("action") {
+            case "resync":
+                err = c.Resync(r.Context())
+            case "stop":
+                c.Stop()
+            case "start":
+                err = reg.restart(c)
+            default:
+                http.Error(w, "action must be resync, stop or start", 400)
+                return
+            }
+            if errors.Is(err, errCollectorStopped) {
+                http.Error(w, err.Error(), 409)
+                return
+            }
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            log.Printf("[collectors] %s %s", c.Name(), q.Get("action"))
+            writeJSON(w, r, c.Status())
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ---------- Point-in-time compare ----------
+
+// 两个时间点之间库存的净变化，给事故复盘用。按 (kind, ref) 取窗口 (from, to] 内的第一条和最后一条记录：
+// 第一条的 before 是 from 时的状态，最后一条的 after 是 to 时的状态（create 的 before、delete 的 after 为 NULL）。
+// 两端都为空（窗口内建了又删）或投影完全相同（改了又改回去）的对象不列出。窗口外没有记录的对象说明没变，也不用管。
+const compareLimit = 10000
+
+type CompareField struct {
+    Field string `json:"field"`
+    From  string `json:"from"`
+    To    string `json:"to"`
+}
+
+func (f CompareField) masked(m *fieldMasker) any {
+    if m.covers(f.Field) {
+        f.From, f.To = m.redactString(f.From), m.redactString(f.To)
+    }
+    return f
+}
+
+type CompareObject struct {
+    Ref       string `json:"ref"`
+    Namespace string `json:"namespace,omitempty"`
+    Name      string `json:"name"`
+    // 窗口内的变更记录数（含压缩合并掉的）和写入来源
+    Changes int      `json:"changes"`
+    Sources []string `json:"sources"`
+    // added 只有 after，removed 只有 before
+    Before RawJSON `json:"before"`
+    After  RawJSON `json:"after"`
+    // 只有 changed 有
+    Fields []CompareField `json:"fields,omitempty"`
+}
+
+type CompareGroup struct {
+    Added   []CompareObject `json:"added"`
+    Removed []CompareObject `json:"removed"`
+    Changed []CompareObject `json:"changed"`
+}
+
+type CompareReport struct {
+    From string `json:"from"`
+    To   string `json:"to"`
+    // 按 history 的 kind 分组
+    Kinds map[string]*CompareGroup `json:"kinds"`
+    // 窗口内变化的对象超过 10000 个时只返回前 10000 个
+    Truncated bool `json:"truncated,omitempty"`
+}
+
+func projectionValue(v any) string {
+    switch v := v.(type) {
+    case string:
+        return v
+    case nil:
+        return ""
+    default:
+        b, _ := json.Marshal(v)
+        return string(b)
+    }
+}
+
+// 顶层字段逐个比较，按字段名排序
+func compareFields(before, after string) ([]CompareField, error) {
+    a, b := map[string]any{}, map[string]any{}
+    if err := json.Unmarshal([]byte(before), &a); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(after), &b); err != nil {
+        return nil, err
+    }
+    var out []CompareField
+    for k := range b {
+        if _, ok := a[k]; !ok {
+            a[k] = nil
+        }
+    }
+    for k, v := range a {
+        if f, t := projectionValue(v), projectionValue(b[k]); f != t {
+            out = append(out, CompareField{Field: k, From: f, To: t})
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+    return out, nil
+}
+
+func parseCompareTime(v string) (string, error) {
+    t, err := time.Parse(time.RFC3339, v)
+    if err != nil {
+        return "", err
+    }
+    return t.UTC().Format(time.RFC3339), nil
+}
+
+func buildCompare(ctx context.Context, db *sql.DB, scope nsScope, from, to string, kinds []string, ns string) (*CompareReport, error) {
+    sc, scArgs := scope.cond("coalesce(namespace,'')")
+    conds := []string{"ts>?", "ts<=?", sc}
+    args := append([]any{from, to}, scArgs...)
+    if len(kinds) > 0 {
+        conds = append(conds, "kind IN (SELECT value FROM json_each(?))")
+        b, _ := json.Marshal(kinds)
+        args = append(args, string(b))
+    }
+    if ns != "" {
+        conds = append(conds, "namespace=?")
+        args = append(args, ns)
+    }
+    where := strings.Join(conds, " AND ")
+    // first / last 是窗口内该对象最早和最晚的记录 id
+    rows, err := db.QueryContext(ctx, `
+SELECT w.kind,w.ref,coalesce(l.namespace,''),coalesce(l.name,''),w.n,w.sources,coalesce(cmdb_unseal(f.before),''),coalesce(cmdb_unseal(l.after),'')
+FROM (SELECT kind,ref,min(id) AS first,max(id) AS last,count(*)+sum(squashed) AS n,group_concat(DISTINCT coalesce(source,'')) AS sources
+      FROM changes WHERE `+where+` GROUP BY kind,ref) w
+JOIN changes f ON f.id=w.first JOIN changes l ON l.id=w.last
+ORDER BY w.kind,l.namespace,l.name,w.ref`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    rep := &CompareReport{From: from, To: to, Kinds: map[string]*CompareGroup{}}
+    n := 0
+    for rows.Next() {
+        var kind, sources string
+        var o CompareObject
+        if err := rows.Scan(&kind, &o.Ref, &o.Namespace, &o.Name, &o.Changes, &sources, &o.Before, &o.After); err != nil {
+            return nil, err
+        }
+        if o.Before == o.After {
+            continue
+        }
+        if n == compareLimit {
+            rep.Truncated = true
+            break
+        }
+        n++
+        o.Sources = strings.Split(sources, ",")
+        g := rep.Kinds[kind]
+        if g == nil {
+            g = &CompareGroup{Added: []CompareObject{}, Removed: []CompareObject{}, Changed: []CompareObject{}}
+            rep.Kinds[kind] = g
+        }
+        switch {
+        case o.Before == "":
+            g.Added = append(g.Added, o)
+        case o.After == "":
+            g.Removed = append(g.Removed, o)
+        default:
+            if o.Fields, err = compareFields(string(o.Before), string(o.After)); err != nil {
+                return nil, err
+            }
+            g.Changed = append(g.Changed, o)
+        }
+    }
+    return rep, rows.Err()
+}
+
+// GET /cmdb/compare?from=<RFC3339>&to=<RFC3339>&kind=pod,node&ns=
+// to 默认现在；限定 namespace 的 key 只看到范围内的对象
+func compareAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        from, err := parseCompareTime(q.Get("from"))
+        if err != nil {
+            http.Error(w, "from must be RFC3339", 400)
+            return
+        }
+        to := time.Now().UTC().Format(time.RFC3339)
+        if v := q.Get("to"); v != "" {
+            if to, err = parseCompareTime(v); err != nil {
+                http.Error(w, "to must be RFC3339", 400)
+                return
+            }
+        }
+        if to <= from {
+            http.Error(w, "to must be after from", 400)
+            return
+        }
+        var kinds []string
+        if v := q.Get("kind"); v != "" {
+            for _, k := range strings.Split(v, ",") {
+                if _, ok := historySourceOf(k); !ok {
+                    http.Error(w, "unknown kind "+k, 400)
+                    return
+                }
+                kinds = append(kinds, k)
+            }
+        }
+        rep, err := buildCompare(r.Context(), db, scopeOf(r.Context()), from, to, kinds, q.Get("ns"))
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, rep)
+    }
+}
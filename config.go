@@ -0,0 +1,1032 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/labels"
+    "sigs.k8s.io/yaml"
+)
+
+// ---------- Config ----------
+
+// 配置文件可选，YAML 或 JSON 均可；路径由 LIGHTCMDB_CONFIG 指定，默认 ./lightcmdb.yaml
+const defaultConfigPath = "lightcmdb.yaml"
+
+type Config struct {
+    Storage StorageConfig `json:"storage"`
+    // role 为 follower 时不连集群，定时从 writer 拉库快照只提供读接口，见 replica.go
+    Replica    ReplicaConfig    `json:"replica"`
+    Federation FederationConfig `json:"federation"`
+    LiveProxy  LiveProxyConfig  `json:"liveProxy"`
+    History    HistoryConfig    `json:"history"`
+    Events     EventsConfig     `json:"events"`
+    PodIPs     PodIPsConfig     `json:"podIPs"`
+    Quotas     QuotasConfig     `json:"quotas"`
+    Baselines  BaselinesConfig  `json:"baselines"`
+    // 定时 incremental_vacuum、ANALYZE、WAL checkpoint，interval 为空（默认）表示不定时运行
+    Maintenance MaintenanceConfig `json:"maintenance"`
+    Auth        AuthConfig        `json:"auth"`
+    Masking     MaskingConfig     `json:"masking"`
+    GitSnapshot GitSnapshotConfig `json:"gitSnapshot"`
+    Server      ServerConfig      `json:"server"`
+    // 不带 /api/v1 的旧路径是否标记为弃用，见 envelope.go
+    LegacyAPI LegacyAPIConfig   `json:"legacyAPI"`
+    TLS       TLSConfig         `json:"tls"`
+    Limits    LimitsConfig      `json:"limits"`
+    NodeHooks NodeWebhookConfig `json:"nodeWebhooks"`
+    CORS      CORSConfig        `json:"cors"`
+    // MetalLB / kube-vip 的宣告节点
+    LoadBalancers LoadBalancerConfig `json:"loadBalancers"`
+    Helm          HelmConfig         `json:"helm"`
+    ArgoCD        ArgoCDConfig       `json:"argocd"`
+    RBAC          RBACConfig         `json:"rbac"`
+    Certificates  CertificatesConfig `json:"certificates"`
+    Unused        UnusedConfig       `json:"unused"`
+    // 定时修复 DB 与 API server 的不一致，interval 为空（默认）表示不定时运行
+    Reconcile ReconcileConfig `json:"reconcile"`
+    // 定时拉 metrics.k8s.io 的节点和 Pod 用量，interval 为空（默认）表示不拉
+    MetricsServer MetricsServerConfig `json:"metricsServer"`
+    // 定时用 trivy 扫描运行中的镜像，interval 为空（默认）表示不扫描
+    Scanner ScannerConfig `json:"scanner"`
+    // /cmdb/images/compliance 认可的镜像来源，见 imagecompliance.go
+    Images ImagesConfig `json:"images"`
+    // 按 kind 用 JSONPath 取出额外的列，见 projections.go
+    Projections map[string][]ProjectionConfig `json:"projections"`
+    // 通过 SSH 采集集群外的主机，interval 为空（默认）表示不采集
+    HostDiscovery HostDiscoveryConfig `json:"hostDiscovery"`
+    // 交换机、路由器的 SNMP 轮询，interval 为空（默认）表示不采集
+    SNMP SNMPConfig `json:"snmp"`
+    // 云上的实例、磁盘和安全组，interval 为空（默认）表示不采集
+    Cloud CloudConfig `json:"cloud"`
+    // 节点每小时的成本，/cmdb/costs 按 Pod requests 分摊
+    Costs CostConfig `json:"costs"`
+    // 从 Namespace 的 annotation / label 取团队，都为空（默认）表示不区分团队
+    Ownership OwnershipConfig `json:"ownership"`
+    // /cmdb/nodegroups 的节点分组，按顺序输出；一个节点可以属于多个组
+    NodeGroups []NodeGroupConfig `json:"nodeGroups"`
+    // 定时推送到 ServiceNow CMDB，instance 为空（默认）表示不推送
+    ServiceNow ServiceNowConfig `json:"serviceNow"`
+    // 按规则对库存告警，rules 为空（默认）表示不启用
+    Alerts AlertsConfig `json:"alerts"`
+    // 按规则自动给 CI 打 tag，rules 为空（默认）表示不打
+    Tagging TaggingConfig `json:"tagging"`
+    // 按天 / 周发送库存摘要，schedule 为空（默认）表示不发送
+    Reports ReportsConfig `json:"reports"`
+    // 定时把 inventory 指标推到 Prometheus remote-write，url 为空（默认）表示不推送
+    RemoteWrite RemoteWriteConfig `json:"remoteWrite"`
+    // 按 exporter 名字（nodeWebhooks、gitSnapshot、serviceNow、alerts、reports、remoteWrite）配置开关和重试
+    Exporters map[string]ExporterConfig `json:"exporters"`
+    // /docs 页面加载 swagger-ui-dist 的地址，离线环境指向内网镜像
+    SwaggerUIBase string `json:"swaggerUIBase"`
+
+    // 实际读取的配置文件路径，没有配置文件时为空
+    path string
+}
+
+type StorageConfig struct {
+    // cmdb.db 所在目录，默认当前目录
+    DataDir string `json:"dataDir"`
+    // 文件权限检查："warn"（默认）只告警，"enforce" 自动收紧，"strict" 不合规直接退出
+    Permissions string `json:"permissions"`
+    // /cmdb/pods、/cmdb/nodes 从内存读模型返回，见 hotstore.go
+    HotReadModel bool `json:"hotReadModel"`
+    // 敏感字段（内网 IP）加密存储，见 encryption.go
+    Encryption EncryptionConfig `json:"encryption"`
+}
+
+type EncryptionConfig struct {
+    // 32 字节 key（hex 或 base64）所在文件；不设置时读 LIGHTCMDB_DB_KEY，都没有则不加密
+    KeyFile string `json:"keyFile"`
+}
+
+// role 为空（默认）时是 writer：同步集群，并提供 /admin/replica/snapshot
+type ReplicaConfig struct {
+    // "" 或 "follower"
+    Role string `json:"role"`
+    // follower 专用：writer 的地址、admin 权限的 API key（或 LIGHTCMDB_REPLICA_API_KEY）、拉取间隔（默认 1m）
+    WriterURL string   `json:"writerURL"`
+    APIKey    string   `json:"apiKey"`
+    Interval  Duration `json:"interval"`
+}
+
+// 没有配置任何 key（keysFile 和 LIGHTCMDB_API_KEYS 都为空）时不做认证
+type AuthConfig struct {
+    KeysFile string     `json:"keysFile"`
+    OIDC     OIDCConfig `json:"oidc"`
+}
+
+// issuerURL 为空表示不启用；groups 把 IdP 的组映射到可见 namespace，"*" 表示全部
+type OIDCConfig struct {
+    IssuerURL    string              `json:"issuerURL"`
+    ClientID     string              `json:"clientID"`
+    ClientSecret string              `json:"clientSecret"`
+    RedirectURL  string              `json:"redirectURL"`
+    Scopes       []string            `json:"scopes"`
+    GroupsClaim  string              `json:"groupsClaim"`
+    Groups       map[string][]string `json:"groups"`
+    // 这些组的用户看到 masking 遮盖的原值
+    UnmaskGroups []string `json:"unmaskGroups"`
+}
+
+// 见 masking.go
+type MaskingConfig struct {
+    // JSON 字段名或列名，不分大小写、忽略 _ 和 -，如 internalIP、podIP
+    Fields []string `json:"fields"`
+    // annotation key，可以用 path.Match 的通配，如 example.com/*
+    Annotations []string `json:"annotations"`
+    // 默认 "***"
+    Replacement string `json:"replacement"`
+}
+
+type HistoryConfig struct {
+    // 同一对象在该窗口内的连续更新会被压缩成一条
+    CompactWindow   Duration `json:"compactWindow"`
+    CompactInterval Duration `json:"compactInterval"`
+    // 同一对象两次写库的最小间隔，窗口内的更新合并成一次，见 syncdebounce.go；0（默认）不限
+    Debounce Duration `json:"debounce"`
+    // 按 namespace 覆盖 debounce，0 表示该 namespace 不限
+    DebounceNamespaces map[string]Duration `json:"debounceNamespaces"`
+}
+
+// enabled 时额外 watch 所有 namespace 的 Event 存进 events 表，需要 events 的 list/watch 权限
+type EventsConfig struct {
+    Enabled bool `json:"enabled"`
+    // /cmdb/history 的记录带上前后这段时间内同一对象的事件，默认 10m
+    Window Duration `json:"window"`
+    // 默认 168h
+    Retention Duration `json:"retention"`
+}
+
+// 已结束的 Pod IP 分配记录保留多久，默认 720h；应不短于 flow log 的保留时间
+type PodIPsConfig struct {
+    Retention Duration `json:"retention"`
+}
+
+// /cmdb/quotas 标出 used / hard 超过 threshold 的项，默认 0.9
+type QuotasConfig struct {
+    Threshold float64 `json:"threshold"`
+}
+
+// interval 为空（默认）表示不定时建 baseline；keep 是保留的自动 baseline 个数，默认 7，手工建的不算
+type BaselinesConfig struct {
+    Interval Duration `json:"interval"`
+    Keep     int      `json:"keep"`
+}
+
+// vacuumPages 是每次最多释放的空闲页数，0 为全部
+type MaintenanceConfig struct {
+    Interval    Duration `json:"interval"`
+    VacuumPages int      `json:"vacuumPages"`
+}
+
+// /cmdb/live/* 直接暴露完整对象（含 env、annotations 等），默认关闭
+type LiveProxyConfig struct {
+    Enabled bool     `json:"enabled"`
+    Kinds   []string `json:"kinds"`
+    // informer 缓存保留完整 spec（只去掉 managedFields），/cmdb/live 才能返回完整对象，见 informertrim.go
+    FullObjects bool `json:"fullObjects"`
+}
+
+type FederationConfig struct {
+    // "" 单机，"hub" 中心，"edge" 边缘
+    Mode string `json:"mode"`
+    // edge 专用；apiKey 是 hub 上发给这个站点的 key（或 LIGHTCMDB_FEDERATION_API_KEY）
+    HubURL       string   `json:"hubURL"`
+    Site         string   `json:"site"`
+    PollInterval Duration `json:"pollInterval"`
+    APIKey       string   `json:"apiKey"`
+}
+
+// ratePerSecond/maxRows 为 0 表示不限
+type LimitsConfig struct {
+    RatePerSecond float64 `json:"ratePerSecond"`
+    Burst         int     `json:"burst"`
+    MaxRows       int     `json:"maxRows"`
+    MaxBodyBytes  int64   `json:"maxBodyBytes"`
+}
+
+// deprecated 时旧路径的响应带 Deprecation 和指向 /api/v1 的 Link 头；sunset 是计划下线的日期（2027-06-30），
+// 写了 sunset 也就是 deprecated。旧路径在 sunset 之后照常可用，下线要靠升级版本
+type LegacyAPIConfig struct {
+    Deprecated bool   `json:"deprecated"`
+    Sunset     string `json:"sunset"`
+}
+
+// 监听地址和连接超时，见 server.go；各项为空时用默认值
+type ServerConfig struct {
+    // 默认 ":8080"
+    Listen string `json:"listen"`
+    // 读完请求头的期限，默认 5s
+    ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+    // 读完整个请求（含请求体）的期限，默认 1m
+    ReadTimeout Duration `json:"readTimeout"`
+    // 从读完请求头到写完响应的期限，默认 5m：大的 CSV 导出给慢客户端也要在这之内下载完
+    WriteTimeout Duration `json:"writeTimeout"`
+    // keep-alive 连接的空闲期限，默认 2m
+    IdleTimeout Duration `json:"idleTimeout"`
+    // 默认 1MiB
+    MaxHeaderBytes int `json:"maxHeaderBytes"`
+    // 每个请求的处理期限，到期后取消请求 context，正在执行的 SQLite 查询随之中断；默认 2m
+    RequestTimeout Duration `json:"requestTimeout"`
+    // 启动时初始 list 还没写完库期间 /cmdb/ 下的 GET：serve（默认）照常返回不完整的数据并带上 X-LightCMDB-Warming，
+    // unavailable 返回 503
+    WhileWarming string `json:"whileWarming"`
+}
+
+// 两者都配置时 server.listen 改为 HTTPS
+type TLSConfig struct {
+    CertFile string `json:"certFile"`
+    KeyFile  string `json:"keyFile"`
+}
+
+// 库存渲染成 YAML 提交到本地 git 仓库 dir，remote 非空时提交后 push 到 branch
+type GitSnapshotConfig struct {
+    Enabled     bool     `json:"enabled"`
+    Dir         string   `json:"dir"`
+    Interval    Duration `json:"interval"`
+    Remote      string   `json:"remote"`
+    Branch      string   `json:"branch"`
+    AuthorName  string   `json:"authorName"`
+    AuthorEmail string   `json:"authorEmail"`
+}
+
+// urls 为空表示不启用；secret 非空时请求带 X-LightCMDB-Signature（HMAC-SHA256）
+type NodeWebhookConfig struct {
+    URLs     []string `json:"urls"`
+    Secret   string   `json:"secret"`
+    Debounce Duration `json:"debounce"`
+    // 待发事件队列长度；接收方跟不上时清空积压，改发一个 resync 事件
+    QueueSize int `json:"queueSize"`
+}
+
+type ReconcileConfig struct {
+    Interval Duration `json:"interval"`
+}
+
+type MetricsServerConfig struct {
+    Interval Duration `json:"interval"`
+    // 保留这段时间内的 Pod 用量采样，空表示只保留当前值
+    PodHistory Duration `json:"podHistory"`
+}
+
+type ScannerConfig struct {
+    Interval Duration `json:"interval"`
+    // 早于这个时间的结果重新扫描，默认 24h
+    Rescan Duration `json:"rescan"`
+    // trivy 可执行文件，默认在 PATH 里找
+    Trivy string `json:"trivy"`
+    // trivy server 地址（client/server 模式），空表示本地下载漏洞库
+    Server string `json:"server"`
+    // 单个镜像的扫描超时，默认 10m
+    Timeout Duration `json:"timeout"`
+}
+
+type ImagesConfig struct {
+    // 镜像引用（补全后的 registry/repository）的前缀，如 registry.internal/、docker.io/library/；为空时不检查来源
+    AllowedRegistries []string `json:"allowedRegistries"`
+}
+
+type HostDiscoveryConfig struct {
+    Interval Duration `json:"interval"`
+    // 主机名、IP、host:port 或 CIDR（最多 4096 个地址）
+    Targets        []string `json:"targets"`
+    User           string   `json:"user"`
+    PrivateKeyFile string   `json:"privateKeyFile"`
+    // OpenSSH known_hosts 格式；不校验主机密钥时必须显式设置 insecureIgnoreHostKey
+    KnownHostsFile        string `json:"knownHostsFile"`
+    InsecureIgnoreHostKey bool   `json:"insecureIgnoreHostKey"`
+    // 同时连接的主机数，默认 8
+    Concurrency int `json:"concurrency"`
+    // 每台主机（连接加执行脚本），默认 30s
+    Timeout Duration `json:"timeout"`
+}
+
+type SNMPConfig struct {
+    Interval Duration `json:"interval"`
+    // IP、主机名、host:port（默认 161）或 CIDR（最多 4096 个地址）
+    Targets []string `json:"targets"`
+    // SNMPv2c 只读 community，为空时取 $LIGHTCMDB_SNMP_COMMUNITY
+    Community string `json:"community"`
+    // 同时轮询的设备数，默认 8
+    Concurrency int `json:"concurrency"`
+    // 每个请求的超时，默认 2s；超时后重发 retries 次，默认 1
+    Timeout Duration `json:"timeout"`
+    Retries int      `json:"retries"`
+}
+
+type CloudConfig struct {
+    Interval  Duration              `json:"interval"`
+    Providers []CloudProviderConfig `json:"providers"`
+}
+
+type CloudProviderConfig struct {
+    // 目前只支持 aws
+    Provider string   `json:"provider"`
+    Regions  []string `json:"regions"`
+    // 替换默认的 API 地址（LocalStack、VPC endpoint），{region} 换成 region
+    Endpoint string `json:"endpoint"`
+}
+
+type CostConfig struct {
+    // 只用于展示，默认 USD
+    Currency string `json:"currency"`
+    // 没有规则匹配的节点的单价
+    DefaultHourly float64 `json:"defaultHourly"`
+    // 按顺序匹配，第一条命中的生效
+    Nodes []NodeCostRule `json:"nodes"`
+}
+
+// password 为空时读环境变量 LIGHTCMDB_SERVICENOW_PASSWORD
+type ServiceNowConfig struct {
+    // 例如 https://acme.service-now.com
+    Instance string   `json:"instance"`
+    User     string   `json:"user"`
+    Password string   `json:"password"`
+    Interval Duration `json:"interval"`
+    // correlation_id 的前缀，默认 lightcmdb；多个集群推到同一个实例时各自设置不同的值
+    CorrelationPrefix string              `json:"correlationPrefix"`
+    Mappings          []ServiceNowMapping `json:"mappings"`
+}
+
+// kind 是 history 的 kind（pod、node、deployment、asset、host ...），table 是 CI 类的表（cmdb_ci_server ...）；
+// fields 为 ServiceNow 字段 -> 列名（该 kind 的 history 列，或主键 ref），"=" 开头表示常量
+type ServiceNowMapping struct {
+    Kind   string            `json:"kind"`
+    Table  string            `json:"table"`
+    Fields map[string]string `json:"fields"`
+}
+
+type AlertsConfig struct {
+    // 求值间隔，默认 30s
+    Interval Duration      `json:"interval"`
+    Targets  []AlertTarget `json:"targets"`
+    Rules    []AlertRule   `json:"rules"`
+}
+
+// type 为 slack（incoming webhook，发文本）或 webhook（发 JSON，secret 非空时带 X-LightCMDB-Signature）
+type AlertTarget struct {
+    Name   string `json:"name"`
+    Type   string `json:"type"`
+    URL    string `json:"url"`
+    Secret string `json:"secret"`
+}
+
+// kind 是 history 的 kind；where 写成 field=value,field!=value（AND），例如 ready=false、phase=Failed,namespace=prod；
+// for 为持续命中多久才通知，默认 0（下一次求值就通知）
+type AlertRule struct {
+    Name    string   `json:"name"`
+    Kind    string   `json:"kind"`
+    Where   string   `json:"where"`
+    For     Duration `json:"for"`
+    Targets []string `json:"targets"`
+}
+
+// column 是小写字母、数字和下划线，存成 x_<column>；jsonPath 用 kubectl -o jsonpath 的语法
+type ProjectionConfig struct {
+    Column   string `json:"column"`
+    JSONPath string `json:"jsonPath"`
+}
+
+type TaggingConfig struct {
+    Rules []TagRule `json:"rules"`
+}
+
+// kinds 为空表示 pods、deployments、services、nodes 全部；namespace 是整体匹配的正则（prod|pci-.*），
+// labelSelector 用 kubectl -l 的语法，images 是 path.Match 的通配（* 不跨 /，如 registry.example.com/pci/*）
+type TagRule struct {
+    Tag           string   `json:"tag"`
+    Kinds         []string `json:"kinds"`
+    Namespace     string   `json:"namespace"`
+    LabelSelector string   `json:"labelSelector"`
+    Images        []string `json:"images"`
+}
+
+// bearerToken 为空时读环境变量 LIGHTCMDB_REMOTE_WRITE_TOKEN，也为空则不带 Authorization
+type RemoteWriteConfig struct {
+    // 例如 http://prometheus:9090/api/v1/write
+    URL string `json:"url"`
+    // 默认 1m
+    Interval Duration `json:"interval"`
+    // /metrics 里的指标名（counter 不带 _total），为空时推送全部 lightcmdb_inventory_*
+    Metrics []string `json:"metrics"`
+    // 加到每条序列上（如 cluster: edge-01），指标自己有同名 label 时不覆盖
+    ExternalLabels map[string]string `json:"externalLabels"`
+    BearerToken    string            `json:"bearerToken"`
+}
+
+// webhookURL 收到 JSON（webhookSecret 非空时带 X-LightCMDB-Signature），email 收到 HTML；至少配置一个
+type ReportsConfig struct {
+    // daily 或 weekly（周一）
+    Schedule string `json:"schedule"`
+    // 本地时间 HH:MM，默认 06:00；报告覆盖到这个时间为止的一天或一周
+    At string `json:"at"`
+    // 默认 10
+    TopImages     int               `json:"topImages"`
+    WebhookURL    string            `json:"webhookURL"`
+    WebhookSecret string            `json:"webhookSecret"`
+    Email         ReportEmailConfig `json:"email"`
+}
+
+// password 为空时读环境变量 LIGHTCMDB_SMTP_PASSWORD；服务器支持时自动 STARTTLS
+type ReportEmailConfig struct {
+    // host:port
+    SMTP     string   `json:"smtp"`
+    From     string   `json:"from"`
+    To       []string `json:"to"`
+    User     string   `json:"user"`
+    Password string   `json:"password"`
+}
+
+// instanceType 和 selector 二选一：instanceType 对应 node.kubernetes.io/instance-type 标签，selector 写成 k=v,k=v
+type NodeCostRule struct {
+    InstanceType string  `json:"instanceType"`
+    Selector     string  `json:"selector"`
+    Hourly       float64 `json:"hourly"`
+}
+
+// 两个都配置时 annotation 优先，没有这个 annotation 的 namespace 再看 label
+type OwnershipConfig struct {
+    Annotation string `json:"annotation"`
+    Label      string `json:"label"`
+}
+
+// selector 和 label 二选一：selector 用 kubectl -l 的语法，整体是一个组；label 按这个标签的每个取值各成一组，没有这个标签的节点不算
+type NodeGroupConfig struct {
+    Name     string `json:"name"`
+    Selector string `json:"selector"`
+    Label    string `json:"label"`
+}
+
+// watchAnnouncements 额外 watch Service 的 nodeAssigned 事件（MetalLB L2 宣告节点），需要 events 的 list/watch 权限
+type LoadBalancerConfig struct {
+    WatchAnnouncements bool `json:"watchAnnouncements"`
+}
+
+// enabled 时同步 ServiceAccount 和 Role / ClusterRole / RoleBinding / ClusterRoleBinding，需要这些资源的 list/watch 权限
+type RBACConfig struct {
+    Enabled bool `json:"enabled"`
+}
+
+// enabled 时 watch type=kubernetes.io/tls 的 Secret 记录证书的过期时间，需要 secrets 的 list/watch 权限；
+// cert-manager 的 Certificate 不受它控制，集群有 CRD 就同步
+type CertificatesConfig struct {
+    Enabled bool `json:"enabled"`
+}
+
+// enabled 时 watch ConfigMap 和 Secret 的元数据（不含 data），/cmdb/unused 才能列出没人引用的；需要 configmaps、secrets 的 list/watch 权限
+type UnusedConfig struct {
+    Enabled bool `json:"enabled"`
+}
+
+// enabled 时 watch type=helm.sh/release.v1 的 Secret 解出 Helm release，需要 secrets 的 list/watch 权限
+type HelmConfig struct {
+    Enabled bool `json:"enabled"`
+}
+
+// 集群有 Application CRD 时自动 watch；instanceLabel 要和 argocd-cm 的 application.instanceLabelKey 一致
+type ArgoCDConfig struct {
+    InstanceLabel string `json:"instanceLabel"`
+}
+
+// allowedOrigins 为空表示不启用 CORS，"*" 表示任意来源（不能和 allowCredentials 同时用）
+type CORSConfig struct {
+    AllowedOrigins   []string `json:"allowedOrigins"`
+    AllowedMethods   []string `json:"allowedMethods"`
+    AllowedHeaders   []string `json:"allowedHeaders"`
+    ExposedHeaders   []string `json:"exposedHeaders"`
+    AllowCredentials bool     `json:"allowCredentials"`
+    MaxAge           Duration `json:"maxAge"`
+}
+
+// enabled 缺省为 true；重试默认 3 次，退避从 1s 开始翻倍，最长 1m
+type ExporterConfig struct {
+    Enabled *bool       `json:"enabled"`
+    Retry   RetryPolicy `json:"retry"`
+}
+
+type RetryPolicy struct {
+    Attempts   int      `json:"attempts"`
+    Backoff    Duration `json:"backoff"`
+    MaxBackoff Duration `json:"maxBackoff"`
+}
+
+// Duration 支持 "30s" 这种写法
+type Duration struct {
+    time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+    s := string(b)
+    if s == "null" {
+        return nil
+    }
+    if len(s) >= 2 && s[0] == '"' {
+        v, err := time.ParseDuration(s[1 : len(s)-1])
+        if err != nil {
+            return err
+        }
+        d.Duration = v
+        return nil
+    }
+    return fmt.Errorf("invalid duration %s", s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return []byte(`"` + d.String() + `"`), nil
+}
+
+func loadConfig() (*Config, error) {
+    path := os.Getenv("LIGHTCMDB_CONFIG")
+    explicit := path != ""
+    if !explicit {
+        path = defaultConfigPath
+    }
+    cfg := &Config{}
+    b, err := os.ReadFile(path)
+    switch {
+    case err == nil:
+        if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+            return nil, fmt.Errorf("%s: %w", path, err)
+        }
+        cfg.path = path
+    case errors.Is(err, os.ErrNotExist) && !explicit:
+        // 没有配置文件就用默认值
+    default:
+        return nil, err
+    }
+    if err := cfg.validate(); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return cfg, nil
+}
+
+func (c *Config) validate() error {
+    if c.Storage.DataDir == "" {
+        c.Storage.DataDir = "."
+    }
+    switch c.Storage.Permissions {
+    case "":
+        c.Storage.Permissions = "warn"
+    case "warn", "enforce", "strict":
+    default:
+        return fmt.Errorf("unknown storage.permissions %q", c.Storage.Permissions)
+    }
+    for _, p := range c.Images.AllowedRegistries {
+        if strings.TrimSpace(p) == "" {
+            return errors.New("images.allowedRegistries must not contain empty entries")
+        }
+    }
+    if _, err := parseProjections(c.Projections); err != nil {
+        return fmt.Errorf("projections: %w", err)
+    }
+    if _, err := loadDBKey(c.Storage.Encryption); err != nil {
+        return fmt.Errorf("storage.encryption: %w", err)
+    }
+    if c.Maintenance.VacuumPages < 0 {
+        return errors.New("maintenance.vacuumPages must not be negative")
+    }
+    switch rp := &c.Replica; rp.Role {
+    case "":
+    case "follower":
+        if rp.WriterURL == "" {
+            return errors.New("replica.writerURL is required for a follower")
+        }
+        rp.WriterURL = strings.TrimRight(rp.WriterURL, "/")
+        if rp.APIKey == "" {
+            rp.APIKey = os.Getenv("LIGHTCMDB_REPLICA_API_KEY")
+        }
+        if rp.Interval.Duration <= 0 {
+            rp.Interval.Duration = time.Minute
+        }
+    default:
+        return fmt.Errorf("unknown replica.role %q", rp.Role)
+    }
+    srv := &c.Server
+    if srv.Listen == "" {
+        srv.Listen = ":8080"
+    }
+    if srv.ReadHeaderTimeout.Duration <= 0 {
+        srv.ReadHeaderTimeout.Duration = 5 * time.Second
+    }
+    if srv.ReadTimeout.Duration <= 0 {
+        srv.ReadTimeout.Duration = time.Minute
+    }
+    if srv.WriteTimeout.Duration <= 0 {
+        srv.WriteTimeout.Duration = 5 * time.Minute
+    }
+    if srv.IdleTimeout.Duration <= 0 {
+        srv.IdleTimeout.Duration = 2 * time.Minute
+    }
+    if srv.MaxHeaderBytes <= 0 {
+        srv.MaxHeaderBytes = 1 << 20
+    }
+    if srv.RequestTimeout.Duration <= 0 {
+        srv.RequestTimeout.Duration = 2 * time.Minute
+    }
+    if srv.RequestTimeout.Duration > srv.WriteTimeout.Duration {
+        return errors.New("server.requestTimeout must not exceed server.writeTimeout")
+    }
+    switch srv.WhileWarming {
+    case "":
+        srv.WhileWarming = "serve"
+    case "serve", "unavailable":
+    default:
+        return fmt.Errorf("server.whileWarming must be serve or unavailable, got %q", srv.WhileWarming)
+    }
+    if la := &c.LegacyAPI; la.Sunset != "" {
+        if _, err := time.Parse(time.DateOnly, la.Sunset); err != nil {
+            return fmt.Errorf("legacyAPI.sunset: expected YYYY-MM-DD: %v", err)
+        }
+        la.Deprecated = true
+    }
+    f := &c.Federation
+    switch f.Mode {
+    case "", "hub":
+    case "edge":
+        if f.HubURL == "" || f.Site == "" {
+            return errors.New("federation.mode=edge requires hubURL and site")
+        }
+        if f.PollInterval.Duration <= 0 {
+            f.PollInterval.Duration = time.Minute
+        }
+        if f.APIKey == "" {
+            f.APIKey = os.Getenv("LIGHTCMDB_FEDERATION_API_KEY")
+        }
+    default:
+        return fmt.Errorf("unknown federation.mode %q", f.Mode)
+    }
+    if c.History.CompactWindow.Duration <= 0 {
+        c.History.CompactWindow.Duration = 10 * time.Minute
+    }
+    if c.ArgoCD.InstanceLabel == "" {
+        c.ArgoCD.InstanceLabel = "app.kubernetes.io/instance"
+    }
+    if c.Events.Window.Duration <= 0 {
+        c.Events.Window.Duration = 10 * time.Minute
+    }
+    if c.Events.Retention.Duration <= 0 {
+        c.Events.Retention.Duration = 7 * 24 * time.Hour
+    }
+    if c.PodIPs.Retention.Duration <= 0 {
+        c.PodIPs.Retention.Duration = 30 * 24 * time.Hour
+    }
+    if c.Baselines.Keep <= 0 {
+        c.Baselines.Keep = 7
+    }
+    if c.Quotas.Threshold <= 0 {
+        c.Quotas.Threshold = 0.9
+    }
+    if c.History.CompactInterval.Duration <= 0 {
+        c.History.CompactInterval.Duration = time.Hour
+    }
+    if c.History.Debounce.Duration < 0 {
+        return errors.New("history.debounce must not be negative")
+    }
+    for ns, d := range c.History.DebounceNamespaces {
+        if d.Duration < 0 {
+            return fmt.Errorf("history.debounceNamespaces.%s must not be negative", ns)
+        }
+    }
+    l := &c.Limits
+    if l.RatePerSecond < 0 || l.Burst < 0 || l.MaxRows < 0 || l.MaxBodyBytes < 0 {
+        return errors.New("limits must not be negative")
+    }
+    if l.Burst == 0 {
+        l.Burst = max(1, int(math.Ceil(2*l.RatePerSecond)))
+    }
+    if l.MaxBodyBytes == 0 {
+        l.MaxBodyBytes = 1 << 20
+    }
+    if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+        return errors.New("tls requires both certFile and keyFile")
+    }
+    if o := &c.Auth.OIDC; o.IssuerURL != "" {
+        if o.ClientID == "" || o.RedirectURL == "" {
+            return errors.New("auth.oidc requires clientID and redirectURL")
+        }
+        if len(o.Scopes) == 0 {
+            o.Scopes = []string{"openid", "email", "profile"}
+        }
+        if o.GroupsClaim == "" {
+            o.GroupsClaim = "groups"
+        }
+        if len(o.Groups) == 0 {
+            return errors.New("auth.oidc.groups is empty, no user could see anything")
+        }
+    }
+    if m := &c.Masking; len(m.Fields) > 0 || len(m.Annotations) > 0 {
+        if m.Replacement == "" {
+            m.Replacement = defaultMaskReplacement
+        }
+        for _, f := range m.Fields {
+            if maskName(f) == "" {
+                return fmt.Errorf("masking.fields: empty field name %q", f)
+            }
+        }
+        for _, a := range m.Annotations {
+            if _, err := path.Match(a, ""); err != nil {
+                return fmt.Errorf("masking.annotations: %q: %v", a, err)
+            }
+        }
+    }
+    if g := &c.GitSnapshot; g.Enabled {
+        if g.Dir == "" {
+            g.Dir = filepath.Join(c.Storage.DataDir, "snapshot")
+        }
+        if g.Interval.Duration <= 0 {
+            g.Interval.Duration = time.Hour
+        }
+        if g.Branch == "" {
+            g.Branch = "main"
+        }
+        if g.AuthorName == "" {
+            g.AuthorName = "LightCMDB"
+        }
+        if g.AuthorEmail == "" {
+            g.AuthorEmail = "lightcmdb@localhost"
+        }
+    }
+    if c.NodeHooks.Debounce.Duration <= 0 {
+        c.NodeHooks.Debounce.Duration = time.Minute
+    }
+    if c.NodeHooks.QueueSize <= 0 {
+        c.NodeHooks.QueueSize = 256
+    }
+    if cors := &c.CORS; len(cors.AllowedOrigins) > 0 {
+        // 中间件总是回显具体来源，"*" 加凭据等于把带 cookie 的跨域请求开放给任意网站
+        if cors.AllowCredentials && slices.Contains(cors.AllowedOrigins, "*") {
+            return fmt.Errorf(`cors: allowedOrigins "*" cannot be combined with allowCredentials: true; list the origins`)
+        }
+        if len(cors.AllowedMethods) == 0 {
+            cors.AllowedMethods = []string{"GET", "POST"}
+        }
+        if len(cors.AllowedHeaders) == 0 {
+            cors.AllowedHeaders = []string{"Authorization", "Content-Type", "X-API-Key"}
+        }
+        if len(cors.ExposedHeaders) == 0 {
+            cors.ExposedHeaders = []string{"Content-Disposition", "Retry-After", "X-Request-ID"}
+        }
+        if cors.MaxAge.Duration <= 0 {
+            cors.MaxAge.Duration = 10 * time.Minute
+        }
+    }
+    if c.Exporters == nil {
+        c.Exporters = map[string]ExporterConfig{}
+    }
+    for name := range c.Exporters {
+        if !slices.Contains(exporterNames, name) {
+            return fmt.Errorf("exporters: unknown exporter %q", name)
+        }
+    }
+    for _, name := range exporterNames {
+        e := c.Exporters[name]
+        if e.Retry.Attempts <= 0 {
+            e.Retry.Attempts = 3
+        }
+        if e.Retry.Backoff.Duration <= 0 {
+            e.Retry.Backoff.Duration = time.Second
+        }
+        if e.Retry.MaxBackoff.Duration <= 0 {
+            e.Retry.MaxBackoff.Duration = time.Minute
+        }
+        c.Exporters[name] = e
+    }
+    if sc := &c.Scanner; sc.Interval.Duration > 0 {
+        if sc.Rescan.Duration <= 0 {
+            sc.Rescan.Duration = 24 * time.Hour
+        }
+        if sc.Trivy == "" {
+            sc.Trivy = "trivy"
+        }
+        if sc.Timeout.Duration <= 0 {
+            sc.Timeout.Duration = 10 * time.Minute
+        }
+    }
+    if h := &c.HostDiscovery; h.Interval.Duration > 0 {
+        if len(h.Targets) == 0 || h.User == "" || h.PrivateKeyFile == "" {
+            return errors.New("hostDiscovery requires targets, user and privateKeyFile")
+        }
+        if h.KnownHostsFile == "" && !h.InsecureIgnoreHostKey {
+            return errors.New("hostDiscovery requires knownHostsFile (or insecureIgnoreHostKey: true)")
+        }
+        if _, err := expandTargets(h.Targets, "22"); err != nil {
+            return fmt.Errorf("hostDiscovery: %w", err)
+        }
+        if h.Concurrency <= 0 {
+            h.Concurrency = 8
+        }
+        if h.Timeout.Duration <= 0 {
+            h.Timeout.Duration = 30 * time.Second
+        }
+    }
+    if s := &c.SNMP; s.Interval.Duration > 0 {
+        if s.Community == "" {
+            s.Community = os.Getenv("LIGHTCMDB_SNMP_COMMUNITY")
+        }
+        if len(s.Targets) == 0 || s.Community == "" {
+            return errors.New("snmp requires targets and community (or LIGHTCMDB_SNMP_COMMUNITY)")
+        }
+        if _, err := expandTargets(s.Targets, "161"); err != nil {
+            return fmt.Errorf("snmp: %w", err)
+        }
+        if s.Concurrency <= 0 {
+            s.Concurrency = 8
+        }
+        if s.Timeout.Duration <= 0 {
+            s.Timeout.Duration = 2 * time.Second
+        }
+        if s.Retries < 0 {
+            return errors.New("snmp.retries must not be negative")
+        }
+        if s.Retries == 0 {
+            s.Retries = 1
+        }
+    }
+    for i, p := range c.Cloud.Providers {
+        if _, ok := cloudProviders[p.Provider]; !ok {
+            return fmt.Errorf("cloud.providers[%d]: unknown provider %q", i, p.Provider)
+        }
+        if len(p.Regions) == 0 {
+            return fmt.Errorf("cloud.providers[%d]: regions is empty", i)
+        }
+    }
+    if c.Cloud.Interval.Duration > 0 && len(c.Cloud.Providers) == 0 {
+        return errors.New("cloud.interval requires providers")
+    }
+    if c.Costs.Currency == "" {
+        c.Costs.Currency = "USD"
+    }
+    if c.Costs.DefaultHourly < 0 {
+        return errors.New("costs.defaultHourly must not be negative")
+    }
+    for i, r := range c.Costs.Nodes {
+        if (r.InstanceType == "") == (r.Selector == "") {
+            return fmt.Errorf("costs.nodes[%d]: exactly one of instanceType and selector is required", i)
+        }
+        if r.Selector != "" && len(parseLabels(r.Selector)) == 0 {
+            return fmt.Errorf("costs.nodes[%d]: invalid selector %q", i, r.Selector)
+        }
+        if r.Hourly < 0 {
+            return fmt.Errorf("costs.nodes[%d]: hourly must not be negative", i)
+        }
+    }
+    groups := map[string]bool{}
+    for i, g := range c.NodeGroups {
+        if (g.Selector == "") == (g.Label == "") {
+            return fmt.Errorf("nodeGroups[%d]: exactly one of selector and label is required", i)
+        }
+        if g.Name == "" {
+            if g.Label == "" {
+                return fmt.Errorf("nodeGroups[%d]: name is required with selector", i)
+            }
+            c.NodeGroups[i].Name = g.Label
+        }
+        if groups[c.NodeGroups[i].Name] {
+            return fmt.Errorf("nodeGroups[%d]: duplicate name %q", i, c.NodeGroups[i].Name)
+        }
+        groups[c.NodeGroups[i].Name] = true
+        if g.Selector != "" {
+            if _, err := labels.Parse(g.Selector); err != nil {
+                return fmt.Errorf("nodeGroups[%d]: invalid selector: %v", i, err)
+            }
+        }
+    }
+    if rw := &c.RemoteWrite; rw.URL != "" {
+        if u, err := url.Parse(rw.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+            return fmt.Errorf("remoteWrite.url %q must be an http(s) URL", rw.URL)
+        }
+        if rw.Interval.Duration <= 0 {
+            rw.Interval.Duration = time.Minute
+        }
+        for _, name := range rw.Metrics {
+            if !metricNamePattern.MatchString(name) {
+                return fmt.Errorf("remoteWrite.metrics: invalid metric name %q", name)
+            }
+        }
+        for k, v := range rw.ExternalLabels {
+            if !labelNamePattern.MatchString(k) || strings.HasPrefix(k, "__") || v == "" {
+                return fmt.Errorf("remoteWrite.externalLabels: invalid label %q=%q", k, v)
+            }
+        }
+        if rw.BearerToken == "" {
+            rw.BearerToken = os.Getenv("LIGHTCMDB_REMOTE_WRITE_TOKEN")
+        }
+    }
+    if sn := &c.ServiceNow; sn.Instance != "" {
+        if sn.Password == "" {
+            sn.Password = os.Getenv("LIGHTCMDB_SERVICENOW_PASSWORD")
+        }
+        if sn.User == "" || sn.Password == "" {
+            return errors.New("serviceNow requires user and password (or LIGHTCMDB_SERVICENOW_PASSWORD)")
+        }
+        if len(sn.Mappings) == 0 {
+            return errors.New("serviceNow.mappings is empty")
+        }
+        if sn.Interval.Duration <= 0 {
+            sn.Interval.Duration = time.Hour
+        }
+        if sn.CorrelationPrefix == "" {
+            sn.CorrelationPrefix = "lightcmdb"
+        }
+        for i, m := range sn.Mappings {
+            h, ok := historySourceOf(m.Kind)
+            if !ok {
+                return fmt.Errorf("serviceNow.mappings[%d]: unknown kind %q", i, m.Kind)
+            }
+            if m.Table == "" || len(m.Fields) == 0 {
+                return fmt.Errorf("serviceNow.mappings[%d]: table and fields are required", i)
+            }
+            for field, col := range m.Fields {
+                if field == "correlation_id" || field == "sys_id" {
+                    return fmt.Errorf("serviceNow.mappings[%d]: field %s is managed by LightCMDB", i, field)
+                }
+                if !strings.HasPrefix(col, "=") && col != "ref" && !slices.Contains(h.Columns, col) {
+                    return fmt.Errorf("serviceNow.mappings[%d]: %s has no column %q (one of ref, %s)", i, m.Kind, col, strings.Join(h.Columns, ", "))
+                }
+            }
+        }
+    }
+    for i, r := range c.Tagging.Rules {
+        if _, err := compileTagRule(r); err != nil {
+            return fmt.Errorf("tagging.rules[%d]: %v", i, err)
+        }
+    }
+    if a := &c.Alerts; len(a.Rules) > 0 {
+        if a.Interval.Duration <= 0 {
+            a.Interval.Duration = 30 * time.Second
+        }
+        targets := map[string]bool{}
+        for i, t := range a.Targets {
+            if t.Name == "" || targets[t.Name] {
+                return fmt.Errorf("alerts.targets[%d]: name is empty or duplicated", i)
+            }
+            if t.Type != "slack" && t.Type != "webhook" {
+                return fmt.Errorf("alerts.targets[%d]: type must be slack or webhook", i)
+            }
+            if t.URL == "" {
+                return fmt.Errorf("alerts.targets[%d]: url is required", i)
+            }
+            targets[t.Name] = true
+        }
+        rules := map[string]bool{}
+        for i, r := range a.Rules {
+            if r.Name == "" || rules[r.Name] {
+                return fmt.Errorf("alerts.rules[%d]: name is empty or duplicated", i)
+            }
+            rules[r.Name] = true
+            if _, err := compileAlertRule(r); err != nil {
+                return fmt.Errorf("alerts.rules[%d]: %w", i, err)
+            }
+            if len(r.Targets) == 0 {
+                return fmt.Errorf("alerts.rules[%d]: targets is empty", i)
+            }
+            for _, t := range r.Targets {
+                if !targets[t] {
+                    return fmt.Errorf("alerts.rules[%d]: unknown target %q", i, t)
+                }
+            }
+        }
+    }
+    rc := &c.Reports
+    if rc.TopImages <= 0 {
+        rc.TopImages = 10
+    }
+    if rc.At == "" {
+        rc.At = "06:00"
+    }
+    if _, err := time.Parse("15:04", rc.At); err != nil {
+        return fmt.Errorf("reports.at %q: expected HH:MM", rc.At)
+    }
+    if e := &rc.Email; e.SMTP != "" {
+        if e.From == "" || len(e.To) == 0 {
+            return errors.New("reports.email requires from and to")
+        }
+        if e.User != "" && e.Password == "" {
+            e.Password = os.Getenv("LIGHTCMDB_SMTP_PASSWORD")
+        }
+    }
+    switch rc.Schedule {
+    case "":
+    case "daily", "weekly":
+        if rc.WebhookURL == "" && rc.Email.SMTP == "" {
+            return errors.New("reports requires webhookURL or email.smtp")
+        }
+    default:
+        return fmt.Errorf("reports.schedule must be daily or weekly, got %q", rc.Schedule)
+    }
+    for _, k := range c.LiveProxy.Kinds {
+        if k != "pods" && k != "nodes" {
+            return fmt.Errorf("liveProxy: unsupported kind %q", k)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func loadTestConfig(t *testing.T, yaml string) (*Config, error) {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "lightcmdb.yaml")
+    if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("LIGHTCMDB_CONFIG", path)
+    return loadConfig()
+}
+
+func TestConfigRejectsWildcardOriginWithCredentials(t *testing.T) {
+    _, err := loadTestConfig(t, "cors:\n  allowedOrigins: [\"*\"]\n  allowCredentials: true\n")
+    if err == nil || !strings.Contains(err.Error(), "allowCredentials") {
+        t.Fatalf("err = %v, want a cors allowCredentials error", err)
+    }
+    for _, yaml := range []string{
+        "cors:\n  allowedOrigins: [\"*\"]\n",
+        "cors:\n  allowedOrigins: [https://dashboard.example.com]\n  allowCredentials: true\n",
+    } {
+        if _, err := loadTestConfig(t, yaml); err != nil {
+            t.Errorf("%q: %v", yaml, err)
+        }
+    }
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+    "net/http"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ---------- Consumer usage ----------
+
+// 按调用方统计导出的数据量：consumer 和限流用同一个标识（key:<名字>，未认证时 ip:<地址>），
+// integration 取 User-Agent 的第一个产品名（Grafana、python-requests、lightcmdb-cli ...），同一个 key 被多个系统共用时能分开。
+// 每分钟拉一遍全量的调用方在 /admin/consumers 里按 bytes 排在最前，也可以用 lightcmdb_export_bytes_total 做告警。
+// 组合数有上限，超出后记到 consumer="other"，避免随意的 User-Agent 把指标撑爆。
+const usageMaxEntries = 256
+
+type usageKey struct {
+    consumer    string
+    integration string
+}
+
+type PathUsage struct {
+    Requests int64 `json:"requests"`
+    Rows     int64 `json:"rows"`
+    Bytes    int64 `json:"bytes"`
+}
+
+type ConsumerUsage struct {
+    Consumer    string `json:"consumer"`
+    Integration string `json:"integration"`
+    Requests    int64  `json:"requests"`
+    // 列表接口输出的行数；GraphQL、topology 等只计字节
+    Rows     int64  `json:"rows"`
+    Bytes    int64  `json:"bytes"`
+    LastPath string `json:"lastPath"`
+    LastSeen string `json:"lastSeen"`
+    // 其中走旧路径（不带 /api/v1）的请求，迁移前看还有谁在用
+    LegacyRequests int64 `json:"legacyRequests"`
+    // 按接口（路径前两段）细分
+    Paths map[string]*PathUsage `json:"paths"`
+}
+
+type ConsumerReport struct {
+    Since     string          `json:"since"`
+    Consumers []ConsumerUsage `json:"consumers"`
+}
+
+type usageTracker struct {
+    mu      sync.Mutex
+    started time.Time
+    entries map[usageKey]*ConsumerUsage
+}
+
+func newUsageTracker() *usageTracker {
+    return &usageTracker{started: time.Now(), entries: map[usageKey]*ConsumerUsage{}}
+}
+
+func integrationOf(r *http.Request) string {
+    f := strings.Fields(r.UserAgent())
+    if len(f) == 0 {
+        return "unknown"
+    }
+    name, _, _ := strings.Cut(f[0], "/")
+    if len(name) > 64 {
+        name = name[:64]
+    }
+    return name
+}
+
+// /cmdb/clusters/edge-07 -> /cmdb/clusters
+func usagePath(p string) string {
+    parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+    if len(parts) > 2 {
+        parts = parts[:2]
+    }
+    return "/" + strings.Join(parts, "/")
+}
+
+// 放在认证之后：principal 已经在 ctx 里
+func (u *usageTracker) wrap(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        consumer := clientKey(r)
+        if ai := accessInfoFrom(r.Context()); ai != nil {
+            ai.consumer = consumer
+        }
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r)
+        var rows int64
+        if ai := accessInfoFrom(r.Context()); ai != nil {
+            rows = ai.rows
+        }
+        u.record(usageKey{consumer, integrationOf(r)}, usagePath(r.URL.Path), rows, rec.bytes, envelopeFrom(r.Context()) == nil)
+    })
+}
+
+func (u *usageTracker) record(k usageKey, path string, rows, bytes int64, legacy bool) {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    e := u.entries[k]
+    if e == nil {
+        if len(u.entries) >= usageMaxEntries {
+            k = usageKey{"other", "other"}
+        }
+        if e = u.entries[k]; e == nil {
+            e = &ConsumerUsage{Consumer: k.consumer, Integration: k.integration, Paths: map[string]*PathUsage{}}
+            u.entries[k] = e
+        }
+    }
+    e.Requests++
+    if legacy {
+        e.LegacyRequests++
+    }
+    e.Rows += rows
+    e.Bytes += bytes
+    e.LastPath = path
+    e.LastSeen = time.Now().UTC().Format(time.RFC3339)
+    p := e.Paths[path]
+    if p == nil {
+        p = &PathUsage{}
+        e.Paths[path] = p
+    }
+    p.Requests++
+    p.Rows += rows
+    p.Bytes += bytes
+}
+
+// 按 bytes 从大到小
+func (u *usageTracker) report() ConsumerReport {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    rep := ConsumerReport{Since: u.started.UTC().Format(time.RFC3339), Consumers: []ConsumerUsage{}}
+    for _, e := range u.entries {
+        c := *e
+        c.Paths = map[string]*PathUsage{}
+        for path, p := range e.Paths {
+            cp := *p
+            c.Paths[path] = &cp
+        }
+        rep.Consumers = append(rep.Consumers, c)
+    }
+    slices.SortFunc(rep.Consumers, func(a, b ConsumerUsage) int {
+        if a.Bytes != b.Bytes {
+            if a.Bytes > b.Bytes {
+                return -1
+            }
+            return 1
+        }
+        return strings.Compare(a.Consumer+"/"+a.Integration, b.Consumer+"/"+b.Integration)
+    })
+    return rep
+}
+
+func (u *usageTracker) registerMetrics(m *metricsRegistry) {
+    counter := func(name, help string, value func(*ConsumerUsage) int64) {
+        m.register(metricFamily{Name: name, Type: "counter", Help: help,
+            Collect: func() []metricSample {
+                var out []metricSample
+                for _, c := range u.report().Consumers {
+                    out = append(out, metricSample{Labels: []metricLabel{{"consumer", c.Consumer}, {"integration", c.Integration}},
+                        Value: float64(value(&c))})
+                }
+                return out
+            }})
+    }
+    counter("lightcmdb_export_requests", "API requests, by consumer and integration", func(c *ConsumerUsage) int64 { return c.Requests })
+    counter("lightcmdb_export_rows", "List rows returned, by consumer and integration", func(c *ConsumerUsage) int64 { return c.Rows })
+    counter("lightcmdb_export_bytes", "Response bytes returned, by consumer and integration", func(c *ConsumerUsage) int64 { return c.Bytes })
+    counter("lightcmdb_export_legacy_requests", "API requests on paths without /api/v1, by consumer and integration",
+        func(c *ConsumerUsage) int64 { return c.LegacyRequests })
+}
+
+// GET /admin/consumers
+func consumersAPI(u *usageTracker) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        writeJSON(w, r, u.report())
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// ---------- CORS ----------
+
+// 让浏览器里的前端直接调 API；放在认证外层，预检请求（OPTIONS）不带凭据，直接在这里回。
+// 不在 allowedOrigins 里的来源不加任何 CORS 头，由浏览器拦截。
+type corsPolicy struct {
+    cfg       CORSConfig
+    origins   map[string]bool
+    anyOrigin bool
+}
+
+func newCORSPolicy(cfg CORSConfig) *corsPolicy {
+    c := &corsPolicy{cfg: cfg, origins: map[string]bool{}}
+    for _, o := range cfg.AllowedOrigins {
+        if o == "*" {
+            c.anyOrigin = true
+        }
+        c.origins[strings.TrimRight(o, "/")] = true
+    }
+    return c
+}
+
+func (c *corsPolicy) allowed(origin string) bool {
+    return c.anyOrigin || c.origins[origin]
+}
+
+func (c *corsPolicy) wrap(next http.Handler) http.Handler {
+    if len(c.origins) == 0 {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        origin := r.Header.Get("Origin")
+        if origin == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        h := w.Header()
+        h.Add("Vary", "Origin")
+        if !c.allowed(origin) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        // 带凭据时规范不允许 "*"，一律回显具体来源
+        h.Set("Access-Control-Allow-Origin", origin)
+        if c.cfg.AllowCredentials {
+            h.Set("Access-Control-Allow-Credentials", "true")
+        }
+        if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+            h.Add("Vary", "Access-Control-Request-Method")
+            h.Add("Vary", "Access-Control-Request-Headers")
+            h.Set("Access-Control-Allow-Methods", strings.Join(c.cfg.AllowedMethods, ", "))
+            h.Set("Access-Control-Allow-Headers", strings.Join(c.cfg.AllowedHeaders, ", "))
+            if c.cfg.MaxAge.Duration > 0 {
+                h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.cfg.MaxAge.Seconds())))
+            }
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        if len(c.cfg.ExposedHeaders) > 0 {
+            h.Set("Access-Control-Expose-Headers", strings.Join(c.cfg.ExposedHeaders, ", "))
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+
+    "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ---------- Cost allocation ----------
+
+// 按当前库存算每小时的成本分摊：节点单价来自 costs.nodes（按 instance type 或标签匹配，第一条命中的生效，都不中用 defaultHourly），
+// 节点成本按 Pod 的 requests 占节点 capacity 的比例分给 Running 的 Pod，CPU 和内存各占一半权重，再按 namespace 或 workload 汇总。
+// 没被 requests 占用的部分归到 "(idle)" 行，各行相加等于全部节点的成本。
+// 这里是当前的小时费率（monthlyCost 按 730 小时折算），不是按月回放的实际值：Pod 的 owner 不在 history 里。
+const costHoursPerMonth = 730
+
+var instanceTypeLabels = []string{"node.kubernetes.io/instance-type", "beta.kubernetes.io/instance-type"}
+
+type CostRow struct {
+    Namespace string `json:"namespace"`
+    // by=workload 时为 Deployment/<name>、StatefulSet/<name>、Pod/<name> 等；by=namespace 时为空
+    Workload           string  `json:"workload"`
+    Pods               int     `json:"pods"`
+    CPURequestMilli    int64   `json:"cpuRequestMilli"`
+    MemoryRequestBytes int64   `json:"memoryRequestBytes"`
+    HourlyCost         float64 `json:"hourlyCost"`
+    MonthlyCost        float64 `json:"monthlyCost"`
+    Currency           string  `json:"currency"`
+}
+
+// 第一条匹配的规则；selector 与 Service selector 同样写成 k=v,k=v
+func nodeHourlyCost(cfg CostConfig, labels map[string]string, flat string) float64 {
+    for _, r := range cfg.Nodes {
+        if r.InstanceType != "" {
+            for _, l := range instanceTypeLabels {
+                if labels[l] == r.InstanceType {
+                    return r.Hourly
+                }
+            }
+            continue
+        }
+        if selectorMatches(r.Selector, flat) {
+            return r.Hourly
+        }
+    }
+    return cfg.DefaultHourly
+}
+
+type costNode struct {
+    hourly   float64
+    cpu, mem int64
+}
+
+func loadCostNodes(q querier, cfg CostConfig) (map[string]costNode, error) {
+    rows, err := q.Query(`SELECT name,coalesce(labels,''),coalesce(capacity_cpu,''),coalesce(capacity_mem,'') FROM nodes`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string]costNode{}
+    for rows.Next() {
+        var name, labels, cpu, mem string
+        if err := rows.Scan(&name, &labels, &cpu, &mem); err != nil {
+            return nil, err
+        }
+        n := costNode{hourly: nodeHourlyCost(cfg, parseLabels(labels), labels)}
+        if v, err := resource.ParseQuantity(cpu); err == nil {
+            n.cpu = v.MilliValue()
+        }
+        if v, err := resource.ParseQuantity(mem); err == nil {
+            n.mem = v.Value()
+        }
+        out[name] = n
+    }
+    return out, rows.Err()
+}
+
+// requests 占比，capacity 未知的一项不计权重；超过 1 的按 1 算
+func requestShare(cpu, mem int64, n costNode) float64 {
+    var parts []float64
+    if n.cpu > 0 {
+        parts = append(parts, float64(cpu)/float64(n.cpu))
+    }
+    if n.mem > 0 {
+        parts = append(parts, float64(mem)/float64(n.mem))
+    }
+    if len(parts) == 0 {
+        return 0
+    }
+    var s float64
+    for _, p := range parts {
+        s += p
+    }
+    return min(1, s/float64(len(parts)))
+}
+
+func roundCost(v float64) float64 {
+    return math.Round(v*10000) / 10000
+}
+
+func computeCosts(q querier, cfg CostConfig, by string, scope nsScope) ([]CostRow, error) {
+    nodes, err := loadCostNodes(q, cfg)
+    if err != nil {
+        return nil, err
+    }
+    // ReplicaSet 再往上找一层到 Deployment，和 GraphQL 的 deployment 关系一致
+    where, args := scope.where("p.namespace", "p.phase='Running' AND coalesce(p.node_name,'')<>''")
+    rows, err := q.Query(`
+SELECT p.namespace,p.name,p.node_name,coalesce(p.cpu_request,0),coalesce(p.mem_request,0),
+ coalesce(CASE WHEN p.owner_kind='ReplicaSet' AND rs.owner_kind<>'' THEN rs.owner_kind ELSE p.owner_kind END,''),
+ coalesce(CASE WHEN p.owner_kind='ReplicaSet' AND rs.owner_kind<>'' THEN rs.owner_name ELSE p.owner_name END,'')
+FROM pods p LEFT JOIN replicasets rs ON p.owner_kind='ReplicaSet' AND rs.uid=p.owner_uid`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    groups := map[[2]string]*CostRow{}
+    used := map[string]float64{}
+    for rows.Next() {
+        var ns, name, node, kind, owner string
+        var cpu, mem int64
+        if err := rows.Scan(&ns, &name, &node, &cpu, &mem, &kind, &owner); err != nil {
+            return nil, err
+        }
+        key := [2]string{ns, ""}
+        if by == "workload" {
+            key[1] = "Pod/" + name
+            if kind != "" {
+                key[1] = kind + "/" + owner
+            }
+        }
+        g := groups[key]
+        if g == nil {
+            g = &CostRow{Namespace: ns, Workload: key[1], Currency: cfg.Currency}
+            groups[key] = g
+        }
+        g.Pods++
+        g.CPURequestMilli += cpu
+        g.MemoryRequestBytes += mem
+        // 节点不在库里时没有单价，只计 requests
+        if n, ok := nodes[node]; ok {
+            share := min(requestShare(cpu, mem, n), 1-used[node])
+            used[node] += share
+            g.HourlyCost += share * n.hourly
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    out := make([]CostRow, 0, len(groups)+1)
+    for _, g := range groups {
+        out = append(out, *g)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Namespace != out[j].Namespace {
+            return out[i].Namespace < out[j].Namespace
+        }
+        return out[i].Workload < out[j].Workload
+    })
+    // 空闲部分属于整个集群，限定 namespace 的 key 看不到
+    if scope == nil {
+        idle := CostRow{Workload: "(idle)", Currency: cfg.Currency}
+        for name, n := range nodes {
+            idle.HourlyCost += (1 - used[name]) * n.hourly
+        }
+        out = append(out, idle)
+    }
+    for i := range out {
+        out[i].HourlyCost = roundCost(out[i].HourlyCost)
+        out[i].MonthlyCost = roundCost(out[i].HourlyCost * costHoursPerMonth)
+    }
+    return out, nil
+}
+
+// GET /cmdb/costs?by=namespace|workload[&format=csv]
+func costsAPI(db *sql.DB, cfg CostConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        by := r.URL.Query().Get("by")
+        if by == "" {
+            by = "namespace"
+        }
+        if by != "namespace" && by != "workload" {
+            http.Error(w, "by must be namespace or workload", 400)
+            return
+        }
+        var out []CostRow
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            out, err = computeCosts(dbFrom(ctx, db), cfg, by, scopeOf(r.Context()))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "costs-by-"+by, CostRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write costs: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "net/url"
+    "os"
+    "strings"
+    "text/tabwriter"
+)
+
+// ---------- CLI ----------
+
+// lightcmdb ctl（或把二进制链接成 lightcmdbctl）：kubectl 风格的命令行，替代 curl+jq，
+// 例如 get pods -n prod、get nodes -o wide、search 10.42.0.5 -o json。
+// 和 tui 一样只走 HTTP API，服务器地址 / token 取 -server / -token 或 $LIGHTCMDB_URL / $LIGHTCMDB_TOKEN。
+type ctlColumn struct {
+    Header string
+    Value  func(item map[string]any) string
+    // 只在 -o wide 时显示
+    Wide bool
+}
+
+type ctlResource struct {
+    Path string
+    Cols []ctlColumn
+    // 支持 -n 过滤（集群级资源忽略 -n）
+    Namespaced bool
+}
+
+func ctlField(key string) func(map[string]any) string {
+    return func(item map[string]any) string { return tuiField(item, key) }
+}
+
+// 数值字段按 div 缩放后取整，用于 毫核 / MiB 显示
+func ctlScaled(key string, div float64) func(map[string]any) string {
+    return func(item map[string]any) string {
+        v, ok := item[key].(float64)
+        if !ok {
+            return ""
+        }
+        return fmt.Sprintf("%.0f", v/div)
+    }
+}
+
+var ctlResources = map[string]ctlResource{
+    "pods": {Path: "/cmdb/pods", Namespaced: true, Cols: []ctlColumn{
+        {Header: "NAMESPACE", Value: ctlField("namespace")},
+        {Header: "NAME", Value: ctlField("name")},
+        {Header: "PHASE", Value: ctlField("phase")},
+        {Header: "NODE", Value: ctlField("nodeName")},
+        {Header: "IP", Value: ctlField("podIP"), Wide: true},
+        {Header: "CPU(m)", Value: ctlScaled("cpuRequestMilli", 1), Wide: true},
+        {Header: "MEM(Mi)", Value: ctlScaled("memoryRequestBytes", 1<<20), Wide: true},
+        {Header: "UPDATED", Value: ctlField("updatedAt"), Wide: true},
+    }},
+    "nodes": {Path: "/cmdb/nodes", Cols: []ctlColumn{
+        {Header: "NAME", Value: ctlField("name")},
+        {Header: "INTERNAL-IP", Value: ctlField("internalIP")},
+        {Header: "CPU", Value: ctlField("cpu")},
+        {Header: "MEMORY", Value: ctlField("memory")},
+        {Header: "LABELS", Value: ctlField("labels"), Wide: true},
+        {Header: "UPDATED", Value: ctlField("updatedAt"), Wide: true},
+    }},
+    "assets": {Path: "/cmdb/assets", Cols: []ctlColumn{
+        {Header: "TYPE", Value: ctlField("type")},
+        {Header: "NAME", Value: ctlField("name")},
+        {Header: "SITE", Value: ctlField("site")},
+        {Header: "OWNER", Value: ctlField("owner")},
+        {Header: "LABELS", Value: ctlField("labels"), Wide: true},
+        {Header: "UPDATED", Value: ctlField("updatedAt"), Wide: true},
+    }},
+}
+
+var ctlAliases = map[string]string{
+    "pod": "pods", "po": "pods",
+    "node": "nodes", "no": "nodes",
+    "asset": "assets",
+}
+
+var ctlSearchColumns = []ctlColumn{
+    {Header: "TYPE", Value: ctlField("type")},
+    {Header: "NAMESPACE", Value: ctlField("namespace")},
+    {Header: "NAME", Value: ctlField("name")},
+    {Header: "MATCH", Value: ctlField("match")},
+    {Header: "ID", Value: ctlField("id"), Wide: true},
+}
+
+const ctlUsage = `usage:
+  lightcmdbctl get pods|nodes|assets [-n namespace] [-l selector] [-o table|wide|json]
+  lightcmdbctl search <text> [-o table|wide|json]
+
+flags may appear anywhere: -server URL, -token TOKEN, -insecure`
+
+// flag 包遇到第一个位置参数就停，这里允许 flag 和位置参数交替出现
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+    var pos []string
+    for {
+        if err := fs.Parse(args); err != nil {
+            return nil, err
+        }
+        args = fs.Args()
+        if len(args) == 0 {
+            return pos, nil
+        }
+        pos = append(pos, args[0])
+        args = args[1:]
+    }
+}
+
+func runCtl(args []string, stdout io.Writer) error {
+    fs := flag.NewFlagSet("lightcmdbctl", flag.ContinueOnError)
+    fs.Usage = func() { fmt.Fprintln(fs.Output(), ctlUsage) }
+    server := fs.String("server", envOr("LIGHTCMDB_URL", "http://localhost:8080"), "LightCMDB base URL")
+    token := fs.String("token", os.Getenv("LIGHTCMDB_TOKEN"), "API key or OIDC ID token")
+    insecure := fs.Bool("insecure", false, "skip TLS certificate verification")
+    ns := fs.String("n", "", "namespace")
+    selector := fs.String("l", "", "label selector, e.g. env=prod,tier in (web,api)")
+    output := fs.String("o", "table", "output format: table, wide or json")
+    pos, err := parseInterleaved(fs, args)
+    if errors.Is(err, flag.ErrHelp) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    switch *output {
+    case "table", "wide", "json":
+    default:
+        return fmt.Errorf("unknown output format %q", *output)
+    }
+    c := newAPIClient(*server, *token, *insecure)
+
+    var path string
+    var q url.Values
+    var cols []ctlColumn
+    switch {
+    case len(pos) == 2 && pos[0] == "get":
+        name := pos[1]
+        if a, ok := ctlAliases[name]; ok {
+            name = a
+        }
+        res, ok := ctlResources[name]
+        if !ok {
+            return fmt.Errorf("unknown resource %q (pods, nodes, assets)", pos[1])
+        }
+        path, cols = res.Path, res.Cols
+        q = url.Values{}
+        if res.Namespaced && *ns != "" {
+            q.Set("ns", *ns)
+        }
+        if *selector != "" {
+            q.Set("labelSelector", *selector)
+        }
+    case len(pos) >= 2 && pos[0] == "search":
+        path, cols = "/cmdb/search", ctlSearchColumns
+        q = url.Values{"q": {strings.Join(pos[1:], " ")}}
+    default:
+        return errors.New(ctlUsage)
+    }
+
+    if *output == "json" {
+        var raw json.RawMessage
+        if err := c.Get(context.Background(), path, q, &raw); err != nil {
+            return err
+        }
+        var buf bytes.Buffer
+        if err := json.Indent(&buf, raw, "", "  "); err != nil {
+            return err
+        }
+        buf.WriteByte('\n')
+        _, err := buf.WriteTo(stdout)
+        return err
+    }
+    var items []map[string]any
+    if err := c.Get(context.Background(), path, q, &items); err != nil {
+        return err
+    }
+    return ctlTable(stdout, cols, items, *output == "wide")
+}
+
+func ctlTable(out io.Writer, cols []ctlColumn, items []map[string]any, wide bool) error {
+    if len(items) == 0 {
+        fmt.Fprintln(os.Stderr, "No resources found.")
+        return nil
+    }
+    tw := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+    var hdr []string
+    for _, c := range cols {
+        if wide || !c.Wide {
+            hdr = append(hdr, c.Header)
+        }
+    }
+    fmt.Fprintln(tw, strings.Join(hdr, "\t"))
+    for _, it := range items {
+        var cells []string
+        for _, c := range cols {
+            if wide || !c.Wide {
+                v := c.Value(it)
+                if v == "" {
+                    v = "<none>"
+                }
+                cells = append(cells, v)
+            }
+        }
+        fmt.Fprintln(tw, strings.Join(cells, "\t"))
+    }
+    return tw.Flush()
+}
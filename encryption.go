@@ -0,0 +1,199 @@
+package main
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/sha256"
+    "database/sql"
+    "database/sql/driver"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+    "sync"
+    "sync/atomic"
+
+    "modernc.org/sqlite"
+)
+
+// ---------- Field encryption at rest ----------
+
+// 用的 SQLite 驱动是纯 Go 的 modernc.org/sqlite，不支持 SQLCipher，所以在应用层对敏感字段加密：
+// pods.pod_ip、nodes.internal_ip、services.cluster_ip / lb_ips 和 pod_ips.ip。配置了 storage.encryption.keyFile
+// （或 LIGHTCMDB_DB_KEY）后这些字段写库前用 AES-256-GCM 加密，存成 enc1<hex>；lb_ips 逐个 IP 加密，逗号保留。
+// nonce 由明文的 HMAC 得出，同一个值总是加密成同一个密文：等值查询、JOIN、变更检测和 search 的精确匹配照常工作，
+// 代价是能看出两行的值是否相同，前缀和范围匹配不再可用。
+// 触发器写入的 changes、baseline 和 search 索引里存的都是密文；读取时用 SQL 函数 cmdb_unseal() 把文本里的
+// 密文替换回明文，没配置 key 或不是密文时原样返回。库里记一个校验值，换错 key 或漏配 key 时启动直接失败，
+// 避免新旧 key 的数据混在一起。已有的明文行在下次写入时加密（会在 history 里留一条只有密文变化的 update）。
+const dbKeyEnv = "LIGHTCMDB_DB_KEY"
+
+const sealPrefix = "enc1"
+
+// nonce 12 字节 + tag 16 字节 + 至少 1 字节密文，hex 后至少 58 个字符
+var sealedToken = regexp.MustCompile(sealPrefix + `[0-9a-f]{58,}`)
+
+type fieldCipher struct {
+    aead cipher.AEAD
+    mac  []byte
+}
+
+// nil 表示没有配置 key
+var dbCipher atomic.Pointer[fieldCipher]
+
+var registerUnseal sync.Once
+
+// 注册 cmdb_unseal()，要在打开连接之前
+func registerSealFunctions() {
+    registerUnseal.Do(func() {
+        sqlite.MustRegisterDeterministicScalarFunction("cmdb_unseal", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+            if s, ok := args[0].(string); ok {
+                return unsealText(s), nil
+            }
+            return args[0], nil
+        })
+    })
+}
+
+// 32 字节，hex 或 base64 编码
+func parseDBKey(s string) ([]byte, error) {
+    s = strings.TrimSpace(s)
+    if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+        return b, nil
+    }
+    if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+        return b, nil
+    }
+    return nil, errors.New("key must be 32 bytes, hex or base64 encoded (e.g. openssl rand -base64 32)")
+}
+
+// keyFile 优先，其次 LIGHTCMDB_DB_KEY；都没有时返回 nil
+func loadDBKey(cfg EncryptionConfig) ([]byte, error) {
+    raw := os.Getenv(dbKeyEnv)
+    if cfg.KeyFile != "" {
+        b, err := os.ReadFile(cfg.KeyFile)
+        if err != nil {
+            return nil, err
+        }
+        raw = string(b)
+    }
+    if strings.TrimSpace(raw) == "" {
+        return nil, nil
+    }
+    return parseDBKey(raw)
+}
+
+func newFieldCipher(key []byte) (*fieldCipher, error) {
+    derive := func(label string) []byte {
+        m := hmac.New(sha256.New, key)
+        m.Write([]byte(label))
+        return m.Sum(nil)
+    }
+    block, err := aes.NewCipher(derive("lightcmdb field encryption"))
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return &fieldCipher{aead: aead, mac: derive("lightcmdb field nonce")}, nil
+}
+
+func (c *fieldCipher) seal(s string) string {
+    m := hmac.New(sha256.New, c.mac)
+    m.Write([]byte(s))
+    nonce := m.Sum(nil)[:c.aead.NonceSize()]
+    return sealPrefix + hex.EncodeToString(c.aead.Seal(nonce, nonce, []byte(s), nil))
+}
+
+func (c *fieldCipher) open(token string) (string, bool) {
+    b, err := hex.DecodeString(strings.TrimPrefix(token, sealPrefix))
+    n := c.aead.NonceSize()
+    if err != nil || len(b) < n {
+        return "", false
+    }
+    out, err := c.aead.Open(nil, b[:n], b[n:], nil)
+    if err != nil {
+        return "", false
+    }
+    return string(out), true
+}
+
+// 写库前调用；没配置 key 或空串时原样返回
+func sealField(s string) string {
+    c := dbCipher.Load()
+    if c == nil || s == "" || sealedToken.MatchString(s) {
+        return s
+    }
+    return c.seal(s)
+}
+
+// 逗号分隔的列表逐个加密
+func sealList(s string) string {
+    if dbCipher.Load() == nil || s == "" {
+        return s
+    }
+    parts := strings.Split(s, ",")
+    for i, p := range parts {
+        parts[i] = sealField(p)
+    }
+    return strings.Join(parts, ",")
+}
+
+// 把文本（单个值、逗号列表、JSON）里的密文换回明文；解不开的保持原样
+func unsealText(s string) string {
+    c := dbCipher.Load()
+    if c == nil || !strings.Contains(s, sealPrefix) {
+        return s
+    }
+    return sealedToken.ReplaceAllStringFunc(s, func(tok string) string {
+        if plain, ok := c.open(tok); ok {
+            return plain
+        }
+        return tok
+    })
+}
+
+const keyCheckPlaintext = "lightcmdb key check"
+
+// openMigratedDB 里调用：加载 key，和库里记的校验值比对，第一次启用时写入校验值
+func setupFieldEncryption(db *sql.DB, cfg EncryptionConfig) error {
+    if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS db_encryption(id INTEGER PRIMARY KEY CHECK (id = 1), key_check TEXT NOT NULL)`); err != nil {
+        return err
+    }
+    key, err := loadDBKey(cfg)
+    if err != nil {
+        return fmt.Errorf("encryption key: %w", err)
+    }
+    var check string
+    err = db.QueryRow(`SELECT key_check FROM db_encryption WHERE id=1`).Scan(&check)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        return err
+    }
+    exists := err == nil
+    if key == nil {
+        if exists {
+            return fmt.Errorf("the DB contains encrypted fields, set storage.encryption.keyFile or %s", dbKeyEnv)
+        }
+        dbCipher.Store(nil)
+        return nil
+    }
+    c, err := newFieldCipher(key)
+    if err != nil {
+        return err
+    }
+    if exists {
+        if plain, ok := c.open(check); !ok || plain != keyCheckPlaintext {
+            return errors.New("encryption key does not match the key the DB was written with")
+        }
+    } else if _, err := db.Exec(`INSERT INTO db_encryption(id,key_check) VALUES(1,?)`, c.seal(keyCheckPlaintext)); err != nil {
+        return err
+    }
+    dbCipher.Store(c)
+    return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// ---------- /api/v1 response envelope ----------
+
+// 原来的列表接口直接返回数组，分页和数据新不新都没地方说。/api/v1/<path> 和 <path> 是同一个接口、同样的认证和参数，
+// 区别只在列表输出：JSON 包成 {"items":[...],"total":N,"page":{...},"freshness":{...},"warnings":[...]}，
+// 并按 ?page=（从 1 开始）和 ?pageSize=（默认 100，最多 1000）分页，NDJSON / CSV 只分页不包。
+// 列表接口本来就是边扫描边输出，total 是跳过和截掉的行也数上以后的总数，不另发 count 查询；limits.maxRows 按一页算。
+// 非列表接口（单个对象、POST 等）原样返回。旧路径的输出不变，可以配置成带弃用头（legacyAPI）；
+// /openapi.json 描述旧路径，/api/v1/openapi.json 描述 /api/v1，列表响应的 schema 是包好的 envelope。
+// /api/v1 下的输出只加字段、不改已有字段的含义，要改就另开 /api/v2。
+const (
+    apiV1Prefix         = "/api/v1"
+    defaultEnvelopePage = 100
+    maxEnvelopePage     = 1000
+)
+
+// 数据来源：writer 上是 syncQueue，follower 上是最后一次拉到的快照
+type freshnessSource interface {
+    // 数据截止的时间（零值表示还没有数据）和要提醒调用方的问题
+    freshness() (time.Time, []string)
+}
+
+type Freshness struct {
+    // writer 上为库和 informer 缓存一致的时间，follower 上为当前快照在 writer 上导出的时间
+    AsOf string `json:"asOf,omitempty"`
+    // 距 asOf 的秒数
+    AgeSeconds int64 `json:"ageSeconds"`
+}
+
+type PageInfo struct {
+    Page     int  `json:"page"`
+    PageSize int  `json:"pageSize"`
+    Returned int  `json:"returned"`
+    HasMore  bool `json:"hasMore"`
+}
+
+type envelopeInfo struct {
+    src            freshnessSource
+    page, pageSize int
+    total          int
+}
+
+type envelopeKey struct{}
+
+func envelopeFrom(ctx context.Context) *envelopeInfo {
+    e, _ := ctx.Value(envelopeKey{}).(*envelopeInfo)
+    return e
+}
+
+// 去掉 /api/v1 前缀后交回 mux，认证、限流、审计都按原路径走
+func apiV1(src freshnessSource, mux http.Handler) http.Handler {
+    return http.StripPrefix(apiV1Prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if envelopeFrom(r.Context()) != nil {
+            http.NotFound(w, r)
+            return
+        }
+        ctx := context.WithValue(r.Context(), envelopeKey{}, &envelopeInfo{src: src})
+        mux.ServeHTTP(w, r.WithContext(ctx))
+    }))
+}
+
+// 旧路径（没经过 apiV1）的响应加上弃用提示，让调用方知道该换到哪个路径：
+// Deprecation: true、Link: </api/v1/cmdb/pods>; rel="successor-version"，配置了 sunset 时还有 Sunset 头
+func deprecateLegacy(cfg LegacyAPIConfig, next http.Handler) http.Handler {
+    if !cfg.Deprecated {
+        return next
+    }
+    sunset := ""
+    if t, err := time.Parse(time.DateOnly, cfg.Sunset); err == nil {
+        sunset = t.UTC().Format(http.TimeFormat)
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if envelopeFrom(r.Context()) == nil {
+            h := w.Header()
+            h.Set("Deprecation", "true")
+            h.Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, apiV1Prefix, r.URL.EscapedPath()))
+            if sunset != "" {
+                h.Set("Sunset", sunset)
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func (e *envelopeInfo) parsePage(r *http.Request) error {
+    q := r.URL.Query()
+    e.page, e.pageSize = 1, defaultEnvelopePage
+    if v := q.Get("page"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            return fmt.Errorf("invalid page %q", v)
+        }
+        e.page = n
+    }
+    if v := q.Get("pageSize"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > maxEnvelopePage {
+            return fmt.Errorf("invalid pageSize %q (1-%d)", v, maxEnvelopePage)
+        }
+        e.pageSize = n
+    }
+    return nil
+}
+
+// 最外层：数总行数，只把当前页交给下层
+type pagedListWriter struct {
+    inner listWriter
+    env   *envelopeInfo
+}
+
+func (p *pagedListWriter) Write(v any) error {
+    i := p.env.total
+    p.env.total++
+    if i < (p.env.page-1)*p.env.pageSize || i >= p.env.page*p.env.pageSize {
+        return nil
+    }
+    return p.inner.Write(v)
+}
+
+func (p *pagedListWriter) Fail(err error) { p.inner.Fail(err) }
+func (p *pagedListWriter) Close() error   { return p.inner.Close() }
+
+// 和 jsonListWriter 一样边写边输出，total 等元数据放在 items 后面
+type envelopeListWriter struct {
+    w      http.ResponseWriter
+    env    *envelopeInfo
+    count  int
+    failed bool
+}
+
+func (e *envelopeListWriter) Write(v any) error {
+    b, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    sep := ","
+    if e.count == 0 {
+        sep = `{"items":[`
+    }
+    if _, err := e.w.Write(append([]byte(sep), b...)); err != nil {
+        return err
+    }
+    e.count++
+    if e.count%500 == 0 {
+        flush(e.w)
+    }
+    return nil
+}
+
+func (e *envelopeListWriter) Fail(err error) {
+    e.failed = true
+    if e.count == 0 {
+        http.Error(e.w, err.Error(), 500)
+        return
+    }
+    // 不补结尾，让客户端能发现结果被截断
+    log.Printf("[http] list aborted after %d rows: %v", e.count, err)
+}
+
+func (e *envelopeListWriter) Close() error {
+    if e.failed {
+        return nil
+    }
+    if e.count == 0 {
+        if _, err := e.w.Write([]byte(`{"items":[`)); err != nil {
+            return err
+        }
+    }
+    tail := struct {
+        Total     int       `json:"total"`
+        Page      PageInfo  `json:"page"`
+        Freshness Freshness `json:"freshness"`
+        Warnings  []string  `json:"warnings"`
+    }{Total: e.env.total, Warnings: []string{}}
+    tail.Page = PageInfo{Page: e.env.page, PageSize: e.env.pageSize, Returned: e.count,
+        HasMore: e.env.page*e.env.pageSize < e.env.total}
+    if e.env.src != nil {
+        asOf, warnings := e.env.src.freshness()
+        if !asOf.IsZero() {
+            tail.Freshness = Freshness{AsOf: asOf.UTC().Format(time.RFC3339), AgeSeconds: int64(time.Since(asOf).Seconds())}
+        }
+        tail.Warnings = append(tail.Warnings, warnings...)
+    }
+    b, err := json.Marshal(tail)
+    if err != nil {
+        return err
+    }
+    // tail 的 { 换成 ],
+    _, err = e.w.Write(append(append([]byte("],"), b[1:]...), '\n'))
+    return err
+}
+
+// 缓存都已同步、队列里没有待写的 key 时库和 informer 缓存一致，asOf 为当前时间；否则为最后一次写库成功的时间。
+// 还在初始 backfill（带进度）、缓存没同步完、暂停同步的 kind 各给一条提醒
+func (q *syncQueue) freshness() (time.Time, []string) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    var asOf time.Time
+    var warnings []string
+    current := len(q.pending) == 0
+    warming := map[string]bool{}
+    for _, kind := range q.warmingLocked() {
+        warming[kind] = true
+    }
+    for _, kind := range q.order {
+        if t := q.lastSuccess[kind]; t.After(asOf) {
+            asOf = t
+        }
+        if warming[kind] {
+            current = false
+            warnings = append(warnings, kind+": warming up, "+q.backfill[kind].progress())
+        } else if !q.kinds[kind].synced() {
+            current = false
+            warnings = append(warnings, kind+": informer cache not synced yet")
+        }
+        if _, ok := q.paused[kind]; ok {
+            current = false
+            warnings = append(warnings, kind+": sync paused")
+        }
+    }
+    if current {
+        asOf = time.Now()
+    }
+    return asOf, warnings
+}
+
+func (f *replicaFollower) freshness() (time.Time, []string) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    var warnings []string
+    if f.taken.IsZero() {
+        warnings = append(warnings, "replica: no snapshot pulled yet")
+    }
+    if f.status.LastError != "" {
+        warnings = append(warnings, "replica: last pull failed: "+f.status.LastError)
+    }
+    return f.taken, warnings
+}
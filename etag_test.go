@@ -0,0 +1,50 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// 能 unmask 的凭据拿到的 ETag 给没有 unmask 的凭据用，不能回 304 让它留着未遮盖的缓存
+func TestConditionalGETSeparatesMaskedCallers(t *testing.T) {
+    db := newTestDB(t)
+    keys := filepath.Join(t.TempDir(), "keys.yaml")
+    err := os.WriteFile(keys, []byte(`
+- {name: viewer, key: viewer-key}
+- {name: sre, key: sre-key, unmask: true}
+`), 0o600)
+    if err != nil {
+        t.Fatal(err)
+    }
+    auth, err := loadAuthenticator(AuthConfig{KeysFile: keys}, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    masker := newFieldMasker(MaskingConfig{Fields: []string{"internalIP"}, Replacement: "***"})
+    h := auth.wrap(masker.wrap(conditionalGET(db, nil, "nodes", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("[]"))
+    })))
+    get := func(key, inm string) *httptest.ResponseRecorder {
+        r := httptest.NewRequest(http.MethodGet, "/cmdb/nodes", nil)
+        r.Header.Set("Authorization", "Bearer "+key)
+        if inm != "" {
+            r.Header.Set("If-None-Match", inm)
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, r)
+        return rec
+    }
+    etag := get("sre-key", "").Header().Get("ETag")
+    if etag == "" {
+        t.Fatal("no ETag on the unmasked response")
+    }
+    if rec := get("sre-key", etag); rec.Code != http.StatusNotModified {
+        t.Fatalf("same caller revalidating = %d, want 304", rec.Code)
+    }
+    if rec := get("viewer-key", etag); rec.Code != 200 || rec.Header().Get("ETag") == etag {
+        t.Fatalf("masked caller with the unmasked ETag = %d ETag %s, want 200 with a different ETag", rec.Code, rec.Header().Get("ETag"))
+    }
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Kubernetes Events ----------
+
+// 只保存 history 跟踪的 kind 的事件，存的时候按 involvedObject 换算成 changes 的 (kind, ref)：
+// 节点的 ref 是名字，其余是 UID。/cmdb/history 的每条记录带上同一对象、时间在记录前后 window 内的事件，
+// “改了什么”和“Kubernetes 当时说了什么”出现在一起。API server 默认一小时后删除事件，这里保留 retention。
+
+// involvedObject.kind -> history kind
+var eventHistoryKinds = map[string]string{
+    "Pod":                    "pod",
+    "Node":                   "node",
+    "Service":                "service",
+    "Deployment":             "deployment",
+    "VirtualMachine":         "vm",
+    "VirtualMachineInstance": "vmi",
+}
+
+// 每条 history 记录最多附带的事件数
+const maxChangeEvents = 20
+
+func initEvents(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS events(
+    uid TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    namespace TEXT,
+    name TEXT,
+    type TEXT,
+    reason TEXT,
+    message TEXT,
+    count INTEGER NOT NULL DEFAULT 1,
+    source TEXT,
+    first_seen TEXT NOT NULL,
+    last_seen TEXT NOT NULL
+);`,
+        `CREATE INDEX IF NOT EXISTS events_ref ON events(kind, ref, last_seen)`,
+        `CREATE INDEX IF NOT EXISTS events_last_seen ON events(last_seen)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 新旧两套字段（core/v1 和 events.k8s.io 写入的）都可能为空，依次回退
+func eventLastSeen(ev *corev1.Event) time.Time {
+    switch {
+    case !ev.LastTimestamp.IsZero():
+        return ev.LastTimestamp.Time
+    case ev.Series != nil && !ev.Series.LastObservedTime.IsZero():
+        return ev.Series.LastObservedTime.Time
+    case !ev.EventTime.IsZero():
+        return ev.EventTime.Time
+    }
+    return ev.CreationTimestamp.Time
+}
+
+func eventFirstSeen(ev *corev1.Event) time.Time {
+    switch {
+    case !ev.FirstTimestamp.IsZero():
+        return ev.FirstTimestamp.Time
+    case !ev.EventTime.IsZero():
+        return ev.EventTime.Time
+    }
+    return ev.CreationTimestamp.Time
+}
+
+func recordEvent(db *sql.DB, ev *corev1.Event) error {
+    o := ev.InvolvedObject
+    kind, ok := eventHistoryKinds[o.Kind]
+    if !ok {
+        return nil
+    }
+    ref := string(o.UID)
+    if kind == "node" {
+        ref = o.Name
+    }
+    if ref == "" {
+        return nil
+    }
+    count := ev.Count
+    if ev.Series != nil {
+        count = ev.Series.Count
+    }
+    source := ev.Source.Component
+    if source == "" {
+        source = ev.ReportingController
+    }
+    last := eventLastSeen(ev).UTC()
+    first := eventFirstSeen(ev).UTC()
+    if first.After(last) {
+        first = last
+    }
+    _, err := db.Exec(`
+INSERT INTO events(uid,kind,ref,namespace,name,type,reason,message,count,source,first_seen,last_seen) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ type=excluded.type,
+ reason=excluded.reason,
+ message=excluded.message,
+ count=excluded.count,
+ source=excluded.source,
+ last_seen=excluded.last_seen
+WHERE excluded.last_seen>=events.last_seen
+`, string(ev.UID), kind, ref, o.Namespace, o.Name, ev.Type, ev.Reason, ev.Message, max(count, 1), source,
+        first.Format(time.RFC3339), last.Format(time.RFC3339))
+    return err
+}
+
+// 单独的 informer List/Watch 所有 namespace 的事件；需要 events 的 list/watch 权限
+func watchEvents(db *sql.DB, client kubernetes.Interface, retention time.Duration, stop <-chan struct{}) {
+    factory := informers.NewSharedInformerFactory(client, 0)
+    handle := func(obj interface{}) {
+        ev, ok := obj.(*corev1.Event)
+        if !ok {
+            return
+        }
+        if err := recordEvent(db, ev); err != nil {
+            log.Printf("[events] %s/%s err=%v", ev.Namespace, ev.Name, err)
+        }
+    }
+    // API server 过期删除的事件保留到 retention，不处理 Delete
+    factory.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+        AddFunc:    handle,
+        UpdateFunc: func(_, obj interface{}) { handle(obj) },
+    })
+    factory.Start(stop)
+    go func() {
+        t := time.NewTicker(time.Hour)
+        defer t.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-t.C:
+                cutoff := time.Now().Add(-retention).UTC().Format(time.RFC3339)
+                res, err := db.Exec(`DELETE FROM events WHERE last_seen<?`, cutoff)
+                if err != nil {
+                    log.Printf("[events] prune: %v", err)
+                    continue
+                }
+                if n, _ := res.RowsAffected(); n > 0 {
+                    log.Printf("[events] pruned %d events older than %s", n, retention)
+                }
+            }
+        }
+    }()
+}
+
+type ChangeEvent struct {
+    Type      string `json:"type"`
+    Reason    string `json:"reason"`
+    Message   string `json:"message"`
+    Count     int    `json:"count"`
+    Source    string `json:"source,omitempty"`
+    FirstSeen string `json:"firstSeen"`
+    LastSeen  string `json:"lastSeen"`
+}
+
+// CSV 里输出为 "Warning/BackOff x3; Normal/Pulled"
+type ChangeEvents []ChangeEvent
+
+func (e ChangeEvents) String() string {
+    parts := make([]string, len(e))
+    for i, ev := range e {
+        parts[i] = ev.Type + "/" + ev.Reason
+        if ev.Count > 1 {
+            parts[i] += fmt.Sprintf(" x%d", ev.Count)
+        }
+    }
+    return strings.Join(parts, "; ")
+}
+
+// 和 [ts-window, ts+window] 有交集的事件，按 last_seen 排序
+func attachChangeEvents(ctx context.Context, db *sql.DB, list []ChangeRow, window time.Duration) error {
+    if window <= 0 {
+        return nil
+    }
+    for i := range list {
+        c := &list[i]
+        ts, err := time.Parse(time.RFC3339, c.TS)
+        if err != nil {
+            continue
+        }
+        rows, err := db.QueryContext(ctx, `SELECT coalesce(type,''),coalesce(reason,''),coalesce(message,''),count,coalesce(source,''),first_seen,last_seen
+FROM events WHERE kind=? AND ref=? AND last_seen>=? AND first_seen<=? ORDER BY last_seen,uid LIMIT ?`,
+            c.Kind, c.Ref, ts.Add(-window).Format(time.RFC3339), ts.Add(window).Format(time.RFC3339), maxChangeEvents)
+        if err != nil {
+            return err
+        }
+        for rows.Next() {
+            var ev ChangeEvent
+            if err := rows.Scan(&ev.Type, &ev.Reason, &ev.Message, &ev.Count, &ev.Source, &ev.FirstSeen, &ev.LastSeen); err != nil {
+                rows.Close()
+                return err
+            }
+            c.Events = append(c.Events, ev)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+// ---------- List output ----------
+
+// 列表接口统一走 listWriter，边扫描边输出，不在内存里攒整个数组（配置了 limits.maxRows 时例外）
+type listWriter interface {
+    Write(v any) error
+    // 出错时如果还没输出过数据就返回 500，否则只能记日志
+    Fail(err error)
+    Close() error
+}
+
+// ?format= 优先，其次看 Accept
+func responseFormat(r *http.Request) string {
+    if f := r.URL.Query().Get("format"); f != "" {
+        return strings.ToLower(f)
+    }
+    accept := r.Header.Get("Accept")
+    switch {
+    case strings.Contains(accept, "text/csv"):
+        return "csv"
+    case strings.Contains(accept, "application/x-ndjson"):
+        return "ndjson"
+    }
+    return "json"
+}
+
+// name 用作下载文件名；sample 是行 DTO 的零值，用来生成 CSV 表头和校验 ?fields=
+func newListWriter(w http.ResponseWriter, r *http.Request, name string, sample any) (listWriter, error) {
+    cols, sparse, err := selectFields(r, csvColumns(reflect.TypeOf(sample)))
+    if err != nil {
+        return nil, err
+    }
+    env := envelopeFrom(r.Context())
+    if env != nil {
+        if err := env.parsePage(r); err != nil {
+            return nil, err
+        }
+    }
+    var lw listWriter
+    switch responseFormat(r) {
+    case "json":
+        w.Header().Set("Content-Type", "application/json")
+        if env != nil {
+            lw = &envelopeListWriter{w: w, env: env}
+        } else {
+            lw = &jsonListWriter{w: w}
+        }
+    case "ndjson":
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        lw = &ndjsonListWriter{w: w, enc: json.NewEncoder(w)}
+    case "csv":
+        w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+        lw = &csvListWriter{w: w, cw: csv.NewWriter(w), cols: cols}
+        sparse = false
+    default:
+        return nil, fmt.Errorf("unsupported format %q", responseFormat(r))
+    }
+    if sparse {
+        lw = &sparseListWriter{inner: lw, cols: cols}
+    }
+    // 在 sparse 外面：遮盖的是行 DTO 本身
+    if m := maskerFrom(r.Context()); m != nil {
+        lw = &maskingListWriter{inner: lw, m: m}
+    }
+    // 计数放在行数上限里面：因超限被丢弃的行不算导出
+    if ai := accessInfoFrom(r.Context()); ai != nil {
+        lw = &countingListWriter{inner: lw, info: ai}
+    }
+    if n := rowCapFrom(r.Context()); n > 0 {
+        lw = &cappedListWriter{w: w, inner: lw, max: n}
+    }
+    // /api/v1 下分页在最外层，行数上限只看当前页
+    if env != nil {
+        lw = &pagedListWriter{inner: lw, env: env}
+    }
+    return lw, nil
+}
+
+type countingListWriter struct {
+    inner listWriter
+    info  *accessInfo
+}
+
+func (c *countingListWriter) Write(v any) error {
+    if err := c.inner.Write(v); err != nil {
+        return err
+    }
+    c.info.rows++
+    return nil
+}
+
+func (c *countingListWriter) Fail(err error) { c.inner.Fail(err) }
+func (c *countingListWriter) Close() error   { return c.inner.Close() }
+
+func flush(w http.ResponseWriter) {
+    if f, ok := w.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+type jsonListWriter struct {
+    w      http.ResponseWriter
+    count  int
+    failed bool
+}
+
+func (j *jsonListWriter) Write(v any) error {
+    b, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    sep := ","
+    if j.count == 0 {
+        sep = "["
+    }
+    if _, err := j.w.Write(append([]byte(sep), b...)); err != nil {
+        return err
+    }
+    j.count++
+    if j.count%500 == 0 {
+        flush(j.w)
+    }
+    return nil
+}
+
+func (j *jsonListWriter) Fail(err error) {
+    j.failed = true
+    if j.count == 0 {
+        http.Error(j.w, err.Error(), 500)
+        return
+    }
+    // 不补 ]，让客户端能发现结果被截断
+    log.Printf("[http] list aborted after %d rows: %v", j.count, err)
+}
+
+func (j *jsonListWriter) Close() error {
+    var err error
+    switch {
+    case j.failed:
+    case j.count == 0:
+        _, err = j.w.Write([]byte("[]\n"))
+    default:
+        _, err = j.w.Write([]byte("]\n"))
+    }
+    return err
+}
+
+// 每行一个 JSON 对象，方便 jq / 数据管道逐行处理
+type ndjsonListWriter struct {
+    w     http.ResponseWriter
+    enc   *json.Encoder
+    count int
+}
+
+func (n *ndjsonListWriter) Write(v any) error {
+    if err := n.enc.Encode(v); err != nil {
+        return err
+    }
+    n.count++
+    if n.count%500 == 0 {
+        flush(n.w)
+    }
+    return nil
+}
+
+func (n *ndjsonListWriter) Fail(err error) {
+    if n.count == 0 {
+        http.Error(n.w, err.Error(), 500)
+        return
+    }
+    log.Printf("[http] ndjson aborted after %d rows: %v", n.count, err)
+}
+
+func (n *ndjsonListWriter) Close() error {
+    return nil
+}
+
+type csvField struct {
+    name  string
+    index int
+}
+
+func csvColumns(t reflect.Type) []csvField {
+    var cols []csvField
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        name := strings.Split(f.Tag.Get("json"), ",")[0]
+        if name == "-" || !f.IsExported() {
+            continue
+        }
+        if name == "" {
+            name = f.Name
+        }
+        cols = append(cols, csvField{name: name, index: i})
+    }
+    return cols
+}
+
+// ?fields=name,namespace,podIP 只输出这些字段，顺序按参数；名字是 JSON 的字段名。没有 fields 时 sparse 为 false
+func selectFields(r *http.Request, all []csvField) ([]csvField, bool, error) {
+    v := r.URL.Query().Get("fields")
+    if v == "" {
+        return all, false, nil
+    }
+    byName := make(map[string]csvField, len(all))
+    names := make([]string, len(all))
+    for i, f := range all {
+        byName[f.name] = f
+        names[i] = f.name
+    }
+    var out []csvField
+    seen := map[string]bool{}
+    for _, name := range strings.Split(v, ",") {
+        name = strings.TrimSpace(name)
+        if name == "" || seen[name] {
+            continue
+        }
+        f, ok := byName[name]
+        if !ok {
+            return nil, false, fmt.Errorf("unknown field %q (one of %s)", name, strings.Join(names, ", "))
+        }
+        seen[name] = true
+        out = append(out, f)
+    }
+    if len(out) == 0 {
+        return nil, false, fmt.Errorf("fields is empty")
+    }
+    return out, true, nil
+}
+
+// 把行 DTO 换成只含所选字段的 JSON 对象再交给 JSON / NDJSON writer；所选字段总是输出，不看 omitempty
+type sparseListWriter struct {
+    inner listWriter
+    cols  []csvField
+    buf   []byte
+}
+
+func (s *sparseListWriter) Write(v any) error {
+    rv := reflect.Indirect(reflect.ValueOf(v))
+    s.buf = append(s.buf[:0], '{')
+    for i, f := range s.cols {
+        if i > 0 {
+            s.buf = append(s.buf, ',')
+        }
+        b, err := json.Marshal(rv.Field(f.index).Interface())
+        if err != nil {
+            return err
+        }
+        s.buf = strconv.AppendQuote(s.buf, f.name)
+        s.buf = append(append(s.buf, ':'), b...)
+    }
+    s.buf = append(s.buf, '}')
+    // 下层的 JSON / NDJSON writer 当场编码，buf 可以复用
+    return s.inner.Write(json.RawMessage(s.buf))
+}
+
+func (s *sparseListWriter) Fail(err error) { s.inner.Fail(err) }
+func (s *sparseListWriter) Close() error   { return s.inner.Close() }
+
+type csvListWriter struct {
+    w       http.ResponseWriter
+    cw      *csv.Writer
+    cols    []csvField
+    count   int
+    started bool
+    failed  bool
+}
+
+func (c *csvListWriter) header() error {
+    if c.started {
+        return nil
+    }
+    c.started = true
+    names := make([]string, len(c.cols))
+    for i, f := range c.cols {
+        names[i] = f.name
+    }
+    return c.cw.Write(names)
+}
+
+func (c *csvListWriter) Write(v any) error {
+    if err := c.header(); err != nil {
+        return err
+    }
+    rv := reflect.Indirect(reflect.ValueOf(v))
+    rec := make([]string, len(c.cols))
+    for i, f := range c.cols {
+        rec[i] = fmt.Sprint(rv.Field(f.index).Interface())
+    }
+    if err := c.cw.Write(rec); err != nil {
+        return err
+    }
+    c.count++
+    if c.count%500 == 0 {
+        c.cw.Flush()
+        flush(c.w)
+    }
+    return c.cw.Error()
+}
+
+func (c *csvListWriter) Fail(err error) {
+    c.failed = true
+    if !c.started {
+        c.started = true
+        http.Error(c.w, err.Error(), 500)
+        return
+    }
+    c.cw.Flush()
+    log.Printf("[http] csv aborted after %d rows: %v", c.count, err)
+}
+
+func (c *csvListWriter) Close() error {
+    if c.failed {
+        return nil
+    }
+    if err := c.header(); err != nil {
+        return err
+    }
+    c.cw.Flush()
+    return c.cw.Error()
+}
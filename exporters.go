@@ -0,0 +1,211 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// ---------- Exporters ----------
+
+// 所有对外推送（node webhook、git 快照 ...）都实现 exporter，由 registry 统一管理：
+// 每次推送都走 exportHandle.do，由它负责开关（配置 + /admin/exporters 运行时切换）、重试退避和状态记录。
+// 新的集成（Kafka、NetBox、ServiceNow、S3 ...）实现这个接口并在 main 里 add 即可。
+type exporter interface {
+    Name() string
+    // 阻塞运行直到 stop
+    Run(h *exportHandle, stop <-chan struct{})
+}
+
+// 配置里允许出现的 exporter 名字
+var exporterNames = []string{"nodeWebhooks", "gitSnapshot", "serviceNow", "alerts", "reports", "remoteWrite"}
+
+type ExporterStatus struct {
+    Name                string `json:"name"`
+    Enabled             bool   `json:"enabled"`
+    Attempts            int    `json:"retryAttempts"`
+    LastAttempt         string `json:"lastAttempt,omitempty"`
+    LastSuccess         string `json:"lastSuccess,omitempty"`
+    LastError           string `json:"lastError,omitempty"`
+    ConsecutiveFailures int    `json:"consecutiveFailures"`
+    Succeeded           int64  `json:"succeeded"`
+    Failed              int64  `json:"failed"`
+    // 关闭期间跳过的推送
+    Skipped int64 `json:"skipped"`
+}
+
+var errExporterDisabled = errors.New("exporter is disabled")
+
+type exportHandle struct {
+    retry       RetryPolicy
+    mu          sync.Mutex
+    st          ExporterStatus
+    lastSuccess time.Time
+}
+
+// 按重试策略执行一次推送；关闭时直接返回 errExporterDisabled
+func (h *exportHandle) do(fn func() error) error {
+    h.mu.Lock()
+    if !h.st.Enabled {
+        h.st.Skipped++
+        h.mu.Unlock()
+        return errExporterDisabled
+    }
+    h.mu.Unlock()
+    var err error
+    backoff := h.retry.Backoff.Duration
+    for attempt := 0; attempt < h.retry.Attempts; attempt++ {
+        if attempt > 0 {
+            time.Sleep(backoff)
+            backoff = min(2*backoff, h.retry.MaxBackoff.Duration)
+        }
+        if err = fn(); err == nil {
+            break
+        }
+    }
+    now := time.Now()
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.st.LastAttempt = now.UTC().Format(time.RFC3339)
+    if err != nil {
+        h.st.Failed++
+        h.st.ConsecutiveFailures++
+        h.st.LastError = err.Error()
+        return err
+    }
+    h.st.Succeeded++
+    h.st.ConsecutiveFailures = 0
+    h.st.LastError = ""
+    h.st.LastSuccess, h.lastSuccess = h.st.LastAttempt, now
+    return nil
+}
+
+func (h *exportHandle) setEnabled(on bool) {
+    h.mu.Lock()
+    h.st.Enabled = on
+    h.mu.Unlock()
+}
+
+func (h *exportHandle) status() ExporterStatus {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return h.st
+}
+
+type exporterRegistry struct {
+    cfg     map[string]ExporterConfig
+    mu      sync.Mutex
+    entries []exporterEntry
+}
+
+type exporterEntry struct {
+    exp exporter
+    h   *exportHandle
+}
+
+func newExporterRegistry(cfg map[string]ExporterConfig) *exporterRegistry {
+    return &exporterRegistry{cfg: cfg}
+}
+
+func (r *exporterRegistry) add(e exporter) *exportHandle {
+    c := r.cfg[e.Name()]
+    h := &exportHandle{retry: c.Retry, st: ExporterStatus{Name: e.Name(), Enabled: c.Enabled == nil || *c.Enabled, Attempts: c.Retry.Attempts}}
+    r.mu.Lock()
+    r.entries = append(r.entries, exporterEntry{exp: e, h: h})
+    r.mu.Unlock()
+    return h
+}
+
+func (r *exporterRegistry) start(stop <-chan struct{}) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, en := range r.entries {
+        go en.exp.Run(en.h, stop)
+    }
+}
+
+func (r *exporterRegistry) find(name string) *exportHandle {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, en := range r.entries {
+        if en.exp.Name() == name {
+            return en.h
+        }
+    }
+    return nil
+}
+
+func (r *exporterRegistry) statuses() []ExporterStatus {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    out := make([]ExporterStatus, 0, len(r.entries))
+    for _, en := range r.entries {
+        out = append(out, en.h.status())
+    }
+    return out
+}
+
+func (r *exporterRegistry) registerMetrics(m *metricsRegistry) {
+    perExporter := func(v func(h *exportHandle) float64) func() []metricSample {
+        return func() []metricSample {
+            r.mu.Lock()
+            defer r.mu.Unlock()
+            var out []metricSample
+            for _, en := range r.entries {
+                out = append(out, metricSample{Labels: []metricLabel{{"exporter", en.exp.Name()}}, Value: v(en.h)})
+            }
+            return out
+        }
+    }
+    m.register(metricFamily{Name: "lightcmdb_exporter_last_success_timestamp_seconds", Type: "gauge",
+        Help: "Unix time of the last successful export, 0 if none yet",
+        Collect: perExporter(func(h *exportHandle) float64 {
+            h.mu.Lock()
+            defer h.mu.Unlock()
+            if h.lastSuccess.IsZero() {
+                return 0
+            }
+            return float64(h.lastSuccess.Unix())
+        })})
+    m.register(metricFamily{Name: "lightcmdb_exporter_failures", Type: "counter",
+        Help:    "Exports that failed after all retries",
+        Collect: perExporter(func(h *exportHandle) float64 { return float64(h.status().Failed) })})
+    m.register(metricFamily{Name: "lightcmdb_exporter_enabled", Type: "gauge",
+        Help: "1 if the exporter is enabled",
+        Collect: perExporter(func(h *exportHandle) float64 {
+            if h.status().Enabled {
+                return 1
+            }
+            return 0
+        })})
+}
+
+// GET /admin/exporters 查看状态；POST /admin/exporters?name=gitSnapshot&enabled=false 运行时开关（重启后以配置为准）
+func exportersAPI(reg *exporterRegistry) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            writeJSON(w, r, reg.statuses())
+        case http.MethodPost:
+            q := r.URL.Query()
+            h := reg.find(q.Get("name"))
+            if h == nil {
+                http.Error(w, "unknown exporter", 404)
+                return
+            }
+            on, err := strconv.ParseBool(q.Get("enabled"))
+            if err != nil {
+                http.Error(w, "enabled must be true or false", 400)
+                return
+            }
+            h.setEnabled(on)
+            log.Printf("[exporters] %s enabled=%v", q.Get("name"), on)
+            writeJSON(w, r, h.status())
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
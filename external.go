@@ -0,0 +1,164 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ---------- External CI updates ----------
+
+// 开通流水线、DCIM 等外部系统把自己管理的 CI 推进来，存成 assets 行（type、name 为外部 id），
+// source 记下是哪个系统，reported_at 是最后一次上报的时间（内容没变也会更新，用来发现已经不再上报的记录）。
+// 和手工资产一样出现在 /cmdb/assets、拓扑、搜索和 history 里，拓扑 id 为 asset/<type>/<id>。
+// 每次上报都是这个 CI 的完整状态：site / owner / labels / attributes 整体覆盖，
+// relations 替换掉以前由同一 source 从这个 CI 出发上报的关系，手工建的关系不动。
+// 一个 (type, id) 只能由一个 source 维护，已由别的 source（包括 manual）建立时返回 409。写操作要求不限范围的 key。
+
+type ExternalCI struct {
+    // 上报的系统，例如 netbox、provisioning；不能是 manual
+    Source     string             `json:"source"`
+    Site       string             `json:"site,omitempty"`
+    Owner      string             `json:"owner,omitempty"`
+    Labels     map[string]string  `json:"labels,omitempty"`
+    Attributes map[string]string  `json:"attributes,omitempty"`
+    Relations  []ExternalRelation `json:"relations,omitempty"`
+}
+
+// 从这个 CI 出发的关系，to 用拓扑的节点 id，必须是已有的 CI
+type ExternalRelation struct {
+    To   string `json:"to"`
+    Type string `json:"type"`
+    Note string `json:"note,omitempty"`
+}
+
+type ExternalCIResult struct {
+    ID         int64  `json:"id"`
+    CI         string `json:"ci"`
+    Source     string `json:"source"`
+    ReportedAt string `json:"reportedAt"`
+    Created    bool   `json:"created"`
+    // 本次上报后这个 source 从该 CI 出发的关系数
+    Relations int `json:"relations"`
+}
+
+var errExternalOwned = errors.New("maintained by another source")
+
+// PUT|POST /cmdb/external/{type}/{id}   id 可以包含 /
+func externalAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPut && r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if !canWriteAssets(r) {
+            http.Error(w, "credentials not allowed to modify assets", 403)
+            return
+        }
+        typ, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/cmdb/external/"), "/")
+        if typ == "" || id == "" {
+            http.Error(w, "path must be /cmdb/external/{type}/{id}", 400)
+            return
+        }
+        var ci ExternalCI
+        if err := json.NewDecoder(r.Body).Decode(&ci); err != nil {
+            http.Error(w, "invalid JSON: "+err.Error(), 400)
+            return
+        }
+        if !relationTypePattern.MatchString(ci.Source) || ci.Source == "manual" {
+            http.Error(w, "source must name the reporting system in lowercase letters, digits, '_' or '-', and not be manual", 400)
+            return
+        }
+        asset := Asset{Type: typ, Name: id, Site: ci.Site, Owner: ci.Owner, Labels: ci.Labels, Attributes: ci.Attributes}
+        if err := validateAsset(asset); err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        self := "asset/" + typ + "/" + id
+        // 关系的另一端在事务外解析，和 POST /cmdb/relations 一样
+        var ends []*topoObj
+        for i, rel := range ci.Relations {
+            if !relationTypePattern.MatchString(rel.Type) {
+                http.Error(w, fmt.Sprintf("relations[%d].type must be lowercase letters, digits, '_' or '-'", i), 400)
+                return
+            }
+            o, err := loadTopoRoot(r.Context(), db, rel.To)
+            switch {
+            case errors.Is(err, errTopoRootNotFound):
+                http.Error(w, fmt.Sprintf("relations[%d].to %s: not found", i, rel.To), 400)
+                return
+            case errors.Is(err, errTopoRootInvalid):
+                http.Error(w, fmt.Sprintf("relations[%d].to %s: %v", i, rel.To, err), 400)
+                return
+            case err != nil:
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            if o.node.ID == self {
+                http.Error(w, fmt.Sprintf("relations[%d] points at the CI itself", i), 400)
+                return
+            }
+            ends = append(ends, o)
+        }
+        res := ExternalCIResult{CI: self, Source: ci.Source, ReportedAt: time.Now().Format(time.RFC3339)}
+        by := changeSourceFor(r, ci.Source)
+        var owner string
+        err := withChangeSource(db, by, func(tx *sql.Tx) error {
+            err := tx.QueryRow(`SELECT source FROM assets WHERE type=? AND name=?`, typ, id).Scan(&owner)
+            switch {
+            case errors.Is(err, sql.ErrNoRows):
+                res.Created = true
+            case err != nil:
+                return err
+            case owner != ci.Source:
+                return errExternalOwned
+            }
+            if err := tx.QueryRow(`
+INSERT INTO assets(type,name,site,owner,labels,attributes,source,created_at,updated_at,reported_at) VALUES(?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(type,name) DO UPDATE SET
+ site=excluded.site,
+ owner=excluded.owner,
+ labels=excluded.labels,
+ attributes=excluded.attributes,
+ updated_at=excluded.updated_at,
+ reported_at=excluded.reported_at
+RETURNING id`, typ, id, ci.Site, ci.Owner, flattenLabels(ci.Labels), encodeAttributes(ci.Attributes), ci.Source,
+                res.ReportedAt, res.ReportedAt, res.ReportedAt).Scan(&res.ID); err != nil {
+                return err
+            }
+            if _, err := tx.Exec(`DELETE FROM relations WHERE from_id=? AND source=?`, self, ci.Source); err != nil {
+                return err
+            }
+            for i, rel := range ci.Relations {
+                // 同样的关系已经手工建过时保留手工的那条
+                n, err := tx.Exec(`INSERT INTO relations(from_id,from_ns,to_id,to_ns,type,source,note,created_by,created_at)
+ VALUES(?,'',?,?,?,?,?,?,?) ON CONFLICT(from_id,to_id,type) DO NOTHING`,
+                    self, ends[i].node.ID, ends[i].node.Namespace, rel.Type, ci.Source, rel.Note, by, res.ReportedAt)
+                if err != nil {
+                    return err
+                }
+                if k, _ := n.RowsAffected(); k > 0 {
+                    res.Relations++
+                }
+            }
+            return nil
+        })
+        if errors.Is(err, errExternalOwned) {
+            http.Error(w, fmt.Sprintf("%s is maintained by %s", self, owner), 409)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if res.Created {
+            log.Printf("[external] %s created by %s", self, by)
+        }
+        writeJSON(w, r, res)
+    }
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ---------- Federation: config distribution ----------
+
+// hub 保存一份全局配置和按站点的覆盖（深合并，覆盖里的 null 表示删除该键），
+// edge 定期拉取合并后的有效配置，落库后上报应用结果，hub 据此给出 rollout 状态。
+// 配置内容对 hub 不透明，edge 侧各组件通过 managedConfig() 读取。
+const globalScope = "*"
+
+func initFederationSchema(db *sql.DB, mode string) error {
+    var stmts []string
+    switch mode {
+    case "hub":
+        stmts = []string{`
+CREATE TABLE IF NOT EXISTS fleet_config(
+    scope TEXT PRIMARY KEY,
+    doc TEXT NOT NULL,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS fleet_rollout(
+    site TEXT PRIMARY KEY,
+    version TEXT,
+    status TEXT,
+    message TEXT,
+    reported_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS fleet_clusters(
+    site TEXT PRIMARY KEY,
+    kubernetes_version TEXT,
+    node_count INTEGER,
+    ready_nodes INTEGER,
+    pod_count INTEGER,
+    running_pods INTEGER,
+    interval_s INTEGER,
+    reported_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS fleet_cluster_nodes(
+    site TEXT NOT NULL,
+    name TEXT NOT NULL,
+    ready INTEGER,
+    internal_ip TEXT,
+    kubelet_version TEXT,
+    PRIMARY KEY(site, name)
+);`}
+    case "edge":
+        stmts = []string{`
+CREATE TABLE IF NOT EXISTS fleet_applied(
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    version TEXT,
+    doc TEXT,
+    applied_at TEXT
+);`}
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func mergeDocs(base, over map[string]any) map[string]any {
+    out := make(map[string]any, len(base))
+    for k, v := range base {
+        out[k] = v
+    }
+    for k, v := range over {
+        if v == nil {
+            delete(out, k)
+            continue
+        }
+        if om, ok := v.(map[string]any); ok {
+            if bm, ok := out[k].(map[string]any); ok {
+                out[k] = mergeDocs(bm, om)
+                continue
+            }
+        }
+        out[k] = v
+    }
+    return out
+}
+
+// json.Marshal 对 map 的 key 排序，结果是确定的
+func docVersion(doc map[string]any) string {
+    b, _ := json.Marshal(doc)
+    sum := sha256.Sum256(b)
+    return hex.EncodeToString(sum[:])[:12]
+}
+
+func loadScopeDoc(db *sql.DB, scope string) (map[string]any, bool, error) {
+    var raw string
+    err := db.QueryRow(`SELECT doc FROM fleet_config WHERE scope=?`, scope).Scan(&raw)
+    if errors.Is(err, sql.ErrNoRows) {
+        return map[string]any{}, false, nil
+    }
+    if err != nil {
+        return nil, false, err
+    }
+    doc := map[string]any{}
+    if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+        return nil, false, err
+    }
+    return doc, true, nil
+}
+
+func effectiveDoc(db *sql.DB, site string) (map[string]any, error) {
+    global, _, err := loadScopeDoc(db, globalScope)
+    if err != nil {
+        return nil, err
+    }
+    if site == "" {
+        return global, nil
+    }
+    over, _, err := loadScopeDoc(db, site)
+    if err != nil {
+        return nil, err
+    }
+    return mergeDocs(global, over), nil
+}
+
+// hub 上的 /federation/*，挂在要认证的 api 上（见 protectedPath）：改配置要 admin，edge 用按站点发的 key
+func registerFederationAPI(api *http.ServeMux, db *sql.DB) {
+    api.HandleFunc("/federation/config", withoutMasking(fleetConfigAPI(db)))
+    api.HandleFunc("/federation/config/effective", withoutMasking(fleetEffectiveAPI(db)))
+    api.HandleFunc("/federation/status", fleetStatusAPI(db))
+    api.HandleFunc("/federation/clusters", fleetClustersAPI(db))
+}
+
+// GET/PUT/DELETE /federation/config[?site=berlin]
+func fleetConfigAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        scope := r.URL.Query().Get("site")
+        if scope == "" {
+            scope = globalScope
+        }
+        switch r.Method {
+        case http.MethodGet:
+            doc, _, err := loadScopeDoc(db, scope)
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            writeJSON(w, r, doc)
+        case http.MethodPut:
+            doc := map[string]any{}
+            if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&doc); err != nil {
+                http.Error(w, "body must be a JSON object: "+err.Error(), 400)
+                return
+            }
+            b, _ := json.Marshal(doc)
+            _, err := db.Exec(`
+INSERT INTO fleet_config(scope,doc,updated_at) VALUES(?,?,?)
+ON CONFLICT(scope) DO UPDATE SET doc=excluded.doc, updated_at=excluded.updated_at
+`, scope, string(b), time.Now().Format(time.RFC3339))
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            log.Printf("[federation] config scope=%s updated", scope)
+            w.WriteHeader(http.StatusNoContent)
+        case http.MethodDelete:
+            if scope == globalScope {
+                http.Error(w, "site is required", 400)
+                return
+            }
+            if _, err := db.Exec(`DELETE FROM fleet_config WHERE scope=?`, scope); err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            w.WriteHeader(http.StatusNoContent)
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
+
+type EffectiveConfig struct {
+    Site    string         `json:"site"`
+    Version string         `json:"version"`
+    Config  map[string]any `json:"config"`
+}
+
+// GET /federation/config/effective?site=berlin
+func fleetEffectiveAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        site := r.URL.Query().Get("site")
+        if !principalFrom(r.Context()).allowsSite(site) {
+            http.Error(w, "key not valid for site "+site, 403)
+            return
+        }
+        doc, err := effectiveDoc(db, site)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, EffectiveConfig{Site: site, Version: docVersion(doc), Config: doc})
+    }
+}
+
+type RolloutReport struct {
+    Site    string `json:"site"`
+    Version string `json:"version"`
+    Status  string `json:"status"`
+    Message string `json:"message,omitempty"`
+}
+
+type RolloutRow struct {
+    Site           string `json:"site"`
+    DesiredVersion string `json:"desiredVersion"`
+    AppliedVersion string `json:"appliedVersion"`
+    Status         string `json:"status"`
+    Message        string `json:"message,omitempty"`
+    ReportedAt     string `json:"reportedAt,omitempty"`
+    InSync         bool   `json:"inSync"`
+}
+
+// POST /federation/status 由 edge 上报；GET 返回各站点 rollout 状态
+func fleetStatusAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodPost:
+            var rep RolloutReport
+            if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&rep); err != nil || rep.Site == "" {
+                http.Error(w, "invalid report", 400)
+                return
+            }
+            if !principalFrom(r.Context()).allowsSite(rep.Site) {
+                http.Error(w, "key not valid for site "+rep.Site, 403)
+                return
+            }
+            _, err := db.Exec(`
+INSERT INTO fleet_rollout(site,version,status,message,reported_at) VALUES(?,?,?,?,?)
+ON CONFLICT(site) DO UPDATE SET
+ version=excluded.version,
+ status=excluded.status,
+ message=excluded.message,
+ reported_at=excluded.reported_at
+`, rep.Site, rep.Version, rep.Status, rep.Message, time.Now().Format(time.RFC3339))
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            w.WriteHeader(http.StatusNoContent)
+        case http.MethodGet:
+            out, err := rolloutStatus(db)
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            writeJSON(w, r, out)
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
+
+func rolloutStatus(db *sql.DB) ([]RolloutRow, error) {
+    // 已上报过的站点 + 只配置了覆盖但还没上线的站点
+    rows, err := db.Query(`
+SELECT s.site, coalesce(r.version,''), coalesce(r.status,'pending'), coalesce(r.message,''), coalesce(r.reported_at,'')
+FROM (SELECT site FROM fleet_rollout UNION SELECT scope FROM fleet_config WHERE scope<>?) s
+LEFT JOIN fleet_rollout r ON r.site=s.site
+ORDER BY s.site`, globalScope)
+    if err != nil {
+        return nil, err
+    }
+    var out []RolloutRow
+    for rows.Next() {
+        var row RolloutRow
+        if err := rows.Scan(&row.Site, &row.AppliedVersion, &row.Status, &row.Message, &row.ReportedAt); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        out = append(out, row)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    // 单连接，必须先关掉 rows 再查有效配置
+    for i := range out {
+        doc, err := effectiveDoc(db, out[i].Site)
+        if err != nil {
+            return nil, err
+        }
+        out[i].DesiredVersion = docVersion(doc)
+        out[i].InSync = out[i].Status == "applied" && out[i].AppliedVersion == out[i].DesiredVersion
+    }
+    return out, nil
+}
+
+// ---------- Federation: edge side ----------
+
+var managed struct {
+    sync.RWMutex
+    version string
+    doc     map[string]any
+}
+
+// 当前生效的下发配置（未下发时为空 map）
+func managedConfig() (string, map[string]any) {
+    managed.RLock()
+    defer managed.RUnlock()
+    if managed.doc == nil {
+        return "", map[string]any{}
+    }
+    return managed.version, managed.doc
+}
+
+func setManagedConfig(version string, doc map[string]any) {
+    managed.Lock()
+    managed.version, managed.doc = version, doc
+    managed.Unlock()
+}
+
+func loadAppliedConfig(db *sql.DB) error {
+    var version, raw string
+    err := db.QueryRow(`SELECT version, doc FROM fleet_applied WHERE id=1`).Scan(&version, &raw)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    doc := map[string]any{}
+    if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+        return err
+    }
+    setManagedConfig(version, doc)
+    return nil
+}
+
+type edgeSyncer struct {
+    db        *sql.DB
+    cfg       FederationConfig
+    client    *http.Client
+    inventory *clusterInventory
+}
+
+func runEdgeConfigSync(db *sql.DB, cfg FederationConfig, inv *clusterInventory, stop <-chan struct{}) {
+    s := &edgeSyncer{db: db, cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}, inventory: inv}
+    if err := loadAppliedConfig(db); err != nil {
+        log.Printf("[federation] load applied config: %v", err)
+    }
+    t := time.NewTicker(cfg.PollInterval.Duration)
+    defer t.Stop()
+    for {
+        s.syncOnce()
+        if err := s.reportCluster(); err != nil {
+            log.Printf("[federation] report cluster: %v", err)
+        }
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+        }
+    }
+}
+
+func (s *edgeSyncer) syncOnce() {
+    eff, err := s.fetch()
+    if err != nil {
+        // hub 不可达时保持上次配置
+        log.Printf("[federation] fetch config: %v", err)
+        return
+    }
+    current, _ := managedConfig()
+    if eff.Version == current {
+        return
+    }
+    b, _ := json.Marshal(eff.Config)
+    _, err = s.db.Exec(`
+INSERT INTO fleet_applied(id,version,doc,applied_at) VALUES(1,?,?,?)
+ON CONFLICT(id) DO UPDATE SET version=excluded.version, doc=excluded.doc, applied_at=excluded.applied_at
+`, eff.Version, string(b), time.Now().Format(time.RFC3339))
+    rep := RolloutReport{Site: s.cfg.Site, Version: eff.Version, Status: "applied"}
+    if err != nil {
+        rep.Status, rep.Message = "error", err.Error()
+        log.Printf("[federation] apply config %s: %v", eff.Version, err)
+    } else {
+        setManagedConfig(eff.Version, eff.Config)
+        log.Printf("[federation] applied config %s", eff.Version)
+    }
+    if err := s.report(rep); err != nil {
+        log.Printf("[federation] report status: %v", err)
+    }
+}
+
+// hub 开了认证时要带上 federation.apiKey
+func (s *edgeSyncer) do(method, path string, body []byte) (*http.Response, error) {
+    req, err := http.NewRequest(method, strings.TrimRight(s.cfg.HubURL, "/")+path, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if s.cfg.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+s.cfg.APIKey)
+    }
+    return s.client.Do(req)
+}
+
+func (s *edgeSyncer) fetch() (*EffectiveConfig, error) {
+    resp, err := s.do(http.MethodGet, "/federation/config/effective?site="+url.QueryEscape(s.cfg.Site), nil)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("hub returned %s", resp.Status)
+    }
+    var eff EffectiveConfig
+    if err := json.NewDecoder(resp.Body).Decode(&eff); err != nil {
+        return nil, err
+    }
+    if eff.Config == nil {
+        eff.Config = map[string]any{}
+    }
+    return &eff, nil
+}
+
+func (s *edgeSyncer) report(rep RolloutReport) error {
+    b, _ := json.Marshal(rep)
+    resp, err := s.do(http.MethodPost, "/federation/status", b)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("hub returned %s", resp.Status)
+    }
+    return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// hub 的 /federation/* 要认证：没有 key 401，edge key 只能拉自己站点的配置和上报，改配置要 admin
+func TestFederationRoutesRequireKeys(t *testing.T) {
+    db := newTestDB(t)
+    if err := initFederationSchema(db, "hub"); err != nil {
+        t.Fatal(err)
+    }
+    keys := filepath.Join(t.TempDir(), "keys.yaml")
+    err := os.WriteFile(keys, []byte(`
+- {name: ops, key: admin-key}
+- {name: berlin, key: berlin-key, sites: [berlin]}
+- {name: team-a, key: team-key, namespaces: [shop]}
+`), 0o600)
+    if err != nil {
+        t.Fatal(err)
+    }
+    auth, err := loadAuthenticator(AuthConfig{KeysFile: keys}, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    api := http.NewServeMux()
+    registerFederationAPI(api, db)
+    h := auth.wrap(api)
+    call := func(method, target, key, body string) int {
+        r := httptest.NewRequest(method, target, strings.NewReader(body))
+        if key != "" {
+            r.Header.Set("Authorization", "Bearer "+key)
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, r)
+        return rec.Code
+    }
+    report := `{"site":"berlin","version":"v1","status":"applied"}`
+    for _, c := range []struct {
+        method, target, key, body string
+        want                      int
+    }{
+        {"GET", "/federation/config", "", "", 401},
+        {"PUT", "/federation/config", "", `{"a":1}`, 401},
+        {"DELETE", "/federation/config?site=berlin", "", "", 401},
+        {"GET", "/federation/config/effective?site=berlin", "", "", 401},
+        {"GET", "/federation/status", "", "", 401},
+        {"POST", "/federation/status", "", report, 401},
+        {"POST", "/federation/clusters", "", `{"site":"berlin"}`, 401},
+
+        {"PUT", "/federation/config", "berlin-key", `{"a":1}`, 403},
+        {"PUT", "/federation/config", "team-key", `{"a":1}`, 403},
+        {"GET", "/federation/config/effective?site=paris", "berlin-key", "", 403},
+        {"POST", "/federation/status", "berlin-key", `{"site":"paris","version":"v1","status":"applied"}`, 403},
+
+        {"PUT", "/federation/config", "admin-key", `{"a":1}`, 204},
+        {"GET", "/federation/config/effective?site=berlin", "berlin-key", "", 200},
+        {"POST", "/federation/status", "berlin-key", report, 204},
+        {"GET", "/federation/status", "admin-key", "", 200},
+    } {
+        if got := call(c.method, c.target, c.key, c.body); got != c.want {
+            t.Errorf("%s %s key=%q: got %d, want %d", c.method, c.target, c.key, got, c.want)
+        }
+    }
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ---------- Full export ----------
+
+// 一次导出所有 history 跟踪的类型（kind 和投影列同 history / git 快照），给备份脚本和下游导入用，不用挨个调 N 个列表接口。
+// 所有类型在同一个只读快照里读出，边扫描边输出。限定 namespace 的 key 看不到集群级对象（node、asset 等）。
+type ExportObject struct {
+    // 只有 NDJSON 带 kind，JSON 里按 kind 分组
+    Kind      string  `json:"kind,omitempty"`
+    Ref       string  `json:"ref"`
+    Namespace string  `json:"namespace,omitempty"`
+    Name      string  `json:"name"`
+    Object    RawJSON `json:"object"`
+}
+
+// JSON 的整体结构，只用于文档；实际是流式写出的
+type InventoryExport struct {
+    Site        string                    `json:"site"`
+    GeneratedAt string                    `json:"generatedAt"`
+    Kinds       map[string][]ExportObject `json:"kinds"`
+}
+
+func exportQuery(h historySource, scope nsScope) (string, []any) {
+    sc, args := scope.cond("coalesce(" + h.col(h.Namespace, "t") + ",'')")
+    return fmt.Sprintf(`SELECT CAST(t.%s AS TEXT),coalesce(%s,''),coalesce(%s,''),%s FROM %s t WHERE %s ORDER BY 2,3,1`,
+        h.Key, h.col(h.Namespace, "t"), h.col(h.Name, "t"), h.plainObject("t"), h.Table, sc), args
+}
+
+// 配置了 limits.maxRows 时先数总数，超了直接 413，不输出半截文档
+func exportRowCount(q querier, sources []historySource, scope nsScope) (int, error) {
+    total := 0
+    for _, h := range sources {
+        sc, args := scope.cond("coalesce(" + h.col(h.Namespace, "t") + ",'')")
+        var n int
+        if err := q.QueryRow(`SELECT count(*) FROM `+h.Table+` t WHERE `+sc, args...).Scan(&n); err != nil {
+            return 0, err
+        }
+        total += n
+    }
+    return total, nil
+}
+
+// 返回已写出的对象数和是否已开始输出；出错时调用方据此决定是回 500 还是只能截断。
+// 对象直接序列化、不经过 listWriter，遮盖要在这里做
+func writeFullExport(ctx context.Context, w http.ResponseWriter, q querier, sources []historySource, scope nsScope, site string, ndjson bool) (n int, started bool, err error) {
+    masker := maskerFrom(ctx)
+    write := func(b []byte) error {
+        started = true
+        _, err := w.Write(b)
+        return err
+    }
+    if !ndjson {
+        head, _ := json.Marshal(map[string]string{"site": site, "generatedAt": time.Now().UTC().Format(time.RFC3339)})
+        // 去掉 }，后面接 kinds
+        if err := write(append(head[:len(head)-1], `,"kinds":{`...)); err != nil {
+            return n, started, err
+        }
+    }
+    for i, h := range sources {
+        if !ndjson {
+            sep := ","
+            if i == 0 {
+                sep = ""
+            }
+            if err := write([]byte(fmt.Sprintf(`%s"%s":[`, sep, h.Kind))); err != nil {
+                return n, started, err
+            }
+        }
+        query, args := exportQuery(h, scope)
+        rows, err := q.Query(query, args...)
+        if err != nil {
+            return n, started, err
+        }
+        first := true
+        for rows.Next() {
+            o := ExportObject{}
+            if err := rows.Scan(&o.Ref, &o.Namespace, &o.Name, &o.Object); err != nil {
+                rows.Close()
+                return n, started, err
+            }
+            if ndjson {
+                o.Kind = h.Kind
+            }
+            o.Object = RawJSON(masker.maskJSON(string(o.Object)))
+            b, err := json.Marshal(o)
+            if err != nil {
+                rows.Close()
+                return n, started, err
+            }
+            switch {
+            case ndjson:
+                b = append(b, '\n')
+            case !first:
+                b = append([]byte{','}, b...)
+            }
+            if err := write(b); err != nil {
+                rows.Close()
+                return n, started, err
+            }
+            first = false
+            n++
+            if n%500 == 0 {
+                flush(w)
+            }
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return n, started, err
+        }
+        if !ndjson {
+            if err := write([]byte("]")); err != nil {
+                return n, started, err
+            }
+        }
+    }
+    if !ndjson {
+        err = write([]byte("}}\n"))
+    }
+    return n, started, err
+}
+
+// GET /cmdb/export?kind=pod,node&format=ndjson，kind 默认全部
+func fullExportAPI(db *sql.DB, site string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        sources := historySources
+        if v := r.URL.Query().Get("kind"); v != "" {
+            sources = nil
+            for _, k := range strings.Split(v, ",") {
+                h, ok := historySourceOf(k)
+                if !ok {
+                    http.Error(w, "unknown kind "+k, 400)
+                    return
+                }
+                sources = append(sources, h)
+            }
+        }
+        format := responseFormat(r)
+        if format != "json" && format != "ndjson" {
+            http.Error(w, fmt.Sprintf("unsupported format %q (json or ndjson)", format), 400)
+            return
+        }
+        scope := scopeOf(r.Context())
+        written, started := 0, false
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            q := dbFrom(ctx, db)
+            if max := rowCapFrom(ctx); max > 0 {
+                total, err := exportRowCount(q, sources, scope)
+                if err != nil {
+                    return err
+                }
+                if total > max {
+                    http.Error(w, fmt.Sprintf("export has more than %d rows, narrow it with kind", max), 413)
+                    log.Printf("[limits] response over %d rows", max)
+                    return nil
+                }
+            }
+            if format == "ndjson" {
+                w.Header().Set("Content-Type", "application/x-ndjson")
+            } else {
+                w.Header().Set("Content-Type", "application/json")
+            }
+            var err error
+            written, started, err = writeFullExport(ctx, w, q, sources, scope, site, format == "ndjson")
+            return err
+        })
+        if ai := accessInfoFrom(r.Context()); ai != nil {
+            ai.rows += int64(written)
+        }
+        if err != nil {
+            if !started {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            // 不补结尾，让客户端能发现结果被截断
+            log.Printf("[http] export aborted after %d objects: %v", written, err)
+        }
+    }
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// 全量导出不走 listWriter，没有 unmask 的 key 也要看到遮盖后的值
+func TestFullExportMasksFields(t *testing.T) {
+    db := newTestDB(t)
+    if _, err := db.Exec(`INSERT INTO nodes(name,internal_ip) VALUES('node-a','10.0.0.5')`); err != nil {
+        t.Fatal(err)
+    }
+    keys := filepath.Join(t.TempDir(), "keys.yaml")
+    err := os.WriteFile(keys, []byte(`
+- {name: viewer, key: viewer-key}
+- {name: sre, key: sre-key, unmask: true}
+`), 0o600)
+    if err != nil {
+        t.Fatal(err)
+    }
+    auth, err := loadAuthenticator(AuthConfig{KeysFile: keys}, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    masker := newFieldMasker(MaskingConfig{Fields: []string{"internalIP"}, Replacement: "***"})
+    h := auth.wrap(masker.wrap(fullExportAPI(db, "berlin")))
+    export := func(key, format string) string {
+        r := httptest.NewRequest(http.MethodGet, "/cmdb/export?kind=node&format="+format, nil)
+        r.Header.Set("Authorization", "Bearer "+key)
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, r)
+        if rec.Code != 200 {
+            t.Fatalf("%s export as %s: %d %s", format, key, rec.Code, rec.Body)
+        }
+        return rec.Body.String()
+    }
+    for _, format := range []string{"json", "ndjson"} {
+        if body := export("viewer-key", format); strings.Contains(body, "10.0.0.5") || !strings.Contains(body, `"internal_ip":"***"`) {
+            t.Errorf("%s export without unmask = %s, want internal_ip masked", format, body)
+        }
+        if body := export("sre-key", format); !strings.Contains(body, `"internal_ip":"10.0.0.5"`) {
+            t.Errorf("%s export with unmask = %s, want the stored internal_ip", format, body)
+        }
+    }
+}
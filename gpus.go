@@ -0,0 +1,247 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "log"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+
+    corev1 "k8s.io/api/core/v1"
+    "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ---------- Extended resources / GPU report ----------
+
+// nodehardware.go 的 devices 只有扩展资源的 allocatable。这里把 capacity、allocatable 和 Pod 的 requests 都按资源名记下来，
+// 格式同 labels（name=数量，数量是 Kubernetes 的 quantity 字符串）：
+// nodes.capacity_extended / allocatable_extended，pods.extended_requests。除 device plugin 的扩展资源外还包括 hugepages-*。
+// /cmdb/gpus 按节点或 namespace 汇总分配情况，默认只看 GPU 类资源（hardwareClasses 里 gpu 的资源名），resource 参数可指定其他资源。
+
+// 扩展资源和 hugepages-2Mi / hugepages-1Gi
+func isTrackedResource(name corev1.ResourceName) bool {
+    return isExtendedResource(name) || strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix)
+}
+
+func extendedResourcesOf(list corev1.ResourceList) string {
+    m := map[string]string{}
+    for name, q := range list {
+        if isTrackedResource(name) && !q.IsZero() {
+            m[string(name)] = q.String()
+        }
+    }
+    return flattenLabels(m)
+}
+
+// 和 podRequests 一样取有效 requests：max(各容器之和, 单个 init 容器最大值) + overhead
+func podExtendedRequests(p *corev1.Pod) string {
+    sum := corev1.ResourceList{}
+    for _, c := range p.Spec.Containers {
+        for name, q := range c.Resources.Requests {
+            if isTrackedResource(name) {
+                v := sum[name]
+                v.Add(q)
+                sum[name] = v
+            }
+        }
+    }
+    for _, c := range p.Spec.InitContainers {
+        for name, q := range c.Resources.Requests {
+            if v, ok := sum[name]; isTrackedResource(name) && (!ok || q.Cmp(v) > 0) {
+                sum[name] = q.DeepCopy()
+            }
+        }
+    }
+    for name, q := range p.Spec.Overhead {
+        if isTrackedResource(name) {
+            v := sum[name]
+            v.Add(q)
+            sum[name] = v
+        }
+    }
+    return extendedResourcesOf(sum)
+}
+
+// 旧库的 nodes / pods 表补列
+func initExtendedResources(db *sql.DB) error {
+    for _, c := range [][2]string{{"nodes", "capacity_extended"}, {"nodes", "allocatable_extended"}, {"pods", "extended_requests"}} {
+        if err := addColumnIfMissing(db, c[0], c[1], "TEXT"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 解析 extended 列，数量按 Value() 取整（GPU 为个数，hugepages 为字节）；解析不了的跳过
+func parseExtendedResources(flat string) map[string]int64 {
+    out := map[string]int64{}
+    for k, v := range parseLabels(flat) {
+        if q, err := resource.ParseQuantity(v); err == nil {
+            out[k] = q.Value()
+        }
+    }
+    return out
+}
+
+var gpuClass = hardwareClasses[slices.IndexFunc(hardwareClasses, func(c hardwareClass) bool { return c.Name == "gpu" })]
+
+// resource=nvidia.com/gpu,hugepages-2Mi：逗号分隔；all 表示全部扩展资源；为空时只看 GPU 类
+func gpuResourceFilter(v string) func(string) bool {
+    switch v {
+    case "":
+        return func(name string) bool { return gpuClass.resource.MatchString(strings.ToLower(name)) }
+    case "all":
+        return func(string) bool { return true }
+    }
+    want := strings.Split(v, ",")
+    for i := range want {
+        want[i] = strings.TrimSpace(want[i])
+    }
+    return func(name string) bool { return slices.Contains(want, name) }
+}
+
+type GPURow struct {
+    // by=node 时有值
+    Node string `json:"node,omitempty"`
+    // by=namespace 时有值
+    Namespace string `json:"namespace,omitempty"`
+    Resource  string `json:"resource"`
+    // 以下三项只有 by=node 时有值；hugepages 为字节
+    Capacity    int64 `json:"capacity"`
+    Allocatable int64 `json:"allocatable"`
+    Available   int64 `json:"available"`
+    // 已调度、未结束的 Pod 的 requests 之和
+    Requested int64 `json:"requested"`
+    Pods      int64 `json:"pods"`
+}
+
+func computeGPUs(q querier, by string, match func(string) bool, scope nsScope) ([]GPURow, error) {
+    groups := map[[2]string]*GPURow{}
+    group := func(key, res string) *GPURow {
+        k := [2]string{key, res}
+        g := groups[k]
+        if g == nil {
+            g = &GPURow{Resource: res}
+            if by == "node" {
+                g.Node = key
+            } else {
+                g.Namespace = key
+            }
+            groups[k] = g
+        }
+        return g
+    }
+    if by == "node" {
+        rows, err := q.Query(`SELECT name,coalesce(capacity_extended,''),coalesce(allocatable_extended,'') FROM nodes`)
+        if err != nil {
+            return nil, err
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var name, capacity, alloc string
+            if err := rows.Scan(&name, &capacity, &alloc); err != nil {
+                return nil, err
+            }
+            for res, v := range parseExtendedResources(capacity) {
+                if match(res) {
+                    group(name, res).Capacity = v
+                }
+            }
+            for res, v := range parseExtendedResources(alloc) {
+                if match(res) {
+                    group(name, res).Allocatable = v
+                }
+            }
+        }
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+    where, args := scope.where("namespace", "coalesce(extended_requests,'')<>'' AND coalesce(node_name,'')<>'' AND phase NOT IN ('Succeeded','Failed')")
+    rows, err := q.Query(`SELECT namespace,node_name,extended_requests FROM pods`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var ns, node, flat string
+        if err := rows.Scan(&ns, &node, &flat); err != nil {
+            return nil, err
+        }
+        key := ns
+        if by == "node" {
+            key = node
+        }
+        for res, v := range parseExtendedResources(flat) {
+            if match(res) {
+                g := group(key, res)
+                g.Requested += v
+                g.Pods++
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    out := make([]GPURow, 0, len(groups))
+    for _, g := range groups {
+        if by == "node" {
+            g.Available = max(g.Allocatable-g.Requested, 0)
+        }
+        out = append(out, *g)
+    }
+    // Node 和 Namespace 只有一个有值
+    sort.Slice(out, func(i, j int) bool {
+        ki, kj := out[i].Node+out[i].Namespace, out[j].Node+out[j].Namespace
+        if ki != kj {
+            return ki < kj
+        }
+        return out[i].Resource < out[j].Resource
+    })
+    return out, nil
+}
+
+// GET /cmdb/gpus?by=node|namespace&resource=nvidia.com/gpu[&format=csv]
+func gpusAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        by := r.URL.Query().Get("by")
+        if by == "" {
+            by = "node"
+        }
+        if by != "node" && by != "namespace" {
+            http.Error(w, "by must be node or namespace", 400)
+            return
+        }
+        var out []GPURow
+        // 节点是集群级对象，限定 namespace 的 key 只能按 namespace 看
+        if by == "namespace" || scopeOf(r.Context()) == nil {
+            err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+                var err error
+                out, err = computeGPUs(dbFrom(ctx, db), by, gpuResourceFilter(r.URL.Query().Get("resource")), scopeOf(r.Context()))
+                return err
+            })
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+        }
+        lw, err := newListWriter(w, r, "gpus-by-"+by, GPURow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write gpus: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
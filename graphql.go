@@ -0,0 +1,416 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "io"
+    "net/http"
+    "strings"
+
+    "github.com/graphql-go/graphql"
+)
+
+// ---------- GraphQL ----------
+
+// 关系查询（Pod -> Node / Deployment / Service 以及反向）一次请求拿全。
+// resolver 里每次查询都完整读完再返回：DB 是单连接，不能边迭代边发新查询。
+type gqlRow = map[string]any
+
+func gqlQuery(ctx context.Context, db *sql.DB, query string, args []any, cols []string) ([]gqlRow, error) {
+    limit := rowCapFrom(ctx)
+    rows, err := dbFrom(ctx, db).Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []gqlRow
+    for rows.Next() {
+        vals := make([]any, len(cols))
+        ptrs := make([]any, len(cols))
+        for i := range vals {
+            ptrs[i] = &vals[i]
+        }
+        if err := rows.Scan(ptrs...); err != nil {
+            return nil, err
+        }
+        row := gqlRow{}
+        for i, c := range cols {
+            row[c] = vals[i]
+        }
+        if limit > 0 && len(out) >= limit {
+            return nil, errTooManyRows
+        }
+        out = append(out, row)
+    }
+    return out, rows.Err()
+}
+
+const gqlPodSelect = `SELECT uid,name,namespace,phase,node_name,cmdb_unseal(pod_ip),labels,images,cpu_request,mem_request,owner_kind,owner_name,owner_uid,updated_at FROM pods`
+
+var gqlPodCols = []string{"uid", "name", "namespace", "phase", "nodeName", "podIP", "labels", "images",
+    "cpuRequestMilli", "memoryRequestBytes", "ownerKind", "ownerName", "ownerUID", "updatedAt"}
+
+// cond 为空表示不加条件；scope 条件总是带上（ctx 来自请求）
+func gqlPods(ctx context.Context, db *sql.DB, cond string, args ...any) ([]gqlRow, error) {
+    where, args := scopeOf(ctx).where("namespace", cond, args...)
+    return gqlQuery(ctx, db, gqlPodSelect+where+" ORDER BY namespace,name", args, gqlPodCols)
+}
+
+func gqlNodes(ctx context.Context, db *sql.DB, cond string, args ...any) ([]gqlRow, error) {
+    where, args := scopeOf(ctx).where("''", cond, args...)
+    return gqlQuery(ctx, db, `SELECT name,labels,capacity_cpu,capacity_mem,cmdb_unseal(internal_ip),coalesce(capabilities,''),coalesce(devices,''),
+ coalesce(sriov_count,0),coalesce(gpu_count,0),coalesce(tpu_count,0),coalesce(fpga_count,0),updated_at FROM nodes`+where+` ORDER BY name`, args,
+        []string{"name", "labels", "cpu", "memory", "internalIP", "capabilities", "devices",
+            "sriovCount", "gpuCount", "tpuCount", "fpgaCount", "updatedAt"})
+}
+
+func gqlServices(ctx context.Context, db *sql.DB, cond string, args ...any) ([]gqlRow, error) {
+    where, args := scopeOf(ctx).where("namespace", cond, args...)
+    return gqlQuery(ctx, db, `SELECT uid,name,namespace,type,cmdb_unseal(cluster_ip),selector,ports,labels,cmdb_unseal(lb_ips),lb_provider,lb_pool,
+ coalesce(nullif(lb_node,''),(SELECT node FROM lb_announcements WHERE service_uid=services.uid)),updated_at FROM services`+where+` ORDER BY namespace,name`, args,
+        []string{"uid", "name", "namespace", "type", "clusterIP", "selector", "ports", "labels",
+            "loadBalancerIPs", "lbProvider", "lbPool", "announcingNode", "updatedAt"})
+}
+
+func gqlDeployments(ctx context.Context, db *sql.DB, cond string, args ...any) ([]gqlRow, error) {
+    where, args := scopeOf(ctx).where("namespace", cond, args...)
+    return gqlQuery(ctx, db, `SELECT uid,name,namespace,replicas,ready_replicas,images,labels,updated_at FROM deployments`+where+` ORDER BY namespace,name`, args,
+        []string{"uid", "name", "namespace", "replicas", "readyReplicas", "images", "labels", "updatedAt"})
+}
+
+func firstRow(rows []gqlRow, err error) (any, error) {
+    if err != nil || len(rows) == 0 {
+        return nil, err
+    }
+    return rows[0], nil
+}
+
+func gqlStr(v any) string {
+    s, _ := v.(string)
+    return s
+}
+
+// Service 的 selector 是否选中该 Pod；空 selector 不选中任何 Pod（与 k8s 一致）
+func selectorMatches(selector, labels string) bool {
+    sel := parseLabels(selector)
+    if len(sel) == 0 {
+        return false
+    }
+    have := parseLabels(labels)
+    for k, v := range sel {
+        if have[k] != v {
+            return false
+        }
+    }
+    return true
+}
+
+// 解析 Pod 的 owner 链，ReplicaSet 再往上找一层
+func podDeployment(ctx context.Context, db *sql.DB, pod gqlRow) (any, error) {
+    switch gqlStr(pod["ownerKind"]) {
+    case "Deployment":
+        return firstRow(gqlDeployments(ctx, db, "uid=?", gqlStr(pod["ownerUID"])))
+    case "ReplicaSet":
+        return firstRow(gqlDeployments(ctx, db, `uid=(SELECT owner_uid FROM replicasets WHERE uid=? AND owner_kind='Deployment')`, gqlStr(pod["ownerUID"])))
+    }
+    return nil, nil
+}
+
+func buildGraphQLSchema(db *sql.DB) (graphql.Schema, error) {
+    var podType, nodeType, serviceType, deploymentType *graphql.Object
+    intField := &graphql.Field{Type: graphql.Int}
+
+    ownerType := graphql.NewObject(graphql.ObjectConfig{
+        Name: "Owner",
+        Fields: graphql.Fields{
+            "kind": &graphql.Field{Type: graphql.String},
+            "name": &graphql.Field{Type: graphql.String},
+            "uid":  &graphql.Field{Type: graphql.String},
+        },
+    })
+
+    podType = graphql.NewObject(graphql.ObjectConfig{
+        Name: "Pod",
+        Fields: graphql.FieldsThunk(func() graphql.Fields {
+            return graphql.Fields{
+                "uid":                &graphql.Field{Type: graphql.String},
+                "name":               &graphql.Field{Type: graphql.String},
+                "namespace":          &graphql.Field{Type: graphql.String},
+                "phase":              &graphql.Field{Type: graphql.String},
+                "nodeName":           &graphql.Field{Type: graphql.String},
+                "podIP":              &graphql.Field{Type: graphql.String},
+                "labels":             &graphql.Field{Type: graphql.String},
+                "images":             &graphql.Field{Type: graphql.String},
+                "cpuRequestMilli":    intField,
+                "memoryRequestBytes": &graphql.Field{Type: graphql.Float},
+                "updatedAt":          &graphql.Field{Type: graphql.String},
+                "owner": &graphql.Field{
+                    Type: ownerType,
+                    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                        pod := p.Source.(gqlRow)
+                        if gqlStr(pod["ownerKind"]) == "" {
+                            return nil, nil
+                        }
+                        return gqlRow{"kind": pod["ownerKind"], "name": pod["ownerName"], "uid": pod["ownerUID"]}, nil
+                    },
+                },
+                "node": &graphql.Field{
+                    Type: nodeType,
+                    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                        return firstRow(gqlNodes(p.Context, db, "name=?", gqlStr(p.Source.(gqlRow)["nodeName"])))
+                    },
+                },
+                "deployment": &graphql.Field{
+                    Type: deploymentType,
+                    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                        return podDeployment(p.Context, db, p.Source.(gqlRow))
+                    },
+                },
+                "services": &graphql.Field{
+                    Type: graphql.NewList(serviceType),
+                    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                        pod := p.Source.(gqlRow)
+                        svcs, err := gqlServices(p.Context, db, "namespace=?", gqlStr(pod["namespace"]))
+                        if err != nil {
+                            return nil, err
+                        }
+                        var out []gqlRow
+                        for _, s := range svcs {
+                            if selectorMatches(gqlStr(s["selector"]), gqlStr(pod["labels"])) {
+                                out = append(out, s)
+                            }
+                        }
+                        return out, nil
+                    },
+                },
+            }
+        }),
+    })
+
+    nodeType = graphql.NewObject(graphql.ObjectConfig{
+        Name: "Node",
+        Fields: graphql.FieldsThunk(func() graphql.Fields {
+            return graphql.Fields{
+                "name":         &graphql.Field{Type: graphql.String},
+                "labels":       &graphql.Field{Type: graphql.String},
+                "cpu":          &graphql.Field{Type: graphql.String},
+                "memory":       &graphql.Field{Type: graphql.String},
+                "internalIP":   &graphql.Field{Type: graphql.String},
+                "capabilities": &graphql.Field{Type: graphql.String},
+                "devices":      &graphql.Field{Type: graphql.String},
+                "sriovCount":   intField,
+                "gpuCount":     intField,
+                "tpuCount":     intField,
+                "fpgaCount":    intField,
+                "updatedAt":    &graphql.Field{Type: graphql.String},
+                "pods": &graphql.Field{
+                    Type: graphql.NewList(podType),
+                    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                        return gqlPods(p.Context, db, "node_name=?", gqlStr(p.Source.(gqlRow)["name"]))
+                    },
+                },
+            }
+        }),
+    })
+
+    serviceType = graphql.NewObject(graphql.ObjectConfig{
+        Name: "Service",
+        Fields: graphql.FieldsThunk(func() graphql.Fields {
+            return graphql.Fields{
+                "uid":       &graphql.Field{Type: graphql.String},
+                "name":      &graphql.Field{Type: graphql.String},
+                "namespace": &graphql.Field{Type: graphql.String},
+                "type":      &graphql.Field{Type: graphql.String},
+                "clusterIP": &graphql.Field{Type: graphql.String},
+                "selector":  &graphql.Field{Type: graphql.String},
+                "ports":     &graphql.Field{Type: graphql.String},
+                "labels":    &graphql.Field{Type: graphql.String},
+                // LoadBalancer 类型才有值
+                "loadBalancerIPs": &graphql.Field{Type: graphql.String},
+                "lbProvider":      &graphql.Field{Type: graphql.String},
+                "lbPool":          &graphql.Field{Type: graphql.String},
+                "announcingNode":  &graphql.Field{Type: graphql.String},
+                "updatedAt":       &graphql.Field{Type: graphql.String},
+                "pods": &graphql.Field{
+                    Type: graphql.NewList(podType),
+                    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                        svc := p.Source.(gqlRow)
+                        pods, err := gqlPods(p.Context, db, "namespace=?", gqlStr(svc["namespace"]))
+                        if err != nil {
+                            return nil, err
+                        }
+                        var out []gqlRow
+                        for _, pod := range pods {
+                            if selectorMatches(gqlStr(svc["selector"]), gqlStr(pod["labels"])) {
+                                out = append(out, pod)
+                            }
+                        }
+                        return out, nil
+                    },
+                },
+            }
+        }),
+    })
+
+    deploymentType = graphql.NewObject(graphql.ObjectConfig{
+        Name: "Deployment",
+        Fields: graphql.FieldsThunk(func() graphql.Fields {
+            return graphql.Fields{
+                "uid":           &graphql.Field{Type: graphql.String},
+                "name":          &graphql.Field{Type: graphql.String},
+                "namespace":     &graphql.Field{Type: graphql.String},
+                "replicas":      intField,
+                "readyReplicas": intField,
+                "images":        &graphql.Field{Type: graphql.String},
+                "labels":        &graphql.Field{Type: graphql.String},
+                "updatedAt":     &graphql.Field{Type: graphql.String},
+                "pods": &graphql.Field{
+                    Type: graphql.NewList(podType),
+                    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                        uid := gqlStr(p.Source.(gqlRow)["uid"])
+                        return gqlPods(p.Context, db, `owner_uid=? OR owner_uid IN (SELECT uid FROM replicasets WHERE owner_uid=?)`, uid, uid)
+                    },
+                },
+            }
+        }),
+    })
+
+    nsArgs := graphql.FieldConfigArgument{"namespace": &graphql.ArgumentConfig{Type: graphql.String}}
+    byNS := func(p graphql.ResolveParams) (string, []any) {
+        if ns, ok := p.Args["namespace"].(string); ok && ns != "" {
+            return "namespace=?", []any{ns}
+        }
+        return "", nil
+    }
+    query := graphql.NewObject(graphql.ObjectConfig{
+        Name: "Query",
+        Fields: graphql.Fields{
+            "pods": &graphql.Field{
+                Type: graphql.NewList(podType),
+                Args: nsArgs,
+                Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                    where, args := byNS(p)
+                    return gqlPods(p.Context, db, where, args...)
+                },
+            },
+            "pod": &graphql.Field{
+                Type: podType,
+                Args: graphql.FieldConfigArgument{
+                    "uid":       &graphql.ArgumentConfig{Type: graphql.String},
+                    "namespace": &graphql.ArgumentConfig{Type: graphql.String},
+                    "name":      &graphql.ArgumentConfig{Type: graphql.String},
+                },
+                Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                    if uid, ok := p.Args["uid"].(string); ok {
+                        return firstRow(gqlPods(p.Context, db, "uid=?", uid))
+                    }
+                    ns, _ := p.Args["namespace"].(string)
+                    name, _ := p.Args["name"].(string)
+                    return firstRow(gqlPods(p.Context, db, "namespace=? AND name=?", ns, name))
+                },
+            },
+            "nodes": &graphql.Field{
+                Type: graphql.NewList(nodeType),
+                // 同 /cmdb/nodes?capability=，逗号分隔，全部都要有
+                Args: graphql.FieldConfigArgument{"capability": &graphql.ArgumentConfig{Type: graphql.String}},
+                Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                    v, _ := p.Args["capability"].(string)
+                    caps, err := parseCapabilities(v)
+                    if err != nil {
+                        return nil, err
+                    }
+                    if len(caps) == 0 {
+                        return gqlNodes(p.Context, db, "")
+                    }
+                    cond, args := capabilitiesCond(caps)
+                    return gqlNodes(p.Context, db, cond, args...)
+                },
+            },
+            "node": &graphql.Field{
+                Type: nodeType,
+                Args: graphql.FieldConfigArgument{"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+                Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                    return firstRow(gqlNodes(p.Context, db, "name=?", p.Args["name"]))
+                },
+            },
+            "services": &graphql.Field{
+                Type: graphql.NewList(serviceType),
+                Args: nsArgs,
+                Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                    where, args := byNS(p)
+                    return gqlServices(p.Context, db, where, args...)
+                },
+            },
+            "deployments": &graphql.Field{
+                Type: graphql.NewList(deploymentType),
+                Args: nsArgs,
+                Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+                    where, args := byNS(p)
+                    return gqlDeployments(p.Context, db, where, args...)
+                },
+            },
+        },
+    })
+    return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+type graphqlRequest struct {
+    Query         string         `json:"query"`
+    OperationName string         `json:"operationName"`
+    Variables     map[string]any `json:"variables"`
+}
+
+// POST /graphql {"query": "..."}；GET /graphql?query=... 也支持，方便调试
+// 整个查询在一个只读快照里执行，嵌套字段和顶层列表看到的是同一时刻的数据
+func graphqlAPI(db *sql.DB, schema graphql.Schema) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req graphqlRequest
+        switch r.Method {
+        case http.MethodGet:
+            req.Query = r.URL.Query().Get("query")
+            req.OperationName = r.URL.Query().Get("operationName")
+            if v := r.URL.Query().Get("variables"); v != "" {
+                if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+                    http.Error(w, "invalid variables: "+err.Error(), 400)
+                    return
+                }
+            }
+        case http.MethodPost:
+            if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+                b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+                if err != nil {
+                    http.Error(w, err.Error(), 400)
+                    return
+                }
+                req.Query = string(b)
+            } else if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+                http.Error(w, "invalid request: "+err.Error(), 400)
+                return
+            }
+        default:
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if req.Query == "" {
+            http.Error(w, "missing query", 400)
+            return
+        }
+        var res *graphql.Result
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            res = graphql.Do(graphql.Params{
+                Schema:         schema,
+                RequestString:  req.Query,
+                VariableValues: req.Variables,
+                OperationName:  req.OperationName,
+                Context:        ctx,
+            })
+            return nil
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, res)
+    }
+}
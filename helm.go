@@ -0,0 +1,601 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    listersv1 "k8s.io/client-go/listers/core/v1"
+    "k8s.io/client-go/tools/cache"
+    "sigs.k8s.io/yaml"
+)
+
+// ---------- Helm releases ----------
+
+// Helm 3 把每个 revision 存成一个 Secret：type helm.sh/release.v1，名字 sh.helm.release.v1.<release>.v<revision>，
+// 标签 owner=helm、name=<release>、version=<revision>，data.release 是 base64 后 gzip 的 release JSON。
+// 单独的 informer 只 List/Watch 这种类型的 Secret（需要 secrets 的 list/watch 权限），进缓存前就解码，
+// 只留下 chart、状态、values 的哈希和 manifest 里的对象列表，manifest 和 values 本身不进缓存也不入库。
+// 一个 release 在 helm_releases 里一行，取最高的 revision（和 helm list 一样，升级失败时是 failed 的那个）；
+// manifest 里的对象存在 helm_release_resources，/cmdb/workloads、拓扑据此把 Deployment / Service 关联到 release。
+
+const (
+    helmSecretType = "helm.sh/release.v1"
+    // transform 后 Secret.Data 里只剩这一项：helmRelease 的 JSON
+    helmSummaryKey = "lightcmdb.summary"
+)
+
+var helmSecretNameRe = regexp.MustCompile(`^sh\.helm\.release\.v1\.(.+)\.v(\d+)$`)
+
+type helmResource struct {
+    Kind      string `json:"kind"`
+    Namespace string `json:"namespace,omitempty"`
+    Name      string `json:"name"`
+}
+
+type helmRelease struct {
+    Namespace     string         `json:"namespace"`
+    Name          string         `json:"name"`
+    Revision      int            `json:"revision"`
+    Chart         string         `json:"chart"`
+    ChartVersion  string         `json:"chartVersion"`
+    AppVersion    string         `json:"appVersion"`
+    Status        string         `json:"status"`
+    ValuesHash    string         `json:"valuesHash"`
+    FirstDeployed string         `json:"firstDeployed"`
+    LastDeployed  string         `json:"lastDeployed"`
+    Description   string         `json:"description"`
+    Resources     []helmResource `json:"resources"`
+}
+
+func initHelm(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS helm_releases(
+    ref TEXT PRIMARY KEY,
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    revision INTEGER,
+    chart TEXT,
+    chart_version TEXT,
+    app_version TEXT,
+    status TEXT,
+    values_hash TEXT,
+    first_deployed TEXT,
+    last_deployed TEXT,
+    description TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS helm_release_resources(
+    release TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY(release, kind, namespace, name)
+);`,
+        `CREATE INDEX IF NOT EXISTS helm_release_resources_obj ON helm_release_resources(kind, namespace, name)`,
+        `DROP TRIGGER IF EXISTS helm_releases_ad`,
+        `CREATE TRIGGER helm_releases_ad AFTER DELETE ON helm_releases BEGIN
+ DELETE FROM helm_release_resources WHERE release=old.ref; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// data.release：base64(gzip(JSON))，老版本没有 gzip
+func decodeHelmRelease(data []byte) (helmRelease, error) {
+    var rel helmRelease
+    b, err := base64.StdEncoding.DecodeString(string(data))
+    if err != nil {
+        return rel, fmt.Errorf("base64: %w", err)
+    }
+    if len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b {
+        zr, err := gzip.NewReader(bytes.NewReader(b))
+        if err != nil {
+            return rel, err
+        }
+        if b, err = io.ReadAll(zr); err != nil {
+            return rel, fmt.Errorf("gzip: %w", err)
+        }
+    }
+    var raw struct {
+        Name      string `json:"name"`
+        Namespace string `json:"namespace"`
+        Version   int    `json:"version"`
+        Info      struct {
+            FirstDeployed string `json:"first_deployed"`
+            LastDeployed  string `json:"last_deployed"`
+            Description   string `json:"description"`
+            Status        string `json:"status"`
+        } `json:"info"`
+        Chart struct {
+            Metadata struct {
+                Name       string `json:"name"`
+                Version    string `json:"version"`
+                AppVersion string `json:"appVersion"`
+            } `json:"metadata"`
+        } `json:"chart"`
+        Config   any    `json:"config"`
+        Manifest string `json:"manifest"`
+    }
+    if err := json.Unmarshal(b, &raw); err != nil {
+        return rel, err
+    }
+    rel = helmRelease{Namespace: raw.Namespace, Name: raw.Name, Revision: raw.Version, Chart: raw.Chart.Metadata.Name,
+        ChartVersion: raw.Chart.Metadata.Version, AppVersion: raw.Chart.Metadata.AppVersion, Status: raw.Info.Status,
+        FirstDeployed: helmTime(raw.Info.FirstDeployed), LastDeployed: helmTime(raw.Info.LastDeployed), Description: raw.Info.Description}
+    // 用户给的 values（helm get values），不含 chart 的默认值；重新序列化后 key 有序，同样的 values 哈希相同
+    if raw.Config == nil {
+        raw.Config = map[string]any{}
+    }
+    canon, _ := json.Marshal(raw.Config)
+    sum := sha256.Sum256(canon)
+    rel.ValuesHash = hex.EncodeToString(sum[:])
+    rel.Resources = helmManifestResources(raw.Manifest)
+    return rel, nil
+}
+
+// Helm 写的是带纳秒和时区的 RFC3339
+func helmTime(s string) string {
+    t, err := time.Parse(time.RFC3339Nano, s)
+    if err != nil {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339)
+}
+
+var yamlDocSep = regexp.MustCompile(`(?m)^---`)
+
+// manifest 是渲染后的多文档 YAML；List 类型的文档不展开
+func helmManifestResources(manifest string) []helmResource {
+    var out []helmResource
+    seen := map[helmResource]bool{}
+    for _, doc := range yamlDocSep.Split(manifest, -1) {
+        var m struct {
+            Kind     string `json:"kind"`
+            Metadata struct {
+                Name      string `json:"name"`
+                Namespace string `json:"namespace"`
+            } `json:"metadata"`
+        }
+        if err := yaml.Unmarshal([]byte(doc), &m); err != nil || m.Kind == "" || m.Metadata.Name == "" {
+            continue
+        }
+        r := helmResource{Kind: m.Kind, Namespace: m.Metadata.Namespace, Name: m.Metadata.Name}
+        if !seen[r] {
+            seen[r] = true
+            out = append(out, r)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool {
+        a, b := out[i], out[j]
+        if a.Kind != b.Kind {
+            return a.Kind < b.Kind
+        }
+        return a.Name < b.Name
+    })
+    return out
+}
+
+// 给 SetTransform 用：解码一次，缓存里只留摘要；对已经转换过的对象原样返回
+func helmSecretTransform(obj interface{}) (interface{}, error) {
+    s, ok := obj.(*corev1.Secret)
+    if !ok || s.Data[helmSummaryKey] != nil {
+        return obj, nil
+    }
+    rel, err := decodeHelmRelease(s.Data["release"])
+    if err != nil {
+        log.Printf("[helm] decode %s/%s: %v", s.Namespace, s.Name, err)
+        rel = helmRelease{Status: "undecodable"}
+    }
+    if rel.Namespace == "" {
+        rel.Namespace = s.Namespace
+    }
+    if rel.Name == "" || rel.Revision == 0 {
+        name, rev := helmSecretRelease(s)
+        rel.Name, rel.Revision = name, rev
+    }
+    summary, err := json.Marshal(rel)
+    if err != nil {
+        return nil, err
+    }
+    s.ManagedFields = nil
+    s.Data = map[string][]byte{helmSummaryKey: summary}
+    return s, nil
+}
+
+// 优先用标签，没有时从 Secret 名字里取
+func helmSecretRelease(s *corev1.Secret) (string, int) {
+    name := s.Labels["name"]
+    rev, _ := strconv.Atoi(s.Labels["version"])
+    if m := helmSecretNameRe.FindStringSubmatch(s.Name); m != nil {
+        if name == "" {
+            name = m[1]
+        }
+        if rev == 0 {
+            rev, _ = strconv.Atoi(m[2])
+        }
+    }
+    return name, rev
+}
+
+type helmWatcher struct {
+    db     *sql.DB
+    lister listersv1.SecretLister
+}
+
+// 某个 release 的所有 revision 里取最高的写库；一个都没有了（helm uninstall）就删掉
+func (h *helmWatcher) resync(ns, name string) error {
+    secrets, err := h.lister.Secrets(ns).List(labels.SelectorFromSet(labels.Set{"owner": "helm", "name": name}))
+    if err != nil {
+        return err
+    }
+    var cur *helmRelease
+    for _, s := range secrets {
+        var rel helmRelease
+        if err := json.Unmarshal(s.Data[helmSummaryKey], &rel); err != nil {
+            continue
+        }
+        if cur == nil || rel.Revision > cur.Revision {
+            cur = &rel
+        }
+    }
+    ref := ns + "/" + name
+    if cur == nil {
+        _, err := h.db.Exec(`DELETE FROM helm_releases WHERE ref=?`, ref)
+        return err
+    }
+    return storeHelmRelease(h.db, ref, *cur)
+}
+
+func storeHelmRelease(db *sql.DB, ref string, rel helmRelease) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    now := time.Now().UTC().Format(time.RFC3339)
+    if _, err := tx.Exec(`
+INSERT INTO helm_releases(ref,namespace,name,revision,chart,chart_version,app_version,status,values_hash,first_deployed,last_deployed,description,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(ref) DO UPDATE SET
+ revision=excluded.revision,
+ chart=excluded.chart,
+ chart_version=excluded.chart_version,
+ app_version=excluded.app_version,
+ status=excluded.status,
+ values_hash=excluded.values_hash,
+ first_deployed=excluded.first_deployed,
+ last_deployed=excluded.last_deployed,
+ description=excluded.description,
+ updated_at=excluded.updated_at
+`, ref, rel.Namespace, rel.Name, rel.Revision, rel.Chart, rel.ChartVersion, rel.AppVersion, rel.Status, rel.ValuesHash,
+        rel.FirstDeployed, rel.LastDeployed, rel.Description, now, now); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM helm_release_resources WHERE release=?`, ref); err != nil {
+        return err
+    }
+    for _, r := range rel.Resources {
+        // 没写 namespace 的对象装在 release 的 namespace 里（集群级对象也一样记，只是用不上）
+        ns := r.Namespace
+        if ns == "" {
+            ns = rel.Namespace
+        }
+        if _, err := tx.Exec(`INSERT OR IGNORE INTO helm_release_resources(release,kind,namespace,name) VALUES(?,?,?,?)`,
+            ref, r.Kind, ns, r.Name); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// 启动时库里有、集群里已经没有的 release（停机期间卸载的）
+func (h *helmWatcher) prune() error {
+    rows, err := h.db.Query(`SELECT namespace,name FROM helm_releases`)
+    if err != nil {
+        return err
+    }
+    var gone [][2]string
+    for rows.Next() {
+        var ns, name string
+        if err := rows.Scan(&ns, &name); err != nil {
+            rows.Close()
+            return err
+        }
+        gone = append(gone, [2]string{ns, name})
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    for _, r := range gone {
+        if err := h.resync(r[0], r[1]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 单独的 informer，只 List/Watch type=helm.sh/release.v1 的 Secret；需要 secrets 的 list/watch 权限
+func watchHelmReleases(db *sql.DB, client kubernetes.Interface, stop <-chan struct{}) {
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+        o.FieldSelector = "type=" + helmSecretType
+    }))
+    inf := factory.Core().V1().Secrets().Informer()
+    if err := inf.SetTransform(helmSecretTransform); err != nil {
+        log.Printf("[helm] transform: %v", err)
+    }
+    h := &helmWatcher{db: db, lister: factory.Core().V1().Secrets().Lister()}
+    handle := func(obj interface{}) {
+        if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+            obj = d.Obj
+        }
+        s, ok := obj.(*corev1.Secret)
+        if !ok {
+            return
+        }
+        name, _ := helmSecretRelease(s)
+        if name == "" {
+            return
+        }
+        if err := h.resync(s.Namespace, name); err != nil {
+            log.Printf("[helm] %s/%s err=%v", s.Namespace, name, err)
+        }
+    }
+    inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+        AddFunc:    handle,
+        UpdateFunc: func(_, obj interface{}) { handle(obj) },
+        DeleteFunc: handle,
+    })
+    factory.Start(stop)
+    go func() {
+        // 不等同步：缺权限时只会打日志，不影响启动
+        if !cache.WaitForCacheSync(stop, inf.HasSynced) {
+            return
+        }
+        if err := h.prune(); err != nil {
+            log.Printf("[helm] prune: %v", err)
+        }
+    }()
+}
+
+// ---------- HTTP ----------
+
+type HelmReleaseRow struct {
+    Namespace     string `json:"namespace"`
+    Name          string `json:"name"`
+    Revision      int64  `json:"revision"`
+    Chart         string `json:"chart"`
+    ChartVersion  string `json:"chartVersion"`
+    AppVersion    string `json:"appVersion"`
+    Status        string `json:"status"`
+    ValuesHash    string `json:"valuesHash"`
+    FirstDeployed string `json:"firstDeployed"`
+    LastDeployed  string `json:"lastDeployed"`
+    Description   string `json:"description"`
+    // manifest 里的 Deployment 中库里有的，逗号分隔
+    Deployments string `json:"deployments"`
+    UpdatedAt   string `json:"updatedAt"`
+}
+
+type HelmReleaseResource struct {
+    Kind      string `json:"kind"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // 拓扑 id；只有库里有的 Deployment / Service 才有
+    CI string `json:"ci,omitempty"`
+}
+
+type HelmReleaseDetail struct {
+    HelmReleaseRow
+    Resources []HelmReleaseResource `json:"resources"`
+}
+
+const helmReleaseSelect = `SELECT r.namespace,r.name,coalesce(r.revision,0),coalesce(r.chart,''),coalesce(r.chart_version,''),
+ coalesce(r.app_version,''),coalesce(r.status,''),coalesce(r.values_hash,''),coalesce(r.first_deployed,''),coalesce(r.last_deployed,''),
+ coalesce(r.description,''),
+ coalesce((SELECT group_concat(x.name) FROM helm_release_resources x JOIN deployments d ON d.namespace=x.namespace AND d.name=x.name
+  WHERE x.release=r.ref AND x.kind='Deployment'),''),
+ coalesce(r.updated_at,'')
+FROM helm_releases r`
+
+func scanHelmRelease(rows *sql.Rows) (HelmReleaseRow, error) {
+    var h HelmReleaseRow
+    err := rows.Scan(&h.Namespace, &h.Name, &h.Revision, &h.Chart, &h.ChartVersion, &h.AppVersion, &h.Status, &h.ValuesHash,
+        &h.FirstDeployed, &h.LastDeployed, &h.Description, &h.Deployments, &h.UpdatedAt)
+    return h, err
+}
+
+// GET /cmdb/helm/releases?ns=&chart=&status=           哪个 chart 的哪个版本装在哪里
+// GET /cmdb/helm/releases/{namespace}/{name}           带 manifest 里的对象
+func helmReleasesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/helm/releases"), "/"); rest != "" {
+            ns, name, ok := strings.Cut(rest, "/")
+            if !ok || ns == "" || name == "" {
+                http.Error(w, "path must be /cmdb/helm/releases/{namespace}/{name}", 400)
+                return
+            }
+            getHelmRelease(db, w, r, ns, name)
+            return
+        }
+        q := r.URL.Query()
+        var conds []string
+        var condArgs []any
+        for _, f := range []struct{ param, col string }{{"ns", "r.namespace"}, {"chart", "r.chart"}, {"status", "r.status"}} {
+            if v := q.Get(f.param); v != "" {
+                conds = append(conds, f.col+"=?")
+                condArgs = append(condArgs, v)
+            }
+        }
+        where, args := scopeOf(r.Context()).where("r.namespace", strings.Join(conds, " AND "), condArgs...)
+        rows, err := db.QueryContext(r.Context(), helmReleaseSelect+where+` ORDER BY r.namespace,r.name`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "helm releases", HelmReleaseRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            h, err := scanHelmRelease(rows)
+            if err != nil {
+                lw.Fail(err)
+                return
+            }
+            if err := lw.Write(h); err != nil {
+                log.Printf("[http] write helm releases: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
+
+func getHelmRelease(db *sql.DB, w http.ResponseWriter, r *http.Request, ns, name string) {
+    ctx := r.Context()
+    where, args := scopeOf(ctx).where("r.namespace", "r.ref=?", ns+"/"+name)
+    rows, err := db.QueryContext(ctx, helmReleaseSelect+where, args...)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    var rel *HelmReleaseDetail
+    if rows.Next() {
+        h, err := scanHelmRelease(rows)
+        if err != nil {
+            rows.Close()
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        rel = &HelmReleaseDetail{HelmReleaseRow: h}
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if rel == nil {
+        http.Error(w, "not found", 404)
+        return
+    }
+    if rel.Resources, err = helmResources(ctx, db, ns+"/"+name); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    writeJSON(w, r, rel)
+}
+
+func helmResources(ctx context.Context, db *sql.DB, ref string) ([]HelmReleaseResource, error) {
+    rows, err := dbFrom(ctx, db).Query(`SELECT x.kind,x.namespace,x.name,
+ CASE WHEN x.kind='Deployment' AND EXISTS(SELECT 1 FROM deployments d WHERE d.namespace=x.namespace AND d.name=x.name)
+   OR x.kind='Service' AND EXISTS(SELECT 1 FROM services s WHERE s.namespace=x.namespace AND s.name=x.name) THEN 1 ELSE 0 END
+FROM helm_release_resources x WHERE x.release=? ORDER BY x.kind,x.namespace,x.name`, ref)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := []HelmReleaseResource{}
+    for rows.Next() {
+        var res HelmReleaseResource
+        var found bool
+        if err := rows.Scan(&res.Kind, &res.Namespace, &res.Name, &found); err != nil {
+            return nil, err
+        }
+        if found {
+            res.CI = topoID(strings.ToLower(res.Kind), res.Namespace, res.Name)
+        }
+        out = append(out, res)
+    }
+    return out, rows.Err()
+}
+
+// 拓扑：helmrelease -> 它装的 Deployment / Service（owns）；反过来从这些对象找到 release
+func helmReleaseLinks(ctx context.Context, db *sql.DB, o topoObj) ([]topoLink, error) {
+    if o.node.Kind != "helmrelease" {
+        rows, err := dbFrom(ctx, db).Query(`SELECT r.namespace,r.name FROM helm_release_resources x JOIN helm_releases r ON r.ref=x.release
+ WHERE x.kind=? AND x.namespace=? AND x.name=? ORDER BY 1,2`, helmTopoKinds[o.node.Kind], o.node.Namespace, o.node.Name)
+        if err != nil {
+            return nil, err
+        }
+        defer rows.Close()
+        var out []topoLink
+        for rows.Next() {
+            var ns, name string
+            if err := rows.Scan(&ns, &name); err != nil {
+                return nil, err
+            }
+            rel := topoObj{node: TopoNode{ID: topoID("helmrelease", ns, name), Kind: "helmrelease", Name: name, Namespace: ns}}
+            out = append(out, topoLink{obj: rel, edge: TopoEdge{Source: rel.node.ID, Target: o.node.ID, Type: "owns"}})
+        }
+        return out, rows.Err()
+    }
+    res, err := helmResources(ctx, db, o.node.Namespace+"/"+o.node.Name)
+    if err != nil {
+        return nil, err
+    }
+    var out []topoLink
+    for _, x := range res {
+        if x.CI == "" {
+            continue
+        }
+        objs, err := loadTopoRoot(ctx, db, x.CI)
+        if errors.Is(err, errTopoRootNotFound) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, topoLink{obj: *objs, edge: TopoEdge{Source: o.node.ID, Target: objs.node.ID, Type: "owns"}})
+    }
+    return out, nil
+}
+
+var helmTopoKinds = map[string]string{"deployment": "Deployment", "service": "Service"}
+
+func loadTopoHelmRelease(ctx context.Context, db *sql.DB, ns, name string) (*topoObj, error) {
+    where, args := scopeOf(ctx).where("namespace", "ref=?", ns+"/"+name)
+    var n int
+    if err := dbFrom(ctx, db).QueryRow(`SELECT count(*) FROM helm_releases`+where, args...).Scan(&n); err != nil {
+        return nil, err
+    }
+    if n == 0 {
+        return nil, errTopoRootNotFound
+    }
+    return &topoObj{node: TopoNode{ID: topoID("helmrelease", ns, name), Kind: "helmrelease", Name: name, Namespace: ns}}, nil
+}
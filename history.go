@@ -0,0 +1,573 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ---------- History ----------
+
+// changes 由触发器写入：每次业务表的增删改（只看投影列，updated_at 不算）记一条，
+// before/after 是该行投影的 JSON；squashed 表示被压缩合并掉的记录数。
+type historySource struct {
+    Kind      string
+    Table     string
+    Key       string
+    Name      string
+    Namespace string
+    Columns   []string
+}
+
+var historySources = []historySource{
+    {
+        Kind:      "pod",
+        Table:     "pods",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"name", "namespace", "phase", "node_name", "pod_ip", "labels", "images", "cpu_request", "mem_request", "extended_requests"},
+    },
+    {
+        Kind:      "node",
+        Table:     "nodes",
+        Key:       "name",
+        Name:      "name",
+        Namespace: "''",
+        Columns: []string{"name", "labels", "capacity_cpu", "capacity_mem", "capacity_extended", "allocatable_extended", "internal_ip",
+            "capabilities", "devices", "ready", "unschedulable"},
+    },
+    {
+        Kind:      "service",
+        Table:     "services",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"name", "namespace", "type", "cluster_ip", "selector", "ports", "labels", "lb_ips", "lb_pool", "lb_node"},
+    },
+    {
+        Kind:      "deployment",
+        Table:     "deployments",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"name", "namespace", "replicas", "images", "labels"},
+    },
+    {
+        Kind:      "vm",
+        Table:     "virtual_machines",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"name", "namespace", "run_strategy", "cpu_cores", "mem_request", "labels"},
+    },
+    {
+        Kind:      "vmi",
+        Table:     "vm_instances",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"name", "namespace", "phase", "node_name", "ip", "guest_os", "cpu_cores", "mem_request"},
+    },
+    {
+        Kind:      "asset",
+        Table:     "assets",
+        Key:       "id",
+        Name:      "name",
+        Namespace: "''",
+        Columns:   []string{"type", "name", "site", "owner", "labels", "attributes"},
+    },
+    {
+        Kind:      "host",
+        Table:     "hosts",
+        Key:       "address",
+        Name:      "address",
+        Namespace: "''",
+        Columns:   []string{"hostname", "os", "kernel", "cpu_cores", "mem_bytes", "ips", "services"},
+    },
+    {
+        Kind:      "helm-release",
+        Table:     "helm_releases",
+        Key:       "ref",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"name", "namespace", "revision", "chart", "chart_version", "app_version", "status", "values_hash"},
+    },
+    {
+        Kind:      "argocd-app",
+        Table:     "argo_applications",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"project", "repo_url", "path", "chart", "target_revision", "sync_status", "synced_revision", "health_status"},
+    },
+    {
+        Kind:      "tls-secret",
+        Table:     "tls_secrets",
+        Key:       "ref",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"common_name", "dns_names", "issuer", "serial", "not_after", "error"},
+    },
+    {
+        Kind:      "certificate",
+        Table:     "cert_manager_certificates",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"secret_name", "dns_names", "issuer", "not_after", "ready"},
+    },
+    {
+        Kind:      "resourcequota",
+        Table:     "resource_quotas",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        // used 随 Pod 增减一直在变，不记
+        Columns: []string{"scopes", "hard", "labels"},
+    },
+    {
+        Kind:      "limitrange",
+        Table:     "limit_ranges",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"limits", "labels"},
+    },
+    {
+        Kind:      "serviceaccount",
+        Table:     "service_accounts",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"automount_token", "image_pull_secrets", "labels"},
+    },
+    {
+        Kind:      "role",
+        Table:     "roles",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"rules", "labels"},
+    },
+    {
+        Kind:      "clusterrole",
+        Table:     "cluster_roles",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "''",
+        Columns:   []string{"aggregation", "rules", "labels"},
+    },
+    {
+        Kind:      "rolebinding",
+        Table:     "role_bindings",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "namespace",
+        Columns:   []string{"role_kind", "role_name", "subjects", "labels"},
+    },
+    {
+        Kind:      "clusterrolebinding",
+        Table:     "cluster_role_bindings",
+        Key:       "uid",
+        Name:      "name",
+        Namespace: "''",
+        Columns:   []string{"role_name", "subjects", "labels"},
+    },
+    {
+        Kind:      "network-device",
+        Table:     "network_devices",
+        Key:       "address",
+        Name:      "address",
+        Namespace: "''",
+        Columns:   []string{"sys_name", "sys_descr", "sys_object_id", "serial", "ips"},
+    },
+    {
+        Kind:      "cloud-instance",
+        Table:     "cloud_instances",
+        Key:       "id",
+        Name:      "name",
+        Namespace: "''",
+        Columns:   []string{"name", "type", "state", "zone", "private_ip", "public_ip", "security_groups", "tags"},
+    },
+    {
+        Kind:      "relation",
+        Table:     "relations",
+        Key:       "id",
+        Name:      "type",
+        Namespace: "from_ns",
+        Columns:   []string{"from_id", "to_id", "type", "note"},
+    },
+    {
+        // ref 是 pod uid / node name / host address，name 列放的是对象类别
+        Kind:      "attributes",
+        Table:     "ci_attributes",
+        Key:       "ref",
+        Name:      "kind",
+        Namespace: "''",
+        Columns:   []string{"kind", "ref", "attributes"},
+    },
+}
+
+func (h historySource) jsonObject(alias string) string {
+    var parts []string
+    for _, c := range h.Columns {
+        parts = append(parts, fmt.Sprintf("'%s',%s.%s", c, alias, c))
+    }
+    return "json_object(" + strings.Join(parts, ",") + ")"
+}
+
+// 给调用方看的对象：加密字段还原成明文。触发器、baseline 这些写库的地方仍用 jsonObject
+func (h historySource) plainObject(alias string) string {
+    return "cmdb_unseal(" + h.jsonObject(alias) + ")"
+}
+
+func (h historySource) col(expr, alias string) string {
+    if strings.HasPrefix(expr, "'") {
+        return expr
+    }
+    return alias + "." + expr
+}
+
+func (h historySource) insertSQL(op, alias, before, after string) string {
+    return fmt.Sprintf(`INSERT INTO changes(kind,ref,namespace,name,op,before,after,source,ts)
+ VALUES('%s',%s.%s,%s,%s,'%s',%s,%s,(SELECT source FROM change_context WHERE id=1),strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ','now'));`,
+        h.Kind, alias, h.Key, h.col(h.Namespace, alias), h.col(h.Name, alias), op, before, after)
+}
+
+func initHistory(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS changes(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    namespace TEXT,
+    name TEXT,
+    op TEXT NOT NULL,
+    before TEXT,
+    after TEXT,
+    source TEXT,
+    ts TEXT NOT NULL,
+    squashed INTEGER NOT NULL DEFAULT 0,
+    first_ts TEXT
+);`,
+        `CREATE INDEX IF NOT EXISTS changes_ref ON changes(kind, ref, id)`,
+        `CREATE INDEX IF NOT EXISTS changes_ts ON changes(ts)`,
+        // 触发器通过它拿到写入来源（informer / reconcile / manual ...）
+        `CREATE TABLE IF NOT EXISTS change_context(id INTEGER PRIMARY KEY CHECK (id = 1), source TEXT NOT NULL)`,
+        `INSERT INTO change_context(id,source) VALUES(1,'informer') ON CONFLICT(id) DO UPDATE SET source='informer'`,
+    }
+    for _, h := range historySources {
+        var changed []string
+        for _, c := range h.Columns {
+            changed = append(changed, fmt.Sprintf("old.%s IS NOT new.%s", c, c))
+        }
+        stmts = append(stmts,
+            fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_history_ai`, h.Table),
+            fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_history_au`, h.Table),
+            fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_history_ad`, h.Table),
+            fmt.Sprintf(`CREATE TRIGGER %s_history_ai AFTER INSERT ON %s BEGIN
+ %s
+END;`, h.Table, h.Table, h.insertSQL("create", "new", "NULL", h.jsonObject("new"))),
+            fmt.Sprintf(`CREATE TRIGGER %s_history_au AFTER UPDATE ON %s WHEN %s BEGIN
+ %s
+END;`, h.Table, h.Table, strings.Join(changed, " OR "), h.insertSQL("update", "new", h.jsonObject("old"), h.jsonObject("new"))),
+            fmt.Sprintf(`CREATE TRIGGER %s_history_ad AFTER DELETE ON %s BEGIN
+ %s
+END;`, h.Table, h.Table, h.insertSQL("delete", "old", h.jsonObject("old"), "NULL")),
+        )
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return addColumnIfMissing(db, "changes", "first_ts", "TEXT")
+}
+
+// 在一个事务里把触发器看到的来源切成 source，结束时恢复为 informer。
+// 单连接 + 事务，期间不会混入 informer 的写入。
+func withChangeSource(db *sql.DB, source string, fn func(tx *sql.Tx) error) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if _, err := tx.Exec(`UPDATE change_context SET source=? WHERE id=1`, source); err != nil {
+        return err
+    }
+    if err := fn(tx); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`UPDATE change_context SET source='informer' WHERE id=1`); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+type ChangeRow struct {
+    ID        int64   `json:"id"`
+    Kind      string  `json:"kind"`
+    Ref       string  `json:"ref"`
+    Namespace string  `json:"namespace,omitempty"`
+    Name      string  `json:"name"`
+    Op        string  `json:"op"`
+    Before    RawJSON `json:"before"`
+    After     RawJSON `json:"after"`
+    Source    string  `json:"source"`
+    TS        string  `json:"ts"`
+    Squashed  int     `json:"squashed,omitempty"`
+    // 同一对象在 events.window 内的 Kubernetes 事件，见 events.go
+    Events ChangeEvents `json:"events,omitempty"`
+}
+
+// RawJSON 原样输出库里存的 JSON 文本，NULL 输出 null
+type RawJSON string
+
+func (r RawJSON) MarshalJSON() ([]byte, error) {
+    if r == "" {
+        return []byte("null"), nil
+    }
+    return []byte(r), nil
+}
+
+// GET /cmdb/history?kind=pod&ref=<uid>&ns=&since=<RFC3339>&limit=，id= 取单条（metrics exemplar 的 change_id）
+func historyAPI(db *sql.DB, eventWindow time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        sc, args := scopeOf(r.Context()).cond("coalesce(namespace,'')")
+        conds := []string{sc}
+        for _, f := range []struct{ param, col string }{{"id", "id"}, {"kind", "kind"}, {"ref", "ref"}, {"ns", "namespace"}, {"name", "name"}} {
+            if v := q.Get(f.param); v != "" {
+                conds = append(conds, f.col+"=?")
+                args = append(args, v)
+            }
+        }
+        if v := q.Get("since"); v != "" {
+            if _, err := time.Parse(time.RFC3339, v); err != nil {
+                http.Error(w, "since must be RFC3339", 400)
+                return
+            }
+            conds = append(conds, "ts>=?")
+            args = append(args, v)
+        }
+        limit := 200
+        if v := q.Get("limit"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n <= 0 {
+                http.Error(w, "invalid limit", 400)
+                return
+            }
+            limit = min(n, 5000)
+        }
+        query := `SELECT id,kind,ref,coalesce(namespace,''),coalesce(name,''),op,coalesce(cmdb_unseal(before),''),coalesce(cmdb_unseal(after),''),coalesce(source,''),ts,squashed FROM changes`
+        query += " WHERE " + strings.Join(conds, " AND ") + " ORDER BY id DESC LIMIT ?"
+        args = append(args, limit)
+        lw, err := newListWriter(w, r, "history", ChangeRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        // 单连接：先读完再查事件
+        list, err := queryChanges(r.Context(), db, query, args...)
+        if err == nil {
+            err = attachChangeEvents(r.Context(), db, list, eventWindow)
+        }
+        if err != nil {
+            lw.Fail(err)
+            return
+        }
+        for _, c := range list {
+            if err := lw.Write(c); err != nil {
+                log.Printf("[http] write history: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
+
+func queryChanges(ctx context.Context, db *sql.DB, query string, args ...any) ([]ChangeRow, error) {
+    rows, err := db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var list []ChangeRow
+    for rows.Next() {
+        var c ChangeRow
+        if err := rows.Scan(&c.ID, &c.Kind, &c.Ref, &c.Namespace, &c.Name, &c.Op, &c.Before, &c.After, &c.Source, &c.TS, &c.Squashed); err != nil {
+            return nil, err
+        }
+        list = append(list, c)
+    }
+    return list, rows.Err()
+}
+
+// ---------- History compaction ----------
+
+// 同一对象连续的 update（中间没有 create/delete），跨度不超过 window 的合并成一条：
+// 保留第一条的 id 和 before，after/ts 取最后一条；净变化为空则整段删除。
+// 窗口从这一段最早那条的时间算起（合并后记在 first_ts），否则每次合并 ts 往后挪，窗口会一直滑下去。
+type compactor struct {
+    db      *sql.DB
+    window  time.Duration
+    mu      sync.Mutex
+    lastRun time.Time
+}
+
+type compactStats struct {
+    Scanned  int `json:"scanned"`
+    Squashed int `json:"squashed"`
+    Dropped  int `json:"dropped"`
+}
+
+type pendingChange struct {
+    id       int64
+    kind     string
+    ref      string
+    op       string
+    before   string
+    after    string
+    ts       time.Time
+    tsRaw    string
+    squashed int
+    // 合并前最早那条的 ts；没合并过就是 ts
+    first    time.Time
+    firstRaw string
+}
+
+func (c *compactor) run() (compactStats, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    var st compactStats
+    now := time.Now().UTC()
+    // 只处理已经"关窗"的记录；和上次的扫描范围重叠一个 window，跨批次的连续更新也能合并
+    cutoff := now.Add(-c.window).Format(time.RFC3339)
+    from := ""
+    if !c.lastRun.IsZero() {
+        from = c.lastRun.Add(-2 * c.window).Format(time.RFC3339)
+    }
+    rows, err := c.db.Query(`SELECT id,kind,ref,op,coalesce(before,''),coalesce(after,''),ts,squashed,coalesce(first_ts,ts)
+FROM changes WHERE ts>=? AND ts<? ORDER BY kind,ref,id`, from, cutoff)
+    if err != nil {
+        return st, err
+    }
+    var all []pendingChange
+    for rows.Next() {
+        var p pendingChange
+        if err := rows.Scan(&p.id, &p.kind, &p.ref, &p.op, &p.before, &p.after, &p.tsRaw, &p.squashed, &p.firstRaw); err != nil {
+            rows.Close()
+            return st, err
+        }
+        p.ts, _ = time.Parse(time.RFC3339, p.tsRaw)
+        p.first, _ = time.Parse(time.RFC3339, p.firstRaw)
+        all = append(all, p)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return st, err
+    }
+    st.Scanned = len(all)
+
+    tx, err := c.db.Begin()
+    if err != nil {
+        return st, err
+    }
+    defer tx.Rollback()
+    flushRun := func(run []pendingChange) error {
+        if len(run) < 2 {
+            return nil
+        }
+        first, last := run[0], run[len(run)-1]
+        ids := make([]string, 0, len(run))
+        merged := 0
+        for _, p := range run {
+            ids = append(ids, strconv.FormatInt(p.id, 10))
+            merged += p.squashed
+        }
+        if first.before == last.after {
+            if _, err := tx.Exec(`DELETE FROM changes WHERE id IN (` + strings.Join(ids, ",") + `)`); err != nil {
+                return err
+            }
+            st.Dropped += len(run)
+            return nil
+        }
+        if _, err := tx.Exec(`UPDATE changes SET after=?, ts=?, squashed=?, first_ts=? WHERE id=?`,
+            last.after, last.tsRaw, merged+len(run)-1, first.firstRaw, first.id); err != nil {
+            return err
+        }
+        if _, err := tx.Exec(`DELETE FROM changes WHERE id IN (` + strings.Join(ids[1:], ",") + `)`); err != nil {
+            return err
+        }
+        st.Squashed += len(run) - 1
+        return nil
+    }
+    var run []pendingChange
+    for _, p := range all {
+        if len(run) > 0 {
+            head := run[0]
+            if p.op == "update" && p.kind == head.kind && p.ref == head.ref && p.ts.Sub(head.first) <= c.window {
+                run = append(run, p)
+                continue
+            }
+            if err := flushRun(run); err != nil {
+                return st, err
+            }
+            run = nil
+        }
+        if p.op == "update" {
+            run = []pendingChange{p}
+        }
+    }
+    if err := flushRun(run); err != nil {
+        return st, err
+    }
+    if err := tx.Commit(); err != nil {
+        return st, err
+    }
+    c.lastRun = now
+    return st, nil
+}
+
+func (c *compactor) loop(every time.Duration, stop <-chan struct{}) {
+    t := time.NewTicker(every)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            st, err := c.run()
+            if err != nil {
+                log.Printf("[history] compact: %v", err)
+                continue
+            }
+            if st.Squashed > 0 || st.Dropped > 0 {
+                log.Printf("[history] compact scanned=%d squashed=%d dropped=%d", st.Scanned, st.Squashed, st.Dropped)
+            }
+        }
+    }
+}
+
+// POST /admin/history/compact 立即执行一次
+func compactAPI(c *compactor) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        st, err := c.run()
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, st)
+    }
+}
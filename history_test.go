@@ -0,0 +1,60 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+// 窗口从第一条算起：合并过的记录不能因为 ts 取了最后一条就把后面的更新一直吸进来
+func TestCompactorWindowAnchoredOnFirst(t *testing.T) {
+    db := newTestDB(t)
+    base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+    insert := func(at time.Duration, before, after string) {
+        t.Helper()
+        if _, err := db.Exec(`INSERT INTO changes(kind,ref,op,before,after,source,ts) VALUES('pod','uid-1','update',?,?,'informer',?)`,
+            before, after, base.Add(at).Format(time.RFC3339)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    c := &compactor{db: db, window: 10 * time.Minute}
+    insert(0, `{"v":0}`, `{"v":1}`)
+    insert(8*time.Minute, `{"v":1}`, `{"v":2}`)
+    if _, err := c.run(); err != nil {
+        t.Fatal(err)
+    }
+    // 离合并后的 ts 只有 4 分钟，离第一条已经 12 分钟，不能再合进去
+    insert(12*time.Minute, `{"v":2}`, `{"v":3}`)
+    // 从头重扫，让合并后的记录和新记录落在同一次扫描里
+    c.lastRun = time.Time{}
+    st, err := c.run()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if st.Squashed != 0 {
+        t.Fatalf("squashed %d records outside the window", st.Squashed)
+    }
+    rows, err := db.Query(`SELECT after,squashed,first_ts FROM changes ORDER BY id`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+    type row struct {
+        after    string
+        squashed int
+        first    any
+    }
+    var got []row
+    for rows.Next() {
+        var r row
+        if err := rows.Scan(&r.after, &r.squashed, &r.first); err != nil {
+            t.Fatal(err)
+        }
+        got = append(got, r)
+    }
+    if len(got) != 2 || got[0].after != `{"v":2}` || got[0].squashed != 1 || got[1].after != `{"v":3}` {
+        t.Fatalf("changes = %+v", got)
+    }
+    if got[0].first != base.Format(time.RFC3339) {
+        t.Fatalf("first_ts = %v, want %s", got[0].first, base.Format(time.RFC3339))
+    }
+}
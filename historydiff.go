@@ -0,0 +1,283 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "html/template"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// ---------- History diff ----------
+
+// 对比同一对象任意两条历史记录之后的状态。投影先展开成“一行一个字段/元素”的文本
+// （labels、images 这类逗号列表每项一行），再按行做 LCS diff，给出 unified 和左右对照两种形式。
+// ?format=html 直接渲染成页面，给值班同学在浏览器里看。
+
+// 这些列存的是逗号分隔的列表
+var listColumns = map[string]bool{"labels": true, "selector": true, "images": true, "ports": true}
+
+type DiffVersion struct {
+    ID int64  `json:"id"`
+    Op string `json:"op"`
+    TS string `json:"ts"`
+}
+
+// Op: same / changed / removed / added；removed 只有 Left，added 只有 Right
+type DiffRow struct {
+    Op    string `json:"op"`
+    Left  string `json:"left,omitempty"`
+    Right string `json:"right,omitempty"`
+}
+
+type HistoryDiff struct {
+    Kind      string      `json:"kind"`
+    Ref       string      `json:"ref"`
+    Namespace string      `json:"namespace,omitempty"`
+    Name      string      `json:"name"`
+    From      DiffVersion `json:"from"`
+    To        DiffVersion `json:"to"`
+    Unified   string      `json:"unified"`
+    Rows      []DiffRow   `json:"rows"`
+}
+
+type diffRecord struct {
+    kind, ref, ns, name string
+    version             DiffVersion
+    state               string
+}
+
+var (
+    errChangeNotFound = errors.New("change not found")
+    errDiffObjects    = errors.New("from and to belong to different objects")
+)
+
+func loadDiffRecord(db *sql.DB, scope nsScope, id string) (*diffRecord, error) {
+    sc, args := scope.cond("coalesce(namespace,'')")
+    // delete 之后对象不存在，状态为空
+    var d diffRecord
+    err := db.QueryRow(`SELECT id,kind,ref,coalesce(namespace,''),coalesce(name,''),op,ts,coalesce(cmdb_unseal(after),'') FROM changes WHERE id=? AND `+sc,
+        append([]any{id}, args...)...).Scan(&d.version.ID, &d.kind, &d.ref, &d.ns, &d.name, &d.version.Op, &d.version.TS, &d.state)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, errChangeNotFound
+    }
+    return &d, err
+}
+
+// 投影 JSON -> 行；字段按名字排序
+func projectionLines(state string) ([]string, error) {
+    if state == "" {
+        return nil, nil
+    }
+    obj := map[string]any{}
+    if err := json.Unmarshal([]byte(state), &obj); err != nil {
+        return nil, err
+    }
+    keys := make([]string, 0, len(obj))
+    for k := range obj {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var lines []string
+    for _, k := range keys {
+        v := obj[k]
+        s, isStr := v.(string)
+        switch {
+        case listColumns[k] && isStr:
+            lines = append(lines, k+":")
+            if s != "" {
+                for _, item := range strings.Split(s, ",") {
+                    lines = append(lines, "  "+item)
+                }
+            }
+        case isStr:
+            lines = append(lines, k+": "+s)
+        case v == nil:
+            lines = append(lines, k+": null")
+        default:
+            b, _ := json.Marshal(v)
+            lines = append(lines, k+": "+string(b))
+        }
+    }
+    return lines, nil
+}
+
+// 标准 LCS，投影只有几十行，O(n*m) 足够
+func diffLines(a, b []string) []DiffRow {
+    lcs := make([][]int, len(a)+1)
+    for i := range lcs {
+        lcs[i] = make([]int, len(b)+1)
+    }
+    for i := len(a) - 1; i >= 0; i-- {
+        for j := len(b) - 1; j >= 0; j-- {
+            if a[i] == b[j] {
+                lcs[i][j] = lcs[i+1][j+1] + 1
+            } else {
+                lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+            }
+        }
+    }
+    var rows []DiffRow
+    i, j := 0, 0
+    for i < len(a) || j < len(b) {
+        switch {
+        case i < len(a) && j < len(b) && a[i] == b[j]:
+            rows = append(rows, DiffRow{Op: "same", Left: a[i], Right: b[j]})
+            i++
+            j++
+        case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+            rows = append(rows, DiffRow{Op: "removed", Left: a[i]})
+            i++
+        default:
+            rows = append(rows, DiffRow{Op: "added", Right: b[j]})
+            j++
+        }
+    }
+    return rows
+}
+
+// 左右对照时把相邻的一段 removed + added 两两配成 changed
+func sideBySide(rows []DiffRow) []DiffRow {
+    var out []DiffRow
+    for i := 0; i < len(rows); {
+        if rows[i].Op == "same" {
+            out = append(out, rows[i])
+            i++
+            continue
+        }
+        var removed, added []string
+        for ; i < len(rows) && rows[i].Op != "same"; i++ {
+            if rows[i].Op == "removed" {
+                removed = append(removed, rows[i].Left)
+            } else {
+                added = append(added, rows[i].Right)
+            }
+        }
+        for k := 0; k < max(len(removed), len(added)); k++ {
+            switch {
+            case k < len(removed) && k < len(added):
+                out = append(out, DiffRow{Op: "changed", Left: removed[k], Right: added[k]})
+            case k < len(removed):
+                out = append(out, DiffRow{Op: "removed", Left: removed[k]})
+            default:
+                out = append(out, DiffRow{Op: "added", Right: added[k]})
+            }
+        }
+    }
+    return out
+}
+
+func unifiedDiff(from, to DiffVersion, rows []DiffRow) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "--- #%d %s %s\n+++ #%d %s %s\n", from.ID, from.Op, from.TS, to.ID, to.Op, to.TS)
+    for _, r := range rows {
+        switch r.Op {
+        case "same":
+            b.WriteString(" " + r.Left + "\n")
+        case "removed":
+            b.WriteString("-" + r.Left + "\n")
+        case "added":
+            b.WriteString("+" + r.Right + "\n")
+        }
+    }
+    return b.String()
+}
+
+// 文本和 HTML 格式不经过 writeJSON，遮盖在拆行之前做
+func buildHistoryDiff(db *sql.DB, scope nsScope, masker *fieldMasker, fromID, toID string) (*HistoryDiff, error) {
+    from, err := loadDiffRecord(db, scope, fromID)
+    if err != nil {
+        return nil, err
+    }
+    to, err := loadDiffRecord(db, scope, toID)
+    if err != nil {
+        return nil, err
+    }
+    if from.kind != to.kind || from.ref != to.ref {
+        return nil, errDiffObjects
+    }
+    a, err := projectionLines(masker.maskJSON(from.state))
+    if err != nil {
+        return nil, err
+    }
+    b, err := projectionLines(masker.maskJSON(to.state))
+    if err != nil {
+        return nil, err
+    }
+    rows := diffLines(a, b)
+    return &HistoryDiff{
+        Kind: to.kind, Ref: to.ref, Namespace: to.ns, Name: to.name,
+        From: from.version, To: to.version,
+        Unified: unifiedDiff(from.version, to.version, rows),
+        Rows:    sideBySide(rows),
+    }, nil
+}
+
+var historyDiffPage = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Kind}} {{if .Namespace}}{{.Namespace}}/{{end}}{{.Name}}: #{{.From.ID}} → #{{.To.ID}}</title>
+<style>
+body { font-family: sans-serif; margin: 1.5em; }
+table { border-collapse: collapse; width: 100%; font-family: monospace; }
+td { padding: 1px 6px; white-space: pre-wrap; vertical-align: top; width: 50%; }
+.removed .l, .changed .l { background: #fdd; }
+.added .r, .changed .r { background: #dfd; }
+pre { background: #f6f6f6; padding: 8px; }
+</style>
+</head>
+<body>
+<h2>{{.Kind}} {{if .Namespace}}{{.Namespace}}/{{end}}{{.Name}}</h2>
+<table>
+<tr><th>#{{.From.ID}} {{.From.Op}} {{.From.TS}}</th><th>#{{.To.ID}} {{.To.Op}} {{.To.TS}}</th></tr>
+{{range .Rows}}<tr class="{{.Op}}"><td class="l">{{.Left}}</td><td class="r">{{.Right}}</td></tr>
+{{end}}</table>
+<h3>Unified</h3>
+<pre>{{.Unified}}</pre>
+</body>
+</html>
+`))
+
+// GET /cmdb/history/diff?from=<change id>&to=<change id>&format=json|text|html
+func historyDiffAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        fromID, toID := q.Get("from"), q.Get("to")
+        for _, v := range []string{fromID, toID} {
+            if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+                http.Error(w, "from and to must be change ids", 400)
+                return
+            }
+        }
+        d, err := buildHistoryDiff(db, scopeOf(r.Context()), maskerFrom(r.Context()), fromID, toID)
+        if errors.Is(err, errChangeNotFound) {
+            http.Error(w, err.Error(), 404)
+            return
+        }
+        if errors.Is(err, errDiffObjects) {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        switch q.Get("format") {
+        case "", "json":
+            writeJSON(w, r, d)
+        case "text":
+            w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+            w.Write([]byte(d.Unified))
+        case "html":
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            historyDiffPage.Execute(w, d)
+        default:
+            http.Error(w, "unsupported format", 400)
+        }
+    }
+}
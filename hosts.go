@@ -0,0 +1,373 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/netip"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ---------- Host discovery (SSH) ----------
+
+// 集群之外的裸金属和虚拟机：按 hostDiscovery.targets（主机名、IP、host:port 或 CIDR）逐台 SSH 登录，
+// 执行一段只读的 POSIX sh 脚本收集系统、CPU、内存、IP 和正在运行的 systemd 服务，写到 hosts 表（按 address 区分）。
+// 单独列出的主机连不上时记下 error、保留上次的结果；CIDR 里连不上 22 端口的地址不是主机，直接跳过。
+// 只支持公钥认证，主机密钥按 knownHostsFile 校验。变化进 history（kind=host，source=ssh-discovery）。
+const maxDiscoveryCIDRHosts = 4096
+
+// 每段前面一行 ==name==，命令缺失时该段为空
+const hostFactsScript = `echo '==os=='; (. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME") || uname -s
+echo '==kernel=='; uname -r
+echo '==hostname=='; hostname
+echo '==cpu=='; nproc 2>/dev/null || getconf _NPROCESSORS_ONLN
+echo '==mem_kb=='; awk '/^MemTotal:/{print $2}' /proc/meminfo 2>/dev/null
+echo '==ips=='; hostname -I 2>/dev/null || ip -o addr show scope global 2>/dev/null | awk '{split($4,a,"/");print a[1]}'
+echo '==services=='; systemctl list-units --type=service --state=running --no-legend --plain 2>/dev/null | awk '{print $1}'
+`
+
+type discoveryTarget struct {
+    // 表的 key：单独列出时为配置里的写法（默认端口省略），CIDR 展开的为 IP
+    Address string
+    dial    string
+    // CIDR 展开的地址连不上时不记录
+    fromCIDR bool
+}
+
+// 不带端口的目标用 port（SSH 为 22，SNMP 为 161）；address 里省略默认端口
+func expandTargets(targets []string, port string) ([]discoveryTarget, error) {
+    defaultPort := port
+    var out []discoveryTarget
+    for _, t := range targets {
+        t = strings.TrimSpace(t)
+        if strings.Contains(t, "/") {
+            p, err := netip.ParsePrefix(t)
+            if err != nil {
+                return nil, fmt.Errorf("target %q: %v", t, err)
+            }
+            p = p.Masked()
+            bits := p.Addr().BitLen() - p.Bits()
+            if bits > 12 {
+                return nil, fmt.Errorf("target %q: more than %d addresses", t, maxDiscoveryCIDRHosts)
+            }
+            for a := p.Addr(); p.Contains(a); a = a.Next() {
+                // IPv4 的网络地址和广播地址（/31、/32 除外）
+                if a.Is4() && bits > 1 && (a == p.Addr() || !p.Contains(a.Next())) {
+                    continue
+                }
+                out = append(out, discoveryTarget{Address: a.String(), dial: net.JoinHostPort(a.String(), defaultPort), fromCIDR: true})
+            }
+            continue
+        }
+        if t == "" {
+            return nil, errors.New("empty target")
+        }
+        host, port, err := net.SplitHostPort(t)
+        if err != nil {
+            host, port = strings.Trim(t, "[]"), defaultPort
+        }
+        addr := host
+        if port != defaultPort {
+            addr = net.JoinHostPort(host, port)
+        }
+        out = append(out, discoveryTarget{Address: addr, dial: net.JoinHostPort(host, port)})
+    }
+    return out, nil
+}
+
+type hostFacts struct {
+    Hostname string
+    OS       string
+    Kernel   string
+    CPUCores int
+    MemBytes int64
+    IPs      string
+    Services string
+}
+
+func parseHostFacts(out string) hostFacts {
+    sections := map[string][]string{}
+    var cur string
+    for _, line := range strings.Split(out, "\n") {
+        line = strings.TrimSpace(line)
+        if strings.HasPrefix(line, "==") && strings.HasSuffix(line, "==") && len(line) > 4 {
+            cur = strings.Trim(line, "=")
+            continue
+        }
+        if line != "" && cur != "" {
+            sections[cur] = append(sections[cur], line)
+        }
+    }
+    first := func(k string) string {
+        if v := sections[k]; len(v) > 0 {
+            return v[0]
+        }
+        return ""
+    }
+    f := hostFacts{Hostname: first("hostname"), OS: first("os"), Kernel: first("kernel")}
+    f.CPUCores, _ = strconv.Atoi(first("cpu"))
+    if kb, err := strconv.ParseInt(first("mem_kb"), 10, 64); err == nil {
+        f.MemBytes = kb * 1024
+    }
+    // hostname -I 一行里空格分隔；跳过回环和 link-local
+    var ips []string
+    for _, l := range sections["ips"] {
+        for _, s := range strings.Fields(l) {
+            if a, err := netip.ParseAddr(s); err == nil && !a.IsLoopback() && !a.IsLinkLocalUnicast() {
+                ips = append(ips, a.String())
+            }
+        }
+    }
+    sort.Strings(ips)
+    f.IPs = strings.Join(ips, ",")
+    var svcs []string
+    for _, s := range sections["services"] {
+        svcs = append(svcs, strings.TrimSuffix(s, ".service"))
+    }
+    sort.Strings(svcs)
+    f.Services = strings.Join(svcs, ",")
+    return f
+}
+
+func initHosts(db *sql.DB) error {
+    _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS hosts(
+    address TEXT PRIMARY KEY,
+    hostname TEXT NOT NULL DEFAULT '',
+    os TEXT NOT NULL DEFAULT '',
+    kernel TEXT NOT NULL DEFAULT '',
+    cpu_cores INTEGER NOT NULL DEFAULT 0,
+    mem_bytes INTEGER NOT NULL DEFAULT 0,
+    ips TEXT NOT NULL DEFAULT '',
+    services TEXT NOT NULL DEFAULT '',
+    last_seen TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TEXT,
+    updated_at TEXT
+);`)
+    return err
+}
+
+type hostDiscoverer struct {
+    db      *sql.DB
+    cfg     HostDiscoveryConfig
+    targets []discoveryTarget
+    ssh     *ssh.ClientConfig
+}
+
+func newHostDiscoverer(db *sql.DB, cfg HostDiscoveryConfig) (*hostDiscoverer, error) {
+    targets, err := expandTargets(cfg.Targets, "22")
+    if err != nil {
+        return nil, err
+    }
+    key, err := os.ReadFile(cfg.PrivateKeyFile)
+    if err != nil {
+        return nil, err
+    }
+    signer, err := ssh.ParsePrivateKey(key)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", cfg.PrivateKeyFile, err)
+    }
+    hostKey := ssh.InsecureIgnoreHostKey()
+    if cfg.KnownHostsFile != "" {
+        if hostKey, err = knownhosts.New(cfg.KnownHostsFile); err != nil {
+            return nil, err
+        }
+    }
+    return &hostDiscoverer{db: db, cfg: cfg, targets: targets, ssh: &ssh.ClientConfig{
+        User:            cfg.User,
+        Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+        HostKeyCallback: hostKey,
+        Timeout:         cfg.Timeout.Duration,
+    }}, nil
+}
+
+// 连接的 deadline 覆盖握手和脚本执行，卡住的主机不会占着 worker
+func (d *hostDiscoverer) collect(t discoveryTarget) (hostFacts, error) {
+    conn, err := net.DialTimeout("tcp", t.dial, d.cfg.Timeout.Duration)
+    if err != nil {
+        return hostFacts{}, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(d.cfg.Timeout.Duration))
+    c, chans, reqs, err := ssh.NewClientConn(conn, t.dial, d.ssh)
+    if err != nil {
+        return hostFacts{}, err
+    }
+    client := ssh.NewClient(c, chans, reqs)
+    defer client.Close()
+    sess, err := client.NewSession()
+    if err != nil {
+        return hostFacts{}, err
+    }
+    defer sess.Close()
+    out, err := sess.Output(hostFactsScript)
+    if err != nil {
+        return hostFacts{}, fmt.Errorf("run script: %w", err)
+    }
+    return parseHostFacts(string(out)), nil
+}
+
+func (d *hostDiscoverer) store(address string, f hostFacts, scanErr error) error {
+    now := time.Now().UTC().Format(time.RFC3339)
+    return withChangeSource(d.db, "ssh-discovery", func(tx *sql.Tx) error {
+        if scanErr != nil {
+            // 保留上次成功时的信息
+            _, err := tx.Exec(`INSERT INTO hosts(address,error,created_at,updated_at) VALUES(?,?,?,?)
+ON CONFLICT(address) DO UPDATE SET error=excluded.error`, address, scanErr.Error(), now, now)
+            return err
+        }
+        _, err := tx.Exec(`
+INSERT INTO hosts(address,hostname,os,kernel,cpu_cores,mem_bytes,ips,services,last_seen,error,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,'',?,?)
+ON CONFLICT(address) DO UPDATE SET
+ hostname=excluded.hostname,
+ os=excluded.os,
+ kernel=excluded.kernel,
+ cpu_cores=excluded.cpu_cores,
+ mem_bytes=excluded.mem_bytes,
+ ips=excluded.ips,
+ services=excluded.services,
+ last_seen=excluded.last_seen,
+ error='',
+ updated_at=excluded.updated_at
+`, address, f.Hostname, f.OS, f.Kernel, f.CPUCores, f.MemBytes, f.IPs, f.Services, now, now, now)
+        return err
+    })
+}
+
+func (d *hostDiscoverer) runOnce() (found, failed int) {
+    jobs := make(chan discoveryTarget)
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    for i := 0; i < d.cfg.Concurrency; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for t := range jobs {
+                f, err := d.collect(t)
+                var dialErr *net.OpError
+                if err != nil && t.fromCIDR && errors.As(err, &dialErr) && dialErr.Op == "dial" {
+                    continue
+                }
+                if err != nil {
+                    log.Printf("[hosts] %s: %v", t.Address, err)
+                }
+                if werr := d.store(t.Address, f, err); werr != nil {
+                    log.Printf("[hosts] store %s: %v", t.Address, werr)
+                }
+                mu.Lock()
+                if err != nil {
+                    failed++
+                } else {
+                    found++
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+    for _, t := range d.targets {
+        jobs <- t
+    }
+    close(jobs)
+    wg.Wait()
+    return found, failed
+}
+
+// 给 periodicCollector 用；一台都没连上时算失败
+func (d *hostDiscoverer) collectOnce(context.Context) error {
+    start := time.Now()
+    found, failed := d.runOnce()
+    log.Printf("[hosts] discovered %d hosts, %d failed, in %s", found, failed, time.Since(start).Round(time.Second))
+    if found == 0 && failed > 0 {
+        return fmt.Errorf("all %d hosts failed", failed)
+    }
+    return nil
+}
+
+type HostRow struct {
+    Address     string `json:"address"`
+    Hostname    string `json:"hostname"`
+    OS          string `json:"os"`
+    Kernel      string `json:"kernel"`
+    CPUCores    int    `json:"cpuCores"`
+    MemoryBytes int64  `json:"memoryBytes"`
+    // 逗号分隔
+    IPs      string `json:"ips"`
+    Services string `json:"services"`
+    // 最近一次成功采集的时间；error 非空表示最近一次失败
+    LastSeen   string `json:"lastSeen"`
+    Error      string `json:"error"`
+    Attributes string `json:"attributes"`
+    UpdatedAt  string `json:"updatedAt"`
+}
+
+var hostSelect = `SELECT address,hostname,os,kernel,cpu_cores,mem_bytes,ips,services,last_seen,error,` + attributesColumn("hosts") + `,
+ coalesce(updated_at,'') FROM hosts`
+
+func scanHostRow(rows *sql.Rows) (HostRow, error) {
+    var h HostRow
+    err := rows.Scan(&h.Address, &h.Hostname, &h.OS, &h.Kernel, &h.CPUCores, &h.MemoryBytes, &h.IPs, &h.Services,
+        &h.LastSeen, &h.Error, &h.Attributes, &h.UpdatedAt)
+    return h, err
+}
+
+// GET /cmdb/hosts?service=nginx&os=ubuntu；os 按子串匹配。主机不属于任何 namespace，限定 namespace 的 key 看不到
+func hostsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        conds := []string{"1"}
+        var args []any
+        if v := q.Get("service"); v != "" {
+            conds = append(conds, `(','||services||',') LIKE ?`)
+            args = append(args, "%,"+strings.TrimSuffix(v, ".service")+",%")
+        }
+        if v := q.Get("os"); v != "" {
+            conds = append(conds, `os LIKE ?`)
+            args = append(args, "%"+v+"%")
+        }
+        where, args := scopeOf(r.Context()).where("''", strings.Join(conds, " AND "), args...)
+        rows, err := db.QueryContext(r.Context(), hostSelect+where+` ORDER BY address`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "hosts", HostRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            h, err := scanHostRow(rows)
+            if err != nil {
+                lw.Fail(err)
+                return
+            }
+            if err := lw.Write(h); err != nil {
+                log.Printf("[http] write hosts: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
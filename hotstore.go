@@ -0,0 +1,251 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "slices"
+    "strings"
+    "sync"
+)
+
+// ---------- Hot read model ----------
+
+// 仪表盘反复刷 /cmdb/pods、/cmdb/nodes，每次都要排队等 SQLite 唯一的连接，和 informer 写库互相拖慢。
+// 开启 storage.hotReadModel 后在内存里保留这两张表的最新一份，列表直接从内存出；SQLite 仍是持久层，history 等其它接口不变。
+// 内存里的行都是写库后按主键读回来的，和 DB 完全一致；purge / 对账这类批量写库之后整体重载。
+// 启动时第一次重载完成之前仍然查 SQLite。
+type hotReadModel struct {
+    db *sql.DB
+    // 串行化所有写入（读 DB + 更新 map），避免较新的单行被旧的整体重载覆盖
+    load sync.Mutex
+
+    mu    sync.RWMutex
+    ready bool
+    pods  map[string]PodRow
+    nodes map[string]NodeRow
+    // 排好序的快照，只整体替换不原地修改；nil 表示需要重建
+    podList  []PodRow
+    nodeList []NodeRow
+    // 每次内存内容变化加一，给 ETag 用
+    podGen, nodeGen uint64
+}
+
+var (
+    podRowColumns = `uid,name,namespace,phase,node_name,cmdb_unseal(pod_ip),coalesce(labels,''),coalesce(cpu_request,0),coalesce(mem_request,0),coalesce(extended_requests,''),
+ coalesce((SELECT cpu_milli FROM pod_usage u WHERE u.uid=pods.uid),0),coalesce((SELECT mem_bytes FROM pod_usage u WHERE u.uid=pods.uid),0),
+ coalesce((SELECT sampled_at FROM pod_usage u WHERE u.uid=pods.uid),''),` + attributesColumn("pods") + `,coalesce(team,''),updated_at`
+    nodeRowColumns = `name,labels,capacity_cpu,capacity_mem,coalesce(capacity_extended,''),coalesce(allocatable_extended,''),cmdb_unseal(internal_ip),coalesce(capabilities,''),coalesce(devices,''),
+ coalesce(sriov_count,0),coalesce(gpu_count,0),coalesce(tpu_count,0),coalesce(fpga_count,0),coalesce(unschedulable,'')='true',coalesce(zone,''),coalesce(region,''),
+ coalesce(architecture,''),coalesce(os,''),coalesce(container_runtime,''),
+ coalesce((SELECT cpu_milli FROM node_usage u WHERE u.name=nodes.name),0),coalesce((SELECT mem_bytes FROM node_usage u WHERE u.name=nodes.name),0),
+ coalesce((SELECT sampled_at FROM node_usage u WHERE u.name=nodes.name),''),` + attributesColumn("nodes") + `,updated_at`
+)
+
+func scanPodRow(rows *sql.Rows) (PodRow, error) {
+    var p PodRow
+    err := rows.Scan(&p.UID, &p.Name, &p.Namespace, &p.Phase, &p.NodeName, &p.PodIP, &p.Labels, &p.CPURequest, &p.MemoryRequest, &p.ExtendedRequests,
+        &p.CPUUsage, &p.MemoryUsage, &p.UsageSampledAt, &p.Attributes, &p.Team, &p.UpdatedAt)
+    return p, err
+}
+
+func scanNodeRow(rows *sql.Rows) (NodeRow, error) {
+    var n NodeRow
+    err := rows.Scan(&n.Name, &n.Labels, &n.CPU, &n.Memory, &n.CapacityExtended, &n.AllocatableExtended, &n.InternalIP, &n.Capabilities, &n.Devices,
+        &n.SRIOVCount, &n.GPUCount, &n.TPUCount, &n.FPGACount, &n.Unschedulable, &n.Zone, &n.Region, &n.Architecture, &n.OS, &n.ContainerRuntime, &n.CPUUsageMilli, &n.MemoryUsageBytes, &n.UsageSampledAt, &n.Attributes, &n.UpdatedAt)
+    if err == nil && n.UsageSampledAt != "" {
+        n.CPUUtilizationPercent = utilizationPercent(n.CPUUsageMilli, n.CPU, true)
+        n.MemoryUtilizationPercent = utilizationPercent(n.MemoryUsageBytes, n.Memory, false)
+    }
+    return n, err
+}
+
+func newHotReadModel(db *sql.DB) *hotReadModel {
+    return &hotReadModel{db: db}
+}
+
+func loadHotRows[T any](db *sql.DB, query string, scan func(*sql.Rows) (T, error), key func(T) string, args ...any) (map[string]T, error) {
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string]T{}
+    for rows.Next() {
+        v, err := scan(rows)
+        if err != nil {
+            return nil, err
+        }
+        out[key(v)] = v
+    }
+    return out, rows.Err()
+}
+
+func podKey(p PodRow) string   { return p.UID }
+func nodeKey(n NodeRow) string { return n.Name }
+
+// 从 SQLite 整体重载；nil 时什么都不做，调用方不用判断是否开启
+func (h *hotReadModel) reload() error {
+    if h == nil {
+        return nil
+    }
+    h.load.Lock()
+    defer h.load.Unlock()
+    pods, err := loadHotRows(h.db, `SELECT `+podRowColumns+` FROM pods`, scanPodRow, podKey)
+    if err != nil {
+        return err
+    }
+    nodes, err := loadHotRows(h.db, `SELECT `+nodeRowColumns+` FROM nodes`, scanNodeRow, nodeKey)
+    if err != nil {
+        return err
+    }
+    h.mu.Lock()
+    h.pods, h.nodes, h.podList, h.nodeList, h.ready = pods, nodes, nil, nil, true
+    h.podGen++
+    h.nodeGen++
+    h.mu.Unlock()
+    return nil
+}
+
+// 批量写库之后调用，失败只记日志：下一次重载或单行事件会再纠正
+func (h *hotReadModel) reloadAfter(what string) {
+    if err := h.reload(); err != nil {
+        log.Printf("[hot] reload after %s: %v", what, err)
+    }
+}
+
+// informer 写库后调用：按主键重读一行，行已不存在（删除）时从内存移除
+func (h *hotReadModel) refreshPod(uid string) {
+    if h == nil {
+        return
+    }
+    h.load.Lock()
+    defer h.load.Unlock()
+    rows, err := loadHotRows(h.db, `SELECT `+podRowColumns+` FROM pods WHERE uid=?`, scanPodRow, podKey, uid)
+    if err != nil {
+        log.Printf("[hot] pod %s: %v", uid, err)
+        return
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if !h.ready {
+        return
+    }
+    if p, ok := rows[uid]; ok {
+        h.pods[uid] = p
+    } else {
+        delete(h.pods, uid)
+    }
+    h.podList = nil
+    h.podGen++
+}
+
+func (h *hotReadModel) refreshNode(name string) {
+    if h == nil {
+        return
+    }
+    h.load.Lock()
+    defer h.load.Unlock()
+    rows, err := loadHotRows(h.db, `SELECT `+nodeRowColumns+` FROM nodes WHERE name=?`, scanNodeRow, nodeKey, name)
+    if err != nil {
+        log.Printf("[hot] node %s: %v", name, err)
+        return
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if !h.ready {
+        return
+    }
+    if n, ok := rows[name]; ok {
+        h.nodes[name] = n
+    } else {
+        delete(h.nodes, name)
+    }
+    h.nodeList = nil
+    h.nodeGen++
+}
+
+// 写库的 worker 按表名调用，其它表不在内存里
+func (h *hotReadModel) refresh(table, key string) {
+    switch table {
+    case "pods":
+        h.refreshPod(key)
+    case "nodes":
+        h.refreshNode(key)
+    case "namespaces":
+        // 团队变了会改写整个 namespace 的 Pod 的 team；namespace 很少变，直接整体重载
+        if h != nil {
+            h.reloadAfter("namespace " + key)
+        }
+    }
+}
+
+// pods / nodes 由内存提供时返回内存的代数；未开启、未加载完成或其它资源返回 false
+func (h *hotReadModel) generation(resource string) (uint64, bool) {
+    if h == nil {
+        return 0, false
+    }
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    if !h.ready {
+        return 0, false
+    }
+    switch resource {
+    case "pods":
+        return h.podGen, true
+    case "nodes":
+        return h.nodeGen, true
+    }
+    return 0, false
+}
+
+// 和 SQL 的 ORDER BY namespace,name 一致（SQLite 默认按字节比较）
+func (h *hotReadModel) podSnapshot() ([]PodRow, bool) {
+    if h == nil {
+        return nil, false
+    }
+    h.mu.RLock()
+    list, ready := h.podList, h.ready
+    h.mu.RUnlock()
+    if !ready || list != nil {
+        return list, ready
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if h.podList == nil {
+        list = make([]PodRow, 0, len(h.pods))
+        for _, p := range h.pods {
+            list = append(list, p)
+        }
+        slices.SortFunc(list, func(a, b PodRow) int {
+            if c := strings.Compare(a.Namespace, b.Namespace); c != 0 {
+                return c
+            }
+            return strings.Compare(a.Name, b.Name)
+        })
+        h.podList = list
+    }
+    return h.podList, true
+}
+
+func (h *hotReadModel) nodeSnapshot() ([]NodeRow, bool) {
+    if h == nil {
+        return nil, false
+    }
+    h.mu.RLock()
+    list, ready := h.nodeList, h.ready
+    h.mu.RUnlock()
+    if !ready || list != nil {
+        return list, ready
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if h.nodeList == nil {
+        list = make([]NodeRow, 0, len(h.nodes))
+        for _, n := range h.nodes {
+            list = append(list, n)
+        }
+        slices.SortFunc(list, func(a, b NodeRow) int { return strings.Compare(a.Name, b.Name) })
+        h.nodeList = list
+    }
+    return h.nodeList, true
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "slices"
+    "strings"
+)
+
+// ---------- Image compliance ----------
+
+// 按镜像清单检查每个 Pod 的每个镜像：
+//   - registry：引用不以 images.allowedRegistries 中任何一个前缀开头（没有配置时不检查）
+//   - latest：tag 是 latest（包括没写 tag 的简写）
+//   - pullPolicy：latest 却不是 Always，各节点上跑的可能是不同时间拉下来的版本
+//   - pullPolicyUnknown：latest，但库里没有 pullPolicy（记录这列之前写入、之后没再更新的 Pod），不能当成合规
+//
+// 前缀和 /cmdb/images 的 ref 一样按补全后的形式比较，nginx 是 docker.io/library/nginx:latest。
+const (
+    violationRegistry    = "registry"
+    violationLatest      = "latest"
+    violationPullPolicy  = "pullPolicy"
+    violationPullUnknown = "pullPolicyUnknown"
+)
+
+var imageViolations = []string{violationRegistry, violationLatest, violationPullPolicy, violationPullUnknown}
+
+type ImageComplianceRow struct {
+    Namespace  string `json:"namespace"`
+    Pod        string `json:"pod"`
+    Node       string `json:"node"`
+    Ref        string `json:"ref"`
+    Registry   string `json:"registry"`
+    Repository string `json:"repository"`
+    Tag        string `json:"tag"`
+    PullPolicy string `json:"pullPolicy"`
+    // 逗号分隔：registry / latest / pullPolicy
+    Violations string `json:"violations"`
+}
+
+func imageViolationsOf(row ImageComplianceRow, allowed []string) []string {
+    var out []string
+    if len(allowed) > 0 && !hasAnyPrefix(row.Ref, allowed) {
+        out = append(out, violationRegistry)
+    }
+    if row.Tag == "latest" {
+        out = append(out, violationLatest)
+        switch row.PullPolicy {
+        case "Always":
+        case "":
+            out = append(out, violationPullUnknown)
+        default:
+            out = append(out, violationPullPolicy)
+        }
+    }
+    return out
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+    for _, p := range prefixes {
+        if strings.HasPrefix(s, p) {
+            return true
+        }
+    }
+    return false
+}
+
+// GET /cmdb/images/compliance?ns=&violation=registry|latest|pullPolicy
+// 每个不合规的 (Pod, 镜像) 一行；限定 namespace 的 key 只看到可见的 Pod
+func imageComplianceAPI(db *sql.DB, cfg ImagesConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        violation := q.Get("violation")
+        if violation != "" && !slices.Contains(imageViolations, violation) {
+            http.Error(w, "violation must be registry, latest, pullPolicy or pullPolicyUnknown", 400)
+            return
+        }
+        cond, args := "1", []any{}
+        if ns := q.Get("ns"); ns != "" {
+            cond, args = "p.namespace=?", append(args, ns)
+        }
+        where, args := scopeOf(r.Context()).where("p.namespace", cond, args...)
+        rows, err := db.QueryContext(r.Context(), `SELECT p.namespace,p.name,coalesce(p.node_name,''),i.ref,i.registry,i.repository,i.tag,
+ coalesce(pi.pull_policy,'') FROM pod_images pi JOIN images i ON i.ref=pi.ref JOIN pods p ON p.uid=pi.uid`+where+`
+ ORDER BY p.namespace,p.name,i.ref`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        var out []ImageComplianceRow
+        for rows.Next() {
+            var row ImageComplianceRow
+            if err := rows.Scan(&row.Namespace, &row.Pod, &row.Node, &row.Ref, &row.Registry, &row.Repository, &row.Tag, &row.PullPolicy); err != nil {
+                rows.Close()
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            v := imageViolationsOf(row, cfg.AllowedRegistries)
+            if len(v) == 0 || violation != "" && !slices.Contains(v, violation) {
+                continue
+            }
+            row.Violations = strings.Join(v, ",")
+            out = append(out, row)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "image-compliance", ImageComplianceRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write image compliance: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
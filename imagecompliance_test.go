@@ -0,0 +1,64 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http/httptest"
+    "testing"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 和 informer 写库的路径一样先过 cacheTransform，pullPolicy 不能在这一步丢掉
+func TestImageCompliancePullPolicyAfterTransform(t *testing.T) {
+    db := newTestDB(t)
+    pod := &corev1.Pod{
+        ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-1", UID: "uid-web-1"},
+        Spec: corev1.PodSpec{Containers: []corev1.Container{
+            {Name: "web", Image: "nginx", ImagePullPolicy: corev1.PullIfNotPresent},
+            {Name: "sidecar", Image: "registry.internal/proxy:latest", ImagePullPolicy: corev1.PullAlways},
+        }},
+    }
+    obj, err := cacheTransform(false)(pod)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := upsertPod(db, obj.(*corev1.Pod)); err != nil {
+        t.Fatal(err)
+    }
+    // 记录 pull_policy 之前写入的行
+    if _, err := db.Exec(`INSERT INTO pods(uid,name,namespace,phase) VALUES('uid-old','old-1','shop','Running')`); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := db.Exec(`INSERT INTO pod_images(uid,ref) SELECT 'uid-old',ref FROM pod_images WHERE uid='uid-web-1'`); err != nil {
+        t.Fatal(err)
+    }
+
+    w := httptest.NewRecorder()
+    imageComplianceAPI(db, ImagesConfig{})(w, httptest.NewRequest("GET", "/cmdb/images/compliance", nil))
+    if w.Code != 200 {
+        t.Fatalf("status %d: %s", w.Code, w.Body.String())
+    }
+    var rows []ImageComplianceRow
+    if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+        t.Fatal(err)
+    }
+    got := map[string]string{}
+    for _, r := range rows {
+        got[r.Pod+" "+r.Ref] = r.Violations
+    }
+    want := map[string]string{
+        "web-1 docker.io/library/nginx:latest": "latest,pullPolicy",
+        "web-1 registry.internal/proxy:latest": "latest",
+        "old-1 docker.io/library/nginx:latest": "latest,pullPolicyUnknown",
+        "old-1 registry.internal/proxy:latest": "latest,pullPolicyUnknown",
+    }
+    if len(got) != len(want) {
+        t.Fatalf("got %v, want %v", got, want)
+    }
+    for k, v := range want {
+        if got[k] != v {
+            t.Errorf("%s: violations %q, want %q", k, got[k], v)
+        }
+    }
+}
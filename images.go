@@ -0,0 +1,283 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Image inventory ----------
+
+// 每个 Pod 写入时按容器记下实际运行的镜像（pod_images），images 表是去重后的镜像引用，拆成 registry/repository/tag/digest，
+// 用来回答"某个镜像还在哪里跑"。digest 优先取 spec 里写死的，否则取 containerStatuses 的 imageID（kubelet 拉取后才有），
+// 所以同一个 nginx:1.25 在不同节点解析到不同 digest 时是两条。Pod 删除时由触发器清理，没有 Pod 再用的镜像一并删掉。
+type imageRef struct {
+    Registry   string
+    Repository string
+    Tag        string
+    Digest     string
+    // 容器的 imagePullPolicy，不属于引用本身，String() 不含；记在 pod_images 上
+    PullPolicy string
+}
+
+// docker 的简写规则：第一段不含 . 或 : 且不是 localhost 时属于 docker.io，单段名字在 library/ 下；没写 tag 和 digest 时为 latest
+func parseImageRef(s string) imageRef {
+    var r imageRef
+    if i := strings.Index(s, "@"); i >= 0 {
+        s, r.Digest = s[:i], s[i+1:]
+    }
+    if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+        s, r.Tag = s[:i], s[i+1:]
+    }
+    if first, rest, ok := strings.Cut(s, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+        r.Registry, r.Repository = first, rest
+    } else {
+        r.Registry, r.Repository = "docker.io", s
+    }
+    if r.Registry == "docker.io" && !strings.Contains(r.Repository, "/") {
+        r.Repository = "library/" + r.Repository
+    }
+    if r.Tag == "" && r.Digest == "" {
+        r.Tag = "latest"
+    }
+    return r
+}
+
+func (r imageRef) String() string {
+    s := r.Registry + "/" + r.Repository
+    if r.Tag != "" {
+        s += ":" + r.Tag
+    }
+    if r.Digest != "" {
+        s += "@" + r.Digest
+    }
+    return s
+}
+
+// imageID 形如 docker-pullable://nginx@sha256:...、docker.io/library/nginx@sha256:...；
+// 只有 sha256:... 时是本地镜像 ID 而不是 registry 里的 digest，不用
+func imageIDDigest(id string) string {
+    if _, d, ok := strings.Cut(id, "@"); ok {
+        return d
+    }
+    return ""
+}
+
+func podImageRefs(p *corev1.Pod) []imageRef {
+    digests := map[string]string{}
+    for _, st := range append(append([]corev1.ContainerStatus{}, p.Status.InitContainerStatuses...), p.Status.ContainerStatuses...) {
+        digests[st.Name] = imageIDDigest(st.ImageID)
+    }
+    var out []imageRef
+    seen := map[imageRef]bool{}
+    for _, c := range append(append([]corev1.Container{}, p.Spec.InitContainers...), p.Spec.Containers...) {
+        if c.Image == "" {
+            continue
+        }
+        r := parseImageRef(c.Image)
+        r.PullPolicy = string(c.ImagePullPolicy)
+        if r.Digest == "" {
+            r.Digest = digests[c.Name]
+        }
+        if !seen[r] {
+            seen[r] = true
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+func initImages(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS images(
+    ref TEXT PRIMARY KEY,
+    registry TEXT NOT NULL,
+    repository TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    digest TEXT NOT NULL,
+    first_seen TEXT NOT NULL
+);`, `
+CREATE TABLE IF NOT EXISTS pod_images(
+    uid TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    PRIMARY KEY(uid, ref)
+);`,
+        `CREATE INDEX IF NOT EXISTS idx_pod_images_ref ON pod_images(ref)`,
+        `CREATE INDEX IF NOT EXISTS idx_images_repository ON images(repository)`,
+        `DROP TRIGGER IF EXISTS pods_images_ad`,
+        `CREATE TRIGGER pods_images_ad AFTER DELETE ON pods BEGIN
+ DELETE FROM pod_images WHERE uid=old.uid;
+ DELETE FROM images WHERE ref IN (SELECT ref FROM images EXCEPT SELECT ref FROM pod_images); END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return addColumnIfMissing(db, "pod_images", "pull_policy", "TEXT")
+}
+
+// 和 replaceReferences 一样：传入 *sql.DB 时自己开事务
+func replacePodImages(q querier, uid string, refs []imageRef) error {
+    db, ok := q.(*sql.DB)
+    if !ok {
+        return writePodImages(q, uid, refs)
+    }
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if err := writePodImages(tx, uid, refs); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func writePodImages(q querier, uid string, refs []imageRef) error {
+    if _, err := q.Exec(`DELETE FROM pod_images WHERE uid=?`, uid); err != nil {
+        return err
+    }
+    now := time.Now().Format(time.RFC3339)
+    for _, r := range refs {
+        ref := r.String()
+        if _, err := q.Exec(`INSERT OR IGNORE INTO images(ref,registry,repository,tag,digest,first_seen) VALUES(?,?,?,?,?,?)`,
+            ref, r.Registry, r.Repository, r.Tag, r.Digest, now); err != nil {
+            return err
+        }
+        if _, err := q.Exec(`INSERT OR IGNORE INTO pod_images(uid,ref,pull_policy) VALUES(?,?,?)`, uid, ref, r.PullPolicy); err != nil {
+            return err
+        }
+    }
+    // 升级镜像后旧的引用没人用了
+    _, err := q.Exec(`DELETE FROM images WHERE ref IN (SELECT ref FROM images EXCEPT SELECT ref FROM pod_images)`)
+    return err
+}
+
+type ImageRow struct {
+    Ref        string `json:"ref"`
+    Registry   string `json:"registry"`
+    Repository string `json:"repository"`
+    Tag        string `json:"tag"`
+    // 还没拉取完（Pending）且 spec 里没写 digest 时为空
+    Digest   string `json:"digest"`
+    PodCount int    `json:"podCount"`
+    // 逗号分隔，按名字排序
+    Namespaces string `json:"namespaces"`
+    Nodes      string `json:"nodes"`
+    Pods       string `json:"pods"` // <ns>/<name>
+    // 运行这些 Pod 的节点的 os/arch，即确实拉到过的平台，见 architectures.go
+    Platforms string `json:"platforms"`
+    FirstSeen string `json:"firstSeen"`
+    // trivy 扫描出的 CVE 数；scannedAt 为空表示还没扫描（或没开 scanner）
+    VulnCritical int    `json:"vulnCritical"`
+    VulnHigh     int    `json:"vulnHigh"`
+    VulnMedium   int    `json:"vulnMedium"`
+    VulnLow      int    `json:"vulnLow"`
+    VulnUnknown  int    `json:"vulnUnknown"`
+    ScannedAt    string `json:"scannedAt"`
+    ScanError    string `json:"scanError"`
+}
+
+func joinSorted(s string) string {
+    if s == "" {
+        return ""
+    }
+    parts := strings.Split(s, ",")
+    sort.Strings(parts)
+    return strings.Join(parts, ",")
+}
+
+// severity=high：至少有一个该级别或更严重的 CVE
+func severityCond(sev string) (string, bool) {
+    var cols []string
+    for _, s := range vulnSeverities[:len(vulnSeverities)-1] {
+        cols = append(cols, "coalesce(v."+s+",0)")
+        if s == sev {
+            return strings.Join(cols, "+") + ">0", true
+        }
+    }
+    return "", false
+}
+
+// GET /cmdb/images?repository=log4j&registry=&tag=&digest=&ns=&severity=
+// repository 按子串匹配，其余精确匹配；限定 namespace 的 key 只看到可见 Pod 用的镜像和这些 Pod
+func imagesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        conds := []string{"1"}
+        var args []any
+        if v := q.Get("repository"); v != "" {
+            conds = append(conds, "i.repository LIKE ?")
+            args = append(args, "%"+v+"%")
+        }
+        for _, f := range []string{"registry", "tag", "digest"} {
+            if v := q.Get(f); v != "" {
+                conds = append(conds, "i."+f+"=?")
+                args = append(args, v)
+            }
+        }
+        if ns := q.Get("ns"); ns != "" {
+            conds = append(conds, "p.namespace=?")
+            args = append(args, ns)
+        }
+        if sev := strings.ToLower(q.Get("severity")); sev != "" {
+            c, ok := severityCond(sev)
+            if !ok {
+                http.Error(w, "severity must be critical, high, medium or low", 400)
+                return
+            }
+            conds = append(conds, c)
+        }
+        where, args := scopeOf(r.Context()).where("p.namespace", strings.Join(conds, " AND "), args...)
+        rows, err := db.QueryContext(r.Context(), `
+SELECT i.ref,i.registry,i.repository,i.tag,i.digest,count(*),
+ group_concat(DISTINCT p.namespace),coalesce(group_concat(DISTINCT nullif(p.node_name,'')),''),
+ group_concat(p.namespace||'/'||p.name),
+ coalesce(group_concat(DISTINCT CASE WHEN coalesce(n.architecture,'')<>'' THEN n.os||'/'||n.architecture END),''),i.first_seen,
+ coalesce(v.critical,0),coalesce(v.high,0),coalesce(v.medium,0),coalesce(v.low,0),coalesce(v.unknown,0),
+ coalesce(v.scanned_at,''),coalesce(v.error,'')
+FROM images i JOIN pod_images pi ON pi.ref=i.ref JOIN pods p ON p.uid=pi.uid
+ LEFT JOIN image_vulnerabilities v ON v.ref=i.ref LEFT JOIN nodes n ON n.name=p.node_name`+where+`
+GROUP BY i.ref ORDER BY i.registry,i.repository,i.tag,i.digest`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        var out []ImageRow
+        for rows.Next() {
+            var row ImageRow
+            if err := rows.Scan(&row.Ref, &row.Registry, &row.Repository, &row.Tag, &row.Digest, &row.PodCount,
+                &row.Namespaces, &row.Nodes, &row.Pods, &row.Platforms, &row.FirstSeen, &row.VulnCritical, &row.VulnHigh, &row.VulnMedium,
+                &row.VulnLow, &row.VulnUnknown, &row.ScannedAt, &row.ScanError); err != nil {
+                rows.Close()
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            row.Namespaces, row.Nodes, row.Pods, row.Platforms = joinSorted(row.Namespaces), joinSorted(row.Nodes), joinSorted(row.Pods), joinSorted(row.Platforms)
+            out = append(out, row)
+        }
+        rows.Close()
+        lw, err := newListWriter(w, r, "images", ImageRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write images: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+)
+
+// ---------- Asset import ----------
+
+// POST /cmdb/import 批量导入手工维护的 CI（写进 assets，按 type+name 新建或覆盖），CSV 或 JSON。
+// CSV 第一行是表头：type、name 必填，site、owner、labels（k=v,k=v）是固定列，其余列都作为 attributes；空单元格不写。
+// 整批在一个事务里：有一行不合法就整批拒绝，返回所有出错的行号。
+var assetColumns = []string{"type", "name", "site", "owner", "labels"}
+
+type ImportError struct {
+    // CSV 为文件里的行号（表头是第 1 行），JSON 为数组下标（从 0 开始）
+    Line  int    `json:"line"`
+    Error string `json:"error"`
+}
+
+type ImportResult struct {
+    DryRun    bool          `json:"dryRun"`
+    Created   int           `json:"created"`
+    Updated   int           `json:"updated"`
+    Unchanged int           `json:"unchanged"`
+    Errors    []ImportError `json:"errors,omitempty"`
+}
+
+func parseAssetCSV(r io.Reader) ([]Asset, []int, []ImportError, error) {
+    cr := csv.NewReader(r)
+    cr.FieldsPerRecord = -1
+    header, err := cr.Read()
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("read header: %w", err)
+    }
+    for i := range header {
+        header[i] = strings.TrimSpace(header[i])
+    }
+    if !slices.Contains(header, "type") || !slices.Contains(header, "name") {
+        return nil, nil, nil, errors.New("header must contain type and name")
+    }
+    var assets []Asset
+    var lines []int
+    var bad []ImportError
+    for line := 2; ; line++ {
+        rec, err := cr.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        if len(rec) != len(header) {
+            bad = append(bad, ImportError{Line: line, Error: fmt.Sprintf("%d fields, header has %d", len(rec), len(header))})
+            continue
+        }
+        a := Asset{Attributes: map[string]string{}}
+        for i, col := range header {
+            v := strings.TrimSpace(rec[i])
+            switch col {
+            case "type":
+                a.Type = v
+            case "name":
+                a.Name = v
+            case "site":
+                a.Site = v
+            case "owner":
+                a.Owner = v
+            case "labels":
+                a.Labels = parseLabels(v)
+            default:
+                if v != "" {
+                    a.Attributes[col] = v
+                }
+            }
+        }
+        assets = append(assets, a)
+        lines = append(lines, line)
+    }
+    return assets, lines, bad, nil
+}
+
+func validateAsset(a Asset) error {
+    if a.Type == "" || a.Name == "" {
+        return errors.New("type and name are required")
+    }
+    for k := range a.Attributes {
+        if k == "" || slices.Contains(assetColumns, k) {
+            return fmt.Errorf("invalid attribute name %q", k)
+        }
+    }
+    return nil
+}
+
+func encodeAttributes(m map[string]string) string {
+    if len(m) == 0 {
+        return ""
+    }
+    b, _ := json.Marshal(m)
+    return string(b)
+}
+
+func decodeAttributes(s string) map[string]string {
+    m := map[string]string{}
+    if s != "" {
+        json.Unmarshal([]byte(s), &m)
+    }
+    return m
+}
+
+// 在事务里逐个新建或覆盖，按和已有行比较的结果计数
+func importAssets(tx *sql.Tx, assets []Asset, res *ImportResult) error {
+    now := time.Now().Format(time.RFC3339)
+    for _, a := range assets {
+        labels, attrs := flattenLabels(a.Labels), encodeAttributes(a.Attributes)
+        var site, owner, oldLabels, oldAttrs string
+        err := tx.QueryRow(`SELECT site,owner,labels,attributes FROM assets WHERE type=? AND name=?`, a.Type, a.Name).
+            Scan(&site, &owner, &oldLabels, &oldAttrs)
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            res.Created++
+        case err != nil:
+            return err
+        case site == a.Site && owner == a.Owner && oldLabels == labels && oldAttrs == attrs:
+            res.Unchanged++
+            continue
+        default:
+            res.Updated++
+        }
+        if err := writeAsset(tx, a, now); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func importAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if !canWriteAssets(r) {
+            http.Error(w, "credentials not allowed to modify assets", 403)
+            return
+        }
+        var assets []Asset
+        var lines []int
+        var bad []ImportError
+        ct := r.Header.Get("Content-Type")
+        switch {
+        case strings.HasPrefix(ct, "text/csv") || r.URL.Query().Get("format") == "csv":
+            var err error
+            if assets, lines, bad, err = parseAssetCSV(r.Body); err != nil {
+                http.Error(w, "invalid CSV: "+err.Error(), 400)
+                return
+            }
+        default:
+            if err := json.NewDecoder(r.Body).Decode(&assets); err != nil {
+                http.Error(w, "invalid JSON (expected an array of assets): "+err.Error(), 400)
+                return
+            }
+            for i := range assets {
+                lines = append(lines, i)
+            }
+        }
+        for i, a := range assets {
+            if err := validateAsset(a); err != nil {
+                bad = append(bad, ImportError{Line: lines[i], Error: err.Error()})
+            }
+        }
+        // 同一批里重复的 type+name 多半是表格写错了，不按后者覆盖
+        seen := map[[2]string]int{}
+        for i, a := range assets {
+            k := [2]string{a.Type, a.Name}
+            if first, ok := seen[k]; ok {
+                bad = append(bad, ImportError{Line: lines[i], Error: fmt.Sprintf("duplicate of line %d", lines[first])})
+                continue
+            }
+            seen[k] = i
+        }
+        res := &ImportResult{DryRun: r.URL.Query().Get("dryRun") == "true"}
+        if len(bad) > 0 {
+            slices.SortStableFunc(bad, func(a, b ImportError) int { return a.Line - b.Line })
+            res.Errors = bad
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(400)
+            json.NewEncoder(w).Encode(res)
+            return
+        }
+        if res.DryRun {
+            tx, err := db.Begin()
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            err = importAssets(tx, assets, res)
+            tx.Rollback()
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            writeJSON(w, r, res)
+            return
+        }
+        err := withChangeSource(db, changeSourceFor(r, "import"), func(tx *sql.Tx) error {
+            return importAssets(tx, assets, res)
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        log.Printf("[assets] import by %s: created=%d updated=%d unchanged=%d", changeSourceFor(r, "import"), res.Created, res.Updated, res.Unchanged)
+        writeJSON(w, r, res)
+    }
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    appsv1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    "k8s.io/apimachinery/pkg/api/meta"
+    "k8s.io/client-go/rest"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Informer cache trimming ----------
+
+// 12k Pod 的集群上完整对象的 informer 缓存要 2GB 左右，大头是 managedFields、
+// kubectl 的 last-applied-configuration 和容器里的 command/args/probe/volumeMounts 等。
+// 内置资源从 API server 取 protobuf（比 JSON 解码快、临时分配少）；对象进缓存前用 transform 去掉：
+//   - 所有 kind：managedFields、last-applied-configuration
+//   - Pod、Deployment 模板：容器只留 name、image、imagePullPolicy、resources、引用 Secret/ConfigMap 的 env/envFrom，
+//     volumes 只留 Secret/ConfigMap/PVC/projected/CSI secret 这些引用（见 references.go）
+//   - ReplicaSet：整个 Pod 模板（只用 owner）
+//   - Node：status.images
+// 写库、引用、镜像和 requests 用到的字段都保留，所以对账从 API server 直接 list 的完整对象算出的行和这里一致。
+// /cmdb/live 返回的是缓存里的对象，需要完整 spec 时设 liveProxy.fullObjects: true，只去掉 managedFields。
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// 只给 kubernetes clientset 用；dynamic client 会自己改回 JSON（CRD 不支持 protobuf）
+func protobufConfig(cfg *rest.Config) *rest.Config {
+    c := rest.CopyConfig(cfg)
+    c.ContentType = "application/vnd.kubernetes.protobuf"
+    c.AcceptContentTypes = "application/vnd.kubernetes.protobuf,application/json"
+    return c
+}
+
+// 返回给 informers.WithTransform / SetTransform 的函数；对同一对象重复调用结果不变
+func cacheTransform(fullObjects bool) cache.TransformFunc {
+    return func(obj interface{}) (interface{}, error) {
+        if m, err := meta.Accessor(obj); err == nil {
+            m.SetManagedFields(nil)
+            if a := m.GetAnnotations(); !fullObjects && a[lastAppliedAnnotation] != "" {
+                delete(a, lastAppliedAnnotation)
+                m.SetAnnotations(a)
+            }
+        }
+        if fullObjects {
+            return obj, nil
+        }
+        switch o := obj.(type) {
+        case *corev1.Pod:
+            trimPodSpec(&o.Spec)
+        case *appsv1.Deployment:
+            trimPodSpec(&o.Spec.Template.Spec)
+        case *appsv1.ReplicaSet:
+            o.Spec.Template = corev1.PodTemplateSpec{}
+        case *corev1.Node:
+            o.Status.Images = nil
+        }
+        return obj, nil
+    }
+}
+
+func trimPodSpec(spec *corev1.PodSpec) {
+    for i := range spec.InitContainers {
+        spec.InitContainers[i] = trimContainer(spec.InitContainers[i])
+    }
+    for i := range spec.Containers {
+        spec.Containers[i] = trimContainer(spec.Containers[i])
+    }
+    for i, ec := range spec.EphemeralContainers {
+        spec.EphemeralContainers[i] = corev1.EphemeralContainer{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+            Name: ec.Name, Image: ec.Image, Env: refEnv(ec.Env), EnvFrom: ec.EnvFrom}}
+    }
+    volumes := spec.Volumes[:0]
+    for _, v := range spec.Volumes {
+        if v.Secret != nil || v.ConfigMap != nil || v.PersistentVolumeClaim != nil || v.Projected != nil ||
+            v.CSI != nil && v.CSI.NodePublishSecretRef != nil {
+            volumes = append(volumes, v)
+        }
+    }
+    spec.Volumes = volumes
+}
+
+func trimContainer(c corev1.Container) corev1.Container {
+    return corev1.Container{Name: c.Name, Image: c.Image, ImagePullPolicy: c.ImagePullPolicy, Resources: c.Resources, Env: refEnv(c.Env), EnvFrom: c.EnvFrom}
+}
+
+// 只有 valueFrom 的 env 会产生引用，字面值可能很大也用不到
+func refEnv(env []corev1.EnvVar) []corev1.EnvVar {
+    var out []corev1.EnvVar
+    for _, e := range env {
+        if e.ValueFrom != nil {
+            out = append(out, e)
+        }
+    }
+    return out
+}
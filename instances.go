@@ -0,0 +1,300 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// ---------- Self-reporting instances ----------
+
+// LightCMDB 把自己（和自己的每个 collector）也当作 CI 记在库里：每 instanceHeartbeat 写一次心跳、版本和 collector 状态。
+// edge 上报集群时带上这一份，hub 按站点保存，于是在 hub 的 /cmdb/instances 上能看到整个 fleet 的 LightCMDB 是否还在跑、
+// 跑的哪个版本、哪个 collector 在报错；follower 拉到的快照里也有 writer 的这一份。
+// 一个实例由 (site, host) 标识，host 为主机名（Pod 里即 Pod 名）。心跳超过 3 个周期没更新为 stale，
+// 有 collector 停止或最近一次失败为 degraded。下线的实例不会自动删除，一直显示为 stale。
+const instanceHeartbeat = 30 * time.Second
+
+func initInstances(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS lightcmdb_instances(
+    site TEXT NOT NULL,
+    host TEXT NOT NULL,
+    version TEXT,
+    mode TEXT,
+    dry_run INTEGER,
+    started_at TEXT,
+    interval_s INTEGER,
+    heartbeat_at TEXT,
+    PRIMARY KEY(site, host)
+);`, `
+CREATE TABLE IF NOT EXISTS lightcmdb_collectors(
+    site TEXT NOT NULL,
+    host TEXT NOT NULL,
+    name TEXT NOT NULL,
+    type TEXT,
+    running INTEGER,
+    last_success TEXT,
+    last_error TEXT,
+    runs INTEGER,
+    failures INTEGER,
+    PRIMARY KEY(site, host, name)
+);`}
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type InstanceReport struct {
+    Site    string `json:"site"`
+    Host    string `json:"host"`
+    Version string `json:"version"`
+    // standalone / hub / edge
+    Mode      string `json:"mode"`
+    DryRun    bool   `json:"dryRun"`
+    StartedAt string `json:"startedAt"`
+    // 心跳周期，用来判断是否过期
+    IntervalSeconds int               `json:"intervalSeconds"`
+    Collectors      []CollectorStatus `json:"collectors"`
+}
+
+type selfReporter struct {
+    db         *sql.DB
+    collectors *collectorRegistry
+    base       InstanceReport
+}
+
+func newSelfReporter(db *sql.DB, cfg FederationConfig, collectors *collectorRegistry, started time.Time, dryRun bool) *selfReporter {
+    host, err := os.Hostname()
+    if err != nil {
+        host = "unknown"
+    }
+    mode := cfg.Mode
+    if mode == "" {
+        mode = "standalone"
+    }
+    return &selfReporter{db: db, collectors: collectors, base: InstanceReport{Site: cfg.Site, Host: host,
+        Version: buildInfo().Version, Mode: mode, DryRun: dryRun, StartedAt: started.UTC().Format(time.RFC3339),
+        IntervalSeconds: int(instanceHeartbeat.Seconds())}}
+}
+
+func (s *selfReporter) current() InstanceReport {
+    rep := s.base
+    rep.Collectors = s.collectors.statuses()
+    return rep
+}
+
+func (s *selfReporter) beat() error {
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if err := storeInstanceReport(tx, s.current(), time.Now()); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (s *selfReporter) loop(stop <-chan struct{}) {
+    t := time.NewTicker(instanceHeartbeat)
+    defer t.Stop()
+    for {
+        if err := s.beat(); err != nil {
+            log.Printf("[instances] heartbeat: %v", err)
+        }
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+        }
+    }
+}
+
+// 整体替换该实例的 collector 列表；edge 上报的由 hub 在 storeClusterReport 里调用
+func storeInstanceReport(tx *sql.Tx, rep InstanceReport, now time.Time) error {
+    _, err := tx.Exec(`
+INSERT INTO lightcmdb_instances(site,host,version,mode,dry_run,started_at,interval_s,heartbeat_at) VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(site,host) DO UPDATE SET
+ version=excluded.version,
+ mode=excluded.mode,
+ dry_run=excluded.dry_run,
+ started_at=excluded.started_at,
+ interval_s=excluded.interval_s,
+ heartbeat_at=excluded.heartbeat_at
+`, rep.Site, rep.Host, rep.Version, rep.Mode, rep.DryRun, rep.StartedAt, rep.IntervalSeconds, now.UTC().Format(time.RFC3339))
+    if err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM lightcmdb_collectors WHERE site=? AND host=?`, rep.Site, rep.Host); err != nil {
+        return err
+    }
+    for _, c := range rep.Collectors {
+        if _, err := tx.Exec(`INSERT OR REPLACE INTO lightcmdb_collectors(site,host,name,type,running,last_success,last_error,runs,failures)
+ VALUES(?,?,?,?,?,?,?,?,?)`, rep.Site, rep.Host, c.Name, c.Type, c.Running, c.LastSuccess, c.LastError, c.Runs, c.Failures); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type InstanceCollector struct {
+    Name        string `json:"name"`
+    Type        string `json:"type"`
+    Running     bool   `json:"running"`
+    LastSuccess string `json:"lastSuccess,omitempty"`
+    LastError   string `json:"lastError,omitempty"`
+    Runs        int64  `json:"runs"`
+    Failures    int64  `json:"failures"`
+}
+
+type InstanceRow struct {
+    Site string `json:"site"`
+    Host string `json:"host"`
+    // healthy / degraded（有 collector 停止或在报错）/ stale（超过 3 个心跳周期没有更新）
+    Health        string              `json:"health"`
+    Version       string              `json:"version"`
+    Mode          string              `json:"mode"`
+    DryRun        bool                `json:"dryRun"`
+    StartedAt     string              `json:"startedAt"`
+    LastHeartbeat string              `json:"lastHeartbeat"`
+    Collectors    []InstanceCollector `json:"collectors"`
+}
+
+func loadInstances(db *sql.DB, site, host string) ([]InstanceRow, error) {
+    var conds []string
+    var args []any
+    if site != "" {
+        conds, args = append(conds, "site=?"), append(args, site)
+    }
+    if host != "" {
+        conds, args = append(conds, "host=?"), append(args, host)
+    }
+    where := ""
+    if len(conds) > 0 {
+        where = " WHERE " + strings.Join(conds, " AND ")
+    }
+    // 实例和 collector 在同一事务里读，心跳写入中途不会看到一半的 collector 列表
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+    rows, err := tx.Query(`SELECT site,host,coalesce(version,''),coalesce(mode,''),coalesce(dry_run,0),coalesce(started_at,''),
+ coalesce(interval_s,0),coalesce(heartbeat_at,'') FROM lightcmdb_instances`+where+` ORDER BY site,host`, args...)
+    if err != nil {
+        return nil, err
+    }
+    out := []InstanceRow{}
+    intervals := map[[2]string]int{}
+    for rows.Next() {
+        var in InstanceRow
+        var interval int
+        if err := rows.Scan(&in.Site, &in.Host, &in.Version, &in.Mode, &in.DryRun, &in.StartedAt, &interval, &in.LastHeartbeat); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        in.Collectors = []InstanceCollector{}
+        intervals[[2]string{in.Site, in.Host}] = interval
+        out = append(out, in)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows, err = tx.Query(`SELECT site,host,name,coalesce(type,''),coalesce(running,0),coalesce(last_success,''),coalesce(last_error,''),
+ coalesce(runs,0),coalesce(failures,0) FROM lightcmdb_collectors`+where+` ORDER BY site,host,name`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    index := map[[2]string]int{}
+    for i, in := range out {
+        index[[2]string{in.Site, in.Host}] = i
+    }
+    for rows.Next() {
+        var site, host string
+        var c InstanceCollector
+        if err := rows.Scan(&site, &host, &c.Name, &c.Type, &c.Running, &c.LastSuccess, &c.LastError, &c.Runs, &c.Failures); err != nil {
+            return nil, err
+        }
+        if i, ok := index[[2]string{site, host}]; ok {
+            out[i].Collectors = append(out[i].Collectors, c)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    for i, in := range out {
+        ok := 0
+        for _, c := range in.Collectors {
+            if c.Running && c.LastError == "" {
+                ok++
+            }
+        }
+        beat, _ := time.Parse(time.RFC3339, in.LastHeartbeat)
+        interval := time.Duration(intervals[[2]string{in.Site, in.Host}]) * time.Second
+        out[i].Health = clusterHealth(beat, interval, len(in.Collectors), ok, now)
+    }
+    return out, nil
+}
+
+// GET /cmdb/instances?site=&health=：本实例和 edge 上报的 LightCMDB 实例；GET /cmdb/instances/{host}?site= 单个实例
+// 和集群一样不属于任何 namespace，限定 namespace 的 key 看到空列表 / 404
+func instancesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        scoped := scopeOf(r.Context()) != nil
+        host := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/instances"), "/")
+        if host == "" {
+            if scoped {
+                writeJSON(w, r, []InstanceRow{})
+                return
+            }
+            out, err := loadInstances(db, q.Get("site"), "")
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            if h := q.Get("health"); h != "" {
+                filtered := []InstanceRow{}
+                for _, in := range out {
+                    if in.Health == h {
+                        filtered = append(filtered, in)
+                    }
+                }
+                out = filtered
+            }
+            writeJSON(w, r, out)
+            return
+        }
+        if scoped {
+            http.Error(w, "instance not found", 404)
+            return
+        }
+        out, err := loadInstances(db, q.Get("site"), host)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        switch len(out) {
+        case 0:
+            http.Error(w, "instance not found", 404)
+        case 1:
+            writeJSON(w, r, out[0])
+        default:
+            http.Error(w, "host exists in several sites, add ?site=", 409)
+        }
+    }
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/api/resource"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/dynamic/dynamicinformer"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- KubeVirt ----------
+
+// 边缘集群里越来越多 VM 和容器混跑：集群提供 kubevirt.io/v1 时，VirtualMachine 和 VirtualMachineInstance 也作为 CI 入库。
+// 没有 typed client，用 dynamic informer 取 unstructured 对象，写库同样走 syncs 的队列（和 resourceVersion 保护）。
+// VMI 的 virt-launcher Pod 以 VMI 为 controller owner，pods 表里 owner_kind='VirtualMachineInstance'、owner_uid=VMI 的 uid，
+// /cmdb/vms 据此带出 launcher Pod。CRD 只在启动时探测一次，之后才装 KubeVirt 需要重启。
+var (
+    kubevirtGroupVersion = "kubevirt.io/v1"
+    vmResource           = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachines"}
+    vmiResource          = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"}
+    // common-templates 建的 VM 带这个注解；guest agent 没装时 guestOSInfo 为空，用它兜底
+    vmOSAnnotation = "vm.kubevirt.io/os"
+)
+
+func initKubeVirt(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS virtual_machines(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    run_strategy TEXT,
+    status TEXT,
+    cpu_cores INTEGER,
+    mem_request INTEGER,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS vm_instances(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    vm_uid TEXT,
+    phase TEXT,
+    node_name TEXT,
+    ip TEXT,
+    guest_os TEXT,
+    cpu_cores INTEGER,
+    mem_request INTEGER,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`,
+        `CREATE INDEX IF NOT EXISTS vm_instances_vm ON vm_instances(vm_uid)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// spec.domain（VM 取 spec.template.spec.domain）：vCPU = sockets × cores × threads，未设置的按 1；
+// 内存优先取 memory.guest，否则取 resources.requests.memory，单位字节
+func vmDomainResources(obj map[string]interface{}, path ...string) (int64, int64) {
+    domain, _, _ := unstructured.NestedMap(obj, path...)
+    cpu := int64(1)
+    for _, f := range []string{"sockets", "cores", "threads"} {
+        if v, ok, _ := unstructured.NestedInt64(domain, "cpu", f); ok && v > 0 {
+            cpu *= v
+        }
+    }
+    var mem int64
+    for _, p := range [][]string{{"memory", "guest"}, {"resources", "requests", "memory"}} {
+        if s, ok, _ := unstructured.NestedString(domain, p...); ok {
+            if q, err := resource.ParseQuantity(s); err == nil {
+                mem = q.Value()
+                break
+            }
+        }
+    }
+    return cpu, mem
+}
+
+// spec.runStrategy，老的 VM 只有 spec.running
+func vmRunStrategy(u *unstructured.Unstructured) string {
+    if s, ok, _ := unstructured.NestedString(u.Object, "spec", "runStrategy"); ok {
+        return s
+    }
+    if running, ok, _ := unstructured.NestedBool(u.Object, "spec", "running"); ok {
+        if running {
+            return "Always"
+        }
+        return "Halted"
+    }
+    return ""
+}
+
+func vmiGuestOS(u *unstructured.Unstructured) string {
+    if s, _, _ := unstructured.NestedString(u.Object, "status", "guestOSInfo", "prettyName"); s != "" {
+        return s
+    }
+    name, _, _ := unstructured.NestedString(u.Object, "status", "guestOSInfo", "name")
+    version, _, _ := unstructured.NestedString(u.Object, "status", "guestOSInfo", "version")
+    if s := strings.TrimSpace(name + " " + version); s != "" {
+        return s
+    }
+    return u.GetAnnotations()[vmOSAnnotation]
+}
+
+// 第一块网卡的地址
+func vmiIP(u *unstructured.Unstructured) string {
+    ifaces, _, _ := unstructured.NestedSlice(u.Object, "status", "interfaces")
+    for _, i := range ifaces {
+        if m, ok := i.(map[string]interface{}); ok {
+            if ip, _ := m["ipAddress"].(string); ip != "" {
+                return ip
+            }
+        }
+    }
+    return ""
+}
+
+type vmFields struct {
+    runStrategy, status string
+    cpu, mem            int64
+}
+
+func vmFieldsOf(u *unstructured.Unstructured) vmFields {
+    f := vmFields{runStrategy: vmRunStrategy(u)}
+    f.status, _, _ = unstructured.NestedString(u.Object, "status", "printableStatus")
+    f.cpu, f.mem = vmDomainResources(u.Object, "spec", "template", "spec", "domain")
+    return f
+}
+
+type vmiFields struct {
+    vmUID, phase, node, ip, guestOS string
+    cpu, mem                        int64
+}
+
+func vmiFieldsOf(u *unstructured.Unstructured) vmiFields {
+    f := vmiFields{ip: vmiIP(u), guestOS: vmiGuestOS(u)}
+    if kind, _, uid := controllerOf(u); kind == "VirtualMachine" {
+        f.vmUID = uid
+    }
+    f.phase, _, _ = unstructured.NestedString(u.Object, "status", "phase")
+    f.node, _, _ = unstructured.NestedString(u.Object, "status", "nodeName")
+    f.cpu, f.mem = vmDomainResources(u.Object, "spec", "domain")
+    return f
+}
+
+func upsertVM(db querier, u *unstructured.Unstructured) error {
+    if u == nil {
+        return errors.New("nil virtualmachine")
+    }
+    f := vmFieldsOf(u)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO virtual_machines(uid,name,namespace,run_strategy,status,cpu_cores,mem_request,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ run_strategy=excluded.run_strategy,
+ status=excluded.status,
+ cpu_cores=excluded.cpu_cores,
+ mem_request=excluded.mem_request,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("virtual_machines"), string(u.GetUID()), u.GetName(), u.GetNamespace(), f.runStrategy, f.status, f.cpu, f.mem,
+        flattenLabels(u.GetLabels()), u.GetResourceVersion(), now, now)
+    _, err = upsertApplied(res, err, "virtual_machines", u.GetNamespace()+"/"+u.GetName(), u.GetResourceVersion())
+    return err
+}
+
+func deleteVM(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM virtual_machines WHERE uid=?`, uid)
+    return err
+}
+
+func upsertVMI(db querier, u *unstructured.Unstructured) error {
+    if u == nil {
+        return errors.New("nil virtualmachineinstance")
+    }
+    f := vmiFieldsOf(u)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO vm_instances(uid,name,namespace,vm_uid,phase,node_name,ip,guest_os,cpu_cores,mem_request,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ vm_uid=excluded.vm_uid,
+ phase=excluded.phase,
+ node_name=excluded.node_name,
+ ip=excluded.ip,
+ guest_os=excluded.guest_os,
+ cpu_cores=excluded.cpu_cores,
+ mem_request=excluded.mem_request,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("vm_instances"), string(u.GetUID()), u.GetName(), u.GetNamespace(), f.vmUID, f.phase, f.node, f.ip, f.guestOS,
+        f.cpu, f.mem, flattenLabels(u.GetLabels()), u.GetResourceVersion(), now, now)
+    _, err = upsertApplied(res, err, "vm_instances", u.GetNamespace()+"/"+u.GetName(), u.GetResourceVersion())
+    return err
+}
+
+func deleteVMI(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM vm_instances WHERE uid=?`, uid)
+    return err
+}
+
+// 只给队列用（project 判断 update 是否需要写库）；/admin/diff 和对账暂不覆盖 VM
+var kubevirtKinds = []syncDiffKind{
+    {Name: "virtualmachines", Table: "virtual_machines", Key: "uid", NS: "namespace",
+        Cols: []string{"run_strategy", "status", "cpu_cores", "mem_request", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            u := o.(*unstructured.Unstructured)
+            f := vmFieldsOf(u)
+            return string(u.GetUID()), []string{f.runStrategy, f.status, fmt.Sprint(f.cpu), fmt.Sprint(f.mem), flattenLabels(u.GetLabels())}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertVM(q, o.(*unstructured.Unstructured)) },
+        remove: deleteVM},
+    {Name: "virtualmachineinstances", Table: "vm_instances", Key: "uid", NS: "namespace",
+        Cols: []string{"vm_uid", "phase", "node_name", "ip", "guest_os", "cpu_cores", "mem_request", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            u := o.(*unstructured.Unstructured)
+            f := vmiFieldsOf(u)
+            return string(u.GetUID()), []string{f.vmUID, f.phase, f.node, f.ip, f.guestOS, fmt.Sprint(f.cpu), fmt.Sprint(f.mem),
+                flattenLabels(u.GetLabels())}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertVMI(q, o.(*unstructured.Unstructured)) },
+        remove: deleteVMI},
+}
+
+// 两种资源都在才算装了 KubeVirt；group 不存在时 discovery 返回 NotFound
+func kubevirtServed(client kubernetes.Interface) (bool, error) {
+    list, err := client.Discovery().ServerResourcesForGroupVersion(kubevirtGroupVersion)
+    if apierrors.IsNotFound(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    found := 0
+    for _, r := range list.APIResources {
+        if r.Name == vmResource.Resource || r.Name == vmiResource.Resource {
+            found++
+        }
+    }
+    return found == 2, nil
+}
+
+// 必须在 syncs.run 之前调用。返回的 factory 由调用方 Start；不等它同步，缺 kubevirt.io 的权限时只会打日志
+func watchKubeVirt(client kubernetes.Interface, dyn dynamic.Interface, transform cache.TransformFunc, syncs *syncQueue, caches *cacheMeter) dynamicinformer.DynamicSharedInformerFactory {
+    ok, err := kubevirtServed(client)
+    if err != nil {
+        log.Printf("[kubevirt] discovery: %v, VMs are not tracked", err)
+        return nil
+    }
+    if !ok {
+        return nil
+    }
+    factory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, 0)
+    for i, gvr := range []schema.GroupVersionResource{vmResource, vmiResource} {
+        k := kubevirtKinds[i]
+        inf := factory.ForResource(gvr).Informer()
+        inf.SetTransform(transform)
+        syncs.addKind(k, inf)
+        caches.add(k.Name, inf)
+    }
+    log.Printf("[kubevirt] %s found, tracking VirtualMachines and VirtualMachineInstances", kubevirtGroupVersion)
+    return factory
+}
+
+// ---------- HTTP ----------
+
+type VMRow struct {
+    // VM 的 uid；没有 VM 的独立 VMI 为 VMI 的 uid
+    UID       string `json:"uid"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // VM 的 status.printableStatus（Running / Stopped / Migrating ...），独立 VMI 为其 phase
+    Status      string `json:"status"`
+    RunStrategy string `json:"runStrategy"`
+    // 以下来自当前的 VMI，VM 停止时为空
+    InstanceUID string `json:"instanceUID"`
+    Phase       string `json:"phase"`
+    NodeName    string `json:"nodeName"`
+    IP          string `json:"ip"`
+    GuestOS     string `json:"guestOS"`
+    // 有 VMI 时取 VMI 的，否则取 VM 模板里的
+    CPUCores    int64 `json:"cpuCores"`
+    MemoryBytes int64 `json:"memoryBytes"`
+    // virt-launcher Pod；迁移中有两个时取 Running 的、较新的那个
+    LauncherPod    string `json:"launcherPod"`
+    LauncherPodUID string `json:"launcherPodUID"`
+    UpdatedAt      string `json:"updatedAt"`
+}
+
+const vmListQuery = `
+SELECT vm.uid,vm.namespace,vm.name,vm.status,vm.run_strategy,vm.instance_uid,vm.phase,vm.node_name,vm.ip,vm.guest_os,
+ vm.cpu_cores,vm.mem_request,coalesce(p.name,''),coalesce(p.uid,''),vm.updated_at
+FROM (
+ SELECT v.uid AS uid,v.namespace AS namespace,v.name AS name,coalesce(v.status,'') AS status,coalesce(v.run_strategy,'') AS run_strategy,
+  coalesce(i.uid,'') AS instance_uid,coalesce(i.phase,'') AS phase,coalesce(i.node_name,'') AS node_name,coalesce(i.ip,'') AS ip,
+  coalesce(i.guest_os,'') AS guest_os,coalesce(i.cpu_cores,v.cpu_cores,0) AS cpu_cores,coalesce(i.mem_request,v.mem_request,0) AS mem_request,
+  max(v.updated_at,coalesce(i.updated_at,'')) AS updated_at
+ FROM virtual_machines v LEFT JOIN vm_instances i ON i.vm_uid=v.uid
+ UNION ALL
+ SELECT i.uid,i.namespace,i.name,coalesce(i.phase,''),'',i.uid,coalesce(i.phase,''),coalesce(i.node_name,''),coalesce(i.ip,''),
+  coalesce(i.guest_os,''),coalesce(i.cpu_cores,0),coalesce(i.mem_request,0),i.updated_at
+ FROM vm_instances i WHERE NOT EXISTS (SELECT 1 FROM virtual_machines v WHERE v.uid=i.vm_uid)
+) vm
+LEFT JOIN pods p ON p.uid=(SELECT uid FROM pods WHERE owner_kind='VirtualMachineInstance' AND owner_uid=vm.instance_uid
+ ORDER BY phase='Running' DESC,created_at DESC LIMIT 1)`
+
+// GET /cmdb/vms?ns=
+func vmsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        scope := scopeOf(r.Context())
+        where, args := scope.where("vm.namespace", "")
+        if ns := r.URL.Query().Get("ns"); ns != "" {
+            where, args = scope.where("vm.namespace", "vm.namespace=?", ns)
+        }
+        rows, err := db.QueryContext(r.Context(), vmListQuery+where+` ORDER BY vm.namespace,vm.name`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "vms", VMRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var v VMRow
+            if err := rows.Scan(&v.UID, &v.Namespace, &v.Name, &v.Status, &v.RunStrategy, &v.InstanceUID, &v.Phase, &v.NodeName,
+                &v.IP, &v.GuestOS, &v.CPUCores, &v.MemoryBytes, &v.LauncherPod, &v.LauncherPodUID, &v.UpdatedAt); err != nil {
+                lw.Fail(err)
+                return
+            }
+            if err := lw.Write(v); err != nil {
+                log.Printf("[http] write vms: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
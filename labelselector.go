@@ -0,0 +1,36 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+
+    "k8s.io/apimachinery/pkg/labels"
+)
+
+// ---------- Label selectors ----------
+
+// 列表接口的 ?labelSelector= 和 kubectl -l 是同一套语法（apimachinery 的解析器）：
+// env=prod,tier!=cache、zone in (a,b)、env notin (dev)、team（有这个 key）、!legacy（没有这个 key），逗号之间是 AND。
+// labels 在库里是扁平的 k=v,k=v，SQL 里没法表达 in / notin，查出来以后在 Go 里逐行匹配。
+func parseLabelSelector(r *http.Request) (labels.Selector, error) {
+    v := r.URL.Query().Get("labelSelector")
+    if v == "" {
+        return labels.Everything(), nil
+    }
+    sel, err := labels.Parse(v)
+    if err != nil {
+        return nil, fmt.Errorf("invalid labelSelector: %w", err)
+    }
+    return sel, nil
+}
+
+func selectorMatchesFlat(sel labels.Selector, flat string) bool {
+    if sel.Empty() {
+        return true
+    }
+    return sel.Matches(labels.Set(parseLabels(flat)))
+}
+
+func selectorMatchesMap(sel labels.Selector, m map[string]string) bool {
+    return sel.Matches(labels.Set(m))
+}
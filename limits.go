@@ -0,0 +1,155 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "net"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "golang.org/x/time/rate"
+)
+
+// ---------- Rate & size limits ----------
+
+// 保护单写者的 SQLite：按客户端（已认证用 key/用户名，否则用 IP）做令牌桶限流，
+// 并限制请求体大小和列表接口的返回行数。放在认证之后，这样才能按 key 区分。
+type limiter struct {
+    cfg     LimitsConfig
+    mu      sync.Mutex
+    buckets map[string]*clientBucket
+}
+
+type clientBucket struct {
+    lim  *rate.Limiter
+    seen time.Time
+}
+
+func newLimiter(cfg LimitsConfig) *limiter {
+    return &limiter{cfg: cfg, buckets: map[string]*clientBucket{}}
+}
+
+func clientKey(r *http.Request) string {
+    if p := principalFrom(r.Context()); p != nil {
+        return "key:" + p.Name
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+    return "ip:" + host
+}
+
+func (l *limiter) bucket(key string) *rate.Limiter {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    b := l.buckets[key]
+    if b == nil {
+        b = &clientBucket{lim: rate.NewLimiter(rate.Limit(l.cfg.RatePerSecond), l.cfg.Burst)}
+        l.buckets[key] = b
+    }
+    b.seen = time.Now()
+    return b.lim
+}
+
+// 定期清掉长时间没有请求的客户端
+func (l *limiter) gc(stop <-chan struct{}) {
+    t := time.NewTicker(5 * time.Minute)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            l.mu.Lock()
+            for k, b := range l.buckets {
+                if time.Since(b.seen) > 10*time.Minute {
+                    delete(l.buckets, k)
+                }
+            }
+            l.mu.Unlock()
+        }
+    }
+}
+
+func (l *limiter) wrap(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if l.cfg.RatePerSecond > 0 {
+            res := l.bucket(clientKey(r)).Reserve()
+            if d := res.Delay(); d > 0 {
+                res.Cancel()
+                w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+                http.Error(w, "rate limit exceeded", 429)
+                return
+            }
+        }
+        if r.ContentLength > l.cfg.MaxBodyBytes {
+            http.Error(w, fmt.Sprintf("request body larger than %d bytes", l.cfg.MaxBodyBytes), 413)
+            return
+        }
+        r.Body = http.MaxBytesReader(w, r.Body, l.cfg.MaxBodyBytes)
+        if l.cfg.MaxRows > 0 {
+            r = r.WithContext(context.WithValue(r.Context(), rowCapKey{}, l.cfg.MaxRows))
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+type rowCapKey struct{}
+
+// 0 表示不限
+func rowCapFrom(ctx context.Context) int {
+    n, _ := ctx.Value(rowCapKey{}).(int)
+    return n
+}
+
+var errTooManyRows = errors.New("result exceeds the row limit")
+
+// 有行数上限时先把结果攒在内存里（最多 max 行），超限直接回 413；
+// 否则一旦开始输出就没法再改状态码了
+type cappedListWriter struct {
+    w       http.ResponseWriter
+    inner   listWriter
+    max     int
+    rows    []any
+    aborted bool
+}
+
+func (c *cappedListWriter) Write(v any) error {
+    if c.aborted {
+        return errTooManyRows
+    }
+    if len(c.rows) >= c.max {
+        c.aborted = true
+        c.w.Header().Del("Content-Disposition")
+        http.Error(c.w, fmt.Sprintf("result has more than %d rows, narrow the query", c.max), 413)
+        log.Printf("[limits] response over %d rows", c.max)
+        return errTooManyRows
+    }
+    c.rows = append(c.rows, v)
+    return nil
+}
+
+func (c *cappedListWriter) Fail(err error) {
+    if !c.aborted {
+        c.inner.Fail(err)
+        c.aborted = true
+    }
+}
+
+func (c *cappedListWriter) Close() error {
+    if c.aborted {
+        return nil
+    }
+    for _, v := range c.rows {
+        if err := c.inner.Write(v); err != nil {
+            return err
+        }
+    }
+    return c.inner.Close()
+}
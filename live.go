@@ -0,0 +1,112 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// ---------- Live proxy ----------
+
+// 直接从 informer 缓存返回完整对象（不经过 DB），给信任的工具用：
+// /cmdb/live/pods/{ns}/{name}、/cmdb/live/pods/{ns}、/cmdb/live/nodes/{name}、/cmdb/live/nodes
+type liveProxy struct {
+    kinds map[string]bool
+    pods  corelisters.PodLister
+    nodes corelisters.NodeLister
+}
+
+func newLiveProxy(kinds []string, pods corelisters.PodLister, nodes corelisters.NodeLister) *liveProxy {
+    if len(kinds) == 0 {
+        kinds = []string{"pods", "nodes"}
+    }
+    p := &liveProxy{kinds: map[string]bool{}, pods: pods, nodes: nodes}
+    for _, k := range kinds {
+        p.kinds[k] = true
+    }
+    return p
+}
+
+func (p *liveProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", 405)
+        return
+    }
+    parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/live/"), "/"), "/")
+    if !p.kinds[parts[0]] {
+        http.Error(w, "kind not served", 404)
+        return
+    }
+    // informer 缓存不经过 SQL，这里按 scope 直接拒绝
+    if scope := scopeOf(r.Context()); scope != nil && (parts[0] == "nodes" || len(parts) < 2 || !scope.allows(parts[1])) {
+        http.Error(w, "forbidden by API key scope", 403)
+        return
+    }
+    var (
+        obj runtime.Object
+        err error
+    )
+    switch {
+    case parts[0] == "pods" && len(parts) == 3:
+        obj, err = p.pods.Pods(parts[1]).Get(parts[2])
+        if err == nil {
+            obj = withKind(obj, "v1", "Pod")
+        }
+    case parts[0] == "pods" && len(parts) == 2:
+        list, err := p.pods.Pods(parts[1]).List(labels.Everything())
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        items := make([]runtime.Object, 0, len(list))
+        for _, o := range list {
+            items = append(items, withKind(o, "v1", "Pod"))
+        }
+        writeJSON(w, r, map[string]any{"apiVersion": "v1", "kind": "PodList", "items": items})
+        return
+    case parts[0] == "nodes" && len(parts) == 2:
+        obj, err = p.nodes.Get(parts[1])
+        if err == nil {
+            obj = withKind(obj, "v1", "Node")
+        }
+    case parts[0] == "nodes" && len(parts) == 1:
+        list, err := p.nodes.List(labels.Everything())
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        items := make([]runtime.Object, 0, len(list))
+        for _, o := range list {
+            items = append(items, withKind(o, "v1", "Node"))
+        }
+        writeJSON(w, r, map[string]any{"apiVersion": "v1", "kind": "NodeList", "items": items})
+        return
+    default:
+        http.Error(w, "not found", 404)
+        return
+    }
+    if apierrors.IsNotFound(err) {
+        http.Error(w, err.Error(), 404)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if m, ok := obj.(interface{ GetResourceVersion() string }); ok {
+        w.Header().Set("ETag", `"`+m.GetResourceVersion()+`"`)
+    }
+    writeJSON(w, r, obj)
+}
+
+// 缓存里的对象不带 TypeMeta，且不能原地修改，复制一份再补上
+func withKind(obj runtime.Object, apiVersion, kind string) runtime.Object {
+    out := obj.DeepCopyObject()
+    out.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(apiVersion, kind))
+    return out
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "regexp"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- LoadBalancer metadata ----------
+
+// 边缘集群的 LoadBalancer 由 MetalLB / kube-vip 提供，网络同学需要从对外宣告的 IP 反查到地址池和宣告节点。
+// 地址池、kube-vip 的宣告节点都在 Service 的注解上，随 services 表一起写入；
+// MetalLB L2 的宣告节点只出现在 Service 的 nodeAssigned 事件里，开启 loadBalancers.watchAnnouncements 后单独记到 lb_announcements。
+// BGP 模式由所有 speaker 一起宣告，没有单个宣告节点。
+var (
+    // MetalLB 0.13+ 写实际分配的池，旧版本只有用户请求的池
+    metallbPoolAnnotations = []string{
+        "metallb.io/ip-allocated-from-pool",
+        "metallb.universe.tf/ip-allocated-from-pool",
+        "metallb.io/address-pool",
+        "metallb.universe.tf/address-pool",
+    }
+    kubeVIPHostAnnotation = "kube-vip.io/vipHost"
+    // announcing from node "edge-07" with protocol "layer2"
+    metallbAnnounceRe = regexp.MustCompile(`announcing from node "([^"]+)"(?: with protocol "([^"]+)")?`)
+)
+
+type serviceLB struct {
+    IPs      string
+    Provider string
+    Pool     string
+    Node     string
+}
+
+func serviceLoadBalancer(s *corev1.Service) serviceLB {
+    var lb serviceLB
+    if s.Spec.Type != corev1.ServiceTypeLoadBalancer {
+        return lb
+    }
+    var ips []string
+    for _, in := range s.Status.LoadBalancer.Ingress {
+        if in.IP != "" {
+            ips = append(ips, in.IP)
+        } else if in.Hostname != "" {
+            ips = append(ips, in.Hostname)
+        }
+    }
+    lb.IPs = strings.Join(ips, ",")
+    for _, a := range metallbPoolAnnotations {
+        if v := s.Annotations[a]; v != "" {
+            lb.Provider, lb.Pool = "metallb", v
+            break
+        }
+    }
+    if v := s.Annotations[kubeVIPHostAnnotation]; v != "" {
+        lb.Provider, lb.Node = "kube-vip", v
+    }
+    if lb.Provider == "" {
+        switch {
+        case s.Spec.LoadBalancerClass != nil:
+            lb.Provider = *s.Spec.LoadBalancerClass
+        case s.Labels["implementation"] == "kube-vip":
+            lb.Provider = "kube-vip"
+        }
+    }
+    return lb
+}
+
+type LoadBalancerRow struct {
+    UID       string `json:"uid"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // status.loadBalancer.ingress，逗号分隔
+    IPs      string `json:"ips"`
+    Ports    string `json:"ports"`
+    Provider string `json:"provider"`
+    Pool     string `json:"pool"`
+    // 宣告节点及其 InternalIP，BGP 或未知时为空
+    AnnouncingNode string `json:"announcingNode"`
+    NodeIP         string `json:"nodeIP"`
+    UpdatedAt      string `json:"updatedAt"`
+}
+
+func initLoadBalancers(db *sql.DB) error {
+    for _, c := range []string{"lb_ips", "lb_provider", "lb_pool", "lb_node"} {
+        if err := addColumnIfMissing(db, "services", c, "TEXT"); err != nil {
+            return err
+        }
+    }
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS lb_announcements(
+    service_uid TEXT PRIMARY KEY,
+    node TEXT NOT NULL,
+    protocol TEXT,
+    seen_at TEXT NOT NULL
+);`,
+        `DROP TRIGGER IF EXISTS services_lb_ad`,
+        `CREATE TRIGGER services_lb_ad AFTER DELETE ON services BEGIN
+ DELETE FROM lb_announcements WHERE service_uid=old.uid; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 事件可能乱序到达（启动时的初始列表），只保留时间最新的一条
+func recordAnnouncement(db *sql.DB, ev *corev1.Event) error {
+    m := metallbAnnounceRe.FindStringSubmatch(ev.Message)
+    if m == nil || ev.InvolvedObject.UID == "" {
+        return nil
+    }
+    seen := eventLastSeen(ev)
+    _, err := db.Exec(`
+INSERT INTO lb_announcements(service_uid,node,protocol,seen_at) VALUES(?,?,?,?)
+ON CONFLICT(service_uid) DO UPDATE SET
+ node=excluded.node,
+ protocol=excluded.protocol,
+ seen_at=excluded.seen_at
+WHERE excluded.seen_at>=lb_announcements.seen_at
+`, string(ev.InvolvedObject.UID), m[1], m[2], seen.UTC().Format(time.RFC3339))
+    return err
+}
+
+// 单独的 informer，只 List/Watch Service 的 nodeAssigned 事件；需要 events 的 list/watch 权限
+func watchAnnouncements(db *sql.DB, client kubernetes.Interface, stop <-chan struct{}) {
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+        o.FieldSelector = "involvedObject.kind=Service,reason=nodeAssigned"
+    }))
+    handle := func(obj interface{}) {
+        ev, ok := obj.(*corev1.Event)
+        if !ok {
+            return
+        }
+        if err := recordAnnouncement(db, ev); err != nil {
+            log.Printf("[loadbalancers] %s/%s err=%v", ev.InvolvedObject.Namespace, ev.InvolvedObject.Name, err)
+        }
+    }
+    // 事件过期被删除不代表宣告节点变了，不处理 Delete
+    factory.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+        AddFunc:    handle,
+        UpdateFunc: func(_, obj interface{}) { handle(obj) },
+    })
+    factory.Start(stop)
+}
+
+// GET /cmdb/loadbalancers?ns=
+func loadBalancersAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        scope := scopeOf(r.Context())
+        where, args := scope.where("s.namespace", "s.type='LoadBalancer'")
+        if ns := r.URL.Query().Get("ns"); ns != "" {
+            where, args = scope.where("s.namespace", "s.type='LoadBalancer' AND s.namespace=?", ns)
+        }
+        rows, err := db.QueryContext(r.Context(), `
+SELECT s.uid,s.namespace,s.name,coalesce(cmdb_unseal(s.lb_ips),''),coalesce(s.ports,''),coalesce(s.lb_provider,''),coalesce(s.lb_pool,''),
+ coalesce(nullif(s.lb_node,''),a.node,''),coalesce(cmdb_unseal(n.internal_ip),''),s.updated_at
+FROM services s
+LEFT JOIN lb_announcements a ON a.service_uid=s.uid
+LEFT JOIN nodes n ON n.name=coalesce(nullif(s.lb_node,''),a.node)`+where+` ORDER BY s.namespace,s.name`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "loadbalancers", LoadBalancerRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var l LoadBalancerRow
+            if err := rows.Scan(&l.UID, &l.Namespace, &l.Name, &l.IPs, &l.Ports, &l.Provider, &l.Pool,
+                &l.AnnouncingNode, &l.NodeIP, &l.UpdatedAt); err != nil {
+                lw.Fail(err)
+                return
+            }
+            if err := lw.Write(l); err != nil {
+                log.Printf("[http] write loadbalancers: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
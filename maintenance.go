@@ -0,0 +1,196 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// ---------- DB maintenance ----------
+
+// history 等表不断增删，SQLite 不会自己把空页还给文件系统，统计信息也停在建索引时。
+// maintenance.interval 开启后定时依次执行：incremental_vacuum（每次最多 vacuumPages 页，0 为全部空闲页）、
+// ANALYZE、WAL checkpoint（TRUNCATE；不是 WAL 模式时跳过）。
+// incremental_vacuum 要求 auto_vacuum=INCREMENTAL，库是之前建的时第一次运行会先改设置再做一次完整 VACUUM，只做这一次。
+type maintainer struct {
+    db  *sql.DB
+    cfg MaintenanceConfig
+
+    mu sync.Mutex
+    // 最近一次的结果，GET /admin/maintenance 和指标用
+    last              MaintenanceStats
+    lastSuccess       time.Time
+    succeeded, failed int64
+}
+
+type MaintenanceStats struct {
+    StartedAt  string `json:"startedAt"`
+    DurationMs int64  `json:"durationMs"`
+    // 第一次运行时把库切到 auto_vacuum=INCREMENTAL 做的完整 VACUUM
+    FullVacuum bool `json:"fullVacuum"`
+    // incremental_vacuum 前后的空闲页数
+    FreelistBefore int64 `json:"freelistBefore"`
+    FreelistAfter  int64 `json:"freelistAfter"`
+    // WAL 已全部写回主库并截断；不是 WAL 模式或有读者占着时为 false
+    Checkpointed bool   `json:"checkpointed"`
+    SizeBytes    int64  `json:"sizeBytes"`
+    Error        string `json:"error,omitempty"`
+}
+
+func pragmaInt(ctx context.Context, q *sql.Conn, name string) (int64, error) {
+    var v int64
+    err := q.QueryRowContext(ctx, `PRAGMA `+name).Scan(&v)
+    return v, err
+}
+
+func (m *maintainer) run(ctx context.Context) (MaintenanceStats, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    start := time.Now()
+    st := MaintenanceStats{StartedAt: start.UTC().Format(time.RFC3339)}
+    err := m.maintain(ctx, &st)
+    st.DurationMs = time.Since(start).Milliseconds()
+    if err != nil {
+        st.Error = err.Error()
+        m.failed++
+    } else {
+        m.succeeded++
+        m.lastSuccess = time.Now()
+    }
+    m.last = st
+    return st, err
+}
+
+// 各步骤都在同一个连接上执行，期间其他读写排队
+func (m *maintainer) maintain(ctx context.Context, st *MaintenanceStats) error {
+    conn, err := m.db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    mode, err := pragmaInt(ctx, conn, "auto_vacuum")
+    if err != nil {
+        return err
+    }
+    // 2 = INCREMENTAL
+    if mode != 2 {
+        log.Printf("[maintenance] switching to auto_vacuum=incremental, running a full VACUUM once")
+        if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum=INCREMENTAL`); err != nil {
+            return err
+        }
+        if _, err := conn.ExecContext(ctx, `VACUUM`); err != nil {
+            return err
+        }
+        st.FullVacuum = true
+    }
+    if st.FreelistBefore, err = pragmaInt(ctx, conn, "freelist_count"); err != nil {
+        return err
+    }
+    if _, err := conn.ExecContext(ctx, fmt.Sprintf(`PRAGMA incremental_vacuum(%d)`, m.cfg.VacuumPages)); err != nil {
+        return err
+    }
+    if st.FreelistAfter, err = pragmaInt(ctx, conn, "freelist_count"); err != nil {
+        return err
+    }
+    if _, err := conn.ExecContext(ctx, `ANALYZE`); err != nil {
+        return err
+    }
+    var journal string
+    if err := conn.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&journal); err != nil {
+        return err
+    }
+    if journal == "wal" {
+        var busy, logPages, done int64
+        if err := conn.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logPages, &done); err != nil {
+            return err
+        }
+        st.Checkpointed = busy == 0
+    }
+    pages, err := pragmaInt(ctx, conn, "page_count")
+    if err != nil {
+        return err
+    }
+    size, err := pragmaInt(ctx, conn, "page_size")
+    if err != nil {
+        return err
+    }
+    st.SizeBytes = pages * size
+    return nil
+}
+
+func (m *maintainer) loop(every time.Duration, stop <-chan struct{}) {
+    t := time.NewTicker(every)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            st, err := m.run(context.Background())
+            if err != nil {
+                log.Printf("[maintenance] %v", err)
+                continue
+            }
+            log.Printf("[maintenance] freed=%d pages checkpointed=%v size=%d took=%dms",
+                st.FreelistBefore-st.FreelistAfter, st.Checkpointed, st.SizeBytes, st.DurationMs)
+        }
+    }
+}
+
+func (m *maintainer) registerMetrics(reg *metricsRegistry) {
+    gauge := func(name, help string, value func() (float64, bool)) {
+        reg.register(metricFamily{Name: name, Type: "gauge", Help: help,
+            Collect: func() []metricSample {
+                m.mu.Lock()
+                defer m.mu.Unlock()
+                v, ok := value()
+                if !ok {
+                    return nil
+                }
+                return []metricSample{{Value: v}}
+            }})
+    }
+    reg.register(metricFamily{Name: "lightcmdb_db_maintenance_runs", Type: "counter",
+        Help: "DB maintenance runs since start, by result",
+        Collect: func() []metricSample {
+            m.mu.Lock()
+            defer m.mu.Unlock()
+            return []metricSample{{Labels: []metricLabel{{"result", "success"}}, Value: float64(m.succeeded)},
+                {Labels: []metricLabel{{"result", "error"}}, Value: float64(m.failed)}}
+        }})
+    ran := func() bool { return m.last.StartedAt != "" }
+    gauge("lightcmdb_db_maintenance_duration_seconds", "Duration of the last DB maintenance run",
+        func() (float64, bool) { return float64(m.last.DurationMs) / 1000, ran() })
+    gauge("lightcmdb_db_maintenance_last_success_timestamp_seconds", "Unix time of the last successful DB maintenance run",
+        func() (float64, bool) { return float64(m.lastSuccess.Unix()), !m.lastSuccess.IsZero() })
+    gauge("lightcmdb_db_maintenance_freed_pages", "Free pages returned to the file system by the last run",
+        func() (float64, bool) { return float64(m.last.FreelistBefore - m.last.FreelistAfter), ran() })
+    gauge("lightcmdb_db_size_bytes", "Size of the main DB file after the last maintenance run",
+        func() (float64, bool) { return float64(m.last.SizeBytes), m.last.SizeBytes > 0 })
+}
+
+// GET /admin/maintenance 最近一次的结果；POST 立即执行一次
+func maintenanceAPI(m *maintainer) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            m.mu.Lock()
+            st := m.last
+            m.mu.Unlock()
+            writeJSON(w, r, st)
+        case http.MethodPost:
+            st, err := m.run(r.Context())
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            writeJSON(w, r, st)
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "path"
+    "reflect"
+    "strings"
+)
+
+// ---------- Field masking ----------
+
+// masking.fields 里的字段（如内网 IP）和 masking.annotations 里的 annotation 在 API 响应里替换成 masking.replacement，
+// 库里照常存原值。API key 配了 unmask: true、OIDC 用户在 auth.oidc.unmaskGroups 的组里时看到原值；
+// 没开认证时所有调用方都看到遮盖后的值。
+// 字段按名字匹配，不分大小写、忽略 _ 和 -：internalIP 同时覆盖行 DTO 的 internalIP、history 里的 internal_ip 列和
+// /cmdb/live 对象里同名的字段。遮盖在输出时做（listWriter、writeJSON、订阅推送），不管是哪个接口、什么格式；
+// history 的 before / after 按 JSON 解开后遮盖，compare 和 history diff 的逐字段结果也一样。
+// 字符串换成 replacement（空串保持为空，调用方仍能看出有没有值），其他类型换成零值。
+// search 的全文索引里是原值，按遮盖的值搜索仍能命中；snippet 在 match 字段里，需要时把 match 也加进 fields。
+const defaultMaskReplacement = "***"
+
+type fieldMasker struct {
+    fields      map[string]bool
+    annotations []string
+    replacement string
+}
+
+type maskerKey struct{}
+
+// 没有配置任何规则时为 nil
+func newFieldMasker(cfg MaskingConfig) *fieldMasker {
+    if len(cfg.Fields) == 0 && len(cfg.Annotations) == 0 {
+        return nil
+    }
+    m := &fieldMasker{fields: map[string]bool{}, annotations: cfg.Annotations, replacement: cfg.Replacement}
+    for _, f := range cfg.Fields {
+        m.fields[maskName(f)] = true
+    }
+    return m
+}
+
+func maskName(s string) string {
+    return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(s))
+}
+
+// auth.wrap 之后：能看原值的调用方不设置 masker
+func (m *fieldMasker) wrap(next http.Handler) http.Handler {
+    if m == nil {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if p := principalFrom(r.Context()); p != nil && p.Unmask {
+            next.ServeHTTP(w, r)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), maskerKey{}, m)))
+    })
+}
+
+// 响应不是 CI 数据（如下发的 fleet 配置），字段名碰巧相同也不能遮盖
+func withoutMasking(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        next(w, r.WithContext(context.WithValue(r.Context(), maskerKey{}, (*fieldMasker)(nil))))
+    }
+}
+
+// 调用方能看到原值时为 nil
+func maskerFrom(ctx context.Context) *fieldMasker {
+    m, _ := ctx.Value(maskerKey{}).(*fieldMasker)
+    return m
+}
+
+func (m *fieldMasker) covers(field string) bool {
+    return m != nil && m.fields[maskName(field)]
+}
+
+// 返回遮盖后的副本，v 本身不改；m 为 nil 时原样返回
+func (m *fieldMasker) mask(v any) any {
+    if m == nil || v == nil {
+        return v
+    }
+    return m.value(reflect.ValueOf(v)).Interface()
+}
+
+// 存成 JSON 文本的对象状态（history 的 after 等）
+func (m *fieldMasker) maskJSON(s string) string {
+    if m == nil || s == "" {
+        return s
+    }
+    var obj any
+    if err := json.Unmarshal([]byte(s), &obj); err != nil {
+        return s
+    }
+    b, err := json.Marshal(m.mask(obj))
+    if err != nil {
+        return s
+    }
+    return string(b)
+}
+
+// 值里是"字段名 + 值"的结构（如 compare 的 field / from / to）自己实现遮盖
+type maskable interface {
+    masked(m *fieldMasker) any
+}
+
+var (
+    marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+    maskableType  = reflect.TypeOf((*maskable)(nil)).Elem()
+)
+
+func (m *fieldMasker) value(v reflect.Value) reflect.Value {
+    t := v.Type()
+    if t.Implements(maskableType) {
+        if v.Kind() == reflect.Pointer && v.IsNil() {
+            return v
+        }
+        return reflect.ValueOf(v.Interface().(maskable).masked(m)).Convert(t)
+    }
+    if t.Implements(marshalerType) {
+        // RawJSON、json.RawMessage 解开再遮盖；time、Quantity 之类自己序列化的值没有字段
+        if t.Kind() == reflect.String {
+            return reflect.ValueOf(m.maskJSON(v.String())).Convert(t)
+        }
+        if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+            return reflect.ValueOf([]byte(m.maskJSON(string(v.Bytes())))).Convert(t)
+        }
+        return v
+    }
+    switch v.Kind() {
+    case reflect.Pointer:
+        if v.IsNil() {
+            return v
+        }
+        out := reflect.New(t.Elem())
+        out.Elem().Set(m.value(v.Elem()))
+        return out
+    case reflect.Interface:
+        if v.IsNil() {
+            return v
+        }
+        out := reflect.New(t).Elem()
+        out.Set(m.value(v.Elem()))
+        return out
+    case reflect.Struct:
+        out := reflect.New(t).Elem()
+        out.Set(v)
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+            name := strings.Split(f.Tag.Get("json"), ",")[0]
+            if !f.IsExported() || name == "-" {
+                continue
+            }
+            if name == "" {
+                name = f.Name
+            }
+            out.Field(i).Set(m.field(name, v.Field(i)))
+        }
+        return out
+    case reflect.Slice:
+        if v.IsNil() || t.Elem().Kind() == reflect.Uint8 {
+            return v
+        }
+        out := reflect.MakeSlice(t, v.Len(), v.Len())
+        for i := 0; i < v.Len(); i++ {
+            out.Index(i).Set(m.value(v.Index(i)))
+        }
+        return out
+    case reflect.Map:
+        if v.IsNil() {
+            return v
+        }
+        out := reflect.MakeMapWithSize(t, v.Len())
+        for it := v.MapRange(); it.Next(); {
+            if it.Key().Kind() == reflect.String {
+                out.SetMapIndex(it.Key(), m.field(it.Key().String(), it.Value()))
+            } else {
+                out.SetMapIndex(it.Key(), m.value(it.Value()))
+            }
+        }
+        return out
+    }
+    return v
+}
+
+// name 是 JSON 字段名或 map 的 key
+func (m *fieldMasker) field(name string, v reflect.Value) reflect.Value {
+    if m.covers(name) {
+        return m.redact(v)
+    }
+    if len(m.annotations) > 0 && maskName(name) == "annotations" {
+        return m.maskAnnotations(v)
+    }
+    return m.value(v)
+}
+
+func (m *fieldMasker) redact(v reflect.Value) reflect.Value {
+    t := v.Type()
+    switch {
+    case v.Kind() == reflect.Interface && !v.IsNil():
+        out := reflect.New(t).Elem()
+        out.Set(m.redact(v.Elem()))
+        return out
+    case t.Implements(marshalerType):
+    case v.Kind() == reflect.String:
+        return reflect.ValueOf(m.redactString(v.String())).Convert(t)
+    case v.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+        if v.IsNil() {
+            return v
+        }
+        out := reflect.MakeSlice(t, v.Len(), v.Len())
+        for i := 0; i < v.Len(); i++ {
+            out.Index(i).Set(m.redact(v.Index(i)))
+        }
+        return out
+    }
+    return reflect.Zero(t)
+}
+
+func (m *fieldMasker) redactString(s string) string {
+    if s == "" {
+        return s
+    }
+    return m.replacement
+}
+
+// map[string]string（typed 对象）或 map[string]any（JSON 解开的）
+func (m *fieldMasker) maskAnnotations(v reflect.Value) reflect.Value {
+    inner := v
+    if inner.Kind() == reflect.Interface && !inner.IsNil() {
+        inner = inner.Elem()
+    }
+    if inner.Kind() != reflect.Map || inner.Type().Key().Kind() != reflect.String || inner.IsNil() {
+        return m.value(v)
+    }
+    out := reflect.MakeMapWithSize(inner.Type(), inner.Len())
+    for it := inner.MapRange(); it.Next(); {
+        val := it.Value()
+        if m.coversAnnotation(it.Key().String()) {
+            val = m.redact(val)
+        }
+        out.SetMapIndex(it.Key(), val)
+    }
+    if v.Kind() == reflect.Interface {
+        w := reflect.New(v.Type()).Elem()
+        w.Set(out)
+        return w
+    }
+    return out
+}
+
+// 完全相同或按 path.Match 匹配（example.com/*）
+func (m *fieldMasker) coversAnnotation(key string) bool {
+    for _, p := range m.annotations {
+        if ok, _ := path.Match(p, key); ok || p == key {
+            return true
+        }
+    }
+    return false
+}
+
+type maskingListWriter struct {
+    inner listWriter
+    m     *fieldMasker
+}
+
+func (l *maskingListWriter) Write(v any) error { return l.inner.Write(l.m.mask(v)) }
+func (l *maskingListWriter) Fail(err error)    { l.inner.Fail(err) }
+func (l *maskingListWriter) Close() error      { return l.inner.Close() }
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+    "time"
+)
+
+// ---------- Metering ----------
+
+// 按月回放 changes 里 Pod 的状态变化，统计每个 namespace 的 Running 时长及其 requests 累计：
+// pod-hours、cpu-request-hours（核·小时）、memory-request-hours（GiB·小时）
+type MeteringRow struct {
+    Month              string  `json:"month"`
+    Namespace          string  `json:"namespace"`
+    Pods               int     `json:"pods"`
+    PodHours           float64 `json:"podHours"`
+    CPURequestHours    float64 `json:"cpuRequestHours"`
+    MemoryRequestHours float64 `json:"memoryRequestGiBHours"`
+}
+
+type podSnapshot struct {
+    Namespace  string `json:"namespace"`
+    Phase      string `json:"phase"`
+    CPURequest int64  `json:"cpu_request"`
+    MemRequest int64  `json:"mem_request"`
+}
+
+type meterAcc struct {
+    pods    map[string]bool
+    seconds float64
+    cpu     float64
+    mem     float64
+}
+
+type meter struct {
+    from, to time.Time
+    byNS     map[string]*meterAcc
+}
+
+// 把 [start,end) 内的状态 s 计入账，超出统计区间的部分裁掉
+func (m *meter) add(ref string, s *podSnapshot, start, end time.Time) {
+    if s == nil || s.Phase != "Running" {
+        return
+    }
+    if start.Before(m.from) {
+        start = m.from
+    }
+    if end.After(m.to) {
+        end = m.to
+    }
+    if !end.After(start) {
+        return
+    }
+    sec := end.Sub(start).Seconds()
+    a := m.byNS[s.Namespace]
+    if a == nil {
+        a = &meterAcc{pods: map[string]bool{}}
+        m.byNS[s.Namespace] = a
+    }
+    a.pods[ref] = true
+    a.seconds += sec
+    a.cpu += float64(s.CPURequest) / 1000 * sec
+    a.mem += float64(s.MemRequest) / (1 << 30) * sec
+}
+
+func computeMetering(ctx context.Context, db *sql.DB, month time.Time, scope nsScope) ([]MeteringRow, error) {
+    m := &meter{from: month, to: month.AddDate(0, 1, 0), byNS: map[string]*meterAcc{}}
+    if now := time.Now().UTC(); m.to.After(now) {
+        m.to = now
+    }
+    sc, args := scope.cond("namespace")
+    rows, err := db.QueryContext(ctx, `SELECT ref,coalesce(after,''),ts FROM changes WHERE kind='pod' AND ts<? AND `+sc+` ORDER BY ref,id`,
+        append([]any{m.to.Format(time.RFC3339)}, args...)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var (
+        curRef   string
+        curState *podSnapshot
+        curSince time.Time
+    )
+    for rows.Next() {
+        var ref, after, ts string
+        if err := rows.Scan(&ref, &after, &ts); err != nil {
+            return nil, err
+        }
+        t, err := time.Parse(time.RFC3339, ts)
+        if err != nil {
+            continue
+        }
+        if ref != curRef {
+            // 上一个对象的最后状态一直持续到统计区间结束
+            m.add(curRef, curState, curSince, m.to)
+            curRef, curState = ref, nil
+        } else {
+            m.add(curRef, curState, curSince, t)
+        }
+        curSince = t
+        curState = nil
+        if after != "" {
+            var s podSnapshot
+            if err := json.Unmarshal([]byte(after), &s); err != nil {
+                log.Printf("[metering] bad snapshot for %s: %v", ref, err)
+                continue
+            }
+            curState = &s
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    m.add(curRef, curState, curSince, m.to)
+
+    label := month.Format("2006-01")
+    out := make([]MeteringRow, 0, len(m.byNS))
+    for ns, a := range m.byNS {
+        out = append(out, MeteringRow{
+            Month:              label,
+            Namespace:          ns,
+            Pods:               len(a.pods),
+            PodHours:           round3(a.seconds / 3600),
+            CPURequestHours:    round3(a.cpu / 3600),
+            MemoryRequestHours: round3(a.mem / 3600),
+        })
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Namespace < out[j].Namespace })
+    return out, nil
+}
+
+func round3(v float64) float64 {
+    return math.Round(v*1000) / 1000
+}
+
+// GET /cmdb/metering?month=2024-06[&format=csv]
+func meteringAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        month := time.Now().UTC()
+        month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+        if v := r.URL.Query().Get("month"); v != "" {
+            t, err := time.Parse("2006-01", v)
+            if err != nil {
+                http.Error(w, "month must be YYYY-MM", 400)
+                return
+            }
+            month = t
+        }
+        out, err := computeMetering(r.Context(), db, month, scopeOf(r.Context()))
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "metering-"+month.Format("2006-01"), MeteringRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write metering: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
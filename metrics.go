@@ -0,0 +1,434 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ---------- Metrics ----------
+
+// 手写的一个最小 registry，不引入 client_golang。Accept 带 application/openmetrics-text 时
+// 输出 OpenMetrics（含 exemplar），否则输出 Prometheus 文本格式。
+type metricLabel struct {
+    Name, Value string
+}
+
+type exemplar struct {
+    Labels []metricLabel
+    Value  float64
+    TS     time.Time
+}
+
+type metricSample struct {
+    Labels   []metricLabel
+    Value    float64
+    Exemplar *exemplar
+    // histogram 的 _bucket / _sum / _count
+    Suffix string
+}
+
+type metricFamily struct {
+    Name    string // counter 不带 _total
+    Help    string
+    Type    string // counter / gauge / histogram
+    Collect func() []metricSample
+}
+
+type metricsRegistry struct {
+    mu       sync.Mutex
+    families []metricFamily
+}
+
+var metrics = &metricsRegistry{}
+
+func (m *metricsRegistry) register(f metricFamily) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.families = append(m.families, f)
+}
+
+func formatLabels(labels []metricLabel) string {
+    if len(labels) == 0 {
+        return ""
+    }
+    parts := make([]string, 0, len(labels))
+    for _, l := range labels {
+        v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l.Value)
+        parts = append(parts, l.Name+`="`+v+`"`)
+    }
+    return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+    switch {
+    case math.IsInf(v, 1):
+        return "+Inf"
+    case math.IsInf(v, -1):
+        return "-Inf"
+    case math.IsNaN(v):
+        return "NaN"
+    }
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    open := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+    m.mu.Lock()
+    families := append([]metricFamily(nil), m.families...)
+    m.mu.Unlock()
+    var b strings.Builder
+    for _, f := range families {
+        sample := f.Name
+        if f.Type == "counter" {
+            sample += "_total"
+        }
+        if open {
+            fmt.Fprintf(&b, "# TYPE %s %s\n# HELP %s %s\n", f.Name, f.Type, f.Name, f.Help)
+        } else {
+            fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", sample, f.Help, sample, f.Type)
+        }
+        for _, s := range f.Collect() {
+            fmt.Fprintf(&b, "%s%s %s", sample+s.Suffix, formatLabels(s.Labels), formatFloat(s.Value))
+            if open && s.Exemplar != nil {
+                fmt.Fprintf(&b, " # %s %s %.3f", formatLabels(s.Exemplar.Labels), formatFloat(s.Exemplar.Value),
+                    float64(s.Exemplar.TS.UnixMilli())/1000)
+            }
+            b.WriteByte('\n')
+        }
+    }
+    if open {
+        b.WriteString("# EOF\n")
+        w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+    } else {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    }
+    w.Write([]byte(b.String()))
+}
+
+// 固定桶的 histogram，调用方自己加锁
+type histogram struct {
+    bounds []float64
+    counts []uint64 // 每个桶单独计数，输出时累加
+    sum    float64
+    count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+    return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+    h.sum += v
+    h.count++
+    if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+        h.counts[i]++
+    }
+}
+
+func (h *histogram) samples(labels ...metricLabel) []metricSample {
+    out := make([]metricSample, 0, len(h.bounds)+3)
+    var cum uint64
+    for i, le := range h.bounds {
+        cum += h.counts[i]
+        out = append(out, metricSample{Suffix: "_bucket", Labels: append(slices.Clip(labels), metricLabel{"le", formatFloat(le)}), Value: float64(cum)})
+    }
+    out = append(out, metricSample{Suffix: "_bucket", Labels: append(slices.Clip(labels), metricLabel{"le", "+Inf"}), Value: float64(h.count)},
+        metricSample{Suffix: "_sum", Labels: labels, Value: h.sum},
+        metricSample{Suffix: "_count", Labels: labels, Value: float64(h.count)})
+    return out
+}
+
+// ---------- Change rate ----------
+
+// 变更记录由触发器写入，这里在每次抓取时增量读取 changes（id > 上次读到的位置）计数，
+// exemplar 带上该序列最新一条记录的 change_id/ref，Grafana 里点尖峰可以直接跳到 /cmdb/history?id=...
+type changeCounter struct {
+    value float64
+    ex    *exemplar
+}
+
+type changeMetrics struct {
+    db     *sql.DB
+    mu     sync.Mutex
+    lastID int64
+    counts map[[2]string]*changeCounter
+}
+
+// 计数从进程启动开始，历史记录不计入
+func newChangeMetrics(db *sql.DB) (*changeMetrics, error) {
+    c := &changeMetrics{db: db, counts: map[[2]string]*changeCounter{}}
+    if err := db.QueryRow(`SELECT coalesce(max(id),0) FROM changes`).Scan(&c.lastID); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+func (c *changeMetrics) refresh() error {
+    // SQLite 的 max() 聚合会让同一行的 ts/ref 取自 id 最大的那条记录
+    rows, err := c.db.Query(`SELECT kind,op,count(*),max(id),ts,ref FROM changes WHERE id>? GROUP BY kind,op`, c.lastID)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+    last := c.lastID
+    for rows.Next() {
+        var kind, op, ts, ref string
+        var n, maxID int64
+        if err := rows.Scan(&kind, &op, &n, &maxID, &ts, &ref); err != nil {
+            return err
+        }
+        k := [2]string{kind, op}
+        cnt := c.counts[k]
+        if cnt == nil {
+            cnt = &changeCounter{}
+            c.counts[k] = cnt
+        }
+        cnt.value += float64(n)
+        t, _ := time.Parse(time.RFC3339, ts)
+        cnt.ex = &exemplar{
+            Labels: []metricLabel{{"change_id", strconv.FormatInt(maxID, 10)}, {"ref", ref}},
+            Value:  1,
+            TS:     t,
+        }
+        last = max(last, maxID)
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    c.lastID = last
+    return nil
+}
+
+func (c *changeMetrics) collect() []metricSample {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if err := c.refresh(); err != nil {
+        log.Printf("[metrics] changes: %v", err)
+    }
+    keys := make([][2]string, 0, len(c.counts))
+    for k := range c.counts {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i][0] != keys[j][0] {
+            return keys[i][0] < keys[j][0]
+        }
+        return keys[i][1] < keys[j][1]
+    })
+    out := make([]metricSample, 0, len(keys))
+    for _, k := range keys {
+        cnt := c.counts[k]
+        out = append(out, metricSample{
+            Labels:   []metricLabel{{"kind", k[0]}, {"op", k[1]}},
+            Value:    cnt.value,
+            Exemplar: cnt.ex,
+        })
+    }
+    return out
+}
+
+// ---------- Inventory gauges ----------
+
+// CMDB 眼里的集群状态，每次抓取时从 DB 现查，方便直接写 Prometheus 告警规则，
+// 例如 lightcmdb_inventory_nodes_not_ready > 0、lightcmdb_inventory_pods_pending_oldest_seconds > 900。
+// Pod 的 pending 时长从 CMDB 第一次看到它（created_at）算起。
+func registerInventoryMetrics(m *metricsRegistry, db *sql.DB) {
+    m.register(metricFamily{Name: "lightcmdb_inventory_pods", Type: "gauge",
+        Help: "Pods in the CMDB, by namespace and phase",
+        Collect: func() []metricSample {
+            rows, err := db.Query(`SELECT coalesce(namespace,''),coalesce(nullif(phase,''),'Unknown'),count(*) FROM pods GROUP BY 1,2 ORDER BY 1,2`)
+            if err != nil {
+                log.Printf("[metrics] inventory pods: %v", err)
+                return nil
+            }
+            defer rows.Close()
+            var out []metricSample
+            for rows.Next() {
+                var ns, phase string
+                var n int
+                if err := rows.Scan(&ns, &phase, &n); err != nil {
+                    log.Printf("[metrics] inventory pods: %v", err)
+                    return out
+                }
+                out = append(out, metricSample{Labels: []metricLabel{{"namespace", ns}, {"phase", phase}}, Value: float64(n)})
+            }
+            if err := rows.Err(); err != nil {
+                log.Printf("[metrics] inventory pods: %v", err)
+            }
+            return out
+        }})
+    pending := func(oldest bool) func() []metricSample {
+        return func() []metricSample {
+            rows, err := db.Query(`SELECT coalesce(namespace,''),sum(phase='Pending'),coalesce(min(CASE WHEN phase='Pending' THEN created_at END),'')
+ FROM pods GROUP BY 1 ORDER BY 1`)
+            if err != nil {
+                log.Printf("[metrics] inventory pending: %v", err)
+                return nil
+            }
+            defer rows.Close()
+            var out []metricSample
+            for rows.Next() {
+                var ns, first string
+                var n int
+                if err := rows.Scan(&ns, &n, &first); err != nil {
+                    log.Printf("[metrics] inventory pending: %v", err)
+                    return out
+                }
+                labels := []metricLabel{{"namespace", ns}}
+                switch {
+                case !oldest:
+                    // 没有 pending 的 namespace 也输出 0，告警规则不用处理缺失的序列
+                    out = append(out, metricSample{Labels: labels, Value: float64(n)})
+                case n > 0:
+                    if t, err := time.Parse(time.RFC3339, first); err == nil {
+                        out = append(out, metricSample{Labels: labels, Value: math.Max(0, time.Since(t).Seconds())})
+                    }
+                }
+            }
+            if err := rows.Err(); err != nil {
+                log.Printf("[metrics] inventory pending: %v", err)
+            }
+            return out
+        }
+    }
+    m.register(metricFamily{Name: "lightcmdb_inventory_pods_orphaned", Type: "gauge",
+        Help: "Pods whose node is not in the CMDB (see /cmdb/stats consistency)",
+        Collect: func() []metricSample {
+            var n int
+            if err := db.QueryRow(`SELECT count(*) FROM pods WHERE ` + orphanPodCond).Scan(&n); err != nil {
+                log.Printf("[metrics] inventory orphaned pods: %v", err)
+                return nil
+            }
+            return []metricSample{{Value: float64(n)}}
+        }})
+    m.register(metricFamily{Name: "lightcmdb_inventory_pods_pending", Type: "gauge",
+        Help: "Pending pods, by namespace (0 for namespaces with pods but none pending)", Collect: pending(false)})
+    m.register(metricFamily{Name: "lightcmdb_inventory_pods_pending_oldest_seconds", Type: "gauge",
+        Help: "Seconds since the CMDB first saw the oldest pending pod, by namespace", Collect: pending(true)})
+    nodes := func() (map[string]int, error) {
+        rows, err := db.Query(`SELECT CASE WHEN ready IN ('true','false') THEN ready ELSE 'unknown' END,count(*) FROM nodes GROUP BY 1`)
+        if err != nil {
+            return nil, err
+        }
+        defer rows.Close()
+        counts := map[string]int{"true": 0, "false": 0, "unknown": 0}
+        for rows.Next() {
+            var ready string
+            var n int
+            if err := rows.Scan(&ready, &n); err != nil {
+                return nil, err
+            }
+            counts[ready] = n
+        }
+        return counts, rows.Err()
+    }
+    m.register(metricFamily{Name: "lightcmdb_inventory_nodes", Type: "gauge",
+        Help: "Nodes in the CMDB, by Ready condition (true, false, unknown)",
+        Collect: func() []metricSample {
+            counts, err := nodes()
+            if err != nil {
+                log.Printf("[metrics] inventory nodes: %v", err)
+                return nil
+            }
+            var out []metricSample
+            for _, ready := range []string{"false", "true", "unknown"} {
+                out = append(out, metricSample{Labels: []metricLabel{{"ready", ready}}, Value: float64(counts[ready])})
+            }
+            return out
+        }})
+    m.register(metricFamily{Name: "lightcmdb_inventory_nodes_not_ready", Type: "gauge",
+        Help: "Nodes whose Ready condition is not true",
+        Collect: func() []metricSample {
+            counts, err := nodes()
+            if err != nil {
+                log.Printf("[metrics] inventory nodes: %v", err)
+                return nil
+            }
+            return []metricSample{{Value: float64(counts["false"] + counts["unknown"])}}
+        }})
+    registerNodeResourceMetrics(m, db)
+}
+
+// 每个节点的容量、运行中 Pod 的 requests 之和，以及 metrics-server 的用量（没有采样的节点不输出），
+// 配合 remote-write（见 remotewrite.go）保留长期的容量和利用率趋势
+func registerNodeResourceMetrics(m *metricsRegistry, db *sql.DB) {
+    type nodeResources struct {
+        name               string
+        cpuCap, memCap     float64
+        cpuReq, memReq     float64
+        cpuUsage, memUsage sql.NullFloat64
+    }
+    load := func() ([]nodeResources, error) {
+        rows, err := db.Query(`SELECT n.name,coalesce(n.capacity_cpu,''),coalesce(n.capacity_mem,''),
+ coalesce(sum(p.cpu_request),0),coalesce(sum(p.mem_request),0),u.cpu_milli,u.mem_bytes
+ FROM nodes n LEFT JOIN pods p ON p.node_name=n.name AND coalesce(p.phase,'') NOT IN ('Succeeded','Failed')
+ LEFT JOIN node_usage u ON u.name=n.name GROUP BY n.name ORDER BY n.name`)
+        if err != nil {
+            return nil, err
+        }
+        defer rows.Close()
+        var out []nodeResources
+        for rows.Next() {
+            var r nodeResources
+            var cpu, mem string
+            if err := rows.Scan(&r.name, &cpu, &mem, &r.cpuReq, &r.memReq, &r.cpuUsage, &r.memUsage); err != nil {
+                return nil, err
+            }
+            if v, err := resource.ParseQuantity(cpu); err == nil {
+                r.cpuCap = float64(v.MilliValue()) / 1000
+            }
+            if v, err := resource.ParseQuantity(mem); err == nil {
+                r.memCap = float64(v.Value())
+            }
+            r.cpuReq /= 1000
+            r.cpuUsage.Float64 /= 1000
+            out = append(out, r)
+        }
+        return out, rows.Err()
+    }
+    for _, f := range []struct {
+        name, help string
+        value      func(r nodeResources) (float64, bool)
+    }{
+        {"lightcmdb_inventory_node_cpu_capacity_cores", "CPU capacity of each node",
+            func(r nodeResources) (float64, bool) { return r.cpuCap, true }},
+        {"lightcmdb_inventory_node_memory_capacity_bytes", "Memory capacity of each node",
+            func(r nodeResources) (float64, bool) { return r.memCap, true }},
+        {"lightcmdb_inventory_node_cpu_requests_cores", "CPU requests of the pods on each node that have not finished",
+            func(r nodeResources) (float64, bool) { return r.cpuReq, true }},
+        {"lightcmdb_inventory_node_memory_requests_bytes", "Memory requests of the pods on each node that have not finished",
+            func(r nodeResources) (float64, bool) { return r.memReq, true }},
+        {"lightcmdb_inventory_node_cpu_usage_cores", "CPU usage of each node from the last metrics-server sample",
+            func(r nodeResources) (float64, bool) { return r.cpuUsage.Float64, r.cpuUsage.Valid }},
+        {"lightcmdb_inventory_node_memory_usage_bytes", "Memory usage of each node from the last metrics-server sample",
+            func(r nodeResources) (float64, bool) { return r.memUsage.Float64, r.memUsage.Valid }},
+    } {
+        f := f
+        m.register(metricFamily{Name: f.name, Type: "gauge", Help: f.help,
+            Collect: func() []metricSample {
+                list, err := load()
+                if err != nil {
+                    log.Printf("[metrics] inventory node resources: %v", err)
+                    return nil
+                }
+                var out []metricSample
+                for _, r := range list {
+                    if v, ok := f.value(r); ok {
+                        out = append(out, metricSample{Labels: []metricLabel{{"node", r.name}}, Value: v})
+                    }
+                }
+                return out
+            }})
+    }
+}
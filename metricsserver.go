@@ -0,0 +1,292 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/resource"
+    "k8s.io/client-go/kubernetes"
+)
+
+// ---------- Node / Pod usage (metrics-server) ----------
+
+// 开启 metricsServer.interval 后定时拉 metrics.k8s.io 的节点和 Pod 用量：节点存到 node_usage，/cmdb/nodes 按 capacity 算出利用率；
+// Pod 存到 pod_usage（容器之和），/cmdb/pods 和 requests 一起返回。
+// 只记最新一次采样：metrics-server 不返回的节点（NotReady 等）/ Pod 删掉，不展示过期的用量。
+// metricsServer.podHistory 非空时另外在 pod_usage_samples 里保留这段时间内的每次采样，/cmdb/pods/usage 按 Pod 返回。
+// 用量不是对象本身的变化，不进 history，也不改 updated_at。需要 nodes/pods.metrics.k8s.io 的 list 权限。
+const (
+    nodeMetricsPath = "/apis/metrics.k8s.io/v1beta1/nodes"
+    podMetricsPath  = "/apis/metrics.k8s.io/v1beta1/pods"
+)
+
+func initNodeUsage(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS node_usage(
+    name TEXT PRIMARY KEY,
+    cpu_milli INTEGER NOT NULL,
+    mem_bytes INTEGER NOT NULL,
+    window_s INTEGER,
+    sampled_at TEXT NOT NULL
+);`, `
+CREATE TABLE IF NOT EXISTS pod_usage(
+    uid TEXT PRIMARY KEY,
+    cpu_milli INTEGER NOT NULL,
+    mem_bytes INTEGER NOT NULL,
+    sampled_at TEXT NOT NULL
+);`, `
+CREATE TABLE IF NOT EXISTS pod_usage_samples(
+    uid TEXT NOT NULL,
+    sampled_at TEXT NOT NULL,
+    cpu_milli INTEGER NOT NULL,
+    mem_bytes INTEGER NOT NULL,
+    PRIMARY KEY(uid, sampled_at)
+);`,
+        `CREATE INDEX IF NOT EXISTS pod_usage_samples_ts ON pod_usage_samples(sampled_at)`,
+        `DROP TRIGGER IF EXISTS nodes_usage_ad`,
+        `CREATE TRIGGER nodes_usage_ad AFTER DELETE ON nodes BEGIN
+ DELETE FROM node_usage WHERE name=old.name; END`,
+        `DROP TRIGGER IF EXISTS pods_usage_ad`,
+        `CREATE TRIGGER pods_usage_ad AFTER DELETE ON pods BEGIN
+ DELETE FROM pod_usage WHERE uid=old.uid; DELETE FROM pod_usage_samples WHERE uid=old.uid; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// metrics.k8s.io/v1beta1 NodeMetricsList / PodMetricsList 里用到的字段；不为这几个字段引入 k8s.io/metrics
+type nodeMetricsList struct {
+    Items []struct {
+        Metadata struct {
+            Name string `json:"name"`
+        } `json:"metadata"`
+        Timestamp time.Time         `json:"timestamp"`
+        Window    string            `json:"window"`
+        Usage     map[string]string `json:"usage"`
+    } `json:"items"`
+}
+
+type podMetricsList struct {
+    Items []struct {
+        Metadata struct {
+            Name      string `json:"name"`
+            Namespace string `json:"namespace"`
+        } `json:"metadata"`
+        Timestamp  time.Time `json:"timestamp"`
+        Containers []struct {
+            Usage map[string]string `json:"usage"`
+        } `json:"containers"`
+    } `json:"items"`
+}
+
+type metricsPoller struct {
+    db     *sql.DB
+    client kubernetes.Interface
+    hot    *hotReadModel
+    // 0 表示不保留 Pod 用量历史
+    podHistory time.Duration
+    // 和上次一样的错误不重复打日志（没装 metrics-server 时每轮都会失败）
+    lastErr string
+}
+
+func (p *metricsPoller) get(ctx context.Context, path string, out any) error {
+    // clientset 默认要 protobuf（见 informertrim.go），这里按 JSON 解码
+    raw, err := p.client.CoreV1().RESTClient().Get().AbsPath(path).SetHeader("Accept", "application/json").Do(ctx).Raw()
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(raw, out)
+}
+
+func usageOf(u map[string]string) (cpu, mem int64, err error) {
+    c, err := resource.ParseQuantity(u["cpu"])
+    if err != nil {
+        return 0, 0, err
+    }
+    m, err := resource.ParseQuantity(u["memory"])
+    if err != nil {
+        return 0, 0, err
+    }
+    return c.MilliValue(), m.Value(), nil
+}
+
+func (p *metricsPoller) pollNodes(ctx context.Context) error {
+    var list nodeMetricsList
+    if err := p.get(ctx, nodeMetricsPath, &list); err != nil {
+        return err
+    }
+    tx, err := p.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if _, err := tx.Exec(`DELETE FROM node_usage`); err != nil {
+        return err
+    }
+    for _, it := range list.Items {
+        cpu, mem, err := usageOf(it.Usage)
+        if err != nil {
+            continue
+        }
+        var window int64
+        if d, err := time.ParseDuration(it.Window); err == nil {
+            window = int64(d.Seconds())
+        }
+        if _, err := tx.Exec(`INSERT OR REPLACE INTO node_usage(name,cpu_milli,mem_bytes,window_s,sampled_at) VALUES(?,?,?,?,?)`,
+            it.Metadata.Name, cpu, mem, window, it.Timestamp.UTC().Format(time.RFC3339)); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// PodMetrics 只有 namespace/name，按当前的 pods 行对到 uid；还没写库的 Pod 下一轮再记
+func (p *metricsPoller) pollPods(ctx context.Context) error {
+    var list podMetricsList
+    if err := p.get(ctx, podMetricsPath, &list); err != nil {
+        return err
+    }
+    tx, err := p.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if _, err := tx.Exec(`DELETE FROM pod_usage`); err != nil {
+        return err
+    }
+    for _, it := range list.Items {
+        var cpu, mem int64
+        ok := true
+        for _, c := range it.Containers {
+            cc, cm, err := usageOf(c.Usage)
+            if err != nil {
+                ok = false
+                break
+            }
+            cpu, mem = cpu+cc, mem+cm
+        }
+        if !ok {
+            continue
+        }
+        ts := it.Timestamp.UTC().Format(time.RFC3339)
+        if _, err := tx.Exec(`INSERT OR REPLACE INTO pod_usage(uid,cpu_milli,mem_bytes,sampled_at)
+ SELECT uid,?,?,? FROM pods WHERE namespace=? AND name=?`, cpu, mem, ts, it.Metadata.Namespace, it.Metadata.Name); err != nil {
+            return err
+        }
+    }
+    if p.podHistory > 0 {
+        // metrics-server 的采样没更新时 timestamp 不变，主键去重
+        if _, err := tx.Exec(`INSERT OR IGNORE INTO pod_usage_samples(uid,sampled_at,cpu_milli,mem_bytes)
+ SELECT uid,sampled_at,cpu_milli,mem_bytes FROM pod_usage`); err != nil {
+            return err
+        }
+        cutoff := time.Now().Add(-p.podHistory).UTC().Format(time.RFC3339)
+        if _, err := tx.Exec(`DELETE FROM pod_usage_samples WHERE sampled_at<?`, cutoff); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func (p *metricsPoller) poll(ctx context.Context) error {
+    err := errors.Join(p.pollNodes(ctx), p.pollPods(ctx))
+    // 用量大批变化，逐行刷新不如整体重载
+    p.hot.reloadAfter("metrics-server")
+    return err
+}
+
+// 给 periodicCollector 用，同样的错误不重复打日志
+func (p *metricsPoller) collectOnce(ctx context.Context) error {
+    err := p.poll(ctx)
+    switch {
+    case err != nil && err.Error() != p.lastErr:
+        log.Printf("[metrics-server] %v", err)
+        p.lastErr = err.Error()
+    case err == nil && p.lastErr != "":
+        log.Printf("[metrics-server] usage available again")
+        p.lastErr = ""
+    }
+    return err
+}
+
+// 用量占 capacity 的百分比，保留一位小数；capacity 解析不了时为 0
+func utilizationPercent(used int64, capacity string, milli bool) float64 {
+    q, err := resource.ParseQuantity(capacity)
+    if err != nil {
+        return 0
+    }
+    total := q.Value()
+    if milli {
+        total = q.MilliValue()
+    }
+    if total <= 0 {
+        return 0
+    }
+    return math.Round(float64(used)/float64(total)*1000) / 10
+}
+
+type PodUsageSample struct {
+    SampledAt        string `json:"sampledAt"`
+    CPUUsageMilli    int64  `json:"cpuUsageMilli"`
+    MemoryUsageBytes int64  `json:"memoryUsageBytes"`
+}
+
+// GET /cmdb/pods/usage?uid=  或 ?ns=&name=，按时间先后
+func podUsageAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        scope := scopeOf(r.Context())
+        var where string
+        var args []any
+        switch {
+        case q.Get("uid") != "":
+            where, args = scope.where("p.namespace", "p.uid=?", q.Get("uid"))
+        case q.Get("ns") != "" && q.Get("name") != "":
+            where, args = scope.where("p.namespace", "p.namespace=? AND p.name=?", q.Get("ns"), q.Get("name"))
+        default:
+            http.Error(w, "uid or ns and name required", 400)
+            return
+        }
+        rows, err := db.QueryContext(r.Context(), `SELECT s.sampled_at,s.cpu_milli,s.mem_bytes FROM pod_usage_samples s JOIN pods p ON p.uid=s.uid`+where+
+            ` ORDER BY s.sampled_at`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "pod-usage", PodUsageSample{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var s PodUsageSample
+            if err := rows.Scan(&s.SampledAt, &s.CPUUsageMilli, &s.MemoryUsageBytes); err != nil {
+                lw.Fail(err)
+                return
+            }
+            if err := lw.Write(s); err != nil {
+                log.Printf("[http] write pod usage: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
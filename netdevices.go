@@ -0,0 +1,578 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/netip"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ---------- Network device discovery (SNMP) ----------
+
+// 交换机、路由器等网络设备：按 snmp.targets（IP、主机名、host:port 或 CIDR）逐台用 SNMPv2c 读
+// sysName / sysDescr / sysObjectID、ENTITY-MIB 的序列号、接口表、设备自己的 IP 和 ARP 表，写到 network_devices
+// 和 network_interfaces（按 address 区分）。ARP 表里的 IP 等于某个节点的 internal IP 时，记一条设备-节点的链路
+// （network_links，带接口名和 MAC），拓扑里是 netdevice/<address> -connects-> node/<name>。
+// 单独列出的设备没有响应时记下 error、保留上次的结果；CIDR 里不响应的地址直接跳过。
+// 设备信息的变化进 history（kind=network-device，source=snmp-discovery），接口状态和链路不进。
+
+var (
+    oidSysDescr    = parseOID("1.3.6.1.2.1.1.1.0")
+    oidSysObjectID = parseOID("1.3.6.1.2.1.1.2.0")
+    oidSysName     = parseOID("1.3.6.1.2.1.1.5.0")
+    // IF-MIB ifTable / ifXTable 的列，index 为 ifIndex
+    oidIfDescr       = parseOID("1.3.6.1.2.1.2.2.1.2")
+    oidIfSpeed       = parseOID("1.3.6.1.2.1.2.2.1.5")
+    oidIfPhysAddress = parseOID("1.3.6.1.2.1.2.2.1.6")
+    oidIfAdminStatus = parseOID("1.3.6.1.2.1.2.2.1.7")
+    oidIfOperStatus  = parseOID("1.3.6.1.2.1.2.2.1.8")
+    oidIfName        = parseOID("1.3.6.1.2.1.31.1.1.1.1")
+    oidIfHighSpeed   = parseOID("1.3.6.1.2.1.31.1.1.1.15")
+    oidIfAlias       = parseOID("1.3.6.1.2.1.31.1.1.1.18")
+    // ipAdEntIfIndex，index 为设备自己的 IPv4 地址
+    oidIPAdEntIfIndex = parseOID("1.3.6.1.2.1.4.20.1.2")
+    // ipNetToMediaPhysAddress，index 为 ifIndex.a.b.c.d
+    oidIPNetToMediaPhysAddress = parseOID("1.3.6.1.2.1.4.22.1.2")
+    // ENTITY-MIB entPhysicalClass（3 为 chassis）和 entPhysicalSerialNum
+    oidEntPhysicalClass     = parseOID("1.3.6.1.2.1.47.1.1.1.1.5")
+    oidEntPhysicalSerialNum = parseOID("1.3.6.1.2.1.47.1.1.1.1.11")
+)
+
+func initNetworkDevices(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS network_devices(
+    address TEXT PRIMARY KEY,
+    sys_name TEXT NOT NULL DEFAULT '',
+    sys_descr TEXT NOT NULL DEFAULT '',
+    sys_object_id TEXT NOT NULL DEFAULT '',
+    serial TEXT NOT NULL DEFAULT '',
+    ips TEXT NOT NULL DEFAULT '',
+    last_seen TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS network_interfaces(
+    device TEXT NOT NULL,
+    if_index INTEGER NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    descr TEXT NOT NULL DEFAULT '',
+    alias TEXT NOT NULL DEFAULT '',
+    mac TEXT NOT NULL DEFAULT '',
+    speed_mbps INTEGER NOT NULL DEFAULT 0,
+    admin_up INTEGER NOT NULL DEFAULT 0,
+    oper_up INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY(device, if_index)
+);`, `
+CREATE TABLE IF NOT EXISTS network_links(
+    device TEXT NOT NULL,
+    node TEXT NOT NULL,
+    interface TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL,
+    mac TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY(device, node, ip)
+);`,
+        `CREATE INDEX IF NOT EXISTS network_links_node ON network_links(node)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type NetworkInterface struct {
+    Index     int    `json:"index"`
+    Name      string `json:"name"`
+    Descr     string `json:"descr"`
+    Alias     string `json:"alias"`
+    MAC       string `json:"mac"`
+    SpeedMbps int64  `json:"speedMbps"`
+    AdminUp   bool   `json:"adminUp"`
+    OperUp    bool   `json:"operUp"`
+}
+
+// ifName，老设备没有 ifXTable 时用 ifDescr
+func (i NetworkInterface) label() string {
+    if i.Name != "" {
+        return i.Name
+    }
+    return i.Descr
+}
+
+type netARPEntry struct {
+    ifIndex int
+    ip, mac string
+}
+
+type netDeviceFacts struct {
+    SysName, SysDescr, SysObjectID, Serial string
+    // 逗号分隔
+    IPs        string
+    Interfaces []NetworkInterface
+    arp        []netARPEntry
+}
+
+func formatMAC(b []byte) string {
+    if len(b) != 6 {
+        return ""
+    }
+    return net.HardwareAddr(b).String()
+}
+
+// OID 最后 4 段是 IPv4 地址
+func oidIPv4(o snmpOID) (string, bool) {
+    if len(o) < 4 {
+        return "", false
+    }
+    var b [4]byte
+    for i, n := range o[len(o)-4:] {
+        if n > 255 {
+            return "", false
+        }
+        b[i] = byte(n)
+    }
+    return netip.AddrFrom4(b).String(), true
+}
+
+type netDeviceDiscoverer struct {
+    db      *sql.DB
+    cfg     SNMPConfig
+    targets []discoveryTarget
+}
+
+func newNetDeviceDiscoverer(db *sql.DB, cfg SNMPConfig) (*netDeviceDiscoverer, error) {
+    targets, err := expandTargets(cfg.Targets, "161")
+    if err != nil {
+        return nil, err
+    }
+    return &netDeviceDiscoverer{db: db, cfg: cfg, targets: targets}, nil
+}
+
+func (d *netDeviceDiscoverer) collect(t discoveryTarget) (netDeviceFacts, error) {
+    var f netDeviceFacts
+    c, err := dialSNMP(t.dial, d.cfg.Community, d.cfg.Timeout.Duration, d.cfg.Retries)
+    if err != nil {
+        return f, err
+    }
+    defer c.Close()
+    vars, err := c.get(oidSysDescr, oidSysObjectID, oidSysName)
+    if err != nil {
+        return f, err
+    }
+    for _, v := range vars {
+        switch {
+        case v.missing():
+        case v.OID.compare(oidSysDescr) == 0:
+            f.SysDescr = v.text()
+        case v.OID.compare(oidSysObjectID) == 0:
+            f.SysObjectID = v.ObjectID.String()
+        case v.OID.compare(oidSysName) == 0:
+            f.SysName = v.text()
+        }
+    }
+    ifaces := map[int]*NetworkInterface{}
+    iface := func(v snmpVar) *NetworkInterface {
+        i := int(v.OID[len(v.OID)-1])
+        if ifaces[i] == nil {
+            ifaces[i] = &NetworkInterface{Index: i}
+        }
+        return ifaces[i]
+    }
+    highSpeed := map[int]bool{}
+    walks := []struct {
+        root snmpOID
+        fn   func(snmpVar)
+    }{
+        {oidIfDescr, func(v snmpVar) { iface(v).Descr = v.text() }},
+        {oidIfName, func(v snmpVar) { iface(v).Name = v.text() }},
+        {oidIfAlias, func(v snmpVar) { iface(v).Alias = v.text() }},
+        {oidIfPhysAddress, func(v snmpVar) { iface(v).MAC = formatMAC(v.Bytes) }},
+        {oidIfAdminStatus, func(v snmpVar) { iface(v).AdminUp = v.Int == 1 }},
+        {oidIfOperStatus, func(v snmpVar) { iface(v).OperUp = v.Int == 1 }},
+        // ifSpeed 单位 bit/s，超过 4Gbit/s 封顶，有 ifHighSpeed（Mbit/s）时用它
+        {oidIfHighSpeed, func(v snmpVar) {
+            i := iface(v)
+            i.SpeedMbps = v.Int
+            highSpeed[i.Index] = true
+        }},
+        {oidIfSpeed, func(v snmpVar) {
+            if i := iface(v); !highSpeed[i.Index] {
+                i.SpeedMbps = v.Int / 1_000_000
+            }
+        }},
+        {oidIPAdEntIfIndex, func(v snmpVar) {
+            if ip, ok := oidIPv4(v.OID); ok && !strings.HasPrefix(ip, "127.") {
+                f.IPs += "," + ip
+            }
+        }},
+        {oidIPNetToMediaPhysAddress, func(v snmpVar) {
+            ip, ok := oidIPv4(v.OID)
+            if ok && len(v.OID) == len(oidIPNetToMediaPhysAddress)+5 {
+                f.arp = append(f.arp, netARPEntry{ifIndex: int(v.OID[len(v.OID)-5]), ip: ip, mac: formatMAC(v.Bytes)})
+            }
+        }},
+    }
+    for _, w := range walks {
+        if err := c.walk(w.root, w.fn); err != nil {
+            return f, fmt.Errorf("walk %s: %w", w.root, err)
+        }
+    }
+    f.IPs = strings.TrimPrefix(f.IPs, ",")
+    // 优先取 chassis 的序列号
+    class := map[uint32]int64{}
+    var serials []snmpVar
+    if err := c.walk(oidEntPhysicalClass, func(v snmpVar) { class[v.OID[len(v.OID)-1]] = v.Int }); err != nil {
+        return f, fmt.Errorf("walk %s: %w", oidEntPhysicalClass, err)
+    }
+    if err := c.walk(oidEntPhysicalSerialNum, func(v snmpVar) {
+        if v.text() != "" {
+            serials = append(serials, v)
+        }
+    }); err != nil {
+        return f, fmt.Errorf("walk %s: %w", oidEntPhysicalSerialNum, err)
+    }
+    for _, v := range serials {
+        if class[v.OID[len(v.OID)-1]] == 3 {
+            f.Serial = v.text()
+            break
+        }
+    }
+    if f.Serial == "" && len(serials) > 0 {
+        f.Serial = serials[0].text()
+    }
+    for _, i := range ifaces {
+        f.Interfaces = append(f.Interfaces, *i)
+    }
+    sort.Slice(f.Interfaces, func(a, b int) bool { return f.Interfaces[a].Index < f.Interfaces[b].Index })
+    return f, nil
+}
+
+// nodeIPs：internal IP -> 节点名，每轮开始时读一次
+func (d *netDeviceDiscoverer) store(address string, f netDeviceFacts, scanErr error, nodeIPs map[string]string) error {
+    now := time.Now().UTC().Format(time.RFC3339)
+    return withChangeSource(d.db, "snmp-discovery", func(tx *sql.Tx) error {
+        if scanErr != nil {
+            // 保留上次成功时的信息
+            _, err := tx.Exec(`INSERT INTO network_devices(address,error,created_at,updated_at) VALUES(?,?,?,?)
+ON CONFLICT(address) DO UPDATE SET error=excluded.error`, address, scanErr.Error(), now, now)
+            return err
+        }
+        if _, err := tx.Exec(`
+INSERT INTO network_devices(address,sys_name,sys_descr,sys_object_id,serial,ips,last_seen,error,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,'',?,?)
+ON CONFLICT(address) DO UPDATE SET
+ sys_name=excluded.sys_name,
+ sys_descr=excluded.sys_descr,
+ sys_object_id=excluded.sys_object_id,
+ serial=excluded.serial,
+ ips=excluded.ips,
+ last_seen=excluded.last_seen,
+ error='',
+ updated_at=excluded.updated_at
+`, address, f.SysName, f.SysDescr, f.SysObjectID, f.Serial, f.IPs, now, now, now); err != nil {
+            return err
+        }
+        if _, err := tx.Exec(`DELETE FROM network_interfaces WHERE device=?`, address); err != nil {
+            return err
+        }
+        names := map[int]string{}
+        for _, i := range f.Interfaces {
+            names[i.Index] = i.label()
+            if _, err := tx.Exec(`INSERT INTO network_interfaces(device,if_index,name,descr,alias,mac,speed_mbps,admin_up,oper_up)
+ VALUES(?,?,?,?,?,?,?,?,?)`, address, i.Index, i.Name, i.Descr, i.Alias, i.MAC, i.SpeedMbps, i.AdminUp, i.OperUp); err != nil {
+                return err
+            }
+        }
+        if _, err := tx.Exec(`DELETE FROM network_links WHERE device=?`, address); err != nil {
+            return err
+        }
+        for _, e := range f.arp {
+            node, ok := nodeIPs[e.ip]
+            if !ok {
+                continue
+            }
+            if _, err := tx.Exec(`INSERT INTO network_links(device,node,interface,ip,mac) VALUES(?,?,?,?,?) ON CONFLICT DO NOTHING`,
+                address, node, names[e.ifIndex], e.ip, e.mac); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (d *netDeviceDiscoverer) nodeIPs() (map[string]string, error) {
+    rows, err := d.db.Query(`SELECT name,cmdb_unseal(internal_ip) FROM nodes WHERE coalesce(internal_ip,'')<>''`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string]string{}
+    for rows.Next() {
+        var name, ip string
+        if err := rows.Scan(&name, &ip); err != nil {
+            return nil, err
+        }
+        out[ip] = name
+    }
+    return out, rows.Err()
+}
+
+func (d *netDeviceDiscoverer) runOnce() (found, failed int) {
+    nodeIPs, err := d.nodeIPs()
+    if err != nil {
+        log.Printf("[netdevices] load node IPs: %v", err)
+    }
+    jobs := make(chan discoveryTarget)
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    for i := 0; i < d.cfg.Concurrency; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for t := range jobs {
+                f, err := d.collect(t)
+                var netErr net.Error
+                if err != nil && t.fromCIDR && errors.As(err, &netErr) && netErr.Timeout() {
+                    continue
+                }
+                if err != nil {
+                    log.Printf("[netdevices] %s: %v", t.Address, err)
+                }
+                if werr := d.store(t.Address, f, err, nodeIPs); werr != nil {
+                    log.Printf("[netdevices] store %s: %v", t.Address, werr)
+                }
+                mu.Lock()
+                if err != nil {
+                    failed++
+                } else {
+                    found++
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+    for _, t := range d.targets {
+        jobs <- t
+    }
+    close(jobs)
+    wg.Wait()
+    return found, failed
+}
+
+// 同 hostDiscoverer.collectOnce
+func (d *netDeviceDiscoverer) collectOnce(context.Context) error {
+    start := time.Now()
+    found, failed := d.runOnce()
+    log.Printf("[netdevices] polled %d devices, %d failed, in %s", found, failed, time.Since(start).Round(time.Second))
+    if found == 0 && failed > 0 {
+        return fmt.Errorf("all %d devices failed", failed)
+    }
+    return nil
+}
+
+type NetworkDeviceRow struct {
+    Address     string `json:"address"`
+    SysName     string `json:"sysName"`
+    SysDescr    string `json:"sysDescr"`
+    SysObjectID string `json:"sysObjectID"`
+    Serial      string `json:"serial"`
+    // 设备自己的 IP 和通过 ARP 连到的节点，逗号分隔
+    IPs   string `json:"ips"`
+    Nodes string `json:"nodes"`
+    // 接口数和 operStatus 为 up 的接口数
+    Interfaces   int `json:"interfaces"`
+    InterfacesUp int `json:"interfacesUp"`
+    // 最近一次成功轮询的时间；error 非空表示最近一次失败
+    LastSeen  string `json:"lastSeen"`
+    Error     string `json:"error"`
+    UpdatedAt string `json:"updatedAt"`
+}
+
+type NetworkLink struct {
+    Node      string `json:"node"`
+    Interface string `json:"interface"`
+    IP        string `json:"ip"`
+    MAC       string `json:"mac"`
+}
+
+type NetworkDevice struct {
+    NetworkDeviceRow
+    InterfaceList []NetworkInterface `json:"interfaceList"`
+    Links         []NetworkLink      `json:"links"`
+}
+
+const netDeviceSelect = `SELECT address,sys_name,sys_descr,sys_object_id,serial,ips,
+ coalesce((SELECT group_concat(node) FROM (SELECT DISTINCT node FROM network_links l WHERE l.device=d.address ORDER BY node)),''),
+ (SELECT count(*) FROM network_interfaces i WHERE i.device=d.address),
+ (SELECT count(*) FROM network_interfaces i WHERE i.device=d.address AND i.oper_up),
+ last_seen,error,coalesce(updated_at,'') FROM network_devices d`
+
+func scanNetDevice(rows *sql.Rows) (NetworkDeviceRow, error) {
+    var d NetworkDeviceRow
+    err := rows.Scan(&d.Address, &d.SysName, &d.SysDescr, &d.SysObjectID, &d.Serial, &d.IPs, &d.Nodes,
+        &d.Interfaces, &d.InterfacesUp, &d.LastSeen, &d.Error, &d.UpdatedAt)
+    return d, err
+}
+
+// GET /cmdb/network-devices?node=<name>         node：通过 ARP 连到该节点的设备
+// GET /cmdb/network-devices/{address}            带接口和链路
+// 设备不属于任何 namespace，限定 namespace 的 key 看不到
+func netDevicesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if addr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/network-devices"), "/"); addr != "" {
+            getNetDevice(db, w, r, addr)
+            return
+        }
+        cond, condArgs := "1", []any(nil)
+        if v := r.URL.Query().Get("node"); v != "" {
+            cond, condArgs = "address IN (SELECT device FROM network_links WHERE node=?)", []any{v}
+        }
+        where, args := scopeOf(r.Context()).where("''", cond, condArgs...)
+        rows, err := db.QueryContext(r.Context(), netDeviceSelect+where+` ORDER BY address`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "network devices", NetworkDeviceRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            d, err := scanNetDevice(rows)
+            if err != nil {
+                lw.Fail(err)
+                return
+            }
+            if err := lw.Write(d); err != nil {
+                log.Printf("[http] write network devices: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
+
+func getNetDevice(db *sql.DB, w http.ResponseWriter, r *http.Request, addr string) {
+    ctx := r.Context()
+    where, args := scopeOf(ctx).where("''", "address=?", addr)
+    rows, err := db.QueryContext(ctx, netDeviceSelect+where, args...)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    var dev *NetworkDevice
+    for rows.Next() {
+        row, err := scanNetDevice(rows)
+        if err != nil {
+            rows.Close()
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        dev = &NetworkDevice{NetworkDeviceRow: row, InterfaceList: []NetworkInterface{}, Links: []NetworkLink{}}
+    }
+    rows.Close()
+    if dev == nil {
+        http.Error(w, "not found", 404)
+        return
+    }
+    if err := loadNetDeviceDetail(ctx, db, dev); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    writeJSON(w, r, dev)
+}
+
+func loadNetDeviceDetail(ctx context.Context, db *sql.DB, dev *NetworkDevice) error {
+    rows, err := db.QueryContext(ctx, `SELECT if_index,name,descr,alias,mac,speed_mbps,admin_up,oper_up FROM network_interfaces WHERE device=? ORDER BY if_index`, dev.Address)
+    if err != nil {
+        return err
+    }
+    for rows.Next() {
+        var i NetworkInterface
+        if err := rows.Scan(&i.Index, &i.Name, &i.Descr, &i.Alias, &i.MAC, &i.SpeedMbps, &i.AdminUp, &i.OperUp); err != nil {
+            rows.Close()
+            return err
+        }
+        dev.InterfaceList = append(dev.InterfaceList, i)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    rows, err = db.QueryContext(ctx, `SELECT node,interface,ip,mac FROM network_links WHERE device=? ORDER BY node,ip`, dev.Address)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var l NetworkLink
+        if err := rows.Scan(&l.Node, &l.Interface, &l.IP, &l.MAC); err != nil {
+            return err
+        }
+        dev.Links = append(dev.Links, l)
+    }
+    return rows.Err()
+}
+
+// 拓扑：设备到节点、节点到设备的 connects 边，via 为设备上的接口
+func netDeviceLinks(ctx context.Context, db *sql.DB, o topoObj) ([]topoLink, error) {
+    col, other := "device", "node"
+    if o.node.Kind == "node" {
+        col, other = "node", "device"
+    }
+    rows, err := dbFrom(ctx, db).Query(`SELECT `+other+`,group_concat(DISTINCT interface) FROM network_links WHERE `+col+`=? GROUP BY 1 ORDER BY 1`, o.node.Name)
+    if err != nil {
+        return nil, err
+    }
+    type link struct{ name, via string }
+    var links []link
+    for rows.Next() {
+        var l link
+        var via sql.NullString
+        if err := rows.Scan(&l.name, &via); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        l.via = via.String
+        links = append(links, l)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    var out []topoLink
+    for _, l := range links {
+        if o.node.Kind == "node" {
+            dev := topoObj{node: TopoNode{ID: "netdevice/" + l.name, Kind: "netdevice", Name: l.name}}
+            out = append(out, topoLink{obj: dev, edge: TopoEdge{Source: dev.node.ID, Target: o.node.ID, Type: "connects", Via: l.via}})
+            continue
+        }
+        nodes, err := topoRows("node")(gqlNodes(ctx, db, "name=?", l.name))
+        if err != nil {
+            return nil, err
+        }
+        for _, n := range nodes {
+            out = append(out, topoLink{obj: n, edge: TopoEdge{Source: o.node.ID, Target: n.node.ID, Type: "connects", Via: l.via}})
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Node cordon and drain tracking ----------
+
+// nodes.unschedulable 记录 spec.unschedulable，cordon / uncordon 也因此出现在 history 里。
+// 另外每段 cordon 记一行 node_cordons：开始时间取 node.kubernetes.io/unschedulable taint 的 timeAdded，
+// 没有时取第一次看到的时间；uncordon 或节点被删除时结束。
+// 驱逐记在 node_evictions：节点 cordon 期间 informer 删掉的 Pod 算作 drain 驱逐（由 pods 的删除触发器写入，
+// 对账和 /admin/purge 删的不算），kubelet 因资源压力驱逐的 Pod（status.reason=Evicted）算作 evicted。
+// 每个 Pod 只记一次。
+const (
+    evictionDrain   = "drain"
+    evictionKubelet = "evicted"
+)
+
+func initNodeCordons(db *sql.DB) error {
+    // "true" / "false"
+    if err := addColumnIfMissing(db, "nodes", "unschedulable", "TEXT"); err != nil {
+        return err
+    }
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS node_cordons(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    node TEXT NOT NULL,
+    cordoned_at TEXT NOT NULL,
+    uncordoned_at TEXT,
+    -- uncordon / deleted
+    ended_by TEXT
+);`,
+        `CREATE INDEX IF NOT EXISTS node_cordons_node ON node_cordons(node, id)`,
+        `CREATE UNIQUE INDEX IF NOT EXISTS node_cordons_open ON node_cordons(node) WHERE uncordoned_at IS NULL`, `
+CREATE TABLE IF NOT EXISTS node_evictions(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    node TEXT NOT NULL,
+    uid TEXT NOT NULL UNIQUE,
+    namespace TEXT,
+    name TEXT,
+    reason TEXT NOT NULL,
+    ts TEXT NOT NULL
+);`,
+        `CREATE INDEX IF NOT EXISTS node_evictions_node ON node_evictions(node, ts)`,
+        `DROP TRIGGER IF EXISTS pods_evictions_ad`,
+        `CREATE TRIGGER pods_evictions_ad AFTER DELETE ON pods
+ WHEN (SELECT source FROM change_context WHERE id=1)='informer'
+  AND EXISTS(SELECT 1 FROM node_cordons WHERE node=old.node_name AND uncordoned_at IS NULL) BEGIN
+ INSERT OR IGNORE INTO node_evictions(node,uid,namespace,name,reason,ts)
+ VALUES(old.node_name,old.uid,old.namespace,old.name,'` + evictionDrain + `',strftime('%Y-%m-%dT%H:%M:%SZ','now')); END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func recordNodeCordon(db querier, n *corev1.Node) error {
+    now := time.Now().UTC()
+    if !n.Spec.Unschedulable {
+        _, err := db.Exec(`UPDATE node_cordons SET uncordoned_at=?,ended_by='uncordon' WHERE node=? AND uncordoned_at IS NULL`,
+            now.Format(time.RFC3339), n.Name)
+        return err
+    }
+    since := now
+    for _, t := range n.Spec.Taints {
+        if t.Key == corev1.TaintNodeUnschedulable && t.TimeAdded != nil && t.TimeAdded.Time.Before(now) {
+            since = t.TimeAdded.Time.UTC()
+        }
+    }
+    _, err := db.Exec(`INSERT INTO node_cordons(node,cordoned_at) VALUES(?,?) ON CONFLICT(node) WHERE uncordoned_at IS NULL DO NOTHING`,
+        n.Name, since.Format(time.RFC3339))
+    return err
+}
+
+func recordNodeCordonRemoved(db querier, name string) error {
+    _, err := db.Exec(`UPDATE node_cordons SET uncordoned_at=?,ended_by='deleted' WHERE node=? AND uncordoned_at IS NULL`,
+        time.Now().UTC().Format(time.RFC3339), name)
+    return err
+}
+
+// upsertPod 调用：kubelet 驱逐的 Pod 留在 Failed，status.reason 为 Evicted
+func recordPodEviction(db querier, p *corev1.Pod) error {
+    if p.Status.Reason != "Evicted" || p.Spec.NodeName == "" {
+        return nil
+    }
+    _, err := db.Exec(`INSERT OR IGNORE INTO node_evictions(node,uid,namespace,name,reason,ts) VALUES(?,?,?,?,?,?)`,
+        p.Spec.NodeName, string(p.UID), p.Namespace, p.Name, evictionKubelet, time.Now().UTC().Format(time.RFC3339))
+    return err
+}
+
+type CordonedNode struct {
+    Node       string `json:"node"`
+    CordonedAt string `json:"cordonedAt"`
+    // 到现在为止 cordon 了多久
+    Seconds float64 `json:"seconds"`
+    // cordon 以来该节点上驱逐的 Pod 数
+    Evictions int64 `json:"evictions"`
+}
+
+type NodeEviction struct {
+    UID       string `json:"uid"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // drain / evicted
+    Reason string `json:"reason"`
+    TS     string `json:"ts"`
+}
+
+type NodeCordonPeriod struct {
+    CordonedAt string `json:"cordonedAt"`
+    // 还在 cordon 时为空
+    UncordonedAt string `json:"uncordonedAt,omitempty"`
+    // uncordon / deleted，还在 cordon 时为空
+    EndedBy   string  `json:"endedBy,omitempty"`
+    Seconds   float64 `json:"seconds"`
+    Evictions int64   `json:"evictions"`
+    // 这段时间内任意一分钟里最多的驱逐数，看 drain 是不是一下子把 Pod 全赶走了
+    PeakPerMinute int64 `json:"peakPerMinute"`
+}
+
+type NodeCordons struct {
+    Node string `json:"node"`
+    From string `json:"from"`
+    To   string `json:"to"`
+    // 和窗口有重叠的 cordon，按开始时间排序
+    Periods []NodeCordonPeriod `json:"periods"`
+    // 窗口内的驱逐，按时间排序
+    Evictions []NodeEviction `json:"evictions"`
+}
+
+func loadCordonedNodes(r *http.Request, db *sql.DB) ([]CordonedNode, error) {
+    rows, err := db.QueryContext(r.Context(), `SELECT c.node,c.cordoned_at,
+ (SELECT count(*) FROM node_evictions e WHERE e.node=c.node AND e.ts>=c.cordoned_at)
+ FROM node_cordons c WHERE c.uncordoned_at IS NULL ORDER BY c.cordoned_at,c.node`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    now := time.Now()
+    out := []CordonedNode{}
+    for rows.Next() {
+        var c CordonedNode
+        if err := rows.Scan(&c.Node, &c.CordonedAt, &c.Evictions); err != nil {
+            return nil, err
+        }
+        if t, err := time.Parse(time.RFC3339, c.CordonedAt); err == nil {
+            c.Seconds = now.Sub(t).Truncate(time.Second).Seconds()
+        }
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+// found 为 false 表示这个节点从没有被 cordon 过，也没有驱逐记录
+func buildNodeCordons(r *http.Request, db *sql.DB, node string, from, to time.Time) (*NodeCordons, bool, error) {
+    f, t := from.Format(time.RFC3339), to.Format(time.RFC3339)
+    c := &NodeCordons{Node: node, From: f, To: t, Periods: []NodeCordonPeriod{}, Evictions: []NodeEviction{}}
+    var found bool
+    if err := db.QueryRowContext(r.Context(), `SELECT EXISTS(SELECT 1 FROM node_cordons WHERE node=?1)
+ OR EXISTS(SELECT 1 FROM node_evictions WHERE node=?1)`, node).Scan(&found); err != nil || !found {
+        return nil, false, err
+    }
+    rows, err := db.QueryContext(r.Context(), `SELECT uid,coalesce(namespace,''),coalesce(name,''),reason,ts FROM node_evictions
+ WHERE node=? AND ts>? AND ts<=? ORDER BY ts,id`, node, f, t)
+    if err != nil {
+        return nil, false, err
+    }
+    for rows.Next() {
+        var e NodeEviction
+        if err := rows.Scan(&e.UID, &e.Namespace, &e.Name, &e.Reason, &e.TS); err != nil {
+            rows.Close()
+            return nil, false, err
+        }
+        c.Evictions = append(c.Evictions, e)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, false, err
+    }
+    rows, err = db.QueryContext(r.Context(), `SELECT cordoned_at,coalesce(uncordoned_at,''),coalesce(ended_by,'') FROM node_cordons
+ WHERE node=? AND cordoned_at<=? AND (uncordoned_at IS NULL OR uncordoned_at>?) ORDER BY cordoned_at,id`, node, t, f)
+    if err != nil {
+        return nil, false, err
+    }
+    defer rows.Close()
+    now := time.Now()
+    for rows.Next() {
+        var p NodeCordonPeriod
+        if err := rows.Scan(&p.CordonedAt, &p.UncordonedAt, &p.EndedBy); err != nil {
+            return nil, false, err
+        }
+        start, _ := time.Parse(time.RFC3339, p.CordonedAt)
+        end := now
+        if p.UncordonedAt != "" {
+            end, _ = time.Parse(time.RFC3339, p.UncordonedAt)
+        }
+        p.Seconds = end.Sub(start).Truncate(time.Second).Seconds()
+        // 同一段里的驱逐按分钟分桶
+        perMinute := map[int64]int64{}
+        for _, e := range c.Evictions {
+            ts, err := time.Parse(time.RFC3339, e.TS)
+            if err != nil || ts.Before(start) || ts.After(end) {
+                continue
+            }
+            p.Evictions++
+            m := ts.Unix() / 60
+            perMinute[m]++
+            p.PeakPerMinute = max(p.PeakPerMinute, perMinute[m])
+        }
+        c.Periods = append(c.Periods, p)
+    }
+    return c, true, rows.Err()
+}
+
+// GET /cmdb/nodes/cordoned：当前 cordon 的节点，最早 cordon 的在前
+// 节点是集群级对象，限定 namespace 的 key 看到空列表
+func cordonedNodesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        out := []CordonedNode{}
+        if scopeOf(r.Context()).allows("") {
+            var err error
+            if out, err = loadCordonedNodes(r, db); err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+        }
+        lw, err := newListWriter(w, r, "cordoned-nodes", CordonedNode{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, c := range out {
+            if err := lw.Write(c); err != nil {
+                log.Printf("[http] write cordoned nodes: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
+
+// GET /cmdb/nodes/{name}/cordons?from=<RFC3339>&to=<RFC3339>
+// from 默认 to 之前 30 天，to 默认现在
+func nodeCordonsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        node := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/cmdb/nodes/"), "/cordons")
+        if node == "" || strings.Contains(node, "/") {
+            http.Error(w, "not found", 404)
+            return
+        }
+        if !scopeOf(r.Context()).allows("") {
+            http.Error(w, "node "+node+" not found", 404)
+            return
+        }
+        q := r.URL.Query()
+        to := time.Now().UTC().Truncate(time.Second)
+        if v := q.Get("to"); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                http.Error(w, "to must be RFC3339", 400)
+                return
+            }
+            to = t.UTC()
+        }
+        from := to.Add(-30 * 24 * time.Hour)
+        if v := q.Get("from"); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                http.Error(w, "from must be RFC3339", 400)
+                return
+            }
+            from = t.UTC()
+        }
+        if !to.After(from) {
+            http.Error(w, "to must be after from", 400)
+            return
+        }
+        c, found, err := buildNodeCordons(r, db, node, from, to)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if !found {
+            http.Error(w, "no cordon history for node "+node, 404)
+            return
+        }
+        writeJSON(w, r, c)
+    }
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Node lifecycle webhooks ----------
+
+// 只推节点的生命周期：joined / not_ready / ready / removed，不需要对方去解析通用的变更流。
+// 每个节点的状态变化先等 debounce，期间又变回去（比如 kubelet 抖一下）就什么都不发。
+const (
+    nodeJoined   = "joined"
+    nodeNotReady = "not_ready"
+    nodeReady    = "ready"
+    nodeRemoved  = "removed"
+    // 队列溢出后发出：之前的事件已丢弃，接收方应重新拉取 /cmdb/nodes
+    nodeResync = "resync"
+)
+
+type NodeEvent struct {
+    Type    string `json:"type"`
+    Node    string `json:"node,omitempty"`
+    Site    string `json:"site,omitempty"`
+    Ready   bool   `json:"ready"`
+    Reason  string `json:"reason,omitempty"`
+    Message string `json:"message,omitempty"`
+    // 状态实际发生变化的时间，不是发送时间
+    Time string `json:"time"`
+}
+
+// 节点当前的可观察状态；present=false 表示已删除
+type nodeState struct {
+    present, ready  bool
+    reason, message string
+}
+
+type nodePending struct {
+    state nodeState
+    since time.Time
+    timer *time.Timer
+}
+
+type nodeNotifier struct {
+    cfg    NodeWebhookConfig
+    site   string
+    client *http.Client
+    queue  chan NodeEvent
+
+    mu        sync.Mutex
+    primed    bool
+    announced map[string]nodeState
+    pending   map[string]*nodePending
+}
+
+func newNodeNotifier(cfg NodeWebhookConfig, site string) *nodeNotifier {
+    return &nodeNotifier{
+        cfg:       cfg,
+        site:      site,
+        client:    &http.Client{Timeout: 10 * time.Second},
+        queue:     make(chan NodeEvent, cfg.QueueSize),
+        announced: map[string]nodeState{},
+        pending:   map[string]*nodePending{},
+    }
+}
+
+func nodeStateOf(n *corev1.Node) nodeState {
+    s := nodeState{present: true}
+    for _, c := range n.Status.Conditions {
+        if c.Type == corev1.NodeReady {
+            s.ready = c.Status == corev1.ConditionTrue
+            s.reason, s.message = c.Reason, c.Message
+        }
+    }
+    return s
+}
+
+// 缓存同步完成后调用：之前看到的节点只记状态，不当作 joined
+func (n *nodeNotifier) prime() {
+    n.mu.Lock()
+    n.primed = true
+    n.mu.Unlock()
+}
+
+func (n *nodeNotifier) observe(name string, s nodeState) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    if !n.primed {
+        n.announced[name] = s
+        return
+    }
+    p := n.pending[name]
+    if sameNodeState(n.announced[name], s) {
+        // 在 debounce 内恢复原状，取消待发事件
+        if p != nil {
+            p.timer.Stop()
+            delete(n.pending, name)
+        }
+        return
+    }
+    if p != nil {
+        // 已在等待中：只更新目标状态，计时不重新开始，避免持续抖动时一直发不出去
+        p.state = s
+        return
+    }
+    p = &nodePending{state: s, since: time.Now()}
+    p.timer = time.AfterFunc(n.cfg.Debounce.Duration, func() { n.fire(name) })
+    n.pending[name] = p
+}
+
+// 只比较会产生事件的字段
+func sameNodeState(a, b nodeState) bool {
+    if a.present != b.present {
+        return false
+    }
+    return !a.present || a.ready == b.ready
+}
+
+func (n *nodeNotifier) fire(name string) {
+    n.mu.Lock()
+    p := n.pending[name]
+    if p == nil {
+        n.mu.Unlock()
+        return
+    }
+    delete(n.pending, name)
+    prev := n.announced[name]
+    if p.state.present {
+        n.announced[name] = p.state
+    } else {
+        delete(n.announced, name)
+    }
+    n.mu.Unlock()
+
+    ev := NodeEvent{Node: name, Site: n.site, Ready: p.state.ready, Reason: p.state.reason,
+        Message: p.state.message, Time: p.since.UTC().Format(time.RFC3339)}
+    switch {
+    case !p.state.present:
+        ev.Type = nodeRemoved
+    case !prev.present:
+        ev.Type = nodeJoined
+    case p.state.ready:
+        ev.Type = nodeReady
+    default:
+        ev.Type = nodeNotReady
+    }
+    n.enqueue(ev)
+}
+
+// 不阻塞 informer：队列满说明接收方太慢，丢掉全部积压换成一个 resync 标记，
+// 而不是只丢最新的事件让对方悄悄错过状态变化
+func (n *nodeNotifier) enqueue(ev NodeEvent) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    select {
+    case n.queue <- ev:
+        return
+    default:
+    }
+    dropped := 1
+    for drained := false; !drained; {
+        select {
+        case <-n.queue:
+            dropped++
+        default:
+            drained = true
+        }
+    }
+    // 持有 mu 时只有 Run 在取，清空后一定放得下
+    n.queue <- NodeEvent{Type: nodeResync, Site: n.site, Time: time.Now().UTC().Format(time.RFC3339)}
+    log.Printf("[nodehooks] queue full, dropped %d events, sending resync", dropped)
+}
+
+func (n *nodeNotifier) Name() string { return "nodeWebhooks" }
+
+// 事件按顺序逐个发送，重试由 exportHandle 负责
+func (n *nodeNotifier) Run(h *exportHandle, stop <-chan struct{}) {
+    for {
+        select {
+        case <-stop:
+            return
+        case ev := <-n.queue:
+            b, _ := json.Marshal(ev)
+            for _, u := range n.cfg.URLs {
+                if err := h.do(func() error { return n.post(u, b) }); err != nil && err != errExporterDisabled {
+                    log.Printf("[nodehooks] %s %s -> %s: %v", ev.Type, ev.Node, u, err)
+                }
+            }
+        }
+    }
+}
+
+func (n *nodeNotifier) post(u string, body []byte) error {
+    return postWebhook(n.client, u, n.cfg.Secret, body)
+}
+
+// secret 非空时带 X-LightCMDB-Signature: sha256=<HMAC-SHA256(body)>，告警的 webhook 目标也用它
+func postWebhook(client *http.Client, u, secret string, body []byte) error {
+    req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if secret != "" {
+        mac := hmac.New(sha256.New, []byte(secret))
+        mac.Write(body)
+        req.Header.Set("X-LightCMDB-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned %s", resp.Status)
+    }
+    return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+
+    "k8s.io/apimachinery/pkg/api/resource"
+    "k8s.io/apimachinery/pkg/labels"
+)
+
+// ---------- Node groups ----------
+
+// 配置里的 nodeGroups 按标签把节点分组（spot 池、GPU 池、每个可用区 ...），/cmdb/nodegroups 每组一行：
+// 节点数和 capacity、组内节点上的 Pod 和 requests、metrics-server 的用量（未开启时为 0）。
+// Pod 只算没结束的（phase 不是 Succeeded / Failed）。节点是集群级对象，限定 namespace 的 key 拿到空列表。
+type NodeGroupRow struct {
+    Group string `json:"group"`
+    // label 分组时为标签的取值，selector 分组时为空
+    Value               string `json:"value"`
+    Nodes               int64  `json:"nodes"`
+    ReadyNodes          int64  `json:"readyNodes"`
+    CPUCapacityMilli    int64  `json:"cpuCapacityMilli"`
+    MemoryCapacityBytes int64  `json:"memoryCapacityBytes"`
+    Pods                int64  `json:"pods"`
+    CPURequestMilli     int64  `json:"cpuRequestMilli"`
+    MemoryRequestBytes  int64  `json:"memoryRequestBytes"`
+    // requests 占 capacity 的百分比，保留一位小数
+    CPURequestPercent    float64 `json:"cpuRequestPercent"`
+    MemoryRequestPercent float64 `json:"memoryRequestPercent"`
+    CPUUsageMilli        int64   `json:"cpuUsageMilli"`
+    MemoryUsageBytes     int64   `json:"memoryUsageBytes"`
+    // 用量占 capacity 的百分比；只算有用量采样的节点的 capacity
+    CPUUtilizationPercent    float64 `json:"cpuUtilizationPercent"`
+    MemoryUtilizationPercent float64 `json:"memoryUtilizationPercent"`
+}
+
+type nodeGroupNode struct {
+    name     string
+    labels   map[string]string
+    ready    bool
+    cpu, mem int64
+    // metrics-server 的采样，sampled 为 false 时没有
+    sampled          bool
+    cpuUsed, memUsed int64
+    pods             int64
+    cpuReq, memReq   int64
+}
+
+func loadNodeGroupNodes(q querier) ([]*nodeGroupNode, error) {
+    rows, err := q.Query(`SELECT n.name,coalesce(n.labels,''),coalesce(n.ready,''),coalesce(n.capacity_cpu,''),coalesce(n.capacity_mem,''),
+ u.cpu_milli IS NOT NULL,coalesce(u.cpu_milli,0),coalesce(u.mem_bytes,0)
+FROM nodes n LEFT JOIN node_usage u ON u.name=n.name ORDER BY n.name`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []*nodeGroupNode
+    for rows.Next() {
+        n := &nodeGroupNode{}
+        var flat, ready, cpu, mem string
+        if err := rows.Scan(&n.name, &flat, &ready, &cpu, &mem, &n.sampled, &n.cpuUsed, &n.memUsed); err != nil {
+            return nil, err
+        }
+        n.labels, n.ready = parseLabels(flat), ready == "true"
+        if v, err := resource.ParseQuantity(cpu); err == nil {
+            n.cpu = v.MilliValue()
+        }
+        if v, err := resource.ParseQuantity(mem); err == nil {
+            n.mem = v.Value()
+        }
+        out = append(out, n)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    byName := make(map[string]*nodeGroupNode, len(out))
+    for _, n := range out {
+        byName[n.name] = n
+    }
+    prows, err := q.Query(`SELECT node_name,count(*),coalesce(sum(cpu_request),0),coalesce(sum(mem_request),0) FROM pods
+ WHERE coalesce(node_name,'')<>'' AND coalesce(phase,'') NOT IN ('Succeeded','Failed') GROUP BY node_name`)
+    if err != nil {
+        return nil, err
+    }
+    defer prows.Close()
+    for prows.Next() {
+        var name string
+        var pods, cpu, mem int64
+        if err := prows.Scan(&name, &pods, &cpu, &mem); err != nil {
+            return nil, err
+        }
+        if n := byName[name]; n != nil {
+            n.pods, n.cpuReq, n.memReq = pods, cpu, mem
+        }
+    }
+    return out, prows.Err()
+}
+
+func percentOf(used, total int64) float64 {
+    if total <= 0 {
+        return 0
+    }
+    return math.Round(float64(used)/float64(total)*1000) / 10
+}
+
+func nodeGroupRow(group, value string, nodes []*nodeGroupNode) NodeGroupRow {
+    row := NodeGroupRow{Group: group, Value: value}
+    var sampledCPU, sampledMem int64
+    for _, n := range nodes {
+        row.Nodes++
+        if n.ready {
+            row.ReadyNodes++
+        }
+        row.CPUCapacityMilli += n.cpu
+        row.MemoryCapacityBytes += n.mem
+        row.Pods += n.pods
+        row.CPURequestMilli += n.cpuReq
+        row.MemoryRequestBytes += n.memReq
+        if n.sampled {
+            row.CPUUsageMilli += n.cpuUsed
+            row.MemoryUsageBytes += n.memUsed
+            sampledCPU += n.cpu
+            sampledMem += n.mem
+        }
+    }
+    row.CPURequestPercent = percentOf(row.CPURequestMilli, row.CPUCapacityMilli)
+    row.MemoryRequestPercent = percentOf(row.MemoryRequestBytes, row.MemoryCapacityBytes)
+    row.CPUUtilizationPercent = percentOf(row.CPUUsageMilli, sampledCPU)
+    row.MemoryUtilizationPercent = percentOf(row.MemoryUsageBytes, sampledMem)
+    return row
+}
+
+// 配置已在加载时校验过，selector 一定能解析
+func computeNodeGroups(q querier, groups []NodeGroupConfig) ([]NodeGroupRow, error) {
+    nodes, err := loadNodeGroupNodes(q)
+    if err != nil {
+        return nil, err
+    }
+    var out []NodeGroupRow
+    for _, g := range groups {
+        if g.Selector != "" {
+            sel, err := labels.Parse(g.Selector)
+            if err != nil {
+                return nil, err
+            }
+            var members []*nodeGroupNode
+            for _, n := range nodes {
+                if sel.Matches(labels.Set(n.labels)) {
+                    members = append(members, n)
+                }
+            }
+            out = append(out, nodeGroupRow(g.Name, "", members))
+            continue
+        }
+        byValue := map[string][]*nodeGroupNode{}
+        for _, n := range nodes {
+            if v, ok := n.labels[g.Label]; ok {
+                byValue[v] = append(byValue[v], n)
+            }
+        }
+        values := make([]string, 0, len(byValue))
+        for v := range byValue {
+            values = append(values, v)
+        }
+        sort.Strings(values)
+        for _, v := range values {
+            out = append(out, nodeGroupRow(g.Name, v, byValue[v]))
+        }
+    }
+    return out, nil
+}
+
+// GET /cmdb/nodegroups?group=<name>[&format=csv]
+func nodeGroupsAPI(db *sql.DB, groups []NodeGroupConfig) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        selected := groups
+        if name := r.URL.Query().Get("group"); name != "" {
+            selected = nil
+            for _, g := range groups {
+                if g.Name == name {
+                    selected = append(selected, g)
+                }
+            }
+            if len(selected) == 0 {
+                http.Error(w, "unknown node group "+name, 404)
+                return
+            }
+        }
+        var out []NodeGroupRow
+        if scopeOf(r.Context()) == nil {
+            err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+                var err error
+                out, err = computeNodeGroups(dbFrom(ctx, db), selected)
+                return err
+            })
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+        }
+        lw, err := newListWriter(w, r, "nodegroups", NodeGroupRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write node groups: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
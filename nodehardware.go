@@ -0,0 +1,161 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "regexp"
+    "slices"
+    "sort"
+    "strings"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Node hardware capabilities ----------
+
+// 做负载规划时要查"哪些节点有 SR-IOV 网卡 / TPU / FPGA"。两个来源合在一起写到节点上：
+// node-feature-discovery 打的 feature.node.kubernetes.io/* 标签（PCI 设备按 class_vendor 命名）说明硬件在不在，
+// device plugin 上报的扩展资源（intel.com/sriov_netdevice、google.com/tpu ...）给出可分配的数量。
+// capabilities 是命中的类别（只有 NFD 标签、插件没装时数量为 0），devices 是全部非零的扩展资源 allocatable。
+type hardwareClass struct {
+    Name string
+    // 值为 "true" 的 NFD / GFD 标签 key
+    label *regexp.Regexp
+    // 扩展资源名（小写）
+    resource *regexp.Regexp
+}
+
+const nfdPrefix = `^feature\.node\.kubernetes\.io/`
+
+var hardwareClasses = []hardwareClass{
+    {Name: "sriov",
+        label:    regexp.MustCompile(nfdPrefix + `(network-sriov|pci-[0-9a-f]{4}_[0-9a-f]{4}\.sriov)\.capable$`),
+        resource: regexp.MustCompile(`sriov`)},
+    // 0302 是 3D controller（数据中心 GPU）；0300 的 VGA 大多是 BMC 的显示芯片，只认 NVIDIA / AMD
+    {Name: "gpu",
+        label:    regexp.MustCompile(`^nvidia\.com/gpu\.present$|` + nfdPrefix + `pci-(0302_[0-9a-f]{4}|03[0-9a-f]{2}_(10de|1002))\.present$`),
+        resource: regexp.MustCompile(`/gpu$|^gpu\.intel\.com/`)},
+    // Coral Edge TPU：PCIe 厂商 1ac1，USB 初始化前后分别是 1a6e:089a / 18d1:9302
+    {Name: "tpu",
+        label:    regexp.MustCompile(nfdPrefix + `(pci-[0-9a-f]{4}_1ac1|usb-ff_1a6e_089a|usb-ff_18d1_9302)\.present$`),
+        resource: regexp.MustCompile(`tpu`)},
+    // Xilinx / Altera
+    {Name: "fpga",
+        label:    regexp.MustCompile(nfdPrefix + `pci-[0-9a-f]{4}_(10ee|1172)\.present$`),
+        resource: regexp.MustCompile(`fpga`)},
+}
+
+type nodeHardware struct {
+    Capabilities string
+    Devices      string
+    // 按类别汇总的 allocatable，顺序同 hardwareClasses
+    Counts []int64
+}
+
+// 带域名且不在 kubernetes.io 下的资源是扩展资源（device plugin 注册的）
+func isExtendedResource(name corev1.ResourceName) bool {
+    s := string(name)
+    return strings.Contains(s, "/") && !strings.Contains(s, "kubernetes.io/") && !strings.HasPrefix(s, "requests.")
+}
+
+func nodeHardwareOf(n *corev1.Node) nodeHardware {
+    hw := nodeHardware{Counts: make([]int64, len(hardwareClasses))}
+    found := make([]bool, len(hardwareClasses))
+    alloc := n.Status.Allocatable
+    if len(alloc) == 0 {
+        alloc = n.Status.Capacity
+    }
+    devices := map[string]string{}
+    for name, q := range alloc {
+        if !isExtendedResource(name) || q.IsZero() {
+            continue
+        }
+        devices[string(name)] = q.String()
+        for i, c := range hardwareClasses {
+            if c.resource.MatchString(strings.ToLower(string(name))) {
+                found[i] = true
+                hw.Counts[i] += q.Value()
+            }
+        }
+    }
+    for k, v := range n.Labels {
+        if v != "true" {
+            continue
+        }
+        for i, c := range hardwareClasses {
+            if c.label.MatchString(k) {
+                found[i] = true
+            }
+        }
+    }
+    var caps []string
+    for i, c := range hardwareClasses {
+        if found[i] {
+            caps = append(caps, c.Name)
+        }
+    }
+    sort.Strings(caps)
+    hw.Capabilities = strings.Join(caps, ",")
+    hw.Devices = flattenLabels(devices)
+    return hw
+}
+
+func (hw nodeHardware) count(class string) int64 {
+    if i := slices.IndexFunc(hardwareClasses, func(c hardwareClass) bool { return c.Name == class }); i >= 0 {
+        return hw.Counts[i]
+    }
+    return 0
+}
+
+// 旧库的 nodes 表补列；数量列名为 <类别>_count
+func initNodeHardware(db *sql.DB) error {
+    if err := addColumnIfMissing(db, "nodes", "capabilities", "TEXT"); err != nil {
+        return err
+    }
+    if err := addColumnIfMissing(db, "nodes", "devices", "TEXT"); err != nil {
+        return err
+    }
+    for _, c := range hardwareClasses {
+        if err := addColumnIfMissing(db, "nodes", c.Name+"_count", "INTEGER"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// capability=sriov,fpga：逗号分隔，全部都要有
+func parseCapabilities(v string) ([]string, error) {
+    if v == "" {
+        return nil, nil
+    }
+    var out []string
+    for _, c := range strings.Split(v, ",") {
+        c = strings.ToLower(strings.TrimSpace(c))
+        if !slices.ContainsFunc(hardwareClasses, func(h hardwareClass) bool { return h.Name == c }) {
+            return nil, fmt.Errorf("unknown capability %q", c)
+        }
+        out = append(out, c)
+    }
+    return out, nil
+}
+
+func hasCapabilities(caps string, want []string) bool {
+    have := strings.Split(caps, ",")
+    for _, w := range want {
+        if !slices.Contains(have, w) {
+            return false
+        }
+    }
+    return true
+}
+
+// SQL 里的同一条件
+func capabilitiesCond(want []string) (string, []any) {
+    var conds []string
+    var args []any
+    for _, w := range want {
+        conds = append(conds, `(','||coalesce(capabilities,'')||',') LIKE ?`)
+        args = append(args, "%,"+w+",%")
+    }
+    return strings.Join(conds, " AND "), args
+}
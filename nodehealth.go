@@ -0,0 +1,264 @@
+package main
+
+import (
+    "database/sql"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Node health history ----------
+
+// 节点 Ready 和各 pressure condition 的每次状态变化记一行，时间取 condition 的 lastTransitionTime，
+// 用来算一段时间内每个节点的可用率。只在 status 变化时写，reason / message 的变化不算。
+// 节点被删除时补一条 Ready=Unknown（reason NodeDeleted），之后的时间不算作可用。
+var healthConditions = []corev1.NodeConditionType{
+    corev1.NodeReady, corev1.NodeMemoryPressure, corev1.NodeDiskPressure, corev1.NodePIDPressure, corev1.NodeNetworkUnavailable,
+}
+
+func initNodeHealth(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS node_health_history(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    node TEXT NOT NULL,
+    condition TEXT NOT NULL,
+    status TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    message TEXT NOT NULL DEFAULT '',
+    since TEXT NOT NULL,
+    recorded_at TEXT NOT NULL
+);`,
+        `CREATE INDEX IF NOT EXISTS node_health_node ON node_health_history(node, condition, id)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 和该 condition 最近一条记录的 status 不同时才插入
+const nodeHealthInsert = `INSERT INTO node_health_history(node,condition,status,reason,message,since,recorded_at)
+SELECT ?1,?2,?3,?4,?5,?6,?7
+WHERE coalesce((SELECT status FROM node_health_history WHERE node=?1 AND condition=?2 ORDER BY id DESC LIMIT 1),'')<>?3`
+
+// <condition>=<status>：Ready 节点上 MemoryPressure 等的变化不改 nodes 的列，update 不能因此被跳过
+func nodeHealthStatuses(n *corev1.Node) []string {
+    var out []string
+    for _, c := range n.Status.Conditions {
+        if slices.Contains(healthConditions, c.Type) {
+            out = append(out, string(c.Type)+"="+string(c.Status))
+        }
+    }
+    return out
+}
+
+func recordNodeHealth(db querier, n *corev1.Node) error {
+    now := time.Now().UTC()
+    for _, c := range n.Status.Conditions {
+        if !slices.Contains(healthConditions, c.Type) {
+            continue
+        }
+        since := c.LastTransitionTime.Time
+        if since.IsZero() {
+            since = now
+        }
+        if _, err := db.Exec(nodeHealthInsert, n.Name, string(c.Type), string(c.Status), c.Reason, c.Message,
+            since.UTC().Format(time.RFC3339), now.Format(time.RFC3339)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 只对有过记录、且最近不是 Unknown 的节点补记
+func recordNodeRemoved(db querier, name string) error {
+    now := time.Now().UTC().Format(time.RFC3339)
+    _, err := db.Exec(nodeHealthInsert+` AND EXISTS(SELECT 1 FROM node_health_history WHERE node=?1)`,
+        name, string(corev1.NodeReady), string(corev1.ConditionUnknown), "NodeDeleted", "node was deleted", now, now)
+    return err
+}
+
+type NodeHealthTransition struct {
+    Condition  string `json:"condition"`
+    Status     string `json:"status"`
+    Reason     string `json:"reason,omitempty"`
+    Message    string `json:"message,omitempty"`
+    Since      string `json:"since"`
+    RecordedAt string `json:"recordedAt"`
+}
+
+type NodeConditionSummary struct {
+    Condition string `json:"condition"`
+    // to 时刻的状态
+    Status string `json:"status"`
+    // 窗口内各状态（True / False / Unknown）的秒数；第一条记录之前的时间不计入
+    Seconds map[string]float64 `json:"seconds"`
+}
+
+type NodeHealth struct {
+    Node string `json:"node"`
+    From string `json:"from"`
+    To   string `json:"to"`
+    // 窗口内有记录的时间里 Ready=True 的比例，没有 Ready 记录时为 null
+    Availability *float64               `json:"availability"`
+    Conditions   []NodeConditionSummary `json:"conditions"`
+    // 窗口 (from, to] 内的状态变化，按时间排序
+    Transitions []NodeHealthTransition `json:"transitions"`
+}
+
+// found 为 false 表示这个节点没有任何记录
+func buildNodeHealth(r *http.Request, db *sql.DB, node string, from, to time.Time, condition string) (*NodeHealth, bool, error) {
+    args := []any{node, to.Format(time.RFC3339)}
+    cond := ""
+    if condition != "" {
+        cond = " AND condition=?"
+        args = append(args, condition)
+    }
+    rows, err := db.QueryContext(r.Context(), `SELECT condition,status,reason,message,since,recorded_at FROM node_health_history
+ WHERE node=? AND since<=?`+cond+` ORDER BY condition,since,id`, args...)
+    if err != nil {
+        return nil, false, err
+    }
+    defer rows.Close()
+    h := &NodeHealth{Node: node, From: from.Format(time.RFC3339), To: to.Format(time.RFC3339),
+        Conditions: []NodeConditionSummary{}, Transitions: []NodeHealthTransition{}}
+    var cur *NodeConditionSummary
+    // 当前 condition 上一段的起点和状态
+    var segStart time.Time
+    closeSeg := func(end time.Time) {
+        if cur == nil || cur.Status == "" {
+            return
+        }
+        if start := maxTime(segStart, from); end.After(start) {
+            cur.Seconds[cur.Status] += end.Sub(start).Seconds()
+        }
+    }
+    found := false
+    for rows.Next() {
+        var t NodeHealthTransition
+        if err := rows.Scan(&t.Condition, &t.Status, &t.Reason, &t.Message, &t.Since, &t.RecordedAt); err != nil {
+            return nil, false, err
+        }
+        found = true
+        since, err := time.Parse(time.RFC3339, t.Since)
+        if err != nil {
+            return nil, false, err
+        }
+        if cur == nil || cur.Condition != t.Condition {
+            closeSeg(to)
+            h.Conditions = append(h.Conditions, NodeConditionSummary{Condition: t.Condition, Seconds: map[string]float64{}})
+            cur = &h.Conditions[len(h.Conditions)-1]
+        } else {
+            closeSeg(since)
+        }
+        cur.Status, segStart = t.Status, since
+        if since.After(from) {
+            h.Transitions = append(h.Transitions, t)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, false, err
+    }
+    closeSeg(to)
+    for _, c := range h.Conditions {
+        if c.Condition != string(corev1.NodeReady) {
+            continue
+        }
+        total := 0.0
+        for _, s := range c.Seconds {
+            total += s
+        }
+        if total > 0 {
+            a := c.Seconds[string(corev1.ConditionTrue)] / total
+            h.Availability = &a
+        }
+    }
+    slices.SortStableFunc(h.Transitions, func(a, b NodeHealthTransition) int { return strings.Compare(a.Since, b.Since) })
+    return h, found, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+    if a.After(b) {
+        return a
+    }
+    return b
+}
+
+// GET /cmdb/nodes/{name}/health?from=<RFC3339>&to=<RFC3339>&condition=Ready
+// from 默认 to 之前 7 天，to 默认现在；节点是集群级对象，限定 namespace 的 key 看不到
+func nodeHealthAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        node := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/cmdb/nodes/"), "/health")
+        if node == "" || strings.Contains(node, "/") {
+            http.Error(w, "not found", 404)
+            return
+        }
+        if !scopeOf(r.Context()).allows("") {
+            http.Error(w, "node "+node+" not found", 404)
+            return
+        }
+        q := r.URL.Query()
+        to := time.Now().UTC().Truncate(time.Second)
+        if v := q.Get("to"); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                http.Error(w, "to must be RFC3339", 400)
+                return
+            }
+            to = t.UTC()
+        }
+        from := to.Add(-7 * 24 * time.Hour)
+        if v := q.Get("from"); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                http.Error(w, "from must be RFC3339", 400)
+                return
+            }
+            from = t.UTC()
+        }
+        if !to.After(from) {
+            http.Error(w, "to must be after from", 400)
+            return
+        }
+        condition := q.Get("condition")
+        if condition != "" && !slices.Contains(healthConditions, corev1.NodeConditionType(condition)) {
+            http.Error(w, "unknown condition "+condition, 400)
+            return
+        }
+        h, found, err := buildNodeHealth(r, db, node, from, to, condition)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if !found {
+            http.Error(w, "no health history for node "+node, 404)
+            return
+        }
+        writeJSON(w, r, h)
+    }
+}
+
+// /cmdb/nodes/ 下：{name}/health 走健康历史，{name}/cordons 走 cordon 记录，其余是自定义属性的 PATCH
+func nodeSubresourceAPI(db *sql.DB, hot *hotReadModel) http.HandlerFunc {
+    health, cordons, attrs := nodeHealthAPI(db), nodeCordonsAPI(db), attributesAPI(db, hot, "nodes")
+    return func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasSuffix(r.URL.Path, "/health") {
+            health(w, r)
+            return
+        }
+        if strings.HasSuffix(r.URL.Path, "/cordons") {
+            cordons(w, r)
+            return
+        }
+        attrs(w, r)
+    }
+}
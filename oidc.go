@@ -0,0 +1,207 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/coreos/go-oidc/v3/oidc"
+    "golang.org/x/oauth2"
+)
+
+// ---------- OIDC ----------
+
+// 人通过 SSO 登录：浏览器走 /auth/login 授权码流程，拿到的 ID token 放进 HttpOnly cookie；
+// 脚本也可以直接用 Authorization: Bearer <id_token>。每次请求都校验签名和过期时间，服务端不存会话。
+// OIDC 用户只读：groups 映射到可见 namespace，不能调用 /admin/*。
+const (
+    sessionCookie = "lightcmdb_session"
+    stateCookie   = "lightcmdb_oidc_state"
+)
+
+type oidcAuth struct {
+    cfg      OIDCConfig
+    verifier *oidc.IDTokenVerifier
+    oauth    oauth2.Config
+}
+
+func newOIDCAuth(ctx context.Context, cfg OIDCConfig) (*oidcAuth, error) {
+    provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+    if err != nil {
+        return nil, fmt.Errorf("oidc discovery %s: %w", cfg.IssuerURL, err)
+    }
+    return &oidcAuth{
+        cfg:      cfg,
+        verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+        oauth: oauth2.Config{
+            ClientID:     cfg.ClientID,
+            ClientSecret: cfg.ClientSecret,
+            RedirectURL:  cfg.RedirectURL,
+            Endpoint:     provider.Endpoint(),
+            Scopes:       cfg.Scopes,
+        },
+    }, nil
+}
+
+var errNoGroupScope = errors.New("none of the user's groups grants access")
+
+func (o *oidcAuth) principal(ctx context.Context, raw string) (*principal, error) {
+    tok, err := o.verifier.Verify(ctx, raw)
+    if err != nil {
+        return nil, err
+    }
+    return o.principalOf(tok)
+}
+
+// 按 groups 计算 scope；多个组取并集，任一组映射到 "*" 则不限
+func (o *oidcAuth) principalOf(tok *oidc.IDToken) (*principal, error) {
+    var claims map[string]any
+    if err := tok.Claims(&claims); err != nil {
+        return nil, err
+    }
+    name := tok.Subject
+    if email, ok := claims["email"].(string); ok && email != "" {
+        name = email
+    }
+    var groups []string
+    switch v := claims[o.cfg.GroupsClaim].(type) {
+    case []any:
+        for _, g := range v {
+            if s, ok := g.(string); ok {
+                groups = append(groups, s)
+            }
+        }
+    case string:
+        groups = []string{v}
+    }
+    p := &principal{Name: "oidc:" + name, Scope: nsScope{}}
+    for _, g := range groups {
+        if slices.Contains(o.cfg.UnmaskGroups, g) {
+            p.Unmask = true
+        }
+    }
+    granted := false
+    seen := map[string]bool{}
+    for _, g := range groups {
+        nss, ok := o.cfg.Groups[g]
+        if !ok {
+            continue
+        }
+        granted = true
+        for _, ns := range nss {
+            if ns == "*" {
+                p.Scope = nil
+                return p, nil
+            }
+            if !seen[ns] {
+                seen[ns] = true
+                p.Scope = append(p.Scope, ns)
+            }
+        }
+    }
+    if !granted {
+        return nil, errNoGroupScope
+    }
+    return p, nil
+}
+
+// 只允许站内相对路径，防止 open redirect
+func safeNext(next string) string {
+    if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+        return "/docs"
+    }
+    return next
+}
+
+func randomToken() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(b)
+}
+
+// GET /auth/login?next=/docs
+func (o *oidcAuth) loginHandler(w http.ResponseWriter, r *http.Request) {
+    state := randomToken()
+    http.SetCookie(w, &http.Cookie{
+        Name:     stateCookie,
+        Value:    state + "|" + url.QueryEscape(safeNext(r.URL.Query().Get("next"))),
+        Path:     "/auth/",
+        MaxAge:   600,
+        HttpOnly: true,
+        Secure:   r.TLS != nil,
+        SameSite: http.SameSiteLaxMode,
+    })
+    http.Redirect(w, r, o.oauth.AuthCodeURL(state), http.StatusFound)
+}
+
+// GET /auth/callback?code=&state=
+func (o *oidcAuth) callbackHandler(w http.ResponseWriter, r *http.Request) {
+    c, err := r.Cookie(stateCookie)
+    if err != nil {
+        http.Error(w, "login expired, retry /auth/login", 400)
+        return
+    }
+    state, next, _ := strings.Cut(c.Value, "|")
+    if r.URL.Query().Get("state") != state {
+        http.Error(w, "state mismatch", 400)
+        return
+    }
+    if e := r.URL.Query().Get("error"); e != "" {
+        http.Error(w, "login failed: "+e+" "+r.URL.Query().Get("error_description"), 401)
+        return
+    }
+    ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+    defer cancel()
+    tok, err := o.oauth.Exchange(ctx, r.URL.Query().Get("code"))
+    if err != nil {
+        log.Printf("[oidc] code exchange: %v", err)
+        http.Error(w, "code exchange failed", 401)
+        return
+    }
+    raw, ok := tok.Extra("id_token").(string)
+    if !ok {
+        http.Error(w, "provider returned no id_token", 401)
+        return
+    }
+    idTok, err := o.verifier.Verify(ctx, raw)
+    if err != nil {
+        http.Error(w, "invalid id_token: "+err.Error(), 401)
+        return
+    }
+    p, err := o.principalOf(idTok)
+    if err != nil {
+        http.Error(w, err.Error(), 403)
+        return
+    }
+    log.Printf("[oidc] login %s scope=%v", p.Name, p.Scope)
+    http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1})
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookie,
+        Value:    raw,
+        Path:     "/",
+        Expires:  idTok.Expiry,
+        HttpOnly: true,
+        Secure:   r.TLS != nil,
+        SameSite: http.SameSiteLaxMode,
+    })
+    if next, err = url.QueryUnescape(next); err != nil {
+        next = ""
+    }
+    http.Redirect(w, r, safeNext(next), http.StatusFound)
+}
+
+// GET/POST /auth/logout 只清本地 cookie
+func (o *oidcAuth) logoutHandler(w http.ResponseWriter, r *http.Request) {
+    http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+    w.Write([]byte("logged out\n"))
+}
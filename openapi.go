@@ -0,0 +1,616 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "reflect"
+    "slices"
+    "strings"
+)
+
+// ---------- OpenAPI ----------
+
+// 新增接口时在 apiRoutes 里登记一条，/openapi.json 由此生成，schema 从 DTO 反射得到
+type apiParam struct {
+    Name     string
+    In       string // query / path / header
+    Desc     string
+    Required bool
+}
+
+type apiRoute struct {
+    Method   string
+    Path     string
+    Tag      string
+    Summary  string
+    Params   []apiParam
+    Body     any // 请求体 DTO 零值，nil 表示无
+    Response any // 响应 DTO 零值，切片表示数组
+    // 额外支持的响应类型（CSV/NDJSON 等）
+    Formats []string
+}
+
+var listFormats = []string{"application/json", "text/csv", "application/x-ndjson"}
+
+var formatParam = apiParam{Name: "format", In: "query", Desc: "json (default), csv or ndjson"}
+
+var fieldsParam = apiParam{Name: "fields", In: "query", Desc: "comma-separated field names to return, e.g. name,namespace,podIP"}
+
+var ifNoneMatchParam = apiParam{Name: "If-None-Match", In: "header", Desc: "ETag of an earlier response; 304 when the list is unchanged"}
+
+var labelSelectorParam = apiParam{Name: "labelSelector", In: "query", Desc: "Kubernetes label selector, e.g. env=prod,tier in (web,api),!legacy"}
+
+var cloudParams = []apiParam{{Name: "provider", In: "query"}, {Name: "region", In: "query"},
+    {Name: "tag", In: "query", Desc: "key=value"}, fieldsParam, formatParam}
+
+var apiRoutes = []apiRoute{
+    {Method: "GET", Path: "/healthz", Tag: "system", Summary: "Health check"},
+    {Method: "GET", Path: "/version", Tag: "system", Summary: "Version, commit, build date and Go runtime of the running build", Response: BuildInfo{}},
+    {Method: "GET", Path: "/metrics", Tag: "system", Summary: "Prometheus metrics; OpenMetrics with exemplars when requested via Accept"},
+    {Method: "GET", Path: "/auth/login", Tag: "auth", Summary: "Start OIDC login, redirects to the provider (auth.oidc)",
+        Params: []apiParam{{Name: "next", In: "query", Desc: "path to return to after login"}}},
+    {Method: "GET", Path: "/auth/callback", Tag: "auth", Summary: "OIDC redirect target, sets the session cookie"},
+    {Method: "POST", Path: "/auth/logout", Tag: "auth", Summary: "Clear the session cookie"},
+    {Method: "GET", Path: "/cmdb/pods", Tag: "inventory", Summary: "List pods",
+        Params: []apiParam{{Name: "ns", In: "query", Desc: "namespace filter"}, {Name: "team", In: "query", Desc: "owning team (ownership), empty for unowned"},
+            labelSelectorParam, fieldsParam, formatParam, ifNoneMatchParam},
+        Response: []PodRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/pods/flapping", Tag: "inventory", Summary: "Pods whose container restart count increased within the window, largest increase first",
+        Params:   []apiParam{{Name: "window", In: "query", Desc: "Go duration, default 1h, at most 24h"}, {Name: "min", In: "query", Desc: "minimum restarts in the window, default 1"}},
+        Response: []FlappingPod{}},
+    {Method: "GET", Path: "/cmdb/churn", Tag: "inventory", Summary: "Pod creates and deletes per namespace in the last window compared with the baseline, highest score first",
+        Params: []apiParam{{Name: "window", In: "query", Desc: "Go duration, default 1h, at most 24h"},
+            {Name: "baseline", In: "query", Desc: "period before the window to compare with, default 24h, at most 720h, at least twice the window"},
+            {Name: "threshold", In: "query", Desc: "score at which a namespace is anomalous, default 3"},
+            {Name: "min", In: "query", Desc: "minimum churn in the window for an anomaly, default 10"},
+            {Name: "ns", In: "query", Desc: "namespace filter"}, {Name: "anomalous", In: "query", Desc: "true to return only anomalous namespaces"},
+            fieldsParam, formatParam},
+        Response: []ChurnRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/pods/usage", Tag: "inventory", Summary: "Recent metrics-server usage samples of one pod (metricsServer.podHistory)",
+        Params: []apiParam{{Name: "uid", In: "query"}, {Name: "ns", In: "query", Desc: "with name, instead of uid"},
+            {Name: "name", In: "query"}, fieldsParam, formatParam},
+        Response: []PodUsageSample{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/nodes", Tag: "inventory", Summary: "List nodes",
+        Params:   []apiParam{{Name: "capability", In: "query", Desc: "sriov, gpu, tpu, fpga; comma-separated, all required"}, labelSelectorParam, fieldsParam, formatParam, ifNoneMatchParam},
+        Response: []NodeRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/nodes/{name}/health", Tag: "inventory", Summary: "Ready and pressure condition transitions of a node, time per status and Ready availability",
+        Params: []apiParam{{Name: "name", In: "path", Required: true}, {Name: "from", In: "query", Desc: "RFC3339, default 7 days before to"},
+            {Name: "to", In: "query", Desc: "RFC3339, default now"}, {Name: "condition", In: "query", Desc: "Ready, MemoryPressure, DiskPressure, PIDPressure or NetworkUnavailable"}},
+        Response: NodeHealth{}},
+    {Method: "GET", Path: "/cmdb/nodes/cordoned", Tag: "inventory", Summary: "Nodes that are cordoned now, with how long and how many pods were evicted since",
+        Params: []apiParam{fieldsParam, formatParam}, Response: []CordonedNode{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/nodes/{name}/cordons", Tag: "inventory", Summary: "Cordon periods and pod evictions of a node",
+        Params: []apiParam{{Name: "name", In: "path", Required: true}, {Name: "from", In: "query", Desc: "RFC3339, default 30 days before to"},
+            {Name: "to", In: "query", Desc: "RFC3339, default now"}},
+        Response: NodeCordons{}},
+    {Method: "GET", Path: "/cmdb/search", Tag: "inventory", Summary: "Full-text search across all CI types",
+        Params: []apiParam{
+            {Name: "q", In: "query", Desc: "search terms (prefix match, AND)", Required: true},
+            {Name: "type", In: "query", Desc: "pod, node, service or deployment"},
+            {Name: "limit", In: "query", Desc: "max hits (default 50, max 500)"},
+        },
+        Response: []SearchHit{}},
+    {Method: "GET", Path: "/cmdb/ip/{address}", Tag: "inventory", Summary: "Pods that held a pod IP, newest first; with at, only the holder at that time",
+        Params:   []apiParam{{Name: "address", In: "path", Required: true}, {Name: "at", In: "query", Desc: "RFC3339"}, fieldsParam, formatParam},
+        Response: []IPAssignment{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/serviceaccounts", Tag: "inventory", Summary: "Service accounts with pod and direct binding counts (rbac.enabled)",
+        Params:   []apiParam{{Name: "ns", In: "query"}, fieldsParam, formatParam},
+        Response: []ServiceAccountRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/serviceaccounts/{namespace}/{name}", Tag: "inventory", Summary: "Bindings and rules that apply to a service account, and the pods running as it",
+        Params: []apiParam{
+            {Name: "namespace", In: "path", Required: true}, {Name: "name", In: "path", Required: true},
+            {Name: "verb", In: "query", Desc: "only rules allowing this verb"}, {Name: "resource", In: "query", Desc: "only rules covering this resource"},
+        },
+        Response: ServiceAccountDetail{}},
+    {Method: "GET", Path: "/cmdb/subscribe", Tag: "history", Summary: "WebSocket: matching objects as snapshot messages, then added / modified / deleted",
+        Params: []apiParam{
+            {Name: "kind", In: "query", Desc: "one or more history kinds, comma separated", Required: true}, {Name: "ns", In: "query"},
+            {Name: "labelSelector", In: "query", Desc: "kubectl -l syntax"},
+        },
+        Response: SubscriptionEvent{}},
+    {Method: "GET", Path: "/cmdb/history", Tag: "history", Summary: "List change records, newest first",
+        Params: []apiParam{
+            {Name: "id", In: "query", Desc: "single change record, e.g. a metrics exemplar change_id"},
+            {Name: "kind", In: "query"}, {Name: "ref", In: "query"}, {Name: "ns", In: "query"},
+            {Name: "name", In: "query"}, {Name: "since", In: "query", Desc: "RFC3339"},
+            {Name: "limit", In: "query"}, fieldsParam, formatParam,
+        },
+        Response: []ChangeRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/history/diff", Tag: "history", Summary: "Diff the object state after two change records",
+        Params: []apiParam{
+            {Name: "from", In: "query", Desc: "change id", Required: true},
+            {Name: "to", In: "query", Desc: "change id of the same object", Required: true},
+            {Name: "format", In: "query", Desc: "json (default), text (unified diff) or html (side-by-side page)"},
+        },
+        Response: HistoryDiff{}, Formats: []string{"application/json", "text/x-diff", "text/html"}},
+    {Method: "GET", Path: "/cmdb/export", Tag: "history", Summary: "All history-tracked kinds in one streamed document from one read snapshot",
+        Params:   []apiParam{{Name: "kind", In: "query", Desc: "comma-separated history kinds, default all"}, {Name: "format", In: "query", Desc: "json (default) or ndjson"}},
+        Response: InventoryExport{}, Formats: []string{"application/json", "application/x-ndjson"}},
+    {Method: "GET", Path: "/cmdb/compare", Tag: "history", Summary: "Objects added, removed or changed between two points in time, grouped by kind",
+        Params: []apiParam{
+            {Name: "from", In: "query", Desc: "RFC3339", Required: true},
+            {Name: "to", In: "query", Desc: "RFC3339, default now"},
+            {Name: "kind", In: "query", Desc: "comma-separated history kinds"}, {Name: "ns", In: "query"},
+        },
+        Response: CompareReport{}},
+    {Method: "GET", Path: "/cmdb/topology", Tag: "inventory", Summary: "Node/edge graph around a CI for impact analysis",
+        Params: []apiParam{
+            {Name: "root", In: "query", Desc: "node/<name>, host/<address>, asset/<type>/<name> or pod|service|deployment|secret|configmap|pvc|sa/<namespace>/<name>", Required: true},
+            {Name: "depth", In: "query", Desc: "hops from root (default 2, max 4)"},
+        },
+        Response: Topology{}},
+    {Method: "GET", Path: "/cmdb/relations", Tag: "inventory", Summary: "Manually maintained relations between CIs",
+        Params:   []apiParam{{Name: "ci", In: "query", Desc: "topology node id of either end"}, {Name: "type", In: "query"}, fieldsParam, formatParam},
+        Response: []RelationRow{}, Formats: listFormats},
+    {Method: "POST", Path: "/cmdb/relations", Tag: "inventory", Summary: "Create a manual relation between two existing CIs",
+        Body: RelationRequest{}, Response: RelationRow{}},
+    {Method: "DELETE", Path: "/cmdb/relations/{id}", Tag: "inventory", Summary: "Delete a manual relation",
+        Params: []apiParam{{Name: "id", In: "path", Required: true}}, Response: map[string]any{}},
+    {Method: "GET", Path: "/cmdb/loadbalancers", Tag: "inventory", Summary: "LoadBalancer services with advertised IPs, address pool and announcing node",
+        Params:   []apiParam{{Name: "ns", In: "query"}, fieldsParam, formatParam},
+        Response: []LoadBalancerRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/certificates", Tag: "inventory", Summary: "TLS Secrets (certificates.enabled) and cert-manager Certificates by expiry, soonest first",
+        Params: []apiParam{
+            {Name: "expiringWithin", In: "query", Desc: "e.g. 30d or 72h; expired certificates are included"}, {Name: "ns", In: "query"},
+            {Name: "source", In: "query", Desc: "secret or cert-manager"}, fieldsParam, formatParam,
+        },
+        Response: []CertificateRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/tags", Tag: "inventory", Summary: "Number of CIs per rule-assigned tag and kind (tagging.rules)",
+        Params:   []apiParam{{Name: "kind", In: "query", Desc: "pods, deployments, services or nodes"}, {Name: "ns", In: "query"}, fieldsParam, formatParam},
+        Response: []TagCount{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/tags/{tag}", Tag: "inventory", Summary: "CIs carrying a rule-assigned tag, with the first matching rule",
+        Params: []apiParam{
+            {Name: "tag", In: "path", Required: true}, {Name: "kind", In: "query", Desc: "pods, deployments, services or nodes"},
+            {Name: "ns", In: "query"}, fieldsParam, formatParam,
+        },
+        Response: []TaggedCI{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/helm/releases", Tag: "inventory", Summary: "Helm releases with chart, version, status and values hash (helm.enabled)",
+        Params:   []apiParam{{Name: "ns", In: "query"}, {Name: "chart", In: "query"}, {Name: "status", In: "query", Desc: "deployed, failed, pending-upgrade, ..."}, fieldsParam, formatParam},
+        Response: []HelmReleaseRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/helm/releases/{namespace}/{name}", Tag: "inventory", Summary: "A Helm release with the objects in its manifest",
+        Params:   []apiParam{{Name: "namespace", In: "path", Required: true}, {Name: "name", In: "path", Required: true}},
+        Response: HelmReleaseDetail{}},
+    {Method: "GET", Path: "/cmdb/apps", Tag: "inventory", Summary: "Argo CD Applications with sync/health status and target revision",
+        Params: []apiParam{{Name: "ns", In: "query"}, {Name: "project", In: "query"}, {Name: "sync", In: "query", Desc: "Synced, OutOfSync or Unknown"},
+            {Name: "health", In: "query", Desc: "Healthy, Progressing, Degraded, ..."}, fieldsParam, formatParam},
+        Response: []AppRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/apps/{namespace}/{name}", Tag: "inventory", Summary: "An Argo CD Application with the workloads it manages",
+        Params:   []apiParam{{Name: "namespace", In: "path", Required: true}, {Name: "name", In: "path", Required: true}},
+        Response: App{}},
+    {Method: "GET", Path: "/cmdb/images", Tag: "inventory", Summary: "Unique running image references with the pods, namespaces and nodes using them",
+        Params: []apiParam{{Name: "repository", In: "query", Desc: "substring match"}, {Name: "registry", In: "query"},
+            {Name: "tag", In: "query"}, {Name: "digest", In: "query"}, {Name: "ns", In: "query"},
+            {Name: "severity", In: "query", Desc: "only images with a CVE of this severity or worse (scanner)"}, fieldsParam, formatParam},
+        Response: []ImageRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/images/compliance", Tag: "inventory", Summary: "Pod images from registries outside images.allowedRegistries or using the latest tag",
+        Params:   []apiParam{{Name: "ns", In: "query"}, {Name: "violation", In: "query", Desc: "registry, latest, pullPolicy or pullPolicyUnknown"}, fieldsParam, formatParam},
+        Response: []ImageComplianceRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/projections/{kind}", Tag: "inventory", Summary: "Extra columns configured under projections, filtered by ?<column>=value",
+        Params: []apiParam{{Name: "kind", In: "path", Required: true, Desc: "pods, nodes, services, deployments, ..."}, {Name: "ns", In: "query"},
+            fieldsParam, formatParam},
+        Response: []ProjectionRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/hosts", Tag: "inventory", Summary: "Hosts outside Kubernetes discovered over SSH (hostDiscovery)",
+        Params:   []apiParam{{Name: "service", In: "query", Desc: "running systemd service"}, {Name: "os", In: "query", Desc: "substring match"}, fieldsParam, formatParam, ifNoneMatchParam},
+        Response: []HostRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/network-devices", Tag: "inventory", Summary: "Switches and routers polled over SNMP (snmp)",
+        Params:   []apiParam{{Name: "node", In: "query", Desc: "devices whose ARP table links them to this node"}, fieldsParam, formatParam},
+        Response: []NetworkDeviceRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/network-devices/{address}", Tag: "inventory", Summary: "A network device with its interfaces and links to nodes",
+        Params:   []apiParam{{Name: "address", In: "path", Required: true}},
+        Response: NetworkDevice{}},
+    {Method: "GET", Path: "/cmdb/cloud/instances", Tag: "inventory", Summary: "Cloud instances with tags and the Kubernetes node with the same provider ID",
+        Params:   cloudParams,
+        Response: []CloudInstanceRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/cloud/volumes", Tag: "inventory", Summary: "Cloud block volumes with the instance and node they are attached to",
+        Params:   cloudParams,
+        Response: []CloudVolumeRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/cloud/securitygroups", Tag: "inventory", Summary: "Cloud security groups with their ingress rules",
+        Params:   cloudParams,
+        Response: []CloudSecurityGroupRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/alerts", Tag: "inventory", Summary: "Objects currently matching an alert rule, pending (for not yet elapsed) or firing",
+        Params:   []apiParam{{Name: "rule", In: "query"}, {Name: "firing", In: "query", Desc: "true: only alerts that were notified"}, fieldsParam, formatParam},
+        Response: []AlertRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/stats", Tag: "inventory", Summary: "Pod counts by namespace, phase and node, node capacity, image usage and last write per kind",
+        Response: InventoryStats{}},
+    {Method: "GET", Path: "/cmdb/architectures", Tag: "inventory", Summary: "Nodes per architecture, OS and container runtime, and workloads pinned to architectures",
+        Params:   []apiParam{{Name: "arch", In: "query", Desc: "only pinned workloads that cannot run on this architecture"}},
+        Response: ArchitectureReport{}},
+    {Method: "GET", Path: "/cmdb/unused", Tag: "inventory", Summary: "Likely unused ConfigMaps and Secrets (unused.enabled), Services without ready endpoints, unmounted PVCs and images only run by completed pods",
+        Params:   []apiParam{{Name: "kind", In: "query", Desc: "configmap, secret, service, pvc or image"}, {Name: "namespace", In: "query"}, fieldsParam, formatParam},
+        Response: []UnusedRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/status", Tag: "inventory", Summary: "Per synced kind: cache synced, last event, write and reconcile, row count, retries, drops, watch errors and startup backfill progress; on a follower, the writer's state when the served snapshot was taken, plus snapshotTaken and replicaLag",
+        Params:   []apiParam{fieldsParam, formatParam},
+        Response: []SyncStatus{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/nodegroups", Tag: "inventory", Summary: "Capacity, pods, requests and utilization per configured node group",
+        Params:   []apiParam{{Name: "group", In: "query", Desc: "only this nodeGroups entry"}, formatParam},
+        Response: []NodeGroupRow{}},
+    {Method: "GET", Path: "/cmdb/workloads", Tag: "inventory", Summary: "Pods grouped by top-level controller with desired and ready counts, images and nodes",
+        Params: []apiParam{{Name: "ns", In: "query"}, {Name: "kind", In: "query", Desc: "Deployment, StatefulSet, DaemonSet, Job, Pod, ..."},
+            {Name: "team", In: "query", Desc: "owning team (ownership), empty for unowned"}, formatParam},
+        Response: []WorkloadRow{}},
+    {Method: "GET", Path: "/cmdb/teams", Tag: "inventory", Summary: "Namespaces, pods, requests, deployments, services and claims per owning team",
+        Params:   []apiParam{{Name: "team", In: "query"}, formatParam},
+        Response: []TeamStats{}},
+    {Method: "GET", Path: "/cmdb/storage", Tag: "inventory", Summary: "Requested vs provisioned PVC capacity per StorageClass or namespace, with the PV pool per class",
+        Params:   []apiParam{{Name: "by", In: "query", Desc: "class (default) or namespace"}, formatParam},
+        Response: []StorageRow{}},
+    {Method: "GET", Path: "/cmdb/quotas", Tag: "inventory", Summary: "ResourceQuota usage against hard limits per namespace or per quota item, flagging those above the threshold",
+        Params: []apiParam{
+            {Name: "by", In: "query", Desc: "namespace (default) or resource"}, {Name: "ns", In: "query"},
+            {Name: "threshold", In: "query", Desc: "used/hard ratio, default quotas.threshold"}, {Name: "over", In: "query", Desc: "true: only rows above the threshold"},
+            fieldsParam, formatParam,
+        },
+        Response: []QuotaNamespaceRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/limitranges", Tag: "inventory", Summary: "LimitRange min/max/defaults, one row per limit type and resource",
+        Params:   []apiParam{{Name: "ns", In: "query"}, fieldsParam, formatParam},
+        Response: []LimitRangeRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/gpus", Tag: "inventory", Summary: "GPU or other extended resource capacity and pod requests per node or namespace",
+        Params: []apiParam{{Name: "by", In: "query", Desc: "node (default) or namespace"},
+            {Name: "resource", In: "query", Desc: "comma-separated resource names, or all; default: GPU resources"}, fieldsParam, formatParam},
+        Response: []GPURow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/costs", Tag: "inventory", Summary: "Current hourly node cost apportioned to namespaces or workloads by pod requests",
+        Params:   []apiParam{{Name: "by", In: "query", Desc: "namespace (default) or workload"}, fieldsParam, formatParam},
+        Response: []CostRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/vms", Tag: "inventory", Summary: "KubeVirt VirtualMachines and standalone VMIs with guest OS, resources, node and virt-launcher pod",
+        Params:   []apiParam{{Name: "ns", In: "query"}, fieldsParam, formatParam, ifNoneMatchParam},
+        Response: []VMRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/assets", Tag: "assets", Summary: "List manually maintained assets",
+        Params:   []apiParam{{Name: "type", In: "query"}, {Name: "site", In: "query"}, {Name: "owner", In: "query"}, {Name: "source", In: "query", Desc: "manual or the pushing system"}, labelSelectorParam, fieldsParam, formatParam, ifNoneMatchParam},
+        Response: []AssetRow{}, Formats: listFormats},
+    {Method: "POST", Path: "/cmdb/assets", Tag: "assets", Summary: "Create or replace assets by type and name (object or array)",
+        Body: []Asset{}, Response: map[string]any{}},
+    {Method: "PUT", Path: "/cmdb/external/{type}/{id}", Tag: "assets", Summary: "Create or replace a CI pushed by an external system, with its relations from that source",
+        Params: []apiParam{{Name: "type", In: "path", Required: true}, {Name: "id", In: "path", Required: true, Desc: "id in the external system, stored as the asset name"}},
+        Body:   ExternalCI{}, Response: ExternalCIResult{}},
+    {Method: "POST", Path: "/cmdb/external/{type}/{id}", Tag: "assets", Summary: "Same as PUT",
+        Params: []apiParam{{Name: "type", In: "path", Required: true}, {Name: "id", In: "path", Required: true}},
+        Body:   ExternalCI{}, Response: ExternalCIResult{}},
+    {Method: "PATCH", Path: "/cmdb/assets", Tag: "assets", Summary: "Bulk-update owner, site or labels of all assets matching a filter",
+        Params: []apiParam{{Name: "dryRun", In: "query", Desc: "true: only return what would change"}},
+        Body:   BulkPatchRequest{}, Response: BulkPatchResult{}},
+    {Method: "PATCH", Path: "/cmdb/pods/{uid}", Tag: "assets", Summary: "Set (string) or remove (null) custom attributes of a pod",
+        Params: []apiParam{{Name: "uid", In: "path", Required: true}}, Body: AttributePatch{}, Response: map[string]any{}},
+    {Method: "PATCH", Path: "/cmdb/nodes/{name}", Tag: "assets", Summary: "Set (string) or remove (null) custom attributes of a node",
+        Params: []apiParam{{Name: "name", In: "path", Required: true}}, Body: AttributePatch{}, Response: map[string]any{}},
+    {Method: "PATCH", Path: "/cmdb/hosts/{address}", Tag: "assets", Summary: "Set (string) or remove (null) custom attributes of a discovered host",
+        Params: []apiParam{{Name: "address", In: "path", Required: true}}, Body: AttributePatch{}, Response: map[string]any{}},
+    {Method: "POST", Path: "/cmdb/import", Tag: "assets", Summary: "Import assets from a CSV file (text/csv) or a JSON array, all or nothing",
+        Params: []apiParam{{Name: "format", In: "query", Desc: "csv: parse the body as CSV regardless of Content-Type"},
+            {Name: "dryRun", In: "query", Desc: "true: validate and count, write nothing"}},
+        Body: []Asset{}, Response: ImportResult{}},
+    {Method: "GET", Path: "/cmdb/metering", Tag: "reports", Summary: "Monthly pod-hours and request-hours per namespace",
+        Params:   []apiParam{{Name: "month", In: "query", Desc: "YYYY-MM (default: current month)"}, fieldsParam, formatParam},
+        Response: []MeteringRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/cmdb/references", Tag: "inventory", Summary: "Pods and Deployments referencing a ConfigMap, Secret, PVC or ServiceAccount",
+        Params: []apiParam{
+            {Name: "kind", In: "query", Desc: "Secret, ConfigMap, PersistentVolumeClaim (pvc) or ServiceAccount (sa)", Required: true},
+            {Name: "name", In: "query", Desc: "ns/name, or name to search all namespaces", Required: true},
+            fieldsParam, formatParam,
+        },
+        Response: []ReferenceRow{}, Formats: listFormats},
+    {Method: "POST", Path: "/graphql", Tag: "inventory", Summary: "GraphQL query over pods, nodes, services and deployments with their relations",
+        Body: graphqlRequest{}, Response: map[string]any{}},
+    {Method: "POST", Path: "/cmdb/batch", Tag: "inventory", Summary: "Up to 1000 CIs by type and id in one request, in request order, from one read snapshot",
+        Body: BatchRequest{}, Response: BatchResult{}},
+    {Method: "GET", Path: "/cmdb/live/pods/{ns}/{name}", Tag: "live", Summary: "Pod straight from the informer cache (liveProxy.enabled)",
+        Params: []apiParam{{Name: "ns", In: "path", Required: true}, {Name: "name", In: "path", Required: true}}},
+    {Method: "GET", Path: "/cmdb/live/nodes/{name}", Tag: "live", Summary: "Node straight from the informer cache (liveProxy.enabled)",
+        Params: []apiParam{{Name: "name", In: "path", Required: true}}},
+    {Method: "POST", Path: "/admin/purge", Tag: "admin", Summary: "Delete rows not refreshed for olderThan (two-phase)",
+        Params: []apiParam{
+            {Name: "resource", In: "query", Desc: "pods or nodes", Required: true},
+            {Name: "olderThan", In: "query", Desc: "duration, e.g. 24h", Required: true},
+            {Name: "ns", In: "query"},
+            {Name: "confirm", In: "query", Desc: "token from the dry run"},
+        }},
+    {Method: "GET", Path: "/admin/status", Tag: "admin", Summary: "Process status and approximate informer cache usage per kind", Response: AdminStatus{}},
+    {Method: "GET", Path: "/admin/exporters", Tag: "admin", Summary: "Status of outbound exporters (last success, failures, enabled)", Response: []ExporterStatus{}},
+    {Method: "POST", Path: "/admin/exporters", Tag: "admin", Summary: "Enable or disable an exporter until restart",
+        Params:   []apiParam{{Name: "name", In: "query", Required: true}, {Name: "enabled", In: "query", Desc: "true or false", Required: true}},
+        Response: ExporterStatus{}},
+    {Method: "GET", Path: "/admin/collectors", Tag: "admin", Summary: "Status of inventory collectors (informers, periodic pollers, standalone watches)", Response: []CollectorStatus{}},
+    {Method: "POST", Path: "/admin/collectors", Tag: "admin", Summary: "Stop, start or resync a collector now; stopping lasts until restart",
+        Params:   []apiParam{{Name: "name", In: "query", Required: true}, {Name: "action", In: "query", Desc: "resync, stop or start", Required: true}},
+        Response: CollectorStatus{}},
+    {Method: "GET", Path: "/admin/diff", Tag: "admin", Summary: "Compare a fresh list from the API server with the DB (missing, stale, ghost)",
+        Params:   []apiParam{{Name: "kinds", In: "query", Desc: "comma-separated: pods, nodes, services, deployments, replicasets, storageclasses, persistentvolumes, persistentvolumeclaims, resourcequotas, limitranges (default all)"}},
+        Response: SyncDiffReport{}},
+    {Method: "POST", Path: "/admin/reconcile", Tag: "admin", Summary: "Repair the differences /admin/diff reports (history source reconcile)",
+        Response: reconcileStats{}},
+    {Method: "POST", Path: "/admin/resync", Tag: "admin", Summary: "List one resource from the API server now and repair its rows, like /admin/reconcile for a single kind",
+        Params:   []apiParam{{Name: "resource", In: "query", Required: true, Desc: "a kind of /admin/diff, e.g. pods"}},
+        Response: resyncResult{}},
+    {Method: "GET", Path: "/admin/watchers", Tag: "admin", Summary: "Sync state per informer kind (paused, events discarded while paused)", Response: []WatcherStatus{}},
+    {Method: "POST", Path: "/admin/watchers", Tag: "admin", Summary: "Pause or resume writing informer events to the DB until restart; resuming requeues every key",
+        Params: []apiParam{{Name: "resource", In: "query", Required: true, Desc: "comma-separated kinds, or all"},
+            {Name: "paused", In: "query", Desc: "true or false", Required: true}},
+        Response: []WatcherStatus{}},
+    {Method: "GET", Path: "/admin/check", Tag: "admin", Summary: "Referential integrity of the DB: dangling pods, relations, refs and history", Response: CheckReport{}},
+    {Method: "POST", Path: "/admin/check", Tag: "admin", Summary: "Repair what the check finds (two-phase); 409 in --dry-run",
+        Params: []apiParam{{Name: "confirm", In: "query", Desc: "token from the dry run"}}},
+    {Method: "GET", Path: "/admin/shadow", Tag: "admin", Summary: "Pending writes recorded by --dry-run --shadow, one row per object",
+        Params:   []apiParam{{Name: "kind", In: "query"}, {Name: "action", In: "query", Desc: "insert, update or delete"}, fieldsParam, formatParam},
+        Response: []ShadowRow{}, Formats: listFormats},
+    {Method: "GET", Path: "/admin/replica/snapshot", Tag: "admin", Summary: "Consistent copy of the SQLite DB (application/vnd.sqlite3) for follower instances"},
+    {Method: "GET", Path: "/admin/replica", Tag: "admin", Summary: "Follower only: last pull attempt, success, error and the snapshot served", Response: ReplicaStatus{}},
+    {Method: "GET", Path: "/admin/consumers", Tag: "admin", Summary: "Requests, rows and bytes exported per consumer and integration",
+        Response: ConsumerReport{}},
+    {Method: "POST", Path: "/admin/history/compact", Tag: "admin", Summary: "Run history compaction now", Response: compactStats{}},
+    {Method: "GET", Path: "/admin/maintenance", Tag: "admin", Summary: "Result of the last DB maintenance run", Response: MaintenanceStats{}},
+    {Method: "POST", Path: "/admin/maintenance", Tag: "admin", Summary: "Run DB maintenance now (incremental vacuum, ANALYZE, WAL checkpoint)", Response: MaintenanceStats{}},
+    {Method: "POST", Path: "/admin/snapshot", Tag: "admin", Summary: "Render and commit the Git inventory snapshot now (gitSnapshot.enabled)",
+        Response: snapshotStats{}},
+    {Method: "GET", Path: "/admin/report", Tag: "admin", Summary: "Inventory summary for the last day or week: new/removed pods, node changes, top images, drift",
+        Params: []apiParam{{Name: "period", In: "query", Desc: "daily or weekly (default reports.schedule, else daily)"},
+            {Name: "format", In: "query", Desc: "json (default) or html"}},
+        Response: InventoryReport{}},
+    {Method: "POST", Path: "/admin/report", Tag: "admin", Summary: "Generate the summary now and send it to the configured webhook and email (reports.schedule)",
+        Params:   []apiParam{{Name: "period", In: "query", Desc: "daily or weekly"}},
+        Response: InventoryReport{}},
+    {Method: "GET", Path: "/admin/baselines", Tag: "admin", Summary: "Named inventory baselines, newest first", Response: []BaselineRow{}},
+    {Method: "POST", Path: "/admin/baselines", Tag: "admin", Summary: "Freeze the current inventory as a named baseline",
+        Body: BaselineRequest{}, Response: BaselineRow{}},
+    {Method: "GET", Path: "/admin/baselines/{name}", Tag: "admin", Summary: "A named baseline",
+        Params: []apiParam{{Name: "name", In: "path", Required: true}}, Response: BaselineRow{}},
+    {Method: "DELETE", Path: "/admin/baselines/{name}", Tag: "admin", Summary: "Delete a baseline (two-phase)",
+        Params: []apiParam{{Name: "name", In: "path", Required: true}, {Name: "confirm", In: "query", Desc: "token from the dry run"}}},
+    {Method: "GET", Path: "/admin/baselines/{name}/diff", Tag: "admin", Summary: "Objects added, removed or changed since the baseline, grouped by kind",
+        Params: []apiParam{
+            {Name: "name", In: "path", Required: true},
+            {Name: "kind", In: "query", Desc: "comma-separated history kinds"}, {Name: "ns", In: "query"},
+        },
+        Response: BaselineDiff{}},
+    {Method: "POST", Path: "/admin/servicenow/sync", Tag: "admin", Summary: "Push creates and updates to the ServiceNow CMDB now (serviceNow.instance)",
+        Response: ServiceNowStats{}},
+    {Method: "GET", Path: "/federation/config", Tag: "federation", Summary: "Get the global config or a site override (hub)",
+        Params: []apiParam{{Name: "site", In: "query"}}},
+    {Method: "PUT", Path: "/federation/config", Tag: "federation", Summary: "Replace the global config or a site override (hub)",
+        Params: []apiParam{{Name: "site", In: "query"}}, Body: map[string]any{}},
+    {Method: "DELETE", Path: "/federation/config", Tag: "federation", Summary: "Remove a site override (hub)",
+        Params: []apiParam{{Name: "site", In: "query", Required: true}}},
+    {Method: "GET", Path: "/federation/config/effective", Tag: "federation", Summary: "Merged config for a site (hub)",
+        Params: []apiParam{{Name: "site", In: "query"}}, Response: EffectiveConfig{}},
+    {Method: "GET", Path: "/federation/status", Tag: "federation", Summary: "Rollout status per site (hub)", Response: []RolloutRow{}},
+    {Method: "POST", Path: "/federation/clusters", Tag: "federation", Summary: "Edge reports its cluster inventory (hub)", Body: ClusterReport{}},
+    {Method: "GET", Path: "/cmdb/clusters", Tag: "federation", Summary: "Edge clusters with health, version, node and pod counts (hub)", Response: []ClusterRow{}},
+    {Method: "GET", Path: "/cmdb/clusters/{site}", Tag: "federation", Summary: "One edge cluster with its nodes (hub)",
+        Params: []apiParam{{Name: "site", In: "path", Required: true}}, Response: ClusterRow{}},
+    {Method: "POST", Path: "/federation/status", Tag: "federation", Summary: "Edge reports the applied config version (hub)", Body: RolloutReport{}},
+    {Method: "GET", Path: "/cmdb/instances", Tag: "federation", Summary: "LightCMDB instances with heartbeat, version and collector status; on a hub also every edge",
+        Params:   []apiParam{{Name: "site", In: "query"}, {Name: "health", In: "query", Desc: "healthy, degraded or stale"}},
+        Response: []InstanceRow{}},
+    {Method: "GET", Path: "/cmdb/instances/{host}", Tag: "federation", Summary: "One LightCMDB instance with its collectors",
+        Params:   []apiParam{{Name: "host", In: "path", Required: true}, {Name: "site", In: "query", Desc: "required when the host name exists in several sites"}},
+        Response: InstanceRow{}},
+}
+
+type schemaBuilder struct {
+    components map[string]any
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+    if t == reflect.TypeOf(RawJSON("")) {
+        return map[string]any{"description": "arbitrary JSON"}
+    }
+    switch t.Kind() {
+    case reflect.Ptr:
+        return b.schema(t.Elem())
+    case reflect.String:
+        return map[string]any{"type": "string"}
+    case reflect.Bool:
+        return map[string]any{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]any{"type": "integer"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]any{"type": "number"}
+    case reflect.Slice, reflect.Array:
+        return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+    case reflect.Map:
+        return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+    case reflect.Interface:
+        return map[string]any{}
+    case reflect.Struct:
+        if t == reflect.TypeOf(Duration{}) {
+            return map[string]any{"type": "string", "example": "30s"}
+        }
+        name := t.Name()
+        if name == "" {
+            return b.object(t)
+        }
+        if _, ok := b.components[name]; !ok {
+            b.components[name] = map[string]any{} // 先占位，防止递归类型死循环
+            b.components[name] = b.object(t)
+        }
+        return map[string]any{"$ref": "#/components/schemas/" + name}
+    }
+    return map[string]any{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+    props := map[string]any{}
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        if !f.IsExported() {
+            continue
+        }
+        tag := strings.Split(f.Tag.Get("json"), ",")
+        name := tag[0]
+        if name == "-" {
+            continue
+        }
+        if f.Anonymous && name == "" {
+            if emb, ok := b.object(f.Type)["properties"].(map[string]any); ok {
+                for k, v := range emb {
+                    props[k] = v
+                }
+            }
+            continue
+        }
+        if name == "" {
+            name = f.Name
+        }
+        props[name] = b.schema(f.Type)
+    }
+    return map[string]any{"type": "object", "properties": props}
+}
+
+// v1 为 true 时描述 /api/v1：路径相对于 servers 里的 /api/v1，列表接口多了 page / pageSize，响应是 envelope
+func buildOpenAPI(v1 bool) map[string]any {
+    b := &schemaBuilder{components: map[string]any{}}
+    paths := map[string]map[string]any{}
+    for _, rt := range apiRoutes {
+        op := map[string]any{
+            "summary":     rt.Summary,
+            "operationId": operationID(rt),
+        }
+        if rt.Tag != "" {
+            op["tags"] = []string{rt.Tag}
+        }
+        list := v1 && slices.Equal(rt.Formats, listFormats)
+        var params []map[string]any
+        ps := rt.Params
+        if list {
+            ps = append(slices.Clip(ps), pageParams...)
+        }
+        for _, p := range ps {
+            params = append(params, map[string]any{
+                "name":        p.Name,
+                "in":          p.In,
+                "description": p.Desc,
+                "required":    p.Required || p.In == "path",
+                "schema":      map[string]any{"type": "string"},
+            })
+        }
+        if params != nil {
+            op["parameters"] = params
+        }
+        if rt.Body != nil {
+            op["requestBody"] = map[string]any{
+                "required": true,
+                "content":  map[string]any{"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(rt.Body))}},
+            }
+        }
+        resp := map[string]any{"description": "OK"}
+        if rt.Response != nil {
+            content := map[string]any{}
+            formats := rt.Formats
+            if len(formats) == 0 {
+                formats = []string{"application/json"}
+            }
+            for _, f := range formats {
+                if f == "application/json" {
+                    schema := b.schema(reflect.TypeOf(rt.Response))
+                    if list {
+                        schema = b.envelope(schema)
+                    }
+                    content[f] = map[string]any{"schema": schema}
+                } else {
+                    content[f] = map[string]any{"schema": map[string]any{"type": "string"}}
+                }
+            }
+            resp["content"] = content
+        }
+        responses := map[string]any{"200": resp}
+        if protectedPath(rt.Path) {
+            op["security"] = []map[string]any{{"bearerAuth": []string{}}, {"apiKeyAuth": []string{}}}
+            responses["401"] = map[string]any{"description": "missing or invalid API key"}
+            responses["403"] = map[string]any{"description": "outside the API key's namespace or cluster scope"}
+            responses["413"] = map[string]any{"description": "request body or result over the configured limits"}
+            responses["429"] = map[string]any{"description": "rate limit exceeded, see Retry-After"}
+        }
+        op["responses"] = responses
+        if paths[rt.Path] == nil {
+            paths[rt.Path] = map[string]any{}
+        }
+        paths[rt.Path][strings.ToLower(rt.Method)] = op
+    }
+    info := map[string]any{
+        "title":   "LightCMDB API",
+        "version": "legacy",
+        "description": "Unversioned paths with bare list arrays, kept for existing consumers. " +
+            "The stable API is described at /api/v1/openapi.json.",
+    }
+    doc := map[string]any{
+        "openapi": "3.0.3",
+        "info":    info,
+        "paths":   paths,
+        "components": map[string]any{
+            "schemas": b.components,
+            "securitySchemes": map[string]any{
+                "bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+                "apiKeyAuth": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+            },
+        },
+    }
+    if v1 {
+        info["version"] = "v1"
+        info["description"] = "JSON list responses are wrapped in {items, total, page, freshness, warnings} and paged with " +
+            "?page= and ?pageSize= (default 100, max 1000). Fields are only added within v1, never changed or removed."
+        doc["servers"] = []map[string]any{{"url": apiV1Prefix}}
+    }
+    return doc
+}
+
+var pageParams = []apiParam{
+    {Name: "page", In: "query", Desc: "1-based, default 1"},
+    {Name: "pageSize", In: "query", Desc: fmt.Sprintf("default %d, max %d", defaultEnvelopePage, maxEnvelopePage)},
+}
+
+// /api/v1 列表响应的外层，items 是原来的数组
+func (b *schemaBuilder) envelope(items map[string]any) map[string]any {
+    return map[string]any{"type": "object", "properties": map[string]any{
+        "items":     items,
+        "total":     map[string]any{"type": "integer"},
+        "page":      b.schema(reflect.TypeOf(PageInfo{})),
+        "freshness": b.schema(reflect.TypeOf(Freshness{})),
+        "warnings":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+    }}
+}
+
+func operationID(rt apiRoute) string {
+    var parts []string
+    for _, p := range strings.Split(rt.Path, "/") {
+        p = strings.Trim(p, "{}")
+        if p == "" {
+            continue
+        }
+        parts = append(parts, strings.ToUpper(p[:1])+p[1:])
+    }
+    return strings.ToLower(rt.Method) + strings.Join(parts, "")
+}
+
+// /openapi.json 描述旧路径，/api/v1/openapi.json 描述 /api/v1
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, r, buildOpenAPI(envelopeFrom(r.Context()) != nil))
+}
+
+// Swagger UI 静态资源较大，不打进二进制，默认从 CDN 加载（离线环境可配置内网镜像）
+const defaultSwaggerUIBase = "https://unpkg.com/swagger-ui-dist@5"
+
+func swaggerUIHandler(base string) http.HandlerFunc {
+    if base == "" {
+        base = defaultSwaggerUIBase
+    }
+    base = strings.TrimRight(base, "/")
+    page := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>LightCMDB API</title>
+<link rel="stylesheet" href="%[1]s/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="%[1]s/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`, base)
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(page))
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "log"
+)
+
+// ---------- Pods on deleted nodes ----------
+
+// Node 删除后，上面的 Pod 由 pod GC 删掉；删除事件丢了（LightCMDB 停机、重试用完被丢弃）时库里会留下
+// 指向早已不存在节点的 Pod 行。Node 行被删时把 node_name 是它的 Pod 全部重新入队：
+// informer 缓存里已经没有的按正常流程删行，还在的（GC 还没跑到）保留，等 Pod 的删除事件。
+// 已经留下的孤儿 Pod 在 /cmdb/stats 的 consistency 里列出，/admin/resync?resource=pods 可以清掉。
+
+// pod_node_missing：已调度、但 node_name 不在 nodes 表里的 Pod
+const orphanPodCond = `coalesce(node_name,'')<>'' AND node_name NOT IN (SELECT name FROM nodes)`
+
+func (q *syncQueue) requeueNodePods(node string) {
+    if _, ok := q.kinds["pods"]; !ok {
+        return
+    }
+    rows, err := q.db.Query(`SELECT namespace,name FROM pods WHERE node_name=?`, node)
+    if err != nil {
+        log.Printf("[nodes] requeue pods of %s: %v", node, err)
+        return
+    }
+    var items []syncItem
+    for rows.Next() {
+        var ns, name string
+        if err := rows.Scan(&ns, &name); err != nil {
+            log.Printf("[nodes] requeue pods of %s: %v", node, err)
+            break
+        }
+        items = append(items, syncItem{kind: "pods", key: cacheKey(ns, name)})
+    }
+    rows.Close()
+    if len(items) == 0 {
+        return
+    }
+    log.Printf("[nodes] %s deleted, requeueing %d pods still on it", node, len(items))
+    for _, it := range items {
+        q.push(it)
+    }
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Team ownership ----------
+
+// 组织是按团队划分的，不是按 namespace：配置 ownership.annotation / ownership.label 后同步 Namespace，
+// 从这个 annotation（优先）或 label 取出团队名，记在 namespaces.team，再由触发器写到 Pod、Service、Deployment、PVC 的 team 列：
+// 新插入的行取所在 namespace 的团队，namespace 的团队变了就更新它下面所有的行。team 不进 history，也不改 updated_at。
+// 列表接口的 ?team= 按它过滤，/cmdb/teams 按团队汇总。没有配置时不同步 Namespace（不需要它的权限），team 都为空。
+var teamTables = []string{"pods", "services", "deployments", "persistent_volume_claims"}
+
+// 在 initWorkloadSchema、initStorage 之后调用
+func initOwnership(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS namespaces(
+    name TEXT PRIMARY KEY,
+    team TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`,
+        `DROP TRIGGER IF EXISTS namespaces_team_ai`,
+        `DROP TRIGGER IF EXISTS namespaces_team_au`,
+        `DROP TRIGGER IF EXISTS namespaces_team_ad`,
+    }
+    var onInsert, onUpdate, onDelete string
+    for _, t := range teamTables {
+        if err := addColumnIfMissing(db, t, "team", "TEXT"); err != nil {
+            return err
+        }
+        stmts = append(stmts, fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_team_ai`, t),
+            fmt.Sprintf(`CREATE TRIGGER %s_team_ai AFTER INSERT ON %s BEGIN
+ UPDATE %s SET team=(SELECT team FROM namespaces WHERE name=new.namespace) WHERE uid=new.uid; END`, t, t, t),
+            fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_team ON %s(team)`, t, t))
+        set := fmt.Sprintf(` UPDATE %s SET team=new.team WHERE namespace=new.name AND team IS NOT new.team;`, t)
+        onInsert += set
+        onUpdate += set
+        onDelete += fmt.Sprintf(` UPDATE %s SET team=NULL WHERE namespace=old.name;`, t)
+    }
+    stmts = append(stmts,
+        `CREATE TRIGGER namespaces_team_ai AFTER INSERT ON namespaces BEGIN`+onInsert+` END`,
+        `CREATE TRIGGER namespaces_team_au AFTER UPDATE OF team ON namespaces BEGIN`+onUpdate+` END`,
+        `CREATE TRIGGER namespaces_team_ad AFTER DELETE ON namespaces BEGIN`+onDelete+` END`)
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func teamOf(cfg OwnershipConfig, ns *corev1.Namespace) string {
+    if cfg.Annotation != "" {
+        if v := ns.Annotations[cfg.Annotation]; v != "" {
+            return v
+        }
+    }
+    if cfg.Label != "" {
+        return ns.Labels[cfg.Label]
+    }
+    return ""
+}
+
+func upsertNamespace(db querier, cfg OwnershipConfig, ns *corev1.Namespace) error {
+    if ns == nil {
+        return errors.New("nil namespace")
+    }
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO namespaces(name,team,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?)
+ON CONFLICT(name) DO UPDATE SET
+ team=excluded.team,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("namespaces"), ns.Name, teamOf(cfg, ns), flattenLabels(ns.Labels), ns.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "namespaces", ns.Name, ns.ResourceVersion)
+    return err
+}
+
+func deleteNamespace(db querier, name string) error {
+    _, err := db.Exec(`DELETE FROM namespaces WHERE name=?`, name)
+    return err
+}
+
+func namespaceKind(cfg OwnershipConfig) syncDiffKind {
+    return syncDiffKind{Name: "namespaces", Table: "namespaces", Key: "name",
+        Cols: []string{"team", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            ns := o.(*corev1.Namespace)
+            return ns.Name, []string{teamOf(cfg, ns), flattenLabels(ns.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertNamespace(q, cfg, o.(*corev1.Namespace)) },
+        remove: deleteNamespace}
+}
+
+// 没有配置 ownership 时返回 nil。同 watchStorage，由调用方 Start、不等它同步
+func watchNamespaces(client kubernetes.Interface, cfg OwnershipConfig, transform cache.TransformFunc, syncs *syncQueue, caches *cacheMeter) informers.SharedInformerFactory {
+    if cfg.Annotation == "" && cfg.Label == "" {
+        return nil
+    }
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(transform))
+    inf := factory.Core().V1().Namespaces().Informer()
+    syncs.addKind(namespaceKind(cfg), inf)
+    caches.add("namespaces", inf)
+    return factory
+}
+
+// ---------- Per-team stats ----------
+
+type TeamStats struct {
+    // 空串表示没有团队的 namespace（没有配置的 annotation / label）
+    Team               string `json:"team"`
+    Namespaces         int64  `json:"namespaces"`
+    Pods               int64  `json:"pods"`
+    RunningPods        int64  `json:"runningPods"`
+    CPURequestMilli    int64  `json:"cpuRequestMilli"`
+    MemoryRequestBytes int64  `json:"memoryRequestBytes"`
+    Deployments        int64  `json:"deployments"`
+    Services           int64  `json:"services"`
+    Claims             int64  `json:"claims"`
+    // PVC 申请的容量之和
+    StorageRequestedBytes int64 `json:"storageRequestedBytes"`
+}
+
+func computeTeamStats(q querier, scope nsScope) ([]TeamStats, error) {
+    teams := map[string]*TeamStats{}
+    team := func(name string) *TeamStats {
+        t := teams[name]
+        if t == nil {
+            t = &TeamStats{Team: name}
+            teams[name] = t
+        }
+        return t
+    }
+    // 每条查询按 team 返回若干个数，依次加到对应字段上
+    queries := []struct {
+        table, cols string
+        fields      func(t *TeamStats) []*int64
+    }{
+        {"pods", `count(*),coalesce(sum(phase='Running'),0),coalesce(sum(cpu_request),0),coalesce(sum(mem_request),0)`,
+            func(t *TeamStats) []*int64 {
+                return []*int64{&t.Pods, &t.RunningPods, &t.CPURequestMilli, &t.MemoryRequestBytes}
+            }},
+        {"deployments", `count(*)`, func(t *TeamStats) []*int64 { return []*int64{&t.Deployments} }},
+        {"services", `count(*)`, func(t *TeamStats) []*int64 { return []*int64{&t.Services} }},
+        {"persistent_volume_claims", `count(*),coalesce(sum(requested_bytes),0)`,
+            func(t *TeamStats) []*int64 { return []*int64{&t.Claims, &t.StorageRequestedBytes} }},
+        // 没有 Namespace 行（未开启同步）时按对象所在的 namespace 数
+        {"(SELECT DISTINCT namespace,team FROM pods UNION SELECT name,team FROM namespaces)", `count(DISTINCT namespace)`,
+            func(t *TeamStats) []*int64 { return []*int64{&t.Namespaces} }},
+    }
+    for _, qs := range queries {
+        where, args := scope.where("namespace", "")
+        rows, err := q.Query(`SELECT coalesce(team,''),`+qs.cols+` FROM `+qs.table+where+` GROUP BY 1`, args...)
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            var name string
+            vals := make([]int64, len(qs.fields(&TeamStats{})))
+            dest := []any{&name}
+            for i := range vals {
+                dest = append(dest, &vals[i])
+            }
+            if err := rows.Scan(dest...); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            for i, f := range qs.fields(team(name)) {
+                *f += vals[i]
+            }
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+    out := make([]TeamStats, 0, len(teams))
+    for _, t := range teams {
+        out = append(out, *t)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Team < out[j].Team })
+    return out, nil
+}
+
+// GET /cmdb/teams[?team=<name>][&format=csv]
+func teamsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        var out []TeamStats
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            out, err = computeTeamStats(dbFrom(ctx, db), scopeOf(r.Context()))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "teams", TeamStats{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        only, filtered := r.URL.Query()["team"]
+        for _, t := range out {
+            if filtered && t.Team != only[0] {
+                continue
+            }
+            if err := lw.Write(t); err != nil {
+                log.Printf("[http] write teams: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
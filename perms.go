@@ -0,0 +1,83 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// ---------- File permissions ----------
+
+// DB（含 -wal/-shm/-journal 和 cmdb.db.* 备份）、数据目录、配置文件和 API key 文件都不应对组/其他用户开放
+const restrictedBits os.FileMode = 0o077
+
+func permissionTargets(cfg *Config) []string {
+    var targets []string
+    // 默认的当前目录不去动它
+    if cfg.Storage.DataDir != "." {
+        targets = append(targets, cfg.Storage.DataDir)
+    }
+    files, _ := filepath.Glob(filepath.Join(cfg.Storage.DataDir, dbFile+"*"))
+    targets = append(targets, files...)
+    if cfg.path != "" {
+        targets = append(targets, cfg.path)
+    }
+    if cfg.Auth.KeysFile != "" {
+        targets = append(targets, cfg.Auth.KeysFile)
+    }
+    if cfg.TLS.KeyFile != "" {
+        targets = append(targets, cfg.TLS.KeyFile)
+    }
+    if cfg.Storage.Encryption.KeyFile != "" {
+        targets = append(targets, cfg.Storage.Encryption.KeyFile)
+    }
+    if cfg.GitSnapshot.Enabled {
+        targets = append(targets, cfg.GitSnapshot.Dir)
+    }
+    return targets
+}
+
+func checkPermissions(cfg *Config) error {
+    if !permissionChecksSupported {
+        return nil
+    }
+    mode := cfg.Storage.Permissions
+    var problems []string
+    for _, path := range permissionTargets(cfg) {
+        fi, err := os.Stat(path)
+        if errors.Is(err, os.ErrNotExist) {
+            continue
+        }
+        if err != nil {
+            problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+            continue
+        }
+        if uid, ok := fileOwner(fi); ok && uid != os.Geteuid() {
+            problems = append(problems, fmt.Sprintf("%s: owned by uid %d, running as uid %d", path, uid, os.Geteuid()))
+        }
+        perm := fi.Mode().Perm()
+        if perm&restrictedBits == 0 {
+            continue
+        }
+        if mode == "enforce" {
+            err := os.Chmod(path, perm&^restrictedBits)
+            if err == nil {
+                log.Printf("[perms] %s: tightened %v -> %v", path, perm, perm&^restrictedBits)
+                continue
+            }
+            problems = append(problems, fmt.Sprintf("%s: mode %v, chmod failed: %v", path, perm, err))
+            continue
+        }
+        problems = append(problems, fmt.Sprintf("%s: mode %v is accessible by group/others", path, perm))
+    }
+    for _, p := range problems {
+        log.Printf("[perms] WARNING %s", p)
+    }
+    if mode == "strict" && len(problems) > 0 {
+        return fmt.Errorf("%d permission problem(s): %s", len(problems), strings.Join(problems, "; "))
+    }
+    return nil
+}
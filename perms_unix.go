@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+const permissionChecksSupported = true
+
+func restrictUmask() {
+    syscall.Umask(0o077)
+}
+
+func fileOwner(fi os.FileInfo) (int, bool) {
+    st, ok := fi.Sys().(*syscall.Stat_t)
+    if !ok {
+        return 0, false
+    }
+    return int(st.Uid), true
+}
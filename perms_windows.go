@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows 上权限位没有意义（ACL 另说），跳过检查
+const permissionChecksSupported = false
+
+func restrictUmask() {}
+
+func fileOwner(fi os.FileInfo) (int, bool) {
+    return 0, false
+}
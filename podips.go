@@ -0,0 +1,222 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "net/netip"
+    "slices"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Pod IP history ----------
+
+// Flow log 里只有 IP，而 Pod 的 IP 几分钟内就会被新 Pod 复用，所以每段分配记一行：(ip, pod, first_seen, last_seen)。
+// Pod 写库时比较 status.podIPs 和它还没结束的记录：新 IP 开一行，不再持有的 IP 填 last_seen。
+// Pod 删除或进入 Succeeded / Failed（CNI 已回收地址）时全部结束。hostNetwork 的 Pod 用的是节点 IP，不记录。
+// 同一 IP 被新 Pod 拿到时，旧记录如果还开着（CMDB 停机期间漏了删除事件）也一并结束。
+// 记录不随 Pod 删除，结束超过 retention 的按小时清理。
+// /cmdb/ip/{addr}?at=<RFC3339> 回答"这个 IP 在 T 时刻是哪个 Pod"。
+
+func initPodIPs(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS pod_ips(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ip TEXT NOT NULL,
+    uid TEXT NOT NULL,
+    namespace TEXT,
+    name TEXT,
+    node_name TEXT,
+    first_seen TEXT NOT NULL,
+    -- 为空表示仍在使用
+    last_seen TEXT
+);`,
+        `CREATE INDEX IF NOT EXISTS pod_ips_ip ON pod_ips(ip, first_seen)`,
+        `CREATE INDEX IF NOT EXISTS pod_ips_open ON pod_ips(uid) WHERE last_seen IS NULL`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 规范化后的 status.podIPs（旧 API server 只填 podIP）
+func podIPsOf(p *corev1.Pod) []string {
+    if p.Spec.HostNetwork || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+        return nil
+    }
+    raw := []string{p.Status.PodIP}
+    for _, ip := range p.Status.PodIPs {
+        raw = append(raw, ip.IP)
+    }
+    var out []string
+    for _, s := range raw {
+        a, err := netip.ParseAddr(s)
+        if err != nil {
+            continue
+        }
+        if ip := a.String(); !slices.Contains(out, ip) {
+            out = append(out, ip)
+        }
+    }
+    return out
+}
+
+func recordPodIPs(db querier, p *corev1.Pod) error {
+    now := time.Now().UTC()
+    uid := string(p.UID)
+    current := podIPsOf(p)
+    for i, ip := range current {
+        current[i] = sealField(ip)
+    }
+    rows, err := db.Query(`SELECT ip FROM pod_ips WHERE uid=? AND last_seen IS NULL`, uid)
+    if err != nil {
+        return err
+    }
+    open := map[string]bool{}
+    for rows.Next() {
+        var ip string
+        if err := rows.Scan(&ip); err != nil {
+            rows.Close()
+            return err
+        }
+        open[ip] = true
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    ts := now.Format(time.RFC3339)
+    for ip := range open {
+        if !slices.Contains(current, ip) {
+            if _, err := db.Exec(`UPDATE pod_ips SET last_seen=? WHERE uid=? AND ip=? AND last_seen IS NULL`, ts, uid, ip); err != nil {
+                return err
+            }
+        }
+    }
+    // 启动时第一次看到的 Pod 从 startTime 算起，比"CMDB 看到的时间"更接近真实的分配时间，
+    // 但不早于上一个持有者的结束时间，避免两段分配重叠
+    start := ts
+    if st := p.Status.StartTime; st != nil && st.Time.Before(now) {
+        start = st.Time.UTC().Format(time.RFC3339)
+    }
+    for _, ip := range current {
+        if open[ip] {
+            continue
+        }
+        if _, err := db.Exec(`UPDATE pod_ips SET last_seen=? WHERE ip=? AND uid<>? AND last_seen IS NULL`, ts, ip, uid); err != nil {
+            return err
+        }
+        var prev string
+        if err := db.QueryRow(`SELECT coalesce(max(last_seen),'') FROM pod_ips WHERE ip=?`, ip).Scan(&prev); err != nil {
+            return err
+        }
+        if _, err := db.Exec(`INSERT INTO pod_ips(ip,uid,namespace,name,node_name,first_seen) VALUES(?,?,?,?,?,?)`,
+            ip, uid, p.Namespace, p.Name, p.Spec.NodeName, max(start, prev)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// deletePod 调用：记录保留，只结束
+func releasePodIPs(db querier, uid string) error {
+    _, err := db.Exec(`UPDATE pod_ips SET last_seen=? WHERE uid=? AND last_seen IS NULL`, time.Now().UTC().Format(time.RFC3339), uid)
+    return err
+}
+
+func prunePodIPs(db *sql.DB, retention time.Duration, stop <-chan struct{}) {
+    t := time.NewTicker(time.Hour)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            cutoff := time.Now().Add(-retention).UTC().Format(time.RFC3339)
+            res, err := db.Exec(`DELETE FROM pod_ips WHERE last_seen IS NOT NULL AND last_seen<?`, cutoff)
+            if err != nil {
+                log.Printf("[podips] prune: %v", err)
+                continue
+            }
+            if n, _ := res.RowsAffected(); n > 0 {
+                log.Printf("[podips] pruned %d assignments released before %s", n, cutoff)
+            }
+        }
+    }
+}
+
+type IPAssignment struct {
+    IP        string `json:"ip"`
+    UID       string `json:"uid"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    NodeName  string `json:"nodeName"`
+    FirstSeen string `json:"firstSeen"`
+    // 仍在使用时为空
+    LastSeen string `json:"lastSeen"`
+    Current  bool   `json:"current"`
+}
+
+// GET /cmdb/ip/{addr}              这个 IP 的全部分配记录，新的在前
+// GET /cmdb/ip/{addr}?at=<RFC3339> 只返回 T 时刻持有它的 Pod
+func podIPAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        addr, err := netip.ParseAddr(strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/ip"), "/"))
+        if err != nil {
+            http.Error(w, "path must be /cmdb/ip/{address}", 400)
+            return
+        }
+        // 启用加密之前记下的明文行也要能查到
+        cond, condArgs := "ip IN (?,?)", []any{addr.String(), sealField(addr.String())}
+        if v := r.URL.Query().Get("at"); v != "" {
+            at, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                http.Error(w, "at must be RFC3339", 400)
+                return
+            }
+            ts := at.UTC().Format(time.RFC3339)
+            cond += " AND first_seen<=? AND (last_seen IS NULL OR last_seen>=?)"
+            condArgs = append(condArgs, ts, ts)
+        }
+        where, args := scopeOf(r.Context()).where("namespace", cond, condArgs...)
+        rows, err := db.QueryContext(r.Context(), `SELECT cmdb_unseal(ip),uid,coalesce(namespace,''),coalesce(name,''),coalesce(node_name,''),
+ first_seen,coalesce(last_seen,'') FROM pod_ips`+where+` ORDER BY first_seen DESC,id DESC`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "ip-history", IPAssignment{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var a IPAssignment
+            if err := rows.Scan(&a.IP, &a.UID, &a.Namespace, &a.Name, &a.NodeName, &a.FirstSeen, &a.LastSeen); err != nil {
+                lw.Fail(err)
+                return
+            }
+            a.Current = a.LastSeen == ""
+            if err := lw.Write(a); err != nil {
+                log.Printf("[http] write ip: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+            return
+        }
+        lw.Close()
+    }
+}
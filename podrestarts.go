@@ -0,0 +1,216 @@
+package main
+
+import (
+    "cmp"
+    "database/sql"
+    "log"
+    "net/http"
+    "slices"
+    "strconv"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Pod restarts / flapping ----------
+
+// 每个容器（含 init 容器）的 restartCount 变化时在 pod_restart_samples 记一行，第一次看到 Pod 时也记一行作为基线。
+// /cmdb/pods/flapping?window=1h 列出窗口内重启次数增加了的 Pod 和增量，回答"现在哪些 Pod 在 crashloop"。
+// 窗口起点之前的最后一条是基线；窗口之前没有记录（Pod 或 CMDB 是窗口内才起来的）时以第一条为基线，
+// 这样 CMDB 重启后第一次同步到的历史重启次数不会被当成新的重启。
+// 只保留 maxFlappingWindow 内的记录，再加上每个容器在这之前的最后一条；Pod 删除时一并删掉。
+const maxFlappingWindow = 24 * time.Hour
+
+func initPodRestarts(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS pod_restart_samples(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uid TEXT NOT NULL,
+    container TEXT NOT NULL,
+    restarts INTEGER NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    recorded_at TEXT NOT NULL
+);`,
+        `CREATE INDEX IF NOT EXISTS pod_restart_samples_uid ON pod_restart_samples(uid, container, id)`,
+        `CREATE INDEX IF NOT EXISTS pod_restart_samples_ts ON pod_restart_samples(recorded_at)`,
+        `DROP TRIGGER IF EXISTS pods_restarts_ad`,
+        `CREATE TRIGGER pods_restarts_ad AFTER DELETE ON pods BEGIN
+ DELETE FROM pod_restart_samples WHERE uid=old.uid; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 和该容器最近一条记录的 restarts 不同时才插入
+const podRestartInsert = `INSERT INTO pod_restart_samples(uid,container,restarts,reason,recorded_at)
+SELECT ?1,?2,?3,?4,?5
+WHERE coalesce((SELECT restarts FROM pod_restart_samples WHERE uid=?1 AND container=?2 ORDER BY id DESC LIMIT 1),-1)<>?3`
+
+// 保留 cutoff 之前的最后一条作为基线
+const podRestartPrune = `DELETE FROM pod_restart_samples WHERE uid=?1 AND container=?2 AND recorded_at<?3
+ AND id<(SELECT max(id) FROM pod_restart_samples WHERE uid=?1 AND container=?2 AND recorded_at<?3)`
+
+func podContainerStatuses(p *corev1.Pod) []corev1.ContainerStatus {
+    return append(append([]corev1.ContainerStatus{}, p.Status.InitContainerStatuses...), p.Status.ContainerStatuses...)
+}
+
+// <container>=<restartCount>：只有重启次数变了的 update 也要写库，否则 Running 在同一节点上 crashloop 的 Pod 一直没有记录
+func podRestartCounts(p *corev1.Pod) []string {
+    var out []string
+    for _, cs := range podContainerStatuses(p) {
+        out = append(out, cs.Name+"="+strconv.Itoa(int(cs.RestartCount)))
+    }
+    return out
+}
+
+func recordPodRestarts(db querier, p *corev1.Pod) error {
+    now := time.Now().UTC()
+    cutoff := now.Add(-maxFlappingWindow).Format(time.RFC3339)
+    for _, cs := range podContainerStatuses(p) {
+        reason := ""
+        if t := cs.LastTerminationState.Terminated; t != nil {
+            reason = t.Reason
+        }
+        res, err := db.Exec(podRestartInsert, string(p.UID), cs.Name, cs.RestartCount, reason, now.Format(time.RFC3339))
+        if err != nil {
+            return err
+        }
+        if n, _ := res.RowsAffected(); n == 0 {
+            continue
+        }
+        if _, err := db.Exec(podRestartPrune, string(p.UID), cs.Name, cutoff); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type ContainerRestarts struct {
+    Name     string `json:"name"`
+    Restarts int64  `json:"restarts"`
+    Delta    int64  `json:"delta"`
+    // 上一次退出的原因，如 Error、OOMKilled
+    LastReason string `json:"lastReason,omitempty"`
+}
+
+type FlappingPod struct {
+    UID       string `json:"uid"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    NodeName  string `json:"nodeName"`
+    Phase     string `json:"phase"`
+    // 所有容器当前的重启次数之和和窗口内的增量
+    Restarts int64 `json:"restarts"`
+    Delta    int64 `json:"delta"`
+    // 最近一次观察到重启次数增加的时间
+    LastRestartAt string              `json:"lastRestartAt"`
+    Containers    []ContainerRestarts `json:"containers"`
+}
+
+// 每个 (Pod, 容器) 一行：当前值、基线、最近一条的原因和时间
+const flappingQuery = `SELECT p.uid,p.namespace,p.name,coalesce(p.node_name,''),coalesce(p.phase,''),s.container,
+ (SELECT restarts FROM pod_restart_samples WHERE uid=s.uid AND container=s.container ORDER BY id DESC LIMIT 1),
+ coalesce((SELECT restarts FROM pod_restart_samples WHERE uid=s.uid AND container=s.container AND recorded_at<=?1 ORDER BY id DESC LIMIT 1),
+  (SELECT restarts FROM pod_restart_samples WHERE uid=s.uid AND container=s.container ORDER BY id LIMIT 1)),
+ (SELECT reason FROM pod_restart_samples WHERE uid=s.uid AND container=s.container ORDER BY id DESC LIMIT 1),
+ (SELECT recorded_at FROM pod_restart_samples WHERE uid=s.uid AND container=s.container ORDER BY id DESC LIMIT 1)
+FROM (SELECT DISTINCT uid,container FROM pod_restart_samples WHERE recorded_at>?1) s JOIN pods p ON p.uid=s.uid`
+
+func flappingPods(r *http.Request, db *sql.DB, since time.Time, minDelta int64) ([]FlappingPod, error) {
+    where, args := scopeOf(r.Context()).where("p.namespace", "")
+    rows, err := db.QueryContext(r.Context(), flappingQuery+where+` ORDER BY p.namespace,p.name,s.uid,s.container`,
+        append([]any{since.UTC().Format(time.RFC3339)}, args...)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []FlappingPod
+    for rows.Next() {
+        var p FlappingPod
+        var c ContainerRestarts
+        var base int64
+        var at string
+        if err := rows.Scan(&p.UID, &p.Namespace, &p.Name, &p.NodeName, &p.Phase, &c.Name, &c.Restarts, &base, &c.LastReason, &at); err != nil {
+            return nil, err
+        }
+        // 重启次数不会变小，保险起见不算负数
+        c.Delta = max(c.Restarts-base, 0)
+        if n := len(out); n == 0 || out[n-1].UID != p.UID {
+            p.Containers = []ContainerRestarts{}
+            out = append(out, p)
+        }
+        cur := &out[len(out)-1]
+        cur.Containers = append(cur.Containers, c)
+        cur.Restarts += c.Restarts
+        cur.Delta += c.Delta
+        if c.Delta > 0 && at > cur.LastRestartAt {
+            cur.LastRestartAt = at
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    flapping := out[:0]
+    for _, p := range out {
+        if p.Delta >= minDelta {
+            flapping = append(flapping, p)
+        }
+    }
+    // 增量大的在前
+    slices.SortStableFunc(flapping, func(a, b FlappingPod) int { return cmp.Compare(b.Delta, a.Delta) })
+    return flapping, nil
+}
+
+// GET /cmdb/pods/flapping?window=1h&min=1，window 默认 1h，最长 24h；min 是窗口内最少的重启次数，默认 1
+func flappingPodsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        window := time.Hour
+        if v := q.Get("window"); v != "" {
+            d, err := time.ParseDuration(v)
+            if err != nil || d <= 0 {
+                http.Error(w, "window must be a positive duration such as 30m or 1h", 400)
+                return
+            }
+            if d > maxFlappingWindow {
+                http.Error(w, "window must not exceed "+maxFlappingWindow.String(), 400)
+                return
+            }
+            window = d
+        }
+        minDelta := int64(1)
+        if v := q.Get("min"); v != "" {
+            n, err := strconv.ParseInt(v, 10, 64)
+            if err != nil || n < 1 {
+                http.Error(w, "min must be a positive integer", 400)
+                return
+            }
+            minDelta = n
+        }
+        pods, err := flappingPods(r, db, time.Now().Add(-window), minDelta)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "flapping-pods", FlappingPod{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, p := range pods {
+            if err := lw.Write(p); err != nil {
+                log.Printf("[http] write flapping pods: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+    "bytes"
+    "database/sql"
+    "fmt"
+    "log"
+    "net/http"
+    "regexp"
+    "slices"
+    "strings"
+    "sync"
+
+    "k8s.io/apimachinery/pkg/api/meta"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/client-go/util/jsonpath"
+)
+
+// ---------- Configured projections ----------
+
+// projections 按 kind（syncDiffKinds 里的名字：pods、nodes、services……）声明额外的列，值是对象上的 JSONPath
+// （kubectl -o jsonpath 的语法，如 {.metadata.annotations.team}，不写花括号时自动补上）。每列存成表里的
+// x_<column>，和内置字段一样在每次 upsert 时写入，参与跳过无变化 update 的比较和 /admin/diff 的对账。
+// JSONPath 作用在 informer 缓存里的对象上，被裁掉的字段（见 informertrim.go）取不到；没匹配到时存 NULL，
+// 匹配到多个值时用空格连接。这些列不进 history，/cmdb/projections/{kind} 按列过滤查询。
+var projectionColumn = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// 列名同时是 /cmdb/projections 的查询参数，不能和已有参数重名
+var reservedProjectionColumns = []string{"ns", "format", "fields"}
+
+type projection struct {
+    name string
+    expr string
+    // JSONPath 执行时会改内部状态，不能并发用
+    mu   sync.Mutex
+    path *jsonpath.JSONPath
+}
+
+func (p *projection) column() string { return "x_" + p.name }
+
+// kind -> 按配置顺序的列，applyProjections 设置后只读
+var projections = map[string][]*projection{}
+
+func parseProjections(cfg map[string][]ProjectionConfig) (map[string][]*projection, error) {
+    out := map[string][]*projection{}
+    for kind, cols := range cfg {
+        if !slices.ContainsFunc(syncDiffKinds, func(k syncDiffKind) bool { return k.Name == kind }) {
+            return nil, fmt.Errorf("unknown kind %q", kind)
+        }
+        seen := map[string]bool{}
+        for _, c := range cols {
+            if !projectionColumn.MatchString(c.Column) {
+                return nil, fmt.Errorf("%s: column %q must match %s", kind, c.Column, projectionColumn)
+            }
+            if slices.Contains(reservedProjectionColumns, c.Column) {
+                return nil, fmt.Errorf("%s: column name %q is reserved", kind, c.Column)
+            }
+            if seen[c.Column] {
+                return nil, fmt.Errorf("%s: duplicate column %q", kind, c.Column)
+            }
+            seen[c.Column] = true
+            expr := strings.TrimSpace(c.JSONPath)
+            if !strings.HasPrefix(expr, "{") {
+                expr = "{" + expr + "}"
+            }
+            path := jsonpath.New(c.Column).AllowMissingKeys(true)
+            if err := path.Parse(expr); err != nil {
+                return nil, fmt.Errorf("%s.%s: jsonPath: %w", kind, c.Column, err)
+            }
+            out[kind] = append(out[kind], &projection{name: c.Column, expr: expr, path: path})
+        }
+    }
+    return out, nil
+}
+
+// openMigratedDB 里在建表之前调用一次：把额外的列接到 syncDiffKinds 的 Cols / project / upsert 上
+func applyProjections(cfg map[string][]ProjectionConfig) error {
+    ps, err := parseProjections(cfg)
+    if err != nil {
+        return err
+    }
+    projections = ps
+    for i := range syncDiffKinds {
+        k := &syncDiffKinds[i]
+        extra := ps[k.Name]
+        if len(extra) == 0 {
+            continue
+        }
+        for _, p := range extra {
+            k.Cols = append(slices.Clip(k.Cols), p.column())
+        }
+        project, upsert := k.project, k.upsert
+        k.project = func(o runtime.Object) (string, []string) {
+            key, vals := project(o)
+            return key, append(vals, projectedValues(extra, o)...)
+        }
+        table, keyCol := k.Table, k.Key
+        k.upsert = func(q querier, o runtime.Object) error {
+            if err := upsert(q, o); err != nil {
+                return err
+            }
+            return writeProjections(q, table, keyCol, extra, o)
+        }
+    }
+    return nil
+}
+
+// initSchema 里调用，要在 initHistory 之前（建表之后）
+func initProjections(db *sql.DB) error {
+    for _, k := range syncDiffKinds {
+        for _, p := range projections[k.Name] {
+            if err := addColumnIfMissing(db, k.Table, p.column(), "TEXT"); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func projectedValues(ps []*projection, o runtime.Object) []string {
+    out := make([]string, len(ps))
+    obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+    if err != nil {
+        log.Printf("[projections] convert: %v", err)
+        return out
+    }
+    for i, p := range ps {
+        var buf bytes.Buffer
+        p.mu.Lock()
+        err := p.path.Execute(&buf, obj)
+        p.mu.Unlock()
+        if err != nil {
+            // 比如对 map 用了 [*] 之外的下标，每个对象都会失败，只记日志
+            log.Printf("[projections] %s %s: %v", p.name, p.expr, err)
+            continue
+        }
+        out[i] = buf.String()
+    }
+    return out
+}
+
+func writeProjections(q querier, table, keyCol string, ps []*projection, o runtime.Object) error {
+    m, err := meta.Accessor(o)
+    if err != nil {
+        return err
+    }
+    key := m.GetName()
+    if keyCol == "uid" {
+        key = string(m.GetUID())
+    }
+    sets := make([]string, len(ps))
+    var args []any
+    for i, v := range projectedValues(ps, o) {
+        sets[i] = ps[i].column() + "=?"
+        args = append(args, sql.NullString{String: v, Valid: v != ""})
+    }
+    _, err = q.Exec(`UPDATE `+table+` SET `+strings.Join(sets, ",")+` WHERE `+keyCol+`=?`, append(args, key)...)
+    return err
+}
+
+type ProjectionRow struct {
+    Key       string            `json:"key"`
+    Namespace string            `json:"namespace"`
+    Name      string            `json:"name"`
+    Fields    map[string]string `json:"fields"`
+}
+
+// GET /cmdb/projections/{kind}?ns=&<column>=value
+// 每个对象一行，fields 是配置的全部列（没有值的不输出）；<column>= 参数按列的值整体匹配，多个之间是 AND，
+// 空值匹配没有值的对象。集群级的 kind 对限定 namespace 的 key 返回空列表
+func projectionsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        kind := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/projections/"), "/")
+        i := slices.IndexFunc(syncDiffKinds, func(k syncDiffKind) bool { return k.Name == kind })
+        ps := projections[kind]
+        if i < 0 || len(ps) == 0 {
+            http.Error(w, "no projections configured for "+kind, 404)
+            return
+        }
+        k := syncDiffKinds[i]
+        q := r.URL.Query()
+        scope := scopeOf(r.Context())
+        ns := "''"
+        var conds []string
+        var cargs []any
+        if k.NS != "" {
+            ns = "coalesce(" + k.NS + ",'')"
+            if v := q.Get("ns"); v != "" {
+                conds, cargs = append(conds, k.NS+"=?"), append(cargs, v)
+            }
+        }
+        cols := make([]string, len(ps))
+        for i, p := range ps {
+            cols[i] = "coalesce(" + p.column() + ",'')"
+            if v, ok := q[p.name]; ok {
+                conds, cargs = append(conds, cols[i]+"=?"), append(cargs, v[0])
+            }
+        }
+        var where string
+        var args []any
+        if k.NS != "" {
+            where, args = scope.where(k.NS, strings.Join(conds, " AND "), cargs...)
+        } else {
+            where, args = nsScope(nil).where("", strings.Join(conds, " AND "), cargs...)
+            if scope != nil {
+                where = " WHERE 0"
+            }
+        }
+        lw, err := newListWriter(w, r, "projections-"+kind, ProjectionRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        rows, err := db.QueryContext(r.Context(), `SELECT `+k.Key+`,`+ns+`,coalesce(name,''),`+strings.Join(cols, ",")+
+            ` FROM `+k.Table+where+` ORDER BY `+ns+`,name`, args...)
+        if err != nil {
+            lw.Fail(err)
+            return
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var row ProjectionRow
+            vals := make([]string, len(ps))
+            dest := []any{&row.Key, &row.Namespace, &row.Name}
+            for i := range vals {
+                dest = append(dest, &vals[i])
+            }
+            if err := rows.Scan(dest...); err != nil {
+                lw.Fail(err)
+                return
+            }
+            row.Fields = map[string]string{}
+            for i, v := range vals {
+                if v != "" {
+                    row.Fields[ps[i].name] = v
+                }
+            }
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write projections: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+        }
+        lw.Close()
+    }
+}
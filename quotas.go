@@ -0,0 +1,479 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    "k8s.io/apimachinery/pkg/api/resource"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- ResourceQuota / LimitRange ----------
+
+// namespace 的配额余量：ResourceQuota 和 LimitRange 同 PVC 一样经 informer 队列写库，也参加 /admin/diff 和对账。
+// hard / used 的格式同 labels（资源名=quantity），直接取 spec.hard 和 quota 控制器算好的 status.used。
+// /cmdb/quotas 按 quota 的每项资源比较 used / hard，超过 quotas.threshold（默认 0.9）的标出来；
+// by=namespace 时每个 namespace 一行，取用得最满的那项。LimitRange 的 spec.limits 原样存成 JSON，/cmdb/limitranges 展开成行。
+// 需要 resourcequotas、limitranges 的 list/watch 权限。
+func initQuotas(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS resource_quotas(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    scopes TEXT,
+    hard TEXT,
+    used TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS limit_ranges(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    limits TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`,
+        `CREATE INDEX IF NOT EXISTS resource_quotas_ns ON resource_quotas(namespace, name)`,
+        `CREATE INDEX IF NOT EXISTS limit_ranges_ns ON limit_ranges(namespace, name)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 单独一个 factory，同 watchStorage：由调用方 Start、不等它同步
+func watchQuotas(client kubernetes.Interface, transform cache.TransformFunc, syncs *syncQueue, caches *cacheMeter) informers.SharedInformerFactory {
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(transform))
+    for _, k := range []struct {
+        kind string
+        inf  cache.SharedIndexInformer
+    }{
+        {"resourcequotas", factory.Core().V1().ResourceQuotas().Informer()},
+        {"limitranges", factory.Core().V1().LimitRanges().Informer()},
+    } {
+        syncs.add(k.kind, k.inf)
+        caches.add(k.kind, k.inf)
+    }
+    return factory
+}
+
+func quantitiesOf(list corev1.ResourceList) string {
+    m := make(map[string]string, len(list))
+    for name, q := range list {
+        m[string(name)] = q.String()
+    }
+    return flattenLabels(m)
+}
+
+// spec.scopes 和 scopeSelector 里的 scopeName，逗号分隔
+func quotaScopes(q *corev1.ResourceQuota) string {
+    var scopes []string
+    for _, s := range q.Spec.Scopes {
+        scopes = append(scopes, string(s))
+    }
+    if q.Spec.ScopeSelector != nil {
+        for _, e := range q.Spec.ScopeSelector.MatchExpressions {
+            scopes = append(scopes, string(e.ScopeName))
+        }
+    }
+    return strings.Join(scopes, ",")
+}
+
+func limitsJSON(items []corev1.LimitRangeItem) string {
+    if len(items) == 0 {
+        return "[]"
+    }
+    b, _ := json.Marshal(items)
+    return string(b)
+}
+
+func upsertResourceQuota(db querier, q *corev1.ResourceQuota) error {
+    if q == nil {
+        return errors.New("nil resourcequota")
+    }
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO resource_quotas(uid,name,namespace,scopes,hard,used,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ scopes=excluded.scopes,
+ hard=excluded.hard,
+ used=excluded.used,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("resource_quotas"), string(q.UID), q.Name, q.Namespace, quotaScopes(q), quantitiesOf(q.Spec.Hard),
+        quantitiesOf(q.Status.Used), flattenLabels(q.Labels), q.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "resource_quotas", q.Namespace+"/"+q.Name, q.ResourceVersion)
+    return err
+}
+
+func deleteResourceQuota(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM resource_quotas WHERE uid=?`, uid)
+    return err
+}
+
+func upsertLimitRange(db querier, lr *corev1.LimitRange) error {
+    if lr == nil {
+        return errors.New("nil limitrange")
+    }
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO limit_ranges(uid,name,namespace,limits,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ limits=excluded.limits,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("limit_ranges"), string(lr.UID), lr.Name, lr.Namespace, limitsJSON(lr.Spec.Limits), flattenLabels(lr.Labels),
+        lr.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "limit_ranges", lr.Namespace+"/"+lr.Name, lr.ResourceVersion)
+    return err
+}
+
+func deleteLimitRange(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM limit_ranges WHERE uid=?`, uid)
+    return err
+}
+
+// ---------- Quota headroom report ----------
+
+type QuotaRow struct {
+    Namespace string `json:"namespace"`
+    Quota     string `json:"quota"`
+    Scopes    string `json:"scopes"`
+    Resource  string `json:"resource"`
+    // quantity 原文，例如 10、8Gi、500m
+    Hard string `json:"hard"`
+    Used string `json:"used"`
+    // used / hard；hard 为 0（禁止使用这种资源）时，没有用量为 0、有用量为 1
+    UsedRatio float64 `json:"usedRatio"`
+    // hard - used，已超出时为负
+    Headroom string `json:"headroom"`
+    Over     bool   `json:"overThreshold"`
+}
+
+type QuotaNamespaceRow struct {
+    Namespace string `json:"namespace"`
+    Quotas    int64  `json:"quotas"`
+    // 用得最满的一项
+    MaxUsedRatio float64 `json:"maxUsedRatio"`
+    MaxQuota     string  `json:"maxQuota"`
+    MaxResource  string  `json:"maxResource"`
+    // 超过阈值的项数
+    OverResources int64 `json:"overResources"`
+    Over          bool  `json:"overThreshold"`
+    LimitRanges   int64 `json:"limitRanges"`
+}
+
+func quotaUsage(hard, used string) (ratio float64, headroom string) {
+    h, err := resource.ParseQuantity(hard)
+    if err != nil {
+        return 0, ""
+    }
+    u, err := resource.ParseQuantity(used)
+    if err != nil {
+        // 控制器还没算出用量
+        u = resource.Quantity{}
+    }
+    left := h.DeepCopy()
+    left.Sub(u)
+    switch {
+    case !h.IsZero():
+        ratio = u.AsApproximateFloat64() / h.AsApproximateFloat64()
+    case !u.IsZero():
+        ratio = 1
+    }
+    return ratio, left.String()
+}
+
+func computeQuotas(q querier, threshold float64, scope nsScope, ns string) ([]QuotaRow, error) {
+    cond, condArgs := "", []any{}
+    if ns != "" {
+        cond, condArgs = "namespace=?", []any{ns}
+    }
+    where, args := scope.where("namespace", cond, condArgs...)
+    rows, err := q.Query(`SELECT namespace,name,coalesce(scopes,''),coalesce(hard,''),coalesce(used,'') FROM resource_quotas`+where+
+        ` ORDER BY namespace,name`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []QuotaRow
+    for rows.Next() {
+        var qns, name, scopes, hard, used string
+        if err := rows.Scan(&qns, &name, &scopes, &hard, &used); err != nil {
+            return nil, err
+        }
+        usedBy := parseLabels(used)
+        var items []QuotaRow
+        for res, h := range parseLabels(hard) {
+            row := QuotaRow{Namespace: qns, Quota: name, Scopes: scopes, Resource: res, Hard: h, Used: usedBy[res]}
+            row.UsedRatio, row.Headroom = quotaUsage(h, row.Used)
+            row.Over = row.UsedRatio > threshold
+            items = append(items, row)
+        }
+        sort.Slice(items, func(i, j int) bool { return items[i].Resource < items[j].Resource })
+        out = append(out, items...)
+    }
+    return out, rows.Err()
+}
+
+func quotaNamespaces(q querier, items []QuotaRow, scope nsScope, ns string) ([]QuotaNamespaceRow, error) {
+    byNS := map[string]*QuotaNamespaceRow{}
+    quotas := map[string]map[string]bool{}
+    get := func(ns string) *QuotaNamespaceRow {
+        g := byNS[ns]
+        if g == nil {
+            g = &QuotaNamespaceRow{Namespace: ns}
+            byNS[ns] = g
+            quotas[ns] = map[string]bool{}
+        }
+        return g
+    }
+    for _, it := range items {
+        g := get(it.Namespace)
+        quotas[it.Namespace][it.Quota] = true
+        if g.MaxResource == "" || it.UsedRatio > g.MaxUsedRatio {
+            g.MaxUsedRatio, g.MaxQuota, g.MaxResource = it.UsedRatio, it.Quota, it.Resource
+        }
+        if it.Over {
+            g.OverResources++
+            g.Over = true
+        }
+    }
+    // 只有 LimitRange、没有 quota 的 namespace 也列出来
+    cond, condArgs := "", []any{}
+    if ns != "" {
+        cond, condArgs = "namespace=?", []any{ns}
+    }
+    where, args := scope.where("namespace", cond, condArgs...)
+    rows, err := q.Query(`SELECT namespace,count(*) FROM limit_ranges`+where+` GROUP BY namespace`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var lns string
+        var n int64
+        if err := rows.Scan(&lns, &n); err != nil {
+            return nil, err
+        }
+        get(lns).LimitRanges = n
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    out := make([]QuotaNamespaceRow, 0, len(byNS))
+    for ns, g := range byNS {
+        g.Quotas = int64(len(quotas[ns]))
+        out = append(out, *g)
+    }
+    // 最满的在前
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].MaxUsedRatio != out[j].MaxUsedRatio {
+            return out[i].MaxUsedRatio > out[j].MaxUsedRatio
+        }
+        return out[i].Namespace < out[j].Namespace
+    })
+    return out, nil
+}
+
+// GET /cmdb/quotas?by=namespace|resource&ns=&threshold=0.8&over=true[&format=csv]
+func quotasAPI(db *sql.DB, defaultThreshold float64) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        qs := r.URL.Query()
+        by := qs.Get("by")
+        if by == "" {
+            by = "namespace"
+        }
+        if by != "namespace" && by != "resource" {
+            http.Error(w, "by must be namespace or resource", 400)
+            return
+        }
+        threshold := defaultThreshold
+        if v := qs.Get("threshold"); v != "" {
+            f, err := strconv.ParseFloat(v, 64)
+            if err != nil || f < 0 {
+                http.Error(w, "threshold must be a non-negative number, e.g. 0.8", 400)
+                return
+            }
+            threshold = f
+        }
+        overOnly := qs.Get("over") == "true"
+        var items []QuotaRow
+        var namespaces []QuotaNamespaceRow
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            q := dbFrom(ctx, db)
+            var err error
+            if items, err = computeQuotas(q, threshold, scopeOf(r.Context()), qs.Get("ns")); err != nil || by == "resource" {
+                return err
+            }
+            namespaces, err = quotaNamespaces(q, items, scopeOf(r.Context()), qs.Get("ns"))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if by == "resource" {
+            lw, err := newListWriter(w, r, "quotas-by-resource", QuotaRow{})
+            if err != nil {
+                http.Error(w, err.Error(), 400)
+                return
+            }
+            for _, row := range items {
+                if overOnly && !row.Over {
+                    continue
+                }
+                if err := lw.Write(row); err != nil {
+                    log.Printf("[http] write quotas: %v", err)
+                    return
+                }
+            }
+            lw.Close()
+            return
+        }
+        lw, err := newListWriter(w, r, "quotas-by-namespace", QuotaNamespaceRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range namespaces {
+            if overOnly && !row.Over {
+                continue
+            }
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write quotas: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
+
+type LimitRangeRow struct {
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // Container / Pod / PersistentVolumeClaim
+    Type     string `json:"type"`
+    Resource string `json:"resource"`
+    Min      string `json:"min"`
+    Max      string `json:"max"`
+    // 未写 limits 的容器得到的默认 limit
+    Default string `json:"default"`
+    // 未写 requests 的容器得到的默认 request
+    DefaultRequest       string `json:"defaultRequest"`
+    MaxLimitRequestRatio string `json:"maxLimitRequestRatio"`
+}
+
+func limitRangeRows(ns, name, limits string) ([]LimitRangeRow, error) {
+    var items []corev1.LimitRangeItem
+    if err := json.Unmarshal([]byte(limits), &items); err != nil {
+        return nil, err
+    }
+    var out []LimitRangeRow
+    for _, it := range items {
+        names := map[corev1.ResourceName]bool{}
+        for _, l := range []corev1.ResourceList{it.Min, it.Max, it.Default, it.DefaultRequest, it.MaxLimitRequestRatio} {
+            for n := range l {
+                names[n] = true
+            }
+        }
+        sorted := make([]string, 0, len(names))
+        for n := range names {
+            sorted = append(sorted, string(n))
+        }
+        sort.Strings(sorted)
+        val := func(l corev1.ResourceList, n string) string {
+            if q, ok := l[corev1.ResourceName(n)]; ok {
+                return q.String()
+            }
+            return ""
+        }
+        for _, n := range sorted {
+            out = append(out, LimitRangeRow{Namespace: ns, Name: name, Type: string(it.Type), Resource: n,
+                Min: val(it.Min, n), Max: val(it.Max, n), Default: val(it.Default, n), DefaultRequest: val(it.DefaultRequest, n),
+                MaxLimitRequestRatio: val(it.MaxLimitRequestRatio, n)})
+        }
+    }
+    return out, nil
+}
+
+// GET /cmdb/limitranges?ns=[&format=csv]   每个 LimitRange 的每类、每种资源一行
+func limitRangesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        cond, condArgs := "", []any{}
+        if ns := r.URL.Query().Get("ns"); ns != "" {
+            cond, condArgs = "namespace=?", []any{ns}
+        }
+        where, args := scopeOf(r.Context()).where("namespace", cond, condArgs...)
+        rows, err := db.QueryContext(r.Context(), `SELECT namespace,name,coalesce(limits,'[]') FROM limit_ranges`+where+` ORDER BY namespace,name`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "limitranges", LimitRangeRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var ns, name, limits string
+            if err := rows.Scan(&ns, &name, &limits); err != nil {
+                lw.Fail(err)
+                return
+            }
+            out, err := limitRangeRows(ns, name, limits)
+            if err != nil {
+                lw.Fail(err)
+                return
+            }
+            for _, row := range out {
+                if err := lw.Write(row); err != nil {
+                    log.Printf("[http] write limitranges: %v", err)
+                    return
+                }
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+            return
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    rbacv1 "k8s.io/api/rbac/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- ServiceAccounts / RBAC ----------
+
+// 安全评审要回答"service account X 能做什么"和"哪些 Pod 以 X 的身份运行"。rbac.enabled 时 ServiceAccount、Role、ClusterRole、
+// RoleBinding、ClusterRoleBinding 经 syncs 的队列入库，每种一张表（队列按 namespace/name 删行，不能混在一张表里）。
+// binding 的 subject 另外拆到 rbac_subjects，按 subject 反查 binding 用。
+// service account 的权限 = 直接点名它的 binding，加上点名它的用户名 system:serviceaccount:<ns>:<name>、
+// 或它所属的组（system:serviceaccounts、system:serviceaccounts:<ns>、system:authenticated）的 binding。
+// 用 ServiceAccount 的 Pod 来自 refs 表（Pod spec 的 serviceAccountName，见 references.go）。
+// 需要这五种资源的 list/watch 权限；和 KubeVirt 一样不参加 /admin/diff 和对账。
+func initRBAC(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS service_accounts(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    automount_token TEXT,
+    image_pull_secrets TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS roles(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    rules TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS cluster_roles(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    -- 聚合 ClusterRole 的规则由控制器填进 rules，这里只记选择器
+    aggregation TEXT,
+    rules TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS role_bindings(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    role_kind TEXT,
+    role_name TEXT,
+    subjects TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS cluster_role_bindings(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    role_name TEXT,
+    subjects TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS rbac_subjects(
+    binding_uid TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY(binding_uid, kind, namespace, name)
+);`,
+        `CREATE INDEX IF NOT EXISTS service_accounts_ns ON service_accounts(namespace, name)`,
+        `CREATE INDEX IF NOT EXISTS roles_ns ON roles(namespace, name)`,
+        `CREATE INDEX IF NOT EXISTS cluster_roles_name ON cluster_roles(name)`,
+        `CREATE INDEX IF NOT EXISTS rbac_subjects_subject ON rbac_subjects(kind, namespace, name)`,
+        `DROP TRIGGER IF EXISTS role_bindings_subjects_ad`,
+        `CREATE TRIGGER role_bindings_subjects_ad AFTER DELETE ON role_bindings BEGIN
+ DELETE FROM rbac_subjects WHERE binding_uid=old.uid; END`,
+        `DROP TRIGGER IF EXISTS cluster_role_bindings_subjects_ad`,
+        `CREATE TRIGGER cluster_role_bindings_subjects_ad AFTER DELETE ON cluster_role_bindings BEGIN
+ DELETE FROM rbac_subjects WHERE binding_uid=old.uid; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 规则原样存成 JSON（字段名同 API），比较和 history 都用这个字符串
+func rulesJSON(rules []rbacv1.PolicyRule) string {
+    if len(rules) == 0 {
+        return "[]"
+    }
+    b, _ := json.Marshal(rules)
+    return string(b)
+}
+
+// RoleBinding 里 ServiceAccount subject 的 namespace 省略时就是 binding 所在的 namespace
+func bindingSubjects(ns string, subjects []rbacv1.Subject) []rbacv1.Subject {
+    out := make([]rbacv1.Subject, len(subjects))
+    for i, s := range subjects {
+        if s.Kind == rbacv1.ServiceAccountKind && s.Namespace == "" {
+            s.Namespace = ns
+        }
+        // 只比较身份，apiGroup 不影响结果
+        s.APIGroup = ""
+        out[i] = s
+    }
+    return out
+}
+
+func subjectsJSON(subjects []rbacv1.Subject) string {
+    if len(subjects) == 0 {
+        return "[]"
+    }
+    b, _ := json.Marshal(subjects)
+    return string(b)
+}
+
+// 未设置时为空串（按 API 默认挂载 token）
+func automountOf(sa *corev1.ServiceAccount) string {
+    if sa.AutomountServiceAccountToken == nil {
+        return ""
+    }
+    return fmt.Sprint(*sa.AutomountServiceAccountToken)
+}
+
+func imagePullSecretNames(sa *corev1.ServiceAccount) string {
+    names := make([]string, len(sa.ImagePullSecrets))
+    for i, s := range sa.ImagePullSecrets {
+        names[i] = s.Name
+    }
+    return strings.Join(names, ",")
+}
+
+func aggregationOf(cr *rbacv1.ClusterRole) string {
+    if cr.AggregationRule == nil {
+        return ""
+    }
+    var sels []string
+    for _, s := range cr.AggregationRule.ClusterRoleSelectors {
+        sels = append(sels, metav1.FormatLabelSelector(&s))
+    }
+    return strings.Join(sels, ";")
+}
+
+func upsertServiceAccount(db querier, sa *corev1.ServiceAccount) error {
+    if sa == nil {
+        return errors.New("nil serviceaccount")
+    }
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO service_accounts(uid,name,namespace,automount_token,image_pull_secrets,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ automount_token=excluded.automount_token,
+ image_pull_secrets=excluded.image_pull_secrets,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("service_accounts"), string(sa.UID), sa.Name, sa.Namespace, automountOf(sa), imagePullSecretNames(sa),
+        flattenLabels(sa.Labels), sa.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "service_accounts", sa.Namespace+"/"+sa.Name, sa.ResourceVersion)
+    return err
+}
+
+func deleteServiceAccount(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM service_accounts WHERE uid=?`, uid)
+    return err
+}
+
+func upsertRole(db querier, r *rbacv1.Role) error {
+    if r == nil {
+        return errors.New("nil role")
+    }
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO roles(uid,name,namespace,rules,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ rules=excluded.rules,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("roles"), string(r.UID), r.Name, r.Namespace, rulesJSON(r.Rules), flattenLabels(r.Labels), r.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "roles", r.Namespace+"/"+r.Name, r.ResourceVersion)
+    return err
+}
+
+func deleteRole(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM roles WHERE uid=?`, uid)
+    return err
+}
+
+func upsertClusterRole(db querier, cr *rbacv1.ClusterRole) error {
+    if cr == nil {
+        return errors.New("nil clusterrole")
+    }
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO cluster_roles(uid,name,aggregation,rules,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ aggregation=excluded.aggregation,
+ rules=excluded.rules,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("cluster_roles"), string(cr.UID), cr.Name, aggregationOf(cr), rulesJSON(cr.Rules), flattenLabels(cr.Labels),
+        cr.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "cluster_roles", cr.Name, cr.ResourceVersion)
+    return err
+}
+
+func deleteClusterRole(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM cluster_roles WHERE uid=?`, uid)
+    return err
+}
+
+func writeRBACSubjects(db querier, uid string, subjects []rbacv1.Subject) error {
+    if _, err := db.Exec(`DELETE FROM rbac_subjects WHERE binding_uid=?`, uid); err != nil {
+        return err
+    }
+    for _, s := range subjects {
+        if _, err := db.Exec(`INSERT OR IGNORE INTO rbac_subjects(binding_uid,kind,namespace,name) VALUES(?,?,?,?)`,
+            uid, s.Kind, s.Namespace, s.Name); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func upsertRoleBinding(db querier, rb *rbacv1.RoleBinding) error {
+    if rb == nil {
+        return errors.New("nil rolebinding")
+    }
+    subjects := bindingSubjects(rb.Namespace, rb.Subjects)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO role_bindings(uid,name,namespace,role_kind,role_name,subjects,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ role_kind=excluded.role_kind,
+ role_name=excluded.role_name,
+ subjects=excluded.subjects,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("role_bindings"), string(rb.UID), rb.Name, rb.Namespace, rb.RoleRef.Kind, rb.RoleRef.Name, subjectsJSON(subjects),
+        flattenLabels(rb.Labels), rb.ResourceVersion, now, now)
+    if ok, err := upsertApplied(res, err, "role_bindings", rb.Namespace+"/"+rb.Name, rb.ResourceVersion); !ok {
+        return err
+    }
+    return writeRBACSubjects(db, string(rb.UID), subjects)
+}
+
+func deleteRoleBinding(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM role_bindings WHERE uid=?`, uid)
+    return err
+}
+
+func upsertClusterRoleBinding(db querier, crb *rbacv1.ClusterRoleBinding) error {
+    if crb == nil {
+        return errors.New("nil clusterrolebinding")
+    }
+    subjects := bindingSubjects("", crb.Subjects)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO cluster_role_bindings(uid,name,role_name,subjects,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ role_name=excluded.role_name,
+ subjects=excluded.subjects,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("cluster_role_bindings"), string(crb.UID), crb.Name, crb.RoleRef.Name, subjectsJSON(subjects),
+        flattenLabels(crb.Labels), crb.ResourceVersion, now, now)
+    if ok, err := upsertApplied(res, err, "cluster_role_bindings", crb.Name, crb.ResourceVersion); !ok {
+        return err
+    }
+    return writeRBACSubjects(db, string(crb.UID), subjects)
+}
+
+func deleteClusterRoleBinding(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM cluster_role_bindings WHERE uid=?`, uid)
+    return err
+}
+
+// 只给队列用（project 判断 update 是否需要写库）；/admin/diff 和对账不覆盖
+var rbacKinds = []syncDiffKind{
+    {Name: "serviceaccounts", Table: "service_accounts", Key: "uid", NS: "namespace",
+        Cols: []string{"automount_token", "image_pull_secrets", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            sa := o.(*corev1.ServiceAccount)
+            return string(sa.UID), []string{automountOf(sa), imagePullSecretNames(sa), flattenLabels(sa.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertServiceAccount(q, o.(*corev1.ServiceAccount)) },
+        remove: deleteServiceAccount},
+    {Name: "roles", Table: "roles", Key: "uid", NS: "namespace",
+        Cols: []string{"rules", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            r := o.(*rbacv1.Role)
+            return string(r.UID), []string{rulesJSON(r.Rules), flattenLabels(r.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertRole(q, o.(*rbacv1.Role)) },
+        remove: deleteRole},
+    {Name: "clusterroles", Table: "cluster_roles", Key: "uid",
+        Cols: []string{"aggregation", "rules", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            cr := o.(*rbacv1.ClusterRole)
+            return string(cr.UID), []string{aggregationOf(cr), rulesJSON(cr.Rules), flattenLabels(cr.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertClusterRole(q, o.(*rbacv1.ClusterRole)) },
+        remove: deleteClusterRole},
+    {Name: "rolebindings", Table: "role_bindings", Key: "uid", NS: "namespace",
+        Cols: []string{"role_kind", "role_name", "subjects", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            rb := o.(*rbacv1.RoleBinding)
+            return string(rb.UID), []string{rb.RoleRef.Kind, rb.RoleRef.Name, subjectsJSON(bindingSubjects(rb.Namespace, rb.Subjects)),
+                flattenLabels(rb.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertRoleBinding(q, o.(*rbacv1.RoleBinding)) },
+        remove: deleteRoleBinding},
+    {Name: "clusterrolebindings", Table: "cluster_role_bindings", Key: "uid",
+        Cols: []string{"role_name", "subjects", "labels"},
+        project: func(o runtime.Object) (string, []string) {
+            crb := o.(*rbacv1.ClusterRoleBinding)
+            return string(crb.UID), []string{crb.RoleRef.Name, subjectsJSON(bindingSubjects("", crb.Subjects)), flattenLabels(crb.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error {
+            return upsertClusterRoleBinding(q, o.(*rbacv1.ClusterRoleBinding))
+        },
+        remove: deleteClusterRoleBinding},
+}
+
+// 必须在 syncs.run 之前调用；和 watchNamespaces 一样由调用方 Start、不等它同步，未启用时返回 nil
+func watchRBAC(client kubernetes.Interface, cfg RBACConfig, transform cache.TransformFunc, syncs *syncQueue, caches *cacheMeter) informers.SharedInformerFactory {
+    if !cfg.Enabled {
+        return nil
+    }
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(transform))
+    infs := []cache.SharedIndexInformer{
+        factory.Core().V1().ServiceAccounts().Informer(),
+        factory.Rbac().V1().Roles().Informer(),
+        factory.Rbac().V1().ClusterRoles().Informer(),
+        factory.Rbac().V1().RoleBindings().Informer(),
+        factory.Rbac().V1().ClusterRoleBindings().Informer(),
+    }
+    for i, k := range rbacKinds {
+        syncs.addKind(k, infs[i])
+        caches.add(k.Name, infs[i])
+    }
+    return factory
+}
+
+// ---------- HTTP ----------
+
+type ServiceAccountRow struct {
+    UID       string `json:"uid"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // true / false，未设置为空（默认挂载）
+    AutomountToken   string `json:"automountToken"`
+    ImagePullSecrets string `json:"imagePullSecrets"`
+    // 以它的身份运行的 Pod 数
+    Pods int64 `json:"pods"`
+    // subject 里直接点名它的 binding 数，不含经组授予的
+    Bindings  int64  `json:"bindings"`
+    UpdatedAt string `json:"updatedAt"`
+}
+
+type RBACGrant struct {
+    // RoleBinding / ClusterRoleBinding
+    BindingKind      string `json:"bindingKind"`
+    BindingNamespace string `json:"bindingNamespace,omitempty"`
+    BindingName      string `json:"bindingName"`
+    RoleKind         string `json:"roleKind"`
+    RoleName         string `json:"roleName"`
+    // 生效的 namespace；ClusterRoleBinding 为 *（所有 namespace 和集群级资源）
+    Scope string `json:"scope"`
+    // 命中的 subject，例如 ServiceAccount、Group system:serviceaccounts:shop
+    Subject string `json:"subject"`
+    // binding 引用的 Role / ClusterRole 不在库里，不授予任何权限
+    RoleMissing bool                `json:"roleMissing,omitempty"`
+    Rules       []rbacv1.PolicyRule `json:"rules"`
+}
+
+type ServiceAccountPod struct {
+    UID      string `json:"uid"`
+    Name     string `json:"name"`
+    NodeName string `json:"nodeName"`
+    Phase    string `json:"phase"`
+}
+
+type ServiceAccountDetail struct {
+    ServiceAccountRow
+    Grants  []RBACGrant         `json:"grants"`
+    PodList []ServiceAccountPod `json:"podList"`
+}
+
+// 直接点名、按用户名、或通过所属组命中这个 service account 的 subject
+func saSubjectCond(ns, name string) (string, []any) {
+    return `(s.kind='ServiceAccount' AND s.namespace=? AND s.name=?) OR (s.kind='User' AND s.name=?)
+ OR (s.kind='Group' AND s.name IN ('system:serviceaccounts',?,'system:authenticated'))`,
+        []any{ns, name, "system:serviceaccount:" + ns + ":" + name, "system:serviceaccounts:" + ns}
+}
+
+func subjectLabel(kind, name string) string {
+    if kind == rbacv1.ServiceAccountKind {
+        return kind
+    }
+    return kind + " " + name
+}
+
+// verb / resource 为空表示不过滤；规则里的 * 匹配任意值
+func ruleMatches(r rbacv1.PolicyRule, verb, res string) bool {
+    has := func(list []string, v string) bool {
+        return v == "" || slices.Contains(list, v) || slices.Contains(list, "*")
+    }
+    return has(r.Verbs, verb) && has(r.Resources, res)
+}
+
+func loadSAGrants(ctx context.Context, db *sql.DB, ns, name, verb, res string) ([]RBACGrant, error) {
+    cond, args := saSubjectCond(ns, name)
+    q := dbFrom(ctx, db)
+    rows, err := q.Query(`SELECT 'RoleBinding',b.namespace,b.name,b.role_kind,b.role_name,b.namespace,s.kind,s.name
+FROM role_bindings b JOIN rbac_subjects s ON s.binding_uid=b.uid WHERE `+cond+`
+UNION SELECT 'ClusterRoleBinding','',b.name,'ClusterRole',b.role_name,'*',s.kind,s.name
+FROM cluster_role_bindings b JOIN rbac_subjects s ON s.binding_uid=b.uid WHERE `+cond+`
+ORDER BY 1,2,3,7,8`, append(args, args...)...)
+    if err != nil {
+        return nil, err
+    }
+    // 单连接：先读完再查规则
+    var grants []RBACGrant
+    for rows.Next() {
+        var g RBACGrant
+        var kind, subject string
+        if err := rows.Scan(&g.BindingKind, &g.BindingNamespace, &g.BindingName, &g.RoleKind, &g.RoleName, &g.Scope, &kind, &subject); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        g.Subject = subjectLabel(kind, subject)
+        // 同一个 binding 经多个 subject 命中时只留第一个
+        if n := len(grants); n > 0 && grants[n-1].BindingKind == g.BindingKind && grants[n-1].BindingNamespace == g.BindingNamespace &&
+            grants[n-1].BindingName == g.BindingName {
+            continue
+        }
+        grants = append(grants, g)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    out := grants[:0]
+    for _, g := range grants {
+        var rules string
+        var err error
+        if g.RoleKind == "Role" {
+            err = q.QueryRow(`SELECT rules FROM roles WHERE namespace=? AND name=?`, g.BindingNamespace, g.RoleName).Scan(&rules)
+        } else {
+            err = q.QueryRow(`SELECT rules FROM cluster_roles WHERE name=?`, g.RoleName).Scan(&rules)
+        }
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            g.RoleMissing = true
+        case err != nil:
+            return nil, err
+        default:
+            var all []rbacv1.PolicyRule
+            if err := json.Unmarshal([]byte(rules), &all); err != nil {
+                return nil, err
+            }
+            for _, r := range all {
+                if ruleMatches(r, verb, res) {
+                    g.Rules = append(g.Rules, r)
+                }
+            }
+        }
+        // 按 verb / resource 过滤时只留有匹配规则的
+        if (verb != "" || res != "") && len(g.Rules) == 0 {
+            continue
+        }
+        if g.Rules == nil {
+            g.Rules = []rbacv1.PolicyRule{}
+        }
+        out = append(out, g)
+    }
+    return out, nil
+}
+
+const serviceAccountSelect = `SELECT uid,namespace,name,coalesce(automount_token,''),coalesce(image_pull_secrets,''),
+ (SELECT count(*) FROM refs r WHERE r.src_kind='Pod' AND r.target_kind='ServiceAccount' AND r.src_namespace=sa.namespace AND r.target_name=sa.name),
+ (SELECT count(DISTINCT binding_uid) FROM rbac_subjects s WHERE s.kind='ServiceAccount' AND s.namespace=sa.namespace AND s.name=sa.name),
+ updated_at FROM service_accounts sa`
+
+func scanServiceAccounts(rows *sql.Rows) ([]ServiceAccountRow, error) {
+    defer rows.Close()
+    var out []ServiceAccountRow
+    for rows.Next() {
+        var s ServiceAccountRow
+        if err := rows.Scan(&s.UID, &s.Namespace, &s.Name, &s.AutomountToken, &s.ImagePullSecrets, &s.Pods, &s.Bindings, &s.UpdatedAt); err != nil {
+            return nil, err
+        }
+        out = append(out, s)
+    }
+    return out, rows.Err()
+}
+
+// GET /cmdb/serviceaccounts?ns=
+// GET /cmdb/serviceaccounts/{namespace}/{name}?verb=get&resource=secrets   授予它的权限和用它的 Pod
+func serviceAccountsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/serviceaccounts"), "/"); rest != "" {
+            ns, name, ok := strings.Cut(rest, "/")
+            if !ok || ns == "" || name == "" {
+                http.Error(w, "path must be /cmdb/serviceaccounts/{namespace}/{name}", 400)
+                return
+            }
+            getServiceAccount(db, w, r, ns, name)
+            return
+        }
+        cond, condArgs := "", []any{}
+        if ns := r.URL.Query().Get("ns"); ns != "" {
+            cond, condArgs = "namespace=?", []any{ns}
+        }
+        where, args := scopeOf(r.Context()).where("namespace", cond, condArgs...)
+        rows, err := db.QueryContext(r.Context(), serviceAccountSelect+where+` ORDER BY namespace,name`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        list, err := scanServiceAccounts(rows)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "serviceaccounts", ServiceAccountRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, s := range list {
+            if err := lw.Write(s); err != nil {
+                log.Printf("[http] write serviceaccounts: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
+
+func getServiceAccount(db *sql.DB, w http.ResponseWriter, r *http.Request, ns, name string) {
+    ctx := r.Context()
+    where, args := scopeOf(ctx).where("namespace", "namespace=? AND name=?", ns, name)
+    rows, err := db.QueryContext(ctx, serviceAccountSelect+where, args...)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    list, err := scanServiceAccounts(rows)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if len(list) == 0 {
+        http.Error(w, "not found", 404)
+        return
+    }
+    d := ServiceAccountDetail{ServiceAccountRow: list[0], PodList: []ServiceAccountPod{}}
+    if d.Grants, err = loadSAGrants(ctx, db, ns, name, r.URL.Query().Get("verb"), r.URL.Query().Get("resource")); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if d.Grants == nil {
+        d.Grants = []RBACGrant{}
+    }
+    prows, err := db.QueryContext(ctx, `SELECT p.uid,p.name,coalesce(p.node_name,''),coalesce(p.phase,'') FROM refs r JOIN pods p ON p.uid=r.src_ref
+WHERE r.src_kind='Pod' AND r.target_kind='ServiceAccount' AND r.src_namespace=? AND r.target_name=?`, ns, name)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    defer prows.Close()
+    for prows.Next() {
+        var p ServiceAccountPod
+        if err := prows.Scan(&p.UID, &p.Name, &p.NodeName, &p.Phase); err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        d.PodList = append(d.PodList, p)
+    }
+    if err := prows.Err(); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    sort.Slice(d.PodList, func(i, j int) bool { return d.PodList[i].Name < d.PodList[j].Name })
+    writeJSON(w, r, d)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+)
+
+// ---------- Read snapshots ----------
+
+// topology / GraphQL / 集群详情一次响应要查好几张表，中间 informer 可能写库，关系和数量会对不上（Pod 指向刚删掉的 Node 等）。
+// 这些接口在只读事务里执行，事务放在 ctx 上，查询函数用 dbFrom 取，同一响应里看到的是同一个快照。
+// DB 只有一个连接：事务期间其它读写都要等，事务内也不能再直接用 db，否则会一直等自己。
+type readTxKey struct{}
+
+func withReadSnapshot(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+    tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    return fn(context.WithValue(ctx, readTxKey{}, tx))
+}
+
+// ctx 上有快照事务时用事务，否则直接用 db
+func dbFrom(ctx context.Context, db *sql.DB) querier {
+    if tx, ok := ctx.Value(readTxKey{}).(*sql.Tx); ok {
+        return tx
+    }
+    return db
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "slices"
+    "sync"
+    "time"
+
+    "k8s.io/client-go/kubernetes"
+)
+
+// ---------- Drift repair ----------
+
+// 定时跑一遍 /admin/diff 的对比并直接修：missing / stale 按 API server 上的对象重新写入，ghost 删除。
+// 修复在 withChangeSource 事务里执行，history 里的 source 为 reconcile。
+// 对比和修复之间 informer 可能已经写过：修复前重读该行，和对比时不一致就跳过，不用旧对象覆盖新数据。
+type reconciler struct {
+    db     *sql.DB
+    client kubernetes.Interface
+    // 修复直接写库，结束后重载内存读模型
+    hot *hotReadModel
+    // 修复过的 kind 整表重算 tag
+    tags *tagger
+    // --dry-run 时不修复
+    dryRun bool
+    mu     sync.Mutex
+    // 每个 kind 最后一次对比完成（含修复）的时间，/cmdb/status 用；run 期间 mu 一直被占着，单独加锁
+    lastMu   sync.Mutex
+    lastDone map[string]time.Time
+}
+
+type reconcileCount struct {
+    Upserted int `json:"upserted"`
+    Deleted  int `json:"deleted"`
+    // 对比后该行已被 informer 更新
+    Skipped int `json:"skipped"`
+}
+
+type reconcileStats struct {
+    Kinds map[string]reconcileCount `json:"kinds"`
+}
+
+func (c *reconciler) run(ctx context.Context) (reconcileStats, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    st := reconcileStats{Kinds: map[string]reconcileCount{}}
+    now := time.Now()
+    for _, k := range syncDiffKinds {
+        _, cnt, err := c.reconcileKind(ctx, k, now)
+        if err != nil {
+            return st, err
+        }
+        st.Kinds[k.Name] = cnt
+    }
+    return st, nil
+}
+
+// 调用方持有 c.mu
+func (c *reconciler) reconcileKind(ctx context.Context, k syncDiffKind, now time.Time) (SyncDiffCount, reconcileCount, error) {
+    var cnt reconcileCount
+    diff, ds, err := diffSyncKind(ctx, c.db, c.client, k, now)
+    if err != nil {
+        return diff, cnt, err
+    }
+    if len(ds) == 0 {
+        c.reconciled(k.Name)
+        return diff, cnt, nil
+    }
+    err = withChangeSource(c.db, "reconcile", func(tx *sql.Tx) error {
+        cnt = reconcileCount{}
+        for _, d := range ds {
+            if err := repairDiscrepancy(tx, k, d, &cnt); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return diff, cnt, err
+    }
+    if err := c.tags.retagKind(c.db, k.Table); err != nil {
+        log.Printf("[reconcile] retag %s: %v", k.Name, err)
+    }
+    if k.Table == "pods" || k.Table == "nodes" {
+        c.hot.reloadAfter("reconcile")
+    }
+    c.reconciled(k.Name)
+    return diff, cnt, nil
+}
+
+func (c *reconciler) reconciled(kind string) {
+    c.lastMu.Lock()
+    defer c.lastMu.Unlock()
+    if c.lastDone == nil {
+        c.lastDone = map[string]time.Time{}
+    }
+    c.lastDone[kind] = time.Now()
+}
+
+func (c *reconciler) lastReconciled(kind string) (time.Time, bool) {
+    c.lastMu.Lock()
+    defer c.lastMu.Unlock()
+    t, ok := c.lastDone[kind]
+    return t, ok
+}
+
+func repairDiscrepancy(tx *sql.Tx, k syncDiffKind, d SyncDiscrepancy, cnt *reconcileCount) error {
+    rows, err := loadSyncDBRows(tx, k, d.Key)
+    if err != nil {
+        return err
+    }
+    row, exists := rows[d.Key]
+    current := exists && d.dbVals != nil && slices.Equal(row.vals, d.dbVals)
+    switch {
+    case d.Type == "missing" && !exists, d.Type == "stale" && current:
+        cnt.Upserted++
+        return k.upsert(tx, d.obj)
+    case d.Type == "ghost" && current:
+        cnt.Deleted++
+        return k.remove(tx, d.Key)
+    default:
+        cnt.Skipped++
+        return nil
+    }
+}
+
+func (c *reconciler) loop(every time.Duration, stop <-chan struct{}) {
+    t := time.NewTicker(every)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            st, err := c.run(context.Background())
+            if err != nil {
+                log.Printf("[reconcile] %v", err)
+                continue
+            }
+            for kind, cnt := range st.Kinds {
+                if cnt != (reconcileCount{}) {
+                    log.Printf("[reconcile] %s upserted=%d deleted=%d skipped=%d", kind, cnt.Upserted, cnt.Deleted, cnt.Skipped)
+                }
+            }
+        }
+    }
+}
+
+// POST /admin/reconcile 立即执行一次
+func reconcileAPI(c *reconciler) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if c.dryRun {
+            http.Error(w, "running with --dry-run, repairs are disabled", 409)
+            return
+        }
+        st, err := c.run(r.Context())
+        if errors.Is(err, errLiveList) {
+            http.Error(w, err.Error(), 502)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, st)
+    }
+}
+
+type resyncResult struct {
+    Resource string `json:"resource"`
+    // 本次从 API server 列出的对象数和修复前的行数
+    Live int `json:"live"`
+    DB   int `json:"db"`
+    reconcileCount
+}
+
+// POST /admin/resync?resource=pods  只对一种资源立即重新 list 并修复，不用重启进程；resource 取 /admin/diff 的 kinds
+func resyncAPI(c *reconciler) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        name := r.URL.Query().Get("resource")
+        i := slices.IndexFunc(syncDiffKinds, func(k syncDiffKind) bool { return k.Name == name })
+        if i < 0 {
+            http.Error(w, fmt.Sprintf("unknown resource %q", name), 400)
+            return
+        }
+        if c.dryRun {
+            http.Error(w, "running with --dry-run, repairs are disabled", 409)
+            return
+        }
+        c.mu.Lock()
+        diff, cnt, err := c.reconcileKind(r.Context(), syncDiffKinds[i], time.Now())
+        c.mu.Unlock()
+        if errors.Is(err, errLiveList) {
+            http.Error(w, err.Error(), 502)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        log.Printf("[reconcile] resync %s live=%d db=%d upserted=%d deleted=%d skipped=%d", name, diff.Live, diff.DB, cnt.Upserted, cnt.Deleted, cnt.Skipped)
+        writeJSON(w, r, resyncResult{Resource: name, Live: diff.Live, DB: diff.DB, reconcileCount: cnt})
+    }
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "strings"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- References ----------
+
+// 反向引用索引：Pod spec 和 Deployment 模板里引用到的 ConfigMap/Secret/PVC/ServiceAccount，
+// 每个来源对象写入时整体替换自己的那部分；来源被删除时由触发器清理。轮换或删除共享对象前先查这里。
+type objectRef struct {
+    Kind string
+    Name string
+    Via  string
+}
+
+type ReferenceRow struct {
+    Kind      string `json:"kind"` // 引用方：Pod / Deployment
+    Ref       string `json:"ref"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // 引用方式，如 volume:certs、env:DB_PASSWORD、envFrom、imagePullSecret、serviceAccount
+    Via    string `json:"via"`
+    Target string `json:"target"` // <Kind>/<ns>/<name>
+}
+
+var referenceKinds = map[string]string{
+    "secret":                "Secret",
+    "configmap":             "ConfigMap",
+    "cm":                    "ConfigMap",
+    "persistentvolumeclaim": "PersistentVolumeClaim",
+    "pvc":                   "PersistentVolumeClaim",
+    "serviceaccount":        "ServiceAccount",
+    "sa":                    "ServiceAccount",
+}
+
+func initReferences(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS refs(
+    src_kind TEXT NOT NULL,
+    src_ref TEXT NOT NULL,
+    src_namespace TEXT,
+    src_name TEXT,
+    target_kind TEXT NOT NULL,
+    target_name TEXT NOT NULL,
+    via TEXT NOT NULL,
+    PRIMARY KEY(src_kind, src_ref, target_kind, target_name, via)
+);`,
+        `CREATE INDEX IF NOT EXISTS idx_refs_target ON refs(target_kind, src_namespace, target_name)`,
+        `DROP TRIGGER IF EXISTS pods_refs_ad`,
+        `CREATE TRIGGER pods_refs_ad AFTER DELETE ON pods BEGIN
+ DELETE FROM refs WHERE src_kind='Pod' AND src_ref=old.uid; END`,
+        `DROP TRIGGER IF EXISTS deployments_refs_ad`,
+        `CREATE TRIGGER deployments_refs_ad AFTER DELETE ON deployments BEGIN
+ DELETE FROM refs WHERE src_kind='Deployment' AND src_ref=old.uid; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 被引用对象都和引用方在同一 namespace，所以只记名称
+func specReferences(spec corev1.PodSpec) []objectRef {
+    var refs []objectRef
+    add := func(kind, name, via string) {
+        if name != "" {
+            refs = append(refs, objectRef{Kind: kind, Name: name, Via: via})
+        }
+    }
+    sa := spec.ServiceAccountName
+    if sa == "" {
+        sa = "default"
+    }
+    add("ServiceAccount", sa, "serviceAccount")
+    for _, s := range spec.ImagePullSecrets {
+        add("Secret", s.Name, "imagePullSecret")
+    }
+    for _, v := range spec.Volumes {
+        via := "volume:" + v.Name
+        switch {
+        case v.Secret != nil:
+            add("Secret", v.Secret.SecretName, via)
+        case v.ConfigMap != nil:
+            add("ConfigMap", v.ConfigMap.Name, via)
+        case v.PersistentVolumeClaim != nil:
+            add("PersistentVolumeClaim", v.PersistentVolumeClaim.ClaimName, via)
+        case v.Projected != nil:
+            for _, src := range v.Projected.Sources {
+                if src.Secret != nil {
+                    add("Secret", src.Secret.Name, via)
+                }
+                if src.ConfigMap != nil {
+                    add("ConfigMap", src.ConfigMap.Name, via)
+                }
+            }
+        case v.CSI != nil && v.CSI.NodePublishSecretRef != nil:
+            add("Secret", v.CSI.NodePublishSecretRef.Name, via)
+        }
+    }
+    containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+    for _, ec := range spec.EphemeralContainers {
+        containers = append(containers, corev1.Container{Env: ec.Env, EnvFrom: ec.EnvFrom})
+    }
+    for _, c := range containers {
+        for _, ef := range c.EnvFrom {
+            if ef.SecretRef != nil {
+                add("Secret", ef.SecretRef.Name, "envFrom")
+            }
+            if ef.ConfigMapRef != nil {
+                add("ConfigMap", ef.ConfigMapRef.Name, "envFrom")
+            }
+        }
+        for _, e := range c.Env {
+            if e.ValueFrom == nil {
+                continue
+            }
+            if e.ValueFrom.SecretKeyRef != nil {
+                add("Secret", e.ValueFrom.SecretKeyRef.Name, "env:"+e.Name)
+            }
+            if e.ValueFrom.ConfigMapKeyRef != nil {
+                add("ConfigMap", e.ValueFrom.ConfigMapKeyRef.Name, "env:"+e.Name)
+            }
+        }
+    }
+    return refs
+}
+
+// 整体替换某个来源对象的引用；传入 *sql.DB 时自己开事务，已在事务里时直接写
+func replaceReferences(q querier, srcKind, srcRef, ns, name string, refs []objectRef) error {
+    db, ok := q.(*sql.DB)
+    if !ok {
+        return writeReferences(q, srcKind, srcRef, ns, name, refs)
+    }
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if err := writeReferences(tx, srcKind, srcRef, ns, name, refs); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func writeReferences(q querier, srcKind, srcRef, ns, name string, refs []objectRef) error {
+    if _, err := q.Exec(`DELETE FROM refs WHERE src_kind=? AND src_ref=?`, srcKind, srcRef); err != nil {
+        return err
+    }
+    for _, r := range refs {
+        if _, err := q.Exec(`INSERT OR IGNORE INTO refs(src_kind,src_ref,src_namespace,src_name,target_kind,target_name,via)
+VALUES(?,?,?,?,?,?,?)`, srcKind, srcRef, ns, name, r.Kind, r.Name, r.Via); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// GET /cmdb/references?kind=Secret&name=ns/mysecret；name 不带 namespace 时查所有 namespace
+func referencesAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        kind, ok := referenceKinds[strings.ToLower(q.Get("kind"))]
+        if !ok {
+            http.Error(w, "kind must be Secret, ConfigMap, PersistentVolumeClaim or ServiceAccount", 400)
+            return
+        }
+        name := q.Get("name")
+        if name == "" {
+            http.Error(w, "missing name", 400)
+            return
+        }
+        sc, args := scopeOf(r.Context()).cond("src_namespace")
+        query := `SELECT src_kind,src_ref,src_namespace,src_name,via,target_name FROM refs WHERE ` + sc + ` AND target_kind=?`
+        args = append(args, kind)
+        if ns, n, ok := strings.Cut(name, "/"); ok {
+            query += ` AND src_namespace=? AND target_name=?`
+            args = append(args, ns, n)
+        } else {
+            query += ` AND target_name=?`
+            args = append(args, name)
+        }
+        rows, err := db.QueryContext(r.Context(), query+` ORDER BY src_namespace,src_kind,src_name,via`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        var out []ReferenceRow
+        for rows.Next() {
+            var row ReferenceRow
+            var target string
+            if err := rows.Scan(&row.Kind, &row.Ref, &row.Namespace, &row.Name, &row.Via, &target); err != nil {
+                rows.Close()
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            row.Target = kind + "/" + row.Namespace + "/" + target
+            out = append(out, row)
+        }
+        rows.Close()
+        lw, err := newListWriter(w, r, "references", ReferenceRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write references: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ---------- Manual relations ----------
+
+// 自动发现不了的关系（"应用 X 依赖数据库 Y"）由人维护，存在 relations 表里（source=manual），
+// 两端用拓扑的节点 id 表示：pod/<ns>/<name>、node/<name>、host/<address>、netdevice/<address>、asset/<type>/<name> 等。
+// 按名字而不是 uid 记，Pod 重建后关系仍然有效；对象删除时不删关系，拓扑里跳过已不存在的一端。
+// /cmdb/topology 展开时和 runs_on / selects 等自动关系一起返回。
+var relationTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+func initRelations(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS relations(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    from_id TEXT NOT NULL,
+    from_ns TEXT NOT NULL DEFAULT '',
+    to_id TEXT NOT NULL,
+    to_ns TEXT NOT NULL DEFAULT '',
+    type TEXT NOT NULL,
+    source TEXT NOT NULL DEFAULT 'manual',
+    note TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TEXT,
+    UNIQUE(from_id, to_id, type)
+);`,
+        `CREATE INDEX IF NOT EXISTS relations_to ON relations(to_id)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type RelationRequest struct {
+    From string `json:"from"`
+    To   string `json:"to"`
+    // 例如 depends_on、backs_up、connects_to
+    Type string `json:"type"`
+    Note string `json:"note,omitempty"`
+}
+
+type RelationRow struct {
+    ID        int64  `json:"id"`
+    From      string `json:"from"`
+    To        string `json:"to"`
+    Type      string `json:"type"`
+    Source    string `json:"source"`
+    Note      string `json:"note"`
+    CreatedBy string `json:"createdBy"`
+    CreatedAt string `json:"createdAt"`
+}
+
+// 引用对象的别名（cm、pvc、sa）换成拓扑里用的名字，查询时 ci= 才能和存下的 id 对上
+func canonicalCIID(id string) string {
+    kind, rest, ok := strings.Cut(id, "/")
+    if k, found := referenceKinds[kind]; ok && found {
+        return strings.ToLower(k) + "/" + rest
+    }
+    return id
+}
+
+// GET /cmdb/relations?ci=<id>&type=   ci 作为任意一端
+// POST /cmdb/relations                 {"from","to","type","note"}，两端必须是已有的 CI
+// DELETE /cmdb/relations/{id}
+func relationsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/relations"), "/")
+        switch {
+        case r.Method == http.MethodGet && id == "":
+            listRelations(db, w, r)
+        case r.Method == http.MethodPost && id == "":
+            if !canWriteAssets(r) {
+                http.Error(w, "credentials not allowed to modify relations", 403)
+                return
+            }
+            createRelation(db, w, r)
+        case r.Method == http.MethodDelete && id != "":
+            if !canWriteAssets(r) {
+                http.Error(w, "credentials not allowed to modify relations", 403)
+                return
+            }
+            deleteRelation(db, w, r, id)
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
+
+const relationSelect = `SELECT id,from_id,to_id,type,source,note,created_by,coalesce(created_at,'') FROM relations`
+
+func scanRelation(rows *sql.Rows) (RelationRow, error) {
+    var rel RelationRow
+    err := rows.Scan(&rel.ID, &rel.From, &rel.To, &rel.Type, &rel.Source, &rel.Note, &rel.CreatedBy, &rel.CreatedAt)
+    return rel, err
+}
+
+// 限定 namespace 的 key 只看到两端都在范围内的关系
+func listRelations(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    scope := scopeOf(r.Context())
+    fromCond, args := scope.cond("from_ns")
+    toCond, toArgs := scope.cond("to_ns")
+    conds := []string{fromCond, toCond}
+    args = append(args, toArgs...)
+    if v := q.Get("ci"); v != "" {
+        conds = append(conds, "(from_id=? OR to_id=?)")
+        args = append(args, canonicalCIID(v), canonicalCIID(v))
+    }
+    if v := q.Get("type"); v != "" {
+        conds = append(conds, "type=?")
+        args = append(args, v)
+    }
+    rows, err := db.QueryContext(r.Context(), relationSelect+` WHERE `+strings.Join(conds, " AND ")+` ORDER BY id`, args...)
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    defer rows.Close()
+    lw, err := newListWriter(w, r, "relations", RelationRow{})
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    for rows.Next() {
+        rel, err := scanRelation(rows)
+        if err != nil {
+            lw.Fail(err)
+            return
+        }
+        if err := lw.Write(rel); err != nil {
+            log.Printf("[http] write relations: %v", err)
+            return
+        }
+    }
+    if err := rows.Err(); err != nil {
+        lw.Fail(err)
+    }
+    lw.Close()
+}
+
+func createRelation(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+    var req RelationRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid JSON: "+err.Error(), 400)
+        return
+    }
+    if !relationTypePattern.MatchString(req.Type) {
+        http.Error(w, "type must be lowercase letters, digits, '_' or '-', e.g. depends_on", 400)
+        return
+    }
+    // 按拓扑的规则解析两端，存下规范化以后的 id
+    var ends [2]*topoObj
+    for i, id := range []string{req.From, req.To} {
+        o, err := loadTopoRoot(r.Context(), db, id)
+        switch {
+        case errors.Is(err, errTopoRootNotFound):
+            http.Error(w, id+": not found", 404)
+            return
+        case errors.Is(err, errTopoRootInvalid):
+            http.Error(w, id+": "+err.Error(), 400)
+            return
+        case err != nil:
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        ends[i] = o
+    }
+    if ends[0].node.ID == ends[1].node.ID {
+        http.Error(w, "from and to are the same CI", 400)
+        return
+    }
+    rel := RelationRow{From: ends[0].node.ID, To: ends[1].node.ID, Type: req.Type, Source: "manual", Note: req.Note,
+        CreatedBy: changeSourceFor(r, "manual"), CreatedAt: time.Now().Format(time.RFC3339)}
+    conflict := false
+    err := withChangeSource(db, rel.CreatedBy, func(tx *sql.Tx) error {
+        res, err := tx.Exec(`INSERT INTO relations(from_id,from_ns,to_id,to_ns,type,source,note,created_by,created_at)
+ VALUES(?,?,?,?,?,?,?,?,?) ON CONFLICT(from_id,to_id,type) DO NOTHING`,
+            rel.From, ends[0].node.Namespace, rel.To, ends[1].node.Namespace, rel.Type, rel.Source, rel.Note, rel.CreatedBy, rel.CreatedAt)
+        if err != nil {
+            return err
+        }
+        if n, _ := res.RowsAffected(); n == 0 {
+            conflict = true
+            return nil
+        }
+        rel.ID, err = res.LastInsertId()
+        return err
+    })
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if conflict {
+        http.Error(w, "relation already exists", 409)
+        return
+    }
+    log.Printf("[relations] %s %s %s created by %s", rel.From, rel.Type, rel.To, rel.CreatedBy)
+    writeJSON(w, r, rel)
+}
+
+func deleteRelation(db *sql.DB, w http.ResponseWriter, r *http.Request, idStr string) {
+    id, err := strconv.ParseInt(idStr, 10, 64)
+    if err != nil {
+        http.Error(w, "invalid relation id", 400)
+        return
+    }
+    var n int64
+    err = withChangeSource(db, changeSourceFor(r, "manual"), func(tx *sql.Tx) error {
+        res, err := tx.Exec(`DELETE FROM relations WHERE id=?`, id)
+        if err != nil {
+            return err
+        }
+        n, err = res.RowsAffected()
+        return err
+    })
+    if err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+    if n == 0 {
+        http.Error(w, "relation not found", 404)
+        return
+    }
+    log.Printf("[relations] %d deleted by %s", id, changeSourceFor(r, "manual"))
+    writeJSON(w, r, map[string]any{"deleted": id})
+}
+
+// ---------- Topology integration ----------
+
+// 一个 CI 的手工关系（双向）；另一端已不存在或对当前凭据不可见时跳过
+func relationLinks(ctx context.Context, db *sql.DB, id string) ([]topoLink, error) {
+    rows, err := dbFrom(ctx, db).Query(`SELECT from_id,to_id,type FROM relations WHERE from_id=? OR to_id=? ORDER BY id`, id, id)
+    if err != nil {
+        return nil, err
+    }
+    var edges []TopoEdge
+    for rows.Next() {
+        e := TopoEdge{Manual: true}
+        if err := rows.Scan(&e.Source, &e.Target, &e.Type); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        edges = append(edges, e)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    var out []topoLink
+    for _, e := range edges {
+        other := e.Target
+        if other == id {
+            other = e.Source
+        }
+        o, err := loadTopoRoot(ctx, db, other)
+        if errors.Is(err, errTopoRootNotFound) || errors.Is(err, errTopoRootInvalid) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, topoLink{obj: *o, edge: e})
+    }
+    return out, nil
+}
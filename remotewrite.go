@@ -0,0 +1,176 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "net/http"
+    "regexp"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    "google.golang.org/protobuf/encoding/protowire"
+)
+
+// ---------- Prometheus remote-write ----------
+
+// CMDB 只存当前状态，容量趋势要靠 TSDB：配置 remoteWrite.url 后每个 interval 把选中的 /metrics 指标
+// （默认全部 lightcmdb_inventory_*：Pod 数、节点容量、requests 和用量）按 remote-write 1.0 推给
+// Prometheus / Mimir / VictoriaMetrics。WriteRequest 只有几个字段，用 protowire 直接编码，不引入 prompb；
+// snappy 只输出 literal（格式合法，只是不压缩），不引入 snappy 库，inventory 指标每次也只有几十 KB。
+// 一轮的样本共用一个时间戳，失败按 exporters.remoteWrite 的策略重试，仍失败就丢掉这一轮，不补推。
+const remoteWriteDefaultPrefix = "lightcmdb_inventory_"
+
+var (
+    metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+    labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type remoteWriter struct {
+    cfg     RemoteWriteConfig
+    metrics *metricsRegistry
+    client  *http.Client
+}
+
+func newRemoteWriter(cfg RemoteWriteConfig, m *metricsRegistry) *remoteWriter {
+    return &remoteWriter{cfg: cfg, metrics: m, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (w *remoteWriter) Name() string { return "remoteWrite" }
+
+func (w *remoteWriter) Run(h *exportHandle, stop <-chan struct{}) {
+    t := time.NewTicker(w.cfg.Interval.Duration)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case now := <-t.C:
+            body := encodeWriteRequest(w.collect(), now.UnixMilli())
+            if err := h.do(func() error { return w.send(body) }); err != nil && err != errExporterDisabled {
+                log.Printf("[remote-write] %v", err)
+            }
+        }
+    }
+}
+
+func (w *remoteWriter) selected(name string) bool {
+    if len(w.cfg.Metrics) == 0 {
+        return strings.HasPrefix(name, remoteWriteDefaultPrefix)
+    }
+    return slices.Contains(w.cfg.Metrics, name)
+}
+
+type remoteSeries struct {
+    labels []metricLabel
+    value  float64
+}
+
+// 每条序列的 label 带上 __name__ 和 externalLabels（指标自己有的同名 label 优先），按名字排序，去掉空值
+func (w *remoteWriter) collect() []remoteSeries {
+    w.metrics.mu.Lock()
+    families := append([]metricFamily(nil), w.metrics.families...)
+    w.metrics.mu.Unlock()
+    var out []remoteSeries
+    for _, f := range families {
+        if !w.selected(f.Name) {
+            continue
+        }
+        name := f.Name
+        if f.Type == "counter" {
+            name += "_total"
+        }
+        for _, s := range f.Collect() {
+            labels := []metricLabel{{"__name__", name + s.Suffix}}
+            seen := map[string]bool{}
+            for _, l := range s.Labels {
+                seen[l.Name] = true
+                if l.Value != "" {
+                    labels = append(labels, l)
+                }
+            }
+            for k, v := range w.cfg.ExternalLabels {
+                if !seen[k] {
+                    labels = append(labels, metricLabel{k, v})
+                }
+            }
+            sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+            out = append(out, remoteSeries{labels: labels, value: s.Value})
+        }
+    }
+    return out
+}
+
+// WriteRequest{timeseries=1}、TimeSeries{labels=1, samples=2}、Label{name=1, value=2}、Sample{value=1, timestamp=2}
+func encodeWriteRequest(series []remoteSeries, ts int64) []byte {
+    var req []byte
+    for _, s := range series {
+        var b []byte
+        for _, l := range s.labels {
+            var lb []byte
+            lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+            lb = protowire.AppendString(lb, l.Name)
+            lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+            lb = protowire.AppendString(lb, l.Value)
+            b = protowire.AppendTag(b, 1, protowire.BytesType)
+            b = protowire.AppendBytes(b, lb)
+        }
+        var sb []byte
+        sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+        sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+        sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+        sb = protowire.AppendVarint(sb, uint64(ts))
+        b = protowire.AppendTag(b, 2, protowire.BytesType)
+        b = protowire.AppendBytes(b, sb)
+        req = protowire.AppendTag(req, 1, protowire.BytesType)
+        req = protowire.AppendBytes(req, b)
+    }
+    return req
+}
+
+// snappy block 格式：未压缩长度（varint）加一串 literal，每个 literal 最多 64KB
+func snappyLiteral(src []byte) []byte {
+    dst := binary.AppendUvarint(nil, uint64(len(src)))
+    for len(src) > 0 {
+        n := min(len(src), 1<<16)
+        if n <= 60 {
+            dst = append(dst, byte(n-1)<<2)
+        } else {
+            // tag 61：后跟 2 字节小端的 长度-1
+            dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+        }
+        dst = append(dst, src[:n]...)
+        src = src[n:]
+    }
+    return dst
+}
+
+func (w *remoteWriter) send(body []byte) error {
+    req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(snappyLiteral(body)))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Encoding", "snappy")
+    req.Header.Set("Content-Type", "application/x-protobuf")
+    req.Header.Set("User-Agent", "lightcmdb/"+version)
+    req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+    if w.cfg.BearerToken != "" {
+        req.Header.Set("Authorization", "Bearer "+w.cfg.BearerToken)
+    }
+    resp, err := w.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode/100 != 2 {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+    }
+    io.Copy(io.Discard, resp.Body)
+    return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+
+    "modernc.org/sqlite"
+)
+
+// ---------- Read replicas ----------
+
+// 读多的部署把读流量放到 follower 上，不拖慢写库：writer 照常同步集群，GET /admin/replica/snapshot 用 VACUUM INTO
+// 导出一份一致的库文件；follower（replica.role: follower）不连集群，每隔 replica.interval 拉一份，
+// 用 SQLite 的 backup API 整库替换本地库，只提供 /cmdb/*、/graphql 等读接口，写请求返回 405。
+// 数据最多落后一个拉取间隔加导出的时间；拉取失败时保留上一份继续服务。follower 和 writer 要用同一版本。
+
+// writer 一侧。导出期间占着唯一的连接，写入和读请求都要等，库大时拉取间隔不要太短。
+// 导出前先调 saveStatus 把内存里的同步状态写进 sync_status，随快照带给 follower 的 /cmdb/status
+func replicaSnapshotAPI(db *sql.DB, dataDir string, saveStatus func() error) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        tmp := filepath.Join(dataDir, fmt.Sprintf(".replica-%d.db", time.Now().UnixNano()))
+        defer os.Remove(tmp)
+        taken := time.Now().UTC()
+        if err := saveStatus(); err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if _, err := db.ExecContext(r.Context(), `VACUUM INTO ?`, tmp); err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        f, err := os.Open(tmp)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer f.Close()
+        st, err := f.Stat()
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        w.Header().Set("Content-Type", "application/vnd.sqlite3")
+        w.Header().Set("Content-Length", strconv.FormatInt(st.Size(), 10))
+        w.Header().Set("X-Snapshot-Taken", taken.Format(time.RFC3339))
+        if _, err := io.Copy(w, f); err != nil {
+            log.Printf("[http] write replica snapshot: %v", err)
+        }
+    }
+}
+
+type ReplicaStatus struct {
+    Role        string `json:"role"`
+    WriterURL   string `json:"writerURL"`
+    LastAttempt string `json:"lastAttempt,omitempty"`
+    LastSuccess string `json:"lastSuccess,omitempty"`
+    LastError   string `json:"lastError,omitempty"`
+    // 当前数据在 writer 上导出的时间
+    SnapshotTaken string `json:"snapshotTaken,omitempty"`
+    SnapshotBytes int64  `json:"snapshotBytes"`
+}
+
+type replicaFollower struct {
+    db      *sql.DB
+    hot     *hotReadModel
+    cfg     ReplicaConfig
+    dataDir string
+    client  *http.Client
+
+    mu     sync.Mutex
+    status ReplicaStatus
+    taken  time.Time
+}
+
+func newReplicaFollower(db *sql.DB, hot *hotReadModel, cfg ReplicaConfig, dataDir string) *replicaFollower {
+    return &replicaFollower{db: db, hot: hot, cfg: cfg, dataDir: dataDir, client: &http.Client{Timeout: 5 * time.Minute},
+        status: ReplicaStatus{Role: "follower", WriterURL: cfg.WriterURL}}
+}
+
+func (f *replicaFollower) loop(stop <-chan struct{}) {
+    t := time.NewTicker(f.cfg.Interval.Duration)
+    defer t.Stop()
+    for {
+        err := f.pull(context.Background())
+        f.mu.Lock()
+        f.status.LastAttempt = time.Now().UTC().Format(time.RFC3339)
+        if err != nil {
+            f.status.LastError = err.Error()
+            log.Printf("[replica] pull: %v", err)
+        } else {
+            f.status.LastError = ""
+            f.status.LastSuccess = f.status.LastAttempt
+        }
+        f.mu.Unlock()
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+        }
+    }
+}
+
+// 先下载到 dataDir 下的临时文件，完整之后才替换本地库，下载中断不影响正在服务的数据
+func (f *replicaFollower) pull(ctx context.Context) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.cfg.WriterURL+"/admin/replica/snapshot", nil)
+    if err != nil {
+        return err
+    }
+    if f.cfg.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+f.cfg.APIKey)
+    }
+    resp, err := f.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("writer returned %s: %s", resp.Status, b)
+    }
+    tmp, err := os.CreateTemp(f.dataDir, ".replica-*.db")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    n, err := io.Copy(tmp, resp.Body)
+    if cerr := tmp.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        return err
+    }
+    if resp.ContentLength >= 0 && n != resp.ContentLength {
+        return fmt.Errorf("snapshot truncated: %d of %d bytes", n, resp.ContentLength)
+    }
+    if err := restoreSQLite(ctx, f.db, tmp.Name()); err != nil {
+        return err
+    }
+    f.hot.reloadAfter("replica")
+    taken, _ := time.Parse(time.RFC3339, resp.Header.Get("X-Snapshot-Taken"))
+    f.mu.Lock()
+    f.status.SnapshotTaken, f.status.SnapshotBytes, f.taken = resp.Header.Get("X-Snapshot-Taken"), n, taken
+    f.mu.Unlock()
+    log.Printf("[replica] restored snapshot taken %s (%d bytes)", resp.Header.Get("X-Snapshot-Taken"), n)
+    return nil
+}
+
+// backup API 一步拷完，对同一连接上的读请求是原子的
+func restoreSQLite(ctx context.Context, db *sql.DB, path string) error {
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    return conn.Raw(func(dc any) error {
+        r, ok := dc.(interface {
+            NewRestore(string) (*sqlite.Backup, error)
+        })
+        if !ok {
+            return errors.New("sqlite driver does not support restore")
+        }
+        b, err := r.NewRestore("file:" + path + "?mode=ro")
+        if err != nil {
+            return err
+        }
+        _, err = b.Step(-1)
+        if ferr := b.Finish(); err == nil {
+            err = ferr
+        }
+        return err
+    })
+}
+
+func (f *replicaFollower) registerMetrics(m *metricsRegistry) {
+    m.register(metricFamily{Name: "lightcmdb_replica_lag_seconds", Type: "gauge",
+        Help: "Age of the writer snapshot this follower currently serves",
+        Collect: func() []metricSample {
+            f.mu.Lock()
+            defer f.mu.Unlock()
+            if f.taken.IsZero() {
+                return nil
+            }
+            return []metricSample{{Value: time.Since(f.taken).Seconds()}}
+        }})
+}
+
+// 当前数据在 writer 上导出的时间，还没拉到过时为零值
+func (f *replicaFollower) snapshotTaken() time.Time {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.taken
+}
+
+// GET /admin/replica
+func (f *replicaFollower) statusAPI(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", 405)
+        return
+    }
+    f.mu.Lock()
+    st := f.status
+    f.mu.Unlock()
+    writeJSON(w, r, st)
+}
+
+// follower 上只有读：GraphQL 没有 mutation，POST /graphql 也是查询；POST /cmdb/batch 同样只读
+func readOnly(writerURL string, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch {
+        case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+        case r.Method == http.MethodPost && (r.URL.Path == "/graphql" || r.URL.Path == "/cmdb/batch"):
+        default:
+            http.Error(w, "read-only follower, send writes to "+writerURL, 405)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// replica.role: follower 时 main 在打开库之后调用，不返回
+func runFollower(cfg *Config, db *sql.DB, auth *authenticator) {
+    var hot *hotReadModel
+    if cfg.Storage.HotReadModel {
+        hot = newHotReadModel(db)
+    }
+    f := newReplicaFollower(db, hot, cfg.Replica, cfg.Storage.DataDir)
+    f.registerMetrics(metrics)
+    stop := make(chan struct{})
+    go f.loop(stop)
+
+    registerInventoryMetrics(metrics, db)
+    usage := newUsageTracker()
+    usage.registerMetrics(metrics)
+    api := http.NewServeMux()
+    if err := registerReadAPI(api, db, hot, cfg); err != nil {
+        log.Fatalf("graphql schema: %v", err)
+    }
+    api.HandleFunc("/admin/replica", f.statusAPI)
+    api.HandleFunc("/cmdb/status", followerSyncStatusAPI(db, f))
+    api.HandleFunc("/admin/consumers", consumersAPI(usage))
+    log.Printf("[replica] follower of %s, pulling every %s", cfg.Replica.WriterURL, cfg.Replica.Interval.Duration)
+    serveHTTP(cfg, auth, usage, f, readOnly(cfg.Replica.WriterURL, api), stop)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes/fake"
+)
+
+// follower 的 /cmdb/status：writer 导出时的同步状态随快照过来，行数按本地库、带上快照时间
+func TestFollowerSyncStatusFromSnapshot(t *testing.T) {
+    writer := newTestDB(t)
+    if _, err := writer.Exec(`INSERT INTO nodes(name) VALUES('n1'),('n2')`); err != nil {
+        t.Fatal(err)
+    }
+    factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+    syncs := newSyncQueue(writer, nil)
+    syncs.add("nodes", factory.Core().V1().Nodes().Informer())
+    srv := httptest.NewServer(replicaSnapshotAPI(writer, t.TempDir(), func() error {
+        return syncs.saveStatuses(writer, "edge-1", &reconciler{})
+    }))
+    defer srv.Close()
+
+    follower := newTestDB(t)
+    f := newReplicaFollower(follower, nil, ReplicaConfig{WriterURL: srv.URL}, t.TempDir())
+    if err := f.pull(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    rec := httptest.NewRecorder()
+    followerSyncStatusAPI(follower, f)(rec, httptest.NewRequest(http.MethodGet, "/cmdb/status", nil))
+    if rec.Code != 200 {
+        t.Fatalf("%d %s", rec.Code, rec.Body)
+    }
+    var list []SyncStatus
+    if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+        t.Fatalf("%s: %v", rec.Body, err)
+    }
+    if len(list) != 1 {
+        t.Fatalf("status = %s", rec.Body)
+    }
+    st := list[0]
+    if st.Kind != "nodes" || st.Cluster != "edge-1" || st.Rows != 2 || st.SnapshotTaken == "" || st.ReplicaLag == "" {
+        t.Fatalf("status = %s", rec.Body)
+    }
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "html/template"
+    "log"
+    "net/http"
+    "net/smtp"
+    "strings"
+    "time"
+
+    "k8s.io/client-go/kubernetes"
+)
+
+// ---------- Summary reports ----------
+
+// 按天或按周生成库存摘要：期间新建 / 删除的 Pod、节点变化、用得最多的镜像、漂移（当前 DB 与 API server 的差异，
+// 以及期间 reconcile 修掉的记录）。新建 / 删除和节点变化取自 changes 表。
+// 定时生成后 POST JSON 到 webhook 和 / 或以 HTML 邮件发出，经 exporter "reports" 发送；错过的周期（进程没在跑）不补发。
+const reportListLimit = 100
+
+type ReportObject struct {
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    Time      string `json:"time"`
+}
+
+type ReportPods struct {
+    // 当前的 Pod 数
+    Total   int `json:"total"`
+    Created int `json:"created"`
+    Deleted int `json:"deleted"`
+    // 各最多 100 条，按时间排序
+    New     []ReportObject `json:"new"`
+    Removed []ReportObject `json:"removed"`
+}
+
+type ReportNodeChange struct {
+    Name    string `json:"name"`
+    Created int    `json:"created"`
+    Updated int    `json:"updated"`
+    Deleted int    `json:"deleted"`
+}
+
+type ReportNodes struct {
+    Total   int                `json:"total"`
+    Changes []ReportNodeChange `json:"changes"`
+}
+
+type ReportDrift struct {
+    // 生成报告时的差异，只列有差异的资源
+    Open map[string]SyncDiffCount `json:"open"`
+    // 对比失败时的错误，此时 open 为空
+    Error string `json:"error,omitempty"`
+    // 期间 reconcile 写入的变更数，按 kind
+    Repaired []StatCount `json:"repaired"`
+}
+
+type InventoryReport struct {
+    Site        string       `json:"site,omitempty"`
+    Period      string       `json:"period"`
+    From        string       `json:"from"`
+    To          string       `json:"to"`
+    GeneratedAt string       `json:"generatedAt"`
+    Pods        ReportPods   `json:"pods"`
+    Nodes       ReportNodes  `json:"nodes"`
+    TopImages   []ImageCount `json:"topImages"`
+    Drift       ReportDrift  `json:"drift"`
+}
+
+type reporter struct {
+    db     *sql.DB
+    client kubernetes.Interface
+    cfg    ReportsConfig
+    site   string
+    http   *http.Client
+}
+
+func newReporter(db *sql.DB, client kubernetes.Interface, cfg ReportsConfig, site string) *reporter {
+    return &reporter{db: db, client: client, cfg: cfg, site: site, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func periodLength(period string) time.Duration {
+    if period == "weekly" {
+        return 7 * 24 * time.Hour
+    }
+    return 24 * time.Hour
+}
+
+// 下一次生成的时间：每天 at，weekly 只在周一
+func (c ReportsConfig) next(after time.Time) time.Time {
+    at, _ := time.Parse("15:04", c.At)
+    t := time.Date(after.Year(), after.Month(), after.Day(), at.Hour(), at.Minute(), 0, 0, after.Location())
+    for !t.After(after) || (c.Schedule == "weekly" && t.Weekday() != time.Monday) {
+        t = t.AddDate(0, 0, 1)
+    }
+    return t
+}
+
+func reportObjects(db *sql.DB, op, from, to string) (int, []ReportObject, error) {
+    var total int
+    if err := db.QueryRow(`SELECT count(*) FROM changes WHERE kind='pod' AND op=? AND ts>? AND ts<=?`, op, from, to).Scan(&total); err != nil {
+        return 0, nil, err
+    }
+    rows, err := db.Query(`SELECT coalesce(namespace,''),coalesce(name,''),ts FROM changes
+ WHERE kind='pod' AND op=? AND ts>? AND ts<=? ORDER BY id LIMIT ?`, op, from, to, reportListLimit)
+    if err != nil {
+        return 0, nil, err
+    }
+    defer rows.Close()
+    out := []ReportObject{}
+    for rows.Next() {
+        var o ReportObject
+        if err := rows.Scan(&o.Namespace, &o.Name, &o.Time); err != nil {
+            return 0, nil, err
+        }
+        out = append(out, o)
+    }
+    return total, out, rows.Err()
+}
+
+// 压缩合并掉的更新（squashed）也算在 updated 里
+func reportNodeChanges(db *sql.DB, from, to string) ([]ReportNodeChange, error) {
+    rows, err := db.Query(`SELECT ref,sum(op='create'),sum(CASE WHEN op='update' THEN 1+squashed ELSE 0 END),sum(op='delete')
+ FROM changes WHERE kind='node' AND ts>? AND ts<=? GROUP BY ref ORDER BY ref`, from, to)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := []ReportNodeChange{}
+    for rows.Next() {
+        var c ReportNodeChange
+        if err := rows.Scan(&c.Name, &c.Created, &c.Updated, &c.Deleted); err != nil {
+            return nil, err
+        }
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+func (rp *reporter) generate(ctx context.Context, period string, end time.Time) (*InventoryReport, error) {
+    start := end.Add(-periodLength(period))
+    from, to := start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)
+    rep := &InventoryReport{Site: rp.site, Period: period, From: from, To: to, GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+    var err error
+    if err = rp.db.QueryRow(`SELECT count(*) FROM pods`).Scan(&rep.Pods.Total); err != nil {
+        return nil, err
+    }
+    if rep.Pods.Created, rep.Pods.New, err = reportObjects(rp.db, "create", from, to); err != nil {
+        return nil, err
+    }
+    if rep.Pods.Deleted, rep.Pods.Removed, err = reportObjects(rp.db, "delete", from, to); err != nil {
+        return nil, err
+    }
+    if err = rp.db.QueryRow(`SELECT count(*) FROM nodes`).Scan(&rep.Nodes.Total); err != nil {
+        return nil, err
+    }
+    if rep.Nodes.Changes, err = reportNodeChanges(rp.db, from, to); err != nil {
+        return nil, err
+    }
+    if rep.TopImages, err = imageCounts(rp.db, "", nil); err != nil {
+        return nil, err
+    }
+    if len(rep.TopImages) > rp.cfg.TopImages {
+        rep.TopImages = rep.TopImages[:rp.cfg.TopImages]
+    }
+    if rep.Drift.Repaired, err = statCounts(rp.db, `SELECT kind,count(*) FROM changes
+ WHERE source='reconcile' AND ts>? AND ts<=? GROUP BY kind ORDER BY kind`, from, to); err != nil {
+        return nil, err
+    }
+    rep.Drift.Open = map[string]SyncDiffCount{}
+    now := time.Now()
+    for _, k := range syncDiffKinds {
+        cnt, _, err := diffSyncKind(ctx, rp.db, rp.client, k, now)
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil, ctx.Err()
+            }
+            rep.Drift.Open, rep.Drift.Error = map[string]SyncDiffCount{}, fmt.Sprintf("%s: %v", k.Name, err)
+            break
+        }
+        if cnt.Missing+cnt.Stale+cnt.Ghost > 0 {
+            rep.Drift.Open[k.Name] = cnt
+        }
+    }
+    return rep, nil
+}
+
+var reportPage = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>LightCMDB {{.Period}} report{{if .Site}} {{.Site}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 1.5em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { padding: 2px 8px; text-align: left; border-bottom: 1px solid #ddd; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h2>LightCMDB {{.Period}} report{{if .Site}} — {{.Site}}{{end}}</h2>
+<p class="muted">{{.From}} → {{.To}}</p>
+<h3>Pods</h3>
+<p>{{.Pods.Total}} pods, {{.Pods.Created}} created, {{.Pods.Deleted}} deleted</p>
+{{if .Pods.New}}<table><tr><th>New</th><th>Time</th></tr>
+{{range .Pods.New}}<tr><td>{{.Namespace}}/{{.Name}}</td><td>{{.Time}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Pods.Removed}}<table><tr><th>Removed</th><th>Time</th></tr>
+{{range .Pods.Removed}}<tr><td>{{.Namespace}}/{{.Name}}</td><td>{{.Time}}</td></tr>
+{{end}}</table>{{end}}
+<h3>Nodes</h3>
+<p>{{.Nodes.Total}} nodes{{if not .Nodes.Changes}}, no changes{{end}}</p>
+{{if .Nodes.Changes}}<table><tr><th>Node</th><th>Created</th><th>Updated</th><th>Deleted</th></tr>
+{{range .Nodes.Changes}}<tr><td>{{.Name}}</td><td>{{.Created}}</td><td>{{.Updated}}</td><td>{{.Deleted}}</td></tr>
+{{end}}</table>{{end}}
+<h3>Top images</h3>
+<table><tr><th>Image</th><th>Pods</th></tr>
+{{range .TopImages}}<tr><td>{{.Image}}</td><td>{{.Pods}}</td></tr>
+{{end}}</table>
+<h3>Drift</h3>
+{{if .Drift.Error}}<p>Live comparison failed: {{.Drift.Error}}</p>
+{{else if .Drift.Open}}<table><tr><th>Resource</th><th>Missing</th><th>Stale</th><th>Ghost</th></tr>
+{{range $k, $c := .Drift.Open}}<tr><td>{{$k}}</td><td>{{$c.Missing}}</td><td>{{$c.Stale}}</td><td>{{$c.Ghost}}</td></tr>
+{{end}}</table>
+{{else}}<p>DB matches the API server.</p>{{end}}
+{{if .Drift.Repaired}}<p>Repaired by reconcile:{{range .Drift.Repaired}} {{.Key}} {{.Count}}{{end}}</p>{{end}}
+<p class="muted">Generated {{.GeneratedAt}}</p>
+</body>
+</html>
+`))
+
+func (rep *InventoryReport) html() ([]byte, error) {
+    var buf bytes.Buffer
+    err := reportPage.Execute(&buf, rep)
+    return buf.Bytes(), err
+}
+
+func (rp *reporter) email(rep *InventoryReport) error {
+    e := rp.cfg.Email
+    body, err := rep.html()
+    if err != nil {
+        return err
+    }
+    subject := fmt.Sprintf("LightCMDB %s report %s", rep.Period, rep.From[:10])
+    if rep.Site != "" {
+        subject += " (" + rep.Site + ")"
+    }
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\n\r\n",
+        e.From, strings.Join(e.To, ", "), subject, time.Now().Format(time.RFC1123Z))
+    msg.Write(body)
+    var auth smtp.Auth
+    if e.User != "" {
+        host, _, _ := strings.Cut(e.SMTP, ":")
+        auth = smtp.PlainAuth("", e.User, e.Password, host)
+    }
+    return smtp.SendMail(e.SMTP, auth, e.From, e.To, msg.Bytes())
+}
+
+// 两个通道分别记住是否已送达，exportHandle 重试时不重复发已成功的那个
+func (rp *reporter) send(h *exportHandle, rep *InventoryReport) error {
+    body, err := json.Marshal(rep)
+    if err != nil {
+        return err
+    }
+    hookDone, mailDone := rp.cfg.WebhookURL == "", rp.cfg.Email.SMTP == ""
+    return h.do(func() error {
+        var errs []error
+        if !hookDone {
+            if err := postWebhook(rp.http, rp.cfg.WebhookURL, rp.cfg.WebhookSecret, body); err != nil {
+                errs = append(errs, fmt.Errorf("webhook: %w", err))
+            } else {
+                hookDone = true
+            }
+        }
+        if !mailDone {
+            if err := rp.email(rep); err != nil {
+                errs = append(errs, fmt.Errorf("email: %w", err))
+            } else {
+                mailDone = true
+            }
+        }
+        return errors.Join(errs...)
+    })
+}
+
+func (rp *reporter) Name() string { return "reports" }
+
+func (rp *reporter) Run(h *exportHandle, stop <-chan struct{}) {
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        <-stop
+        cancel()
+    }()
+    for {
+        at := rp.cfg.next(time.Now())
+        t := time.NewTimer(time.Until(at))
+        select {
+        case <-stop:
+            t.Stop()
+            return
+        case <-t.C:
+        }
+        rep, err := rp.generate(ctx, rp.cfg.Schedule, at)
+        if err != nil {
+            if ctx.Err() == nil {
+                log.Printf("[reports] generate: %v", err)
+            }
+            continue
+        }
+        if err := rp.send(h, rep); err != nil {
+            if err != errExporterDisabled {
+                log.Printf("[reports] send: %v", err)
+            }
+            continue
+        }
+        log.Printf("[reports] sent %s report %s - %s", rep.Period, rep.From, rep.To)
+    }
+}
+
+// GET /admin/report?period=daily|weekly&format=json|html   截至现在的报告
+// POST /admin/report                                       按 reports 的配置立即生成并发送一次
+func reportAPI(rp *reporter, h *exportHandle) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        period := r.URL.Query().Get("period")
+        switch period {
+        case "":
+            period = rp.cfg.Schedule
+            if period == "" {
+                period = "daily"
+            }
+        case "daily", "weekly":
+        default:
+            http.Error(w, "period must be daily or weekly", 400)
+            return
+        }
+        if r.Method != http.MethodGet && r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        if r.Method == http.MethodPost && h == nil {
+            http.Error(w, "reports.schedule is not configured", 409)
+            return
+        }
+        rep, err := rp.generate(r.Context(), period, time.Now())
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        if r.Method == http.MethodPost {
+            err := rp.send(h, rep)
+            if err == errExporterDisabled {
+                http.Error(w, err.Error(), 409)
+                return
+            }
+            if err != nil {
+                http.Error(w, err.Error(), 502)
+                return
+            }
+        }
+        if r.URL.Query().Get("format") == "html" {
+            page, err := rep.html()
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            w.Write(page)
+            return
+        }
+        writeJSON(w, r, rep)
+    }
+}
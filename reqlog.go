@@ -0,0 +1,134 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ---------- Request log ----------
+
+// 最外层中间件：沿用客户端/网关传来的 X-Request-ID（不合法就重新生成），回写到响应头，
+// 每个请求记一行 [http] 日志；4xx/5xx 的纯文本错误体末尾也带上这个 ID，方便用户报问题时对日志。
+const requestIDHeader = "X-Request-ID"
+
+// 只接受长度合理的可打印 ASCII，避免把换行之类写进日志
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 128 {
+        return false
+    }
+    for _, c := range id {
+        if c < 0x21 || c > 0x7e {
+            return false
+        }
+    }
+    return true
+}
+
+func newRequestID() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+    if s.status == 0 {
+        s.status = code
+    }
+    s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+    if s.status == 0 {
+        s.status = http.StatusOK
+    }
+    n, err := s.ResponseWriter.Write(b)
+    s.bytes += int64(n)
+    return n, err
+}
+
+// 列表接口和 live 代理依赖 Flusher
+func (s *statusRecorder) Flush() {
+    if f, ok := s.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// /cmdb/subscribe 升级成 WebSocket 要接管连接
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    conn, rw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+    if err == nil && s.status == 0 {
+        s.status = http.StatusSwitchingProtocols
+    }
+    return conn, rw, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+    return s.ResponseWriter
+}
+
+// 内层补充的访问信息：认证后的调用方、列表接口输出的行数，requestLog 记日志时带上
+type accessInfo struct {
+    consumer string
+    rows     int64
+    // 处理超过 server.requestTimeout
+    timedOut bool
+}
+
+type accessInfoKey struct{}
+
+func accessInfoFrom(ctx context.Context) *accessInfo {
+    ai, _ := ctx.Value(accessInfoKey{}).(*accessInfo)
+    return ai
+}
+
+func requestLog(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get(requestIDHeader)
+        if !validRequestID(id) {
+            id = newRequestID()
+        }
+        // 写回请求头，live 代理转发时会带上
+        r.Header.Set(requestIDHeader, id)
+        w.Header().Set(requestIDHeader, id)
+        rec := &statusRecorder{ResponseWriter: w}
+        ai := &accessInfo{}
+        start := time.Now()
+        next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, ai)))
+        if rec.status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+            fmt.Fprintf(rec, "request id: %s\n", id)
+        }
+        if r.URL.Path == "/healthz" {
+            return
+        }
+        status := rec.status
+        if status == 0 {
+            status = http.StatusOK
+        }
+        extra := ""
+        if ai.consumer != "" {
+            extra += " " + ai.consumer
+        }
+        if ai.rows > 0 {
+            extra += fmt.Sprintf(" rows=%d", ai.rows)
+        }
+        if ai.timedOut {
+            extra += " timeout"
+        }
+        log.Printf("[http] %s %s %s %d %s %dB%s", id, r.Method, r.URL.Path, status,
+            time.Since(start).Round(100*time.Microsecond), rec.bytes, extra)
+    })
+}
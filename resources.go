@@ -0,0 +1,216 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    appsv1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ---------- Services / Deployments / ReplicaSets ----------
+
+// replicasets 只用来把 Pod 的 owner 链解析到 Deployment，不对外单独提供
+func initWorkloadSchema(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS services(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    type TEXT,
+    cluster_ip TEXT,
+    selector TEXT,
+    ports TEXT,
+    labels TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS deployments(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    replicas INTEGER,
+    ready_replicas INTEGER,
+    images TEXT,
+    labels TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS replicasets(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    owner_kind TEXT,
+    owner_name TEXT,
+    owner_uid TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`}
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 只记录 controller=true 的那个 ownerReference
+func controllerOf(obj metav1.Object) (kind, name, uid string) {
+    if ref := metav1.GetControllerOf(obj); ref != nil {
+        return ref.Kind, ref.Name, string(ref.UID)
+    }
+    return "", "", ""
+}
+
+func containerImages(spec corev1.PodSpec) string {
+    return podImages(&corev1.Pod{Spec: spec})
+}
+
+func servicePorts(s *corev1.Service) string {
+    var ports []string
+    for _, p := range s.Spec.Ports {
+        v := p.TargetPort.String()
+        ports = append(ports, strings.ToLower(string(p.Protocol))+"/"+strconv.Itoa(int(p.Port))+":"+v)
+    }
+    return strings.Join(ports, ",")
+}
+
+func upsertService(db querier, s *corev1.Service) error {
+    if s == nil {
+        return errors.New("nil service")
+    }
+    lb := serviceLoadBalancer(s)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO services(uid,name,namespace,type,cluster_ip,selector,ports,labels,lb_ips,lb_provider,lb_pool,lb_node,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ type=excluded.type,
+ cluster_ip=excluded.cluster_ip,
+ selector=excluded.selector,
+ ports=excluded.ports,
+ labels=excluded.labels,
+ lb_ips=excluded.lb_ips,
+ lb_provider=excluded.lb_provider,
+ lb_pool=excluded.lb_pool,
+ lb_node=excluded.lb_node,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("services"), string(s.UID), s.Name, s.Namespace, string(s.Spec.Type), sealField(s.Spec.ClusterIP), flattenLabels(s.Spec.Selector),
+        servicePorts(s), flattenLabels(s.Labels), sealList(lb.IPs), lb.Provider, lb.Pool, lb.Node, s.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "services", s.Namespace+"/"+s.Name, s.ResourceVersion)
+    return err
+}
+
+func deleteService(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM services WHERE uid=?`, uid)
+    return err
+}
+
+func upsertDeployment(db querier, d *appsv1.Deployment) error {
+    if d == nil {
+        return errors.New("nil deployment")
+    }
+    replicas := deploymentReplicas(d)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO deployments(uid,name,namespace,replicas,ready_replicas,images,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ replicas=excluded.replicas,
+ ready_replicas=excluded.ready_replicas,
+ images=excluded.images,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("deployments"), string(d.UID), d.Name, d.Namespace, replicas, d.Status.ReadyReplicas, containerImages(d.Spec.Template.Spec),
+        flattenLabels(d.Labels), d.ResourceVersion, now, now)
+    if ok, err := upsertApplied(res, err, "deployments", d.Namespace+"/"+d.Name, d.ResourceVersion); !ok {
+        return err
+    }
+    return replaceReferences(db, "Deployment", string(d.UID), d.Namespace, d.Name, specReferences(d.Spec.Template.Spec))
+}
+
+// spec.replicas 未设置时默认 1
+func deploymentReplicas(d *appsv1.Deployment) int32 {
+    if d.Spec.Replicas != nil {
+        return *d.Spec.Replicas
+    }
+    return 1
+}
+
+func deleteDeployment(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM deployments WHERE uid=?`, uid)
+    return err
+}
+
+func upsertReplicaSet(db querier, rs *appsv1.ReplicaSet) error {
+    if rs == nil {
+        return errors.New("nil replicaset")
+    }
+    kind, name, uid := controllerOf(rs)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO replicasets(uid,name,namespace,owner_kind,owner_name,owner_uid,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ owner_kind=excluded.owner_kind,
+ owner_name=excluded.owner_name,
+ owner_uid=excluded.owner_uid,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("replicasets"), string(rs.UID), rs.Name, rs.Namespace, kind, name, uid, rs.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "replicasets", rs.Namespace+"/"+rs.Name, rs.ResourceVersion)
+    return err
+}
+
+func deleteReplicaSet(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM replicasets WHERE uid=?`, uid)
+    return err
+}
+
+// ---------- resourceVersion guard ----------
+
+// 每行记下写入时对象的 resourceVersion，upsert 带的版本比库里旧就不更新：
+// relist、对账的 List 结果或重试的旧事件晚到时，不会把较新的状态改回去。
+// resourceVersion 按约定是不透明字符串，但 etcd 下是递增整数，这里按整数比较（按字符串 "9" > "10"）；
+// 任一边为空（旧库里的行、测试用的对象）时不拦。
+var versionedTables = []string{"pods", "nodes", "services", "deployments", "replicasets"}
+
+func initResourceVersions(db *sql.DB) error {
+    for _, t := range versionedTables {
+        if err := addColumnIfMissing(db, t, "resource_version", "TEXT"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 接在 ON CONFLICT DO UPDATE SET ... 之后
+func notOlderThanStored(table string) string {
+    return `WHERE excluded.resource_version='' OR coalesce(` + table + `.resource_version,'')=''
+ OR CAST(excluded.resource_version AS INTEGER)>=CAST(` + table + `.resource_version AS INTEGER)`
+}
+
+// 被版本条件拦下时 SQLite 报告 0 行变更；返回 false 时调用方不再写引用等附属数据
+func upsertApplied(res sql.Result, err error, table, key, rv string) (bool, error) {
+    if err != nil {
+        return false, err
+    }
+    if n, err := res.RowsAffected(); err != nil || n > 0 {
+        return err == nil, err
+    }
+    log.Printf("[%s] %s resourceVersion %s is older than the stored row, skipped", table, key, rv)
+    return false, nil
+}
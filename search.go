@@ -0,0 +1,221 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "net/http"
+    "net/netip"
+    "strconv"
+    "strings"
+
+    cmdbclient "lightcmdb-week3/client"
+)
+
+// ---------- Search ----------
+
+// 每种 CI 在 search_fts 中的映射，表达式里的 {row} 是行别名（new/old/r）
+type searchSource struct {
+    Kind      string
+    Table     string
+    Key       string
+    Name      string
+    Namespace string
+    Labels    string
+    IPs       string
+    Images    string
+}
+
+var searchSources = []searchSource{
+    {
+        Kind:      "pod",
+        Table:     "pods",
+        Key:       "uid",
+        Name:      "{row}.name",
+        Namespace: "{row}.namespace",
+        Labels:    "{row}.labels",
+        IPs:       "{row}.pod_ip",
+        Images:    "{row}.images",
+    },
+    {
+        Kind:      "node",
+        Table:     "nodes",
+        Key:       "name",
+        Name:      "{row}.name",
+        Namespace: "''",
+        Labels:    "{row}.labels",
+        IPs:       "{row}.internal_ip",
+        Images:    "''",
+    },
+    {
+        Kind:      "service",
+        Table:     "services",
+        Key:       "uid",
+        Name:      "{row}.name",
+        Namespace: "{row}.namespace",
+        Labels:    "{row}.labels",
+        IPs:       "trim(coalesce({row}.cluster_ip,'')||' '||coalesce({row}.lb_ips,''))",
+        Images:    "''",
+    },
+    {
+        Kind:      "deployment",
+        Table:     "deployments",
+        Key:       "uid",
+        Name:      "{row}.name",
+        Namespace: "{row}.namespace",
+        Labels:    "{row}.labels",
+        IPs:       "''",
+        Images:    "{row}.images",
+    },
+}
+
+func (s searchSource) values(alias string) string {
+    cols := []string{s.Name, s.Namespace, s.Labels, s.IPs, s.Images}
+    for i, c := range cols {
+        cols[i] = "coalesce(" + strings.ReplaceAll(c, "{row}", alias) + ",'')"
+    }
+    return strings.Join(cols, ",")
+}
+
+// 触发器内的 OR IGNORE 会被外层 UPSERT 的冲突策略覆盖，这里用 NOT EXISTS
+func (s searchSource) ensureDocSQL(alias string) string {
+    return fmt.Sprintf(`INSERT INTO search_docs(kind,ref) SELECT '%[1]s',%[2]s.%[3]s
+ WHERE NOT EXISTS (SELECT 1 FROM search_docs WHERE kind='%[1]s' AND ref=%[2]s.%[3]s);`, s.Kind, alias, s.Key)
+}
+
+func (s searchSource) insertSQL(alias string) string {
+    return fmt.Sprintf(`INSERT INTO search_fts(rowid,name,namespace,labels,ips,images)
+ SELECT id,%s FROM search_docs WHERE kind='%s' AND ref=%s.%s;`, s.values(alias), s.Kind, alias, s.Key)
+}
+
+func (s searchSource) deleteSQL(alias string) string {
+    return fmt.Sprintf(`DELETE FROM search_fts WHERE rowid=(SELECT id FROM search_docs WHERE kind='%s' AND ref=%s.%s);`, s.Kind, alias, s.Key)
+}
+
+func initSearch(db *sql.DB) error {
+    // search_docs 提供稳定的 rowid，避免依赖业务表的隐式 rowid（VACUUM 后会变）
+    stmts := []string{
+        `CREATE TABLE IF NOT EXISTS search_docs(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    UNIQUE(kind, ref)
+);`,
+        `CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts5(name, namespace, labels, ips, images);`,
+    }
+    for _, s := range searchSources {
+        stmts = append(stmts,
+            fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_search_ai`, s.Table),
+            fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_search_au`, s.Table),
+            fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_search_ad`, s.Table),
+            fmt.Sprintf(`CREATE TRIGGER %s_search_ai AFTER INSERT ON %s BEGIN
+ %s
+ %s
+END;`, s.Table, s.Table, s.ensureDocSQL("new"), s.insertSQL("new")),
+            fmt.Sprintf(`CREATE TRIGGER %s_search_au AFTER UPDATE ON %s BEGIN
+ %s
+ %s
+ %s
+END;`, s.Table, s.Table, s.deleteSQL("old"), s.ensureDocSQL("new"), s.insertSQL("new")),
+            fmt.Sprintf(`CREATE TRIGGER %s_search_ad AFTER DELETE ON %s BEGIN
+ %s
+ DELETE FROM search_docs WHERE kind='%s' AND ref=old.%s;
+END;`, s.Table, s.Table, s.deleteSQL("old"), s.Kind, s.Key),
+        )
+    }
+    for _, stmt := range stmts {
+        if _, err := db.Exec(stmt); err != nil {
+            return err
+        }
+    }
+    return rebuildSearchIndex(db)
+}
+
+// 启动时全量重建，保证索引与业务表一致（老库升级、崩溃残留）
+func rebuildSearchIndex(db *sql.DB) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if _, err := tx.Exec(`DELETE FROM search_fts`); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM search_docs`); err != nil {
+        return err
+    }
+    for _, s := range searchSources {
+        if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO search_docs(kind,ref) SELECT '%s',%s FROM %s`, s.Kind, s.Key, s.Table)); err != nil {
+            return err
+        }
+        if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO search_fts(rowid,name,namespace,labels,ips,images)
+ SELECT d.id,%s FROM %s r JOIN search_docs d ON d.kind='%s' AND d.ref=r.%s`, s.values("r"), s.Table, s.Kind, s.Key)); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// 把用户输入转成 FTS5 查询：每个词做前缀短语匹配，词之间 AND
+// 例如 10.42.3.17 -> "10.42.3.17"*，避免 . - : 等字符触发 FTS 语法错误
+func ftsQuery(q string) string {
+    var terms []string
+    for _, t := range strings.Fields(q) {
+        term := `"` + strings.ReplaceAll(t, `"`, `""`) + `"*`
+        // 加密后的 IP 在索引里是一个整体的密文 token，只能精确匹配
+        if a, err := netip.ParseAddr(t); err == nil && dbCipher.Load() != nil {
+            term = `(` + term + ` OR "` + sealField(a.String()) + `")`
+        }
+        terms = append(terms, term)
+    }
+    return strings.Join(terms, " ")
+}
+
+type SearchHit = cmdbclient.SearchHit
+
+func searchAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := ftsQuery(r.URL.Query().Get("q"))
+        if q == "" {
+            http.Error(w, "missing q", 400)
+            return
+        }
+        limit := 50
+        if v := r.URL.Query().Get("limit"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n <= 0 {
+                http.Error(w, "invalid limit", 400)
+                return
+            }
+            limit = min(n, 500)
+        }
+        query := `SELECT d.kind,d.ref,f.name,f.namespace,cmdb_unseal(snippet(search_fts,-1,'[',']','...',8))
+FROM search_fts f JOIN search_docs d ON d.id=f.rowid
+WHERE search_fts MATCH ?`
+        args := []any{q}
+        sc, sargs := scopeOf(r.Context()).cond("f.namespace")
+        query += ` AND ` + sc
+        args = append(args, sargs...)
+        if t := r.URL.Query().Get("type"); t != "" {
+            query += ` AND d.kind=?`
+            args = append(args, t)
+        }
+        query += ` ORDER BY rank LIMIT ?`
+        args = append(args, limit)
+        rows, err := db.QueryContext(r.Context(), query, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        out := []SearchHit{}
+        for rows.Next() {
+            var h SearchHit
+            if err := rows.Scan(&h.Type, &h.ID, &h.Name, &h.Namespace, &h.Match); err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            out = append(out, h)
+        }
+        writeJSON(w, r, out)
+    }
+}
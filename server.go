@@ -0,0 +1,86 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "log"
+    "net/http"
+    "time"
+)
+
+// ---------- HTTP server ----------
+
+// 只设 ReadHeaderTimeout 时，慢吞吞发请求体或不读响应的客户端能一直占着连接；列表接口边扫描边输出，
+// 卡在写响应上的请求还会一直占着 SQLite 唯一的连接。所以读、写、空闲都有期限，
+// 另外每个请求的 context 带上 requestTimeout，处理函数把它传给查询，到期后查询中断、连接让出来。
+func newHTTPServer(cfg ServerConfig, h http.Handler) *http.Server {
+    return &http.Server{
+        Addr:              cfg.Listen,
+        Handler:           h,
+        ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+        ReadTimeout:       cfg.ReadTimeout.Duration,
+        WriteTimeout:      cfg.WriteTimeout.Duration,
+        IdleTimeout:       cfg.IdleTimeout.Duration,
+        MaxHeaderBytes:    cfg.MaxHeaderBytes,
+    }
+}
+
+// 超时的请求在 [http] 日志行末尾记 timeout
+func withRequestTimeout(d time.Duration, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), d)
+        defer cancel()
+        next.ServeHTTP(w, r.WithContext(ctx))
+        if ctx.Err() == context.DeadlineExceeded {
+            if ai := accessInfoFrom(ctx); ai != nil {
+                ai.timedOut = true
+            }
+        }
+    })
+}
+
+// 在 httpMux 之上加请求日志、CORS 和请求期限后监听，不返回
+func serveHTTP(cfg *Config, auth *authenticator, usage *usageTracker, fresh freshnessSource, api http.Handler, stop <-chan struct{}) {
+    mux := httpMux(cfg, auth, usage, fresh, api, stop)
+
+    // 请求期限放在请求日志里面，超时才能记到日志行上
+    srv := newHTTPServer(cfg.Server,
+        requestLog(newCORSPolicy(cfg.CORS).wrap(withRequestTimeout(cfg.Server.RequestTimeout.Duration, mux))))
+
+    if cfg.TLS.CertFile != "" {
+        certs, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+        if err != nil {
+            log.Fatalf("tls: %v", err)
+        }
+        go certs.watch(stop)
+        srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+        log.Printf("LightCMDB Week3 %s started on %s (TLS)", version, srv.Addr)
+        log.Fatal(srv.ListenAndServeTLS("", ""))
+    }
+    log.Printf("LightCMDB Week3 %s started on %s", version, srv.Addr)
+    log.Fatal(srv.ListenAndServe())
+}
+
+// 公开路由（publicPaths）单独注册，其余路径都先认证再交给 api
+func httpMux(cfg *Config, auth *authenticator, usage *usageTracker, fresh freshnessSource, api http.Handler, stop <-chan struct{}) *http.ServeMux {
+    mux := http.NewServeMux()
+    limits := newLimiter(cfg.Limits)
+    go limits.gc(stop)
+    api = deprecateLegacy(cfg.LegacyAPI, api)
+    api = warmingGate(cfg.Server.WhileWarming, fresh, api)
+    api = newFieldMasker(cfg.Masking).wrap(api)
+    mux.Handle("/", auth.wrap(limits.wrap(usage.wrap(api))))
+    if auth.oidc != nil {
+        mux.HandleFunc("/auth/login", auth.oidc.loginHandler)
+        mux.HandleFunc("/auth/callback", auth.oidc.callbackHandler)
+        mux.HandleFunc("/auth/logout", auth.oidc.logoutHandler)
+    }
+    registerBuildInfoMetric(metrics)
+    mux.Handle("/metrics", metrics)
+    mux.HandleFunc("/openapi.json", openAPIHandler)
+    mux.HandleFunc("/docs", swaggerUIHandler(cfg.SwaggerUIBase))
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+    mux.HandleFunc("/version", versionAPI)
+    mux.Handle(apiV1Prefix+"/", apiV1(fresh, mux))
+    return mux
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// 没登记为公开的路由默认要认证，包括以后新加的前缀和 /api/v1 下的同一路由
+func TestHTTPMuxDeniesByDefault(t *testing.T) {
+    t.Setenv("LIGHTCMDB_API_KEYS", "k1")
+    auth, err := loadAuthenticator(AuthConfig{}, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg := &Config{}
+    if err := cfg.validate(); err != nil {
+        t.Fatal(err)
+    }
+    api := http.NewServeMux()
+    api.HandleFunc("/newthing/items", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+    stop := make(chan struct{})
+    defer close(stop)
+    mux := httpMux(cfg, auth, newUsageTracker(), newSyncQueue(newTestDB(t), nil), api, stop)
+    call := func(target, key string) int {
+        r := httptest.NewRequest(http.MethodGet, target, nil)
+        if key != "" {
+            r.Header.Set("Authorization", "Bearer "+key)
+        }
+        rec := httptest.NewRecorder()
+        mux.ServeHTTP(rec, r)
+        return rec.Code
+    }
+    for _, c := range []struct {
+        target, key string
+        want        int
+    }{
+        {"/newthing/items", "", 401},
+        {"/api/v1/newthing/items", "", 401},
+        {"/federation/config", "", 401},
+        {"/nowhere", "", 401},
+        {"/newthing/items", "k1", 200},
+        {"/healthz", "", 200},
+        {"/version", "", 200},
+        {"/api/v1/healthz", "", 200},
+    } {
+        if got := call(c.target, c.key); got != c.want {
+            t.Errorf("GET %s key=%q: got %d, want %d", c.target, c.key, got, c.want)
+        }
+    }
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ---------- ServiceNow export ----------
+
+// 定时把库存推到 ServiceNow 的 CMDB（Table API），代替手工同步。serviceNow.mappings 决定哪个 kind 进哪张 CI 表、
+// 每个 ServiceNow 字段取哪一列（history 的投影列，和 git 快照是同一份数据）。
+// 每条记录的 correlation_id 写成 <prefix>:<kind>:<ref>，据此找到上次推过去的 CI：没有就新建，字段有变化才 PATCH。
+// 只做新建和更新；LightCMDB 里删除的对象不会在 ServiceNow 里删除或退役。
+const serviceNowPageSize = 1000
+
+type serviceNowExporter struct {
+    db     *sql.DB
+    cfg    ServiceNowConfig
+    client *http.Client
+}
+
+type ServiceNowStats struct {
+    Created   int `json:"created"`
+    Updated   int `json:"updated"`
+    Unchanged int `json:"unchanged"`
+    Failed    int `json:"failed"`
+}
+
+func newServiceNowExporter(db *sql.DB, cfg ServiceNowConfig) *serviceNowExporter {
+    return &serviceNowExporter{db: db, cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func historySourceOf(kind string) (historySource, bool) {
+    for _, h := range historySources {
+        if h.Kind == kind {
+            return h, true
+        }
+    }
+    return historySource{}, false
+}
+
+// 列名 ref 是主键（pod uid、node name ...），"=" 开头的是常量
+func (m ServiceNowMapping) value(row map[string]any, col string) string {
+    if c, ok := strings.CutPrefix(col, "="); ok {
+        return c
+    }
+    v := row[col]
+    if v == nil {
+        return ""
+    }
+    return fmt.Sprint(v)
+}
+
+// 按映射渲染本地记录，key 是 correlation_id
+func (s *serviceNowExporter) localRecords(m ServiceNowMapping) (map[string]map[string]string, error) {
+    h, _ := historySourceOf(m.Kind)
+    rows, err := s.db.Query(fmt.Sprintf(`SELECT t.%s,%s FROM %s t`, h.Key, h.plainObject("t"), h.Table))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string]map[string]string{}
+    for rows.Next() {
+        var ref, doc string
+        if err := rows.Scan(&ref, &doc); err != nil {
+            return nil, err
+        }
+        row := map[string]any{}
+        dec := json.NewDecoder(strings.NewReader(doc))
+        dec.UseNumber()
+        if err := dec.Decode(&row); err != nil {
+            return nil, err
+        }
+        row["ref"] = ref
+        rec := map[string]string{}
+        for field, col := range m.Fields {
+            rec[field] = m.value(row, col)
+        }
+        out[s.cfg.CorrelationPrefix+":"+m.Kind+":"+ref] = rec
+    }
+    return out, rows.Err()
+}
+
+// ServiceNow 的错误体是 {"error":{"message":"...","detail":"..."}}
+func (s *serviceNowExporter) call(ctx context.Context, method, path string, query url.Values, body any, out any) error {
+    u := strings.TrimSuffix(s.cfg.Instance, "/") + path
+    if len(query) > 0 {
+        u += "?" + query.Encode()
+    }
+    var rd io.Reader
+    if body != nil {
+        b, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        rd = bytes.NewReader(b)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, u, rd)
+    if err != nil {
+        return err
+    }
+    req.SetBasicAuth(s.cfg.User, s.cfg.Password)
+    req.Header.Set("Accept", "application/json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    b, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode >= 300 {
+        var e struct {
+            Error struct {
+                Message string `json:"message"`
+                Detail  string `json:"detail"`
+            } `json:"error"`
+        }
+        msg := strings.TrimSpace(string(b))
+        if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+            msg = strings.TrimSuffix(e.Error.Message+": "+e.Error.Detail, ": ")
+        }
+        return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, msg)
+    }
+    if out == nil {
+        return nil
+    }
+    return json.Unmarshal(b, out)
+}
+
+// 上次推过去的 CI（按 correlation_id 前缀），值都取原始值，引用字段是 sys_id
+func (s *serviceNowExporter) remoteRecords(ctx context.Context, m ServiceNowMapping) (map[string]map[string]string, error) {
+    fields := []string{"sys_id", "correlation_id"}
+    for f := range m.Fields {
+        fields = append(fields, f)
+    }
+    sort.Strings(fields)
+    out := map[string]map[string]string{}
+    for offset := 0; ; offset += serviceNowPageSize {
+        var page struct {
+            Result []map[string]any `json:"result"`
+        }
+        q := url.Values{
+            "sysparm_query":                  {"correlation_idSTARTSWITH" + s.cfg.CorrelationPrefix + ":" + m.Kind + ":"},
+            "sysparm_fields":                 {strings.Join(fields, ",")},
+            "sysparm_display_value":          {"false"},
+            "sysparm_exclude_reference_link": {"true"},
+            "sysparm_limit":                  {fmt.Sprint(serviceNowPageSize)},
+            "sysparm_offset":                 {fmt.Sprint(offset)},
+        }
+        if err := s.call(ctx, http.MethodGet, "/api/now/table/"+m.Table, q, nil, &page); err != nil {
+            return nil, err
+        }
+        for _, r := range page.Result {
+            rec := map[string]string{}
+            for k, v := range r {
+                if v != nil {
+                    rec[k] = fmt.Sprint(v)
+                }
+            }
+            out[rec["correlation_id"]] = rec
+        }
+        if len(page.Result) < serviceNowPageSize {
+            return out, nil
+        }
+    }
+}
+
+// 单条记录失败只计数，其余照常推送；有失败时整轮返回错误，由 exportHandle 重试（重试是幂等的）
+func (s *serviceNowExporter) syncMapping(ctx context.Context, m ServiceNowMapping, st *ServiceNowStats) error {
+    local, err := s.localRecords(m)
+    if err != nil {
+        return err
+    }
+    remote, err := s.remoteRecords(ctx, m)
+    if err != nil {
+        return err
+    }
+    ids := make([]string, 0, len(local))
+    for id := range local {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    var firstErr error
+    for _, id := range ids {
+        rec := local[id]
+        old, ok := remote[id]
+        var err error
+        switch {
+        case !ok:
+            body := map[string]string{"correlation_id": id}
+            for k, v := range rec {
+                body[k] = v
+            }
+            if err = s.call(ctx, http.MethodPost, "/api/now/table/"+m.Table, nil, body, nil); err == nil {
+                st.Created++
+            }
+        default:
+            changed := map[string]string{}
+            for k, v := range rec {
+                if old[k] != v {
+                    changed[k] = v
+                }
+            }
+            if len(changed) == 0 {
+                st.Unchanged++
+                continue
+            }
+            if err = s.call(ctx, http.MethodPatch, "/api/now/table/"+m.Table+"/"+url.PathEscape(old["sys_id"]), nil, changed, nil); err == nil {
+                st.Updated++
+            }
+        }
+        if err != nil {
+            if ctx.Err() != nil {
+                return ctx.Err()
+            }
+            st.Failed++
+            if firstErr == nil {
+                firstErr = fmt.Errorf("%s: %w", id, err)
+            }
+        }
+    }
+    return firstErr
+}
+
+func (s *serviceNowExporter) run(ctx context.Context) (ServiceNowStats, error) {
+    var st ServiceNowStats
+    var errs []error
+    for _, m := range s.cfg.Mappings {
+        if err := s.syncMapping(ctx, m, &st); err != nil {
+            if ctx.Err() != nil {
+                return st, ctx.Err()
+            }
+            errs = append(errs, fmt.Errorf("%s -> %s: %w", m.Kind, m.Table, err))
+        }
+    }
+    if st.Failed > 0 {
+        errs = append(errs, fmt.Errorf("%d records failed", st.Failed))
+    }
+    return st, errors.Join(errs...)
+}
+
+func (s *serviceNowExporter) Name() string { return "serviceNow" }
+
+func (s *serviceNowExporter) Run(h *exportHandle, stop <-chan struct{}) {
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        <-stop
+        cancel()
+    }()
+    t := time.NewTicker(s.cfg.Interval.Duration)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            st, err := s.export(ctx, h)
+            if err != nil {
+                if err != errExporterDisabled && ctx.Err() == nil {
+                    log.Printf("[servicenow] %v", err)
+                }
+                continue
+            }
+            if st.Created+st.Updated > 0 {
+                log.Printf("[servicenow] created=%d updated=%d unchanged=%d", st.Created, st.Updated, st.Unchanged)
+            }
+        }
+    }
+}
+
+func (s *serviceNowExporter) export(ctx context.Context, h *exportHandle) (ServiceNowStats, error) {
+    var st ServiceNowStats
+    err := h.do(func() error {
+        var err error
+        st, err = s.run(ctx)
+        return err
+    })
+    return st, err
+}
+
+// POST /admin/servicenow/sync 立即同步一次，返回本轮的计数
+func serviceNowSyncAPI(s *serviceNowExporter, h *exportHandle) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        st, err := s.export(r.Context(), h)
+        if err == errExporterDisabled {
+            http.Error(w, err.Error(), 409)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), 502)
+            return
+        }
+        writeJSON(w, r, st)
+    }
+}
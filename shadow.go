@@ -0,0 +1,206 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/meta"
+    "k8s.io/apimachinery/pkg/runtime"
+)
+
+// ---------- Dry-run / shadow mode ----------
+
+// lightcmdb --dry-run：informer 照常 list/watch，队列照常处理，但 worker 不调用 upsert / remove，
+// 只按库里现有的行算出这次会 insert、update（哪些列）还是 delete，打日志。加 --shadow 时再把结果记到 shadow_rows，
+// 每个对象一行、保留最后一次（又和主表一致时删掉），/admin/shadow 列出来，用来在生产库上验证新的 collector 再正式打开。
+// 主表一行不改：定时 reconcile、metrics-server 轮询和其他写主表的采集（公告、镜像扫描、主机发现、云资产）都不启动，
+// /admin/reconcile、/admin/resync 返回 409。
+func initShadow(db *sql.DB) error {
+    _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS shadow_rows(
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    namespace TEXT,
+    name TEXT,
+    action TEXT NOT NULL,
+    fields TEXT,
+    vals TEXT,
+    observed_at TEXT NOT NULL,
+    PRIMARY KEY(kind, key)
+);`)
+    return err
+}
+
+type ShadowRow struct {
+    Kind      string `json:"kind"`
+    Key       string `json:"key"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // insert / update / delete
+    Action string `json:"action"`
+    // update 时和主表不同的列，逗号分隔
+    Fields string `json:"fields"`
+    // 会写入的列值（JSON 对象），delete 时为空
+    Values     string `json:"values"`
+    ObservedAt string `json:"observedAt"`
+}
+
+// syncStore 的 --dry-run 实现；shadow 为 false 时只打日志
+type dryRunStore struct {
+    db     *sql.DB
+    shadow bool
+}
+
+func (s *dryRunStore) apply(k syncDiffKind, ns, name string, obj runtime.Object) error {
+    // 这次记下的 key；同名的其他 shadow 行已经过时（对象还没进主表就删了，或又和主表一致了）
+    var recorded []any
+    keep := ""
+    if obj != nil {
+        m, err := meta.Accessor(obj)
+        if err != nil {
+            return err
+        }
+        key, vals := k.project(obj)
+        keep = key
+        rows, err := loadSyncDBRows(s.db, k, key)
+        if err != nil {
+            return err
+        }
+        row := ShadowRow{Kind: k.Name, Key: key, Namespace: m.GetNamespace(), Name: m.GetName(), Action: "insert"}
+        if stored, ok := rows[key]; ok {
+            var changed []string
+            for i, col := range k.Cols {
+                if vals[i] != stored.vals[i] {
+                    changed = append(changed, col)
+                }
+            }
+            row.Action, row.Fields = "update", strings.Join(changed, ",")
+        }
+        if row.Action == "insert" || row.Fields != "" {
+            byCol := make(map[string]string, len(k.Cols))
+            for i, col := range k.Cols {
+                byCol[col] = vals[i]
+            }
+            b, _ := json.Marshal(byCol)
+            row.Values = string(b)
+            if err := s.record(row); err != nil {
+                return err
+            }
+            recorded = append(recorded, key)
+        }
+    }
+    stale, err := staleRowKeys(s.db, k, ns, name, keep)
+    if err != nil {
+        return err
+    }
+    for _, key := range stale {
+        if err := s.record(ShadowRow{Kind: k.Name, Key: key, Namespace: ns, Name: name, Action: "delete"}); err != nil {
+            return err
+        }
+        recorded = append(recorded, key)
+    }
+    if !s.shadow {
+        return nil
+    }
+    query, args := `DELETE FROM shadow_rows WHERE kind=? AND coalesce(namespace,'')=? AND name=?`, []any{k.Name, ns, name}
+    if len(recorded) > 0 {
+        query += ` AND key NOT IN (?` + strings.Repeat(",?", len(recorded)-1) + `)`
+        args = append(args, recorded...)
+    }
+    _, err = s.db.Exec(query, args...)
+    return err
+}
+
+func (s *dryRunStore) record(row ShadowRow) error {
+    if row.Fields != "" {
+        log.Printf("[%s/dry-run] %s %s fields=%s", row.Kind, row.Action, cacheKey(row.Namespace, row.Name), row.Fields)
+    } else {
+        log.Printf("[%s/dry-run] %s %s", row.Kind, row.Action, cacheKey(row.Namespace, row.Name))
+    }
+    if !s.shadow {
+        return nil
+    }
+    _, err := s.db.Exec(`
+INSERT INTO shadow_rows(kind,key,namespace,name,action,fields,vals,observed_at) VALUES(?,?,?,?,?,?,?,?)
+ON CONFLICT(kind,key) DO UPDATE SET
+ namespace=excluded.namespace,
+ name=excluded.name,
+ action=excluded.action,
+ fields=excluded.fields,
+ vals=excluded.vals,
+ observed_at=excluded.observed_at`, row.Kind, row.Key, row.Namespace, row.Name, row.Action, row.Fields, row.Values,
+        time.Now().UTC().Format(time.RFC3339))
+    return err
+}
+
+func cacheKey(ns, name string) string {
+    if ns == "" {
+        return name
+    }
+    return ns + "/" + name
+}
+
+// GET /admin/shadow?kind=pods&action=update[&format=csv]
+func shadowAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        var conds []string
+        var args []any
+        if v := q.Get("kind"); v != "" {
+            conds, args = append(conds, "kind=?"), append(args, v)
+        }
+        if v := q.Get("action"); v != "" {
+            if !slices.Contains([]string{"insert", "update", "delete"}, v) {
+                http.Error(w, "action must be insert, update or delete", 400)
+                return
+            }
+            conds, args = append(conds, "action=?"), append(args, v)
+        }
+        query := `SELECT kind,key,coalesce(namespace,''),coalesce(name,''),action,coalesce(fields,''),coalesce(vals,''),observed_at FROM shadow_rows`
+        if len(conds) > 0 {
+            query += ` WHERE ` + strings.Join(conds, " AND ")
+        }
+        var out []ShadowRow
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            rows, err := dbFrom(ctx, db).Query(query+` ORDER BY kind,namespace,name,key`, args...)
+            if err != nil {
+                return err
+            }
+            defer rows.Close()
+            for rows.Next() {
+                var s ShadowRow
+                if err := rows.Scan(&s.Kind, &s.Key, &s.Namespace, &s.Name, &s.Action, &s.Fields, &s.Values, &s.ObservedAt); err != nil {
+                    return err
+                }
+                out = append(out, s)
+            }
+            return rows.Err()
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "shadow", ShadowRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, s := range out {
+            if err := lw.Write(s); err != nil {
+                log.Printf("[http] write shadow: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
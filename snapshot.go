@@ -0,0 +1,238 @@
+package main
+
+import (
+    "bytes"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "sigs.k8s.io/yaml"
+)
+
+// ---------- Git snapshot ----------
+
+// 定时把库存渲染成 YAML（每个对象一个文件，只含 history 的投影列，键有序），
+// 有变化就 commit 到 gitSnapshot.dir，配置了 remote 再 push。长期历史交给 git 做 diff/blame/异地备份。
+type gitSnapshotter struct {
+    db  *sql.DB
+    cfg GitSnapshotConfig
+    mu  sync.Mutex
+}
+
+type snapshotStats struct {
+    Files     int    `json:"files"`
+    Written   int    `json:"written"`
+    Removed   int    `json:"removed"`
+    Committed bool   `json:"committed"`
+    Commit    string `json:"commit,omitempty"`
+    Pushed    bool   `json:"pushed"`
+}
+
+// pods/<ns>/<name>.yaml、nodes/<name>.yaml，目录名取 history 的 kind
+func (h historySource) snapshotPath(ns, name string) string {
+    dir := h.Kind + "s"
+    if ns == "" {
+        return filepath.Join(dir, safeFileName(name)+".yaml")
+    }
+    return filepath.Join(dir, safeFileName(ns), safeFileName(name)+".yaml")
+}
+
+// k8s 名称本身是 DNS 安全的，这里只防御 "/" 和 ".."
+func safeFileName(s string) string {
+    s = strings.ReplaceAll(s, "/", "_")
+    if s == "" || s == "." || s == ".." {
+        s = "_" + s
+    }
+    return s
+}
+
+func renderInventory(db *sql.DB) (map[string][]byte, error) {
+    files := map[string][]byte{}
+    for _, h := range historySources {
+        rows, err := db.Query(fmt.Sprintf(`SELECT %s,%s,%s FROM %s t`,
+            h.col(h.Namespace, "t"), h.col(h.Name, "t"), h.plainObject("t"), h.Table))
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            var ns, name, doc string
+            if err := rows.Scan(&ns, &name, &doc); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            var obj map[string]any
+            if err := json.Unmarshal([]byte(doc), &obj); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            obj["kind"] = h.Kind
+            b, err := yaml.Marshal(obj)
+            if err != nil {
+                rows.Close()
+                return nil, err
+            }
+            files[h.snapshotPath(ns, name)] = b
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+    return files, nil
+}
+
+func (g *gitSnapshotter) git(args ...string) (string, error) {
+    cmd := exec.Command("git", append([]string{"-C", g.cfg.Dir}, args...)...)
+    cmd.Env = append(os.Environ(),
+        "GIT_AUTHOR_NAME="+g.cfg.AuthorName, "GIT_AUTHOR_EMAIL="+g.cfg.AuthorEmail,
+        "GIT_COMMITTER_NAME="+g.cfg.AuthorName, "GIT_COMMITTER_EMAIL="+g.cfg.AuthorEmail)
+    var out bytes.Buffer
+    cmd.Stdout = &out
+    cmd.Stderr = &out
+    err := cmd.Run()
+    if err != nil {
+        return out.String(), fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(out.String()))
+    }
+    return strings.TrimSpace(out.String()), nil
+}
+
+// 写入渲染结果，并删掉受管目录（各 kind 目录）下已不存在的对象文件；内容未变的文件不重写
+func (g *gitSnapshotter) writeFiles(files map[string][]byte) (written, removed int, err error) {
+    for rel, b := range files {
+        path := filepath.Join(g.cfg.Dir, rel)
+        if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, b) {
+            continue
+        }
+        if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+            return written, removed, err
+        }
+        if err := os.WriteFile(path, b, 0o600); err != nil {
+            return written, removed, err
+        }
+        written++
+    }
+    for _, h := range historySources {
+        root := filepath.Join(g.cfg.Dir, h.Kind+"s")
+        err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+            if err != nil || fi.IsDir() || !strings.HasSuffix(path, ".yaml") {
+                return nil
+            }
+            rel, _ := filepath.Rel(g.cfg.Dir, path)
+            if _, ok := files[rel]; !ok {
+                removed++
+                return os.Remove(path)
+            }
+            return nil
+        })
+        if err != nil {
+            return written, removed, err
+        }
+    }
+    return written, removed, nil
+}
+
+func (g *gitSnapshotter) run() (snapshotStats, error) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    var st snapshotStats
+    files, err := renderInventory(g.db)
+    if err != nil {
+        return st, err
+    }
+    st.Files = len(files)
+    if err := os.MkdirAll(g.cfg.Dir, 0o700); err != nil {
+        return st, err
+    }
+    if _, err := os.Stat(filepath.Join(g.cfg.Dir, ".git")); os.IsNotExist(err) {
+        if _, err := g.git("init", "-q", "-b", g.cfg.Branch); err != nil {
+            return st, err
+        }
+    }
+    if st.Written, st.Removed, err = g.writeFiles(files); err != nil {
+        return st, err
+    }
+    if _, err := g.git("add", "-A"); err != nil {
+        return st, err
+    }
+    // 没有变化就不产生空提交
+    if status, err := g.git("status", "--porcelain"); err != nil || status == "" {
+        return st, err
+    }
+    msg := fmt.Sprintf("inventory snapshot %s (%d objects)", time.Now().UTC().Format(time.RFC3339), len(files))
+    if _, err := g.git("commit", "-q", "-m", msg); err != nil {
+        return st, err
+    }
+    st.Committed = true
+    st.Commit, _ = g.git("rev-parse", "--short", "HEAD")
+    if g.cfg.Remote != "" {
+        if _, err := g.git("push", "-q", g.cfg.Remote, "HEAD:"+g.cfg.Branch); err != nil {
+            // push 失败不影响本地提交，下次成功时一并推上去
+            return st, err
+        }
+        st.Pushed = true
+    }
+    return st, nil
+}
+
+func (g *gitSnapshotter) Name() string { return "gitSnapshot" }
+
+func (g *gitSnapshotter) Run(h *exportHandle, stop <-chan struct{}) {
+    t := time.NewTicker(g.cfg.Interval.Duration)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+            st, err := g.export(h)
+            if err != nil {
+                if err != errExporterDisabled {
+                    log.Printf("[snapshot] %v", err)
+                }
+                continue
+            }
+            if st.Committed {
+                log.Printf("[snapshot] commit %s files=%d written=%d removed=%d pushed=%v", st.Commit, st.Files, st.Written, st.Removed, st.Pushed)
+            }
+        }
+    }
+}
+
+// 经 exportHandle 执行，失败按重试策略重来；本地已提交、只是 push 失败时重试只会重新 push
+func (g *gitSnapshotter) export(h *exportHandle) (snapshotStats, error) {
+    var st snapshotStats
+    err := h.do(func() error {
+        var err error
+        st, err = g.run()
+        return err
+    })
+    return st, err
+}
+
+// POST /admin/snapshot 立即做一次快照
+func snapshotAPI(g *gitSnapshotter, h *exportHandle) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        st, err := g.export(h)
+        if err == errExporterDisabled {
+            http.Error(w, err.Error(), 409)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, st)
+    }
+}
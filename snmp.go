@@ -0,0 +1,381 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "math/rand"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// ---------- SNMP v2c client ----------
+
+// 网络设备发现只需要 SNMPv2c 的 Get 和 GetBulk（按子树 walk），协议很小，这里直接实现 BER 编解码，
+// 不引入第三方库。只读，不支持 Set、trap 和 v3。
+
+type snmpOID []uint32
+
+func parseOID(s string) snmpOID {
+    var oid snmpOID
+    for _, p := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+        n, err := strconv.ParseUint(p, 10, 32)
+        if err != nil {
+            panic("snmp: invalid OID " + s)
+        }
+        oid = append(oid, uint32(n))
+    }
+    return oid
+}
+
+func (o snmpOID) String() string {
+    parts := make([]string, len(o))
+    for i, n := range o {
+        parts[i] = strconv.FormatUint(uint64(n), 10)
+    }
+    return strings.Join(parts, ".")
+}
+
+func (o snmpOID) hasPrefix(p snmpOID) bool {
+    if len(o) < len(p) {
+        return false
+    }
+    for i := range p {
+        if o[i] != p[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// 和 p 比较：-1 表示 o 在前
+func (o snmpOID) compare(p snmpOID) int {
+    for i := 0; i < len(o) && i < len(p); i++ {
+        switch {
+        case o[i] < p[i]:
+            return -1
+        case o[i] > p[i]:
+            return 1
+        }
+    }
+    return len(o) - len(p)
+}
+
+const (
+    berInteger     = 0x02
+    berOctetString = 0x04
+    berNull        = 0x05
+    berOIDTag      = 0x06
+    berSequence    = 0x30
+    snmpIPAddress  = 0x40
+    snmpCounter32  = 0x41
+    snmpGauge32    = 0x42
+    snmpTimeTicks  = 0x43
+    snmpCounter64  = 0x46
+    // v2c 的异常值
+    snmpNoSuchObject   = 0x80
+    snmpNoSuchInstance = 0x81
+    snmpEndOfMibView   = 0x82
+
+    snmpGetRequest  = 0xa0
+    snmpGetResponse = 0xa2
+    snmpGetBulk     = 0xa5
+)
+
+type snmpVar struct {
+    OID  snmpOID
+    Type byte
+    // octet string / IpAddress 的原始字节
+    Bytes []byte
+    // 整数类（INTEGER、Counter、Gauge、TimeTicks）
+    Int int64
+    // OID 类型的值，例如 sysObjectID
+    ObjectID snmpOID
+}
+
+// noSuchObject / noSuchInstance / endOfMibView
+func (v snmpVar) missing() bool {
+    return v.Type == snmpNoSuchObject || v.Type == snmpNoSuchInstance || v.Type == snmpEndOfMibView
+}
+
+// 去掉结尾的 NUL，不可打印的换成 ?（有的设备在 sysDescr 里带二进制）
+func (v snmpVar) text() string {
+    s := strings.ToValidUTF8(string(bytes.TrimRight(v.Bytes, "\x00")), "?")
+    return strings.Map(func(r rune) rune {
+        if r == '\n' || r == '\t' || unicode.IsPrint(r) {
+            return r
+        }
+        return '?'
+    }, strings.TrimSpace(s))
+}
+
+func berTLV(tag byte, content []byte) []byte {
+    out := []byte{tag}
+    n := len(content)
+    switch {
+    case n < 0x80:
+        out = append(out, byte(n))
+    default:
+        var l []byte
+        for ; n > 0; n >>= 8 {
+            l = append([]byte{byte(n)}, l...)
+        }
+        out = append(out, 0x80|byte(len(l)))
+        out = append(out, l...)
+    }
+    return append(out, content...)
+}
+
+func berInt(v int64) []byte {
+    var b []byte
+    for {
+        b = append([]byte{byte(v)}, b...)
+        // 剩下的位全是符号位时结束
+        if v >= -128 && v < 128 {
+            break
+        }
+        v >>= 8
+    }
+    return berTLV(berInteger, b)
+}
+
+func berOID(o snmpOID) []byte {
+    if len(o) < 2 {
+        return berTLV(berOIDTag, []byte{0})
+    }
+    b := []byte{byte(o[0]*40 + o[1])}
+    for _, n := range o[2:] {
+        var enc []byte
+        enc = append(enc, byte(n&0x7f))
+        for n >>= 7; n > 0; n >>= 7 {
+            enc = append([]byte{byte(n&0x7f) | 0x80}, enc...)
+        }
+        b = append(b, enc...)
+    }
+    return berTLV(berOIDTag, b)
+}
+
+var errBER = errors.New("snmp: malformed response")
+
+// 读一个 TLV，返回 tag、内容和剩下的字节
+func berNext(b []byte) (byte, []byte, []byte, error) {
+    if len(b) < 2 {
+        return 0, nil, nil, errBER
+    }
+    tag, n, b := b[0], int(b[1]), b[2:]
+    if n&0x80 != 0 {
+        k := n & 0x7f
+        if k == 0 || k > 4 || len(b) < k {
+            return 0, nil, nil, errBER
+        }
+        n = 0
+        for _, c := range b[:k] {
+            n = n<<8 | int(c)
+        }
+        b = b[k:]
+    }
+    if n < 0 || len(b) < n {
+        return 0, nil, nil, errBER
+    }
+    return tag, b[:n], b[n:], nil
+}
+
+func berParseInt(b []byte) int64 {
+    var v int64
+    for i, c := range b {
+        if i == 0 && c&0x80 != 0 {
+            v = -1
+        }
+        v = v<<8 | int64(c)
+    }
+    return v
+}
+
+func berParseOID(b []byte) (snmpOID, error) {
+    if len(b) == 0 {
+        return nil, errBER
+    }
+    oid := snmpOID{uint32(b[0]) / 40, uint32(b[0]) % 40}
+    var n uint32
+    for _, c := range b[1:] {
+        n = n<<7 | uint32(c&0x7f)
+        if c&0x80 == 0 {
+            oid = append(oid, n)
+            n = 0
+        }
+    }
+    return oid, nil
+}
+
+func snmpEncode(community string, pduType byte, reqID int32, a, b int, oids []snmpOID) []byte {
+    var vbs []byte
+    for _, o := range oids {
+        vbs = append(vbs, berTLV(berSequence, append(berOID(o), berNull, 0))...)
+    }
+    var pdu []byte
+    pdu = append(pdu, berInt(int64(reqID))...)
+    pdu = append(pdu, berInt(int64(a))...)
+    pdu = append(pdu, berInt(int64(b))...)
+    pdu = append(pdu, berTLV(berSequence, vbs)...)
+    var msg []byte
+    // version 1 即 v2c
+    msg = append(msg, berInt(1)...)
+    msg = append(msg, berTLV(berOctetString, []byte(community))...)
+    msg = append(msg, berTLV(pduType, pdu)...)
+    return berTLV(berSequence, msg)
+}
+
+type snmpResponse struct {
+    reqID       int32
+    errorStatus int64
+    errorIndex  int64
+    vars        []snmpVar
+}
+
+func snmpDecode(b []byte) (*snmpResponse, error) {
+    tag, msg, _, err := berNext(b)
+    if err != nil || tag != berSequence {
+        return nil, errBER
+    }
+    // version、community
+    for i := 0; i < 2; i++ {
+        if _, _, msg, err = berNext(msg); err != nil {
+            return nil, err
+        }
+    }
+    tag, pdu, _, err := berNext(msg)
+    if err != nil || tag != snmpGetResponse {
+        return nil, errBER
+    }
+    var ints [3]int64
+    for i := range ints {
+        var c []byte
+        if tag, c, pdu, err = berNext(pdu); err != nil || tag != berInteger {
+            return nil, errBER
+        }
+        ints[i] = berParseInt(c)
+    }
+    resp := &snmpResponse{reqID: int32(ints[0]), errorStatus: ints[1], errorIndex: ints[2]}
+    tag, vbs, _, err := berNext(pdu)
+    if err != nil || tag != berSequence {
+        return nil, errBER
+    }
+    for len(vbs) > 0 {
+        var vb, c []byte
+        if tag, vb, vbs, err = berNext(vbs); err != nil || tag != berSequence {
+            return nil, errBER
+        }
+        if tag, c, vb, err = berNext(vb); err != nil || tag != berOIDTag {
+            return nil, errBER
+        }
+        v := snmpVar{}
+        if v.OID, err = berParseOID(c); err != nil {
+            return nil, err
+        }
+        if v.Type, c, _, err = berNext(vb); err != nil {
+            return nil, err
+        }
+        switch v.Type {
+        case berInteger, snmpCounter32, snmpGauge32, snmpTimeTicks, snmpCounter64:
+            v.Int = berParseInt(c)
+            // 无符号类型最高位为 1 时不是负数
+            if v.Type != berInteger && v.Int < 0 && len(c) < 8 {
+                v.Int += 1 << (8 * len(c))
+            }
+        case berOIDTag:
+            v.ObjectID, _ = berParseOID(c)
+        default:
+            v.Bytes = c
+        }
+        resp.vars = append(resp.vars, v)
+    }
+    return resp, nil
+}
+
+type snmpClient struct {
+    conn      net.Conn
+    community string
+    timeout   time.Duration
+    retries   int
+    reqID     int32
+}
+
+func dialSNMP(addr, community string, timeout time.Duration, retries int) (*snmpClient, error) {
+    conn, err := net.Dial("udp", addr)
+    if err != nil {
+        return nil, err
+    }
+    return &snmpClient{conn: conn, community: community, timeout: timeout, retries: retries, reqID: rand.Int31()}, nil
+}
+
+func (c *snmpClient) Close() error { return c.conn.Close() }
+
+// 超时重发；忽略 request id 对不上的迟到响应
+func (c *snmpClient) request(pduType byte, a, b int, oids []snmpOID) (*snmpResponse, error) {
+    c.reqID++
+    msg := snmpEncode(c.community, pduType, c.reqID, a, b, oids)
+    buf := make([]byte, 65535)
+    var lastErr error
+    for attempt := 0; attempt <= c.retries; attempt++ {
+        if _, err := c.conn.Write(msg); err != nil {
+            return nil, err
+        }
+        c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+        for {
+            n, err := c.conn.Read(buf)
+            if err != nil {
+                lastErr = err
+                break
+            }
+            resp, err := snmpDecode(buf[:n])
+            if err != nil {
+                lastErr = err
+                continue
+            }
+            if resp.reqID != c.reqID {
+                continue
+            }
+            if resp.errorStatus != 0 {
+                return nil, fmt.Errorf("snmp: error-status %d at index %d", resp.errorStatus, resp.errorIndex)
+            }
+            return resp, nil
+        }
+        var ne net.Error
+        if !errors.As(lastErr, &ne) || !ne.Timeout() {
+            return nil, lastErr
+        }
+    }
+    return nil, fmt.Errorf("snmp: no response after %d attempts: %w", c.retries+1, lastErr)
+}
+
+func (c *snmpClient) get(oids ...snmpOID) ([]snmpVar, error) {
+    resp, err := c.request(snmpGetRequest, 0, 0, oids)
+    if err != nil {
+        return nil, err
+    }
+    return resp.vars, nil
+}
+
+// GetBulk 逐段取 root 下的子树；设备返回的 OID 不递增时停止，防止死循环
+func (c *snmpClient) walk(root snmpOID, fn func(snmpVar)) error {
+    next := root
+    for {
+        resp, err := c.request(snmpGetBulk, 0, 25, []snmpOID{next})
+        if err != nil {
+            return err
+        }
+        if len(resp.vars) == 0 {
+            return nil
+        }
+        for _, v := range resp.vars {
+            if v.missing() || !v.OID.hasPrefix(root) || v.OID.compare(next) <= 0 {
+                return nil
+            }
+            fn(v)
+            next = v.OID
+        }
+    }
+}
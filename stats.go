@@ -0,0 +1,310 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ---------- Inventory statistics ----------
+
+// 仪表盘要的汇总数字全在这里用 GROUP BY 算好，不用每个客户端把全量 Pod 拉下来自己数。
+// 所有查询在同一个只读快照里，各项之和对得上；限定 namespace 的 key 只统计可见的 Pod，没有 Node 汇总。
+type StatCount struct {
+    Key   string `json:"key"`
+    Count int    `json:"count"`
+}
+
+type PodStats struct {
+    Total int `json:"total"`
+    // 有效 requests 之和，毫核 / 字节
+    CPURequestMilli    int64       `json:"cpuRequestMilli"`
+    MemoryRequestBytes int64       `json:"memoryRequestBytes"`
+    ByNamespace        []StatCount `json:"byNamespace"`
+    ByPhase            []StatCount `json:"byPhase"`
+    // key 为空表示尚未调度
+    ByNode []StatCount `json:"byNode"`
+}
+
+type NodeStats struct {
+    Total               int   `json:"total"`
+    CPUCapacityMilli    int64 `json:"cpuCapacityMilli"`
+    MemoryCapacityBytes int64 `json:"memoryCapacityBytes"`
+}
+
+type ImageCount struct {
+    Image string `json:"image"`
+    Pods  int    `json:"pods"`
+}
+
+type InventoryStats struct {
+    GeneratedAt string    `json:"generatedAt"`
+    Pods        PodStats  `json:"pods"`
+    Nodes       NodeStats `json:"nodes"`
+    // 按使用的 Pod 数从多到少
+    Images []ImageCount `json:"images"`
+    // 每种资源最近一次写库的时间（updated_at 的最大值），表为空时没有这一项
+    LastSync map[string]string `json:"lastSync"`
+    // 库内不一致的行，每条规则一项，没有问题时 count 为 0
+    Consistency []ConsistencyIssue `json:"consistency"`
+    // 按节点的 zone 汇总，见 zones.go；zone 为空是未调度的 Pod 和没有 zone 标签的节点
+    Zones []ZoneStat `json:"zones"`
+    // 集群有多个 zone 时，至少 2 个 Pod 却全在同一个 zone 的 workload
+    SingleZoneWorkloads []SingleZoneWorkload `json:"singleZoneWorkloads"`
+}
+
+type ZoneStat struct {
+    Zone   string `json:"zone"`
+    Region string `json:"region"`
+    // 限定 namespace 的 key 为 0
+    Nodes int `json:"nodes"`
+    Pods  int `json:"pods"`
+}
+
+type SingleZoneWorkload struct {
+    Namespace string `json:"namespace"`
+    Kind      string `json:"kind"`
+    Name      string `json:"name"`
+    Zone      string `json:"zone"`
+    Pods      int64  `json:"pods"`
+}
+
+type ConsistencyIssue struct {
+    Rule  string `json:"rule"`
+    Count int    `json:"count"`
+    // 最多 consistencyExamples 个 namespace/name
+    Examples []string `json:"examples"`
+}
+
+const consistencyExamples = 10
+
+func statCounts(q querier, query string, args ...any) ([]StatCount, error) {
+    rows, err := q.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := []StatCount{}
+    for rows.Next() {
+        var c StatCount
+        if err := rows.Scan(&c.Key, &c.Count); err != nil {
+            return nil, err
+        }
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+// 同一组 images 的 Pod 先在 SQL 里合并，再拆开按镜像累加
+func imageCounts(q querier, where string, args []any) ([]ImageCount, error) {
+    groups, err := statCounts(q, `SELECT coalesce(images,''),count(*) FROM pods`+where+` GROUP BY 1`, args...)
+    if err != nil {
+        return nil, err
+    }
+    byImage := map[string]int{}
+    for _, g := range groups {
+        for _, img := range strings.Split(g.Key, ",") {
+            if img != "" {
+                byImage[img] += g.Count
+            }
+        }
+    }
+    out := make([]ImageCount, 0, len(byImage))
+    for img, n := range byImage {
+        out = append(out, ImageCount{Image: img, Pods: n})
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Pods != out[j].Pods {
+            return out[i].Pods > out[j].Pods
+        }
+        return out[i].Image < out[j].Image
+    })
+    return out, nil
+}
+
+// capacity 存的是 quantity 字符串（"4"、"16318412Ki"），SQL 里没法直接 sum：按取值分组后在这里解析
+func nodeStats(q querier) (NodeStats, error) {
+    var s NodeStats
+    rows, err := q.Query(`SELECT coalesce(capacity_cpu,''),coalesce(capacity_mem,''),count(*) FROM nodes GROUP BY 1,2`)
+    if err != nil {
+        return s, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var cpu, mem string
+        var n int
+        if err := rows.Scan(&cpu, &mem, &n); err != nil {
+            return s, err
+        }
+        s.Total += n
+        if v, err := resource.ParseQuantity(cpu); err == nil {
+            s.CPUCapacityMilli += v.MilliValue() * int64(n)
+        }
+        if v, err := resource.ParseQuantity(mem); err == nil {
+            s.MemoryCapacityBytes += v.Value() * int64(n)
+        }
+    }
+    return s, rows.Err()
+}
+
+func computeStats(q querier, scope nsScope) (*InventoryStats, error) {
+    st := &InventoryStats{GeneratedAt: time.Now().UTC().Format(time.RFC3339), Images: []ImageCount{}, LastSync: map[string]string{}}
+    where, args := scope.where("namespace", "")
+    p := &st.Pods
+    if err := q.QueryRow(`SELECT count(*),coalesce(sum(cpu_request),0),coalesce(sum(mem_request),0) FROM pods`+where, args...).
+        Scan(&p.Total, &p.CPURequestMilli, &p.MemoryRequestBytes); err != nil {
+        return nil, err
+    }
+    var err error
+    for _, g := range []struct {
+        dst *[]StatCount
+        col string
+    }{{&p.ByNamespace, "namespace"}, {&p.ByPhase, "phase"}, {&p.ByNode, "node_name"}} {
+        if *g.dst, err = statCounts(q, `SELECT coalesce(`+g.col+`,''),count(*) FROM pods`+where+` GROUP BY 1 ORDER BY 1`, args...); err != nil {
+            return nil, err
+        }
+    }
+    if st.Images, err = imageCounts(q, where, args); err != nil {
+        return nil, err
+    }
+    if scope == nil {
+        if st.Nodes, err = nodeStats(q); err != nil {
+            return nil, err
+        }
+    }
+    for _, k := range append(append(append(append(append([]syncDiffKind{}, syncDiffKinds...), kubevirtKinds...), argoKinds...), rbacKinds...), certManagerKinds...) {
+        // 集群级资源对受限 key 不可见
+        if k.NS == "" && scope != nil {
+            continue
+        }
+        w, a := scope.where(k.NS, "")
+        if k.NS == "" {
+            w, a = "", nil
+        }
+        var last string
+        if err := q.QueryRow(`SELECT coalesce(max(updated_at),'') FROM `+k.Table+w, a...).Scan(&last); err != nil {
+            return nil, err
+        }
+        if last != "" {
+            st.LastSync[k.Name] = last
+        }
+    }
+    orphans := ConsistencyIssue{Rule: "pod_node_missing", Examples: []string{}}
+    where, args = scope.where("namespace", orphanPodCond)
+    if err := q.QueryRow(`SELECT count(*) FROM pods`+where, args...).Scan(&orphans.Count); err != nil {
+        return nil, err
+    }
+    if orphans.Count > 0 {
+        rows, err := q.Query(`SELECT namespace,name FROM pods`+where+` ORDER BY namespace,name LIMIT ?`, append(args, consistencyExamples)...)
+        if err != nil {
+            return nil, err
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var ns, name string
+            if err := rows.Scan(&ns, &name); err != nil {
+                return nil, err
+            }
+            orphans.Examples = append(orphans.Examples, ns+"/"+name)
+        }
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+    st.Consistency = []ConsistencyIssue{orphans}
+    if st.Zones, st.SingleZoneWorkloads, err = zoneStats(q, scope); err != nil {
+        return nil, err
+    }
+    return st, nil
+}
+
+func zoneStats(q querier, scope nsScope) ([]ZoneStat, []SingleZoneWorkload, error) {
+    byZone := map[[2]string]*ZoneStat{}
+    add := func(query string, args []any, pods bool) error {
+        rows, err := q.Query(query, args...)
+        if err != nil {
+            return err
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var zone, region string
+            var n int
+            if err := rows.Scan(&zone, &region, &n); err != nil {
+                return err
+            }
+            z := byZone[[2]string{zone, region}]
+            if z == nil {
+                z = &ZoneStat{Zone: zone, Region: region}
+                byZone[[2]string{zone, region}] = z
+            }
+            if pods {
+                z.Pods += n
+            } else {
+                z.Nodes += n
+            }
+        }
+        return rows.Err()
+    }
+    where, args := scope.where("p.namespace", "")
+    if err := add(`SELECT coalesce(n.zone,''),coalesce(n.region,''),count(*) FROM pods p LEFT JOIN nodes n ON n.name=p.node_name`+where+` GROUP BY 1,2`,
+        args, true); err != nil {
+        return nil, nil, err
+    }
+    if scope == nil {
+        if err := add(`SELECT coalesce(zone,''),coalesce(region,''),count(*) FROM nodes GROUP BY 1,2`, nil, false); err != nil {
+            return nil, nil, err
+        }
+    }
+    zones := make([]ZoneStat, 0, len(byZone))
+    for _, z := range byZone {
+        zones = append(zones, *z)
+    }
+    sort.Slice(zones, func(i, j int) bool {
+        if zones[i].Region != zones[j].Region {
+            return zones[i].Region < zones[j].Region
+        }
+        return zones[i].Zone < zones[j].Zone
+    })
+    single := []SingleZoneWorkload{}
+    // 只有一个 zone 的集群谈不上跨 zone 冗余
+    var n int
+    if err := q.QueryRow(`SELECT count(DISTINCT zone) FROM nodes WHERE coalesce(zone,'')<>''`).Scan(&n); err != nil || n < 2 {
+        return zones, single, err
+    }
+    workloads, err := computeWorkloads(q, "", scope)
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, w := range workloads {
+        if w.Pods >= 2 && w.Zones != "" && !strings.Contains(w.Zones, ",") {
+            single = append(single, SingleZoneWorkload{Namespace: w.Namespace, Kind: w.Kind, Name: w.Name, Zone: w.Zones, Pods: w.Pods})
+        }
+    }
+    return zones, single, nil
+}
+
+// GET /cmdb/stats
+func statsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        var st *InventoryStats
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            st, err = computeStats(dbFrom(ctx, db), scopeOf(r.Context()))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeJSON(w, r, st)
+    }
+}
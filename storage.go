@@ -0,0 +1,395 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    storagev1 "k8s.io/api/storage/v1"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- PV / PVC / StorageClass ----------
+
+// 存储容量规划用：StorageClass、PV、PVC 同 Pod 等一样经 informer 队列写库，也参加 /admin/diff 和对账。
+// /cmdb/storage 按 StorageClass 或 namespace 汇总 PVC 申请的容量和实际供给的容量（PV 的 capacity），
+// 按 StorageClass 时另外给出 PV 池的情况：总量、未绑定可用的、Released 等着回收的。
+// 需要 persistentvolumes、persistentvolumeclaims、storageclasses 的 list/watch 权限。
+func initStorage(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS storage_classes(
+    name TEXT PRIMARY KEY,
+    provisioner TEXT,
+    reclaim_policy TEXT,
+    binding_mode TEXT,
+    allow_expansion TEXT,
+    is_default TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS persistent_volumes(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    storage_class TEXT,
+    capacity_bytes INTEGER,
+    access_modes TEXT,
+    reclaim_policy TEXT,
+    phase TEXT,
+    claim_namespace TEXT,
+    claim_name TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`, `
+CREATE TABLE IF NOT EXISTS persistent_volume_claims(
+    uid TEXT PRIMARY KEY,
+    name TEXT,
+    namespace TEXT,
+    storage_class TEXT,
+    requested_bytes INTEGER,
+    capacity_bytes INTEGER,
+    access_modes TEXT,
+    phase TEXT,
+    volume_name TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT
+);`,
+        `CREATE INDEX IF NOT EXISTS persistent_volumes_name ON persistent_volumes(name)`,
+        `CREATE INDEX IF NOT EXISTS persistent_volume_claims_ns ON persistent_volume_claims(namespace, name)`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+const defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+func accessModes(modes []corev1.PersistentVolumeAccessMode) string {
+    out := make([]string, len(modes))
+    for i, m := range modes {
+        out[i] = string(m)
+    }
+    return strings.Join(out, ",")
+}
+
+// 单独一个 factory，由调用方 Start、不等它同步：缺这几种资源的权限时只打日志，不挡住其它资源的启动
+func watchStorage(client kubernetes.Interface, transform cache.TransformFunc, syncs *syncQueue, caches *cacheMeter) informers.SharedInformerFactory {
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(transform))
+    for _, k := range []struct {
+        kind string
+        inf  cache.SharedIndexInformer
+    }{
+        {"storageclasses", factory.Storage().V1().StorageClasses().Informer()},
+        {"persistentvolumes", factory.Core().V1().PersistentVolumes().Informer()},
+        {"persistentvolumeclaims", factory.Core().V1().PersistentVolumeClaims().Informer()},
+    } {
+        syncs.add(k.kind, k.inf)
+        caches.add(k.kind, k.inf)
+    }
+    return factory
+}
+
+// storageClassName 为空（未设置或 ""）时记为空串，报表里归到 "" 这一类
+func classOf(name *string) string {
+    if name == nil {
+        return ""
+    }
+    return *name
+}
+
+// 未设置时按 API 的默认值：Delete、Immediate、不允许扩容
+func storageClassPolicies(sc *storagev1.StorageClass) (reclaim, binding string, expand, isDefault bool) {
+    reclaim, binding = string(corev1.PersistentVolumeReclaimDelete), string(storagev1.VolumeBindingImmediate)
+    if sc.ReclaimPolicy != nil {
+        reclaim = string(*sc.ReclaimPolicy)
+    }
+    if sc.VolumeBindingMode != nil {
+        binding = string(*sc.VolumeBindingMode)
+    }
+    expand = sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion
+    return reclaim, binding, expand, sc.Annotations[defaultClassAnnotation] == "true"
+}
+
+func upsertStorageClass(db querier, sc *storagev1.StorageClass) error {
+    if sc == nil {
+        return errors.New("nil storageclass")
+    }
+    reclaim, binding, expand, isDefault := storageClassPolicies(sc)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO storage_classes(name,provisioner,reclaim_policy,binding_mode,allow_expansion,is_default,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(name) DO UPDATE SET
+ provisioner=excluded.provisioner,
+ reclaim_policy=excluded.reclaim_policy,
+ binding_mode=excluded.binding_mode,
+ allow_expansion=excluded.allow_expansion,
+ is_default=excluded.is_default,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("storage_classes"), sc.Name, sc.Provisioner, reclaim, binding, fmt.Sprint(expand),
+        fmt.Sprint(isDefault), flattenLabels(sc.Labels), sc.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "storage_classes", sc.Name, sc.ResourceVersion)
+    return err
+}
+
+func deleteStorageClass(db querier, name string) error {
+    _, err := db.Exec(`DELETE FROM storage_classes WHERE name=?`, name)
+    return err
+}
+
+// Available 的 PV 也可能带着 claimRef（预留给某个 PVC）
+func pvClaim(pv *corev1.PersistentVolume) (ns, name string) {
+    if ref := pv.Spec.ClaimRef; ref != nil {
+        return ref.Namespace, ref.Name
+    }
+    return "", ""
+}
+
+func upsertPersistentVolume(db querier, pv *corev1.PersistentVolume) error {
+    if pv == nil {
+        return errors.New("nil persistentvolume")
+    }
+    claimNS, claimName := pvClaim(pv)
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO persistent_volumes(uid,name,storage_class,capacity_bytes,access_modes,reclaim_policy,phase,claim_namespace,claim_name,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ storage_class=excluded.storage_class,
+ capacity_bytes=excluded.capacity_bytes,
+ access_modes=excluded.access_modes,
+ reclaim_policy=excluded.reclaim_policy,
+ phase=excluded.phase,
+ claim_namespace=excluded.claim_namespace,
+ claim_name=excluded.claim_name,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("persistent_volumes"), string(pv.UID), pv.Name, pv.Spec.StorageClassName, pv.Spec.Capacity.Storage().Value(),
+        accessModes(pv.Spec.AccessModes), string(pv.Spec.PersistentVolumeReclaimPolicy), string(pv.Status.Phase), claimNS, claimName,
+        flattenLabels(pv.Labels), pv.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "persistent_volumes", pv.Name, pv.ResourceVersion)
+    return err
+}
+
+func deletePersistentVolume(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM persistent_volumes WHERE uid=?`, uid)
+    return err
+}
+
+func upsertPersistentVolumeClaim(db querier, pvc *corev1.PersistentVolumeClaim) error {
+    if pvc == nil {
+        return errors.New("nil persistentvolumeclaim")
+    }
+    now := time.Now().Format(time.RFC3339)
+    res, err := db.Exec(`
+INSERT INTO persistent_volume_claims(uid,name,namespace,storage_class,requested_bytes,capacity_bytes,access_modes,phase,volume_name,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(uid) DO UPDATE SET
+ name=excluded.name,
+ namespace=excluded.namespace,
+ storage_class=excluded.storage_class,
+ requested_bytes=excluded.requested_bytes,
+ capacity_bytes=excluded.capacity_bytes,
+ access_modes=excluded.access_modes,
+ phase=excluded.phase,
+ volume_name=excluded.volume_name,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at
+`+notOlderThanStored("persistent_volume_claims"), string(pvc.UID), pvc.Name, pvc.Namespace, classOf(pvc.Spec.StorageClassName),
+        pvc.Spec.Resources.Requests.Storage().Value(), pvc.Status.Capacity.Storage().Value(), accessModes(pvc.Spec.AccessModes),
+        string(pvc.Status.Phase), pvc.Spec.VolumeName, flattenLabels(pvc.Labels), pvc.ResourceVersion, now, now)
+    _, err = upsertApplied(res, err, "persistent_volume_claims", pvc.Namespace+"/"+pvc.Name, pvc.ResourceVersion)
+    return err
+}
+
+func deletePersistentVolumeClaim(db querier, uid string) error {
+    _, err := db.Exec(`DELETE FROM persistent_volume_claims WHERE uid=?`, uid)
+    return err
+}
+
+// ---------- Storage report ----------
+
+type StorageRow struct {
+    // by=namespace 时有值；by=class 时为空
+    Namespace    string `json:"namespace,omitempty"`
+    StorageClass string `json:"storageClass"`
+    // StorageClass 不在库里（已删除，或 PV 是手工建的）时为空
+    Provisioner string `json:"provisioner"`
+    Claims      int64  `json:"claims"`
+    Pending     int64  `json:"pendingClaims"`
+    // PVC 的 spec.resources.requests.storage 之和
+    RequestedBytes int64 `json:"requestedBytes"`
+    // 已绑定的 PVC 的 status.capacity 之和，可能大于申请量（按块对齐、静态 PV 偏大）
+    ProvisionedBytes int64 `json:"provisionedBytes"`
+    // 以下只有 by=class 且 key 不受 namespace 限制时有值：这个 class 的全部 PV
+    Volumes     int64 `json:"volumes"`
+    VolumeBytes int64 `json:"volumeBytes"`
+    // Available（未绑定）的 PV，可直接被新 PVC 用
+    AvailableVolumes int64 `json:"availableVolumes"`
+    AvailableBytes   int64 `json:"availableBytes"`
+    // Released：PVC 已删、PV 还没回收，Retain 策略下要人工处理
+    ReleasedBytes int64 `json:"releasedBytes"`
+}
+
+func computeStorage(q querier, by string, scope nsScope) ([]StorageRow, error) {
+    provisioners := map[string]string{}
+    prows, err := q.Query(`SELECT name,coalesce(provisioner,'') FROM storage_classes`)
+    if err != nil {
+        return nil, err
+    }
+    for prows.Next() {
+        var name, p string
+        if err := prows.Scan(&name, &p); err != nil {
+            prows.Close()
+            return nil, err
+        }
+        provisioners[name] = p
+    }
+    prows.Close()
+    if err := prows.Err(); err != nil {
+        return nil, err
+    }
+    groups := map[[2]string]*StorageRow{}
+    group := func(ns, class string) *StorageRow {
+        key := [2]string{ns, class}
+        g := groups[key]
+        if g == nil {
+            g = &StorageRow{Namespace: ns, StorageClass: class, Provisioner: provisioners[class]}
+            groups[key] = g
+        }
+        return g
+    }
+    where, args := scope.where("namespace", "")
+    rows, err := q.Query(`SELECT coalesce(namespace,''),coalesce(storage_class,''),coalesce(phase,''),
+ coalesce(requested_bytes,0),coalesce(capacity_bytes,0) FROM persistent_volume_claims`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var ns, class, phase string
+        var requested, capacity int64
+        if err := rows.Scan(&ns, &class, &phase, &requested, &capacity); err != nil {
+            return nil, err
+        }
+        if by == "class" {
+            ns = ""
+        }
+        g := group(ns, class)
+        g.Claims++
+        g.RequestedBytes += requested
+        if phase == string(corev1.ClaimBound) {
+            g.ProvisionedBytes += capacity
+        } else {
+            g.Pending++
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    // PV 是集群级对象，限定 namespace 的 key 看不到
+    if by == "class" && scope == nil {
+        vrows, err := q.Query(`SELECT coalesce(storage_class,''),coalesce(phase,''),coalesce(capacity_bytes,0) FROM persistent_volumes`)
+        if err != nil {
+            return nil, err
+        }
+        defer vrows.Close()
+        for vrows.Next() {
+            var class, phase string
+            var capacity int64
+            if err := vrows.Scan(&class, &phase, &capacity); err != nil {
+                return nil, err
+            }
+            g := group("", class)
+            g.Volumes++
+            g.VolumeBytes += capacity
+            switch corev1.PersistentVolumePhase(phase) {
+            case corev1.VolumeAvailable:
+                g.AvailableVolumes++
+                g.AvailableBytes += capacity
+            case corev1.VolumeReleased:
+                g.ReleasedBytes += capacity
+            }
+        }
+        if err := vrows.Err(); err != nil {
+            return nil, err
+        }
+        // 还没有 PV 和 PVC 的 class 也列出来
+        for name := range provisioners {
+            group("", name)
+        }
+    }
+    out := make([]StorageRow, 0, len(groups))
+    for _, g := range groups {
+        out = append(out, *g)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Namespace != out[j].Namespace {
+            return out[i].Namespace < out[j].Namespace
+        }
+        return out[i].StorageClass < out[j].StorageClass
+    })
+    return out, nil
+}
+
+// GET /cmdb/storage?by=class|namespace[&format=csv]
+func storageAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        by := r.URL.Query().Get("by")
+        if by == "" {
+            by = "class"
+        }
+        if by != "class" && by != "namespace" {
+            http.Error(w, "by must be class or namespace", 400)
+            return
+        }
+        var out []StorageRow
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            out, err = computeStorage(dbFrom(ctx, db), by, scopeOf(r.Context()))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "storage-by-"+by, StorageRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, row := range out {
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write storage: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "slices"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "golang.org/x/net/websocket"
+    "k8s.io/apimachinery/pkg/labels"
+)
+
+// ---------- WebSocket subscriptions ----------
+
+// 看板要的是"先给我当前符合条件的全部对象，之后只推变化"。GET /cmdb/subscribe?kind=pod,deployment&ns=&labelSelector=
+// 升级成 WebSocket 后先逐条发 snapshot，再发一条 synced，之后按 changes 表（触发器写入，informer、reconcile、手工修改都在里面）
+// 推 added / modified / deleted。每个连接自己记读到的 change id，每秒查一次新记录：快照和起始 id 在同一个只读事务里取，
+// 中间不会漏也不会重复。过滤在服务端做：对象因为 labels 改了不再匹配时发 deleted，开始匹配时发 added，和 kubectl get -w -l 一样。
+// 限定 namespace 的凭据只看得到自己的 namespace，集群级的 kind 直接 403。
+// 读 changes 和往连接写分开：中间是有界队列，和 node webhook（nodeevents.go）一样，队列满说明客户端读得太慢，
+// 丢掉全部积压换成一个 resync，然后在同一个连接上重发快照和 synced，接着往下推；客户端收到 resync 丢掉本地状态即可。
+// 单条写超过 subscribeWriteTimeout 说明连接已经不通了，这种才断开。
+const (
+    subscribePoll         = time.Second
+    subscribeHeartbeat    = 30 * time.Second
+    subscribeWriteTimeout = 10 * time.Second
+    // 每轮最多读的 changes 条数，积压时下一轮接着读
+    subscribeBatch = 500
+    // 等待发送的事件数，超过就 resync
+    subscribeBuffer  = 4 * subscribeBatch
+    maxSubscriptions = 64
+    // 之前的事件已丢弃，后面紧跟新的 snapshot 和 synced
+    subscribeResync = "resync"
+)
+
+var activeSubscriptions atomic.Int64
+
+// type 为 snapshot / synced / added / modified / deleted / heartbeat / resync / error
+type SubscriptionEvent struct {
+    Type      string `json:"type"`
+    Kind      string `json:"kind,omitempty"`
+    Ref       string `json:"ref,omitempty"`
+    Namespace string `json:"namespace,omitempty"`
+    Name      string `json:"name,omitempty"`
+    // history 的投影列；deleted 为删除前的值
+    Object RawJSON `json:"object,omitempty"`
+    // 这条事件对应的 changes.id；snapshot 为 0，synced / heartbeat 为当前读到的位置
+    ChangeID int64  `json:"changeId,omitempty"`
+    Error    string `json:"error,omitempty"`
+}
+
+type subscription struct {
+    sources []historySource
+    ns      string
+    sel     labels.Selector
+    scope   nsScope
+}
+
+func parseSubscription(r *http.Request) (*subscription, int, error) {
+    q := r.URL.Query()
+    s := &subscription{ns: q.Get("ns"), scope: scopeOf(r.Context())}
+    if q.Get("kind") == "" {
+        return nil, 400, errors.New("kind is required, e.g. kind=pod,deployment")
+    }
+    for _, kind := range strings.Split(q.Get("kind"), ",") {
+        h, ok := historySourceOf(strings.TrimSpace(kind))
+        if !ok {
+            return nil, 400, fmt.Errorf("unknown kind %q", kind)
+        }
+        if h.Namespace == "''" && s.scope != nil {
+            return nil, 403, fmt.Errorf("%s is cluster-scoped and not visible to namespace-scoped credentials", h.Kind)
+        }
+        s.sources = append(s.sources, h)
+    }
+    if s.ns != "" && !s.scope.allows(s.ns) {
+        return nil, 403, fmt.Errorf("namespace %s is outside the credential's scope", s.ns)
+    }
+    sel, err := parseLabelSelector(r)
+    if err != nil {
+        return nil, 400, err
+    }
+    if !sel.Empty() {
+        for _, h := range s.sources {
+            if !slices.Contains(h.Columns, "labels") {
+                return nil, 400, fmt.Errorf("%s has no labels, labelSelector is not supported", h.Kind)
+            }
+        }
+        s.sel = sel
+    }
+    return s, 0, nil
+}
+
+func (s *subscription) kinds() []string {
+    out := make([]string, len(s.sources))
+    for i, h := range s.sources {
+        out[i] = h.Kind
+    }
+    return out
+}
+
+// object 是 history 的投影 JSON，namespace 已经单独取出
+func (s *subscription) matches(ns, object string) bool {
+    if object == "" {
+        return false
+    }
+    if s.ns != "" && ns != s.ns {
+        return false
+    }
+    if !s.scope.allows(ns) {
+        return false
+    }
+    if s.sel == nil {
+        return true
+    }
+    var o struct {
+        Labels string `json:"labels"`
+    }
+    if err := json.Unmarshal([]byte(object), &o); err != nil {
+        return false
+    }
+    return selectorMatchesFlat(s.sel, o.Labels)
+}
+
+// 在同一个只读事务里取当前的 changes 位置，匹配的对象逐条交给 emit，不在内存里攒整张表
+func (s *subscription) snapshot(db *sql.DB, emit func(SubscriptionEvent) error) (last int64, err error) {
+    err = withReadSnapshot(context.Background(), db, func(ctx context.Context) error {
+        last, err = s.snapshotRows(dbFrom(ctx, db), emit)
+        return err
+    })
+    return last, err
+}
+
+// namespace 和 scope 在 SQL 里过滤，和列表接口一样；labelSelector 只能对投影后的 labels 在 Go 里判断
+func (s *subscription) snapshotQuery(h historySource) (string, []any) {
+    ns := "coalesce(" + h.col(h.Namespace, "t") + ",'')"
+    cond, args := "", []any(nil)
+    if s.ns != "" {
+        cond, args = ns+"=?", []any{s.ns}
+    }
+    where, args := s.scope.where(ns, cond, args...)
+    return fmt.Sprintf(`SELECT CAST(t.%s AS TEXT),%s,coalesce(%s,''),%s FROM %s t%s ORDER BY 2,3`,
+        h.Key, ns, h.col(h.Name, "t"), h.plainObject("t"), h.Table, where), args
+}
+
+func (s *subscription) snapshotRows(q querier, emit func(SubscriptionEvent) error) (int64, error) {
+    var last int64
+    if err := q.QueryRow(`SELECT coalesce(max(id),0) FROM changes`).Scan(&last); err != nil {
+        return 0, err
+    }
+    for _, h := range s.sources {
+        query, args := s.snapshotQuery(h)
+        rows, err := q.Query(query, args...)
+        if err != nil {
+            return 0, fmt.Errorf("%s: %w", h.Kind, err)
+        }
+        for rows.Next() {
+            ev := SubscriptionEvent{Type: "snapshot", Kind: h.Kind}
+            var obj string
+            if err := rows.Scan(&ev.Ref, &ev.Namespace, &ev.Name, &obj); err != nil {
+                rows.Close()
+                return 0, err
+            }
+            if !s.matches(ev.Namespace, obj) {
+                continue
+            }
+            ev.Object = RawJSON(obj)
+            if err := emit(ev); err != nil {
+                rows.Close()
+                return 0, err
+            }
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return 0, err
+        }
+    }
+    return last, nil
+}
+
+// 读 after 之后的变更，换算成这个订阅看到的事件；返回读到的最后一个 id
+func (s *subscription) changesSince(db *sql.DB, after int64) (int64, []SubscriptionEvent, error) {
+    b, _ := json.Marshal(s.kinds())
+    rows, err := db.Query(`SELECT id,kind,ref,coalesce(namespace,''),coalesce(name,''),coalesce(cmdb_unseal(before),''),coalesce(cmdb_unseal(after),'')
+ FROM changes WHERE id>? AND kind IN (SELECT value FROM json_each(?)) ORDER BY id LIMIT ?`, after, string(b), subscribeBatch)
+    if err != nil {
+        return after, nil, err
+    }
+    defer rows.Close()
+    var out []SubscriptionEvent
+    for rows.Next() {
+        var ev SubscriptionEvent
+        var before, afterObj string
+        if err := rows.Scan(&ev.ChangeID, &ev.Kind, &ev.Ref, &ev.Namespace, &ev.Name, &before, &afterObj); err != nil {
+            return after, nil, err
+        }
+        after = ev.ChangeID
+        was, is := s.matches(ev.Namespace, before), s.matches(ev.Namespace, afterObj)
+        switch {
+        case is && was:
+            ev.Type, ev.Object = "modified", RawJSON(afterObj)
+        case is:
+            ev.Type, ev.Object = "added", RawJSON(afterObj)
+        case was:
+            ev.Type, ev.Object = "deleted", RawJSON(before)
+        default:
+            continue
+        }
+        out = append(out, ev)
+    }
+    if err := rows.Err(); err != nil {
+        return after, nil, err
+    }
+    return after, out, nil
+}
+
+// GET /cmdb/subscribe（WebSocket）。浏览器用 OIDC 登录后的 cookie 认证；Origin 不是本站时要在 cors.allowedOrigins 里
+func subscribeAPI(db *sql.DB, cors *corsPolicy) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        sub, code, err := parseSubscription(r)
+        if err != nil {
+            http.Error(w, err.Error(), code)
+            return
+        }
+        if activeSubscriptions.Add(1) > maxSubscriptions {
+            activeSubscriptions.Add(-1)
+            http.Error(w, fmt.Sprintf("more than %d subscriptions open, try again later", maxSubscriptions), 503)
+            return
+        }
+        defer activeSubscriptions.Add(-1)
+        srv := websocket.Server{
+            Handshake: func(_ *websocket.Config, r *http.Request) error {
+                return checkSubscribeOrigin(r, cors)
+            },
+            Handler: func(ws *websocket.Conn) {
+                defer ws.Close()
+                if err := sub.serve(db, ws); err != nil {
+                    log.Printf("[subscribe] %s: %v", clientKey(r), err)
+                }
+            },
+        }
+        srv.ServeHTTP(w, r)
+    }
+}
+
+// 没有 Origin（非浏览器客户端）或与 Host 相同时放行，跨站的要在 cors.allowedOrigins 里，防止别的网站借用户的 cookie 订阅
+func checkSubscribeOrigin(r *http.Request, cors *corsPolicy) error {
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return nil
+    }
+    u, err := url.Parse(origin)
+    if err == nil && u.Host == r.Host {
+        return nil
+    }
+    if cors != nil && cors.allowed(origin) {
+        return nil
+    }
+    return fmt.Errorf("origin %s not allowed", origin)
+}
+
+func (s *subscription) serve(db *sql.DB, ws *websocket.Conn) error {
+    // http.Server 的读写期限对升级后的长连接不适用
+    ws.SetDeadline(time.Time{})
+    masker := maskerFrom(ws.Request().Context())
+    send := func(ev SubscriptionEvent) error {
+        ws.SetWriteDeadline(time.Now().Add(subscribeWriteTimeout))
+        return websocket.JSON.Send(ws, masker.mask(ev))
+    }
+    // 客户端不发数据，读只是为了发现连接关闭
+    closed := make(chan struct{})
+    go func() {
+        defer close(closed)
+        var discard []byte
+        for websocket.Message.Receive(ws, &discard) == nil {
+        }
+    }()
+    queue := make(chan SubscriptionEvent, subscribeBuffer)
+    resumed := make(chan int64, 1)
+    failed := make(chan error, 1)
+    stop := make(chan struct{})
+    defer close(stop)
+    go s.poll(db, queue, resumed, failed, stop)
+    // 快照边读边写连接，不经过队列；读到的位置交给 poll 从那里接着读
+    sendSnapshot := func() error {
+        var sendErr error
+        last, err := s.snapshot(db, func(ev SubscriptionEvent) error {
+            sendErr = send(ev)
+            return sendErr
+        })
+        if sendErr != nil {
+            return sendErr
+        }
+        if err != nil {
+            send(SubscriptionEvent{Type: "error", Error: err.Error()})
+            return err
+        }
+        if err := send(SubscriptionEvent{Type: "synced", ChangeID: last}); err != nil {
+            return err
+        }
+        resumed <- last
+        return nil
+    }
+    if err := sendSnapshot(); err != nil {
+        return err
+    }
+    for {
+        select {
+        case <-closed:
+            return nil
+        case err := <-failed:
+            send(SubscriptionEvent{Type: "error", Error: err.Error()})
+            return err
+        case ev := <-queue:
+            if err := send(ev); err != nil {
+                return err
+            }
+            if ev.Type == subscribeResync {
+                if err := sendSnapshot(); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+}
+
+// 每 subscribePoll 读一次 changes 放进 queue，从 resumed 给的位置开始。queue 满了就清空、放一个 resync，
+// 然后停下来等 serve 发完新快照、从 resumed 交回新的位置。只有这里往 queue 里放，清空后一定放得下
+func (s *subscription) poll(db *sql.DB, queue chan SubscriptionEvent, resumed <-chan int64, failed chan<- error, stop <-chan struct{}) {
+    var last int64
+    select {
+    case last = <-resumed:
+    case <-stop:
+        return
+    }
+    offer := func(ev SubscriptionEvent) bool {
+        select {
+        case queue <- ev:
+            return true
+        default:
+            return false
+        }
+    }
+    t := time.NewTicker(subscribePoll)
+    defer t.Stop()
+    idle := time.Now()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+        }
+        next, evs, err := s.changesSince(db, last)
+        if err != nil {
+            failed <- err
+            return
+        }
+        last = next
+        queued := 0
+        for queued < len(evs) && offer(evs[queued]) {
+            queued++
+        }
+        if queued < len(evs) {
+            dropped := len(evs) - queued
+            for drained := false; !drained; {
+                select {
+                case <-queue:
+                    dropped++
+                default:
+                    drained = true
+                }
+            }
+            queue <- SubscriptionEvent{Type: subscribeResync}
+            log.Printf("[subscribe] client too slow, dropped %d queued events, sending resync", dropped)
+            select {
+            case last = <-resumed:
+            case <-stop:
+                return
+            }
+            idle = time.Now()
+            continue
+        }
+        if len(evs) > 0 {
+            idle = time.Now()
+        } else if time.Since(idle) >= subscribeHeartbeat {
+            // 放不进去说明还有没发完的，不需要心跳
+            offer(SubscriptionEvent{Type: "heartbeat", ChangeID: last})
+            idle = time.Now()
+        }
+    }
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "slices"
+    "strings"
+    "testing"
+    "time"
+)
+
+// 客户端跟不上时不断开：积压换成一个 resync，等新的位置交回来之前不再往队列里放
+func TestSubscriptionPollResyncsWhenQueueFull(t *testing.T) {
+    db := newTestDB(t)
+    h, _ := historySourceOf("pod")
+    sub := &subscription{sources: []historySource{h}}
+    for _, name := range []string{"a", "b", "c"} {
+        if _, err := db.Exec(`INSERT INTO pods(uid,name,namespace) VALUES(?,?,'shop')`, "uid-"+name, name); err != nil {
+            t.Fatal(err)
+        }
+    }
+    queue := make(chan SubscriptionEvent, 2)
+    resumed := make(chan int64, 1)
+    failed := make(chan error, 1)
+    stop := make(chan struct{})
+    defer close(stop)
+    resumed <- 0
+    go sub.poll(db, queue, resumed, failed, stop)
+
+    // 不读队列，模拟发不出去的连接；两轮过后应该只剩一个 resync，poll 在等新的位置
+    time.Sleep(2*subscribePoll + 200*time.Millisecond)
+    select {
+    case err := <-failed:
+        t.Fatal(err)
+    default:
+    }
+    if len(queue) != 1 {
+        t.Fatalf("%d events queued, want only the resync", len(queue))
+    }
+    if ev := <-queue; ev.Type != subscribeResync {
+        t.Fatalf("queued event = %+v, want resync", ev)
+    }
+
+    var last int64
+    if err := db.QueryRow(`SELECT max(id) FROM changes`).Scan(&last); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := db.Exec(`INSERT INTO pods(uid,name,namespace) VALUES('uid-d','d','shop')`); err != nil {
+        t.Fatal(err)
+    }
+    resumed <- last
+    select {
+    case ev := <-queue:
+        if ev.Type != "added" || ev.Name != "d" {
+            t.Fatalf("event after resume = %+v, want added d", ev)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("timed out waiting for the change after resume")
+    }
+}
+
+// 快照按 ns 和 scope 在 SQL 里过滤，逐条交出去
+func TestSubscriptionSnapshotFiltersInQuery(t *testing.T) {
+    db := newTestDB(t)
+    h, _ := historySourceOf("pod")
+    for _, p := range [][2]string{{"a", "shop"}, {"b", "shop"}, {"c", "billing"}, {"d", "kube-system"}} {
+        if _, err := db.Exec(`INSERT INTO pods(uid,name,namespace) VALUES(?,?,?)`, "uid-"+p[0], p[0], p[1]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, tc := range []struct {
+        sub  subscription
+        want []string
+    }{
+        {subscription{}, []string{"c", "d", "a", "b"}},
+        {subscription{ns: "shop"}, []string{"a", "b"}},
+        {subscription{scope: nsScope{"shop", "billing"}}, []string{"c", "a", "b"}},
+        {subscription{ns: "billing", scope: nsScope{"shop", "billing"}}, []string{"c"}},
+    } {
+        sub := tc.sub
+        sub.sources = []historySource{h}
+        query, _ := sub.snapshotQuery(h)
+        if (sub.ns != "" || sub.scope != nil) && !strings.Contains(query, " WHERE ") {
+            t.Errorf("snapshot query for ns=%q scope=%v has no WHERE: %s", sub.ns, sub.scope, query)
+        }
+        var got []string
+        if _, err := sub.snapshot(db, func(ev SubscriptionEvent) error {
+            got = append(got, ev.Name)
+            return nil
+        }); err != nil {
+            t.Fatal(err)
+        }
+        if !slices.Equal(got, tc.want) {
+            t.Errorf("snapshot for ns=%q scope=%v = %v, want %v", sub.ns, sub.scope, got, tc.want)
+        }
+    }
+}
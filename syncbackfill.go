@@ -0,0 +1,166 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// ---------- Startup backfill ----------
+
+// 大集群上初始 list 要几分钟才能全部写进库，这期间列表接口返回的是不完整的数据（空库时就是空列表），却是 200。
+// 每个 kind 记下初始 list 里收到的对象（listed）和其中已写库的数量（written，重试用完丢掉的也算处理完），
+// handler 收完初始 list 且这些 key 都处理完之后 backfill 结束。进行中的 kind：
+//   - /cmdb/status 给出 warming、backfillListed / backfillWritten 和按目前速度估计的剩余时间
+//   - 每 10s 打一行进度日志，结束时打一行总数和耗时
+//   - /cmdb/ 下的 GET 响应带 X-LightCMDB-Warming: <kind,...>，/api/v1 的 envelope 里有一条 warning；
+//     server.whileWarming: unavailable 时直接返回 503 和 Retry-After，不返回不完整的数据
+//
+// 暂停的 kind 不算在内。
+const backfillLogInterval = 10 * time.Second
+
+type backfillState struct {
+    // 收到初始 list 的第一个对象
+    started time.Time
+    // handler 收完初始 list
+    handlerSynced func() bool
+    listed        int
+    written       int
+    // 初始 list 里还没写库的 key
+    keys map[string]bool
+    done time.Time
+}
+
+// 调用方持有 q.mu
+func (b *backfillState) finish() bool {
+    if b.done.IsZero() && len(b.keys) == 0 && b.handlerSynced != nil && b.handlerSynced() {
+        b.done = time.Now()
+        return true
+    }
+    return false
+}
+
+// 按目前的写库速度估计剩余时间；初始 list 还没收完时为 0
+func (b *backfillState) eta() time.Duration {
+    if !b.done.IsZero() || b.handlerSynced == nil || !b.handlerSynced() || b.written == 0 {
+        return 0
+    }
+    perObject := time.Since(b.started) / time.Duration(b.written)
+    return (perObject * time.Duration(b.listed-b.written)).Round(time.Second)
+}
+
+func (b *backfillState) progress() string {
+    s := fmt.Sprintf("%d of %d listed objects written", b.written, b.listed)
+    if b.handlerSynced == nil || !b.handlerSynced() {
+        return s + ", still listing"
+    }
+    if eta := b.eta(); eta > 0 {
+        s += fmt.Sprintf(", about %s left", eta)
+    }
+    return s
+}
+
+// 初始 list 的 add 入队时调用
+func (q *syncQueue) backfillListed(it syncItem) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if b := q.backfill[it.kind]; b != nil && b.done.IsZero() && !b.keys[it.key] {
+        if b.listed == 0 {
+            b.started = time.Now()
+        }
+        b.keys[it.key] = true
+        b.listed++
+    }
+}
+
+// 写库成功或放弃重试后调用（持有 q.mu）
+func (q *syncQueue) backfillWrote(it syncItem) {
+    b := q.backfill[it.kind]
+    if b == nil || !b.keys[it.key] {
+        return
+    }
+    delete(b.keys, it.key)
+    b.written++
+    if b.finish() {
+        log.Printf("[%s] backfill done: %d objects in %s", it.kind, b.listed, b.done.Sub(b.started).Round(time.Millisecond))
+    }
+}
+
+// 还在 backfill 的 kind，跳过暂停的（调用方持有 q.mu）
+func (q *syncQueue) warmingLocked() []string {
+    var out []string
+    for _, kind := range q.order {
+        b := q.backfill[kind]
+        if _, paused := q.paused[kind]; b == nil || paused || !b.done.IsZero() {
+            continue
+        }
+        if b.finish() {
+            continue
+        }
+        out = append(out, kind)
+    }
+    return out
+}
+
+func (q *syncQueue) warming() []string {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.warmingLocked()
+}
+
+// syncs.run 旁边起一个；所有 kind 都结束后退出
+func (q *syncQueue) reportBackfill(stop <-chan struct{}) {
+    t := time.NewTicker(backfillLogInterval)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+        }
+        q.mu.Lock()
+        kinds := q.warmingLocked()
+        for _, kind := range kinds {
+            log.Printf("[%s] backfill: %s", kind, q.backfill[kind].progress())
+        }
+        q.mu.Unlock()
+        if len(kinds) == 0 {
+            return
+        }
+    }
+}
+
+// 只有 writer 的 syncQueue 有 backfill；follower 上的快照不经过这一步
+type warmingSource interface {
+    warming() []string
+}
+
+// mode 为 server.whileWarming：serve（默认）照常返回并带上 X-LightCMDB-Warming，unavailable 返回 503。
+// /cmdb/status 要能看进度，不拦；POST /cmdb/batch 是读，和 GET 一样处理
+func warmingGate(mode string, src freshnessSource, next http.Handler) http.Handler {
+    ws, ok := src.(warmingSource)
+    if !ok {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        read := r.Method == http.MethodGet || r.Method == http.MethodPost && r.URL.Path == "/cmdb/batch"
+        if !read || !strings.HasPrefix(r.URL.Path, "/cmdb/") || r.URL.Path == "/cmdb/status" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        kinds := ws.warming()
+        if len(kinds) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+        w.Header().Set("X-LightCMDB-Warming", strings.Join(kinds, ","))
+        if mode == "unavailable" {
+            w.Header().Set("Retry-After", fmt.Sprint(int(backfillLogInterval.Seconds())))
+            http.Error(w, "initial sync in progress ("+strings.Join(kinds, ", ")+"), see /cmdb/status", http.StatusServiceUnavailable)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
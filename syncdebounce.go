@@ -0,0 +1,66 @@
+package main
+
+import (
+    "time"
+
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Debouncing updates ----------
+
+// 每秒都在更新状态的对象（抖动的 Pod、频繁改 annotation 的 controller）每次更新都写一次库、记一条 history。
+// 配置 history.debounce 后，同一对象两次写库至少间隔这么久：窗口内的 update 不立即入队，而是延后到窗口结束时
+// 入队一次。worker 写的总是 informer 缓存里的当前对象，所以最后的状态不会丢，只是中间的版本不再单独记录。
+// add 和 delete 不受限制；history.debounceNamespaces 按 namespace 覆盖窗口，0 表示该 namespace 不限，
+// 集群级对象（Node 等）用默认值。
+
+func (q *syncQueue) setDebounce(window time.Duration, namespaces map[string]Duration) {
+    q.debounce = window
+    q.debounceNS = map[string]time.Duration{}
+    for ns, d := range namespaces {
+        q.debounceNS[ns] = d.Duration
+    }
+}
+
+func (q *syncQueue) debounceFor(key string) time.Duration {
+    ns, _, err := cache.SplitMetaNamespaceKey(key)
+    if err != nil {
+        return 0
+    }
+    if d, ok := q.debounceNS[ns]; ok {
+        return d
+    }
+    return q.debounce
+}
+
+// update 用：距上次写库不到一个窗口时延后入队，重复的延后入队由 workqueue 合并
+func (q *syncQueue) pushDebounced(it syncItem) {
+    window := q.debounceFor(it.key)
+    if window <= 0 {
+        q.push(it)
+        return
+    }
+    q.mu.Lock()
+    wait := window - time.Since(q.lastWrite[it])
+    if wait <= 0 {
+        q.mu.Unlock()
+        q.push(it)
+        return
+    }
+    q.debounced[it.kind]++
+    q.pending[it] = true
+    q.mu.Unlock()
+    q.queue.AddAfter(it, wait)
+}
+
+// 写库成功后调用（持有 q.mu）；对象已经不在缓存里时不再记录，map 不会随删掉的对象增长
+func (q *syncQueue) wrote(it syncItem) {
+    if q.debounce <= 0 && len(q.debounceNS) == 0 {
+        return
+    }
+    if _, exists, _ := q.kinds[it.kind].indexer.GetByKey(it.key); !exists {
+        delete(q.lastWrite, it)
+        return
+    }
+    q.lastWrite[it] = time.Now()
+}
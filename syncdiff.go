@@ -0,0 +1,364 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    appsv1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    storagev1 "k8s.io/api/storage/v1"
+    "k8s.io/apimachinery/pkg/api/meta"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/pager"
+)
+
+// ---------- Live vs CMDB diff ----------
+
+// /admin/diff 绕过 informer 缓存直接向 API server 分页 List，和 DB 行逐条对比，用来验证同步是否可信：
+// missing = API server 有、DB 没有；ghost = DB 有、API server 没有；stale = 都有但写库的字段不一致。
+// 先读 DB 再 List，刚创建的对象（syncDiffGrace 内）不算 missing；对比期间正在变化的对象可能短暂显示为 stale。
+const syncDiffGrace = 30 * time.Second
+
+var errLiveList = errors.New("list from API server")
+
+type syncDiffKind struct {
+    Name  string
+    Table string
+    Key   string
+    // namespace 列，集群级资源为空
+    NS string
+    // 参与对比的列，顺序和 project 返回的值一致
+    Cols    []string
+    list    func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error)
+    project func(o runtime.Object) (key string, vals []string)
+    // 写到 refs 表的引用，不在 Cols 里；跳过无变化的 update 时一起比较
+    refs func(o runtime.Object) []objectRef
+    // upsert 顺带写到其它表的状态（容器重启次数等），同样不在 Cols 里、/admin/diff 不比较，跳过 update 时一起比较
+    extra func(o runtime.Object) []string
+    // 对账修复用：和 informer 回调写的是同一套函数
+    upsert func(q querier, o runtime.Object) error
+    remove func(q querier, key string) error
+}
+
+var syncDiffKinds = []syncDiffKind{
+    {Name: "pods", Table: "pods", Key: "uid", NS: "namespace",
+        Cols: []string{"phase", "node_name", "pod_ip", "labels", "images", "cpu_request", "mem_request", "extended_requests",
+            "owner_kind", "owner_name", "owner_uid", "ready", "arch_pin"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.CoreV1().Pods("").List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            p := o.(*corev1.Pod)
+            cpu, mem := podRequests(p)
+            kind, name, owner := controllerOf(p)
+            return string(p.UID), []string{string(p.Status.Phase), p.Spec.NodeName, p.Status.PodIP, flattenLabels(p.Labels),
+                podImages(p), fmt.Sprint(cpu), fmt.Sprint(mem), podExtendedRequests(p), kind, name, owner, fmt.Sprint(podReady(p)), podArchPin(p.Spec)}
+        },
+        refs:   func(o runtime.Object) []objectRef { return specReferences(o.(*corev1.Pod).Spec) },
+        extra:  func(o runtime.Object) []string { return podRestartCounts(o.(*corev1.Pod)) },
+        upsert: func(q querier, o runtime.Object) error { return upsertPod(q, o.(*corev1.Pod)) },
+        remove: deletePod},
+    {Name: "nodes", Table: "nodes", Key: "name",
+        Cols: []string{"labels", "capacity_cpu", "capacity_mem", "capacity_extended", "allocatable_extended", "internal_ip", "capabilities", "devices",
+            "sriov_count", "gpu_count", "tpu_count", "fpga_count", "provider_id", "ready", "unschedulable",
+            "zone", "region", "architecture", "os", "container_runtime"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.CoreV1().Nodes().List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            n := o.(*corev1.Node)
+            hw := nodeHardwareOf(n)
+            zone, region := nodeZoneOf(n)
+            arch, nodeOS, containerRuntime := nodePlatformOf(n)
+            return n.Name, []string{flattenLabels(n.Labels), n.Status.Capacity.Cpu().String(), n.Status.Capacity.Memory().String(),
+                extendedResourcesOf(n.Status.Capacity), extendedResourcesOf(n.Status.Allocatable), nodeInternalIP(n), hw.Capabilities, hw.Devices, fmt.Sprint(hw.count("sriov")), fmt.Sprint(hw.count("gpu")),
+                fmt.Sprint(hw.count("tpu")), fmt.Sprint(hw.count("fpga")), n.Spec.ProviderID, fmt.Sprint(nodeStateOf(n).ready),
+                fmt.Sprint(n.Spec.Unschedulable), zone, region, arch, nodeOS, containerRuntime}
+        },
+        extra:  func(o runtime.Object) []string { return nodeHealthStatuses(o.(*corev1.Node)) },
+        upsert: func(q querier, o runtime.Object) error { return upsertNode(q, o.(*corev1.Node)) },
+        remove: deleteNode},
+    {Name: "services", Table: "services", Key: "uid", NS: "namespace",
+        Cols: []string{"type", "cluster_ip", "selector", "ports", "labels", "lb_ips", "lb_provider", "lb_pool", "lb_node"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.CoreV1().Services("").List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            s := o.(*corev1.Service)
+            lb := serviceLoadBalancer(s)
+            return string(s.UID), []string{string(s.Spec.Type), s.Spec.ClusterIP, flattenLabels(s.Spec.Selector),
+                servicePorts(s), flattenLabels(s.Labels), lb.IPs, lb.Provider, lb.Pool, lb.Node}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertService(q, o.(*corev1.Service)) },
+        remove: deleteService},
+    {Name: "deployments", Table: "deployments", Key: "uid", NS: "namespace",
+        Cols: []string{"replicas", "ready_replicas", "images", "labels"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.AppsV1().Deployments("").List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            d := o.(*appsv1.Deployment)
+            return string(d.UID), []string{fmt.Sprint(deploymentReplicas(d)), fmt.Sprint(d.Status.ReadyReplicas),
+                containerImages(d.Spec.Template.Spec), flattenLabels(d.Labels)}
+        },
+        refs:   func(o runtime.Object) []objectRef { return specReferences(o.(*appsv1.Deployment).Spec.Template.Spec) },
+        upsert: func(q querier, o runtime.Object) error { return upsertDeployment(q, o.(*appsv1.Deployment)) },
+        remove: deleteDeployment},
+    {Name: "replicasets", Table: "replicasets", Key: "uid", NS: "namespace",
+        Cols: []string{"owner_kind", "owner_name", "owner_uid"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.AppsV1().ReplicaSets("").List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            rs := o.(*appsv1.ReplicaSet)
+            kind, name, uid := controllerOf(rs)
+            return string(rs.UID), []string{kind, name, uid}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertReplicaSet(q, o.(*appsv1.ReplicaSet)) },
+        remove: deleteReplicaSet},
+    {Name: "storageclasses", Table: "storage_classes", Key: "name",
+        Cols: []string{"provisioner", "reclaim_policy", "binding_mode", "allow_expansion", "is_default", "labels"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.StorageV1().StorageClasses().List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            sc := o.(*storagev1.StorageClass)
+            reclaim, binding, expand, isDefault := storageClassPolicies(sc)
+            return sc.Name, []string{sc.Provisioner, reclaim, binding, fmt.Sprint(expand), fmt.Sprint(isDefault), flattenLabels(sc.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertStorageClass(q, o.(*storagev1.StorageClass)) },
+        remove: deleteStorageClass},
+    {Name: "persistentvolumes", Table: "persistent_volumes", Key: "uid",
+        Cols: []string{"storage_class", "capacity_bytes", "access_modes", "reclaim_policy", "phase", "claim_namespace", "claim_name", "labels"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.CoreV1().PersistentVolumes().List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            pv := o.(*corev1.PersistentVolume)
+            claimNS, claimName := pvClaim(pv)
+            return string(pv.UID), []string{pv.Spec.StorageClassName, fmt.Sprint(pv.Spec.Capacity.Storage().Value()), accessModes(pv.Spec.AccessModes),
+                string(pv.Spec.PersistentVolumeReclaimPolicy), string(pv.Status.Phase), claimNS, claimName, flattenLabels(pv.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error {
+            return upsertPersistentVolume(q, o.(*corev1.PersistentVolume))
+        },
+        remove: deletePersistentVolume},
+    {Name: "persistentvolumeclaims", Table: "persistent_volume_claims", Key: "uid", NS: "namespace",
+        Cols: []string{"storage_class", "requested_bytes", "capacity_bytes", "access_modes", "phase", "volume_name", "labels"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.CoreV1().PersistentVolumeClaims("").List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            pvc := o.(*corev1.PersistentVolumeClaim)
+            return string(pvc.UID), []string{classOf(pvc.Spec.StorageClassName), fmt.Sprint(pvc.Spec.Resources.Requests.Storage().Value()),
+                fmt.Sprint(pvc.Status.Capacity.Storage().Value()), accessModes(pvc.Spec.AccessModes), string(pvc.Status.Phase),
+                pvc.Spec.VolumeName, flattenLabels(pvc.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error {
+            return upsertPersistentVolumeClaim(q, o.(*corev1.PersistentVolumeClaim))
+        },
+        remove: deletePersistentVolumeClaim},
+    {Name: "resourcequotas", Table: "resource_quotas", Key: "uid", NS: "namespace",
+        Cols: []string{"scopes", "hard", "used", "labels"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.CoreV1().ResourceQuotas("").List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            q := o.(*corev1.ResourceQuota)
+            return string(q.UID), []string{quotaScopes(q), quantitiesOf(q.Spec.Hard), quantitiesOf(q.Status.Used), flattenLabels(q.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertResourceQuota(q, o.(*corev1.ResourceQuota)) },
+        remove: deleteResourceQuota},
+    {Name: "limitranges", Table: "limit_ranges", Key: "uid", NS: "namespace",
+        Cols: []string{"limits", "labels"},
+        list: func(ctx context.Context, c kubernetes.Interface, opts metav1.ListOptions) (runtime.Object, error) {
+            return c.CoreV1().LimitRanges("").List(ctx, opts)
+        },
+        project: func(o runtime.Object) (string, []string) {
+            lr := o.(*corev1.LimitRange)
+            return string(lr.UID), []string{limitsJSON(lr.Spec.Limits), flattenLabels(lr.Labels)}
+        },
+        upsert: func(q querier, o runtime.Object) error { return upsertLimitRange(q, o.(*corev1.LimitRange)) },
+        remove: deleteLimitRange},
+}
+
+type SyncFieldDiff struct {
+    Field string `json:"field"`
+    Live  string `json:"live"`
+    DB    string `json:"db"`
+}
+
+type SyncDiscrepancy struct {
+    Kind string `json:"kind"`
+    // missing / stale / ghost
+    Type      string          `json:"type"`
+    Key       string          `json:"key"`
+    Namespace string          `json:"namespace,omitempty"`
+    Name      string          `json:"name"`
+    Fields    []SyncFieldDiff `json:"fields,omitempty"`
+
+    // 修复时用：API server 上的对象（ghost 为 nil）和对比时的 DB 值（missing 为 nil）
+    obj    runtime.Object
+    dbVals []string
+}
+
+type SyncDiffCount struct {
+    Live    int `json:"live"`
+    DB      int `json:"db"`
+    Missing int `json:"missing"`
+    Stale   int `json:"stale"`
+    Ghost   int `json:"ghost"`
+}
+
+type SyncDiffReport struct {
+    CheckedAt     string                   `json:"checkedAt"`
+    Counts        map[string]SyncDiffCount `json:"counts"`
+    Discrepancies []SyncDiscrepancy        `json:"discrepancies"`
+}
+
+type syncDBRow struct {
+    ns, name string
+    vals     []string
+}
+
+// key 非空时只读这一行
+func loadSyncDBRows(q querier, k syncDiffKind, key string) (map[string]syncDBRow, error) {
+    ns := "''"
+    if k.NS != "" {
+        ns = "coalesce(" + k.NS + ",'')"
+    }
+    cols := make([]string, len(k.Cols))
+    for i, c := range k.Cols {
+        cols[i] = "CAST(coalesce(cmdb_unseal(" + c + "),'') AS TEXT)"
+    }
+    query := `SELECT ` + k.Key + `,` + ns + `,coalesce(name,''),` + strings.Join(cols, ",") + ` FROM ` + k.Table
+    var args []any
+    if key != "" {
+        query += ` WHERE ` + k.Key + `=?`
+        args = append(args, key)
+    }
+    rows, err := q.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := map[string]syncDBRow{}
+    for rows.Next() {
+        var key string
+        r := syncDBRow{vals: make([]string, len(k.Cols))}
+        dest := []any{&key, &r.ns, &r.name}
+        for i := range r.vals {
+            dest = append(dest, &r.vals[i])
+        }
+        if err := rows.Scan(dest...); err != nil {
+            return nil, err
+        }
+        out[key] = r
+    }
+    return out, rows.Err()
+}
+
+func diffSyncKind(ctx context.Context, db *sql.DB, c kubernetes.Interface, k syncDiffKind, now time.Time) (SyncDiffCount, []SyncDiscrepancy, error) {
+    var cnt SyncDiffCount
+    dbRows, err := loadSyncDBRows(db, k, "")
+    if err != nil {
+        return cnt, nil, err
+    }
+    cnt.DB = len(dbRows)
+    var out []SyncDiscrepancy
+    seen := map[string]bool{}
+    p := pager.New(pager.SimplePageFunc(func(opts metav1.ListOptions) (runtime.Object, error) {
+        return k.list(ctx, c, opts)
+    }))
+    err = p.EachListItem(ctx, metav1.ListOptions{}, func(o runtime.Object) error {
+        m, err := meta.Accessor(o)
+        if err != nil {
+            return err
+        }
+        cnt.Live++
+        key, vals := k.project(o)
+        seen[key] = true
+        d := SyncDiscrepancy{Kind: k.Name, Key: key, Namespace: m.GetNamespace(), Name: m.GetName(), obj: o}
+        row, ok := dbRows[key]
+        if !ok {
+            // informer 还没来得及写库
+            if now.Sub(m.GetCreationTimestamp().Time) < syncDiffGrace {
+                return nil
+            }
+            d.Type = "missing"
+            cnt.Missing++
+            out = append(out, d)
+            return nil
+        }
+        for i, col := range k.Cols {
+            if vals[i] != row.vals[i] {
+                d.Fields = append(d.Fields, SyncFieldDiff{Field: col, Live: vals[i], DB: row.vals[i]})
+            }
+        }
+        if len(d.Fields) > 0 {
+            d.Type, d.dbVals = "stale", row.vals
+            cnt.Stale++
+            out = append(out, d)
+        }
+        return nil
+    })
+    if err != nil {
+        return cnt, nil, fmt.Errorf("%w %s: %v", errLiveList, k.Name, err)
+    }
+    for key, row := range dbRows {
+        if !seen[key] {
+            cnt.Ghost++
+            out = append(out, SyncDiscrepancy{Kind: k.Name, Type: "ghost", Key: key, Namespace: row.ns, Name: row.name, dbVals: row.vals})
+        }
+    }
+    slices.SortFunc(out, func(a, b SyncDiscrepancy) int {
+        return strings.Compare(a.Namespace+"/"+a.Name+"/"+a.Key, b.Namespace+"/"+b.Name+"/"+b.Key)
+    })
+    return cnt, out, nil
+}
+
+// GET /admin/diff?kinds=pods,nodes   不传 kinds 时对比全部
+func syncDiffAPI(db *sql.DB, client kubernetes.Interface) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        kinds := syncDiffKinds
+        if v := r.URL.Query().Get("kinds"); v != "" {
+            kinds = nil
+            for _, name := range strings.Split(v, ",") {
+                i := slices.IndexFunc(syncDiffKinds, func(k syncDiffKind) bool { return k.Name == strings.TrimSpace(name) })
+                if i < 0 {
+                    http.Error(w, fmt.Sprintf("unknown kind %q", name), 400)
+                    return
+                }
+                kinds = append(kinds, syncDiffKinds[i])
+            }
+        }
+        now := time.Now()
+        rep := SyncDiffReport{CheckedAt: now.UTC().Format(time.RFC3339), Counts: map[string]SyncDiffCount{}, Discrepancies: []SyncDiscrepancy{}}
+        for _, k := range kinds {
+            cnt, ds, err := diffSyncKind(r.Context(), db, client, k, now)
+            if errors.Is(err, errLiveList) {
+                http.Error(w, err.Error(), 502)
+                return
+            }
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            rep.Counts[k.Name] = cnt
+            rep.Discrepancies = append(rep.Discrepancies, ds...)
+        }
+        writeJSON(w, r, rep)
+    }
+}
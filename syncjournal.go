@@ -0,0 +1,114 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "time"
+
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Delete journal ----------
+
+// 删除事件只在 informer 回调里出现一次：进程在收到事件和删行之间退出，或者重试用完被丢弃，
+// 库里就会留下 API server 上早已不存在的行，直到对账或 /admin/resync 清掉。
+// 所以删除事件先写进 sync_pending_deletes 再入队；这个 key 同步成功（行已删掉，或同名对象已重建、行已更新）后
+// 才从表里删掉。启动时把表里剩下的条目在对应 kind 的缓存同步后重新入队，缓存里没有的对象按正常流程删行。
+// 重试用完的条目留在表里，下次启动再试；--dry-run 不写主表，也不记日志。
+func initDeleteJournal(db *sql.DB) error {
+    _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS sync_pending_deletes(
+    kind TEXT NOT NULL,
+    key TEXT NOT NULL,
+    -- 最近一次删除事件，unix 纳秒；同一 key 处理期间又来了删除事件时，只清掉处理前记下的那次
+    received_at INTEGER NOT NULL,
+    PRIMARY KEY(kind, key)
+);`)
+    return err
+}
+
+// DeleteFunc 里、入队之前调用
+func (q *syncQueue) journalDelete(it syncItem) {
+    if q.dryRun {
+        return
+    }
+    now := time.Now().UnixNano()
+    if _, err := q.db.Exec(`INSERT INTO sync_pending_deletes(kind,key,received_at) VALUES(?,?,?)
+ ON CONFLICT(kind,key) DO UPDATE SET received_at=excluded.received_at`, it.kind, it.key, now); err != nil {
+        log.Printf("[%s] journal delete %s: %v", it.kind, it.key, err)
+        return
+    }
+    q.mu.Lock()
+    q.journaled[it] = now
+    q.mu.Unlock()
+}
+
+func (q *syncQueue) journaledAt(it syncItem) int64 {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.journaled[it]
+}
+
+// sync 成功后调用，at 是开始同步前 journaledAt 的值
+func (q *syncQueue) clearDelete(it syncItem, at int64) error {
+    if at == 0 {
+        return nil
+    }
+    if _, err := q.db.Exec(`DELETE FROM sync_pending_deletes WHERE kind=? AND key=? AND received_at<=?`, it.kind, it.key, at); err != nil {
+        return err
+    }
+    q.mu.Lock()
+    if q.journaled[it] == at {
+        delete(q.journaled, it)
+    }
+    q.mu.Unlock()
+    return nil
+}
+
+// syncs.run 之前调用：读出上次没处理完的删除，各 kind 的缓存同步后入队。没有注册的 kind（collector 没启用）留在表里
+func (q *syncQueue) replayDeletes(stop <-chan struct{}) error {
+    if q.dryRun {
+        return nil
+    }
+    rows, err := q.db.Query(`SELECT kind,key,received_at FROM sync_pending_deletes ORDER BY received_at`)
+    if err != nil {
+        return err
+    }
+    byKind := map[string][]syncItem{}
+    skipped := map[string]int{}
+    for rows.Next() {
+        var it syncItem
+        var at int64
+        if err := rows.Scan(&it.kind, &it.key, &at); err != nil {
+            rows.Close()
+            return err
+        }
+        if _, ok := q.kinds[it.kind]; !ok {
+            skipped[it.kind]++
+            continue
+        }
+        byKind[it.kind] = append(byKind[it.kind], it)
+        q.mu.Lock()
+        q.journaled[it] = at
+        q.mu.Unlock()
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    for kind, n := range skipped {
+        log.Printf("[%s] %d journaled deletes kept, kind is not watched", kind, n)
+    }
+    for kind, items := range byKind {
+        go func(kind string, items []syncItem) {
+            if !cache.WaitForCacheSync(stop, q.kinds[kind].synced) {
+                return
+            }
+            log.Printf("[%s] replaying %d journaled deletes", kind, len(items))
+            for _, it := range items {
+                q.push(it)
+            }
+        }(kind, items)
+    }
+    return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "fmt"
+
+    corev1 "k8s.io/api/core/v1"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Sync loop ----------
+
+// informer → syncQueue → syncStore → SQLite 的接线和启动顺序，runServe 和集成测试共用：
+// 换成 fake clientset / fake dynamic client 和临时库，不需要 k3s 就能跑和生产一样的同步循环。
+// informer collector 在 newSyncLoop 里注册 kind，要在 start（syncs.run）之前；
+// watch collector（addWatchers）和 periodic collector 在 start 之后 add 到 collectors，立即启动。
+type syncLoop struct {
+    syncs      *syncQueue
+    collectors *collectorRegistry
+    caches     *cacheMeter
+    // 核心资源的 factory，live proxy 和 edge 上报用它的 lister
+    factory informers.SharedInformerFactory
+    // watchCore 里 node webhook 的注册，用来等初始列表
+    nodeReg cache.ResourceEventHandlerRegistration
+}
+
+// 集群没装的 CRD（KubeVirt / Argo CD / cert-manager）和没启用的 collector 不注册
+func newSyncLoop(cfg *Config, client kubernetes.Interface, dyn dynamic.Interface, syncs *syncQueue, observeNode func(string, nodeState)) *syncLoop {
+    // 也可换成 informers.WithNamespace("default") 只看一个 namespace
+    transform := cacheTransform(cfg.LiveProxy.FullObjects)
+    l := &syncLoop{
+        syncs:      syncs,
+        collectors: newCollectorRegistry(),
+        caches:     newCacheMeter(),
+        factory:    informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(transform)),
+    }
+    l.collectors.add(newInformerCollector("kubernetes", syncs, func() informerFactory {
+        l.nodeReg = watchCore(l.factory, syncs, l.caches, observeNode)
+        return l.factory
+    }))
+    l.collectors.add(newInformerCollector("kubevirt", syncs, func() informerFactory {
+        return watchKubeVirt(client, dyn, transform, syncs, l.caches)
+    }))
+    l.collectors.add(newInformerCollector("argocd", syncs, func() informerFactory {
+        return watchArgoCD(client, dyn, transform, syncs, l.caches)
+    }))
+    l.collectors.add(newInformerCollector("certManager", syncs, func() informerFactory {
+        return watchCertManager(client, dyn, transform, syncs, l.caches)
+    }))
+    l.collectors.add(newInformerCollector("storage", syncs, func() informerFactory {
+        return watchStorage(client, transform, syncs, l.caches)
+    }))
+    l.collectors.add(newInformerCollector("quotas", syncs, func() informerFactory {
+        return watchQuotas(client, transform, syncs, l.caches)
+    }))
+    l.collectors.add(newInformerCollector("rbac", syncs, func() informerFactory {
+        return watchRBAC(client, cfg.RBAC, transform, syncs, l.caches)
+    }))
+    l.collectors.add(newInformerCollector("namespaces", syncs, func() informerFactory {
+        return watchNamespaces(client, cfg.Ownership, transform, syncs, l.caches)
+    }))
+    return l
+}
+
+// 单独 watch、直接写自己的表的采集（不经过 syncQueue），按配置启用。start 之后调用：
+// 不等同步，缺权限时只会打日志，不影响启动
+func (l *syncLoop) addWatchers(cfg *Config, client kubernetes.Interface) {
+    db := l.syncs.db
+    if cfg.LoadBalancers.WatchAnnouncements {
+        l.collectors.add(newWatchCollector("loadBalancerAnnouncements", func(stop <-chan struct{}) {
+            watchAnnouncements(db, client, stop)
+        }))
+    }
+    if cfg.Helm.Enabled {
+        l.collectors.add(newWatchCollector("helm", func(stop <-chan struct{}) {
+            watchHelmReleases(db, client, stop)
+        }))
+    }
+    if cfg.Certificates.Enabled {
+        l.collectors.add(newWatchCollector("tlsSecrets", func(stop <-chan struct{}) {
+            watchTLSSecrets(db, client, stop)
+        }))
+    }
+    if cfg.Unused.Enabled {
+        l.collectors.add(newWatchCollector("configObjects", func(stop <-chan struct{}) {
+            watchConfigObjects(db, client, stop)
+        }))
+    }
+    if cfg.Events.Enabled {
+        l.collectors.add(newWatchCollector("events", func(stop <-chan struct{}) {
+            watchEvents(db, client, cfg.Events.Retention.Duration, stop)
+        }))
+    }
+}
+
+// 先把上次没处理完的删除放回队列，再启动 worker 和各 informer；返回时缓存不一定已同步，
+// 只等核心资源时用 factory.WaitForCacheSync
+func (l *syncLoop) start(stop <-chan struct{}) error {
+    if err := l.syncs.replayDeletes(stop); err != nil {
+        return fmt.Errorf("delete journal: %w", err)
+    }
+    go l.syncs.run(stop)
+    go l.syncs.reportBackfill(stop)
+    l.collectors.start(stop)
+    return nil
+}
+
+// pods、nodes、services、deployments、replicasets 注册到 syncs，即 "kubernetes" collector。
+// observeNode 收到每个节点的 Ready 变化（删除时是零值），只给 node webhook 用，返回它的注册用来等初始列表
+func watchCore(factory informers.SharedInformerFactory, syncs *syncQueue, caches *cacheMeter, observeNode func(string, nodeState)) cache.ResourceEventHandlerRegistration {
+    podInformer := factory.Core().V1().Pods().Informer()
+    syncs.add("pods", podInformer)
+    caches.add("pods", podInformer)
+    // Node Informer：写库走队列，这里只给 node webhook 观察 Ready 变化
+    nodeInformer := factory.Core().V1().Nodes().Informer()
+    syncs.add("nodes", nodeInformer)
+    caches.add("nodes", nodeInformer)
+    nodeReg, _ := nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+        AddFunc: func(obj interface{}) {
+            n := obj.(*corev1.Node)
+            observeNode(n.Name, nodeStateOf(n))
+        },
+        UpdateFunc: func(oldObj, newObj interface{}) {
+            n := newObj.(*corev1.Node)
+            observeNode(n.Name, nodeStateOf(n))
+        },
+        DeleteFunc: func(obj interface{}) {
+            switch t := obj.(type) {
+            case *corev1.Node:
+                observeNode(t.Name, nodeState{})
+            case cache.DeletedFinalStateUnknown:
+                if n, ok := t.Obj.(*corev1.Node); ok {
+                    observeNode(n.Name, nodeState{})
+                }
+            }
+        },
+    })
+    // Service / Deployment / ReplicaSet
+    for _, k := range []struct {
+        kind string
+        inf  cache.SharedIndexInformer
+    }{
+        {"services", factory.Core().V1().Services().Informer()},
+        {"deployments", factory.Apps().V1().Deployments().Informer()},
+        {"replicasets", factory.Apps().V1().ReplicaSets().Informer()},
+    } {
+        syncs.add(k.kind, k.inf)
+        caches.add(k.kind, k.inf)
+    }
+    return nodeReg
+}
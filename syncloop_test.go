@@ -0,0 +1,530 @@
+package main
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "math/big"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+
+    appsv1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    rbacv1 "k8s.io/api/rbac/v1"
+    storagev1 "k8s.io/api/storage/v1"
+    "k8s.io/apimachinery/pkg/api/meta"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/apimachinery/pkg/watch"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    "k8s.io/client-go/kubernetes/fake"
+    k8stesting "k8s.io/client-go/testing"
+)
+
+// ---------- Sync loop harness ----------
+
+// 和 runServe 同一套接线（newSyncLoop + start + addWatchers），集群换成 fake clientset / fake dynamic client，
+// 库是 newTestDB 的临时 SQLite。discovery 里 KubeVirt、Argo CD、cert-manager 都在，所有 informer collector 都会注册。
+// 对象经 tracker 增删改；tracker 不维护 resourceVersion，apply 时按次数递增
+type syncHarness struct {
+    t      *testing.T
+    db     *sql.DB
+    cfg    *Config
+    client *fake.Clientset
+    dyn    *dynamicfake.FakeDynamicClient
+    // start 之前设置时用来包一层 store（注入写库失败）
+    wrapStore func(syncStore) syncStore
+
+    syncs *syncQueue
+    loop  *syncLoop
+    stop  chan struct{}
+
+    mu      sync.Mutex
+    watches map[string]int
+    rv      int
+}
+
+var dynamicResources = map[schema.GroupVersionResource]string{
+    vmResource:          "VirtualMachineList",
+    vmiResource:         "VirtualMachineInstanceList",
+    argoAppResource:     "ApplicationList",
+    certManagerResource: "CertificateList",
+}
+
+func newSyncHarness(t *testing.T, cfg *Config) *syncHarness {
+    t.Helper()
+    h := &syncHarness{t: t, db: newTestDB(t), cfg: cfg, client: fake.NewSimpleClientset(),
+        dyn: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), dynamicResources), watches: map[string]int{}}
+    h.client.Resources = []*metav1.APIResourceList{
+        {GroupVersion: kubevirtGroupVersion, APIResources: []metav1.APIResource{{Name: vmResource.Resource}, {Name: vmiResource.Resource}}},
+        {GroupVersion: argoGroupVersion, APIResources: []metav1.APIResource{{Name: argoAppResource.Resource}}},
+        {GroupVersion: certManagerGroupVersion, APIResources: []metav1.APIResource{{Name: certManagerResource.Resource}}},
+    }
+    // 和 fake 默认的 watch reactor 一样，只是多记一次：计数在注册到 tracker 之后，h.watching 等到了就不会漏事件
+    countWatches := func(tracker k8stesting.ObjectTracker) k8stesting.WatchReactionFunc {
+        return func(action k8stesting.Action) (bool, watch.Interface, error) {
+            w, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+            if err == nil {
+                h.mu.Lock()
+                h.watches[action.GetResource().Resource]++
+                h.mu.Unlock()
+            }
+            return true, w, err
+        }
+    }
+    h.client.PrependWatchReactor("*", countWatches(h.client.Tracker()))
+    h.dyn.PrependWatchReactor("*", countWatches(h.dyn.Tracker()))
+    t.Cleanup(h.shutdown)
+    return h
+}
+
+// 启动一个新的 syncQueue 和 syncLoop，等所有 informer collector 同步完；库和集群沿用之前的
+func (h *syncHarness) start() {
+    h.t.Helper()
+    h.mu.Lock()
+    h.watches = map[string]int{}
+    h.mu.Unlock()
+    h.syncs = newSyncQueue(h.db, nil)
+    if h.wrapStore != nil {
+        h.syncs.store = h.wrapStore(h.syncs.store)
+    }
+    h.loop = newSyncLoop(h.cfg, h.client, h.dyn, h.syncs, func(string, nodeState) {})
+    h.stop = make(chan struct{})
+    if err := h.loop.start(h.stop); err != nil {
+        h.t.Fatal(err)
+    }
+    h.loop.addWatchers(h.cfg, h.client)
+    eventually(h.t, "informer collectors synced", func() (bool, error) {
+        for _, st := range h.loop.collectors.statuses() {
+            if st.Type == "informer" && !st.Synced {
+                return false, nil
+            }
+        }
+        return true, nil
+    })
+}
+
+// 模拟进程退出：队列和所有 informer 停下，库里停在当时的状态
+func (h *syncHarness) shutdown() {
+    if h.stop != nil {
+        close(h.stop)
+        h.stop = nil
+    }
+}
+
+// resource 上至少有 n 个 watch（同一种资源可能被几个 collector 各 watch 一次）
+func (h *syncHarness) watching(resource string, n int) {
+    h.t.Helper()
+    eventually(h.t, "watch on "+resource, func() (bool, error) {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        return h.watches[resource] >= n, nil
+    })
+}
+
+func (h *syncHarness) tracker(gvr schema.GroupVersionResource) k8stesting.ObjectTracker {
+    if _, ok := dynamicResources[gvr]; ok {
+        return h.dyn.Tracker()
+    }
+    return h.client.Tracker()
+}
+
+// 新建或更新对象，resourceVersion 每次加一
+func (h *syncHarness) apply(gvr schema.GroupVersionResource, obj runtime.Object) {
+    h.t.Helper()
+    m, err := meta.Accessor(obj)
+    if err != nil {
+        h.t.Fatal(err)
+    }
+    h.mu.Lock()
+    h.rv++
+    m.SetResourceVersion(strconv.Itoa(h.rv))
+    h.mu.Unlock()
+    tracker := h.tracker(gvr)
+    if _, err := tracker.Get(gvr, m.GetNamespace(), m.GetName()); err == nil {
+        err = tracker.Update(gvr, obj, m.GetNamespace())
+    } else {
+        err = tracker.Create(gvr, obj, m.GetNamespace())
+    }
+    if err != nil {
+        h.t.Fatal(err)
+    }
+}
+
+func (h *syncHarness) delete(gvr schema.GroupVersionResource, ns, name string) {
+    h.t.Helper()
+    if err := h.tracker(gvr).Delete(gvr, ns, name); err != nil {
+        h.t.Fatal(err)
+    }
+}
+
+// SELECT count(*) FROM <from> 在 5s 内满足 want
+func (h *syncHarness) rows(what, from string, want func(n int) bool) {
+    h.t.Helper()
+    count := countRows(h.db, `SELECT count(*) FROM `+from)
+    eventually(h.t, what+": "+from, func() (bool, error) {
+        n, err := count()
+        return want(n), err
+    })
+}
+
+func (h *syncHarness) exists(what, from string) {
+    h.t.Helper()
+    h.rows(what, from, func(n int) bool { return n > 0 })
+}
+
+func (h *syncHarness) gone(what, from string) {
+    h.t.Helper()
+    h.rows(what, from, func(n int) bool { return n == 0 })
+}
+
+func harnessConfig() *Config {
+    cfg := &Config{}
+    cfg.RBAC.Enabled = true
+    cfg.Ownership.Annotation = "example.com/team"
+    cfg.LoadBalancers.WatchAnnouncements = true
+    cfg.Helm.Enabled = true
+    cfg.Certificates.Enabled = true
+    cfg.Unused.Enabled = true
+    cfg.Events.Enabled = true
+    cfg.Events.Retention.Duration = 24 * time.Hour
+    return cfg
+}
+
+func objectMeta(ns, name, uid string) metav1.ObjectMeta {
+    return metav1.ObjectMeta{Namespace: ns, Name: name, UID: types.UID(uid), Labels: map[string]string{"app": name}}
+}
+
+func unstructuredObject(apiVersion, kind, ns, name, uid string) *unstructured.Unstructured {
+    u := &unstructured.Unstructured{Object: map[string]any{"apiVersion": apiVersion, "kind": kind}}
+    u.SetNamespace(ns)
+    u.SetName(name)
+    u.SetUID(types.UID(uid))
+    u.SetLabels(map[string]string{"app": name})
+    return u
+}
+
+// 一个对象经 collector 落库的全过程：add 之后 row 有一行，update 之后这行满足 updated，delete 之后没有了
+type collectorCase struct {
+    collector string
+    gvr       schema.GroupVersionResource
+    obj       runtime.Object
+    // FROM 子句，选出这个对象对应的行
+    row string
+    // 默认给对象加一个 rev=2 的 label，row 的 labels 里要有它
+    update  func(obj runtime.Object)
+    updated string
+    // 同一种资源被几个 collector 各 watch 一次
+    watchers int
+    // 事件类的采集不处理删除，行保留到过期
+    keptOnDelete bool
+}
+
+func (c collectorCase) run(t *testing.T, h *syncHarness) {
+    watchers := c.watchers
+    if watchers == 0 {
+        watchers = 1
+    }
+    h.watching(c.gvr.Resource, watchers)
+    m, _ := meta.Accessor(c.obj)
+    h.apply(c.gvr, c.obj)
+    h.exists("add", c.row)
+
+    update, updated := c.update, c.updated
+    if update == nil {
+        update = func(obj runtime.Object) {
+            m, _ := meta.Accessor(obj)
+            labels := m.GetLabels()
+            labels["rev"] = "2"
+            m.SetLabels(labels)
+        }
+        updated = `labels LIKE '%rev=2%'`
+    }
+    obj := c.obj.DeepCopyObject()
+    update(obj)
+    h.apply(c.gvr, obj)
+    h.exists("update", c.row+` AND `+updated)
+
+    h.delete(c.gvr, m.GetNamespace(), m.GetName())
+    if c.keptOnDelete {
+        // 删除事件不入库：等一个 worker 周期后行还在
+        time.Sleep(200 * time.Millisecond)
+        h.exists("kept after delete", c.row)
+        return
+    }
+    h.gone("delete", c.row)
+}
+
+// 每个 informer collector 的每种 kind，以及各 watch collector，都按 add / update / delete 走一遍
+func TestSyncLoopCollectors(t *testing.T) {
+    h := newSyncHarness(t, harnessConfig())
+    h.start()
+    want := []string{"kubernetes", "kubevirt", "argocd", "certManager", "storage", "quotas", "rbac", "namespaces",
+        "loadBalancerAnnouncements", "helm", "tlsSecrets", "configObjects", "events"}
+    for _, name := range want {
+        c := h.loop.collectors.find(name)
+        if c == nil {
+            t.Fatalf("collector %s is not registered", name)
+        }
+        if st := c.Status(); !st.Running {
+            t.Fatalf("collector %s is not running: %+v", name, st)
+        }
+    }
+
+    core, apps, rbac := corev1.SchemeGroupVersion, appsv1.SchemeGroupVersion, rbacv1.SchemeGroupVersion
+    replicas := int32(1)
+    class := "local"
+    controller := true
+    cases := map[string]collectorCase{
+        "kubernetes/pods": {collector: "kubernetes", gvr: core.WithResource("pods"),
+            obj: &corev1.Pod{ObjectMeta: objectMeta("shop", "cart", "uid-pod"),
+                Spec: corev1.PodSpec{NodeName: "n1", Containers: []corev1.Container{{Name: "app", Image: "cart:1"}}}},
+            row: `pods WHERE uid='uid-pod'`},
+        "kubernetes/nodes": {collector: "kubernetes", gvr: core.WithResource("nodes"),
+            obj: &corev1.Node{ObjectMeta: objectMeta("", "n1", "uid-node")},
+            row: `nodes WHERE name='n1'`},
+        "kubernetes/services": {collector: "kubernetes", gvr: core.WithResource("services"),
+            obj: &corev1.Service{ObjectMeta: objectMeta("shop", "cart", "uid-svc"),
+                Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, ClusterIP: "10.96.0.10"}},
+            row: `services WHERE uid='uid-svc'`},
+        "kubernetes/deployments": {collector: "kubernetes", gvr: apps.WithResource("deployments"),
+            obj: &appsv1.Deployment{ObjectMeta: objectMeta("shop", "cart", "uid-deploy"), Spec: appsv1.DeploymentSpec{Replicas: &replicas}},
+            row: `deployments WHERE uid='uid-deploy'`},
+        "kubernetes/replicasets": {collector: "kubernetes", gvr: apps.WithResource("replicasets"),
+            obj: &appsv1.ReplicaSet{ObjectMeta: objectMeta("shop", "cart-1", "uid-rs")},
+            row: `replicasets WHERE uid='uid-rs'`,
+            update: func(obj runtime.Object) {
+                obj.(*appsv1.ReplicaSet).OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "cart",
+                    UID: "uid-deploy", Controller: &controller}}
+            },
+            updated: `owner_kind='Deployment' AND owner_name='cart'`},
+        "storage/storageclasses": {collector: "storage", gvr: storagev1.SchemeGroupVersion.WithResource("storageclasses"),
+            obj: &storagev1.StorageClass{ObjectMeta: objectMeta("", "local", "uid-sc"), Provisioner: "rancher.io/local-path"},
+            row: `storage_classes WHERE name='local'`},
+        "storage/persistentvolumes": {collector: "storage", gvr: core.WithResource("persistentvolumes"),
+            obj: &corev1.PersistentVolume{ObjectMeta: objectMeta("", "pv-1", "uid-pv"), Spec: corev1.PersistentVolumeSpec{StorageClassName: class}},
+            row: `persistent_volumes WHERE uid='uid-pv'`},
+        "storage/persistentvolumeclaims": {collector: "storage", gvr: core.WithResource("persistentvolumeclaims"),
+            obj: &corev1.PersistentVolumeClaim{ObjectMeta: objectMeta("shop", "data", "uid-pvc"), Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &class}},
+            row: `persistent_volume_claims WHERE uid='uid-pvc'`},
+        "quotas/resourcequotas": {collector: "quotas", gvr: core.WithResource("resourcequotas"),
+            obj: &corev1.ResourceQuota{ObjectMeta: objectMeta("shop", "quota", "uid-quota")},
+            row: `resource_quotas WHERE uid='uid-quota'`},
+        "quotas/limitranges": {collector: "quotas", gvr: core.WithResource("limitranges"),
+            obj: &corev1.LimitRange{ObjectMeta: objectMeta("shop", "limits", "uid-limits")},
+            row: `limit_ranges WHERE uid='uid-limits'`},
+        "rbac/serviceaccounts": {collector: "rbac", gvr: core.WithResource("serviceaccounts"),
+            obj: &corev1.ServiceAccount{ObjectMeta: objectMeta("shop", "cart", "uid-sa")},
+            row: `service_accounts WHERE uid='uid-sa'`},
+        "rbac/roles": {collector: "rbac", gvr: rbac.WithResource("roles"),
+            obj: &rbacv1.Role{ObjectMeta: objectMeta("shop", "reader", "uid-role")},
+            row: `roles WHERE uid='uid-role'`},
+        "rbac/clusterroles": {collector: "rbac", gvr: rbac.WithResource("clusterroles"),
+            obj: &rbacv1.ClusterRole{ObjectMeta: objectMeta("", "viewer", "uid-crole")},
+            row: `cluster_roles WHERE uid='uid-crole'`},
+        "rbac/rolebindings": {collector: "rbac", gvr: rbac.WithResource("rolebindings"),
+            obj: &rbacv1.RoleBinding{ObjectMeta: objectMeta("shop", "reader", "uid-rb"),
+                RoleRef: rbacv1.RoleRef{Kind: "Role", Name: "reader"}},
+            row: `role_bindings WHERE uid='uid-rb'`},
+        "rbac/clusterrolebindings": {collector: "rbac", gvr: rbac.WithResource("clusterrolebindings"),
+            obj: &rbacv1.ClusterRoleBinding{ObjectMeta: objectMeta("", "viewer", "uid-crb"),
+                RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "viewer"}},
+            row: `cluster_role_bindings WHERE uid='uid-crb'`},
+        "namespaces/namespaces": {collector: "namespaces", gvr: core.WithResource("namespaces"),
+            obj: &corev1.Namespace{ObjectMeta: objectMeta("", "shop", "uid-ns")},
+            row: `namespaces WHERE name='shop'`,
+            update: func(obj runtime.Object) {
+                obj.(*corev1.Namespace).Annotations = map[string]string{"example.com/team": "payments"}
+            },
+            updated: `team='payments'`},
+        "kubevirt/virtualmachines": {collector: "kubevirt", gvr: vmResource,
+            obj: unstructuredObject("kubevirt.io/v1", "VirtualMachine", "vms", "vm-1", "uid-vm"),
+            row: `virtual_machines WHERE uid='uid-vm'`},
+        "kubevirt/virtualmachineinstances": {collector: "kubevirt", gvr: vmiResource,
+            obj: unstructuredObject("kubevirt.io/v1", "VirtualMachineInstance", "vms", "vm-1", "uid-vmi"),
+            row: `vm_instances WHERE uid='uid-vmi'`},
+        "argocd/applications": {collector: "argocd", gvr: argoAppResource,
+            obj: unstructuredObject("argoproj.io/v1alpha1", "Application", "argocd", "shop", "uid-app"),
+            row: `argo_applications WHERE uid='uid-app'`,
+            update: func(obj runtime.Object) {
+                unstructured.SetNestedField(obj.(*unstructured.Unstructured).Object, "payments", "spec", "project")
+            },
+            updated: `project='payments'`},
+        "certManager/certificates": {collector: "certManager", gvr: certManagerResource,
+            obj: unstructuredObject("cert-manager.io/v1", "Certificate", "shop", "web", "uid-cert"),
+            row: `cert_manager_certificates WHERE uid='uid-cert'`},
+
+        // watch collector：各自的表，不经过 syncQueue；fake clientset 不看 field selector，同一种资源的 watch 都会收到
+        "configObjects/configmaps": {collector: "configObjects", gvr: core.WithResource("configmaps"),
+            obj: &corev1.ConfigMap{ObjectMeta: objectMeta("shop", "cart-config", "uid-cm")},
+            row: `config_objects WHERE kind='ConfigMap' AND namespace='shop' AND name='cart-config'`},
+        "helm/secrets": {collector: "helm", gvr: core.WithResource("secrets"), watchers: 3,
+            obj: helmReleaseSecret("shop", "web", 1),
+            row: `helm_releases WHERE ref='shop/web'`,
+            update: func(obj runtime.Object) {
+                s := obj.(*corev1.Secret)
+                s.Data = helmReleaseSecret("shop", "web", 2).Data
+            },
+            updated: `revision=2`},
+        "tlsSecrets/secrets": {collector: "tlsSecrets", gvr: core.WithResource("secrets"), watchers: 3,
+            obj: tlsSecret(t, "shop", "web-tls", "a.example.com"),
+            row: `tls_secrets WHERE ref='shop/web-tls'`,
+            update: func(obj runtime.Object) {
+                obj.(*corev1.Secret).Data = tlsSecret(t, "shop", "web-tls", "b.example.com").Data
+            },
+            updated: `common_name='b.example.com'`},
+        "events/events": {collector: "events", gvr: core.WithResource("events"), watchers: 2, keptOnDelete: true,
+            obj: &corev1.Event{ObjectMeta: objectMeta("shop", "cart.1", "uid-event"), Reason: "BackOff", Type: "Warning", Count: 1,
+                InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "cart", UID: "uid-pod"}},
+            row:     `events WHERE uid='uid-event'`,
+            update:  func(obj runtime.Object) { obj.(*corev1.Event).Count = 5 },
+            updated: `count=5`},
+        "loadBalancerAnnouncements/events": {collector: "loadBalancerAnnouncements", gvr: core.WithResource("events"), watchers: 2,
+            keptOnDelete: true,
+            obj: &corev1.Event{ObjectMeta: objectMeta("shop", "cart-lb.1", "uid-lb-event"), Reason: "nodeAssigned",
+                Message:        `announcing from node "n1" with protocol "layer2"`,
+                InvolvedObject: corev1.ObjectReference{Kind: "Service", Namespace: "shop", Name: "cart-lb", UID: "uid-lb"}},
+            row: `lb_announcements WHERE service_uid='uid-lb'`,
+            update: func(obj runtime.Object) {
+                obj.(*corev1.Event).Message = `announcing from node "n2" with protocol "layer2"`
+            },
+            updated: `node='n2'`},
+    }
+    for name, c := range cases {
+        t.Run(name, func(t *testing.T) {
+            outer := h.t
+            h.t = t
+            defer func() { h.t = outer }()
+            c.run(t, h)
+        })
+    }
+}
+
+// helm 存 release 的 Secret：release 是 base64 的 JSON（helm 自己还会 gzip，decodeHelmRelease 两种都认）
+func helmReleaseSecret(ns, name string, revision int) *corev1.Secret {
+    rel, _ := json.Marshal(map[string]any{"name": name, "namespace": ns, "version": revision,
+        "info":  map[string]any{"status": "deployed"},
+        "chart": map[string]any{"metadata": map[string]any{"name": name, "version": "1.0.0"}}})
+    return &corev1.Secret{
+        ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "sh.helm.release.v1." + name + ".v1", UID: types.UID("uid-helm-" + name),
+            Labels: map[string]string{"owner": "helm", "name": name}},
+        Type: helmSecretType,
+        Data: map[string][]byte{"release": []byte(base64.StdEncoding.EncodeToString(rel))},
+    }
+}
+
+func tlsSecret(t *testing.T, ns, name, cn string) *corev1.Secret {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl := &x509.Certificate{SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: cn},
+        DNSNames: []string{cn}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &corev1.Secret{
+        ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, UID: types.UID("uid-" + name)},
+        Type:       corev1.SecretTypeTLS,
+        Data:       map[string][]byte{corev1.TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+    }
+}
+
+// 前几次写库失败的 store
+type flakyStore struct {
+    syncStore
+    mu    sync.Mutex
+    fails int
+    // 为 true 时只让删除失败，次数不限
+    deletes bool
+}
+
+var errInjected = errors.New("injected write failure")
+
+func (s *flakyStore) apply(k syncDiffKind, ns, name string, obj runtime.Object) error {
+    s.mu.Lock()
+    fail := s.deletes && obj == nil
+    if !s.deletes && s.fails > 0 {
+        s.fails, fail = s.fails-1, true
+    }
+    s.mu.Unlock()
+    if fail {
+        return errInjected
+    }
+    return s.syncStore.apply(k, ns, name, obj)
+}
+
+// 写库失败按退避重试，重试成功后行照样写进去
+func TestSyncLoopRetriesFailedWrites(t *testing.T) {
+    h := newSyncHarness(t, &Config{})
+    h.wrapStore = func(s syncStore) syncStore { return &flakyStore{syncStore: s, fails: 3} }
+    h.start()
+    h.watching("pods", 1)
+    h.apply(corev1.SchemeGroupVersion.WithResource("pods"), &corev1.Pod{ObjectMeta: objectMeta("shop", "cart", "uid-pod")})
+    h.exists("write after retries", `pods WHERE uid='uid-pod'`)
+    h.syncs.mu.Lock()
+    retries, dropped := h.syncs.retries["pods"], h.syncs.dropped["pods"]
+    h.syncs.mu.Unlock()
+    if retries != 3 || dropped != 0 {
+        t.Fatalf("retries=%d dropped=%d, want 3 and 0", retries, dropped)
+    }
+}
+
+// informer collector 的 Resync：改过的行按缓存写回，缓存里没有的行删掉
+func TestSyncLoopResyncRepairsRows(t *testing.T) {
+    h := newSyncHarness(t, &Config{})
+    h.start()
+    h.watching("pods", 1)
+    h.apply(corev1.SchemeGroupVersion.WithResource("pods"), &corev1.Pod{ObjectMeta: objectMeta("shop", "cart", "uid-pod"),
+        Status: corev1.PodStatus{Phase: corev1.PodRunning}})
+    h.exists("add", `pods WHERE uid='uid-pod' AND phase='Running'`)
+
+    // 绕过 informer 直接改库：一行被改坏，一行是 API server 上没有的 ghost
+    if _, err := h.db.Exec(`UPDATE pods SET phase='Unknown' WHERE uid='uid-pod'`); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := h.db.Exec(`INSERT INTO pods(uid,name,namespace) VALUES('uid-ghost','ghost','shop')`); err != nil {
+        t.Fatal(err)
+    }
+    if err := h.loop.collectors.find("kubernetes").Resync(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    h.exists("repaired", `pods WHERE uid='uid-pod' AND phase='Running'`)
+    h.gone("ghost removed", `pods WHERE uid='uid-ghost'`)
+}
+
+// 删除事件写库失败后进程退出：删除日志里的条目在下次启动时重放，行被删掉、条目清掉
+func TestSyncLoopReplaysJournaledDeletes(t *testing.T) {
+    h := newSyncHarness(t, &Config{})
+    h.wrapStore = func(s syncStore) syncStore { return &flakyStore{syncStore: s, deletes: true} }
+    h.start()
+    pods := corev1.SchemeGroupVersion.WithResource("pods")
+    h.watching("pods", 1)
+    h.apply(pods, &corev1.Pod{ObjectMeta: objectMeta("shop", "cart", "uid-pod")})
+    h.exists("add", `pods WHERE uid='uid-pod'`)
+    h.delete(pods, "shop", "cart")
+    h.exists("journaled delete", `sync_pending_deletes WHERE kind='pods' AND key='shop/cart'`)
+    h.shutdown()
+    if n, err := countRows(h.db, `SELECT count(*) FROM pods WHERE uid='uid-pod'`)(); err != nil || n != 1 {
+        t.Fatalf("pod row before restart: n=%d err=%v, want the row left behind", n, err)
+    }
+
+    h.wrapStore = nil
+    h.start()
+    h.gone("replayed delete", `pods WHERE uid='uid-pod'`)
+    h.gone("journal cleared", `sync_pending_deletes WHERE kind='pods'`)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ---------- Pausing watchers ----------
+
+// 集群维护（滚动升级节点、批量重建）时事件会暴增，写库跟不上也没有意义。/admin/watchers 可以按 kind 暂停同步：
+// informer 照常运行、缓存保持最新，只是事件不再入队，已在队列里的也直接丢掉，库里停在暂停时的状态。
+// 恢复时把 informer 缓存里的全部 key 和库里已有的行都重新入队，期间的新建、修改、删除一次补齐。
+// 一个进程只看一个集群，resource=all 即暂停整个集群。暂停状态只在内存里，重启后全部恢复。
+
+// 暂停中时计数并返回 true
+func (q *syncQueue) ignorePaused(kind string) bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if _, ok := q.paused[kind]; !ok {
+        return false
+    }
+    q.ignored[kind]++
+    return true
+}
+
+func (q *syncQueue) setPaused(kind string, paused bool) error {
+    q.mu.Lock()
+    _, was := q.paused[kind]
+    switch {
+    case paused && !was:
+        q.paused[kind] = time.Now()
+    case !paused && was:
+        delete(q.paused, kind)
+    }
+    q.mu.Unlock()
+    if paused || !was {
+        return nil
+    }
+    return q.requeueAll(kind)
+}
+
+// 缓存里的对象 upsert，库里有而缓存里没有的行在 sync 里删掉
+func (q *syncQueue) requeueAll(kind string) error {
+    k := q.kinds[kind]
+    keys := map[string]bool{}
+    for _, key := range k.indexer.ListKeys() {
+        keys[key] = true
+    }
+    rows, err := loadSyncDBRows(q.db, k.syncDiffKind, "")
+    if err != nil {
+        return err
+    }
+    for _, row := range rows {
+        keys[cacheKey(row.ns, row.name)] = true
+    }
+    for key := range keys {
+        q.push(syncItem{kind: kind, key: key})
+    }
+    log.Printf("[%s] %d keys requeued", kind, len(keys))
+    return nil
+}
+
+type WatcherStatus struct {
+    Kind     string `json:"kind"`
+    Paused   bool   `json:"paused"`
+    PausedAt string `json:"pausedAt,omitempty"`
+    // 启动以来暂停期间丢掉的事件
+    IgnoredEvents int64 `json:"ignoredEvents"`
+}
+
+func (q *syncQueue) watcherStatuses() []WatcherStatus {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    out := make([]WatcherStatus, 0, len(q.order))
+    for _, kind := range q.order {
+        st := WatcherStatus{Kind: kind, IgnoredEvents: q.ignored[kind]}
+        if at, ok := q.paused[kind]; ok {
+            st.Paused, st.PausedAt = true, at.UTC().Format(time.RFC3339)
+        }
+        out = append(out, st)
+    }
+    return out
+}
+
+// GET /admin/watchers
+// POST /admin/watchers?resource=pods,nodes&paused=true   resource=all 为全部 kind
+func watchersAPI(q *syncQueue) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+            writeJSON(w, r, q.watcherStatuses())
+        case http.MethodPost:
+            v := r.URL.Query()
+            paused, err := strconv.ParseBool(v.Get("paused"))
+            if err != nil {
+                http.Error(w, "paused must be true or false", 400)
+                return
+            }
+            kinds := q.order
+            if res := v.Get("resource"); res != "all" {
+                kinds = nil
+                for _, name := range strings.Split(res, ",") {
+                    name = strings.TrimSpace(name)
+                    if _, ok := q.kinds[name]; !ok {
+                        http.Error(w, fmt.Sprintf("unknown resource %q", name), 404)
+                        return
+                    }
+                    kinds = append(kinds, name)
+                }
+            }
+            for _, kind := range kinds {
+                if err := q.setPaused(kind, paused); err != nil {
+                    http.Error(w, err.Error(), 500)
+                    return
+                }
+                log.Printf("[watchers] %s paused=%v", kind, paused)
+            }
+            writeJSON(w, r, q.watcherStatuses())
+        default:
+            http.Error(w, "method not allowed", 405)
+        }
+    }
+}
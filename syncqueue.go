@@ -0,0 +1,483 @@
+package main
+
+import (
+    "database/sql"
+    "log"
+    "slices"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/meta"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/client-go/tools/cache"
+    "k8s.io/client-go/util/workqueue"
+)
+
+// ---------- Informer → DB workqueue ----------
+
+// informer 回调只把 namespace/name 放进限速队列，由单个 worker 写库（SQLite 只有一个连接，多开没有意义）。
+// worker 按 key 从 informer 缓存取当前对象：还在就 upsert，再删掉同名但 uid 不同的旧行（已删除或删除后重建），
+// 所以 add/update/delete 都是同一个幂等操作，队列里合并掉的事件不影响结果。
+// 写库失败按指数退避重试，超过 syncMaxRetries 后放弃并计数，剩下的差异由 /admin/reconcile 兜底。
+// update 的 resourceVersion 没变（resync）或写库的字段都没变（Node 心跳、Pod 状态条件等）时不入队，updated_at 也不会被刷新。
+//
+// 指标按 kind 给出收到的事件数（含跳过和暂停丢掉的）、写库耗时、排队中的 key 数，以及距上次收到事件、
+// 上次写库成功的秒数：事件还在来但写库成功停住是写不进去或跟不上，两个都停住多半是 watch 断了。
+const syncMaxRetries = 15
+
+// 写库耗时的桶（秒）
+var syncLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type syncItem struct {
+    kind string
+    key  string
+}
+
+type syncQueueKind struct {
+    syncDiffKind
+    indexer cache.Indexer
+    synced  cache.InformerSynced
+}
+
+// 队列只管排队、合并、重试和指标，一个 key 怎么落库由 syncStore 决定：正常运行是 tableStore（写主表），
+// --dry-run 是 dryRunStore（只和主表比较，见 shadow.go）。obj 是缓存里的当前对象，缓存里已经没有这个 key 时为 nil；
+// 同一 namespace/name 下 key 不同的旧行（删除后重建）也由 store 处理
+type syncStore interface {
+    apply(k syncDiffKind, ns, name string, obj runtime.Object) error
+}
+
+type syncQueue struct {
+    db    *sql.DB
+    store syncStore
+    queue workqueue.RateLimitingInterface
+    kinds map[string]syncQueueKind
+    // 注册顺序，指标按它输出
+    order []string
+
+    mu      sync.Mutex
+    retries map[string]int64
+    dropped map[string]int64
+    skipped map[string]int64
+    // 暂停的 kind 和暂停时间、暂停期间丢掉的事件，见 syncpause.go
+    paused  map[string]time.Time
+    ignored map[string]int64
+    // --dry-run，见 shadow.go
+    dryRun bool
+    // 同一对象两次写库的最小间隔，见 syncdebounce.go
+    debounce   time.Duration
+    debounceNS map[string]time.Duration
+    lastWrite  map[syncItem]time.Time
+    debounced  map[string]int64
+    // 已写进 sync_pending_deletes 还没处理完的删除，见 syncjournal.go
+    journaled map[syncItem]int64
+    // 启动时的初始 list，见 syncbackfill.go
+    backfill map[string]*backfillState
+    // 按 kind、事件类型（add / update / delete）收到的事件
+    events map[[2]string]int64
+    // 已入队还没被 worker 取走的 key（包括等待重试的）
+    pending     map[syncItem]bool
+    latency     map[string]*histogram
+    lastEvent   map[string]time.Time
+    lastSuccess map[string]time.Time
+    // list / watch 失败，见 syncstatus.go
+    watchErrors    map[string]int64
+    lastWatchError map[string]string
+}
+
+func newSyncQueue(db *sql.DB, hot *hotReadModel) *syncQueue {
+    q := &syncQueue{
+        db:             db,
+        queue:          workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: "lightcmdb"}),
+        kinds:          map[string]syncQueueKind{},
+        retries:        map[string]int64{},
+        dropped:        map[string]int64{},
+        skipped:        map[string]int64{},
+        paused:         map[string]time.Time{},
+        ignored:        map[string]int64{},
+        lastWrite:      map[syncItem]time.Time{},
+        debounced:      map[string]int64{},
+        journaled:      map[syncItem]int64{},
+        backfill:       map[string]*backfillState{},
+        events:         map[[2]string]int64{},
+        pending:        map[syncItem]bool{},
+        latency:        map[string]*histogram{},
+        lastEvent:      map[string]time.Time{},
+        lastSuccess:    map[string]time.Time{},
+        watchErrors:    map[string]int64{},
+        lastWatchError: map[string]string{},
+    }
+    q.store = &tableStore{db: db, hot: hot, nodeRemoved: q.requeueNodePods}
+    return q
+}
+
+// --dry-run：之后的 key 都交给 dryRunStore，主表和删除日志都不写
+func (q *syncQueue) useDryRun(shadow bool) {
+    q.dryRun = true
+    q.store = &dryRunStore{db: q.db, shadow: shadow}
+}
+
+// kind 取 syncDiffKinds 里的名字，upsert / remove 和对账修复共用
+func (q *syncQueue) add(kind string, inf cache.SharedIndexInformer) {
+    i := slices.IndexFunc(syncDiffKinds, func(k syncDiffKind) bool { return k.Name == kind })
+    if i < 0 {
+        panic("syncqueue: unknown kind " + kind)
+    }
+    q.addKind(syncDiffKinds[i], inf)
+}
+
+// 不在 syncDiffKinds 里的资源（KubeVirt 等 CRD）直接传 kind
+func (q *syncQueue) addKind(k syncDiffKind, inf cache.SharedIndexInformer) {
+    kind := k.Name
+    q.kinds[kind] = syncQueueKind{syncDiffKind: k, indexer: inf.GetIndexer(), synced: inf.HasSynced}
+    q.order = append(q.order, kind)
+    q.latency[kind] = newHistogram(syncLatencyBuckets)
+    q.watchErrorsOf(kind, inf)
+    received := func(event string) {
+        q.mu.Lock()
+        q.events[[2]string{kind, event}]++
+        q.lastEvent[kind] = time.Now()
+        q.mu.Unlock()
+    }
+    enqueue := func(obj interface{}, event string) {
+        if q.ignorePaused(kind) {
+            return
+        }
+        // Delete 时 obj 可能是 DeletedFinalStateUnknown
+        key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+        if err != nil {
+            log.Printf("[%s] key: %v", kind, err)
+            return
+        }
+        it := syncItem{kind: kind, key: key}
+        switch event {
+        case "list":
+            q.backfillListed(it)
+        case "update":
+            q.pushDebounced(it)
+            return
+        case "delete":
+            q.journalDelete(it)
+        }
+        q.push(it)
+    }
+    b := &backfillState{keys: map[string]bool{}}
+    q.mu.Lock()
+    q.backfill[kind] = b
+    q.mu.Unlock()
+    reg, err := inf.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+        AddFunc: func(obj interface{}, isInInitialList bool) {
+            received("add")
+            if isInInitialList {
+                enqueue(obj, "list")
+                return
+            }
+            enqueue(obj, "add")
+        },
+        UpdateFunc: func(oldObj, newObj interface{}) {
+            received("update")
+            if unchangedForDB(k, oldObj, newObj) {
+                q.mu.Lock()
+                q.skipped[kind]++
+                q.mu.Unlock()
+                return
+            }
+            enqueue(newObj, "update")
+        },
+        DeleteFunc: func(obj interface{}) {
+            received("delete")
+            enqueue(obj, "delete")
+        },
+    })
+    if err != nil {
+        log.Printf("[%s] add event handler: %v", kind, err)
+        return
+    }
+    q.mu.Lock()
+    b.handlerSynced = reg.HasSynced
+    q.mu.Unlock()
+}
+
+func (q *syncQueue) push(it syncItem) {
+    q.mu.Lock()
+    q.pending[it] = true
+    q.mu.Unlock()
+    q.queue.Add(it)
+}
+
+func unchangedForDB(k syncDiffKind, oldObj, newObj interface{}) bool {
+    o, ok1 := oldObj.(runtime.Object)
+    n, ok2 := newObj.(runtime.Object)
+    if !ok1 || !ok2 {
+        return false
+    }
+    om, err1 := meta.Accessor(o)
+    nm, err2 := meta.Accessor(n)
+    if err1 != nil || err2 != nil || om.GetUID() != nm.GetUID() {
+        return false
+    }
+    if om.GetResourceVersion() == nm.GetResourceVersion() {
+        return true
+    }
+    _, ov := k.project(o)
+    _, nv := k.project(n)
+    if !slices.Equal(ov, nv) {
+        return false
+    }
+    if k.extra != nil && !slices.Equal(k.extra(o), k.extra(n)) {
+        return false
+    }
+    return k.refs == nil || slices.Equal(k.refs(o), k.refs(n))
+}
+
+func (q *syncQueue) sync(it syncItem) error {
+    k := q.kinds[it.kind]
+    obj, exists, err := k.indexer.GetByKey(it.key)
+    if err != nil {
+        return err
+    }
+    ns, name, err := cache.SplitMetaNamespaceKey(it.key)
+    if err != nil {
+        return err
+    }
+    var o runtime.Object
+    if exists {
+        o = obj.(runtime.Object)
+    }
+    return q.store.apply(k.syncDiffKind, ns, name, o)
+}
+
+// 写主表：upsert 当前对象，再删掉同名的旧行
+type tableStore struct {
+    db  *sql.DB
+    hot *hotReadModel
+    // nil 表示没有配置 tagging 规则，见 tags.go
+    tags *tagger
+    // 节点行删掉后把它上面的 pod 重新入队，见 orphans.go
+    nodeRemoved func(name string)
+}
+
+func (s *tableStore) apply(k syncDiffKind, ns, name string, obj runtime.Object) error {
+    keep := ""
+    if obj != nil {
+        m, err := meta.Accessor(obj)
+        if err != nil {
+            return err
+        }
+        if err := k.upsert(s.db, obj); err != nil {
+            return err
+        }
+        keep = m.GetName()
+        if k.Key == "uid" {
+            keep = string(m.GetUID())
+        }
+        if err := s.tags.retag(s.db, k.Table, keep); err != nil {
+            return err
+        }
+        s.hot.refresh(k.Table, keep)
+    }
+    stale, err := staleRowKeys(s.db, k, ns, name, keep)
+    if err != nil {
+        return err
+    }
+    for _, key := range stale {
+        if err := k.remove(s.db, key); err != nil {
+            return err
+        }
+        s.hot.refresh(k.Table, key)
+        log.Printf("[%s/del] %s", k.Name, cacheKey(ns, name))
+    }
+    if obj == nil && k.Name == "nodes" && s.nodeRemoved != nil {
+        s.nodeRemoved(name)
+    }
+    return nil
+}
+
+// 同一 namespace/name 下除 keep 以外的行
+func staleRowKeys(db *sql.DB, k syncDiffKind, ns, name, keep string) ([]string, error) {
+    query, args := `SELECT `+k.Key+` FROM `+k.Table+` WHERE name=? AND `+k.Key+`<>?`, []any{name, keep}
+    if k.NS != "" {
+        query += ` AND coalesce(` + k.NS + `,'')=?`
+        args = append(args, ns)
+    }
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []string
+    for rows.Next() {
+        var key string
+        if err := rows.Scan(&key); err != nil {
+            return nil, err
+        }
+        out = append(out, key)
+    }
+    return out, rows.Err()
+}
+
+func (q *syncQueue) processNext() bool {
+    item, shutdown := q.queue.Get()
+    if shutdown {
+        return false
+    }
+    defer q.queue.Done(item)
+    it := item.(syncItem)
+    q.mu.Lock()
+    delete(q.pending, it)
+    q.mu.Unlock()
+    // 暂停前已入队的也不写，恢复时整体重新入队
+    if q.ignorePaused(it.kind) {
+        q.queue.Forget(item)
+        return true
+    }
+    start := time.Now()
+    journaled := q.journaledAt(it)
+    err := q.sync(it)
+    if err == nil {
+        err = q.clearDelete(it, journaled)
+    }
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.latency[it.kind].observe(time.Since(start).Seconds())
+    if err == nil {
+        q.lastSuccess[it.kind] = time.Now()
+        q.wrote(it)
+        q.backfillWrote(it)
+        q.queue.Forget(item)
+        return true
+    }
+    if n := q.queue.NumRequeues(item); n < syncMaxRetries {
+        log.Printf("[%s] %s err=%v (retry %d)", it.kind, it.key, err, n+1)
+        q.retries[it.kind]++
+        q.pending[it] = true
+        q.queue.AddRateLimited(item)
+        return true
+    }
+    log.Printf("[%s] %s err=%v, giving up after %d retries", it.kind, it.key, err, syncMaxRetries)
+    q.dropped[it.kind]++
+    q.backfillWrote(it)
+    q.queue.Forget(item)
+    return true
+}
+
+func (q *syncQueue) run(stop <-chan struct{}) {
+    go func() {
+        <-stop
+        q.queue.ShutDown()
+    }()
+    for q.processNext() {
+    }
+}
+
+func (q *syncQueue) registerMetrics(m *metricsRegistry) {
+    m.register(metricFamily{Name: "lightcmdb_sync_queue_depth", Type: "gauge",
+        Help: "Informer events waiting to be written to the DB",
+        Collect: func() []metricSample {
+            return []metricSample{{Value: float64(q.queue.Len())}}
+        }})
+    m.register(metricFamily{Name: "lightcmdb_sync_pending_deletes", Type: "gauge",
+        Help: "Delete events in the journal that are not yet applied to the DB, by kind",
+        Collect: func() []metricSample {
+            q.mu.Lock()
+            defer q.mu.Unlock()
+            counts := map[string]int{}
+            for it := range q.journaled {
+                counts[it.kind]++
+            }
+            var out []metricSample
+            for _, kind := range q.order {
+                out = append(out, metricSample{Labels: []metricLabel{{"kind", kind}}, Value: float64(counts[kind])})
+            }
+            return out
+        }})
+    counter := func(name, help string, counts map[string]int64) {
+        m.register(metricFamily{Name: name, Type: "counter", Help: help,
+            Collect: func() []metricSample {
+                q.mu.Lock()
+                defer q.mu.Unlock()
+                var out []metricSample
+                for _, kind := range q.order {
+                    out = append(out, metricSample{Labels: []metricLabel{{"kind", kind}}, Value: float64(counts[kind])})
+                }
+                return out
+            }})
+    }
+    counter("lightcmdb_sync_retries", "DB writes retried after an error, by kind", q.retries)
+    counter("lightcmdb_sync_dropped", "Informer events dropped after exhausting retries, by kind", q.dropped)
+    counter("lightcmdb_sync_skipped", "Informer updates skipped because no stored field changed, by kind", q.skipped)
+    counter("lightcmdb_sync_watch_errors", "Informer list or watch calls that failed, by kind", q.watchErrors)
+    counter("lightcmdb_sync_debounced", "Informer updates delayed by history.debounce, by kind", q.debounced)
+    m.register(metricFamily{Name: "lightcmdb_sync_events", Type: "counter",
+        Help: "Informer events received, by kind and event (add, update, delete)",
+        Collect: func() []metricSample {
+            q.mu.Lock()
+            defer q.mu.Unlock()
+            var out []metricSample
+            for _, kind := range q.order {
+                for _, event := range []string{"add", "update", "delete"} {
+                    out = append(out, metricSample{Labels: []metricLabel{{"kind", kind}, {"event", event}},
+                        Value: float64(q.events[[2]string{kind, event}])})
+                }
+            }
+            return out
+        }})
+    m.register(metricFamily{Name: "lightcmdb_sync_duration_seconds", Type: "histogram",
+        Help: "Time to write one queued key to the DB, by kind",
+        Collect: func() []metricSample {
+            q.mu.Lock()
+            defer q.mu.Unlock()
+            var out []metricSample
+            for _, kind := range q.order {
+                out = append(out, q.latency[kind].samples(metricLabel{"kind", kind})...)
+            }
+            return out
+        }})
+    m.register(metricFamily{Name: "lightcmdb_sync_pending", Type: "gauge",
+        Help: "Keys queued or waiting for a retry, by kind",
+        Collect: func() []metricSample {
+            q.mu.Lock()
+            defer q.mu.Unlock()
+            counts := map[string]int{}
+            for it := range q.pending {
+                counts[it.kind]++
+            }
+            var out []metricSample
+            for _, kind := range q.order {
+                out = append(out, metricSample{Labels: []metricLabel{{"kind", kind}}, Value: float64(counts[kind])})
+            }
+            return out
+        }})
+    // 还没有过事件 / 成功写库的 kind 不输出
+    age := func(name, help string, last map[string]time.Time) {
+        m.register(metricFamily{Name: name, Type: "gauge", Help: help,
+            Collect: func() []metricSample {
+                q.mu.Lock()
+                defer q.mu.Unlock()
+                var out []metricSample
+                for _, kind := range q.order {
+                    if t, ok := last[kind]; ok {
+                        out = append(out, metricSample{Labels: []metricLabel{{"kind", kind}}, Value: time.Since(t).Seconds()})
+                    }
+                }
+                return out
+            }})
+    }
+    age("lightcmdb_sync_seconds_since_last_event", "Seconds since the informer delivered an event, by kind", q.lastEvent)
+    age("lightcmdb_sync_seconds_since_last_success", "Seconds since a queued key was last written to the DB, by kind", q.lastSuccess)
+    counter("lightcmdb_sync_paused_events", "Informer events discarded while the kind was paused, by kind", q.ignored)
+    m.register(metricFamily{Name: "lightcmdb_sync_paused", Type: "gauge",
+        Help: "1 while syncing of the kind is paused through /admin/watchers",
+        Collect: func() []metricSample {
+            q.mu.Lock()
+            defer q.mu.Unlock()
+            var out []metricSample
+            for _, kind := range q.order {
+                v := 0.0
+                if _, paused := q.paused[kind]; paused {
+                    v = 1
+                }
+                out = append(out, metricSample{Labels: []metricLabel{{"kind", kind}}, Value: v})
+            }
+            return out
+        }})
+}
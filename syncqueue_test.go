@@ -0,0 +1,215 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "testing"
+    "time"
+
+    appsv1 "k8s.io/api/apps/v1"
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+    t.Helper()
+    db, err := openDB(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+    if err := initSchema(db); err != nil {
+        t.Fatal(err)
+    }
+    return db
+}
+
+// cond 在 5s 内成立，否则失败
+func eventually(t *testing.T, what string, cond func() (bool, error)) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        ok, err := cond()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if ok {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+}
+
+func countRows(db *sql.DB, query string, args ...any) func() (int, error) {
+    return func() (int, error) {
+        var n int
+        err := db.QueryRow(query, args...).Scan(&n)
+        return n, err
+    }
+}
+
+// informer → syncQueue → DB：只有 restartCount 变了的 update 也要记一条重启
+func TestSyncQueueRecordsRestartOnlyUpdate(t *testing.T) {
+    db := newTestDB(t)
+    pod := &corev1.Pod{
+        ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart", UID: "uid-cart", ResourceVersion: "1"},
+        Spec:       corev1.PodSpec{NodeName: "n1", Containers: []corev1.Container{{Name: "app", Image: "cart:1"}}},
+        Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.7",
+            ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: 3}}},
+    }
+    client := fake.NewSimpleClientset(pod)
+    factory := informers.NewSharedInformerFactory(client, 0)
+    syncs := newSyncQueue(db, nil)
+    syncs.add("pods", factory.Core().V1().Pods().Informer())
+    stop := make(chan struct{})
+    defer close(stop)
+    factory.Start(stop)
+    factory.WaitForCacheSync(stop)
+    go syncs.run(stop)
+
+    restarts := func(want int) func() (bool, error) {
+        return func() (bool, error) {
+            var n int
+            err := db.QueryRow(`SELECT coalesce(max(restarts),-1) FROM pod_restart_samples WHERE uid=? AND container='app'`, "uid-cart").Scan(&n)
+            return n == want, err
+        }
+    }
+    eventually(t, "baseline sample", restarts(3))
+
+    // 同一节点、同一 IP、仍是 Running，只有重启次数变了；fake clientset 不维护 resourceVersion
+    pod = pod.DeepCopy()
+    pod.ResourceVersion = "2"
+    pod.Status.ContainerStatuses[0].RestartCount = 4
+    if _, err := client.CoreV1().Pods("shop").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, "restart sample", restarts(4))
+    n, err := countRows(db, `SELECT count(*) FROM pod_restart_samples WHERE uid=?`, "uid-cart")()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != 2 {
+        t.Fatalf("got %d restart samples, want 2", n)
+    }
+}
+
+// Ready 节点上只有 MemoryPressure 变了的 update 也要写进健康历史
+func TestSyncQueueRecordsNodePressure(t *testing.T) {
+    db := newTestDB(t)
+    node := &corev1.Node{
+        ObjectMeta: metav1.ObjectMeta{Name: "n1", ResourceVersion: "1"},
+        Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+            {Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+            {Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+        }},
+    }
+    client := fake.NewSimpleClientset(node)
+    factory := informers.NewSharedInformerFactory(client, 0)
+    syncs := newSyncQueue(db, nil)
+    syncs.add("nodes", factory.Core().V1().Nodes().Informer())
+    stop := make(chan struct{})
+    defer close(stop)
+    factory.Start(stop)
+    factory.WaitForCacheSync(stop)
+    go syncs.run(stop)
+
+    pressure := func(want string) func() (bool, error) {
+        return func() (bool, error) {
+            var status string
+            err := db.QueryRow(`SELECT coalesce((SELECT status FROM node_health_history WHERE node='n1' AND condition=? ORDER BY id DESC LIMIT 1),'')`,
+                string(corev1.NodeMemoryPressure)).Scan(&status)
+            return status == want, err
+        }
+    }
+    eventually(t, "initial condition", pressure("False"))
+
+    node = node.DeepCopy()
+    node.ResourceVersion = "2"
+    node.Status.Conditions[1].Status = corev1.ConditionTrue
+    if _, err := client.CoreV1().Nodes().UpdateStatus(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, "MemoryPressure transition", pressure("True"))
+}
+
+// newSyncLoop 里 kubernetes collector 的接线：带 transform 的 factory + watchCore + syncs.run，
+// 检查落库的行和触发器写的 changes（create / update / delete）
+func TestWatchCoreSyncsToDB(t *testing.T) {
+    db := newTestDB(t)
+    replicas := int32(2)
+    pod := &corev1.Pod{
+        ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart", UID: "uid-cart", ResourceVersion: "1"},
+        Spec:       corev1.PodSpec{NodeName: "n1", Containers: []corev1.Container{{Name: "app", Image: "cart:1"}}},
+        Status:     corev1.PodStatus{Phase: corev1.PodPending},
+    }
+    client := fake.NewSimpleClientset(
+        &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1", ResourceVersion: "1"},
+            Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}}},
+        pod,
+        &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart", UID: "uid-svc", ResourceVersion: "1"},
+            Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, ClusterIP: "10.96.0.10", Selector: map[string]string{"app": "cart"}}},
+        &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart", UID: "uid-deploy", ResourceVersion: "1"},
+            Spec: appsv1.DeploymentSpec{Replicas: &replicas}},
+    )
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(cacheTransform(false)))
+    syncs := newSyncQueue(db, nil)
+    nodeReady := make(chan bool, 10)
+    watchCore(factory, syncs, newCacheMeter(), func(_ string, s nodeState) { nodeReady <- s.ready })
+    stop := make(chan struct{})
+    defer close(stop)
+    factory.Start(stop)
+    factory.WaitForCacheSync(stop)
+    go syncs.run(stop)
+
+    has := func(query string, args ...any) func() (bool, error) {
+        count := countRows(db, query, args...)
+        return func() (bool, error) {
+            n, err := count()
+            return n > 0, err
+        }
+    }
+    for _, q := range []string{
+        `SELECT count(*) FROM nodes WHERE name='n1' AND ready='true'`,
+        `SELECT count(*) FROM pods WHERE uid='uid-cart' AND phase='Pending' AND node_name='n1'`,
+        `SELECT count(*) FROM services WHERE uid='uid-svc' AND cluster_ip='10.96.0.10'`,
+        `SELECT count(*) FROM deployments WHERE uid='uid-deploy' AND replicas=2`,
+        `SELECT count(*) FROM changes WHERE kind='pod' AND op='create' AND ref='uid-cart' AND source='informer'`,
+        `SELECT count(*) FROM changes WHERE kind='node' AND op='create' AND ref='n1'`,
+        `SELECT count(*) FROM changes WHERE kind='service' AND op='create' AND ref='uid-svc'`,
+        `SELECT count(*) FROM changes WHERE kind='deployment' AND op='create' AND ref='uid-deploy'`,
+    } {
+        eventually(t, q, has(q))
+    }
+    if !<-nodeReady {
+        t.Fatal("observeNode got n1 not ready")
+    }
+
+    ctx := context.Background()
+    pod = pod.DeepCopy()
+    pod.ResourceVersion = "2"
+    pod.Status.Phase = corev1.PodRunning
+    pod.Status.PodIP = "10.0.0.7"
+    if _, err := client.CoreV1().Pods("shop").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, "pod update", has(`SELECT count(*) FROM pods WHERE uid='uid-cart' AND phase='Running' AND pod_ip='10.0.0.7'`))
+    eventually(t, "pod update change", has(`SELECT count(*) FROM changes WHERE kind='pod' AND op='update' AND ref='uid-cart'
+ AND json_extract(before,'$.phase')='Pending' AND json_extract(after,'$.phase')='Running'`))
+
+    if err := client.CoreV1().Pods("shop").Delete(ctx, "cart", metav1.DeleteOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    eventually(t, "pod delete change", has(`SELECT count(*) FROM changes WHERE kind='pod' AND op='delete' AND ref='uid-cart'`))
+    n, err := countRows(db, `SELECT count(*) FROM pods WHERE uid='uid-cart'`)()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != 0 {
+        t.Fatalf("pod row still present after delete")
+    }
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "time"
+
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Sync status ----------
+
+// /cmdb/status 按 kind 给出这份数据新不新：informer 缓存是否已同步、最后收到事件和最后写库成功的时间、
+// 最后一次对账（定时 drift repair 或 /admin/resync）完成的时间、当前行数，以及重试、丢弃、list / watch 失败次数。
+// 一个进程只同步一个集群，cluster 取 federation.site，没配置时为空。
+// 集群级资源对受限 key 不可见，和 /cmdb/stats 一致。
+// follower 不同步集群，给出的是 writer 导出快照那一刻的状态（sync_status 随快照过来），
+// 加上快照的时间和落后了多久；rows 按 follower 本地的库数。
+
+type SyncStatus struct {
+    Cluster     string `json:"cluster"`
+    Kind        string `json:"kind"`
+    CacheSynced bool   `json:"cacheSynced"`
+    Paused      bool   `json:"paused"`
+    Rows        int64  `json:"rows"`
+    // 已入队还没写完的 key
+    Pending       int    `json:"pending"`
+    LastEvent     string `json:"lastEvent,omitempty"`
+    LastSuccess   string `json:"lastSuccess,omitempty"`
+    LastReconcile string `json:"lastReconcile,omitempty"`
+    // 启动以来
+    Retries        int64  `json:"retries"`
+    Dropped        int64  `json:"dropped"`
+    WatchErrors    int64  `json:"watchErrors"`
+    LastWatchError string `json:"lastWatchError,omitempty"`
+    // 初始 list 的进度，见 syncbackfill.go；backfillEta 按目前的写库速度估计
+    Warming         bool   `json:"warming"`
+    BackfillListed  int    `json:"backfillListed"`
+    BackfillWritten int    `json:"backfillWritten"`
+    BackfillETA     string `json:"backfillEta,omitempty"`
+    BackfillDone    string `json:"backfillDone,omitempty"`
+    // 只在 follower 上有：这份数据在 writer 上导出的时间和距今多久
+    SnapshotTaken string `json:"snapshotTaken,omitempty"`
+    ReplicaLag    string `json:"replicaLag,omitempty"`
+}
+
+// 表名和 namespace 列随状态一起存，CRD 类型不在 syncDiffKinds 里，follower 也能数行数
+func initSyncStatus(db *sql.DB) error {
+    _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS sync_status(
+    kind TEXT PRIMARY KEY,
+    tbl TEXT NOT NULL,
+    ns TEXT NOT NULL,
+    status TEXT NOT NULL,
+    pos INTEGER NOT NULL
+);`)
+    return err
+}
+
+// 一个 kind 的状态和数行数要用的表
+type syncStatusKind struct {
+    SyncStatus
+    table, ns string
+}
+
+// 导出快照前调用，整表替换
+func (q *syncQueue) saveStatuses(db *sql.DB, site string, rec *reconciler) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if _, err := tx.Exec(`DELETE FROM sync_status`); err != nil {
+        return err
+    }
+    for i, st := range q.statuses(site, rec) {
+        k := q.kinds[st.Kind]
+        b, _ := json.Marshal(st)
+        if _, err := tx.Exec(`INSERT INTO sync_status(kind,tbl,ns,status,pos) VALUES(?,?,?,?,?)`, st.Kind, k.Table, k.NS, string(b), i); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// 在 informer 启动前调用；原来的处理（打日志、退避重连）不变
+func (q *syncQueue) watchErrorsOf(kind string, inf cache.SharedIndexInformer) {
+    err := inf.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+        // watch 正常断开
+        if !errors.Is(err, io.EOF) {
+            q.mu.Lock()
+            q.watchErrors[kind]++
+            q.lastWatchError[kind] = err.Error()
+            q.mu.Unlock()
+        }
+        cache.DefaultWatchErrorHandler(r, err)
+    })
+    if err != nil {
+        log.Printf("[%s] watch error handler: %v", kind, err)
+    }
+}
+
+func (q *syncQueue) statuses(site string, rec *reconciler) []SyncStatus {
+    pending := map[string]int{}
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    for it := range q.pending {
+        pending[it.kind]++
+    }
+    warming := map[string]bool{}
+    for _, kind := range q.warmingLocked() {
+        warming[kind] = true
+    }
+    out := make([]SyncStatus, 0, len(q.order))
+    for _, kind := range q.order {
+        st := SyncStatus{Cluster: site, Kind: kind, CacheSynced: q.kinds[kind].synced(), Pending: pending[kind],
+            Retries: q.retries[kind], Dropped: q.dropped[kind], WatchErrors: q.watchErrors[kind], LastWatchError: q.lastWatchError[kind]}
+        _, st.Paused = q.paused[kind]
+        st.LastEvent = statusTime(q.lastEvent[kind])
+        st.LastSuccess = statusTime(q.lastSuccess[kind])
+        if t, ok := rec.lastReconciled(kind); ok {
+            st.LastReconcile = statusTime(t)
+        }
+        if b := q.backfill[kind]; b != nil {
+            st.Warming = warming[kind]
+            st.BackfillListed, st.BackfillWritten = b.listed, b.written
+            if eta := b.eta(); eta > 0 {
+                st.BackfillETA = eta.String()
+            }
+            st.BackfillDone = statusTime(b.done)
+        }
+        out = append(out, st)
+    }
+    return out
+}
+
+func statusTime(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339)
+}
+
+// GET /cmdb/status[?format=csv]
+func syncStatusAPI(db *sql.DB, q *syncQueue, rec *reconciler, site string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        var kinds []syncStatusKind
+        for _, st := range q.statuses(site, rec) {
+            k := q.kinds[st.Kind]
+            kinds = append(kinds, syncStatusKind{SyncStatus: st, table: k.Table, ns: k.NS})
+        }
+        writeSyncStatuses(w, r, db, kinds)
+    }
+}
+
+// follower 上的 GET /cmdb/status[?format=csv]；还没拉到快照时为空列表
+func followerSyncStatusAPI(db *sql.DB, f *replicaFollower) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        rows, err := dbFrom(r.Context(), db).Query(`SELECT tbl,ns,status FROM sync_status ORDER BY pos`)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        var kinds []syncStatusKind
+        taken := f.snapshotTaken()
+        for rows.Next() {
+            var k syncStatusKind
+            var status string
+            if err := rows.Scan(&k.table, &k.ns, &status); err != nil {
+                rows.Close()
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            if err := json.Unmarshal([]byte(status), &k.SyncStatus); err != nil {
+                rows.Close()
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            if !taken.IsZero() {
+                k.SnapshotTaken = statusTime(taken)
+                k.ReplicaLag = time.Since(taken).Round(time.Second).String()
+            }
+            kinds = append(kinds, k)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        writeSyncStatuses(w, r, db, kinds)
+    }
+}
+
+// 受限 key 看不到集群级的 kind，行数只数它的 namespace
+func writeSyncStatuses(w http.ResponseWriter, r *http.Request, db *sql.DB, kinds []syncStatusKind) {
+    scope := scopeOf(r.Context())
+    var list []SyncStatus
+    for _, k := range kinds {
+        if k.ns == "" && scope != nil {
+            continue
+        }
+        where, args := "", []any(nil)
+        if k.ns != "" {
+            where, args = scope.where(k.ns, "")
+        }
+        st := k.SyncStatus
+        if err := dbFrom(r.Context(), db).QueryRow(`SELECT count(*) FROM `+k.table+where, args...).Scan(&st.Rows); err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        list = append(list, st)
+    }
+    lw, err := newListWriter(w, r, "status", SyncStatus{})
+    if err != nil {
+        http.Error(w, err.Error(), 400)
+        return
+    }
+    for _, st := range list {
+        if err := lw.Write(st); err != nil {
+            log.Printf("[http] write status: %v", err)
+            return
+        }
+    }
+    lw.Close()
+}
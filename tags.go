@@ -0,0 +1,343 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "path"
+    "regexp"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/labels"
+)
+
+// ---------- Tagging rules ----------
+
+// 按配置里的规则（tagging.rules）自动给 CI 打标签，比如 namespace 匹配 ^pci- 的都是 pci-scope、
+// 带 exposure=public 标签的 Service 是 internet-facing。一条规则里 namespace、labelSelector、images 之间是 AND，
+// 至少要写一个；images 里任一 pattern 匹配任一镜像即算命中。多条规则给同一个 CI 打同一个 tag 只记一次，rule 为第一条命中的规则。
+// 结果存在 ci_tags 表，syncQueue 每次 upsert 后重算这一行，启动时和 reconcile 修复后整表重算（规则可能改过），
+// 对象删除时由触发器一起删。和 ci_attributes 不同，这里的 tag 完全由规则算出，不能手工修改。
+type tagKind struct {
+    table, key, namespace, images string
+}
+
+var tagKinds = map[string]tagKind{
+    "pods":        {table: "pods", key: "uid", namespace: "namespace", images: "images"},
+    "deployments": {table: "deployments", key: "uid", namespace: "namespace", images: "images"},
+    "services":    {table: "services", key: "uid", namespace: "namespace", images: "''"},
+    "nodes":       {table: "nodes", key: "name", namespace: "''", images: "''"},
+}
+
+func initTags(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS ci_tags(
+    kind TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    namespace TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL DEFAULT '',
+    rule INTEGER NOT NULL,
+    PRIMARY KEY(kind, ref, tag)
+);`, `CREATE INDEX IF NOT EXISTS idx_ci_tags_tag ON ci_tags(tag, kind)`}
+    for kind, k := range tagKinds {
+        stmts = append(stmts, `DROP TRIGGER IF EXISTS `+k.table+`_tags_ad`,
+            fmt.Sprintf(`CREATE TRIGGER %s_tags_ad AFTER DELETE ON %s BEGIN
+ DELETE FROM ci_tags WHERE kind='%s' AND ref=old.%s; END`, k.table, k.table, kind, k.key))
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type tagRule struct {
+    TagRule
+    // nil 表示全部 kind
+    kinds map[string]bool
+    ns    *regexp.Regexp
+    sel   labels.Selector
+}
+
+func compileTagRule(r TagRule) (tagRule, error) {
+    out := tagRule{TagRule: r}
+    if r.Tag == "" || strings.ContainsAny(r.Tag, ", ") {
+        return out, errors.New("tag is empty or contains ',' or spaces")
+    }
+    if r.Namespace == "" && r.LabelSelector == "" && len(r.Images) == 0 {
+        return out, errors.New("at least one of namespace, labelSelector and images is required")
+    }
+    for _, k := range r.Kinds {
+        if _, ok := tagKinds[k]; !ok {
+            return out, fmt.Errorf("unknown kind %q (one of pods, deployments, services, nodes)", k)
+        }
+        if out.kinds == nil {
+            out.kinds = map[string]bool{}
+        }
+        out.kinds[k] = true
+    }
+    if r.Namespace != "" {
+        re, err := regexp.Compile(`^(?:` + r.Namespace + `)$`)
+        if err != nil {
+            return out, fmt.Errorf("invalid namespace pattern: %v", err)
+        }
+        out.ns = re
+    }
+    if r.LabelSelector != "" {
+        sel, err := labels.Parse(r.LabelSelector)
+        if err != nil {
+            return out, fmt.Errorf("invalid labelSelector: %v", err)
+        }
+        out.sel = sel
+    }
+    for _, p := range r.Images {
+        if _, err := path.Match(p, ""); err != nil {
+            return out, fmt.Errorf("invalid image pattern %q: %v", p, err)
+        }
+    }
+    return out, nil
+}
+
+// images 是逗号分隔的镜像列表（pods / deployments 的 images 列）
+func (r tagRule) matches(kind, ns, flatLabels, images string) bool {
+    if r.kinds != nil && !r.kinds[kind] {
+        return false
+    }
+    if r.ns != nil && !r.ns.MatchString(ns) {
+        return false
+    }
+    if r.sel != nil && !selectorMatchesFlat(r.sel, flatLabels) {
+        return false
+    }
+    if len(r.Images) == 0 {
+        return true
+    }
+    for _, img := range strings.Split(images, ",") {
+        if img == "" {
+            continue
+        }
+        for _, p := range r.Images {
+            if ok, _ := path.Match(p, img); ok {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// nil 表示没有配置规则，各方法都直接返回
+type tagger struct {
+    rules []tagRule
+}
+
+func newTagger(cfg TaggingConfig) *tagger {
+    if len(cfg.Rules) == 0 {
+        return nil
+    }
+    t := &tagger{}
+    for _, r := range cfg.Rules {
+        rule, _ := compileTagRule(r)
+        t.rules = append(t.rules, rule)
+    }
+    return t
+}
+
+type taggedRow struct {
+    ref, ns, name, labels, images string
+}
+
+func (k tagKind) selectRows() string {
+    return `SELECT ` + k.key + `,coalesce(` + k.namespace + `,''),coalesce(name,''),coalesce(labels,''),coalesce(` + k.images + `,'') FROM ` + k.table
+}
+
+// 写入 row 命中的 tag，调用方先删掉这一行原来的
+func (t *tagger) apply(q querier, kind string, row taggedRow) error {
+    for i, r := range t.rules {
+        if !r.matches(kind, row.ns, row.labels, row.images) {
+            continue
+        }
+        if _, err := q.Exec(`INSERT OR IGNORE INTO ci_tags(kind,ref,tag,namespace,name,rule) VALUES(?,?,?,?,?,?)`,
+            kind, row.ref, r.Tag, row.ns, row.name, i); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// syncQueue upsert 之后调用：按 table 里这一行当前的值重算，不在 tagKinds 里的表直接返回
+func (t *tagger) retag(q querier, table, ref string) error {
+    if t == nil {
+        return nil
+    }
+    k, ok := tagKinds[table]
+    if !ok {
+        return nil
+    }
+    var row taggedRow
+    err := q.QueryRow(k.selectRows()+` WHERE `+k.key+`=?`, ref).Scan(&row.ref, &row.ns, &row.name, &row.labels, &row.images)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if _, err := q.Exec(`DELETE FROM ci_tags WHERE kind=? AND ref=?`, table, ref); err != nil {
+        return err
+    }
+    return t.apply(q, table, row)
+}
+
+// 整表重算一个 kind；没有配置规则时清空，去掉规则删除后留下的 tag
+func (t *tagger) retagKind(db *sql.DB, kind string) error {
+    k, ok := tagKinds[kind]
+    if !ok {
+        return nil
+    }
+    var list []taggedRow
+    if t != nil {
+        rows, err := db.Query(k.selectRows())
+        if err != nil {
+            return err
+        }
+        for rows.Next() {
+            var row taggedRow
+            if err := rows.Scan(&row.ref, &row.ns, &row.name, &row.labels, &row.images); err != nil {
+                rows.Close()
+                return err
+            }
+            list = append(list, row)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return err
+        }
+    }
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if _, err := tx.Exec(`DELETE FROM ci_tags WHERE kind=?`, kind); err != nil {
+        return err
+    }
+    for _, row := range list {
+        if err := t.apply(tx, kind, row); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func (t *tagger) retagAll(db *sql.DB) error {
+    for kind := range tagKinds {
+        if err := t.retagKind(db, kind); err != nil {
+            return fmt.Errorf("%s: %w", kind, err)
+        }
+    }
+    return nil
+}
+
+type TagCount struct {
+    Tag   string `json:"tag"`
+    Kind  string `json:"kind"`
+    Count int64  `json:"count"`
+}
+
+type TaggedCI struct {
+    Kind      string `json:"kind"`
+    Ref       string `json:"ref"`
+    Namespace string `json:"namespace"`
+    Name      string `json:"name"`
+    // 第一条命中的规则在 tagging.rules 里的下标
+    Rule int `json:"rule"`
+}
+
+// GET /cmdb/tags：每个 tag 按 kind 的数量；GET /cmdb/tags/{tag}?kind=&ns=：打了这个 tag 的 CI。
+// 限定 namespace 的凭据看不到 nodes 的 tag
+func tagsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        tag := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cmdb/tags"), "/")
+        var conds []string
+        var condArgs []any
+        if tag != "" {
+            conds, condArgs = append(conds, "tag=?"), append(condArgs, tag)
+        }
+        if v := q.Get("kind"); v != "" {
+            if _, ok := tagKinds[v]; !ok {
+                http.Error(w, "kind must be one of pods, deployments, services, nodes", 400)
+                return
+            }
+            conds, condArgs = append(conds, "kind=?"), append(condArgs, v)
+        }
+        if v := q.Get("ns"); v != "" {
+            conds, condArgs = append(conds, "namespace=?"), append(condArgs, v)
+        }
+        where, args := scopeOf(r.Context()).where("namespace", strings.Join(conds, " AND "), condArgs...)
+        if tag == "" {
+            rows, err := db.QueryContext(r.Context(), `SELECT tag,kind,count(*) FROM ci_tags`+where+` GROUP BY tag,kind ORDER BY tag,kind`, args...)
+            if err != nil {
+                http.Error(w, err.Error(), 500)
+                return
+            }
+            defer rows.Close()
+            lw, err := newListWriter(w, r, "tags", TagCount{})
+            if err != nil {
+                http.Error(w, err.Error(), 400)
+                return
+            }
+            for rows.Next() {
+                var c TagCount
+                if err := rows.Scan(&c.Tag, &c.Kind, &c.Count); err != nil {
+                    lw.Fail(err)
+                    return
+                }
+                if err := lw.Write(c); err != nil {
+                    log.Printf("[http] write tags: %v", err)
+                    return
+                }
+            }
+            if err := rows.Err(); err != nil {
+                lw.Fail(err)
+                return
+            }
+            lw.Close()
+            return
+        }
+        rows, err := db.QueryContext(r.Context(), `SELECT kind,ref,namespace,name,rule FROM ci_tags`+where+` ORDER BY kind,namespace,name`, args...)
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        defer rows.Close()
+        lw, err := newListWriter(w, r, "tagged", TaggedCI{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for rows.Next() {
+            var c TaggedCI
+            if err := rows.Scan(&c.Kind, &c.Ref, &c.Namespace, &c.Name, &c.Rule); err != nil {
+                lw.Fail(err)
+                return
+            }
+            if err := lw.Write(c); err != nil {
+                log.Printf("[http] write tagged: %v", err)
+                return
+            }
+        }
+        if err := rows.Err(); err != nil {
+            lw.Fail(err)
+            return
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "crypto/tls"
+    "log"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// ---------- TLS ----------
+
+// 证书在 SIGHUP 或文件变化时重新加载，cert-manager 轮换后无需重启。
+// 用轮询 mtime 而不是 inotify：Secret 挂载是通过 symlink 原子切换的，os.Stat 跟随链接正好能看到。
+const certPollInterval = 30 * time.Second
+
+type certReloader struct {
+    certFile, keyFile string
+    mu                sync.RWMutex
+    cert              *tls.Certificate
+    modTime           time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+    c := &certReloader{certFile: certFile, keyFile: keyFile}
+    if err := c.reload(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// 两个文件中较新的修改时间
+func (c *certReloader) filesModTime() time.Time {
+    var latest time.Time
+    for _, f := range []string{c.certFile, c.keyFile} {
+        if fi, err := os.Stat(f); err == nil && fi.ModTime().After(latest) {
+            latest = fi.ModTime()
+        }
+    }
+    return latest
+}
+
+// 加载失败时继续用旧证书
+func (c *certReloader) reload() error {
+    mt := c.filesModTime()
+    cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+    if err != nil {
+        return err
+    }
+    c.mu.Lock()
+    c.cert, c.modTime = &cert, mt
+    c.mu.Unlock()
+    return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.cert, nil
+}
+
+func (c *certReloader) watch(stop <-chan struct{}) {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    defer signal.Stop(hup)
+    t := time.NewTicker(certPollInterval)
+    defer t.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-hup:
+            c.reloadAndLog("SIGHUP")
+        case <-t.C:
+            c.mu.RLock()
+            changed := !c.filesModTime().Equal(c.modTime)
+            c.mu.RUnlock()
+            if changed {
+                c.reloadAndLog("file change")
+            }
+        }
+    }
+}
+
+func (c *certReloader) reloadAndLog(reason string) {
+    if err := c.reload(); err != nil {
+        log.Printf("[tls] reload on %s failed, keeping current certificate: %v", reason, err)
+        return
+    }
+    log.Printf("[tls] certificate reloaded (%s)", reason)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// ---------- Topology ----------
+
+// 从 root 出发按关系做 BFS，返回节点/边列表，可直接喂给 cytoscape、d3 之类的图库做影响分析。
+// 关系和 GraphQL 用的是同一套：Pod 运行在 Node 上、Deployment 管理 Pod（经 ReplicaSet）、
+// Service 通过 selector 选中 Pod，以及 refs 表里 Pod/Deployment 引用的 Secret/ConfigMap/PVC/ServiceAccount；
+// 再加上 relations 表里人工维护的关系（见 relations.go）。
+// 节点 id 形如 pod/<ns>/<name>、node/<name>、secret/<ns>/<name>、host/<address>、netdevice/<address>、helmrelease/<ns>/<name>、argoapp/<ns>/<name>、asset/<type>/<name>。
+const (
+    topoDefaultDepth = 2
+    topoMaxDepth     = 4
+    topoMaxNodes     = 500
+)
+
+type TopoNode struct {
+    ID        string `json:"id"`
+    Kind      string `json:"kind"`
+    Name      string `json:"name"`
+    Namespace string `json:"namespace,omitempty"`
+    // 与根节点的跳数
+    Depth int `json:"depth"`
+}
+
+// Type: runs_on（pod->node）/ manages（deployment->pod）/ selects（service->pod）/ uses（pod|deployment->引用对象）/
+// connects（netdevice->node，ARP 匹配）/ owns（helmrelease|argoapp->deployment|service），
+// 手工关系为用户给的类型，manual 为 true
+type TopoEdge struct {
+    Source string `json:"source"`
+    Target string `json:"target"`
+    Type   string `json:"type"`
+    Via    string `json:"via,omitempty"`
+    Manual bool   `json:"manual,omitempty"`
+}
+
+type Topology struct {
+    Root  string     `json:"root"`
+    Nodes []TopoNode `json:"nodes"`
+    Edges []TopoEdge `json:"edges"`
+    // 超过 topoMaxNodes 时停止展开
+    Truncated bool `json:"truncated,omitempty"`
+}
+
+// 图里的一个对象：row 是 gql 加载函数返回的行，引用对象没有 row
+type topoObj struct {
+    node TopoNode
+    row  gqlRow
+}
+
+var (
+    errTopoRootNotFound = errors.New("root not found")
+    errTopoRootInvalid  = errors.New("root must be node/<name>, host/<address>, netdevice/<address>, asset/<type>/<name> or <kind>/<namespace>/<name>")
+)
+
+func topoID(kind, ns, name string) string {
+    if ns == "" {
+        return kind + "/" + name
+    }
+    return kind + "/" + ns + "/" + name
+}
+
+func topoFromRow(kind string, row gqlRow) topoObj {
+    ns := gqlStr(row["namespace"])
+    name := gqlStr(row["name"])
+    return topoObj{node: TopoNode{ID: topoID(kind, ns, name), Kind: kind, Name: name, Namespace: ns}, row: row}
+}
+
+func topoRef(kind, ns, name string) topoObj {
+    k := strings.ToLower(kind)
+    return topoObj{node: TopoNode{ID: topoID(k, ns, name), Kind: k, Name: name, Namespace: ns}}
+}
+
+// 用法 topoRows("pod")(gqlPods(...))，直接接住加载函数的两个返回值
+func topoRows(kind string) func([]gqlRow, error) ([]topoObj, error) {
+    return func(rows []gqlRow, err error) ([]topoObj, error) {
+        if err != nil {
+            return nil, err
+        }
+        out := make([]topoObj, 0, len(rows))
+        for _, r := range rows {
+            out = append(out, topoFromRow(kind, r))
+        }
+        return out, nil
+    }
+}
+
+// root 解析：node/<name>、host/<address>、netdevice/<address>、asset/<type>/<name> 或 <kind>/<ns>/<name>；引用对象的 kind 接受 /cmdb/references 的别名
+func loadTopoRoot(ctx context.Context, db *sql.DB, root string) (*topoObj, error) {
+    if rest, ok := strings.CutPrefix(root, "asset/"); ok {
+        return loadTopoAsset(ctx, db, rest)
+    }
+    parts := strings.Split(root, "/")
+    var objs []topoObj
+    var err error
+    switch {
+    case len(parts) == 2 && parts[0] == "node":
+        objs, err = topoRows("node")(gqlNodes(ctx, db, "name=?", parts[1]))
+    case len(parts) == 2 && (parts[0] == "host" || parts[0] == "netdevice"):
+        table := map[string]string{"host": "hosts", "netdevice": "network_devices"}[parts[0]]
+        where, args := scopeOf(ctx).where("''", "address=?", parts[1])
+        var n int
+        if err := dbFrom(ctx, db).QueryRow(`SELECT count(*) FROM `+table+where, args...).Scan(&n); err != nil {
+            return nil, err
+        }
+        if n == 0 {
+            return nil, errTopoRootNotFound
+        }
+        return &topoObj{node: TopoNode{ID: root, Kind: parts[0], Name: parts[1]}}, nil
+    case len(parts) == 3:
+        ns, name := parts[1], parts[2]
+        switch parts[0] {
+        case "pod":
+            objs, err = topoRows("pod")(gqlPods(ctx, db, "namespace=? AND name=?", ns, name))
+        case "service":
+            objs, err = topoRows("service")(gqlServices(ctx, db, "namespace=? AND name=?", ns, name))
+        case "deployment":
+            objs, err = topoRows("deployment")(gqlDeployments(ctx, db, "namespace=? AND name=?", ns, name))
+        case "helmrelease":
+            return loadTopoHelmRelease(ctx, db, ns, name)
+        case "argoapp":
+            return loadTopoArgoApp(ctx, db, ns, name)
+        default:
+            kind, ok := referenceKinds[parts[0]]
+            if !ok {
+                return nil, fmt.Errorf("%w: unsupported kind %q", errTopoRootInvalid, parts[0])
+            }
+            if !scopeOf(ctx).allows(ns) {
+                return nil, errTopoRootNotFound
+            }
+            o := topoRef(kind, ns, name)
+            return &o, nil
+        }
+    default:
+        return nil, errTopoRootInvalid
+    }
+    if err != nil {
+        return nil, err
+    }
+    if len(objs) == 0 {
+        return nil, errTopoRootNotFound
+    }
+    return &objs[0], nil
+}
+
+// 资产名里可以有 /，type 里不行
+func loadTopoAsset(ctx context.Context, db *sql.DB, rest string) (*topoObj, error) {
+    typ, name, ok := strings.Cut(rest, "/")
+    if !ok || typ == "" || name == "" {
+        return nil, errTopoRootInvalid
+    }
+    where, args := scopeOf(ctx).where("''", "type=? AND name=?", typ, name)
+    var n int
+    if err := dbFrom(ctx, db).QueryRow(`SELECT count(*) FROM assets`+where, args...).Scan(&n); err != nil {
+        return nil, err
+    }
+    if n == 0 {
+        return nil, errTopoRootNotFound
+    }
+    return &topoObj{node: TopoNode{ID: "asset/" + rest, Kind: "asset", Name: name}}, nil
+}
+
+type topoLink struct {
+    obj  topoObj
+    edge TopoEdge
+}
+
+func podRefLinks(ctx context.Context, db *sql.DB, srcKind, srcRef, from string) ([]topoLink, error) {
+    rows, err := dbFrom(ctx, db).Query(`SELECT target_kind,coalesce(src_namespace,''),target_name,via FROM refs WHERE src_kind=? AND src_ref=? ORDER BY 1,3,4`, srcKind, srcRef)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []topoLink
+    for rows.Next() {
+        var kind, ns, name, via string
+        if err := rows.Scan(&kind, &ns, &name, &via); err != nil {
+            return nil, err
+        }
+        o := topoRef(kind, ns, name)
+        out = append(out, topoLink{obj: o, edge: TopoEdge{Source: from, Target: o.node.ID, Type: "uses", Via: via}})
+    }
+    return out, rows.Err()
+}
+
+// 一个对象的全部直接关系（双向）
+func topoNeighbors(ctx context.Context, db *sql.DB, o topoObj) ([]topoLink, error) {
+    id := o.node.ID
+    var out []topoLink
+    add := func(objs []topoObj, err error, edge func(other string) TopoEdge) error {
+        if err != nil {
+            return err
+        }
+        for _, x := range objs {
+            out = append(out, topoLink{obj: x, edge: edge(x.node.ID)})
+        }
+        return nil
+    }
+    switch o.node.Kind {
+    case "pod":
+        nodes, err := topoRows("node")(gqlNodes(ctx, db, "name=?", gqlStr(o.row["nodeName"])))
+        if err := add(nodes, err, func(n string) TopoEdge { return TopoEdge{Source: id, Target: n, Type: "runs_on"} }); err != nil {
+            return nil, err
+        }
+        d, err := podDeployment(ctx, db, o.row)
+        if err != nil {
+            return nil, err
+        }
+        if d != nil {
+            dep := topoFromRow("deployment", d.(gqlRow))
+            out = append(out, topoLink{obj: dep, edge: TopoEdge{Source: dep.node.ID, Target: id, Type: "manages"}})
+        }
+        svcs, err := gqlServices(ctx, db, "namespace=?", o.node.Namespace)
+        if err != nil {
+            return nil, err
+        }
+        for _, s := range svcs {
+            if selectorMatches(gqlStr(s["selector"]), gqlStr(o.row["labels"])) {
+                svc := topoFromRow("service", s)
+                out = append(out, topoLink{obj: svc, edge: TopoEdge{Source: svc.node.ID, Target: id, Type: "selects"}})
+            }
+        }
+        refs, err := podRefLinks(ctx, db, "Pod", gqlStr(o.row["uid"]), id)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, refs...)
+    case "node":
+        pods, err := topoRows("pod")(gqlPods(ctx, db, "node_name=?", o.node.Name))
+        if err := add(pods, err, func(p string) TopoEdge { return TopoEdge{Source: p, Target: id, Type: "runs_on"} }); err != nil {
+            return nil, err
+        }
+        // 网络设备和节点一样对受限 key 不可见，能看到节点的 key 才会走到这里
+        links, err := netDeviceLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+    case "netdevice":
+        links, err := netDeviceLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+    case "service":
+        pods, err := gqlPods(ctx, db, "namespace=?", o.node.Namespace)
+        if err != nil {
+            return nil, err
+        }
+        for _, p := range pods {
+            if selectorMatches(gqlStr(o.row["selector"]), gqlStr(p["labels"])) {
+                pod := topoFromRow("pod", p)
+                out = append(out, topoLink{obj: pod, edge: TopoEdge{Source: id, Target: pod.node.ID, Type: "selects"}})
+            }
+        }
+        links, err := helmReleaseLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+        links, err = argoAppLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+    case "deployment":
+        uid := gqlStr(o.row["uid"])
+        pods, err := topoRows("pod")(gqlPods(ctx, db,
+            `(owner_kind='Deployment' AND owner_uid=?) OR (owner_kind='ReplicaSet' AND owner_uid IN (SELECT uid FROM replicasets WHERE owner_kind='Deployment' AND owner_uid=?))`, uid, uid))
+        if err := add(pods, err, func(p string) TopoEdge { return TopoEdge{Source: id, Target: p, Type: "manages"} }); err != nil {
+            return nil, err
+        }
+        refs, err := podRefLinks(ctx, db, "Deployment", uid, id)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, refs...)
+        links, err := helmReleaseLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+        links, err = argoAppLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+    case "helmrelease":
+        links, err := helmReleaseLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+    case "argoapp":
+        links, err := argoAppLinks(ctx, db, o)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, links...)
+    case "host", "asset":
+        // 只有手工关系
+    default:
+        // 引用对象：反查引用它的 Pod / Deployment
+        kind := referenceKinds[o.node.Kind]
+        rows, err := dbFrom(ctx, db).Query(`SELECT src_kind,src_ref,via FROM refs WHERE target_kind=? AND src_namespace=? AND target_name=? ORDER BY 1,2,3`,
+            kind, o.node.Namespace, o.node.Name)
+        if err != nil {
+            return nil, err
+        }
+        type src struct{ kind, ref, via string }
+        var srcs []src
+        for rows.Next() {
+            var s src
+            if err := rows.Scan(&s.kind, &s.ref, &s.via); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            srcs = append(srcs, s)
+        }
+        rows.Close()
+        for _, s := range srcs {
+            var objs []topoObj
+            if s.kind == "Pod" {
+                objs, err = topoRows("pod")(gqlPods(ctx, db, "uid=?", s.ref))
+            } else {
+                objs, err = topoRows("deployment")(gqlDeployments(ctx, db, "uid=?", s.ref))
+            }
+            if err := add(objs, err, func(x string) TopoEdge { return TopoEdge{Source: x, Target: id, Type: "uses", Via: s.via} }); err != nil {
+                return nil, err
+            }
+        }
+    }
+    manual, err := relationLinks(ctx, db, id)
+    if err != nil {
+        return nil, err
+    }
+    return append(out, manual...), nil
+}
+
+func buildTopology(ctx context.Context, db *sql.DB, root string, depth int) (*Topology, error) {
+    r, err := loadTopoRoot(ctx, db, root)
+    if err != nil {
+        return nil, err
+    }
+    topo := &Topology{Root: r.node.ID, Nodes: []TopoNode{r.node}, Edges: []TopoEdge{}}
+    seen := map[string]bool{r.node.ID: true}
+    edgeSeen := map[TopoEdge]bool{}
+    frontier := []topoObj{*r}
+    for d := 1; d <= depth && len(frontier) > 0; d++ {
+        var next []topoObj
+        for _, o := range frontier {
+            links, err := topoNeighbors(ctx, db, o)
+            if err != nil {
+                return nil, err
+            }
+            for _, l := range links {
+                if !seen[l.obj.node.ID] {
+                    if len(topo.Nodes) >= topoMaxNodes {
+                        topo.Truncated = true
+                        continue
+                    }
+                    seen[l.obj.node.ID] = true
+                    l.obj.node.Depth = d
+                    topo.Nodes = append(topo.Nodes, l.obj.node)
+                    next = append(next, l.obj)
+                }
+                if !edgeSeen[l.edge] {
+                    edgeSeen[l.edge] = true
+                    topo.Edges = append(topo.Edges, l.edge)
+                }
+            }
+        }
+        frontier = next
+    }
+    return topo, nil
+}
+
+// GET /cmdb/topology?root=pod/shop/web-1&depth=2
+func topologyAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        depth := topoDefaultDepth
+        if v := q.Get("depth"); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n < 1 {
+                http.Error(w, "invalid depth", 400)
+                return
+            }
+            depth = min(n, topoMaxDepth)
+        }
+        // 逐层展开要查很多次，放在同一个快照里，避免中途写库导致节点和边对不上
+        var topo *Topology
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            topo, err = buildTopology(ctx, db, q.Get("root"), depth)
+            return err
+        })
+        switch {
+        case errors.Is(err, errTopoRootNotFound):
+            http.Error(w, err.Error(), 404)
+        case errors.Is(err, errTooManyRows):
+            http.Error(w, err.Error(), 413)
+        case errors.Is(err, errTopoRootInvalid):
+            http.Error(w, err.Error(), 400)
+        case err != nil:
+            http.Error(w, err.Error(), 500)
+        default:
+            writeJSON(w, r, topo)
+        }
+    }
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os/exec"
+    "strings"
+    "time"
+)
+
+// ---------- Vulnerability scan (Trivy) ----------
+
+// 开启 scanner.interval 后定时用 trivy 扫描镜像清单里的镜像，按严重程度记下 CVE 数量（image_vulnerabilities），
+// /cmdb/images 里和运行中的 Pod 一起返回。只扫没扫过的和结果早于 scanner.rescan 的镜像（漏洞库每天更新）。
+// 配了 scanner.server 时用 trivy 的 client/server 模式，漏洞库只在 server 上维护；拉镜像的凭据沿用 trivy 自己的配置。
+var vulnSeverities = []string{"critical", "high", "medium", "low", "unknown"}
+
+func initVulnerabilities(db *sql.DB) error {
+    stmts := []string{`
+CREATE TABLE IF NOT EXISTS image_vulnerabilities(
+    ref TEXT PRIMARY KEY,
+    critical INTEGER NOT NULL DEFAULT 0,
+    high INTEGER NOT NULL DEFAULT 0,
+    medium INTEGER NOT NULL DEFAULT 0,
+    low INTEGER NOT NULL DEFAULT 0,
+    unknown INTEGER NOT NULL DEFAULT 0,
+    scanned_at TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);`,
+        // 没有 Pod 再用的镜像从 images 删除时结果一起删
+        `DROP TRIGGER IF EXISTS images_vulns_ad`,
+        `CREATE TRIGGER images_vulns_ad AFTER DELETE ON images BEGIN
+ DELETE FROM image_vulnerabilities WHERE ref=old.ref; END`,
+    }
+    for _, s := range stmts {
+        if _, err := db.Exec(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// trivy image --format json 的输出里用到的字段
+type trivyReport struct {
+    Results []struct {
+        Vulnerabilities []struct {
+            VulnerabilityID string `json:"VulnerabilityID"`
+            Severity        string `json:"Severity"`
+        } `json:"Vulnerabilities"`
+    } `json:"Results"`
+}
+
+// 同一个 CVE 出现在多个包里只算一次，取最高的严重程度
+func (r trivyReport) counts() map[string]int {
+    rank := func(s string) int {
+        for i, v := range vulnSeverities {
+            if v == s {
+                return i
+            }
+        }
+        return len(vulnSeverities) - 1
+    }
+    worst := map[string]string{}
+    for _, res := range r.Results {
+        for _, v := range res.Vulnerabilities {
+            s := strings.ToLower(v.Severity)
+            if rank(s) == len(vulnSeverities)-1 {
+                s = "unknown"
+            }
+            if old, ok := worst[v.VulnerabilityID]; !ok || rank(s) < rank(old) {
+                worst[v.VulnerabilityID] = s
+            }
+        }
+    }
+    out := map[string]int{}
+    for _, s := range worst {
+        out[s]++
+    }
+    return out
+}
+
+type imageScanner struct {
+    db  *sql.DB
+    cfg ScannerConfig
+}
+
+// 有 digest 时按 digest 扫，扫到的就是节点上实际运行的那份
+func scanTarget(registry, repository, tag, digest string) string {
+    if digest != "" {
+        return registry + "/" + repository + "@" + digest
+    }
+    return registry + "/" + repository + ":" + tag
+}
+
+// 第二个返回值是这个镜像本身的失败（拉不到、超时、输出不对），第三个是 trivy 不能运行
+func (s *imageScanner) trivy(ctx context.Context, target string) (trivyReport, error, error) {
+    ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout.Duration)
+    defer cancel()
+    args := []string{"image", "--format", "json", "--quiet", "--scanners", "vuln"}
+    if s.cfg.Server != "" {
+        args = append(args, "--server", s.cfg.Server)
+    }
+    cmd := exec.CommandContext(ctx, s.cfg.Trivy, append(args, target)...)
+    var out, stderr bytes.Buffer
+    cmd.Stdout = &out
+    cmd.Stderr = &stderr
+    var rep trivyReport
+    if err := cmd.Run(); err != nil {
+        var exitErr *exec.ExitError
+        if !errors.As(err, &exitErr) {
+            return rep, nil, err
+        }
+        if ctx.Err() != nil {
+            return rep, fmt.Errorf("timed out after %s", s.cfg.Timeout.Duration), nil
+        }
+        return rep, fmt.Errorf("%v: %s", err, lastLine(stderr.String())), nil
+    }
+    if err := json.Unmarshal(out.Bytes(), &rep); err != nil {
+        return rep, fmt.Errorf("parse trivy output: %w", err), nil
+    }
+    return rep, nil, nil
+}
+
+func lastLine(s string) string {
+    s = strings.TrimSpace(s)
+    if i := strings.LastIndex(s, "\n"); i >= 0 {
+        s = s[i+1:]
+    }
+    return s
+}
+
+// 到期的镜像先一次读出来，扫描期间不占着连接
+func (s *imageScanner) due() ([][5]string, error) {
+    cutoff := time.Now().Add(-s.cfg.Rescan.Duration).UTC().Format(time.RFC3339)
+    rows, err := s.db.Query(`SELECT i.ref,i.registry,i.repository,i.tag,i.digest FROM images i
+ LEFT JOIN image_vulnerabilities v ON v.ref=i.ref WHERE v.ref IS NULL OR v.scanned_at<? ORDER BY v.scanned_at IS NOT NULL,v.scanned_at,i.ref`, cutoff)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out [][5]string
+    for rows.Next() {
+        var r [5]string
+        if err := rows.Scan(&r[0], &r[1], &r[2], &r[3], &r[4]); err != nil {
+            return nil, err
+        }
+        out = append(out, r)
+    }
+    return out, rows.Err()
+}
+
+func (s *imageScanner) record(ref string, counts map[string]int, scanErr string) error {
+    _, err := s.db.Exec(`INSERT OR REPLACE INTO image_vulnerabilities(ref,critical,high,medium,low,unknown,scanned_at,error)
+ SELECT ref,?,?,?,?,?,?,? FROM images WHERE ref=?`, counts["critical"], counts["high"], counts["medium"], counts["low"], counts["unknown"],
+        time.Now().UTC().Format(time.RFC3339), scanErr, ref)
+    return err
+}
+
+// 单个镜像扫描失败（拉不到、超时）记在 error 里，下次到期再试；trivy 本身不能运行时整轮放弃
+func (s *imageScanner) scanOnce(ctx context.Context) (scanned, failed int, err error) {
+    images, err := s.due()
+    if err != nil {
+        return 0, 0, err
+    }
+    for _, img := range images {
+        rep, scanErr, err := s.trivy(ctx, scanTarget(img[1], img[2], img[3], img[4]))
+        // 停止时被杀掉的不算这个镜像失败
+        if ctx.Err() != nil {
+            return scanned, failed, ctx.Err()
+        }
+        if err != nil {
+            return scanned, failed, err
+        }
+        if scanErr != nil {
+            log.Printf("[scanner] %s: %v", img[0], scanErr)
+            err, failed = s.record(img[0], nil, scanErr.Error()), failed+1
+        } else {
+            err, scanned = s.record(img[0], rep.counts(), ""), scanned+1
+        }
+        if err != nil {
+            return scanned, failed, err
+        }
+    }
+    return scanned, failed, nil
+}
+
+// 给 periodicCollector 用；停止时 ctx 被取消，扫到一半的镜像下一轮重扫
+func (s *imageScanner) collectOnce(ctx context.Context) error {
+    start := time.Now()
+    scanned, failed, err := s.scanOnce(ctx)
+    if err != nil && ctx.Err() == nil {
+        log.Printf("[scanner] %v", err)
+    }
+    if scanned+failed > 0 {
+        log.Printf("[scanner] scanned %d images, %d failed, in %s", scanned, failed, time.Since(start).Round(time.Second))
+    }
+    return err
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "time"
+
+    "golang.org/x/term"
+
+    cmdbclient "lightcmdb-week3/client"
+)
+
+// ---------- TUI ----------
+
+// lightcmdb tui：给只能 SSH 到边缘站点的同学用的终端界面，通过 HTTP API 读数据（和浏览器看到的范围一致）。
+// 只依赖 x/term 做 raw mode，界面用 ANSI 转义自己画：上面 tab + 表格，回车打开详情（字段 + 最近变更），
+// 定时刷新当前 tab。
+type tuiTab struct {
+    Title string
+    Path  string
+    Cols  []string
+    // 详情里显示最近变更用，空表示该 kind 没有历史
+    HistoryKind, HistoryRef string
+}
+
+var tuiTabs = []tuiTab{
+    {Title: "pods", Path: "/cmdb/pods", Cols: []string{"namespace", "name", "phase", "nodeName", "podIP"}, HistoryKind: "pod", HistoryRef: "uid"},
+    {Title: "nodes", Path: "/cmdb/nodes", Cols: []string{"name", "internalIP", "cpu", "memory", "updatedAt"}, HistoryKind: "node", HistoryRef: "name"},
+    {Title: "assets", Path: "/cmdb/assets", Cols: []string{"type", "name", "site", "owner", "labels"}, HistoryKind: "asset", HistoryRef: "id"},
+    {Title: "search", Path: "/cmdb/search", Cols: []string{"type", "namespace", "name", "match"}},
+}
+
+type tuiState struct {
+    client   *cmdbclient.Client
+    tab      int
+    items    []map[string]any
+    visible  []int // 过滤后的 items 下标
+    cursor   int
+    offset   int
+    filter   string
+    editing  bool
+    input    string
+    detail   bool
+    history  []map[string]any
+    status   string
+    loadedAt time.Time
+    width    int
+    height   int
+}
+
+func runTUI(args []string) error {
+    fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+    server := fs.String("server", envOr("LIGHTCMDB_URL", "http://localhost:8080"), "LightCMDB base URL")
+    token := fs.String("token", os.Getenv("LIGHTCMDB_TOKEN"), "API key or OIDC ID token")
+    insecure := fs.Bool("insecure", false, "skip TLS certificate verification")
+    refresh := fs.Duration("refresh", 5*time.Second, "refresh interval of the current tab")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    fd := int(os.Stdin.Fd())
+    if !term.IsTerminal(fd) {
+        return errors.New("tui needs an interactive terminal")
+    }
+    s := &tuiState{client: newAPIClient(*server, *token, *insecure)}
+
+    old, err := term.MakeRaw(fd)
+    if err != nil {
+        return err
+    }
+    // 备用屏幕 + 隐藏光标，退出时恢复
+    os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+    defer func() {
+        os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+        term.Restore(fd, old)
+    }()
+
+    keys := make(chan string)
+    go readKeys(os.Stdin, keys)
+    s.load()
+    s.draw()
+    tick := time.NewTicker(*refresh)
+    defer tick.Stop()
+    resize := time.NewTicker(500 * time.Millisecond)
+    defer resize.Stop()
+    for {
+        select {
+        case k, ok := <-keys:
+            if !ok || !s.handleKey(k) {
+                return nil
+            }
+        case <-tick.C:
+            if !s.editing {
+                s.load()
+            }
+        case <-resize.C:
+            if w, h, err := term.GetSize(int(os.Stdout.Fd())); err != nil || (w == s.width && h == s.height) {
+                continue
+            }
+        }
+        s.draw()
+    }
+}
+
+// tui 和 ctl 共用的只读 API 客户端
+func newAPIClient(server, token string, insecure bool) *cmdbclient.Client {
+    c := cmdbclient.New(server, token)
+    if insecure {
+        tr := http.DefaultTransport.(*http.Transport).Clone()
+        tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+        c.HTTPClient.Transport = tr
+    }
+    // 服务端按 User-Agent 区分 integration（/admin/consumers）
+    c.UserAgent = "lightcmdb-cli"
+    return c
+}
+
+func envOr(key, def string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return def
+}
+
+// 把输入切成按键：普通字符一个一组，ESC 序列整组
+func readKeys(r io.Reader, out chan<- string) {
+    defer close(out)
+    buf := make([]byte, 64)
+    for {
+        n, err := r.Read(buf)
+        if err != nil {
+            return
+        }
+        b := buf[:n]
+        for len(b) > 0 {
+            if b[0] == 0x1b && len(b) > 1 && (b[1] == '[' || b[1] == 'O') {
+                i := 2
+                for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+                    i++
+                }
+                i = min(i+1, len(b))
+                out <- string(b[:i])
+                b = b[i:]
+                continue
+            }
+            out <- string(b[:1])
+            b = b[1:]
+        }
+    }
+}
+
+// 返回 false 表示退出
+func (s *tuiState) handleKey(k string) bool {
+    if s.editing {
+        switch k {
+        case "\r", "\n":
+            s.editing, s.filter = false, s.input
+            if tuiTabs[s.tab].Title == "search" {
+                s.load()
+            } else {
+                s.applyFilter()
+            }
+        case "\x1b", "\x03":
+            s.editing = false
+        case "\x7f", "\b":
+            if r := []rune(s.input); len(r) > 0 {
+                s.input = string(r[:len(r)-1])
+            }
+        default:
+            if len(k) == 1 && k[0] >= 0x20 && k[0] != 0x7f {
+                s.input += k
+            }
+        }
+        return true
+    }
+    switch k {
+    case "q", "\x03":
+        return false
+    case "\t", "\x1b[Z":
+        if k == "\t" {
+            s.tab = (s.tab + 1) % len(tuiTabs)
+        } else {
+            s.tab = (s.tab + len(tuiTabs) - 1) % len(tuiTabs)
+        }
+        s.filter, s.cursor, s.offset, s.detail = "", 0, 0, false
+        s.items, s.visible = nil, nil
+        s.load()
+    case "j", "\x1b[B", "\x1bOB":
+        s.move(1)
+    case "k", "\x1b[A", "\x1bOA":
+        s.move(-1)
+    case "\x1b[6~", " ":
+        s.move(s.pageSize())
+    case "\x1b[5~":
+        s.move(-s.pageSize())
+    case "g":
+        s.move(-len(s.visible))
+    case "G":
+        s.move(len(s.visible))
+    case "/":
+        s.editing, s.input = true, s.filter
+    case "\r", "\n":
+        s.detail = !s.detail
+        s.loadHistory()
+    case "\x1b":
+        s.detail = false
+    case "r":
+        s.load()
+    }
+    return true
+}
+
+func (s *tuiState) move(d int) {
+    s.cursor = max(0, min(len(s.visible)-1, s.cursor+d))
+    if s.detail {
+        s.loadHistory()
+    }
+}
+
+func (s *tuiState) load() {
+    t := tuiTabs[s.tab]
+    var items []map[string]any
+    var err error
+    if t.Title == "search" {
+        if s.filter == "" {
+            s.items, s.visible, s.status = nil, nil, "press / to search"
+            return
+        }
+        err = s.client.Get(context.Background(), t.Path, url.Values{"q": {s.filter}, "limit": {"500"}}, &items)
+    } else {
+        err = s.client.Get(context.Background(), t.Path, nil, &items)
+    }
+    if err != nil {
+        s.status = "error: " + err.Error()
+        return
+    }
+    // 按列排序，刷新后光标尽量停在同一个对象上
+    var selected string
+    if s.cursor < len(s.visible) {
+        selected = tuiKey(s.items[s.visible[s.cursor]], t)
+    }
+    sort.SliceStable(items, func(i, j int) bool { return tuiKey(items[i], t) < tuiKey(items[j], t) })
+    s.items, s.loadedAt, s.status = items, time.Now(), ""
+    s.applyFilter()
+    for i, idx := range s.visible {
+        if tuiKey(s.items[idx], t) == selected {
+            s.cursor = i
+        }
+    }
+}
+
+func tuiKey(item map[string]any, t tuiTab) string {
+    var parts []string
+    for _, c := range t.Cols[:min(2, len(t.Cols))] {
+        parts = append(parts, tuiField(item, c))
+    }
+    return strings.Join(parts, "\x00")
+}
+
+func tuiField(item map[string]any, key string) string {
+    switch v := item[key].(type) {
+    case nil:
+        return ""
+    case string:
+        return v
+    default:
+        b, _ := json.Marshal(v)
+        return string(b)
+    }
+}
+
+// 本地过滤：所有字段里做不区分大小写的子串匹配；search tab 的过滤词由服务端处理
+func (s *tuiState) applyFilter() {
+    s.visible = s.visible[:0]
+    f := strings.ToLower(s.filter)
+    search := tuiTabs[s.tab].Title == "search"
+    for i, it := range s.items {
+        if f == "" || search {
+            s.visible = append(s.visible, i)
+            continue
+        }
+        for k := range it {
+            if strings.Contains(strings.ToLower(tuiField(it, k)), f) {
+                s.visible = append(s.visible, i)
+                break
+            }
+        }
+    }
+    s.cursor = max(0, min(s.cursor, len(s.visible)-1))
+}
+
+func (s *tuiState) selected() map[string]any {
+    if s.cursor < len(s.visible) {
+        return s.items[s.visible[s.cursor]]
+    }
+    return nil
+}
+
+func (s *tuiState) loadHistory() {
+    s.history = nil
+    t := tuiTabs[s.tab]
+    it := s.selected()
+    if !s.detail || it == nil || t.HistoryKind == "" {
+        return
+    }
+    q := url.Values{"kind": {t.HistoryKind}, "ref": {tuiField(it, t.HistoryRef)}, "limit": {"10"}}
+    if err := s.client.Get(context.Background(), "/cmdb/history", q, &s.history); err != nil {
+        s.status = "history: " + err.Error()
+    }
+}
+
+// 表格可用行数：tab 行、表头、状态行，详情打开时占下半屏
+func (s *tuiState) pageSize() int {
+    rows := s.height - 3
+    if s.detail {
+        rows = (s.height - 3) / 2
+    }
+    return max(1, rows)
+}
+
+func (s *tuiState) draw() {
+    w, h, err := term.GetSize(int(os.Stdout.Fd()))
+    if err != nil {
+        w, h = 80, 24
+    }
+    s.width, s.height = w, h
+    var b strings.Builder
+    b.WriteString("\x1b[H\x1b[2J")
+    line := func(text string, style string) {
+        r := []rune(text)
+        if len(r) > w {
+            r = r[:w]
+        }
+        if style != "" {
+            b.WriteString(style + string(r) + strings.Repeat(" ", w-len(r)) + "\x1b[0m")
+        } else {
+            b.WriteString(string(r))
+        }
+        b.WriteString("\r\n")
+    }
+
+    // tab 行
+    var tabs strings.Builder
+    for i, t := range tuiTabs {
+        if i == s.tab {
+            tabs.WriteString("\x1b[7m " + t.Title + " \x1b[0m ")
+        } else {
+            tabs.WriteString(" " + t.Title + "  ")
+        }
+    }
+    b.WriteString(tabs.String() + " " + s.client.BaseURL() + "\r\n")
+
+    t := tuiTabs[s.tab]
+    widths := make([]int, len(t.Cols))
+    for i, c := range t.Cols {
+        widths[i] = len(c)
+    }
+    for _, idx := range s.visible {
+        for i, c := range t.Cols {
+            widths[i] = max(widths[i], min(40, len([]rune(tuiField(s.items[idx], c)))))
+        }
+    }
+    row := func(vals []string) string {
+        var parts []string
+        for i, v := range vals {
+            r := []rune(v)
+            if len(r) > widths[i] {
+                r = append(r[:widths[i]-1], '~')
+            }
+            parts = append(parts, string(r)+strings.Repeat(" ", widths[i]-len(r)))
+        }
+        return strings.Join(parts, "  ")
+    }
+    line(row(t.Cols), "\x1b[1m")
+
+    page := s.pageSize()
+    if s.cursor < s.offset {
+        s.offset = s.cursor
+    }
+    if s.cursor >= s.offset+page {
+        s.offset = s.cursor - page + 1
+    }
+    for i := s.offset; i < min(len(s.visible), s.offset+page); i++ {
+        vals := make([]string, len(t.Cols))
+        for j, c := range t.Cols {
+            vals[j] = tuiField(s.items[s.visible[i]], c)
+        }
+        if i == s.cursor {
+            line(row(vals), "\x1b[7m")
+        } else {
+            line(row(vals), "")
+        }
+    }
+    drawn := min(len(s.visible), s.offset+page) - s.offset
+    for i := drawn; i < page; i++ {
+        b.WriteString("\r\n")
+    }
+
+    if s.detail {
+        detailRows := h - 3 - page
+        var lines []string
+        if it := s.selected(); it != nil {
+            keys := make([]string, 0, len(it))
+            for k := range it {
+                keys = append(keys, k)
+            }
+            sort.Strings(keys)
+            for _, k := range keys {
+                lines = append(lines, fmt.Sprintf("%-20s %s", k, tuiField(it, k)))
+            }
+            if len(s.history) > 0 {
+                lines = append(lines, "", "recent changes:")
+                for _, c := range s.history {
+                    lines = append(lines, fmt.Sprintf("  #%s %s %-7s %s", tuiField(c, "id"), tuiField(c, "ts"), tuiField(c, "op"), tuiField(c, "source")))
+                }
+            }
+        }
+        line(strings.Repeat("─", w), "")
+        for i := 0; i < detailRows-1; i++ {
+            if i < len(lines) {
+                line(lines[i], "")
+            } else {
+                b.WriteString("\r\n")
+            }
+        }
+    }
+
+    // 状态行
+    status := fmt.Sprintf("%s %d/%d", t.Title, len(s.visible), len(s.items))
+    if s.filter != "" {
+        status += " filter=" + s.filter
+    }
+    if !s.loadedAt.IsZero() {
+        status += " updated " + s.loadedAt.Format("15:04:05")
+    }
+    if s.status != "" {
+        status += " | " + s.status
+    }
+    if s.editing {
+        status = "/" + s.input + "█"
+    } else {
+        status += " | Tab switch  / filter  Enter detail  r refresh  q quit"
+    }
+    b.WriteString("\x1b[" + fmt.Sprint(h) + ";1H")
+    r := []rune(status)
+    if len(r) > w {
+        r = r[:w]
+    }
+    b.WriteString("\x1b[7m" + string(r) + strings.Repeat(" ", w-len(r)) + "\x1b[0m")
+    os.Stdout.WriteString(b.String())
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/informers"
+    "k8s.io/client-go/kubernetes"
+    "k8s.io/client-go/tools/cache"
+)
+
+// ---------- Unused objects ----------
+
+// 清理前的候选清单：可能已经没人用的对象，全部按库里的关系算，不实时查 API server。
+//   - ConfigMap / Secret：没有 Pod 或 Deployment 模板引用（refs）。unused.enabled 时才有这两种对象的清单：
+//     单独的 informer watch ConfigMap 和 Secret，进缓存前就去掉 data，只有元数据入库（config_objects），
+//     需要 configmaps、secrets 的 list/watch 权限
+//   - Service：有 selector 但没有 Ready 的 Pod 匹配，即 endpoints 为空；ExternalName 和不带 selector（手工维护 Endpoints）的不算
+//   - PVC：没有 Pod 挂载，Deployment 模板里引用了但副本为 0 的也算
+//   - 镜像：只剩 Succeeded / Failed 的 Pod 在用（Job 的 Pod 跑完是正常的，不算）；没有 Pod 的镜像 images 表里已经删掉了
+//
+// 不是用户自己管理的 ConfigMap / Secret 不列：有 ownerReferences 的、kube-system 等系统 namespace 里的、kube-root-ca.crt、
+// ServiceAccount token、Helm release、cert-manager 签发的证书。Ingress 的 TLS Secret、只被 CRD 引用的对象库里没有引用关系，会误报。
+var unusedKinds = map[string]string{
+    "configmap":             "ConfigMap",
+    "cm":                    "ConfigMap",
+    "secret":                "Secret",
+    "service":               "Service",
+    "svc":                   "Service",
+    "persistentvolumeclaim": "PersistentVolumeClaim",
+    "pvc":                   "PersistentVolumeClaim",
+    "image":                 "Image",
+}
+
+var unusedKindOrder = []string{"ConfigMap", "Secret", "Service", "PersistentVolumeClaim", "Image"}
+
+// transform 后只留这几个 annotation，用来判断 managed_by；其余的（包括 last-applied-configuration 里的 Secret 内容）丢掉
+var configObjectAnnotations = []string{corev1.ServiceAccountNameKey, "cert-manager.io/certificate-name"}
+
+var systemNamespaces = map[string]bool{"kube-system": true, "kube-public": true, "kube-node-lease": true}
+
+func initUnused(db *sql.DB) error {
+    _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS config_objects(
+    kind TEXT NOT NULL,
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    uid TEXT,
+    -- Secret 的 type，ConfigMap 为空
+    type TEXT,
+    -- 生命周期由谁管理，见 configObjectManager；为空时是用户自己的对象
+    managed_by TEXT,
+    labels TEXT,
+    resource_version TEXT,
+    created_at TEXT,
+    updated_at TEXT,
+    PRIMARY KEY(kind, namespace, name)
+);`)
+    return err
+}
+
+func configObjectTransform(obj interface{}) (interface{}, error) {
+    var meta *metav1.ObjectMeta
+    switch o := obj.(type) {
+    case *corev1.ConfigMap:
+        o.Data, o.BinaryData = nil, nil
+        meta = &o.ObjectMeta
+    case *corev1.Secret:
+        o.Data, o.StringData = nil, nil
+        meta = &o.ObjectMeta
+    default:
+        return obj, nil
+    }
+    meta.ManagedFields = nil
+    kept := map[string]string{}
+    for _, k := range configObjectAnnotations {
+        if v, ok := meta.Annotations[k]; ok {
+            kept[k] = v
+        }
+    }
+    meta.Annotations = kept
+    return obj, nil
+}
+
+func configObjectManager(meta metav1.ObjectMeta, secretType corev1.SecretType) string {
+    switch {
+    case len(meta.OwnerReferences) > 0:
+        return meta.OwnerReferences[0].Kind
+    case systemNamespaces[meta.Namespace], meta.Name == "kube-root-ca.crt",
+        secretType == corev1.SecretTypeServiceAccountToken, meta.Annotations[corev1.ServiceAccountNameKey] != "":
+        return "kubernetes"
+    case secretType == helmSecretType, meta.Labels["owner"] == "helm":
+        return "helm"
+    case meta.Annotations["cert-manager.io/certificate-name"] != "":
+        return "cert-manager"
+    }
+    return ""
+}
+
+func storeConfigObject(db *sql.DB, kind string, meta metav1.ObjectMeta, secretType corev1.SecretType) error {
+    now := time.Now().UTC().Format(time.RFC3339)
+    _, err := db.Exec(`
+INSERT INTO config_objects(kind,namespace,name,uid,type,managed_by,labels,resource_version,created_at,updated_at)
+VALUES(?,?,?,?,?,?,?,?,?,?)
+ON CONFLICT(kind,namespace,name) DO UPDATE SET
+ uid=excluded.uid,
+ type=excluded.type,
+ managed_by=excluded.managed_by,
+ labels=excluded.labels,
+ resource_version=excluded.resource_version,
+ updated_at=excluded.updated_at`,
+        kind, meta.Namespace, meta.Name, string(meta.UID), string(secretType), configObjectManager(meta, secretType),
+        flattenLabels(meta.Labels), meta.ResourceVersion, now, now)
+    return err
+}
+
+// 同 watchTLSSecrets：单独的 factory，不经过 syncs 的队列，同步后删掉库里多出来的行
+func watchConfigObjects(db *sql.DB, client kubernetes.Interface, stop <-chan struct{}) {
+    factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(configObjectTransform))
+    for _, k := range []struct {
+        kind string
+        inf  cache.SharedIndexInformer
+    }{
+        {"ConfigMap", factory.Core().V1().ConfigMaps().Informer()},
+        {"Secret", factory.Core().V1().Secrets().Informer()},
+    } {
+        kind, inf := k.kind, k.inf
+        upsert := func(obj interface{}) {
+            var err error
+            switch o := obj.(type) {
+            case *corev1.ConfigMap:
+                err = storeConfigObject(db, kind, o.ObjectMeta, "")
+            case *corev1.Secret:
+                err = storeConfigObject(db, kind, o.ObjectMeta, o.Type)
+            default:
+                return
+            }
+            if err != nil {
+                log.Printf("[unused] %s err=%v", kind, err)
+            }
+        }
+        inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+            AddFunc:    upsert,
+            UpdateFunc: func(_, obj interface{}) { upsert(obj) },
+            DeleteFunc: func(obj interface{}) {
+                key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+                if err != nil {
+                    return
+                }
+                ns, name, _ := cache.SplitMetaNamespaceKey(key)
+                if _, err := db.Exec(`DELETE FROM config_objects WHERE kind=? AND namespace=? AND name=?`, kind, ns, name); err != nil {
+                    log.Printf("[unused] delete %s %s err=%v", kind, key, err)
+                }
+            },
+        })
+        go func() {
+            // 不等同步：缺权限时只会打日志，不影响启动
+            if !cache.WaitForCacheSync(stop, inf.HasSynced) {
+                return
+            }
+            if err := pruneConfigObjects(db, kind, inf.GetStore()); err != nil {
+                log.Printf("[unused] prune %s: %v", kind, err)
+            }
+        }()
+    }
+    factory.Start(stop)
+}
+
+func pruneConfigObjects(db *sql.DB, kind string, store cache.Store) error {
+    live := map[string]bool{}
+    for _, k := range store.ListKeys() {
+        live[k] = true
+    }
+    rows, err := db.Query(`SELECT namespace,name FROM config_objects WHERE kind=?`, kind)
+    if err != nil {
+        return err
+    }
+    var gone [][2]string
+    for rows.Next() {
+        var ns, name string
+        if err := rows.Scan(&ns, &name); err != nil {
+            rows.Close()
+            return err
+        }
+        if !live[ns+"/"+name] {
+            gone = append(gone, [2]string{ns, name})
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    for _, g := range gone {
+        if _, err := db.Exec(`DELETE FROM config_objects WHERE kind=? AND namespace=? AND name=?`, kind, g[0], g[1]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type UnusedRow struct {
+    // ConfigMap / Secret / Service / PersistentVolumeClaim / Image
+    Kind      string `json:"kind"`
+    Namespace string `json:"namespace"`
+    // 镜像为完整引用
+    Name   string `json:"name"`
+    Reason string `json:"reason"`
+}
+
+// kind 为空时全部；镜像按 namespace 各一行，scope 限定的调用方只按自己 namespace 里的 Pod 算
+func computeUnused(q querier, scope nsScope, kind, namespace string) ([]UnusedRow, error) {
+    var out []UnusedRow
+    want := func(k string) bool { return kind == "" || kind == k }
+    cond := func(col string, c string, args ...any) (string, []any) {
+        if namespace != "" {
+            if c != "" {
+                c += " AND "
+            }
+            c += col + "=?"
+            args = append(args, namespace)
+        }
+        return scope.where(col, c, args...)
+    }
+    collect := func(reason string, query string, args ...any) error {
+        rows, err := q.Query(query, args...)
+        if err != nil {
+            return err
+        }
+        defer rows.Close()
+        for rows.Next() {
+            var u UnusedRow
+            if err := rows.Scan(&u.Kind, &u.Namespace, &u.Name); err != nil {
+                return err
+            }
+            u.Reason = reason
+            out = append(out, u)
+        }
+        return rows.Err()
+    }
+    for _, k := range []string{"ConfigMap", "Secret"} {
+        if !want(k) {
+            continue
+        }
+        where, args := cond("c.namespace", `c.kind=? AND coalesce(c.managed_by,'')='' AND NOT EXISTS
+ (SELECT 1 FROM refs r WHERE r.target_kind=c.kind AND r.src_namespace=c.namespace AND r.target_name=c.name)`, k)
+        if err := collect("not referenced by any pod or deployment", `SELECT c.kind,c.namespace,c.name FROM config_objects c`+where, args...); err != nil {
+            return nil, err
+        }
+    }
+    if want("PersistentVolumeClaim") {
+        where, args := cond("p.namespace", `NOT EXISTS
+ (SELECT 1 FROM refs r WHERE r.src_kind='Pod' AND r.target_kind='PersistentVolumeClaim' AND r.src_namespace=p.namespace AND r.target_name=p.name)`)
+        rows, err := q.Query(`SELECT p.namespace,p.name,coalesce(p.phase,'') FROM persistent_volume_claims p`+where, args...)
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            u := UnusedRow{Kind: "PersistentVolumeClaim", Reason: "not mounted by any pod"}
+            var phase string
+            if err := rows.Scan(&u.Namespace, &u.Name, &phase); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            if phase != string(corev1.ClaimBound) {
+                u.Reason = fmt.Sprintf("%s (%s)", u.Reason, strings.ToLower(phase))
+            }
+            out = append(out, u)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+    if want("Service") {
+        services, err := unusedServices(q, cond)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, services...)
+    }
+    if want("Image") {
+        where, args := cond("p.namespace", "")
+        inner, innerArgs := cond("p2.namespace", "")
+        if err := collect("only used by completed pods", `SELECT 'Image',p.namespace,pi.ref FROM pod_images pi JOIN pods p ON p.uid=pi.uid`+where+`
+ AND pi.ref IN (SELECT pi2.ref FROM pod_images pi2 JOIN pods p2 ON p2.uid=pi2.uid`+inner+`
+ GROUP BY pi2.ref HAVING sum(coalesce(p2.phase,'') NOT IN ('Succeeded','Failed') OR coalesce(p2.owner_kind,'')='Job')=0)
+ GROUP BY pi.ref,p.namespace`, append(args, innerArgs...)...); err != nil {
+            return nil, err
+        }
+    }
+    order := map[string]int{}
+    for i, k := range unusedKindOrder {
+        order[k] = i
+    }
+    sort.SliceStable(out, func(i, j int) bool {
+        a, b := out[i], out[j]
+        if a.Kind != b.Kind {
+            return order[a.Kind] < order[b.Kind]
+        }
+        if a.Namespace != b.Namespace {
+            return a.Namespace < b.Namespace
+        }
+        return a.Name < b.Name
+    })
+    return out, nil
+}
+
+// endpoints 不入库：Ready 的 Pod 就是 endpoints controller 会放进去的地址（publishNotReadyAddresses 的除外）
+func unusedServices(q querier, cond func(col, c string, args ...any) (string, []any)) ([]UnusedRow, error) {
+    where, args := cond("namespace", "ready='true'")
+    rows, err := q.Query(`SELECT namespace,coalesce(labels,'') FROM pods`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    ready := map[string][]string{}
+    for rows.Next() {
+        var ns, labels string
+        if err := rows.Scan(&ns, &labels); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        ready[ns] = append(ready[ns], labels)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    where, args = cond("namespace", `coalesce(selector,'')!='' AND coalesce(type,'')!='ExternalName'`)
+    rows, err = q.Query(`SELECT namespace,name,selector FROM services`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []UnusedRow
+    for rows.Next() {
+        var ns, name, selector string
+        if err := rows.Scan(&ns, &name, &selector); err != nil {
+            return nil, err
+        }
+        matched := false
+        for _, labels := range ready[ns] {
+            if selectorMatches(selector, labels) {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            out = append(out, UnusedRow{Kind: "Service", Namespace: ns, Name: name, Reason: "no ready pod matches the selector"})
+        }
+    }
+    return out, rows.Err()
+}
+
+// GET /cmdb/unused?kind=configmap|secret|service|pvc|image&namespace=
+func unusedAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        kind := ""
+        if v := q.Get("kind"); v != "" {
+            kind = unusedKinds[strings.ToLower(v)]
+            if kind == "" {
+                http.Error(w, "unknown kind "+v, 400)
+                return
+            }
+        }
+        var rows []UnusedRow
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            rows, err = computeUnused(dbFrom(ctx, db), scopeOf(r.Context()), kind, q.Get("namespace"))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "unused", UnusedRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        for _, u := range rows {
+            if err := lw.Write(u); err != nil {
+                log.Printf("[http] write unused: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "runtime"
+    "runtime/debug"
+)
+
+// ---------- Version and build info ----------
+
+// 发布构建用 -ldflags 注入：
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// 没注入 commit / buildDate 时取 go build 自带的 vcs.revision / vcs.time（在 git 工作区里构建才有）。
+// GET /version、lightcmdb version 和指标 lightcmdb_build_info 给出同样的内容，用来确认各集群上跑的是哪个构建。
+var (
+    version   = "dev"
+    commit    = ""
+    buildDate = ""
+)
+
+type BuildInfo struct {
+    Version string `json:"version"`
+    Commit  string `json:"commit"`
+    // 构建时工作区有未提交的修改（只在取 vcs 信息时知道）
+    Dirty     bool   `json:"dirty,omitempty"`
+    BuildDate string `json:"buildDate"`
+    GoVersion string `json:"goVersion"`
+    Platform  string `json:"platform"`
+}
+
+func buildInfo() BuildInfo {
+    b := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate,
+        GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+    if info, ok := debug.ReadBuildInfo(); ok {
+        for _, s := range info.Settings {
+            switch s.Key {
+            case "vcs.revision":
+                if b.Commit == "" {
+                    b.Commit = s.Value
+                }
+            case "vcs.time":
+                if b.BuildDate == "" {
+                    b.BuildDate = s.Value
+                }
+            case "vcs.modified":
+                b.Dirty = commit == "" && s.Value == "true"
+            }
+        }
+    }
+    if b.Commit == "" {
+        b.Commit = "unknown"
+    }
+    return b
+}
+
+func runVersion(args []string, stdout io.Writer) error {
+    fs := flag.NewFlagSet("version", flag.ContinueOnError)
+    asJSON := fs.Bool("json", false, "print the same JSON as GET /version")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    b := buildInfo()
+    if *asJSON {
+        enc := json.NewEncoder(stdout)
+        enc.SetIndent("", "  ")
+        return enc.Encode(b)
+    }
+    rev := b.Commit
+    if b.Dirty {
+        rev += "-dirty"
+    }
+    fmt.Fprintf(stdout, "lightcmdb %s (commit %s, built %s, %s %s)\n", b.Version, rev, orUnknown(b.BuildDate), b.GoVersion, b.Platform)
+    return nil
+}
+
+func orUnknown(s string) string {
+    if s == "" {
+        return "unknown"
+    }
+    return s
+}
+
+// GET /version，和 /healthz 一样不需要认证
+func versionAPI(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", 405)
+        return
+    }
+    writeJSON(w, r, buildInfo())
+}
+
+func registerBuildInfoMetric(m *metricsRegistry) {
+    b := buildInfo()
+    m.register(metricFamily{Name: "lightcmdb_build_info", Type: "gauge",
+        Help: "Always 1; labels identify the running build",
+        Collect: func() []metricSample {
+            return []metricSample{{Labels: []metricLabel{{"version", b.Version}, {"commit", b.Commit},
+                {"build_date", b.BuildDate}, {"goversion", b.GoVersion}}, Value: 1}}
+        }})
+}
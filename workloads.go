@@ -0,0 +1,233 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+// ---------- Workload rollup ----------
+
+// 管理报表按 workload 看，不按 Pod 看：Pod 按最上层的 controller 归组（ReplicaSet 再往上找一层到 Deployment，同 /cmdb/costs），
+// 没有 controller 的 Pod 各自一行（kind 为 Pod）。desired 只有 Deployment 有 spec.replicas；
+// 其他 controller（StatefulSet、DaemonSet、Job 等）不在库里，desired 取当前 Pod 数。
+// 没有 Pod 的 Deployment（缩到 0、全部调度不上）也列出来。
+type WorkloadRow struct {
+    Namespace string `json:"namespace"`
+    Kind      string `json:"kind"`
+    Name      string `json:"name"`
+    // 所在 namespace 的团队，见 ownership.go
+    Team    string `json:"team"`
+    Desired int64  `json:"desired"`
+    Pods    int64  `json:"pods"`
+    // Ready condition 为 True 的 Pod 数
+    Ready   int64 `json:"ready"`
+    Running int64 `json:"running"`
+    // 各 Pod 镜像去重后逗号分隔
+    Images string `json:"images"`
+    // 用到的节点和这些节点所在的 zone，逗号分隔
+    Nodes string `json:"nodes"`
+    Zones string `json:"zones"`
+    // 这些节点的 os/arch，和 Pod 上 kubernetes.io/arch 约束允许的架构（Pod 之间不一致时用 | 隔开），见 architectures.go
+    Platforms string `json:"platforms"`
+    ArchPin   string `json:"archPin,omitempty"`
+    // manifest 里有这个 workload 的 Helm release 和它的 chart-version，见 helm.go
+    HelmRelease string `json:"helmRelease,omitempty"`
+    Chart       string `json:"chart,omitempty"`
+}
+
+type workloadGroup struct {
+    row    WorkloadRow
+    images map[string]bool
+    nodes  map[string]bool
+    zones  map[string]bool
+    // 平台和 arch_pin
+    platforms map[string]bool
+    pins      map[string]bool
+}
+
+func joinSet(set map[string]bool) string {
+    return joinSetWith(set, ",")
+}
+
+func joinSetWith(set map[string]bool, sep string) string {
+    out := make([]string, 0, len(set))
+    for v := range set {
+        out = append(out, v)
+    }
+    sort.Strings(out)
+    return strings.Join(out, sep)
+}
+
+func computeWorkloads(q querier, ns string, scope nsScope) ([]WorkloadRow, error) {
+    podCond, depCond, helmCond, nsArgs := "", "", "", []any(nil)
+    if ns != "" {
+        podCond, depCond, helmCond, nsArgs = "p.namespace=?", "namespace=?", "x.namespace=?", []any{ns}
+    }
+    where, args := scope.where("p.namespace", podCond, nsArgs...)
+    rows, err := q.Query(`
+SELECT p.namespace,p.name,coalesce(p.team,''),coalesce(p.phase,''),coalesce(p.node_name,''),coalesce(p.images,''),coalesce(p.ready,''),
+ coalesce((SELECT zone FROM nodes n WHERE n.name=p.node_name),''),
+ coalesce((SELECT os||'/'||architecture FROM nodes n WHERE n.name=p.node_name AND coalesce(architecture,'')<>''),''),coalesce(p.arch_pin,''),
+ coalesce(CASE WHEN p.owner_kind='ReplicaSet' AND rs.owner_kind<>'' THEN rs.owner_kind ELSE p.owner_kind END,''),
+ coalesce(CASE WHEN p.owner_kind='ReplicaSet' AND rs.owner_kind<>'' THEN rs.owner_name ELSE p.owner_name END,'')
+FROM pods p LEFT JOIN replicasets rs ON p.owner_kind='ReplicaSet' AND rs.uid=p.owner_uid`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    groups := map[[3]string]*workloadGroup{}
+    group := func(ns, kind, name, team string) *workloadGroup {
+        key := [3]string{ns, kind, name}
+        g := groups[key]
+        if g == nil {
+            g = &workloadGroup{row: WorkloadRow{Namespace: ns, Kind: kind, Name: name, Team: team}, images: map[string]bool{}, nodes: map[string]bool{}, zones: map[string]bool{},
+                platforms: map[string]bool{}, pins: map[string]bool{}}
+            groups[key] = g
+        }
+        return g
+    }
+    for rows.Next() {
+        var ns, name, team, phase, node, images, ready, zone, platform, pin, kind, owner string
+        if err := rows.Scan(&ns, &name, &team, &phase, &node, &images, &ready, &zone, &platform, &pin, &kind, &owner); err != nil {
+            return nil, err
+        }
+        if kind == "" {
+            kind, owner = "Pod", name
+        }
+        g := group(ns, kind, owner, team)
+        g.row.Pods++
+        if ready == "true" {
+            g.row.Ready++
+        }
+        if phase == "Running" {
+            g.row.Running++
+        }
+        for _, img := range strings.Split(images, ",") {
+            if img != "" {
+                g.images[img] = true
+            }
+        }
+        if node != "" {
+            g.nodes[node] = true
+        }
+        if zone != "" {
+            g.zones[zone] = true
+        }
+        if platform != "" {
+            g.platforms[platform] = true
+        }
+        if pin != "" {
+            g.pins[pin] = true
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    for _, g := range groups {
+        g.row.Desired = g.row.Pods
+    }
+    where, args = scope.where("namespace", depCond, nsArgs...)
+    drows, err := q.Query(`SELECT namespace,name,coalesce(team,''),coalesce(replicas,0),coalesce(images,'') FROM deployments`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer drows.Close()
+    for drows.Next() {
+        var ns, name, team, images string
+        var replicas int64
+        if err := drows.Scan(&ns, &name, &team, &replicas, &images); err != nil {
+            return nil, err
+        }
+        g := group(ns, "Deployment", name, team)
+        g.row.Desired = replicas
+        // 没有 Pod 时用模板里的镜像
+        if len(g.images) == 0 {
+            for _, img := range strings.Split(images, ",") {
+                if img != "" {
+                    g.images[img] = true
+                }
+            }
+        }
+    }
+    if err := drows.Err(); err != nil {
+        return nil, err
+    }
+    where, args = scope.where("x.namespace", helmCond, nsArgs...)
+    hrows, err := q.Query(`SELECT x.namespace,x.kind,x.name,r.name,coalesce(r.chart,'')||'-'||coalesce(r.chart_version,'')
+FROM helm_release_resources x JOIN helm_releases r ON r.ref=x.release`+where, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer hrows.Close()
+    for hrows.Next() {
+        var ns, kind, name, release, chart string
+        if err := hrows.Scan(&ns, &kind, &name, &release, &chart); err != nil {
+            return nil, err
+        }
+        if g := groups[[3]string{ns, kind, name}]; g != nil {
+            g.row.HelmRelease, g.row.Chart = release, chart
+        }
+    }
+    if err := hrows.Err(); err != nil {
+        return nil, err
+    }
+    out := make([]WorkloadRow, 0, len(groups))
+    for _, g := range groups {
+        g.row.Images, g.row.Nodes, g.row.Zones = joinSet(g.images), joinSet(g.nodes), joinSet(g.zones)
+        g.row.Platforms, g.row.ArchPin = joinSet(g.platforms), joinSetWith(g.pins, "|")
+        out = append(out, g.row)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        a, b := out[i], out[j]
+        if a.Namespace != b.Namespace {
+            return a.Namespace < b.Namespace
+        }
+        if a.Kind != b.Kind {
+            return a.Kind < b.Kind
+        }
+        return a.Name < b.Name
+    })
+    return out, nil
+}
+
+// GET /cmdb/workloads?ns=&kind=Deployment&team=[&format=csv]
+func workloadsAPI(db *sql.DB) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            http.Error(w, "method not allowed", 405)
+            return
+        }
+        q := r.URL.Query()
+        var list []WorkloadRow
+        err := withReadSnapshot(r.Context(), db, func(ctx context.Context) error {
+            var err error
+            list, err = computeWorkloads(dbFrom(ctx, db), q.Get("ns"), scopeOf(r.Context()))
+            return err
+        })
+        if err != nil {
+            http.Error(w, err.Error(), 500)
+            return
+        }
+        lw, err := newListWriter(w, r, "workloads", WorkloadRow{})
+        if err != nil {
+            http.Error(w, err.Error(), 400)
+            return
+        }
+        kind := q.Get("kind")
+        team, byTeam := q["team"]
+        for _, row := range list {
+            if kind != "" && !strings.EqualFold(row.Kind, kind) || byTeam && row.Team != team[0] {
+                continue
+            }
+            if err := lw.Write(row); err != nil {
+                log.Printf("[http] write workloads: %v", err)
+                return
+            }
+        }
+        lw.Close()
+    }
+}
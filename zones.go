@@ -0,0 +1,33 @@
+package main
+
+import (
+    "database/sql"
+
+    corev1 "k8s.io/api/core/v1"
+)
+
+// ---------- Zones and regions ----------
+
+// 节点的 topology.kubernetes.io/zone 和 region（没有时用旧的 failure-domain.beta 标签）单独存成列，
+// /cmdb/stats 按 zone 汇总节点和 Pod，并列出所有 Pod 都在同一个 zone 里的 workload，见 stats.go。
+func initNodeZones(db *sql.DB) error {
+    for _, c := range []string{"zone", "region"} {
+        if err := addColumnIfMissing(db, "nodes", c, "TEXT"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func nodeZoneOf(n *corev1.Node) (zone, region string) {
+    label := func(keys ...string) string {
+        for _, k := range keys {
+            if v := n.Labels[k]; v != "" {
+                return v
+            }
+        }
+        return ""
+    }
+    return label(corev1.LabelTopologyZone, corev1.LabelFailureDomainBetaZone),
+        label(corev1.LabelTopologyRegion, corev1.LabelFailureDomainBetaRegion)
+}